@@ -0,0 +1,479 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"flyt-project-template/utils"
+)
+
+// privateRuntimeDir returns a directory only this user can enter for
+// sockets and tokens: $XDG_RUNTIME_DIR, or else a per-user directory in the
+// temporary directory, which must not have been created by anyone else.
+func privateRuntimeDir() (string, error) {
+	if dir := os.Getenv("XDG_RUNTIME_DIR"); dir != "" {
+		return dir, nil
+	}
+	dir := filepath.Join(os.TempDir(), fmt.Sprintf("ai_wraper-%d", os.Getuid()))
+	if err := os.Mkdir(dir, 0700); err != nil && !errors.Is(err, os.ErrExist) {
+		return "", err
+	}
+	info, err := os.Lstat(dir)
+	if err != nil {
+		return "", err
+	}
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !info.IsDir() || info.Mode().Perm()&0077 != 0 || !ok || int(stat.Uid) != os.Getuid() {
+		return "", fmt.Errorf("%s is not a private directory of this user; remove it or set XDG_RUNTIME_DIR", dir)
+	}
+	return dir, nil
+}
+
+// adminSocketPath returns the Unix socket the admin API of the server
+// listening on addr uses, so servers on one host each have their own.
+func adminSocketPath(addr string) (string, error) {
+	if path := os.Getenv("AI_WRAPER_ADMIN_SOCKET"); path != "" {
+		return path, nil
+	}
+	return runtimeFileFor("admin", addr, ".sock")
+}
+
+// runtimeFileFor names a file of the server listening on addr in
+// privateRuntimeDir, e.g. ai_wraper-admin-127.0.0.1_8765.sock.
+func runtimeFileFor(kind, addr, ext string) (string, error) {
+	dir, err := privateRuntimeDir()
+	if err != nil {
+		return "", err
+	}
+	name := strings.Map(func(r rune) rune {
+		if r == '.' || r == '-' || r >= '0' && r <= '9' || r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' {
+			return r
+		}
+		return '_'
+	}, addr)
+	return filepath.Join(dir, "ai_wraper-"+kind+"-"+name+ext), nil
+}
+
+// writeToken writes a new random token to path, readable by this user
+// alone, and returns it. The file must be new, so no one else can have
+// opened it first.
+func writeToken(path string) (string, error) {
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return "", err
+	}
+	token := hex.EncodeToString(secret)
+	os.Remove(path)
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err == nil {
+		_, err = f.WriteString(token + "\n")
+		if closeErr := f.Close(); err == nil {
+			err = closeErr
+		}
+	}
+	if err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
+// adminTokenPath is where the server writes the token admin clients send.
+func adminTokenPath(socket string) string {
+	return socket + ".token"
+}
+
+// listenAdmin serves the admin API on a Unix socket only the server's user
+// can open, and requires the token it writes next to the socket, readable
+// by that user alone. The returned function stops it and removes both.
+func (s *server) listenAdmin(socket string) (func(), error) {
+	// A leftover socket from a crashed server is removed; a live one is an error.
+	if conn, err := net.Dial("unix", socket); err == nil {
+		conn.Close()
+		return nil, fmt.Errorf("another server's admin API listens on %s", socket)
+	}
+	os.Remove(socket)
+
+	token, err := writeToken(adminTokenPath(socket))
+	if err != nil {
+		return nil, fmt.Errorf("failed to write the admin token: %w", err)
+	}
+	listener, err := net.Listen("unix", socket)
+	if err != nil {
+		os.Remove(adminTokenPath(socket))
+		return nil, fmt.Errorf("failed to listen on %s: %w", socket, err)
+	}
+	if err := os.Chmod(socket, 0600); err != nil {
+		listener.Close()
+		os.Remove(adminTokenPath(socket))
+		return nil, fmt.Errorf("failed to restrict %s: %w", socket, err)
+	}
+
+	srv := &http.Server{Handler: s.adminRoutes(token)}
+	go srv.Serve(listener)
+	log.Printf("🛠️  Admin API on %s", socket)
+	return func() {
+		srv.Close()
+		os.Remove(socket)
+		os.Remove(adminTokenPath(socket))
+	}, nil
+}
+
+func (s *server) adminRoutes(token string) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /admin/users", s.handleAdminUsers)
+	mux.HandleFunc("POST /admin/users", s.handleAdminAddUser)
+	mux.HandleFunc("PATCH /admin/users/{name}", s.handleAdminSetLimits)
+	mux.HandleFunc("GET /admin/sessions", s.handleAdminSessions)
+	mux.HandleFunc("DELETE /admin/sessions", s.handleAdminKillSession)
+	mux.HandleFunc("POST /admin/cache/purge", s.handleAdminPurgeCache)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		sent, _ := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if subtle.ConstantTimeCompare([]byte(sent), []byte(token)) != 1 {
+			writeError(w, http.StatusUnauthorized, "wrong admin token")
+			return
+		}
+		mux.ServeHTTP(w, r)
+	})
+}
+
+// adminUser is a user as the admin API lists them, with their spending.
+type adminUser struct {
+	Name       string  `json:"name"`
+	RPM        int     `json:"rpm"`
+	DailyUSD   float64 `json:"daily_usd"`
+	MonthlyUSD float64 `json:"monthly_usd"`
+	Admin      bool    `json:"admin"`
+	TodayUSD   float64 `json:"today_usd"`
+	MonthUSD   float64 `json:"month_usd"`
+}
+
+// needUsers answers 404 when the server has no -users table.
+func (s *server) needUsers(w http.ResponseWriter) bool {
+	if s.users == nil {
+		writeError(w, http.StatusNotFound, "the server has no user table; start it with -users")
+		return false
+	}
+	return true
+}
+
+func (s *server) handleAdminUsers(w http.ResponseWriter, r *http.Request) {
+	if !s.needUsers(w) {
+		return
+	}
+	now := time.Now()
+	var users []adminUser
+	for _, u := range s.users.users() {
+		usage, err := s.users.usage(r.Context(), u.Name, now)
+		if err != nil {
+			writeError(w, http.StatusServiceUnavailable, "%v", err)
+			return
+		}
+		users = append(users, adminUser{u.Name, u.RPM, u.DailyUSD, u.MonthlyUSD, u.Admin, usage.Today.CostUSD, usage.Month.CostUSD})
+	}
+	slices.SortFunc(users, func(a, b adminUser) int { return strings.Compare(a.Name, b.Name) })
+	writeJSON(w, http.StatusOK, map[string]any{"users": users})
+}
+
+func (s *server) handleAdminAddUser(w http.ResponseWriter, r *http.Request) {
+	if !s.needUsers(w) {
+		return
+	}
+	var u serverUser
+	if err := json.NewDecoder(r.Body).Decode(&u); err != nil {
+		writeError(w, http.StatusBadRequest, "body must be JSON with name and optional rpm, daily_usd, monthly_usd and admin")
+		return
+	}
+	key, err := s.users.addUser(u)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "%v", err)
+		return
+	}
+	log.Printf("🛠️  Added user %s", u.Name)
+	writeJSON(w, http.StatusCreated, map[string]string{"name": u.Name, "key": key})
+}
+
+func (s *server) handleAdminSetLimits(w http.ResponseWriter, r *http.Request) {
+	if !s.needUsers(w) {
+		return
+	}
+	var limits userLimits
+	if err := json.NewDecoder(r.Body).Decode(&limits); err != nil {
+		writeError(w, http.StatusBadRequest, "body must be JSON with some of rpm, daily_usd, monthly_usd and admin")
+		return
+	}
+	u, err := s.users.setLimits(r.PathValue("name"), limits)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "%v", err)
+		return
+	}
+	log.Printf("🛠️  Set the limits of %s", u.Name)
+	writeJSON(w, http.StatusOK, adminUser{Name: u.Name, RPM: u.RPM, DailyUSD: u.DailyUSD, MonthlyUSD: u.MonthlyUSD, Admin: u.Admin})
+}
+
+func (s *server) handleAdminSessions(w http.ResponseWriter, r *http.Request) {
+	sessions, err := s.daemon.listSessions(r.Context())
+	if err != nil {
+		writeError(w, http.StatusServiceUnavailable, "%v", err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"sessions": sessions})
+}
+
+func (s *server) handleAdminKillSession(w http.ResponseWriter, r *http.Request) {
+	name := r.URL.Query().Get("name")
+	found, err := s.daemon.deleteSession(r.Context(), name)
+	switch {
+	case err != nil:
+		writeError(w, http.StatusServiceUnavailable, "%v", err)
+	case !found:
+		writeError(w, http.StatusNotFound, "no session %q", name)
+	default:
+		log.Printf("🛠️  Killed session %q", name)
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// handleAdminPurgeCache drops every uploaded document, which clients upload
+// again as after an eviction.
+func (s *server) handleAdminPurgeCache(w http.ResponseWriter, r *http.Request) {
+	purged := 0
+	if s.state != nil {
+		keys, err := s.state.Keys(r.Context(), "doc:")
+		if err != nil {
+			writeError(w, http.StatusServiceUnavailable, "%v", err)
+			return
+		}
+		for _, key := range keys {
+			if err := s.state.Delete(r.Context(), key); err != nil {
+				writeError(w, http.StatusServiceUnavailable, "%v", err)
+				return
+			}
+			purged++
+		}
+	}
+	s.mu.Lock()
+	for _, ws := range s.workspaces {
+		ws.mu.Lock()
+		purged += len(ws.docs)
+		ws.docs = map[string]*document{}
+		ws.mu.Unlock()
+	}
+	s.mu.Unlock()
+	log.Printf("🛠️  Purged %d document(s)", purged)
+	writeJSON(w, http.StatusOK, map[string]int{"documents": purged})
+}
+
+// adminClient calls a server's admin API over its socket.
+type adminClient struct {
+	http  *http.Client
+	token string
+}
+
+func newAdminClient(socket string) (*adminClient, error) {
+	token, err := os.ReadFile(adminTokenPath(socket))
+	if err != nil {
+		return nil, fmt.Errorf("no server admin API at %s (start one with `%s serve`, or pass -socket): %w", socket, filepath.Base(os.Args[0]), err)
+	}
+	transport := &http.Transport{DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+		var d net.Dialer
+		return d.DialContext(ctx, "unix", socket)
+	}}
+	return &adminClient{http: &http.Client{Transport: transport, Timeout: time.Minute}, token: strings.TrimSpace(string(token))}, nil
+}
+
+// call sends body as JSON, when not nil, and decodes the reply into out.
+func (c *adminClient) call(method, path string, body, out any) error {
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(data)
+	}
+	req, err := http.NewRequest(method, "http://admin"+path, reader)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+c.token)
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		var failure struct {
+			Error string `json:"error"`
+		}
+		json.NewDecoder(resp.Body).Decode(&failure)
+		return fmt.Errorf("server: %s", failure.Error)
+	}
+	if out == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// formatUSD prints an amount, or "-" for a limit of 0.
+func formatUSD(amount float64, limit bool) string {
+	if limit && amount == 0 {
+		return "-"
+	}
+	return fmt.Sprintf("$%.2f", amount)
+}
+
+// runAdmin manages a running server through its admin socket.
+func runAdmin(args []string) error {
+	usage := fmt.Errorf("usage: %s", subcommands["admin"].usage)
+	fs := flag.NewFlagSet("admin", flag.ExitOnError)
+	addr := fs.String("addr", "127.0.0.1:8765", "Address of the server (serve -addr), which picks its admin socket")
+	socket := fs.String("socket", "", "Admin socket of the server, when it was started with -admin-socket")
+	rpm := fs.Int("rpm", -1, "Requests per minute (0 for no limit)")
+	daily := fs.Float64("daily-usd", -1, "Daily budget in USD (0 for no limit)")
+	monthly := fs.Float64("monthly-usd", -1, "Monthly budget in USD (0 for no limit)")
+	admin := fs.Bool("admin", false, "Let the user see everyone's usage")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	// Flags may also follow the command and the user's name.
+	var words []string
+	for rest := fs.Args(); len(rest) > 0; rest = fs.Args() {
+		words = append(words, rest[0])
+		if err := fs.Parse(rest[1:]); err != nil {
+			return err
+		}
+	}
+	if len(words) < 2 {
+		return usage
+	}
+	var limits userLimits
+	fs.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "rpm":
+			limits.RPM = rpm
+		case "daily-usd":
+			limits.DailyUSD = daily
+		case "monthly-usd":
+			limits.MonthlyUSD = monthly
+		case "admin":
+			limits.Admin = admin
+		}
+	})
+
+	if *socket == "" {
+		path, err := adminSocketPath(*addr)
+		if err != nil {
+			return err
+		}
+		*socket = path
+	}
+	client, err := newAdminClient(*socket)
+	if err != nil {
+		return err
+	}
+	switch command := words[0] + " " + words[1]; {
+	case command == "users list" && len(words) == 2:
+		var reply struct {
+			Users []adminUser `json:"users"`
+		}
+		if err := client.call("GET", "/admin/users", nil, &reply); err != nil {
+			return err
+		}
+		table := utils.MarkdownTable{Header: []string{"Name", "Admin", "RPM", "Daily", "Monthly", "Spent today", "Spent this month"}, Align: []int{0, 0, utils.AlignRight, utils.AlignRight, utils.AlignRight, utils.AlignRight, utils.AlignRight}}
+		for _, u := range reply.Users {
+			rpm := "-"
+			if u.RPM > 0 {
+				rpm = strconv.Itoa(u.RPM)
+			}
+			role := ""
+			if u.Admin {
+				role = "yes"
+			}
+			table.Rows = append(table.Rows, []string{u.Name, role, rpm, formatUSD(u.DailyUSD, true), formatUSD(u.MonthlyUSD, true), formatUSD(u.TodayUSD, false), formatUSD(u.MonthUSD, false)})
+		}
+		fmt.Print(table.Render(utils.TerminalWidth()))
+	case command == "users add" && len(words) == 3:
+		u := serverUser{Name: words[2], Admin: *admin}
+		if limits.RPM != nil {
+			u.RPM = *limits.RPM
+		}
+		if limits.DailyUSD != nil {
+			u.DailyUSD = *limits.DailyUSD
+		}
+		if limits.MonthlyUSD != nil {
+			u.MonthlyUSD = *limits.MonthlyUSD
+		}
+		var reply struct {
+			Key string `json:"key"`
+		}
+		if err := client.call("POST", "/admin/users", u, &reply); err != nil {
+			return err
+		}
+		utils.PrintStatus("✅ Added %s. Their API key is shown only this once:", u.Name)
+		fmt.Println(reply.Key)
+	case command == "limits set" && len(words) == 3:
+		if limits == (userLimits{}) {
+			return fmt.Errorf("limits set needs -rpm, -daily-usd, -monthly-usd or -admin")
+		}
+		var u adminUser
+		if err := client.call("PATCH", "/admin/users/"+url.PathEscape(words[2]), limits, &u); err != nil {
+			return err
+		}
+		fmt.Printf("%s: rpm %s, daily %s, monthly %s, admin %t\n", u.Name, map[bool]string{true: strconv.Itoa(u.RPM), false: "-"}[u.RPM > 0], formatUSD(u.DailyUSD, true), formatUSD(u.MonthlyUSD, true), u.Admin)
+	case command == "sessions list" && len(words) == 2:
+		var reply struct {
+			Sessions []sessionInfo `json:"sessions"`
+		}
+		if err := client.call("GET", "/admin/sessions", nil, &reply); err != nil {
+			return err
+		}
+		if len(reply.Sessions) == 0 {
+			fmt.Println("No sessions.")
+			return nil
+		}
+		table := utils.MarkdownTable{Header: []string{"Session", "Turns", "Size", "Last used"}, Align: []int{0, utils.AlignRight, utils.AlignRight, 0}}
+		for _, session := range reply.Sessions {
+			used := "-"
+			if !session.LastUsed.IsZero() {
+				used = session.LastUsed.Local().Format("2006-01-02 15:04")
+			}
+			table.Rows = append(table.Rows, []string{session.Name, strconv.Itoa(session.Turns), utils.FormatBytes(session.Bytes), used})
+		}
+		fmt.Print(table.Render(utils.TerminalWidth()))
+	case command == "sessions kill" && len(words) == 3:
+		if err := client.call("DELETE", "/admin/sessions?name="+url.QueryEscape(words[2]), nil, nil); err != nil {
+			return err
+		}
+		fmt.Printf("Killed session %s.\n", words[2])
+	case command == "cache purge" && len(words) == 2:
+		var reply struct {
+			Documents int `json:"documents"`
+		}
+		if err := client.call("POST", "/admin/cache/purge", nil, &reply); err != nil {
+			return err
+		}
+		fmt.Printf("Purged %d uploaded document(s).\n", reply.Documents)
+	default:
+		return usage
+	}
+	return nil
+}
@@ -0,0 +1,505 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"flyt-project-template/utils"
+
+	"github.com/mark3labs/flyt"
+)
+
+// chatSession is the state of the interactive chat that commands act on.
+type chatSession struct {
+	ctx    context.Context
+	reader *bufio.Reader
+	shared *flyt.SharedStore
+	// kbScope holds the namespaces set by a bare /from until changed;
+	// questionScope those for the question being asked.
+	kbScope, questionScope []string
+}
+
+// chatCommand is typed in the chat as /name [argument]. run returns a
+// question to send to the model, or "" when the command was handled locally.
+type chatCommand struct {
+	usage string
+	help  string
+	run   func(s *chatSession, arg string) string
+}
+
+var chatCommands map[string]chatCommand
+
+func init() {
+	chatCommands = map[string]chatCommand{
+		"/help":        {usage: "/help", help: "List these commands", run: showChatHelp},
+		"/save":        {usage: "/save [name]", help: "Save the conversation now, optionally renaming it", run: saveCommand},
+		"/quit":        {usage: "/quit [summary]", help: "Save the conversation and exit; summary first adds a recap of the session and its TODOs", run: quitCommand},
+		"/clear":       {usage: "/clear", help: "Save the conversation and start a new one", run: clearConversation},
+		"/model":       {usage: "/model [name|default]", help: "Show the model, or switch to another one for the following turns", run: switchModel},
+		"/system":      {usage: "/system [prompt|file|default]", help: "Show the system prompt, or replace it for the following turns", run: systemCommand},
+		"/tools":       {usage: "/tools [all|none|name,...]", help: "Show the tools answers may use, or change the allowlist for the following turns", run: toolsCommand},
+		"/history":     {usage: "/history", help: "List the turns of this conversation", run: showTurns},
+		"/pin":         {usage: "/pin [N|list]", help: "Keep turn N (default: the last) when history is trimmed; list shows pinned and muted turns", run: markCommand("/pin")},
+		"/unpin":       {usage: "/unpin [N]", help: "Unpin turn N", run: markCommand("/unpin")},
+		"/mute":        {usage: "/mute [N]", help: "Stop sending turn N to the model", run: markCommand("/mute")},
+		"/unmute":      {usage: "/unmute [N]", help: "Send turn N to the model again", run: markCommand("/unmute")},
+		"/context":     {usage: "/context [question]", help: "Show what the next prompt would contain and cost", run: showContext},
+		"/ticket":      {usage: "/ticket [jira|linear] [description]", help: "Draft a ticket from the description or the conversation", run: ticketCommand},
+		"/why":         {usage: "/why [N]", help: "Show what was put in the prompt of the last answer", run: whyCommand},
+		"/edit":        {usage: "/edit [text]", help: "Write the question in $EDITOR, starting from the text", run: editCommand},
+		"/continue":    {usage: "/continue", help: "Finish an answer that was cut off", run: continueCommand},
+		"/from":        {usage: "/from ns[,ns...] [question] | /from all", help: "Search only these knowledge-base namespaces", run: fromCommand},
+		"/table":       {usage: "/table [N csv|json [file]]", help: "List the tables in the last answer, or export one", run: tableCommand},
+		"/copy-answer": {usage: "/copy-answer", help: "Copy the last answer to the clipboard", run: copyCommand(false)},
+		"/copy-code":   {usage: "/copy-code", help: "Copy the first code block of the last answer", run: copyCommand(true)},
+		"/image":       {usage: "/image [path...] | /image clear", help: "Attach images to the following questions, list them, or remove them", run: attachImages},
+		"/usage":       {usage: "/usage", help: "Show the tokens used and their estimated cost this session, and the router's savings", run: showUsage},
+		"/memstats":    {usage: "/memstats", help: "Show the memory held by the history and knowledge base, their limits and evictions", run: showMemStats},
+		"/flashcards":  {usage: "/flashcards [document]", help: "Export Q/A flashcards from the conversation or a document for Anki", run: flashcardsCommand},
+	}
+}
+
+// runChatCommand handles a line starting with "/". It returns the question
+// to send to the model, if any. A line starting with "//" is sent as a
+// question with the first slash removed.
+func runChatCommand(s *chatSession, line string) string {
+	if strings.HasPrefix(line, "//") {
+		return line[1:]
+	}
+	name, arg := line, ""
+	if i := strings.IndexAny(line, " \t\n"); i >= 0 {
+		name, arg = line[:i], strings.TrimSpace(line[i:])
+	}
+	cmd, ok := chatCommands[name]
+	if !ok {
+		utils.PrintWarning("Unknown command %s. /help lists the commands; start the line with // to send it as a question.", name)
+		return ""
+	}
+	return cmd.run(s, arg)
+}
+
+func showChatHelp(s *chatSession, arg string) string {
+	names := make([]string, 0, len(chatCommands))
+	for name := range chatCommands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	table := utils.MarkdownTable{Header: []string{"Command", "Does"}, Align: make([]int, 2)}
+	for _, name := range names {
+		table.Rows = append(table.Rows, []string{chatCommands[name].usage, chatCommands[name].help})
+	}
+	fmt.Print(table.Render(utils.TerminalWidth()))
+	fmt.Println("/1, /2, /3 ask a suggested follow-up (with -suggest). quit or exit leaves the chat.")
+	return ""
+}
+
+// saveCommand handles "/save [name]".
+func saveCommand(s *chatSession, name string) string {
+	if name != "" {
+		if ConversationFile != "" {
+			key, err := conversationStore.Rename(ConversationFile, name)
+			if err != nil {
+				utils.PrintWarning("⚠️  Could not rename the conversation: %v", err)
+				return ""
+			}
+			ConversationFile = key
+		}
+		ConversationName = name
+		s.shared.Set("conversation_name", name)
+	}
+	key, err := saveConversation(s.shared)
+	if err != nil {
+		utils.PrintWarning("⚠️  Could not save the conversation: %v", err)
+		return ""
+	}
+	fmt.Printf("✅ Conversation saved to %s\n", key)
+	return ""
+}
+
+// clearConversation handles "/clear": the current conversation stays saved
+// and the chat starts over with an empty history.
+func clearConversation(s *chatSession, arg string) string {
+	if len(utils.GetHistory(s.shared).Conversations) > 0 {
+		key, err := saveConversation(s.shared)
+		if err != nil {
+			utils.PrintWarning("⚠️  Could not save the current conversation, keeping it: %v", err)
+			return ""
+		}
+		fmt.Printf("✅ Previous conversation saved to %s\n", key)
+	}
+	saveHistory(s.shared, utils.History{})
+	ConversationName, ConversationFile = "", ""
+	s.shared.Set("conversation_name", "")
+	s.shared.Set("provenance", nil)
+	s.shared.Set("follow_ups", []string(nil))
+	fmt.Println("🧹 Started a new conversation.")
+	return ""
+}
+
+// quitCommand handles "/quit".
+func quitCommand(s *chatSession, arg string) string {
+	if arg == "summary" || quitSummary {
+		recapSession(s.shared)
+	}
+	fmt.Println("🤖 Goodbye!")
+	saveAndExit(s.shared)
+	return ""
+}
+
+// recapSession asks the model for a recap of the conversation, keeps it
+// under "session_recap" to be saved with it, and prints it.
+func recapSession(shared *flyt.SharedStore) {
+	history := utils.GetHistory(shared)
+	if len(history.Conversations) == 0 {
+		return
+	}
+	transcript := utils.FormatHistory(history.ForPrompt())
+	if history.Summary != "" {
+		transcript = "Summary of the earlier turns: " + history.Summary + "\n" + transcript
+	}
+	date := time.Now().Format("2006-01-02")
+	prompt, err := utils.SessionRecapTemplate.Render(transcript, date)
+	if err != nil {
+		utils.PrintWarning("Could not summarize the session: %v", err)
+		return
+	}
+	utils.PrintStatus("📝 Summarizing the session...")
+	recap := &sessionRecap{Date: date}
+	_, _, err = runValidatedGeneration(prompt, func(candidate string) error {
+		if _, err := utils.SessionRecapTemplate.Validate(candidate); err != nil {
+			return err
+		}
+		return json.Unmarshal([]byte(utils.ExtractJSON(candidate)), recap)
+	}, 3)
+	if err != nil {
+		utils.PrintWarning("Could not summarize the session: %v", err)
+		return
+	}
+	shared.Set("session_recap", recap)
+	printRecap(recap)
+}
+
+// printRecap shows a session recap.
+func printRecap(recap *sessionRecap) {
+	fmt.Printf("%s %s\n", utils.Paint(utils.StyleAI, "📝 Session recap ("+recap.Date+"):"), recap.Summary)
+	for _, decision := range recap.Decisions {
+		fmt.Printf("  ✔ %s\n", decision)
+	}
+	for _, todo := range recap.TODOs {
+		fmt.Printf("  ☐ %s\n", todo)
+	}
+}
+
+// switchModel handles "/model [name]". The model is kept under the "model"
+// key, which the answer node reads each turn; "default" goes back to -model.
+func switchModel(s *chatSession, name string) string {
+	switch name {
+	case "":
+		fmt.Printf("Model: %s\n", turnModel(s.shared))
+		return ""
+	case "default":
+		name = ""
+	}
+	s.shared.Set("model", name)
+	fmt.Printf("🔁 Using %s from the next turn.\n", turnModel(s.shared))
+	return ""
+}
+
+// defaultSystemPrompt is the conversation's system prompt until -system or /system changes it.
+const defaultSystemPrompt = " you are a helpful assistant. "
+
+// readSystemPrompt returns the contents of the file named by arg, or arg itself
+// when it is not a file.
+func readSystemPrompt(arg string) string {
+	if data, err := os.ReadFile(arg); err == nil {
+		return strings.TrimSpace(string(data))
+	}
+	return arg
+}
+
+// systemCommand handles "/system". The prompt is the shared "context",
+// sent as the system instruction and saved with the conversation.
+func systemCommand(s *chatSession, arg string) string {
+	switch arg {
+	case "":
+		context, _ := s.shared.Get("context")
+		fmt.Printf("System prompt: %s\n", strings.TrimSpace(fmt.Sprint(context)))
+		return ""
+	case "default":
+		arg = defaultSystemPrompt
+	}
+	s.shared.Set("context", readSystemPrompt(arg))
+	fmt.Println("🧭 System prompt updated for the following turns.")
+	return ""
+}
+
+// parseTools parses a tool allowlist: all (nil), none, or a comma-separated
+// list of toolChoices.
+func parseTools(arg string) ([]string, error) {
+	switch arg {
+	case "all":
+		return nil, nil
+	case "none":
+		return []string{}, nil
+	}
+	var tools []string
+	for _, name := range strings.Split(arg, ",") {
+		name = strings.TrimSpace(name)
+		if !slices.Contains(toolChoices(), name) {
+			return nil, fmt.Errorf("unknown tool %q (use all, none, or some of %s)", name, strings.Join(toolChoices(), ", "))
+		}
+		tools = append(tools, name)
+	}
+	return tools, nil
+}
+
+// toolsCommand handles "/tools". The allowlist is the shared "tools", saved
+// with the conversation.
+func toolsCommand(s *chatSession, arg string) string {
+	if arg != "" {
+		tools, err := parseTools(arg)
+		if err != nil {
+			utils.PrintWarning("%v", err)
+			return ""
+		}
+		s.shared.Set("tools", tools)
+	}
+	allowed := allowedTools(s.shared)
+	var on, off []string
+	for _, tool := range toolChoices() {
+		if allowed(tool) {
+			on = append(on, tool)
+		} else {
+			off = append(off, tool)
+		}
+	}
+	if len(on) == 0 {
+		on = []string{"none"}
+	}
+	fmt.Printf("Tools: %s\n", strings.Join(on, ", "))
+	if len(off) > 0 {
+		fmt.Printf("Off: %s\n", strings.Join(off, ", "))
+	}
+	return ""
+}
+
+// showTurns handles "/history".
+func showTurns(s *chatSession, arg string) string {
+	h := utils.GetHistory(s.shared)
+	if len(h.Conversations) == 0 {
+		fmt.Println("No turns yet.")
+		return ""
+	}
+	for i, c := range h.Conversations {
+		var marks string
+		if c.Pinned {
+			marks += "📌"
+		}
+		if c.Muted {
+			marks += "🔇"
+		}
+		if c.Truncated {
+			marks += "✂️"
+		}
+		if c.Summarized {
+			marks += "🗜️"
+		}
+		fmt.Printf("%3d. %s %s\n", i+1, TruncateString(strings.Join(strings.Fields(c.User), " "), 70), marks)
+	}
+	return ""
+}
+
+func markCommand(name string) func(*chatSession, string) string {
+	return func(s *chatSession, arg string) string {
+		markTurn(s.shared, name, arg)
+		return ""
+	}
+}
+
+func showContext(s *chatSession, arg string) string {
+	fmt.Print(utils.FormatPromptBreakdown(promptComponents(s.shared, arg), turnModel(s.shared)))
+	return ""
+}
+
+func ticketCommand(s *chatSession, arg string) string {
+	draftTicket(s.ctx, s.reader, s.shared, arg)
+	return ""
+}
+
+func whyCommand(s *chatSession, arg string) string {
+	showProvenance(s.shared, arg)
+	return ""
+}
+
+func continueCommand(s *chatSession, arg string) string {
+	continueAnswer(s.ctx, s.shared)
+	return ""
+}
+
+// editCommand handles "/edit": the question is what is saved in the editor.
+func editCommand(s *chatSession, arg string) string {
+	question, err := utils.EditText(arg)
+	if err != nil {
+		utils.PrintWarning("%v", err)
+		return ""
+	}
+	if question == "" {
+		fmt.Println("Nothing to send.")
+		return ""
+	}
+	fmt.Println(question)
+	return question
+}
+
+// fromCommand handles "/from": with a question it scopes only that question,
+// without one it scopes the following questions.
+func fromCommand(s *chatSession, arg string) string {
+	scope, question, err := parseFromCommand(s.shared, arg)
+	if err != nil {
+		utils.PrintWarning("%v", err)
+		return ""
+	}
+	if question == "" {
+		s.kbScope = scope
+		if scope == nil {
+			fmt.Println("📚 Searching every namespace.")
+		} else {
+			fmt.Printf("📚 Searching only %s until the next /from.\n", strings.Join(scope, ", "))
+		}
+		return ""
+	}
+	s.questionScope = scope
+	return question
+}
+
+func tableCommand(s *chatSession, arg string) string {
+	exportTable(s.shared, strings.Fields(arg))
+	return ""
+}
+
+func copyCommand(codeOnly bool) func(*chatSession, string) string {
+	return func(s *chatSession, arg string) string {
+		answer, ok := s.shared.Get("answer")
+		if !ok {
+			fmt.Println("Nothing to copy yet.")
+			return ""
+		}
+		copyToClipboard(answer.(string), codeOnly)
+		return ""
+	}
+}
+
+// attachImages handles "/image": paths are added to the images sent with the
+// following questions, "clear" removes them all and no argument lists them.
+func attachImages(s *chatSession, arg string) string {
+	var current []string
+	if v, ok := s.shared.Get("image_paths"); ok && v != nil {
+		current, _ = v.([]string)
+	}
+	switch arg {
+	case "":
+		if len(current) == 0 {
+			fmt.Println("No images attached.")
+		}
+		for _, path := range current {
+			fmt.Printf("   • %s\n", path)
+		}
+		return ""
+	case "clear":
+		s.shared.Set("image_paths", []string(nil))
+		fmt.Printf("🖼️ Removed %d image(s).\n", len(current))
+		return ""
+	}
+	paths := append(append([]string(nil), current...), strings.Fields(arg)...)
+	attachments, err := utils.ValidateAttachments(paths)
+	if err != nil {
+		utils.PrintWarning("⚠️  Invalid attachment: %v", err)
+		return ""
+	}
+	s.shared.Set("image_paths", paths)
+	fmt.Printf("🖼️ Attached %d image(s) for the following questions:\n", len(attachments))
+	for _, a := range attachments {
+		fmt.Printf("   • %s\n", a)
+	}
+	return ""
+}
+
+// flashcardsCommand handles "/flashcards [document]": it writes cards from the
+// document, or the conversation, to an Anki import file in the current directory.
+func flashcardsCommand(s *chatSession, arg string) string {
+	deck := ConversationName
+	if arg != "" {
+		deck = strings.TrimSuffix(filepath.Base(arg), filepath.Ext(arg))
+	}
+	if deck == "" {
+		deck = "ai_wraper"
+	}
+	s.shared.Set("flashcard_source", arg)
+	if err := CreateFlashcardsFlow().Run(s.ctx, s.shared); err != nil {
+		utils.PrintWarning("Could not write flashcards: %v", err)
+		return ""
+	}
+	value, _ := s.shared.Get("flashcards")
+	cards := value.([]utils.Flashcard)
+	path := deck + "-flashcards.txt"
+	if err := utils.CheckWritable("writing " + path); err != nil {
+		utils.PrintWarning("Could not save flashcards: %v", err)
+		return ""
+	}
+	if err := os.WriteFile(path, []byte(utils.AnkiText(cards, deck)), 0644); err != nil {
+		utils.PrintWarning("Could not save flashcards: %v", err)
+		return ""
+	}
+	for i, c := range cards[:min(3, len(cards))] {
+		fmt.Printf("  %d. %s\n     → %s\n", i+1, TruncateString(c.Front, 70), TruncateString(c.Back, 70))
+	}
+	fmt.Printf("🗂️ Wrote %d flashcard(s) to %s; import it in Anki with File > Import.\n", len(cards), path)
+	return ""
+}
+
+// showUsage lists the session's token usage per model, with estimated cost.
+// showMemStats handles "/memstats".
+func showMemStats(s *chatSession, arg string) string {
+	fmt.Print(utils.RenderMarkdownTables(utils.FormatMemoryStats(utils.MemoryStats()), utils.TerminalWidth()))
+	return ""
+}
+
+func showUsage(s *chatSession, arg string) string {
+	collectUsage(s.shared)
+	value, _ := s.shared.Get("usage")
+	usage := value.(utils.UsageByModel)
+	if usage.Total().Calls == 0 {
+		fmt.Println("No tokens used yet.")
+		return ""
+	}
+	models := make([]string, 0, len(usage))
+	for model := range usage {
+		models = append(models, model)
+	}
+	sort.Strings(models)
+	table := utils.MarkdownTable{Header: []string{"model", "calls", "input tokens", "output tokens", "cost"}, Align: []int{utils.AlignLeft, utils.AlignRight, utils.AlignRight, utils.AlignRight, utils.AlignRight}}
+	for _, model := range models {
+		one := utils.UsageByModel{model: usage[model]}
+		cost := "no pricing"
+		if c, known := one.Cost(); known {
+			cost = fmt.Sprintf("$%.4f", c)
+		}
+		t := usage[model]
+		table.Rows = append(table.Rows, []string{model, strconv.Itoa(t.Calls), strconv.Itoa(t.PromptTokens), strconv.Itoa(t.OutputTokens), cost})
+	}
+	fmt.Print(table.Render(utils.TerminalWidth()))
+	fmt.Printf("Total: %s\n", usage)
+	if router, _ := s.shared.Get("router"); router != nil {
+		fmt.Printf("Router: %s\n", router.(*utils.ModelRouter).Stats)
+	}
+	return ""
+}
@@ -0,0 +1,670 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+	"unicode"
+
+	"flyt-project-template/utils"
+
+	"github.com/mark3labs/flyt"
+)
+
+// daemonRequest is one line of JSON sent to the daemon by "ask" or an editor plugin.
+type daemonRequest struct {
+	// ID is echoed back so clients can match concurrent responses.
+	ID json.RawMessage `json:"id,omitempty"`
+	// Action is "ask" (default), "insert", "explain", "apply-diff" or "memstats".
+	Action   string `json:"action,omitempty"`
+	Question string `json:"question"`
+	// Mode selects the flow for "ask": "qa" (default) or "agent".
+	Mode string `json:"mode,omitempty"`
+	// Session keeps history between requests that use the same name.
+	Session string `json:"session,omitempty"`
+
+	// Editor context: the file being edited, its language, the selected text,
+	// and the text around the cursor.
+	File      string `json:"file,omitempty"`
+	Filetype  string `json:"filetype,omitempty"`
+	Selection string `json:"selection,omitempty"`
+	Before    string `json:"before,omitempty"`
+	After     string `json:"after,omitempty"`
+	Content   string `json:"content,omitempty"`
+
+	// events, when set, receives the answer as it is generated.
+	events utils.StreamHandler
+}
+
+// daemonResponse is the daemon's one-line JSON reply.
+type daemonResponse struct {
+	ID     json.RawMessage `json:"id,omitempty"`
+	Answer string          `json:"answer,omitempty"`
+	// Text is code to insert ("insert") or the patched file ("apply-diff").
+	Text  string `json:"text,omitempty"`
+	Diff  string `json:"diff,omitempty"`
+	Error string `json:"error,omitempty"`
+}
+
+// daemonSocketPath returns the Unix socket the daemon listens on.
+func daemonSocketPath() string {
+	if path := os.Getenv("AI_WRAPER_SOCKET"); path != "" {
+		return path
+	}
+	if dir := os.Getenv("XDG_RUNTIME_DIR"); dir != "" {
+		return filepath.Join(dir, "ai_wraper.sock")
+	}
+	return filepath.Join(os.TempDir(), fmt.Sprintf("ai_wraper-%d.sock", os.Getuid()))
+}
+
+// daemon answers requests from "ask" clients in one long-lived process, so
+// HTTP connections, system instructions and session history stay warm.
+type daemon struct {
+	mu       sync.Mutex
+	sessions map[string]utils.History
+	// lastUsed orders sessions for eviction once they hold more than maxBytes.
+	lastUsed  map[string]time.Time
+	maxBytes  int64
+	evictions int
+	// store, when set, keeps sessions across restarts: saveSessions writes
+	// them and session restores each on its first use; keys are their keys
+	// in store ("" when none was found).
+	store Storage
+	keys  map[string]string
+	// state, when set, holds the sessions instead, shared with other
+	// server replicas; they expire stateTTL after their last turn.
+	state    utils.StateStore
+	stateTTL time.Duration
+}
+
+// defaultMaxSessionMemory bounds the histories a daemon or server keeps.
+const defaultMaxSessionMemory = 64 << 20
+
+// newDaemon returns a daemon whose sessions hold at most maxBytes of
+// history (0 means no limit), and reports them in the memory stats.
+func newDaemon(maxBytes int64) *daemon {
+	d := &daemon{sessions: map[string]utils.History{}, lastUsed: map[string]time.Time{}, maxBytes: maxBytes, keys: map[string]string{}}
+	utils.RegisterMemoryPool("sessions", d.memoryStat)
+	return d
+}
+
+func (d *daemon) memoryStat() utils.MemoryStat {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	stat := utils.MemoryStat{Entries: len(d.sessions), Limit: d.maxBytes, Evictions: d.evictions}
+	for _, h := range d.sessions {
+		stat.Bytes += utils.HistoryBytes(h)
+	}
+	return stat
+}
+
+// session returns the history of the named session.
+func (d *daemon) session(ctx context.Context, name string) (utils.History, error) {
+	if d.state != nil && name != "" {
+		var h utils.History
+		data, ok, err := d.state.Get(ctx, "session:"+name)
+		if err != nil || !ok {
+			return h, err
+		}
+		if err := json.Unmarshal(data, &h); err != nil {
+			return h, fmt.Errorf("corrupt shared session %q: %w", name, err)
+		}
+		return h, nil
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if _, ok := d.sessions[name]; !ok {
+		d.restoreSession(name)
+	}
+	if _, ok := d.sessions[name]; ok {
+		d.lastUsed[name] = time.Now()
+	}
+	return d.sessions[name], nil
+}
+
+// sessionLockTTL bounds how long a replica holds a shared session's lock,
+// so that one which died mid-turn does not block the session for good.
+const sessionLockTTL = 10 * time.Minute
+
+// lockSession keeps other replicas from answering in the named shared
+// session until unlock is called, so that no turn is lost to a concurrent
+// read-modify-write. It waits for a turn in progress elsewhere, or until
+// ctx is done. The lock holds a token of its own, so that a turn that ran
+// past sessionLockTTL does not release a lock another replica took since.
+func (d *daemon) lockSession(ctx context.Context, name string) (unlock func(), err error) {
+	key := "lock:session:" + name
+	random := make([]byte, 16)
+	if _, err := rand.Read(random); err != nil {
+		return nil, err
+	}
+	token := []byte(hex.EncodeToString(random))
+	for {
+		ok, err := d.state.SetNX(ctx, key, token, sessionLockTTL)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			return func() {
+				released, err := d.state.DeleteIf(context.WithoutCancel(ctx), key, token)
+				switch {
+				case err != nil:
+					log.Printf("⚠️ Could not unlock session %q: %v", name, err)
+				case !released:
+					log.Printf("⚠️ The lock of session %q expired during the turn; another replica may have answered meanwhile", name)
+				}
+			}, nil
+		}
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(100 * time.Millisecond):
+		}
+	}
+}
+
+// restoreSession loads the named session from d.store the first time it is
+// used, and again after it was evicted and saved. d.mu is held.
+func (d *daemon) restoreSession(name string) {
+	if d.store == nil || name == "" {
+		return
+	}
+	if _, seen := d.keys[name]; seen {
+		return
+	}
+	d.keys[name] = ""
+	key, err := d.store.Find(storedSessionName(name))
+	if err != nil {
+		return // never saved
+	}
+	saved, err := d.store.Load(key)
+	if err != nil {
+		log.Printf("⚠️ Could not restore session %q: %v", name, err)
+		return
+	}
+	d.keys[name] = key
+	d.sessions[name] = saved.History
+}
+
+// saveSessions writes every session in memory to d.store and returns how
+// many were saved.
+func (d *daemon) saveSessions() (int, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	saved := 0
+	var errs []error
+	for name, h := range d.sessions {
+		key, err := d.store.Save(d.keys[name], savedConversation{Name: storedSessionName(name), History: h})
+		if err != nil {
+			errs = append(errs, fmt.Errorf("session %q: %w", name, err))
+			continue
+		}
+		d.keys[name] = key
+		saved++
+	}
+	return saved, errors.Join(errs...)
+}
+
+// sessionInfo describes a session for the admin API.
+type sessionInfo struct {
+	Name     string    `json:"name"`
+	Turns    int       `json:"turns"`
+	Bytes    int64     `json:"bytes"`
+	LastUsed time.Time `json:"last_used,omitzero"`
+}
+
+// listSessions describes the sessions in memory, or with state those in the
+// shared store, by name.
+func (d *daemon) listSessions(ctx context.Context) ([]sessionInfo, error) {
+	var sessions []sessionInfo
+	if d.state != nil {
+		keys, err := d.state.Keys(ctx, "session:")
+		if err != nil {
+			return nil, err
+		}
+		for _, key := range keys {
+			name := strings.TrimPrefix(key, "session:")
+			h, err := d.session(ctx, name)
+			if err != nil {
+				return nil, err
+			}
+			sessions = append(sessions, sessionInfo{Name: name, Turns: len(h.Conversations), Bytes: utils.HistoryBytes(h)})
+		}
+	} else {
+		d.mu.Lock()
+		for name, h := range d.sessions {
+			sessions = append(sessions, sessionInfo{Name: name, Turns: len(h.Conversations), Bytes: utils.HistoryBytes(h), LastUsed: d.lastUsed[name]})
+		}
+		d.mu.Unlock()
+	}
+	slices.SortFunc(sessions, func(a, b sessionInfo) int { return strings.Compare(a.Name, b.Name) })
+	return sessions, nil
+}
+
+// deleteSession forgets the named session, with its saved copy when
+// sessions persist, and reports whether there was one.
+func (d *daemon) deleteSession(ctx context.Context, name string) (bool, error) {
+	if d.state != nil {
+		_, ok, err := d.state.Get(ctx, "session:"+name)
+		if err != nil || !ok {
+			return false, err
+		}
+		return true, d.state.Delete(ctx, "session:"+name)
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.restoreSession(name)
+	_, found := d.sessions[name]
+	delete(d.sessions, name)
+	delete(d.lastUsed, name)
+	if d.store != nil {
+		if key := d.keys[name]; key != "" {
+			if err := d.store.Delete(key); err != nil {
+				return found, err
+			}
+			found = true
+		}
+		// Seen with nothing saved, so it is not restored again.
+		d.keys[name] = ""
+	}
+	return found, nil
+}
+
+// storedSessionName is the conversation name a session is saved under. The
+// hash keeps sessions whose names differ only in punctuation apart, and keeps
+// one name from being a prefix of another when the store looks it up.
+func storedSessionName(session string) string {
+	sum := sha256.Sum256([]byte(session))
+	clean := strings.Map(func(r rune) rune {
+		if r < 128 && (unicode.IsLetter(r) || unicode.IsDigit(r) || r == '-') {
+			return r
+		}
+		return '_'
+	}, session)
+	return fmt.Sprintf("serve_%s-%s", TruncateString(clean, 60), hex.EncodeToString(sum[:4]))
+}
+
+// storeSession saves h as the named session. Over the memory limit, the
+// least recently used other sessions are evicted first, then the oldest
+// turns of this one. Shared sessions expire after d.stateTTL instead.
+func (d *daemon) storeSession(ctx context.Context, name string, h utils.History) error {
+	if d.state != nil {
+		data, err := json.Marshal(h)
+		if err != nil {
+			return err
+		}
+		return d.state.Set(ctx, "session:"+name, data, d.stateTTL)
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.sessions[name], d.lastUsed[name] = h, time.Now()
+	if d.maxBytes <= 0 {
+		return nil
+	}
+	var total int64
+	for _, h := range d.sessions {
+		total += utils.HistoryBytes(h)
+	}
+	for total > d.maxBytes {
+		oldest := ""
+		for other := range d.sessions {
+			if other != name && (oldest == "" || d.lastUsed[other].Before(d.lastUsed[oldest])) {
+				oldest = other
+			}
+		}
+		if oldest == "" {
+			break
+		}
+		total -= utils.HistoryBytes(d.sessions[oldest])
+		if d.store != nil {
+			// Saved so that its next request restores it.
+			if _, err := d.store.Save(d.keys[oldest], savedConversation{Name: storedSessionName(oldest), History: d.sessions[oldest]}); err == nil {
+				delete(d.keys, oldest)
+			}
+		}
+		delete(d.sessions, oldest)
+		delete(d.lastUsed, oldest)
+		d.evictions++
+		log.Printf("🧹 Evicted session %q to stay under %s", oldest, utils.FormatBytes(d.maxBytes))
+	}
+	for total > d.maxBytes && len(h.Conversations) > 1 {
+		total -= utils.HistoryBytes(utils.History{Conversations: h.Conversations[:1]})
+		h.Conversations = h.Conversations[1:]
+		d.evictions++
+	}
+	d.sessions[name] = h
+	return nil
+}
+
+// runDaemon listens on the Unix socket until interrupted.
+func runDaemon(args []string) error {
+	fs, model := newSubcommandFlags("daemon")
+	socket := fs.String("socket", daemonSocketPath(), "Unix socket to listen on")
+	maxSessionMemory := utils.ByteSize(defaultMaxSessionMemory)
+	fs.Var(&maxSessionMemory, "max-session-memory", "Most memory the sessions' histories may hold (e.g. 64MiB, 0 for no limit); the least recently used are evicted first")
+	if err := parseWithSettings(fs, args); err != nil {
+		return err
+	}
+	utils.DefaultModel = *model
+
+	// A leftover socket from a crashed daemon is removed; a live one is an error.
+	if conn, err := net.Dial("unix", *socket); err == nil {
+		conn.Close()
+		return fmt.Errorf("a daemon is already listening on %s", *socket)
+	}
+	os.Remove(*socket)
+
+	listener, err := net.Listen("unix", *socket)
+	if err != nil {
+		return fmt.Errorf("failed to listen on %s: %w", *socket, err)
+	}
+	if err := os.Chmod(*socket, 0600); err != nil {
+		listener.Close()
+		return fmt.Errorf("failed to restrict %s: %w", *socket, err)
+	}
+
+	sigChan := make(chan os.Signal, 1)
+	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		<-sigChan
+		listener.Close()
+	}()
+
+	log.Printf("🛰️  Daemon listening on %s (model %s)", *socket, utils.DefaultModel)
+	d := newDaemon(int64(maxSessionMemory))
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				log.Println("🛰️  Daemon stopped.")
+				return nil
+			}
+			return err
+		}
+		go d.serve(conn)
+	}
+}
+
+// serve answers a single request on conn.
+func (d *daemon) serve(conn net.Conn) {
+	defer conn.Close()
+
+	var req daemonRequest
+	var resp daemonResponse
+	line, err := bufio.NewReader(conn).ReadBytes('\n')
+	if len(line) == 0 && err == io.EOF {
+		return // a liveness probe such as another daemon starting up
+	}
+	switch {
+	case err != nil && err != io.EOF:
+		resp.Error = fmt.Sprintf("failed to read request: %v", err)
+	case json.Unmarshal(line, &req) != nil:
+		resp.Error = "request is not valid JSON"
+	default:
+		resp = d.handle(req)
+	}
+
+	if err := json.NewEncoder(conn).Encode(resp); err != nil {
+		log.Printf("Failed to write response: %v", err)
+	}
+}
+
+// handle runs one request and never fails; errors are reported in the response.
+func (d *daemon) handle(req daemonRequest) daemonResponse {
+	resp := daemonResponse{ID: req.ID}
+	var err error
+	switch req.Action {
+	case "", "ask":
+		if strings.TrimSpace(req.Question) == "" {
+			err = fmt.Errorf("empty question")
+			break
+		}
+		resp.Answer, err = d.answer(context.Background(), req, " you are a helpful assistant. ")
+	case "explain":
+		if req.Selection == "" {
+			err = fmt.Errorf("explain needs a selection")
+			break
+		}
+		if strings.TrimSpace(req.Question) == "" {
+			req.Question = "Explain what this code does and point out anything surprising."
+		}
+		resp.Answer, err = d.answer(context.Background(), req, fmt.Sprintf("The user selected this %s code in %s:\n```%s\n%s\n```", req.Filetype, req.File, req.Filetype, req.Selection))
+	case "insert":
+		resp.Text, err = insertAtCursor(req)
+	case "apply-diff":
+		resp.Diff, resp.Text, err = proposeDiff(req)
+	case "memstats":
+		resp.Text = utils.FormatMemoryStats(utils.MemoryStats())
+	default:
+		err = fmt.Errorf("unknown action %q (use ask, insert, explain, apply-diff or memstats)", req.Action)
+	}
+	if err != nil {
+		resp.Error = err.Error()
+	}
+	return resp
+}
+
+// answer runs the requested flow with the given context, continuing the named session if there is one.
+func (d *daemon) answer(ctx context.Context, req daemonRequest, promptContext string) (string, error) {
+	var flow *flyt.Flow
+	switch req.Mode {
+	case "", "qa":
+		flow = CreateQAFlow()
+	case "agent":
+		flow = CreateAgentFlow()
+	default:
+		return "", fmt.Errorf("unknown mode %q (use qa or agent)", req.Mode)
+	}
+
+	if d.state != nil && req.Session != "" {
+		unlock, err := d.lockSession(ctx, req.Session)
+		if err != nil {
+			return "", fmt.Errorf("could not lock session %q: %w", req.Session, err)
+		}
+		defer unlock()
+	}
+	shared := flyt.NewSharedStore()
+	history, err := d.session(ctx, req.Session)
+	if err != nil {
+		return "", err
+	}
+	shared.Set("history", history)
+	shared.Set("context", promptContext)
+	shared.Set("question", req.Question)
+	if req.events != nil {
+		shared.Set("stream_events", req.events)
+	}
+
+	if err := flow.Run(ctx, shared); err != nil {
+		return "", err
+	}
+	// A cancelled turn is not kept, even if a node swallowed the error.
+	if err := ctx.Err(); err != nil {
+		return "", err
+	}
+	if req.Session != "" {
+		// The answer is still returned; the turn is only missing from the session.
+		if err := d.storeSession(ctx, req.Session, utils.GetHistory(shared)); err != nil {
+			log.Printf("⚠️ Could not save session %q: %v", req.Session, err)
+		}
+	}
+	answer, _ := shared.Get("answer")
+	text, _ := answer.(string)
+	return text, nil
+}
+
+// runAsk sends a question (from the arguments or stdin) to the daemon and
+// prints the answer. Without a daemon the question is answered in this
+// process, so ask works in shell pipelines on its own; the exit status tells
+// a failure (1), a missing question (2) and an interrupt (130) apart.
+func runAsk(args []string) error {
+	fs, model := newSubcommandFlags("ask")
+	socket := fs.String("socket", daemonSocketPath(), "Unix socket of the daemon")
+	session := fs.String("session", "", "Continue the daemon-side conversation with this name (needs the daemon)")
+	agent := fs.Bool("agent", false, "Use the agent flow instead of plain Q&A")
+	if err := parseWithSettings(fs, args); err != nil {
+		return err
+	}
+	utils.DefaultModel = *model
+
+	question := strings.Join(fs.Args(), " ")
+	switch {
+	case question == "":
+		data, err := io.ReadAll(os.Stdin)
+		if err != nil {
+			return fmt.Errorf("failed to read stdin: %w", err)
+		}
+		question = string(data)
+	case utils.IsPiped(os.Stdin):
+		var err error
+		if question, err = readAttachedStdin(question); err != nil {
+			return err
+		}
+	}
+	if strings.TrimSpace(question) == "" {
+		return exitCodeError{exitUsage, fmt.Errorf("usage: %s", subcommands["ask"].usage)}
+	}
+
+	req := daemonRequest{Question: question, Session: *session}
+	if *agent {
+		req.Mode = "agent"
+	}
+
+	conn, err := net.Dial("unix", *socket)
+	if err != nil {
+		if *session != "" {
+			return fmt.Errorf("no daemon on %s for -session (start one with `%s daemon`): %w", *socket, filepath.Base(os.Args[0]), err)
+		}
+		// Only the answer goes to stdout; status lines go to stderr.
+		out := os.Stdout
+		os.Stdout = os.Stderr
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+		defer stop()
+		answer, err := newDaemon(0).answer(ctx, req, " you are a helpful assistant. ")
+		if ctx.Err() != nil {
+			return exitCodeError{exitInterrupted, ctx.Err()}
+		}
+		if err != nil {
+			return err
+		}
+		fmt.Fprintln(out, answer)
+		return nil
+	}
+	defer conn.Close()
+
+	if err := json.NewEncoder(conn).Encode(req); err != nil {
+		return fmt.Errorf("failed to send request: %w", err)
+	}
+	var resp daemonResponse
+	if err := json.NewDecoder(conn).Decode(&resp); err != nil {
+		return fmt.Errorf("failed to read response: %w", err)
+	}
+	if resp.Error != "" {
+		return fmt.Errorf("daemon: %s", resp.Error)
+	}
+	fmt.Println(resp.Answer)
+	return nil
+}
+
+// insertAtCursor asks for code to insert between req.Before and req.After.
+func insertAtCursor(req daemonRequest) (string, error) {
+	if strings.TrimSpace(req.Question) == "" {
+		req.Question = "Complete the code at the cursor."
+	}
+	prompt := fmt.Sprintf(`You are editing %s (%s). The cursor is between BEFORE and AFTER.
+BEFORE:
+%s
+AFTER:
+%s
+
+Instruction: %s
+Reply with only the code to insert at the cursor, in one code block, matching the surrounding indentation.`,
+		req.File, req.Filetype, req.Before, req.After, req.Question)
+	reply, err := utils.CallLLM(context.Background(), prompt)
+	if err != nil {
+		return "", err
+	}
+	return utils.ExtractCodeBlock(reply), nil
+}
+
+// proposeDiff asks for a unified diff implementing req.Question on req.Content,
+// retrying until it applies cleanly, and returns the diff and the patched content.
+func proposeDiff(req daemonRequest) (string, string, error) {
+	if req.Content == "" || strings.TrimSpace(req.Question) == "" {
+		return "", "", fmt.Errorf("apply-diff needs content and a question")
+	}
+	name := filepath.Base(req.File)
+	prompt := fmt.Sprintf(`Here is %s:
+`+"```%s\n%s\n```"+`
+
+Make this change: %s
+Reply with only a unified diff (--- a/%s, +++ b/%s, @@ hunks with 3 lines of context) in one code block.`,
+		name, req.Filetype, req.Content, req.Question, name, name)
+
+	var patched string
+	validate := func(diff string) error {
+		var err error
+		patched, err = utils.ApplyUnifiedDiff(req.Content, diff)
+		return err
+	}
+	diff, _, err := runValidatedGeneration(prompt, validate, 3)
+	if err != nil {
+		return diff, "", err
+	}
+	return diff, patched, nil
+}
+
+// runEditor serves the daemon protocol over stdin/stdout, one JSON request per
+// line, for editor plugins. Requests run concurrently; match responses by "id".
+func runEditor(args []string) error {
+	fs, model := newSubcommandFlags("editor")
+	if err := parseWithSettings(fs, args); err != nil {
+		return err
+	}
+	utils.DefaultModel = *model
+
+	// Only protocol messages may reach stdout; status output goes to stderr.
+	out := json.NewEncoder(os.Stdout)
+	os.Stdout = os.Stderr
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	d := &daemon{sessions: map[string]utils.History{}}
+	scanner := bufio.NewScanner(os.Stdin)
+	scanner.Buffer(make([]byte, 1024*1024), 64*1024*1024)
+	for scanner.Scan() {
+		line := append([]byte(nil), scanner.Bytes()...)
+		if len(strings.TrimSpace(string(line))) == 0 {
+			continue
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			var req daemonRequest
+			resp := daemonResponse{Error: "request is not valid JSON"}
+			if json.Unmarshal(line, &req) == nil {
+				resp = d.handle(req)
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			out.Encode(resp)
+		}()
+	}
+	wg.Wait()
+	return scanner.Err()
+}
@@ -0,0 +1,133 @@
+-- Reference Neovim client for the `editor` subcommand: one JSON request per
+-- line on the tool's stdin, one JSON response per line on its stdout, matched by id.
+--
+-- Install: copy to ~/.config/nvim/lua/ai_wraper.lua, then in init.lua:
+--   require("ai_wraper").setup({ cmd = { "/path/to/ai-query", "editor" } })
+local M = {}
+
+local config = {
+  cmd = { "ai-query", "editor" },
+  session = "nvim",
+  context_lines = 200,
+}
+
+local job = nil
+local next_id = 0
+local pending = {}
+local partial = ""
+
+local function on_stdout(_, data)
+  -- Lines may arrive split across callbacks; the last element is always incomplete.
+  data[1] = partial .. data[1]
+  partial = table.remove(data)
+  for _, line in ipairs(data) do
+    if line ~= "" then
+      local ok, resp = pcall(vim.json.decode, line)
+      if ok and resp.id and pending[resp.id] then
+        local cb = pending[resp.id]
+        pending[resp.id] = nil
+        vim.schedule(function() cb(resp) end)
+      end
+    end
+  end
+end
+
+local function ensure_job()
+  if job and vim.fn.jobwait({ job }, 0)[1] == -1 then
+    return job
+  end
+  partial = ""
+  job = vim.fn.jobstart(config.cmd, { on_stdout = on_stdout })
+  if job <= 0 then
+    error("ai_wraper: could not start " .. table.concat(config.cmd, " "))
+  end
+  return job
+end
+
+local function request(req, cb)
+  next_id = next_id + 1
+  req.id = next_id
+  req.session = config.session
+  pending[next_id] = cb
+  vim.fn.chansend(ensure_job(), vim.json.encode(req) .. "\n")
+  vim.notify("ai_wraper: " .. req.action .. "…")
+end
+
+local function ok(resp)
+  if resp.error and resp.error ~= "" then
+    vim.notify("ai_wraper: " .. resp.error, vim.log.levels.ERROR)
+    return false
+  end
+  return true
+end
+
+local function show(text, filetype)
+  vim.cmd("botright new")
+  local buf = vim.api.nvim_get_current_buf()
+  vim.bo[buf].buftype = "nofile"
+  vim.bo[buf].bufhidden = "wipe"
+  vim.bo[buf].filetype = filetype or "markdown"
+  vim.api.nvim_buf_set_lines(buf, 0, -1, false, vim.split(text, "\n"))
+end
+
+local function buffer_info()
+  local buf = vim.api.nvim_get_current_buf()
+  return buf, vim.api.nvim_buf_get_name(buf), vim.bo[buf].filetype
+end
+
+local function join(lines)
+  return table.concat(lines, "\n")
+end
+
+-- :AiAsk question
+function M.ask(question)
+  request({ action = "ask", question = question }, function(resp)
+    if ok(resp) then show(resp.answer) end
+  end)
+end
+
+-- :'<,'>AiExplain [question]
+function M.explain(line1, line2, question)
+  local buf, file, filetype = buffer_info()
+  local selection = join(vim.api.nvim_buf_get_lines(buf, line1 - 1, line2, false))
+  request({ action = "explain", question = question, file = file, filetype = filetype, selection = selection }, function(resp)
+    if ok(resp) then show(resp.answer) end
+  end)
+end
+
+-- :AiInsert instruction — inserts the generated code below the cursor line.
+function M.insert(instruction)
+  local buf, file, filetype = buffer_info()
+  local row = vim.api.nvim_win_get_cursor(0)[1]
+  local before = vim.api.nvim_buf_get_lines(buf, math.max(row - config.context_lines, 0), row, false)
+  local after = vim.api.nvim_buf_get_lines(buf, row, row + config.context_lines, false)
+  request({ action = "insert", question = instruction, file = file, filetype = filetype, before = join(before), after = join(after) }, function(resp)
+    if ok(resp) and resp.text ~= "" then
+      vim.api.nvim_buf_set_lines(buf, row, row, false, vim.split(resp.text, "\n"))
+    end
+  end)
+end
+
+-- :AiApply instruction — shows the proposed diff and replaces the buffer once confirmed.
+function M.apply(instruction)
+  local buf, file, filetype = buffer_info()
+  local content = join(vim.api.nvim_buf_get_lines(buf, 0, -1, false)) .. "\n"
+  request({ action = "apply-diff", question = instruction, file = file, filetype = filetype, content = content }, function(resp)
+    if not ok(resp) then return end
+    show(resp.diff, "diff")
+    if vim.fn.confirm("Apply this diff?", "&Yes\n&No", 2) == 1 then
+      local text = resp.text:gsub("\n$", "")
+      vim.api.nvim_buf_set_lines(buf, 0, -1, false, vim.split(text, "\n"))
+    end
+  end)
+end
+
+function M.setup(opts)
+  config = vim.tbl_extend("force", config, opts or {})
+  vim.api.nvim_create_user_command("AiAsk", function(o) M.ask(o.args) end, { nargs = "+" })
+  vim.api.nvim_create_user_command("AiExplain", function(o) M.explain(o.line1, o.line2, o.args) end, { nargs = "*", range = true })
+  vim.api.nvim_create_user_command("AiInsert", function(o) M.insert(o.args) end, { nargs = "+" })
+  vim.api.nvim_create_user_command("AiApply", function(o) M.apply(o.args) end, { nargs = "+" })
+end
+
+return M
@@ -8,10 +8,18 @@ import (
 func CreateQAFlow() *flyt.Flow {
 	// Create nodes
 	// getQuestionNode := CreateGetQuestionNode()
+	routeNode := CreateRouteNode()
+	retrieveNode := CreateRetrieveNode()
 	answerNode := CreateAnswerNode()
+	youTubeNode := CreateYouTubeAnswerNode()
+	calendarNode := CreateCalendarAnswerNode()
 
 	// Connect nodes in sequence
-	flow := flyt.NewFlow(answerNode)
+	flow := flyt.NewFlow(routeNode)
+	flow.Connect(routeNode, flyt.DefaultAction, retrieveNode)
+	flow.Connect(retrieveNode, flyt.DefaultAction, answerNode)
+	flow.Connect(routeNode, "youtube", youTubeNode)
+	flow.Connect(routeNode, "calendar", calendarNode)
 	// flow.Connect(getQuestionNode, flyt.DefaultAction, answerNode)
 
 	return flow
@@ -23,6 +31,10 @@ func CreateAgentFlow() *flyt.Flow {
 	analyzeNode := CreateAnalyzeNode()
 	searchAnswerNode := CreateSearchAnswerNode()
 	imageAnswerNode := CreateImageAnswerNode()
+	manHelpNode := CreateManHelpNode()
+	symbolNode := CreateSymbolAnswerNode()
+	youTubeNode := CreateYouTubeAnswerNode()
+	calendarNode := CreateCalendarAnswerNode()
 	// processNode := CreateProcessNode()
 	// answerNode := CreateAnswerNode()
 
@@ -31,6 +43,32 @@ func CreateAgentFlow() *flyt.Flow {
 
 	flow.Connect(analyzeNode, "search", searchAnswerNode)
 	flow.Connect(analyzeNode, "analyze_images", imageAnswerNode)
+	flow.Connect(analyzeNode, "man", manHelpNode)
+	flow.Connect(analyzeNode, "symbol", symbolNode)
+	flow.Connect(analyzeNode, "youtube", youTubeNode)
+	flow.Connect(analyzeNode, "calendar", calendarNode)
+	flow.Connect(analyzeNode, "edit", CreateEditFilesNode())
+	// With -tool-choice model, the model picks the tools by function calling.
+	flow.Connect(analyzeNode, "tool_use", CreateToolUseNode())
+	// With -tool-choice react the model reasons and acts in a loop until it
+	// answers or runs out of steps.
+	reasonNode := CreateReasonNode()
+	reactSearchNode := CreateReActSearchNode()
+	reactShellNode := CreateReActShellNode()
+	askUserNode := CreateAskUserNode()
+	reactAnswerNode := CreateReActAnswerNode()
+	flow.Connect(analyzeNode, "react", reasonNode)
+	flow.Connect(reasonNode, "reason", reasonNode)
+	flow.Connect(reasonNode, "search", reactSearchNode)
+	flow.Connect(reactSearchNode, "reason", reasonNode)
+	flow.Connect(reasonNode, "run_shell", reactShellNode)
+	flow.Connect(reactShellNode, "reason", reasonNode)
+	flow.Connect(reasonNode, "ask_user", askUserNode)
+	flow.Connect(askUserNode, "reason", reasonNode)
+	flow.Connect(reasonNode, "answer", reactAnswerNode)
+	flow.Connect(reasonNode, "finish", reactAnswerNode)
+	// Without the search tool (-tools), questions get a plain answer.
+	flow.Connect(analyzeNode, "answer", CreateAnswerNode())
 
 	// Connect based on analysis results
 	// flow.Connect(analyzeNode, "search", searchNode)
@@ -61,3 +99,206 @@ func CreateBatchFlow() *flyt.Flow {
 
 	return flow
 }
+
+// CreateBatchAPIFlow creates a batch flow that runs the prompts as one
+// asynchronous provider batch job instead of one request per item.
+func CreateBatchAPIFlow() *flyt.Flow {
+	loadItemsNode := CreateLoadItemsNode()
+	batchAPINode := CreateBatchAPINode()
+	aggregateNode := CreateAggregateResultsNode()
+
+	flow := flyt.NewFlow(loadItemsNode)
+	flow.Connect(loadItemsNode, flyt.DefaultAction, batchAPINode)
+	flow.Connect(batchAPINode, flyt.DefaultAction, aggregateNode)
+
+	return flow
+}
+
+// CreateDataFlow creates a flow that answers questions about tabular data:
+// the LLM plans aggregations, they run locally, and only the results are sent back.
+func CreateDataFlow() *flyt.Flow {
+	planNode := CreateDataPlanNode()
+	queryNode := CreateDataQueryNode()
+	answerNode := CreateDataAnswerNode()
+
+	flow := flyt.NewFlow(planNode)
+	flow.Connect(planNode, flyt.DefaultAction, queryNode)
+	flow.Connect(queryNode, flyt.DefaultAction, answerNode)
+
+	return flow
+}
+
+// CreateLogFlow creates a map-reduce flow over a large log file:
+// chunks are scanned for anomalies concurrently, then correlated into a root-cause summary.
+func CreateLogFlow() *flyt.Flow {
+	loadNode := CreateLoadLogChunksNode()
+	mapNode := CreateLogMapNode()
+	reduceNode := CreateLogReduceNode()
+
+	flow := flyt.NewFlow(loadNode)
+	flow.Connect(loadNode, flyt.DefaultAction, mapNode)
+	flow.Connect(mapNode, flyt.DefaultAction, reduceNode)
+
+	return flow
+}
+
+// CreateValidatedGenerationFlow creates a generate → validate loop that retries
+// with the validation error until the answer passes or maxAttempts is reached.
+func CreateValidatedGenerationFlow(validate func(candidate string) error, maxAttempts int) *flyt.Flow {
+	generateNode := CreateGenerateCandidateNode()
+	validateNode := CreateValidateCandidateNode(validate, maxAttempts)
+
+	flow := flyt.NewFlow(generateNode)
+	flow.Connect(generateNode, flyt.DefaultAction, validateNode)
+	flow.Connect(validateNode, "retry", generateNode)
+
+	return flow
+}
+
+// CreateFollowUpFlow creates a single-node flow that suggests follow-up questions to the last answer.
+func CreateFollowUpFlow() *flyt.Flow {
+	return flyt.NewFlow(CreateFollowUpNode())
+}
+
+// CreateSummarizeFlow creates a single-node flow that replaces the history with a summary of it.
+func CreateSummarizeFlow() *flyt.Flow {
+	return flyt.NewFlow(CreateSummarizeHistoryNode())
+}
+
+// CreateCompactHistoryFlow creates a single-node flow that folds older turns into the history's summary.
+func CreateCompactHistoryFlow() *flyt.Flow {
+	return flyt.NewFlow(CreateCompactHistoryNode())
+}
+
+// CreatePRReviewFlow creates a flow that fetches a GitHub pull request,
+// reviews each file's diff concurrently, and reports (or posts) the line comments.
+func CreatePRReviewFlow() *flyt.Flow {
+	loadNode := CreateLoadPRNode()
+	reviewNode := CreatePRReviewFileNode()
+	reportNode := CreatePRReportNode()
+
+	flow := flyt.NewFlow(loadNode)
+	flow.Connect(loadNode, flyt.DefaultAction, reviewNode)
+	flow.Connect(reviewNode, flyt.DefaultAction, reportNode)
+
+	return flow
+}
+
+// CreateSecurityReviewFlow creates a flow that runs the static security
+// checks over source files, has the model review each chunk concurrently with
+// their hits as hints, and merges everything into one deduplicated report.
+func CreateSecurityReviewFlow() *flyt.Flow {
+	loadNode := CreateLoadSecurityFilesNode()
+	reviewNode := CreateSecurityReviewFileNode()
+	reportNode := CreateSecurityReportNode()
+
+	flow := flyt.NewFlow(loadNode)
+	flow.Connect(loadNode, flyt.DefaultAction, reviewNode)
+	flow.Connect(reviewNode, flyt.DefaultAction, reportNode)
+
+	return flow
+}
+
+// CreateIssueTriageFlow creates a flow that loads open issues, classifies them
+// concurrently (with embedding-based duplicate candidates), and builds a report.
+func CreateIssueTriageFlow() *flyt.Flow {
+	loadNode := CreateLoadIssuesNode()
+	classifyNode := CreateClassifyIssueNode()
+	reportNode := CreateTriageReportNode()
+
+	flow := flyt.NewFlow(loadNode)
+	flow.Connect(loadNode, flyt.DefaultAction, classifyNode)
+	flow.Connect(classifyNode, flyt.DefaultAction, reportNode)
+
+	return flow
+}
+
+// CreatePlanExplainFlow creates a flow that parses a terraform JSON plan,
+// assesses each resource type's changes concurrently, and ranks them by risk.
+func CreatePlanExplainFlow() *flyt.Flow {
+	loadNode := CreateLoadPlanNode()
+	riskNode := CreatePlanRiskNode()
+	reportNode := CreatePlanReportNode()
+
+	flow := flyt.NewFlow(loadNode)
+	flow.Connect(loadNode, flyt.DefaultAction, riskNode)
+	flow.Connect(riskNode, flyt.DefaultAction, reportNode)
+
+	return flow
+}
+
+// CreateReleaseNotesFlow creates a flow that reads the commits in a git range,
+// drafts release notes grouped by type, and redrafts them until every listed
+// change cites a real commit of the range.
+func CreateReleaseNotesFlow(maxAttempts int) *flyt.Flow {
+	loadNode := CreateLoadReleaseCommitsNode()
+	draftNode := CreateGenerateCandidateNode()
+	verifyNode := CreateVerifyReleaseNotesNode(maxAttempts)
+
+	flow := flyt.NewFlow(loadNode)
+	flow.Connect(loadNode, flyt.DefaultAction, draftNode)
+	flow.Connect(draftNode, flyt.DefaultAction, verifyNode)
+	flow.Connect(verifyNode, "retry", draftNode)
+
+	return flow
+}
+
+// CreateFeedDigestFlow creates a flow that fetches feeds, summarizes the new
+// items concurrently, and writes a digest.
+func CreateFeedDigestFlow() *flyt.Flow {
+	fetchNode := CreateFetchFeedsNode()
+	summarizeNode := CreateSummarizeFeedItemNode()
+	digestNode := CreateFeedDigestNode()
+
+	flow := flyt.NewFlow(fetchNode)
+	flow.Connect(fetchNode, flyt.DefaultAction, summarizeNode)
+	flow.Connect(summarizeNode, flyt.DefaultAction, digestNode)
+
+	return flow
+}
+
+// CreateAudioFlow creates a pipeline for long recordings: split into segments,
+// transcribe them concurrently with checkpoints, then map-reduce summarize.
+func CreateAudioFlow() *flyt.Flow {
+	loadNode := CreateLoadAudioSegmentsNode()
+	transcribeNode := CreateTranscribeSegmentNode()
+	mapNode := CreateAudioMapNode()
+	reduceNode := CreateAudioReduceNode()
+
+	flow := flyt.NewFlow(loadNode)
+	flow.Connect(loadNode, flyt.DefaultAction, transcribeNode)
+	flow.Connect(transcribeNode, flyt.DefaultAction, mapNode)
+	flow.Connect(mapNode, flyt.DefaultAction, reduceNode)
+
+	return flow
+}
+
+// CreateEmailFlow creates a single-node flow that answers questions about fetched emails.
+func CreateEmailFlow() *flyt.Flow {
+	return flyt.NewFlow(CreateEmailAnswerNode())
+}
+
+// CreateTicketFlow creates a single-node flow that drafts an issue-tracker ticket.
+func CreateTicketFlow() *flyt.Flow {
+	return flyt.NewFlow(CreateTicketDraftNode())
+}
+
+// CreateFlashcardsFlow creates a single-node flow that writes study flashcards.
+func CreateFlashcardsFlow() *flyt.Flow {
+	return flyt.NewFlow(CreateFlashcardsNode())
+}
+
+// CreateQuizSetupFlow creates a single-node flow that indexes the quiz documents.
+func CreateQuizSetupFlow() *flyt.Flow {
+	return flyt.NewFlow(CreateQuizIndexNode())
+}
+
+// CreateQuizQuestionFlow creates a single-node flow that writes the next quiz question.
+func CreateQuizQuestionFlow() *flyt.Flow {
+	return flyt.NewFlow(CreateQuizQuestionNode())
+}
+
+// CreateQuizGradeFlow creates a single-node flow that grades a quiz answer.
+func CreateQuizGradeFlow() *flyt.Flow {
+	return flyt.NewFlow(CreateQuizGradeNode())
+}
@@ -0,0 +1,263 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"flag"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"flyt-project-template/utils"
+)
+
+// hookMarker identifies hook scripts written by "hook install".
+const hookMarker = "# installed by ai_wraper hook install"
+
+// gitHooks are the hooks "hook install" manages.
+var gitHooks = []string{"prepare-commit-msg", "pre-push"}
+
+// maxHookDiffChars caps how much of a diff is sent to the model from a hook.
+const maxHookDiffChars = 60000
+
+// runHook dispatches "hook install|uninstall" and the hook entry points that the installed scripts call.
+func runHook(args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("usage: %s", subcommands["hook"].usage)
+	}
+	switch args[0] {
+	case "install":
+		fs := flag.NewFlagSet("hook install", flag.ExitOnError)
+		force := fs.Bool("force", false, "Overwrite existing hooks that were not installed by this tool")
+		timeout := fs.Duration("timeout", 20*time.Second, "Give up on the model after this long and let git continue")
+		fs.Parse(args[1:])
+		return installHooks(*force, *timeout)
+	case "uninstall":
+		return uninstallHooks()
+	case "prepare-commit-msg", "pre-push":
+		fs, model := newSubcommandFlags("hook " + args[0])
+		timeout := fs.Duration("timeout", 20*time.Second, "Give up on the model after this long")
+		provider := fs.String("provider", "gemini", "LLM backend: gemini or openai (usually set in the config file or AI_WRAPER_PROVIDER)")
+		baseURL := fs.String("base-url", "", "API base URL for -provider openai")
+		if err := parseWithSettings(fs, args[1:]); err != nil {
+			return err
+		}
+		switch *provider {
+		case "gemini":
+		case "openai":
+			utils.DefaultProvider = utils.NewOpenAIProvider(*baseURL)
+			modelSet := false
+			fs.Visit(func(f *flag.Flag) { modelSet = modelSet || f.Name == "model" })
+			if !modelSet {
+				*model = "gpt-4o-mini"
+			}
+		default:
+			return fmt.Errorf("unknown provider %q (use gemini or openai)", *provider)
+		}
+		utils.DefaultModel = *model
+		// Messages from -m, merges, squashes and amends are left alone, without any network check.
+		if args[0] == "prepare-commit-msg" && fs.NArg() > 1 && fs.Arg(1) != "" && fs.Arg(1) != "template" {
+			return nil
+		}
+		// A hook must never block git: problems are reported and swallowed.
+		if err := runHookWithSafeguards(args[0], fs.Args(), *timeout); err != nil {
+			fmt.Fprintf(os.Stderr, "ai_wraper %s: skipped (%v)\n", args[0], err)
+		}
+		return nil
+	default:
+		return fmt.Errorf("usage: %s", subcommands["hook"].usage)
+	}
+}
+
+func gitHooksDir() (string, error) {
+	out, err := exec.Command("git", "rev-parse", "--git-path", "hooks").Output()
+	if err != nil {
+		return "", fmt.Errorf("not inside a git repository")
+	}
+	return filepath.Abs(strings.TrimSpace(string(out)))
+}
+
+func installHooks(force bool, timeout time.Duration) error {
+	dir, err := gitHooksDir()
+	if err != nil {
+		return err
+	}
+	binary, err := os.Executable()
+	if err != nil {
+		return fmt.Errorf("could not locate this binary: %w", err)
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+
+	for _, hook := range gitHooks {
+		path := filepath.Join(dir, hook)
+		if existing, err := os.ReadFile(path); err == nil && !strings.Contains(string(existing), hookMarker) && !force {
+			return fmt.Errorf("%s already exists and was not installed by this tool (use -force to overwrite)", path)
+		}
+		// %q would leave $, ` and \ in the path for sh to expand.
+		script := fmt.Sprintf("#!/bin/sh\n%s\n# Set AI_WRAPER_SKIP_HOOKS=1 to bypass.\n[ -n \"$AI_WRAPER_SKIP_HOOKS\" ] && exit 0\nexec %s hook %s -timeout %s \"$@\"\n",
+			hookMarker, shellQuote(binary), hook, timeout)
+		if err := os.WriteFile(path, []byte(script), 0755); err != nil {
+			return fmt.Errorf("failed to write %s: %w", path, err)
+		}
+		fmt.Printf("🪝 Installed %s\n", path)
+	}
+	return nil
+}
+
+func uninstallHooks() error {
+	dir, err := gitHooksDir()
+	if err != nil {
+		return err
+	}
+	for _, hook := range gitHooks {
+		path := filepath.Join(dir, hook)
+		existing, err := os.ReadFile(path)
+		if err != nil || !strings.Contains(string(existing), hookMarker) {
+			continue
+		}
+		if err := os.Remove(path); err != nil {
+			return err
+		}
+		fmt.Printf("Removed %s\n", path)
+	}
+	return nil
+}
+
+// providerAddress returns the host:port of the configured provider's API, or
+// an error when the key it needs is not set.
+func providerAddress() (string, error) {
+	p, ok := utils.DefaultProvider.(utils.OpenAIProvider)
+	if !ok {
+		if os.Getenv("GEMINI_API_KEY") == "" {
+			return "", fmt.Errorf("GEMINI_API_KEY not set")
+		}
+		return "generativelanguage.googleapis.com:443", nil
+	}
+	// Local and other compatible servers may need no key.
+	if p.APIKey == "" && p.BaseURL == utils.DefaultOpenAIBaseURL {
+		return "", fmt.Errorf("OPENAI_API_KEY not set")
+	}
+	u, err := url.Parse(p.BaseURL)
+	if err != nil || u.Hostname() == "" {
+		return "", fmt.Errorf("invalid -base-url %q", p.BaseURL)
+	}
+	port := u.Port()
+	if port == "" {
+		port = "443"
+		if u.Scheme == "http" {
+			port = "80"
+		}
+	}
+	return net.JoinHostPort(u.Hostname(), port), nil
+}
+
+// runHookWithSafeguards skips the hook when the provider is unreachable and
+// abandons it after timeout.
+func runHookWithSafeguards(hook string, args []string, timeout time.Duration) error {
+	addr, err := providerAddress()
+	if err != nil {
+		return err
+	}
+	conn, err := net.DialTimeout("tcp", addr, 2*time.Second)
+	if err != nil {
+		return fmt.Errorf("%s unreachable", addr)
+	}
+	conn.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	done := make(chan error, 1)
+	go func() {
+		if hook == "pre-push" {
+			done <- summarizePush(ctx, args)
+		} else {
+			done <- draftCommitMessage(ctx, args)
+		}
+	}()
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return fmt.Errorf("timed out after %s", timeout)
+	}
+}
+
+// draftCommitMessage writes a drafted message for the staged changes into the commit message file.
+func draftCommitMessage(ctx context.Context, args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("missing commit message file")
+	}
+	file := args[0]
+
+	diff, err := exec.Command("git", "diff", "--cached").Output()
+	if err != nil || len(strings.TrimSpace(string(diff))) == 0 {
+		return nil
+	}
+	recent, _ := exec.Command("git", "log", "--oneline", "-10").Output()
+
+	prompt := fmt.Sprintf(`Write a git commit message for this staged diff.
+Match the style of the repository's recent commits:
+%s
+Use a short imperative subject line (under 72 characters), a blank line, then a brief body only if the change needs explaining.
+Reply with only the commit message in one code block.
+
+%s`, recent, TruncateString(string(diff), maxHookDiffChars))
+	reply, err := utils.CallLLM(ctx, prompt)
+	if err != nil {
+		return err
+	}
+
+	existing, err := os.ReadFile(file)
+	if err != nil {
+		return err
+	}
+	draft := utils.ExtractCodeBlock(reply) + "\n" + string(existing)
+	return os.WriteFile(file, []byte(draft), 0644)
+}
+
+// summarizePush prints a short summary of the commits about to be pushed.
+// Git passes one "<local ref> <local sha> <remote ref> <remote sha>" line per ref on stdin.
+func summarizePush(ctx context.Context, args []string) error {
+	const zeroSHA = "0000000000000000000000000000000000000000"
+	var logText strings.Builder
+	scanner := bufio.NewScanner(os.Stdin)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) != 4 || fields[1] == zeroSHA {
+			continue // deleting a ref
+		}
+		rangeArgs := []string{fields[3] + ".." + fields[1]}
+		if fields[3] == zeroSHA {
+			// A new branch: everything not already on a remote.
+			rangeArgs = []string{fields[1], "--not", "--remotes"}
+		}
+		out, err := exec.Command("git", append([]string{"log", "--stat", "--format=%h %s%n%b"}, rangeArgs...)...).Output()
+		if err != nil {
+			return fmt.Errorf("git log failed: %w", err)
+		}
+		fmt.Fprintf(&logText, "Ref %s:\n%s\n", fields[2], out)
+	}
+	if strings.TrimSpace(logText.String()) == "" {
+		return nil
+	}
+
+	remote := "the remote"
+	if len(args) > 0 {
+		remote = args[0]
+	}
+	prompt := fmt.Sprintf("Summarize in 3-5 bullet points what this push to %s changes, for a teammate. Mention anything risky (migrations, config, deleted files).\n\n%s",
+		remote, TruncateString(logText.String(), maxHookDiffChars))
+	summary, err := utils.CallLLM(ctx, prompt)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "\n📤 Push summary:\n%s\n\n", strings.TrimSpace(summary))
+	return nil
+}
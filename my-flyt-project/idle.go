@@ -0,0 +1,133 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+
+	"flyt-project-template/utils"
+
+	"github.com/mark3labs/flyt"
+)
+
+// idleCheckInterval is how often the idle watcher looks at the clock and the screen lock.
+const idleCheckInterval = 15 * time.Second
+
+// idleWatcher saves the conversation and clears the terminal when the chat
+// has waited too long for input or the screen locks, optionally leaving only
+// a compressed or encrypted copy on disk.
+type idleWatcher struct {
+	shared *flyt.SharedStore
+	after  time.Duration
+	seal   string // "", "gzip" or "encrypt"
+
+	mu           sync.Mutex
+	waitingSince time.Time // zero while a question is being answered
+	fired        bool
+}
+
+// startIdleWatcher checks for inactivity in the background until the program exits.
+func startIdleWatcher(shared *flyt.SharedStore, after time.Duration, seal string) *idleWatcher {
+	w := &idleWatcher{shared: shared, after: after, seal: seal}
+	go func() {
+		for range time.Tick(idleCheckInterval) {
+			w.check()
+		}
+	}()
+	return w
+}
+
+// waiting records that the chat is waiting for the user from now on.
+func (w *idleWatcher) waiting() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.waitingSince, w.fired = time.Now(), false
+}
+
+// busy records that input arrived. It waits for a save in progress, so the
+// chat never touches the conversation at the same time.
+func (w *idleWatcher) busy() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.waitingSince = time.Time{}
+}
+
+func (w *idleWatcher) check() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.waitingSince.IsZero() || w.fired {
+		return
+	}
+	var reason string
+	switch {
+	case time.Since(w.waitingSince) >= w.after:
+		reason = fmt.Sprintf("after %s of inactivity", w.after)
+	case screenLocked():
+		reason = "because the screen locked"
+	default:
+		return
+	}
+	w.fired = true
+
+	fileName := ""
+	if len(utils.GetHistory(w.shared).Conversations) > 0 {
+		var err error
+		if fileName, err = w.save(); err != nil {
+			utils.PrintWarning("⚠️  Could not save the idle conversation: %v", err)
+			return
+		}
+	}
+	// Clear the screen and the scrollback so the conversation is not left on display.
+	fmt.Print("\033[H\033[2J\033[3J")
+	if fileName != "" {
+		fmt.Printf("🔒 Conversation saved to %s %s.\n", fileName, reason)
+	}
+	fmt.Println("The chat is still open; type your next question to continue.")
+}
+
+// save writes the conversation and, with -idle-seal, replaces the file with
+// a sealed copy that the next save after the user returns removes again.
+func (w *idleWatcher) save() (string, error) {
+	fileName, err := saveConversation(w.shared)
+	if err != nil || w.seal == "" {
+		return fileName, err
+	}
+	data, err := os.ReadFile(fileName)
+	if err != nil {
+		return "", err
+	}
+	key, ext := "", utils.SealedExt
+	if w.seal == "encrypt" {
+		key, ext = os.Getenv("CONVERSATION_KEY"), utils.EncryptedExt
+	}
+	sealed, err := utils.Seal(data, key)
+	if err != nil {
+		return "", fmt.Errorf("sealing conversation: %w", err)
+	}
+	if err := os.WriteFile(fileName+ext, sealed, 0600); err != nil {
+		return "", err
+	}
+	if err := os.Remove(fileName); err != nil {
+		return "", err
+	}
+	sealedCopy = fileName + ext
+	return sealedCopy, nil
+}
+
+// screenLocked reports whether the session's screen is locked. Only
+// systemd-logind sessions (most Linux desktops) can be checked.
+func screenLocked() bool {
+	session := os.Getenv("XDG_SESSION_ID")
+	if runtime.GOOS != "linux" || session == "" {
+		return false
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	out, err := exec.CommandContext(ctx, "loginctl", "show-session", session, "-p", "LockedHint", "--value").Output()
+	return err == nil && strings.TrimSpace(string(out)) == "yes"
+}
@@ -4,6 +4,7 @@ import (
 	"bufio"
 	"context"
 	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
 	"io"
@@ -11,7 +12,11 @@ import (
 	"os"
 	"os/exec"
 	"os/signal"
+	"regexp"
+	"sort"
+	"strconv"
 	"strings"
+	"sync"
 	"syscall"
 	"time"
 	"unicode/utf8"
@@ -24,6 +29,17 @@ import (
 
 var ConversationName string
 
+// ConversationFile is the key (with JSON storage, the file) the conversation
+// was first saved to or resumed from; saves overwrite it.
+var ConversationFile string
+
+// sealedCopy is a compressed or encrypted copy of the conversation, removed
+// by the next plain save.
+var sealedCopy string
+
+// conversationsDir is where conversations are saved (-save-dir).
+var conversationsDir = "Conversations"
+
 func TruncateString(s string, n int) string {
 	// If the string has N or fewer characters, return the whole string.
 	if utf8.RuneCountInString(s) <= n {
@@ -62,7 +78,92 @@ func readMultiLineInput(reader *bufio.Reader) (string, error) {
 	return builder.String(), nil
 }
 
+var (
+	// rawLaTeX disables rendering of LaTeX math to Unicode in displayed answers.
+	rawLaTeX bool
+	// usePager sends answers taller than the terminal through a pager.
+	usePager = true
+	// quitSummary makes /quit write a session recap first.
+	quitSummary bool
+	// answerJSON prints one-shot answers as a oneShotResult instead of text.
+	answerJSON bool
+	// answerRenderer displays finished answers: builtin, bat, glow or plain.
+	answerRenderer = "builtin"
+	// searchProvider grounds agent-mode answers: gemini (Google Search grounding) or duckduckgo.
+	searchProvider = "gemini"
+	// toolChoice decides who picks agent mode's tools: rules (the analyze
+	// node's checks), model (function calling) or react (a reason-act loop).
+	toolChoice = "rules"
+	// maxReActSteps caps the reason-act iterations of -tool-choice react.
+	maxReActSteps = 6
+)
+
+// readOnlyUsage documents -readonly, which the chat and every subcommand take.
+const readOnlyUsage = "Disable every tool that writes files, runs shell commands or changes data over HTTP (tickets, reviews, labels, calendar events, drafts), for flows over untrusted input"
+
+// inFlight holds the text of the answer being streamed, so an interrupt can
+// save what already arrived.
+var inFlight struct {
+	sync.Mutex
+	text strings.Builder
+}
+
+// terminalStream prints a streamed answer under the "✅ Answer:" header as it
+// arrives, with tool calls as status lines and citations after the text.
+func terminalStream() utils.StreamHandler {
+	started := false
+	return func(ev utils.StreamEvent) error {
+		if !started {
+			fmt.Println("\n" + utils.Paint(utils.StyleAI, "✅ Answer:"))
+			started = true
+			inFlight.Lock()
+			inFlight.text.Reset()
+			inFlight.Unlock()
+		}
+		switch ev := ev.(type) {
+		case utils.TextDelta:
+			inFlight.Lock()
+			inFlight.text.WriteString(ev.Text)
+			inFlight.Unlock()
+			fmt.Print(ev.Text)
+		case utils.ToolCallStart:
+			utils.PrintStatus("\n🔧 Calling %s", ev.Call.Name)
+		case utils.Citation:
+			fmt.Print(utils.Paint(utils.StyleCitation, fmt.Sprintf("\n  ↳ %s (%s)", ev.Title, ev.URI)))
+		case utils.Done:
+			fmt.Println()
+			started = false
+			inFlight.Lock()
+			inFlight.text.Reset()
+			inFlight.Unlock()
+		}
+		return nil
+	}
+}
+
 func displayAnswer(answer string) error {
+	if !rawLaTeX {
+		answer = utils.RenderLaTeX(answer)
+	}
+	answer = utils.RenderMarkdownTables(answer, utils.TerminalWidth())
+	// Search citations are printed after the body in the citation color.
+	answer, sources, _ := strings.Cut(answer, sourcesMarker)
+	if answerRenderer == "plain" {
+		if !usePager {
+			fmt.Print(answer + "\n" + formatSources(sources))
+			return nil
+		}
+		return utils.Page(answer + "\n" + formatSources(sources))
+	}
+	if answerRenderer == "builtin" {
+		rendered := utils.RenderMarkdown(answer, utils.TerminalWidth()) + formatSources(sources)
+		if !usePager {
+			fmt.Print(rendered)
+			return nil
+		}
+		return utils.Page(rendered)
+	}
+
 	tmpFile, err := os.CreateTemp("", "ai-answer-*.md")
 	if err != nil {
 		return fmt.Errorf("could not create temp file: %w", err)
@@ -76,93 +177,1166 @@ func displayAnswer(answer string) error {
 		return fmt.Errorf("could not close temp file: %w", err)
 	}
 
-	// We use 'bat' with flags for a clean, non-interactive output.
-	cmd := exec.Command("bat", "--paging=never", "--style=plain", "--language=markdown", tmpFile.Name())
+	var cmd *exec.Cmd
+	if answerRenderer == "glow" {
+		style := "auto"
+		if !utils.ColorEnabled() {
+			style = "notty"
+		}
+		cmd = exec.Command("glow", "--style="+style, "--width", strconv.Itoa(utils.TerminalWidth()), tmpFile.Name())
+	} else {
+		// We use 'bat' with flags for a clean, non-interactive output.
+		args := []string{"--paging=never", "--style=plain", "--language=markdown"}
+		switch {
+		case !utils.ColorEnabled():
+			args = append(args, "--color=never")
+		case usePager:
+			// bat only colors terminals; we page its output ourselves.
+			args = append(args, "--color=always")
+		}
+		if theme := utils.BatTheme(); theme != "" {
+			args = append(args, "--theme="+theme)
+		}
+		cmd = exec.Command("bat", append(args, tmpFile.Name())...)
+	}
 	// ------------------------------------------
 
-	cmd.Stdout = os.Stdout
 	cmd.Stderr = os.Stderr
+	if !usePager {
+		cmd.Stdout = os.Stdout
+		if err := cmd.Run(); err != nil {
+			return err
+		}
+		fmt.Print(formatSources(sources))
+		return nil
+	}
 
-	return cmd.Run()
+	rendered, err := cmd.Output()
+	if err != nil {
+		return err
+	}
+	return utils.Page(string(rendered) + formatSources(sources))
 }
 
-func setupSignalHandler(shared *flyt.SharedStore) {
-	// Create a channel to receive OS signals.
-	sigChan := make(chan os.Signal, 1)
+// sourcesMarker separates an answer from the citations appended by utils.CallLLMWithSearch.
+const sourcesMarker = "\n\n---\n**Sources:**\n"
 
-	// Tell the OS to notify our channel when an interrupt (Ctrl+C) or terminate signal occurs.
-	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
+func formatSources(sources string) string {
+	if sources == "" {
+		return ""
+	}
+	return "\n" + utils.Paint(utils.StyleCitation, "Sources:\n"+sources)
+}
 
-	// Start a new goroutine. This will run in the background without blocking the main chat loop.
-	go func() {
-		// This line will block until a signal is received on the channel.
-		<-sigChan
+// suggestFollowUps runs the follow-up flow and prints its suggestions as /N shortcuts.
+func suggestFollowUps(ctx context.Context, shared *flyt.SharedStore) {
+	shared.Set("follow_ups", []string(nil))
+	if err := CreateFollowUpFlow().Run(ctx, shared); err != nil {
+		log.Printf("Could not suggest follow-ups: %v", err)
+		return
+	}
+	followUps, _ := shared.Get("follow_ups")
+	list, _ := followUps.([]string)
+	if len(list) == 0 {
+		return
+	}
+	fmt.Println("\n💡 Follow-ups:")
+	for i, q := range list {
+		fmt.Printf("   /%d %s\n", i+1, q)
+	}
+}
+
+// pickFollowUp resolves "/N" to the Nth suggested follow-up question.
+func pickFollowUp(shared *flyt.SharedStore, input string) (string, bool) {
+	n, err := strconv.Atoi(strings.TrimPrefix(input, "/"))
+	if !strings.HasPrefix(input, "/") || err != nil {
+		return "", false
+	}
+	followUps, _ := shared.Get("follow_ups")
+	list, _ := followUps.([]string)
+	if n < 1 || n > len(list) {
+		return "", false
+	}
+	return list[n-1], true
+}
+
+// markTurn handles "/pin [N]", "/unpin [N]", "/mute [N]" and "/unmute [N]" on
+// history turn N (default: the last turn). "/pin list" shows pinned and muted turns.
+func markTurn(shared *flyt.SharedStore, cmd, arg string) {
+	h := utils.GetHistory(shared)
+	if len(h.Conversations) == 0 {
+		fmt.Println("No turns yet.")
+		return
+	}
+	if arg == "list" {
+		for i, c := range h.Conversations {
+			switch {
+			case c.Pinned:
+				fmt.Printf("📌 %d: %s\n", i+1, TruncateString(c.User, 60))
+			case c.Muted:
+				fmt.Printf("🔇 %d: %s\n", i+1, TruncateString(c.User, 60))
+			}
+		}
+		return
+	}
+
+	n := len(h.Conversations)
+	if arg != "" {
+		var err error
+		if n, err = strconv.Atoi(arg); err != nil || n < 1 || n > len(h.Conversations) {
+			fmt.Printf("Usage: %s [N] or /pin list  (N is 1-%d)\n", cmd, len(h.Conversations))
+			return
+		}
+	}
+	turn := &h.Conversations[n-1]
+	switch cmd {
+	case "/pin":
+		turn.Pinned = true
+		fmt.Printf("📌 Pinned turn %d: %s\n", n, TruncateString(turn.User, 60))
+	case "/unpin":
+		turn.Pinned = false
+		fmt.Printf("Unpinned turn %d.\n", n)
+	case "/mute":
+		turn.Muted = true
+		fmt.Printf("🔇 Turn %d will no longer be sent to the model: %s\n", n, TruncateString(turn.User, 60))
+	case "/unmute":
+		turn.Muted = false
+		fmt.Printf("Turn %d will be sent again.\n", n)
+	}
+	saveHistory(shared, h)
+}
+
+// continueAnswer handles "/continue": it asks the model to finish the last
+// answer where it was cut off and appends the rest to that turn.
+func continueAnswer(ctx context.Context, shared *flyt.SharedStore) {
+	h := utils.GetHistory(shared)
+	if len(h.Conversations) == 0 || !h.Conversations[len(h.Conversations)-1].Truncated {
+		fmt.Println("The last answer is complete; there is nothing to continue.")
+		return
+	}
+	turn := &h.Conversations[len(h.Conversations)-1]
+	partial := fmt.Sprint(turn.AI)
+	context, _ := shared.Get("context")
+	contextText, _ := context.(string)
+	config := utils.NodeConfig("answer")
+	config.Model = turnModel(shared)
+	config.History = h.ForPrompt()
+	config.System = contextText
+	prompt, err := utils.BuildPrompt(utils.PromptParts{
+		Question: fmt.Sprintf("Your last answer (to %q) was cut off. Continue it exactly where it stopped, without repeating anything or adding a preamble.", turn.User),
+	}, config.Model)
+	if err != nil {
+		utils.PrintWarning("⚠️  %v", err)
+		return
+	}
+
+	var rest any
+	if emit, _ := shared.Get("stream_events"); emit != nil {
+		rest, err = streamAnswer(ctx, prompt, config, emit.(utils.StreamHandler))
+	} else if rest, err = utils.CallLLMWithConfig(ctx, prompt, config, false); err == nil {
+		fmt.Println("\n" + utils.Paint(utils.StyleAI, "✅ Answer:"))
+		if err := displayAnswer(rest.(string)); err != nil {
+			fmt.Println(rest)
+		}
+	}
+	if err != nil {
+		utils.PrintWarning("⚠️  Could not continue the answer: %v", err)
+		return
+	}
+	_, truncated := rest.(truncatedAnswer)
+	turn.AI = partial + fmt.Sprint(rest)
+	turn.Truncated = truncated
+	saveHistory(shared, h)
+	shared.Set("answer", turn.AI)
+	if truncated {
+		utils.PrintWarning("⚠️  The answer was cut off again; /continue picks up where it stopped.")
+	}
+	if _, err := saveConversation(shared); err != nil {
+		utils.PrintWarning("⚠️  Could not save the conversation: %v", err)
+	}
+}
 
-		// Once the signal is caught, we start the shutdown procedure.
-		fmt.Println("\n🤖 Interrupt signal received. Saving conversation...")
-		history := utils.GetHistory(shared)
+// showProvenance handles "/why [N]": it lists what was put in the prompt of the
+// last answer, or shows item N in full.
+func showProvenance(shared *flyt.SharedStore, arg string) {
+	value, _ := shared.Get("provenance")
+	items, _ := value.([]provenanceItem)
+	if len(items) == 0 {
+		fmt.Println("No retrieved passages, search results or tool output were used for the last answer.")
+		return
+	}
+	if arg != "" {
+		n, err := strconv.Atoi(arg)
+		if err != nil || n < 1 || n > len(items) {
+			fmt.Printf("Usage: /why [N]  (N is 1-%d)\n", len(items))
+			return
+		}
+		item := items[n-1]
+		fmt.Printf("%d. [%s] %s %s\n\n%s\n", n, item.Kind, item.Title, item.Ref, item.Text)
+		return
+	}
+	fmt.Println("Included in the prompt of the last answer:")
+	for i, item := range items {
+		line := fmt.Sprintf("%d. [%s] %s", i+1, item.Kind, item.Title)
+		if item.Ref != "" {
+			line += " — " + item.Ref
+		}
+		if item.Score > 0 {
+			line += fmt.Sprintf(" (score %.2f)", item.Score)
+		}
+		fmt.Println(line)
+		if item.Text != "" {
+			preview := strings.Join(strings.Fields(item.Text), " ")
+			fmt.Println("   " + utils.Paint(utils.StyleCitation, TruncateString(preview, 160)))
+		}
+	}
+}
 
-		// If there's nothing to save, just exit.
-		if len(history.Conversations) == 0 {
-			fmt.Println("No conversation to save. Exiting.")
-			os.Exit(0)
+// exportTable handles "/table N csv [file]": it prints table N of the last
+// answer as CSV, or writes it to file. With no arguments it lists the tables.
+func exportTable(shared *flyt.SharedStore, args []string) {
+	answer, _ := shared.Get("answer")
+	text, _ := answer.(string)
+	tables := utils.FindMarkdownTables(text)
+	if len(tables) == 0 {
+		fmt.Println("The last answer has no tables.")
+		return
+	}
+	if len(args) == 0 {
+		for i, t := range tables {
+			fmt.Printf("%d: %s (%d rows)\n", i+1, strings.Join(t.Header, ", "), len(t.Rows))
 		}
+		return
+	}
 
-		// Marshal the history struct into a nicely formatted JSON.
-		jsonData, err := json.MarshalIndent(history, "", "  ")
+	n, err := strconv.Atoi(args[0])
+	if err != nil || n < 1 || n > len(tables) || len(args) < 2 || args[1] != "csv" {
+		fmt.Printf("Usage: /table N csv [file]  (N is 1-%d)\n", len(tables))
+		return
+	}
+	csv := tables[n-1].CSV()
+	if len(args) < 3 {
+		fmt.Print(csv)
+		return
+	}
+	if err := utils.CheckWritable("writing " + args[2]); err != nil {
+		utils.PrintWarning("⚠️  %v", err)
+		return
+	}
+	if err := os.WriteFile(args[2], []byte(csv), 0644); err != nil {
+		utils.PrintWarning("⚠️  Could not write %s: %v", args[2], err)
+		return
+	}
+	fmt.Printf("💾 Wrote table %d to %s.\n", n, args[2])
+}
+
+// copyToClipboard copies the answer, or only its first code block, to the system clipboard.
+func copyToClipboard(answer string, codeOnly bool) {
+	text, what := answer, "answer"
+	if codeOnly {
+		text, what = utils.ExtractCodeBlock(answer), "code block"
+	}
+	if err := utils.CopyToClipboard(text); err != nil {
+		utils.PrintWarning("⚠️  Could not copy to clipboard: %v", err)
+		return
+	}
+	fmt.Printf("📋 Copied %s to clipboard.\n", what)
+}
+
+// checkTopicChange offers to save and clear the conversation, or to summarize it,
+// when question looks unrelated to the recent turns.
+func checkTopicChange(ctx context.Context, reader *bufio.Reader, shared *flyt.SharedStore, question string) {
+	h := utils.GetHistory(shared)
+	if len(h.ForPrompt()) == 0 {
+		return
+	}
+	similarity, err := utils.TopicSimilarity(ctx, h.ForPrompt(), question)
+	if err != nil {
+		log.Printf("Could not check for a topic change: %v", err)
+		return
+	}
+	if similarity >= utils.TopicChangeThreshold {
+		return
+	}
+
+	fmt.Print(utils.Paint(utils.StyleWarning, fmt.Sprintf("🔀 This looks like a new topic (similarity %.2f). [n]ew conversation, [s]ummarize and continue, or [k]eep everything? [k]: ", similarity)))
+	choice, err := reader.ReadString('\n')
+	if err != nil {
+		return
+	}
+	switch strings.ToLower(strings.TrimSpace(choice)) {
+	case "n", "new":
+		fileName, err := saveConversation(shared)
 		if err != nil {
-			log.Printf("Error marshalling history to JSON: %v", err)
-			os.Exit(1) // Exit with an error code
+			utils.PrintWarning("⚠️  Could not save the current conversation, keeping it: %v", err)
+			return
+		}
+		fmt.Printf("✅ Previous conversation saved to %s\n", fileName)
+		saveHistory(shared, utils.History{})
+		ConversationName, ConversationFile = "", ""
+	case "s", "summarize":
+		if err := CreateSummarizeFlow().Run(ctx, shared); err != nil {
+			utils.PrintWarning("⚠️  Could not summarize, keeping the full history: %v", err)
+		}
+	}
+}
+
+// promptComponents estimates the size of each part of the prompt that the next
+// question would send. question may be empty to inspect only the pending context.
+func promptComponents(shared *flyt.SharedStore, question string) []utils.PromptComponent {
+	components := []utils.PromptComponent{{Name: "system", Tokens: utils.SystemInstructionsTokens()}}
+	if c, ok := shared.Get("context"); ok {
+		components = append(components, utils.PromptComponent{Name: "context", Tokens: utils.CountTokens(fmt.Sprint(c))})
+	}
+	components = append(components, utils.PromptComponent{Name: "history", Tokens: utils.CountTokens(utils.FormatHistory(utils.GetHistory(shared).ForPrompt()))})
+
+	attachmentTokens := 0
+	if v, ok := shared.Get("image_paths"); ok {
+		paths, _ := v.([]string)
+		for _, path := range paths {
+			tokens, err := utils.EstimateAttachmentTokens(path)
+			if err != nil {
+				log.Printf("Could not size attachment %s: %v", path, err)
+				continue
+			}
+			attachmentTokens += tokens
+		}
+	}
+	return append(components,
+		utils.PromptComponent{Name: "attachments", Tokens: attachmentTokens},
+		utils.PromptComponent{Name: "question", Tokens: utils.CountTokens(question)},
+	)
+}
+
+// fetchEmails reads the newest matching messages of a mailbox without changing their flags.
+func fetchEmails(mailbox string, days int, query string, limit int) ([]utils.Email, error) {
+	config, err := utils.IMAPConfigFromEnv()
+	if err != nil {
+		return nil, err
+	}
+	client, err := utils.DialIMAP(config)
+	if err != nil {
+		return nil, err
+	}
+	defer client.Close()
+
+	if err := client.Examine(mailbox); err != nil {
+		return nil, err
+	}
+	uids, err := client.SearchRecent(days, query)
+	if err != nil {
+		return nil, err
+	}
+	if len(uids) > limit {
+		uids = uids[len(uids)-limit:]
+	}
+	emails, err := client.Fetch(uids)
+	if err != nil {
+		return nil, err
+	}
+	for i, e := range emails {
+		fmt.Printf("  %2d. %s — %s\n", i+1, TruncateString(e.From, 30), TruncateString(e.Subject, 60))
+	}
+	return emails, nil
+}
+
+// parseFromCommand reads "/from ns[,ns...] [question]" ("/from all" clears the
+// filter) and checks the namespaces against the loaded knowledge base.
+func parseFromCommand(shared *flyt.SharedStore, arg string) ([]string, string, error) {
+	value, _ := shared.Get("kb_index")
+	index, _ := value.(*utils.KBIndex)
+	if index == nil {
+		return nil, "", fmt.Errorf("/from filters knowledge-base search; start with -kb")
+	}
+	list, question, _ := strings.Cut(strings.TrimSpace(arg), " ")
+	if list == "" {
+		return nil, "", fmt.Errorf("usage: /from namespace[,namespace...] [question], or /from all")
+	}
+	if list == "all" {
+		return nil, strings.TrimSpace(question), nil
+	}
+	known := index.Namespaces()
+	var scope []string
+	for _, ns := range strings.Split(list, ",") {
+		if _, ok := known[ns]; !ok {
+			names := make([]string, 0, len(known))
+			for name := range known {
+				names = append(names, name)
+			}
+			sort.Strings(names)
+			return nil, "", fmt.Errorf("unknown namespace %q (known: %s)", ns, strings.Join(names, ", "))
 		}
+		scope = append(scope, ns)
+	}
+	return scope, strings.TrimSpace(question), nil
+}
+
+// draftTicket handles "/ticket [jira|linear] [description]": it drafts a
+// ticket from the description or the conversation, prints it in the
+// tracker's layout, and files it only after confirmation.
+func draftTicket(ctx context.Context, reader *bufio.Reader, shared *flyt.SharedStore, arg string) {
+	tracker, request := "markdown", arg
+	if first, rest, _ := strings.Cut(arg, " "); first == "jira" || first == "linear" {
+		tracker, request = first, rest
+	}
+	shared.Set("ticket_request", request)
+	if err := CreateTicketFlow().Run(ctx, shared); err != nil {
+		utils.PrintWarning("Could not draft a ticket: %v", err)
+		return
+	}
+	value, _ := shared.Get("ticket")
+	ticket := value.(utils.Ticket)
+	description, err := utils.RenderTicket(ticket, tracker)
+	if err != nil {
+		utils.PrintWarning("%v", err)
+		return
+	}
+	fmt.Printf("\n%s\n\n%s\n\n", utils.Paint(utils.StyleAI, ticket.Title), description)
+
+	if tracker == "markdown" {
+		return
+	}
+	if !utils.TicketTrackerConfigured(tracker) {
+		fmt.Printf("Set the %s credentials (see README) to file tickets directly.\n", tracker)
+		return
+	}
+	fmt.Print(utils.Paint(utils.StyleWarning, fmt.Sprintf("Create this ticket in %s? [y/N]: ", tracker)))
+	answer, err := reader.ReadString('\n')
+	answer = strings.ToLower(strings.TrimSpace(answer))
+	if err != nil || (answer != "y" && answer != "yes") {
+		return
+	}
+	created, err := utils.CreateTicket(ticket, tracker, description)
+	if err != nil {
+		utils.PrintWarning("Could not create the ticket: %v", err)
+		return
+	}
+	fmt.Printf("🎫 Created %s\n", created)
+}
+
+// createCalendarEvent adds a proposed event to the calendar after explicit approval.
+func createCalendarEvent(reader *bufio.Reader, event *utils.CalendarEvent) {
+	source, _ := utils.CalendarFromEnv()
+	if !source.CanCreate() {
+		fmt.Println("📅 Your calendar is read-only here (CALENDAR_ICS); add the event yourself or set CALDAV_URL.")
+		return
+	}
+	if utils.ReadOnly {
+		fmt.Println("📅 Not creating the event with -readonly; add it yourself.")
+		return
+	}
+	fmt.Print(utils.Paint(utils.StyleWarning, fmt.Sprintf("Create %q on %s? [y/N]: ", event.Summary, event.Start.Local().Format("Mon 2006-01-02 15:04"))))
+	answer, err := reader.ReadString('\n')
+	answer = strings.ToLower(strings.TrimSpace(answer))
+	if err != nil || (answer != "y" && answer != "yes") {
+		return
+	}
+	if err := source.CreateEvent(*event); err != nil {
+		utils.PrintWarning("Could not create the event: %v", err)
+		return
+	}
+	fmt.Println("📅 Event created.")
+}
+
+// reviewSandbox asks whether to apply the sandboxed edits shown in the
+// answer to the workspace, all at once, or discard them.
+func reviewSandbox(reader *bufio.Reader, sandbox *utils.Sandbox) {
+	defer sandbox.Discard()
+	changed := sandbox.Changed()
+	if len(changed) == 0 {
+		return
+	}
+	if utils.ReadOnly {
+		fmt.Println("✏️  Not applying the changes with -readonly; they are discarded.")
+		return
+	}
+	fmt.Print(utils.Paint(utils.StyleWarning, fmt.Sprintf("Apply the changes to %s? [y/N]: ", strings.Join(changed, ", "))))
+	answer, err := reader.ReadString('\n')
+	answer = strings.ToLower(strings.TrimSpace(answer))
+	if err != nil || (answer != "y" && answer != "yes") {
+		fmt.Println("✏️  Changes discarded.")
+		return
+	}
+	if err := sandbox.Apply(); err != nil {
+		utils.PrintWarning("Could not apply the changes: %v", err)
+		return
+	}
+	fmt.Printf("✏️  Applied the changes to %d file(s).\n", len(changed))
+}
+
+// saveEmailDraft asks before writing a drafted reply to the IMAP drafts folder.
+func saveEmailDraft(reader *bufio.Reader, draft *emailDraft) {
+	config, err := utils.IMAPConfigFromEnv()
+	if err != nil || utils.ReadOnly {
+		return
+	}
+	fmt.Print(utils.Paint(utils.StyleWarning, fmt.Sprintf("Save this reply to %q in %s? [y/N]: ", draft.Email.Subject, config.Drafts)))
+	answer, err := reader.ReadString('\n')
+	answer = strings.ToLower(strings.TrimSpace(answer))
+	if err != nil || (answer != "y" && answer != "yes") {
+		return
+	}
 
-		// Ensure the Conversations directory exists.
-		dir := "Conversations"
-		err = os.MkdirAll(dir, 0755)
+	client, err := utils.DialIMAP(config)
+	if err != nil {
+		utils.PrintWarning("Could not save the draft: %v", err)
+		return
+	}
+	defer client.Close()
+	if err := client.AppendDraft(config.Drafts, utils.ComposeReply(draft.Email, config.User, draft.Body)); err != nil {
+		utils.PrintWarning("Could not save the draft: %v", err)
+		return
+	}
+	fmt.Printf("📝 Draft saved to %s\n", config.Drafts)
+}
+
+// applyIssueTriage writes the suggested labels and response of each issue
+// back to the tracker, asking for confirmation before every issue.
+func applyIssueTriage(reader *bufio.Reader, tracker utils.IssueTracker, triages []issueTriage) {
+	if err := utils.CheckWritable("-apply-triage"); err != nil {
+		utils.PrintWarning("⚠️  %v", err)
+		return
+	}
+	for _, t := range triages {
+		fmt.Printf("\n#%d %s\n  labels: %s\n  reply: %s\n", t.Issue.Number, t.Issue.Title, strings.Join(t.Labels, ", "), TruncateString(t.Response, 200))
+		fmt.Print(utils.Paint(utils.StyleWarning, "Apply to the issue? [y/N/q]: "))
+		answer, err := reader.ReadString('\n')
 		if err != nil {
-			log.Printf("Error creating directory %s: %v", dir, err)
-			os.Exit(1)
+			return
+		}
+		answer = strings.ToLower(strings.TrimSpace(answer))
+		if answer == "q" {
+			return
+		}
+		if answer != "y" && answer != "yes" {
+			continue
+		}
+		if len(t.Labels) > 0 {
+			if err := tracker.AddLabels(t.Issue.Number, t.Labels); err != nil {
+				utils.PrintWarning("Could not label #%d: %v", t.Issue.Number, err)
+			}
+		}
+		if strings.TrimSpace(t.Response) != "" {
+			if err := tracker.Comment(t.Issue.Number, t.Response); err != nil {
+				utils.PrintWarning("Could not comment on #%d: %v", t.Issue.Number, err)
+			}
 		}
+		fmt.Printf("✅ Updated %s\n", t.Issue.URL)
+	}
+}
+
+// confirmEstimatedCost prints the estimated size and cost of the pending request
+// and asks the user to confirm when it exceeds threshold USD.
+func confirmEstimatedCost(reader *bufio.Reader, shared *flyt.SharedStore, question string, threshold float64) bool {
+	var text strings.Builder
+	if c, ok := shared.Get("context"); ok {
+		text.WriteString(fmt.Sprintf("%v\n", c))
+	}
+	text.WriteString(utils.FormatHistory(utils.GetHistory(shared).ForPrompt()))
+	text.WriteString(question)
+
+	var attachments []string
+	if v, ok := shared.Get("image_paths"); ok {
+		attachments, _ = v.([]string)
+	}
+
+	estimate, err := utils.EstimatePromptCost(turnModel(shared), text.String(), attachments)
+	if err != nil {
+		log.Printf("Could not estimate request cost: %v", err)
+		return true
+	}
+	fmt.Printf("💰 Estimated %s\n", estimate)
+
+	if threshold <= 0 || !estimate.PricingKnown || estimate.Cost <= threshold {
+		return true
+	}
+
+	fmt.Print(utils.Paint(utils.StyleWarning, fmt.Sprintf("⚠️  This request exceeds the $%.2f cost warning threshold. Send anyway? [y/N]: ", threshold)))
+	answer, err := reader.ReadString('\n')
+	if err != nil {
+		return false
+	}
+	answer = strings.ToLower(strings.TrimSpace(answer))
+	return answer == "y" || answer == "yes"
+}
+
+// collectUsage adds the token usage the API reported since the last call to
+// the session totals in shared "usage" and returns it.
+func collectUsage(shared *flyt.SharedStore) utils.UsageByModel {
+	session, _ := shared.Get("usage")
+	totals, _ := session.(utils.UsageByModel)
+	if totals == nil {
+		totals = utils.UsageByModel{}
+		shared.Set("usage", totals)
+	}
+	usage := utils.TakeUsage()
+	totals.Add(usage)
+	return usage
+}
+
+// savedConversation is the JSON layout of a saved conversation. The history
+// fields stay at the top level, so files saved before Name and Context existed still load.
+type savedConversation struct {
+	Name    string `json:",omitempty"`
+	Context string `json:",omitempty"`
+	// Settings are absent from conversations saved before they were kept.
+	Settings *conversationSettings `json:",omitempty"`
+	// Recap is written by /quit summary (or -quit-summary).
+	Recap *sessionRecap `json:",omitempty"`
+	utils.History
+}
+
+// sessionRecap is the end-of-session summary of a conversation, shown again
+// when it is resumed.
+type sessionRecap struct {
+	Summary   string   `json:"summary"`
+	Decisions []string `json:"decisions"`
+	TODOs     []string `json:"todos"`
+	Date      string   `json:"date"`
+}
+
+// conversationSettings is the configuration a conversation runs with besides
+// its system prompt (Context), restored by -resume.
+type conversationSettings struct {
+	Model       string
+	Temperature float64
+	// Tools is the tool allowlist; null allows every tool.
+	Tools []string
+}
+
+// currentSettings are the settings of the conversation in shared.
+func currentSettings(shared *flyt.SharedStore) *conversationSettings {
+	tools, _ := shared.Get("tools")
+	allowed, _ := tools.([]string)
+	return &conversationSettings{Model: turnModel(shared), Temperature: utils.DefaultTemperature, Tools: allowed}
+}
+
+// applySettings restores saved settings, except those given on the command
+// line or in the environment, which win over the saved conversation.
+func applySettings(shared *flyt.SharedStore, saved *conversationSettings) {
+	if saved == nil {
+		return
+	}
+	if !explicitFlags["model"] && saved.Model != "" {
+		shared.Set("model", saved.Model)
+	}
+	if !explicitFlags["temperature"] {
+		utils.DefaultTemperature = saved.Temperature
+	}
+	if !explicitFlags["tools"] {
+		shared.Set("tools", saved.Tools)
+	}
+}
+
+// saveConversation saves the conversation to conversationStore: the first
+// time under a new <name>_<timestamp> key, afterwards (or after -resume) over
+// the same one. It returns the key, the file name with JSON storage.
+func saveConversation(shared *flyt.SharedStore) (string, error) {
+	context, _ := shared.Get("context")
+	saved := savedConversation{Name: ConversationName, Settings: currentSettings(shared), History: utils.GetHistory(shared)}
+	saved.Context, _ = context.(string)
+	recap, _ := shared.Get("session_recap")
+	saved.Recap, _ = recap.(*sessionRecap)
+
+	key, err := conversationStore.Save(ConversationFile, saved)
+	if err != nil {
+		return "", err
+	}
+	ConversationFile = key
+	if sealedCopy != "" {
+		os.Remove(sealedCopy)
+		sealedCopy = ""
+	}
+	return key, nil
+}
 
-		// Create a unique filename with a timestamp.
-		timestamp := time.Now().Format("2006-01-02_15-04-05")
-		baseName := timestamp
-		if ConversationName != "" {
-			// sanitize spaces for filename
-			baseName = strings.ReplaceAll(ConversationName, " ", "_") + "_" + timestamp
+// newConversationKey is <name>_<timestamp>, or only the timestamp for an unnamed conversation.
+func newConversationKey(name string) string {
+	timestamp := time.Now().Format("2006-01-02_15-04-05")
+	if name == "" {
+		return timestamp
+	}
+	// sanitize spaces for filename
+	return strings.ReplaceAll(name, " ", "_") + "_" + timestamp
+}
+
+// conversationExts are the extensions of saved conversations: plain JSON, and
+// the compressed or encrypted copies left by -idle-seal.
+var conversationExts = []string{".json", ".json" + utils.SealedExt, ".json" + utils.EncryptedExt}
+
+// plainConversationPath strips the sealed extension from path, if any.
+func plainConversationPath(path string) string {
+	for _, ext := range conversationExts[1:] {
+		if strings.HasSuffix(path, ext) {
+			return strings.TrimSuffix(path, ext) + ".json"
 		}
-		fileName := dir + string(os.PathSeparator) + baseName + ".json"
+	}
+	return path
+}
+
+// conversationTimestamp matches the timestamp newConversationKey appends to names.
+var conversationTimestamp = regexp.MustCompile(`_?(\d{4}-\d{2}-\d{2}_\d{2}-\d{2}-\d{2})$`)
+
+// conversationTimestampGlob matches exactly that timestamp in filepath.Glob
+// and SQLite GLOB patterns, so that "notes" does not find "notes_draft".
+const conversationTimestampGlob = "[0-9][0-9][0-9][0-9]-[0-9][0-9]-[0-9][0-9]_[0-9][0-9]-[0-9][0-9]-[0-9][0-9]"
+
+// resumeConversation loads a saved conversation into the shared store and
+// returns the key (file) it came from.
+func resumeConversation(shared *flyt.SharedStore, arg string) (string, error) {
+	path, err := conversationStore.Find(arg)
+	if err != nil {
+		return "", err
+	}
+	saved, err := conversationStore.Load(path)
+	if err != nil {
+		return "", err
+	}
 
-		// Write the JSON data to the file.
-		err = os.WriteFile(fileName, jsonData, 0644)
+	saveHistory(shared, saved.History)
+	if saved.Context != "" {
+		shared.Set("context", saved.Context)
+	}
+	applySettings(shared, saved.Settings)
+	shared.Set("session_recap", saved.Recap)
+	ConversationName = saved.Name
+	shared.Set("conversation_name", ConversationName)
+	// A sealed conversation is saved as plain JSON again, replacing the sealed copy.
+	ConversationFile = plainConversationPath(path)
+	if ConversationFile != path {
+		sealedCopy = path
+	}
+	return path, nil
+}
+
+// askOnce answers question, or the question on stdin when it is "-", with
+// flow for -q. Otherwise text piped on stdin is attached to the question.
+// The answer is rendered when out is a terminal and written as is otherwise,
+// or written as a oneShotResult with -output json; the result is the exit status.
+func askOnce(flow *flyt.Flow, shared *flyt.SharedStore, question string, out *os.File) int {
+	start := time.Now()
+	fail := func(code int, err error) int {
+		log.Printf("❌ %v", err)
+		if answerJSON {
+			writeOneShotJSON(out, oneShotResult{Error: err.Error(), Sources: []oneShotSource{}, LatencyMS: time.Since(start).Milliseconds()})
+		}
+		return code
+	}
+	if question == "-" {
+		data, err := io.ReadAll(os.Stdin)
 		if err != nil {
-			log.Printf("Error writing conversation to file: %v", err)
-			os.Exit(1)
+			return fail(exitFailed, fmt.Errorf("failed to read stdin: %w", err))
 		}
+		question = string(data)
+	} else if utils.IsPiped(os.Stdin) {
+		attached, err := readAttachedStdin(question)
+		if err != nil {
+			return fail(exitFailed, err)
+		}
+		question = attached
+	}
+	if question = strings.TrimSpace(question); question == "" {
+		return fail(exitUsage, fmt.Errorf("-q: empty question"))
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	shared.Set("question", question)
+	if err := flow.Run(ctx, shared); err != nil {
+		if ctx.Err() != nil {
+			return fail(exitInterrupted, ctx.Err())
+		}
+		return fail(exitFailed, err)
+	}
+	answer, _ := shared.Get("answer")
+	text, _ := answer.(string)
+	if strings.TrimSpace(text) == "" {
+		return fail(exitFailed, fmt.Errorf("the model returned an empty answer"))
+	}
+
+	if answerJSON {
+		writeOneShotJSON(out, newOneShotResult(shared, text, time.Since(start)))
+		return 0
+	}
+	os.Stdout = out
+	if utils.IsTerminal(out) {
+		if err := displayAnswer(text); err == nil {
+			return 0
+		}
+	}
+	fmt.Fprintln(out, text)
+	return 0
+}
+
+// oneShotResult is what -output json prints for a one-shot question.
+type oneShotResult struct {
+	Answer    string          `json:"answer,omitempty"`
+	Model     string          `json:"model,omitempty"`
+	Tokens    *oneShotTokens  `json:"tokens,omitempty"`
+	CostUSD   *float64        `json:"cost_usd,omitempty"`
+	Sources   []oneShotSource `json:"sources"`
+	LatencyMS int64           `json:"latency_ms"`
+	Error     string          `json:"error,omitempty"`
+}
+
+type oneShotTokens struct {
+	Calls  int `json:"calls"`
+	Input  int `json:"input"`
+	Output int `json:"output"`
+}
+
+// oneShotSource is a provenanceItem without its text.
+type oneShotSource struct {
+	Kind  string  `json:"kind"`
+	Title string  `json:"title,omitempty"`
+	Ref   string  `json:"ref,omitempty"`
+	Score float64 `json:"score,omitempty"`
+}
+
+// newOneShotResult collects the answer's model, usage and sources from shared.
+func newOneShotResult(shared *flyt.SharedStore, answer string, latency time.Duration) oneShotResult {
+	result := oneShotResult{Answer: answer, Model: turnModel(shared), LatencyMS: latency.Milliseconds(), Sources: []oneShotSource{}}
+	usage := collectUsage(shared)
+	total := usage.Total()
+	result.Tokens = &oneShotTokens{Calls: total.Calls, Input: total.PromptTokens, Output: total.OutputTokens}
+	if cost, known := usage.Cost(); known && total.Calls > 0 {
+		result.CostUSD = &cost
+	}
+	items, _ := shared.Get("provenance")
+	list, _ := items.([]provenanceItem)
+	for _, item := range list {
+		result.Sources = append(result.Sources, oneShotSource{Kind: item.Kind, Title: item.Title, Ref: item.Ref, Score: item.Score})
+	}
+	return result
+}
+
+// writeOneShotJSON prints result as one line of JSON.
+func writeOneShotJSON(out *os.File, result oneShotResult) {
+	if err := json.NewEncoder(out).Encode(result); err != nil {
+		log.Printf("❌ Failed to write JSON: %v", err)
+	}
+}
+
+// readAttachedStdin adds the text piped on stdin to question as a code
+// block; empty input leaves the question as it is.
+func readAttachedStdin(question string) (string, error) {
+	data, err := io.ReadAll(os.Stdin)
+	if err != nil {
+		return "", fmt.Errorf("failed to read stdin: %w", err)
+	}
+	if strings.TrimSpace(string(data)) == "" {
+		return question, nil
+	}
+	fmt.Fprintf(os.Stderr, "📎 Attached %d line(s) from stdin\n", strings.Count(strings.TrimRight(string(data), "\n"), "\n")+1)
+	return utils.AttachText(question, string(data)), nil
+}
+
+// currentTurn is the cancel function of the question being answered, nil
+// at the prompt, so the first Ctrl+C can stop the answer.
+var currentTurn struct {
+	sync.Mutex
+	cancel context.CancelFunc
+}
+
+// startTurn returns the context one question is answered under, and the
+// function that ends the turn.
+func startTurn(ctx context.Context) (context.Context, context.CancelFunc) {
+	turnCtx, cancel := context.WithCancel(ctx)
+	currentTurn.Lock()
+	currentTurn.cancel = cancel
+	currentTurn.Unlock()
+	return turnCtx, func() {
+		currentTurn.Lock()
+		currentTurn.cancel = nil
+		currentTurn.Unlock()
+		cancel()
+		// A stopped answer was kept by its node; nothing is in flight anymore.
+		inFlight.Lock()
+		inFlight.text.Reset()
+		inFlight.Unlock()
+	}
+}
+
+// stopTurn cancels the turn in progress and reports whether there was one.
+func stopTurn() bool {
+	currentTurn.Lock()
+	defer currentTurn.Unlock()
+	if currentTurn.cancel == nil {
+		return false
+	}
+	currentTurn.cancel()
+	currentTurn.cancel = nil
+	return true
+}
+
+// setupSignalHandler makes the first Ctrl+C stop the answer being generated
+// and return to the prompt. Ctrl+C at the prompt, a second one while the
+// answer is stopping, or SIGTERM saves the conversation and exits.
+func setupSignalHandler(shared *flyt.SharedStore) {
+	// Create a channel to receive OS signals.
+	sigChan := make(chan os.Signal, 1)
+
+	// Tell the OS to notify our channel when an interrupt (Ctrl+C) or terminate signal occurs.
+	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
 
-		fmt.Printf("✅ Conversation successfully saved to %s\n", fileName)
-		os.Exit(0) // Exit the program cleanly
+	// Start a new goroutine. This will run in the background without blocking the main chat loop.
+	go func() {
+		for sig := range sigChan {
+			if sig == syscall.SIGINT && stopTurn() {
+				fmt.Println("\n⏹️  Stopped. Press Ctrl+C again or type /quit to save and exit.")
+				continue
+			}
+			fmt.Println("\n🤖 Interrupt signal received. Saving conversation...")
+			saveAndExit(shared)
+		}
 	}()
 }
+
+// saveAndExit saves the conversation, with an answer still streaming kept as
+// truncated, and exits.
+func saveAndExit(shared *flyt.SharedStore) {
+	utils.RestoreTerminal()
+	history := utils.GetHistory(shared)
+	// Keep an answer that was still streaming, marked so /continue can finish it after -resume.
+	inFlight.Lock()
+	if inFlight.text.Len() > 0 {
+		question, _ := shared.Get("question")
+		q, _ := question.(string)
+		history.Conversations = append(history.Conversations, utils.Conversation{User: q, AI: inFlight.text.String(), Truncated: true})
+		saveHistory(shared, history)
+	}
+	inFlight.Unlock()
+
+	// If there's nothing to save, just exit.
+	if len(history.Conversations) == 0 {
+		fmt.Println("No conversation to save. Exiting.")
+		os.Exit(0)
+	}
+
+	fileName, err := saveConversation(shared)
+	if err != nil {
+		log.Printf("Error saving conversation: %v", err)
+		os.Exit(1) // Exit with an error code
+	}
+
+	fmt.Printf("✅ Conversation successfully saved to %s\n", fileName)
+	os.Exit(0) // Exit the program cleanly
+}
+
 func main() {
 	err := godotenv.Load()
-	if err != nil {
+	// One-shot subcommands bypass the interactive chat loop. They often run
+	// outside the project directory (git hooks, editors), so .env is optional for them.
+	if len(os.Args) > 1 {
+		if cmd, ok := subcommands[os.Args[1]]; ok {
+			if err := cmd.run(os.Args[2:]); err != nil {
+				log.Printf("❌ %s: %v", os.Args[1], err)
+				var coded exitCodeError
+				if errors.As(err, &coded) {
+					os.Exit(coded.code)
+				}
+				os.Exit(exitFailed)
+			}
+			return
+		}
+	}
+	// .env is optional: the variables may come from the environment itself.
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
 		log.Fatalf("Error loading .env file: %v", err)
 	}
 	// Define command line flags
 	var (
-		mode          = flag.String("mode", "qa", "Flow mode: qa, agent, or batch")
+		oneShot       = flag.String("q", "", "Answer this question (- reads it from stdin) in -mode qa or agent, print the answer to stdout and exit: 0 on success, 1 on failure, 2 for an empty question, 130 when interrupted")
+		output        = flag.String("output", "text", "Output of one-shot answers (-q or a question as arguments): text, or json with the answer, model, tokens, sources and latency")
+		mode          = flag.String("mode", "qa", "Flow mode: qa, agent, batch, data, logs, audio, email, pr-review, triage, security-review, or quiz")
 		verbose       = flag.Bool("v", false, "Enable verbose output")
 		model         = flag.String("model", "gemini-2.5-flash", "LLM model to use")
+		system        = flag.String("system", "", "System prompt for the conversation, or a file containing it (default: a helpful assistant)")
+		temperature   = flag.Float64("temperature", 0.7, "Sampling temperature of the LLM")
+		search        = flag.String("search", "gemini", "Web search for agent mode: gemini (Google Search grounding) or duckduckgo (results added to the prompt, works with any provider)")
+		saveDir       = flag.String("save-dir", conversationsDir, "Directory conversations are saved in")
+		renderer      = flag.String("renderer", "builtin", "How finished answers are displayed: builtin (rendered here), bat or glow (external programs), or plain (as-is)")
+		summarizeAt   = flag.Int("summarize-at", utils.SummarizeAt, "Once the history sent is larger than about this many tokens, summarize all but the last few turns with the model (0 never does)")
+		useRouter     = flag.Bool("router", false, "In qa mode, answer simple questions with -cheap-model and escalate long or code-heavy ones, or unsure cheap answers, to -model")
+		cheapModel    = flag.String("cheap-model", "", "The cheap model of -router (default "+utils.FollowUpModel+", or -model with -provider openai)")
+		historyTokens = flag.Int("history-tokens", utils.HistoryTokenBudget, "Send at most about this many tokens of conversation history, leaving out the oldest unpinned turns first (0 sends it all)")
+		provider      = flag.String("provider", "gemini", "LLM backend: gemini, or openai for any OpenAI-compatible /chat/completions API (key in OPENAI_API_KEY)")
+		baseURL       = flag.String("base-url", "", "API base URL for -provider openai (default "+utils.DefaultOpenAIBaseURL+"), e.g. https://api.groq.com/openai/v1")
+		resume        = flag.String("resume", "", "Continue a saved conversation: a file, or a name in the Conversations directory (the newest with that name)")
+		idleSave      = flag.Duration("idle-save", 0, "Save the conversation and clear the screen after this long without input, or when the screen locks (0 disables)")
+		idleSeal      = flag.String("idle-seal", "", "With -idle-save, leave only a gzip or encrypt (AES, key in CONVERSATION_KEY) copy of the conversation on disk until you return")
+		choice        = flag.String("tool-choice", "rules", "Who picks agent mode's tools: rules (fixed checks on the question), model (the model calls the registered tools, such as web_search, find_symbol, calculator, read_file and run_shell, itself by function calling) or react (the model reasons step by step, choosing to search, run a shell command you confirm, ask you, answer or finish, with each step shown)")
+		maxSteps      = flag.Int("max-steps", 6, "Most reason-act steps of -tool-choice react before the model must answer")
+		tools         = flag.String("tools", "all", "Tools the flows may use instead of a plain answer: all, none, or a comma-separated list of "+strings.Join(toolChoices(), ", "))
+		readOnly      = flag.Bool("readonly", false, readOnlyUsage)
+		unsandboxed   = flag.Bool("unsandboxed-shell", false, "Let the run_shell tool run confirmed commands without bubblewrap (bwrap), in a copy of the workspace but with access to your files and network")
+		injection     = flag.String("injection", "annotate", "What agent mode does with search results, documents, transcripts and tool output that look like prompt injection: annotate (mark them as untrusted data), quarantine (remove the suspicious lines) or off")
+		threads       = flag.Bool("threads", false, "Keep each conversation in a provider-side thread instead of resending the history every turn (qa mode; needs -provider openai and its Responses API)")
 		imagePathsStr = flag.String("images", "", "Comma-separated list of image paths")
+		batchFile     = flag.String("batch-file", "", "File with one prompt per line to answer in batch mode")
+		batchAPI      = flag.Bool("batch-api", false, "In batch mode, submit all prompts as one asynchronous Gemini batch job at the discounted batch rate")
+		retries       = flag.Int("retries", utils.MaxRetries, "Retry LLM and search requests this many times, with jittered exponential backoff, when rate limited (429) or on a transient server error (0 disables)")
+		rpm           = flag.Int("rpm", 0, "Requests per minute allowed by your API quota; interactive requests are served first (0 means unlimited)")
+		bgConcurrency = flag.Int("background-concurrency", 2, "Maximum concurrent background (batch) LLM requests")
+		dataPath      = flag.String("data", "", "CSV, TSV or XLSX file to query in data mode")
+		logPath       = flag.String("log", "", "Log file to analyze in logs mode")
+		logConfirm    = flag.Int("log-confirm-chunks", 25, "In logs mode, ask before analyzing more than this many chunks not analyzed before (0 never asks)")
+		audioPath     = flag.String("audio", "", "Recording (any format ffmpeg reads) to transcribe and summarize in audio mode")
+		transcriber   = flag.String("transcriber", "gemini", "Speech-to-text for audio mode: gemini or whisper (local openai-whisper CLI)")
+		mailbox       = flag.String("mailbox", "INBOX", "IMAP mailbox to read in email mode")
+		mailDays      = flag.Int("mail-days", 7, "In email mode, fetch messages from the last this many days")
+		mailQuery     = flag.String("mail-query", "", "In email mode, only fetch messages containing this text")
+		mailLimit     = flag.Int("mail-limit", 30, "In email mode, fetch at most this many of the newest matching messages")
+		prRepo        = flag.String("repo", "", "Repository (owner/name) to work on in pr-review and triage modes")
+		prNumber      = flag.Int("pr", 0, "Pull request number to review in pr-review mode")
+		postReview    = flag.Bool("post-review", false, "In pr-review mode, post the comments as a GitHub review instead of only printing them")
+		forge         = flag.String("forge", "github", "Where the -repo issues live in triage mode: github or gitlab")
+		issueLimit    = flag.Int("issue-limit", 50, "Maximum number of open issues to triage")
+		applyTriage   = flag.Bool("apply-triage", false, "In triage mode, offer to add the suggested labels and responses to each issue, asking before every write")
+		sarifPath     = flag.String("sarif", "", "In security-review mode, also write the findings to this file as SARIF")
+		quizCount     = flag.Int("questions", 5, "In quiz mode, how many questions to ask (0 asks until you quit)")
+		maxImageDim   = flag.Int("max-image-dim", 2048, "Downscale attached images so their longest side is at most this many pixels (0 disables)")
+		copyAnswer    = flag.Bool("copy", false, "Copy each final answer to the clipboard")
+		copyCode      = flag.Bool("copy-code", false, "Copy the first code block of each answer to the clipboard")
+		costWarn      = flag.Float64("cost-warn", 0.05, "Ask for confirmation when a request is estimated to cost more than this many USD (0 disables)")
+		topicDetect   = flag.Bool("topic-detect", false, "Compare each question with the recent conversation (via embeddings) and offer a fresh start when the topic changes")
+		suggest       = flag.Bool("suggest", false, "Suggest follow-up questions after each answer, selectable with /1, /2, /3")
+		theme         = flag.String("theme", "dark", "Color theme for terminal output: dark, light, or none (NO_COLOR is also honored)")
+		noColor       = flag.Bool("no-color", false, "Print without colors, the same as -theme none")
+		width         = flag.Int("width", 0, "Wrap rendered answers and tables to this many columns (0 uses the terminal width)")
+		recapOnQuit   = flag.Bool("quit-summary", false, "On /quit, first summarize the session (what was discussed and decided, and the open TODOs), print it and save it with the conversation")
+		useEditor     = flag.Bool("editor", false, "Write every question in $VISUAL or $EDITOR instead of at the prompt; saving an empty file leaves the chat")
+		noStream      = flag.Bool("no-stream", false, "In qa mode, wait for the whole answer and render it instead of printing it as it is generated")
+		noPager       = flag.Bool("no-pager", false, "Print long answers straight to the terminal instead of through $PAGER or less")
+		maxKBMemory   = utils.ByteSize(1 << 30)
+		useKB         = flag.Bool("kb", false, "Answer from the knowledge-base index built by the kb subcommand when it has relevant passages")
+		noLaTeX       = flag.Bool("raw-latex", false, "Print LaTeX math in answers as-is instead of rendering it to Unicode")
+		noProject     = flag.Bool("no-project-context", false, "Do not load AI.md or .ai_context from the current directory")
 	)
-	// Parse flags first, then set package-level default model in utils so other packages use the selected model
-	flag.Parse()
+	flag.Var(&maxKBMemory, "max-kb-memory", "Most memory the knowledge base loaded by -kb may hold (e.g. 512MiB, 0 for no limit); the least recently synced namespaces are left out beyond it")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage of %s:\n", os.Args[0])
+		flag.PrintDefaults()
+		printSubcommandUsage()
+	}
+	// Parse flags first, then set package-level default model in utils so other packages use the selected model.
+	// The config file and environment fill in or override some of them (see settings).
+	if err := parseWithSettings(flag.CommandLine, os.Args[1:]); err != nil {
+		log.Fatalf("❌ %v", err)
+	}
+	switch *provider {
+	case "gemini":
+	case "openai":
+		if *batchAPI {
+			log.Fatal("❌ -batch-api submits Gemini batch jobs and needs -provider gemini")
+		}
+		utils.DefaultProvider = utils.NewOpenAIProvider(*baseURL)
+		modelSet := false
+		flag.Visit(func(f *flag.Flag) { modelSet = modelSet || f.Name == "model" })
+		if !modelSet {
+			*model = "gpt-4o-mini"
+		}
+		// The cheap helper models default to Gemini ones; use the chosen model instead.
+		utils.FollowUpModel, utils.OCRModel = *model, *model
+	default:
+		log.Fatalf("❌ Unknown provider %q (use gemini or openai)", *provider)
+	}
 	utils.DefaultModel = *model
+	utils.DefaultTemperature = *temperature
+	utils.HistoryTokenBudget = *historyTokens
+	utils.SummarizeAt = *summarizeAt
+	utils.MaxRetries = max(*retries, 0)
+	utils.ReadOnly = *readOnly
+	utils.AllowUnsandboxedShell = *unsandboxed
+	if *readOnly {
+		utils.PrintStatus("🔒 Read-only: file writes, shell commands and changes to trackers, calendars and mail are disabled.")
+	}
+	switch *injection {
+	case "annotate", "quarantine", "off":
+		utils.InjectionPolicy = *injection
+	default:
+		log.Fatalf("❌ Unknown injection policy %q (use annotate, quarantine or off)", *injection)
+	}
+	switch *search {
+	case "gemini", "duckduckgo":
+		searchProvider = *search
+	default:
+		log.Fatalf("❌ Unknown search provider %q (use gemini or duckduckgo)", *search)
+	}
+	switch *choice {
+	case "rules", "model", "react":
+		toolChoice = *choice
+	default:
+		log.Fatalf("❌ Unknown tool choice %q (use rules, model or react)", *choice)
+	}
+	if *maxSteps < 1 {
+		log.Fatalf("❌ -max-steps must be at least 1")
+	}
+	maxReActSteps = *maxSteps
+	switch *renderer {
+	case "builtin", "bat", "glow", "plain":
+		answerRenderer = *renderer
+	default:
+		log.Fatalf("❌ Unknown renderer %q (use builtin, bat, glow or plain)", *renderer)
+	}
+	conversationsDir = *saveDir
+	if conversationStore, err = openStorage(); err != nil {
+		log.Fatalf("❌ %v", err)
+	}
+	utils.MaxImageDimension = *maxImageDim
+	utils.DefaultScheduler = utils.NewScheduler(*rpm, *bgConcurrency)
+	rawLaTeX = *noLaTeX
+	if *noColor {
+		*theme = "none"
+	}
+	if err := utils.SetTheme(*theme); err != nil {
+		log.Fatalf("❌ %v", err)
+	}
+	utils.OutputWidth = max(*width, 0)
+	usePager = !*noPager
+	quitSummary = *recapOnQuit
+	// `ai "explain this"` asks one question, as -q does.
+	if *oneShot == "" && flag.NArg() > 0 && (*mode == "qa" || *mode == "agent") {
+		*oneShot = strings.Join(flag.Args(), " ")
+	}
+	if *oneShot != "" && *mode != "qa" && *mode != "agent" {
+		log.Fatalf("❌ -q works in -mode qa or agent, not %s", *mode)
+	}
+	switch *output {
+	case "text":
+	case "json":
+		if *oneShot == "" {
+			log.Fatalf("❌ -output json needs a one-shot question (-q or a question as arguments)")
+		}
+		answerJSON = true
+	default:
+		log.Fatalf("❌ unknown -output %q (use text or json)", *output)
+	}
+	// With -q only the answer goes to stdout; status lines go to stderr.
+	answerOut := os.Stdout
+	if *oneShot != "" {
+		os.Stdout = os.Stderr
+	}
+	if !*noProject {
+		path, err := utils.LoadProjectContext(".")
+		if err != nil {
+			log.Fatalf("❌ %v", err)
+		}
+		if path != "" {
+			utils.PrintStatus("📄 Using project instructions from %s", path)
+		}
+	}
+	if err := utils.LoadPersonas(utils.PersonasPath()); err != nil {
+		log.Fatalf("❌ %v", err)
+	}
+	if len(utils.Personas) > 0 {
+		utils.PrintStatus("🎭 Personas for %d node(s) from %s", len(utils.Personas), utils.PersonasPath())
+	}
 	log.Printf("Setting default LLM model to: %s", utils.DefaultModel)
 
 	// Check for required environment variables
-	if os.Getenv("GEMINI_API_KEY") == "" {
-		log.Println("Warning: GEMINI_API_KEY not set. Some features may not work.")
+	if *provider == "gemini" && os.Getenv("GEMINI_API_KEY") == "" {
+		log.Println("Warning: GEMINI_API_KEY is not set in the environment or .env. Some features may not work.")
 	}
 
 	// Create shared store
@@ -170,16 +1344,78 @@ func main() {
 	var history utils.History
 	// Store the full History struct (not just the slice) for easier retrieval
 	shared.Set("history", history)
-	setupSignalHandler(shared)
+	if *oneShot == "" {
+		setupSignalHandler(shared)
+	}
+	if *threads {
+		if !utils.ProviderSupportsThreads() {
+			log.Fatalf("❌ -threads needs a provider with server-side threads (-provider openai)")
+		}
+		shared.Set("use_threads", true)
+	}
 
-	shared.Set("context", " you are a helpful assistant. ")
+	shared.Set("context", defaultSystemPrompt)
+	allowed, err := parseTools(*tools)
+	if err != nil {
+		log.Fatalf("❌ -tools: %v", err)
+	}
+	shared.Set("tools", allowed)
+	if *mode == "qa" && !*noStream && *oneShot == "" {
+		shared.Set("stream_events", terminalStream())
+	}
+	if *resume != "" {
+		path, err := resumeConversation(shared, *resume)
+		if err != nil {
+			log.Fatalf("❌ Could not resume: %v", err)
+		}
+		fmt.Printf("📂 Resumed %s (%d turns)\n", path, len(utils.GetHistory(shared).Conversations))
+		if recap, _ := shared.Get("session_recap"); recap != nil && recap.(*sessionRecap) != nil {
+			printRecap(recap.(*sessionRecap))
+		}
+	}
+	if *system != "" {
+		shared.Set("context", readSystemPrompt(*system))
+	}
 	var initialImagePaths []string
 	if *imagePathsStr != "" {
 		// Split the comma-separated string into a slice of paths
 		initialImagePaths = strings.Split(*imagePathsStr, ",")
-		fmt.Printf("🖼️ Loaded %d image(s) from command line.\n", len(initialImagePaths))
+		attachments, err := utils.ValidateAttachments(initialImagePaths)
+		if err != nil {
+			log.Fatalf("❌ Invalid attachment: %v", err)
+		}
+		fmt.Printf("🖼️ Loaded %d image(s) from command line:\n", len(attachments))
+		for _, a := range attachments {
+			fmt.Printf("   • %s\n", a)
+		}
 	}
 	shared.Set("image_paths", initialImagePaths) // Set it once at the start
+	if *useRouter {
+		if *cheapModel == "" {
+			*cheapModel = utils.FollowUpModel
+		}
+		shared.Set("router", &utils.ModelRouter{Cheap: *cheapModel})
+		utils.PrintStatus("🧭 Routing simple questions to %s", *cheapModel)
+	}
+	if *useKB {
+		index, err := utils.LoadKBIndex(utils.DefaultKBIndexPath())
+		if err != nil {
+			log.Fatalf("❌ %v", err)
+		}
+		dropped := index.FitMemory(int64(maxKBMemory))
+		if len(dropped) > 0 {
+			utils.PrintWarning("⚠️  The knowledge base is larger than -max-kb-memory %s; left out the least recently synced namespace(s): %s", maxKBMemory, strings.Join(dropped, ", "))
+		}
+		utils.RegisterMemoryPool("knowledge base", func() utils.MemoryStat {
+			return utils.MemoryStat{Entries: len(index.Chunks), Bytes: index.MemoryBytes(), Limit: int64(maxKBMemory), Evictions: len(dropped)}
+		})
+		shared.Set("kb_index", index)
+		utils.PrintStatus("📚 Knowledge base: %d chunk(s)", len(index.Chunks))
+	}
+	utils.RegisterMemoryPool("history", func() utils.MemoryStat {
+		h := utils.GetHistory(shared)
+		return utils.MemoryStat{Entries: len(h.Conversations), Bytes: utils.HistoryBytes(h)}
+	})
 
 	// Create context
 	ctx := context.Background()
@@ -189,20 +1425,156 @@ func main() {
 
 	switch *mode {
 	case "qa":
-		fmt.Println("🤖 Starting Q&A Flow...")
+		utils.PrintStatus("🤖 Starting Q&A Flow...")
 		flow = CreateQAFlow()
 
 	case "agent":
-		fmt.Println("🤖 Starting Agent Flow...")
+		utils.PrintStatus("🤖 Starting Agent Flow...")
 		flow = CreateAgentFlow()
 		// For agent mode, we need to set an initial question
 
 	case "batch":
-		fmt.Println("🤖 Starting Batch Processing Flow...")
-		flow = CreateBatchFlow()
+		utils.PrintStatus("🤖 Starting Batch Processing Flow...")
+		shared.Set("batch_file", *batchFile)
+		switch {
+		case *batchAPI && *batchFile == "":
+			log.Fatalf("-batch-api needs prompts: use -batch-file path/to/prompts.txt")
+		case *batchAPI:
+			flow = CreateBatchAPIFlow()
+		default:
+			flow = CreateBatchFlow()
+		}
+
+	case "data":
+		if *dataPath == "" {
+			log.Fatalf("Data mode needs a file: use -data path/to/file.csv")
+		}
+		df, err := utils.LoadDataFrame(*dataPath)
+		if err != nil {
+			log.Fatalf("❌ Could not load data: %v", err)
+		}
+		shared.Set("dataframe", df)
+		fmt.Printf("🤖 Starting Data Q&A Flow on %s\n%s", *dataPath, df.Schema())
+		flow = CreateDataFlow()
+
+	case "logs":
+		if *logPath == "" {
+			log.Fatalf("Logs mode needs a file: use -log path/to/app.log")
+		}
+		if _, err := os.Stat(*logPath); err != nil {
+			log.Fatalf("❌ Could not open log file: %v", err)
+		}
+		shared.Set("log_path", *logPath)
+		shared.Set("log_confirm_chunks", *logConfirm)
+		utils.PrintStatus("🤖 Starting Log Analysis Flow on %s...", *logPath)
+		flow = CreateLogFlow()
+
+	case "audio":
+		if *audioPath == "" {
+			log.Fatalf("Audio mode needs a file: use -audio path/to/podcast.mp3")
+		}
+		if _, err := os.Stat(*audioPath); err != nil {
+			log.Fatalf("❌ Could not open audio file: %v", err)
+		}
+		shared.Set("audio_path", *audioPath)
+		shared.Set("audio_transcriber", *transcriber)
+		utils.PrintStatus("🤖 Starting Audio Summarization Flow on %s...", *audioPath)
+		flow = CreateAudioFlow()
+
+	case "email":
+		emails, err := fetchEmails(*mailbox, *mailDays, *mailQuery, *mailLimit)
+		if err != nil {
+			log.Fatalf("❌ Could not fetch email: %v", err)
+		}
+		shared.Set("emails", emails)
+		utils.PrintStatus("🤖 Starting Email Flow on %d message(s) from %s...", len(emails), *mailbox)
+		flow = CreateEmailFlow()
+
+	case "pr-review":
+		if !strings.Contains(*prRepo, "/") || *prNumber <= 0 {
+			log.Fatalf("PR review mode needs a pull request: use -repo owner/name -pr 123")
+		}
+		// Extra arguments steer the review, e.g. "focus on concurrency".
+		shared.Set("question", strings.Join(flag.Args(), " "))
+		shared.Set("pr_repo", *prRepo)
+		shared.Set("pr_number", *prNumber)
+		shared.Set("pr_post", *postReview)
+		utils.PrintStatus("🤖 Starting PR Review Flow on %s#%d...", *prRepo, *prNumber)
+		if err := CreatePRReviewFlow().Run(ctx, shared); err != nil {
+			log.Fatalf("❌ PR review failed: %v", err)
+		}
+		answer, _ := shared.Get("answer")
+		if err := displayAnswer(answer.(string)); err != nil {
+			log.Fatalf("❌ %v", err)
+		}
+		return
+
+	case "triage":
+		tracker, err := utils.NewIssueTracker(*forge, *prRepo)
+		if err != nil {
+			log.Fatalf("❌ Triage mode needs a repository: %v", err)
+		}
+		shared.Set("issue_tracker", tracker)
+		shared.Set("issue_limit", *issueLimit)
+		utils.PrintStatus("🤖 Starting Issue Triage Flow on %s...", *prRepo)
+		if err := CreateIssueTriageFlow().Run(ctx, shared); err != nil {
+			log.Fatalf("❌ Issue triage failed: %v", err)
+		}
+		answer, _ := shared.Get("answer")
+		if err := displayAnswer(answer.(string)); err != nil {
+			log.Fatalf("❌ %v", err)
+		}
+		if *applyTriage {
+			triages, _ := shared.Get("triages")
+			applyIssueTriage(bufio.NewReader(os.Stdin), tracker, triages.([]issueTriage))
+		}
+		return
+
+	case "security-review":
+		// The files or directories to review follow the flags.
+		paths := flag.Args()
+		if len(paths) == 0 {
+			paths = []string{"."}
+		}
+		shared.Set("security_paths", paths)
+		utils.PrintStatus("🤖 Starting Security Review Flow on %s...", strings.Join(paths, ", "))
+		if err := CreateSecurityReviewFlow().Run(ctx, shared); err != nil {
+			log.Fatalf("❌ Security review failed: %v", err)
+		}
+		if *sarifPath != "" {
+			sarif, _ := shared.Get("security_sarif")
+			if err := os.WriteFile(*sarifPath, sarif.([]byte), 0644); err != nil {
+				log.Fatalf("❌ Could not write SARIF: %v", err)
+			}
+			utils.PrintStatus("📄 SARIF written to %s", *sarifPath)
+		}
+		answer, _ := shared.Get("answer")
+		if err := displayAnswer(answer.(string)); err != nil {
+			log.Fatalf("❌ %v", err)
+		}
+		return
+
+	case "quiz":
+		// The documents to quiz on follow the flags; without any, the knowledge base is used.
+		index, err := utils.LoadKBIndex(utils.DefaultKBIndexPath())
+		if err != nil {
+			log.Fatalf("❌ %v", err)
+		}
+		shared.Set("kb_index", index)
+		shared.Set("quiz_paths", flag.Args())
+		utils.PrintStatus("🤖 Starting Quiz Flow...")
+		if err := CreateQuizSetupFlow().Run(ctx, shared); err != nil {
+			log.Fatalf("❌ %v", err)
+		}
+		runQuiz(ctx, bufio.NewReader(os.Stdin), shared, *quizCount)
+		return
 
 	default:
-		log.Fatalf("Unknown mode: %s. Use 'qa', 'agent', or 'batch'", *mode)
+		log.Fatalf("Unknown mode: %s. Use 'qa', 'agent', 'batch', 'data', 'logs', 'audio', 'email', 'pr-review', 'triage', 'security-review', or 'quiz'", *mode)
+	}
+
+	if *oneShot != "" {
+		os.Exit(askOnce(flow, shared, *oneShot, answerOut))
 	}
 
 	// Enable verbose logging if requested
@@ -211,14 +1583,76 @@ func main() {
 		// In a real implementation, you might configure logging here
 	}
 
+	var idle *idleWatcher
+	if *idleSave > 0 {
+		if _, ok := conversationStore.(jsonStorage); !ok && *idleSeal != "" {
+			log.Fatalf("❌ -idle-seal only works with JSON conversation files, not CONVERSATION_STORE=%s", os.Getenv("CONVERSATION_STORE"))
+		}
+		switch *idleSeal {
+		case "", "gzip":
+		case "encrypt":
+			if os.Getenv("CONVERSATION_KEY") == "" {
+				log.Fatalf("❌ -idle-seal encrypt needs a passphrase in CONVERSATION_KEY")
+			}
+		default:
+			log.Fatalf("❌ Unknown -idle-seal %q (use gzip or encrypt)", *idleSeal)
+		}
+		idle = startIdleWatcher(shared, *idleSave, *idleSeal)
+	}
+
 	reader := bufio.NewReader(os.Stdin)
+	session := &chatSession{ctx: ctx, reader: reader, shared: shared}
+	// The react loop's ask_user action asks here, mid-turn.
+	shared.Set("ask_user", userAsker(func(ctx context.Context, question string) (string, error) {
+		fmt.Print("\n" + utils.Paint(utils.StyleUser, "❓ "+question) + " ")
+		reply, err := reader.ReadString('\n')
+		if err == io.EOF {
+			err = nil
+		}
+		return strings.TrimSpace(reply), err
+	}))
+	// Tools that act, like run_shell, ask here before each action.
+	shared.Set("confirm", utils.Confirm(func(ctx context.Context, prompt string) bool {
+		fmt.Print("\n" + utils.Paint(utils.StyleWarning, prompt+" [y/N]: "))
+		answer, _ := reader.ReadString('\n')
+		answer = strings.ToLower(strings.TrimSpace(answer))
+		return answer == "y" || answer == "yes"
+	}))
+	// On a terminal, questions are typed in a line editor that recalls earlier ones.
+	editor := utils.NewLineEditor()
+	if editor != nil {
+		for _, c := range utils.GetHistory(shared).Conversations {
+			editor.AddHistory(c.User)
+		}
+	}
+	endTurn := func() {}
 	for {
-		fmt.Print("\nYou: ")
-		// Call our new multi-line input function instead of the single-line read.
-		userInput, err := readMultiLineInput(reader)
+		endTurn()
+		fmt.Print("\n" + utils.Paint(utils.StyleUser, "You:") + " ")
+		if idle != nil {
+			idle.waiting()
+		}
+		var userInput string
+		if *useEditor {
+			fmt.Printf("(opening %s)\n", utils.EditorCommand()[0])
+			if userInput, err = utils.EditText(""); err == nil {
+				if userInput == "" {
+					userInput = "quit"
+				}
+				fmt.Println(userInput)
+			}
+		} else if editor != nil {
+			userInput, err = editor.ReadInput()
+			editor.AddHistory(userInput)
+		} else {
+			userInput, err = readMultiLineInput(reader)
+		}
 		if err != nil {
 			log.Fatalf("Failed to read input: %v", err)
 		}
+		if idle != nil {
+			idle.busy()
+		}
 		userInput = strings.TrimSpace(userInput)
 
 		// If the user enters *only* "quit" or "exit", we should still quit.
@@ -230,6 +1664,25 @@ func main() {
 			fmt.Println("🤖 Goodbye!")
 			break
 		}
+		// Commands and the flow run under the turn's context, which Ctrl+C cancels.
+		var turnCtx context.Context
+		turnCtx, endTurn = startTurn(ctx)
+		session.ctx = turnCtx
+		if followUp, ok := pickFollowUp(shared, userInput); ok {
+			fmt.Printf("➡️  %s\n", followUp)
+			userInput = followUp
+		}
+		session.questionScope = session.kbScope
+		if strings.HasPrefix(userInput, "/") {
+			if userInput = runChatCommand(session, userInput); userInput == "" {
+				continue
+			}
+		}
+		shared.Set("kb_namespaces", session.questionScope)
+
+		if *topicDetect {
+			checkTopicChange(turnCtx, reader, shared, userInput)
+		}
 
 		shared.Set("question", userInput)
 		if ConversationName == "" {
@@ -239,20 +1692,73 @@ func main() {
 
 		}
 
-		fmt.Println("🚀 Running flow...")
-		err = flow.Run(ctx, shared)
+		if !confirmEstimatedCost(reader, shared, userInput, *costWarn) {
+			fmt.Println("🛑 Request cancelled.")
+			continue
+		}
+
+		if len(utils.GetHistory(shared).ToSummarize()) > 0 {
+			if err := CreateCompactHistoryFlow().Run(turnCtx, shared); err != nil && turnCtx.Err() == nil {
+				utils.PrintWarning("⚠️  Could not summarize older turns, sending them as they are: %v", err)
+			}
+		}
+		if dropped := utils.GetHistory(shared).Dropped(); dropped > 0 {
+			utils.PrintStatus("✂️ Leaving out the %d oldest turn(s) to stay within -history-tokens (/pin keeps a turn).", dropped)
+		}
+		// Usage of commands and checks so far is counted, but not in this answer's footer.
+		collectUsage(shared)
+		utils.PrintStatus("🚀 Running flow...")
+		shared.Set("provenance", nil)
+		shared.Set("answer_streamed", false)
+		err = flow.Run(turnCtx, shared)
+		if err != nil && turnCtx.Err() != nil {
+			// Stopped with Ctrl+C: keep what was saved and go back to the prompt.
+			if _, err := saveConversation(shared); err != nil {
+				utils.PrintWarning("⚠️  Could not save the conversation: %v", err)
+			}
+			continue
+		}
 		if err != nil {
-			log.Fatalf("❌ Flow failed: %v", err)
+			log.Fatal(utils.Paint(utils.StyleError, fmt.Sprintf("❌ Flow failed: %v", err)))
+		}
+
+		// Save after every turn so a crash or kill loses nothing.
+		if _, err := saveConversation(shared); err != nil {
+			utils.PrintWarning("⚠️  Could not save the conversation: %v", err)
 		}
 
-		fmt.Println("\n🎉 Flow completed successfully!")
+		fmt.Println()
+		utils.PrintStatus("🎉 Flow completed successfully!")
 		if answer, ok := shared.Get("answer"); ok {
-			fmt.Println("\n✅ Answer:")
-			// fmt.Println(answer)
-			if err := displayAnswer(answer.(string)); err != nil {
-				// If Glow fails, fall back to plain text.
-				fmt.Println("Glow renderer failed, printing raw text:")
-				fmt.Println(answer)
+			// Streamed answers were already printed while they were generated.
+			if streamed, _ := shared.Get("answer_streamed"); streamed != true {
+				fmt.Println("\n" + utils.Paint(utils.StyleAI, "✅ Answer:"))
+				if err := displayAnswer(answer.(string)); err != nil {
+					// If the external renderer fails, fall back to plain text.
+					utils.PrintWarning("⚠️  Renderer failed (%v), printing raw text:", err)
+					fmt.Println(answer)
+				}
+			}
+			if usage := collectUsage(shared); usage.Total().Calls > 0 {
+				session, _ := shared.Get("usage")
+				utils.PrintStatus("📊 %s (session: %s)", usage, session)
+			}
+			if *copyAnswer || *copyCode {
+				copyToClipboard(answer.(string), *copyCode)
+			}
+			if event, _ := shared.Get("calendar_event"); event != nil && event.(*utils.CalendarEvent) != nil {
+				createCalendarEvent(reader, event.(*utils.CalendarEvent))
+				shared.Set("calendar_event", nil)
+			}
+			if sandbox, _ := shared.Get("sandbox"); sandbox != nil && sandbox.(*utils.Sandbox) != nil {
+				reviewSandbox(reader, sandbox.(*utils.Sandbox))
+				shared.Set("sandbox", nil)
+			}
+			if draft, _ := shared.Get("email_draft"); draft != nil && draft.(*emailDraft) != nil {
+				saveEmailDraft(reader, draft.(*emailDraft))
+			}
+			if *suggest {
+				suggestFollowUps(turnCtx, shared)
 			}
 		}
 	}
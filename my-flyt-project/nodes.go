@@ -6,10 +6,18 @@ import (
 	"flyt-project-template/utils"
 	"fmt"
 	"io"
+	"math/rand/v2"
 	"net/http"
 	"net/url"
 	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"sort"
+	"strconv"
 	"strings"
+	"time"
+	"unicode/utf8"
 
 	"github.com/mark3labs/flyt"
 )
@@ -19,6 +27,62 @@ func saveHistory(shared *flyt.SharedStore, h utils.History) {
 	shared.Set("history", h)
 }
 
+// turnModel is the model for the next turn: the "model" key set by /model,
+// or utils.DefaultModel.
+func turnModel(shared *flyt.SharedStore) string {
+	if model, _ := shared.Get("model"); model != nil && model.(string) != "" {
+		return model.(string)
+	}
+	return utils.DefaultModel
+}
+
+// agentTools are the tools -tools and /tools choose from: the routes the qa
+// and agent flows take instead of a plain answer.
+var agentTools = []string{"search", "images", "man", "symbol", "youtube", "calendar", "edit"}
+
+// allowedTools reports which tools the shared "tools" allowlist allows; no
+// allowlist allows them all.
+func allowedTools(shared *flyt.SharedStore) func(tool string) bool {
+	value, _ := shared.Get("tools")
+	allowed, ok := value.([]string)
+	return func(tool string) bool {
+		return !ok || allowed == nil || slices.Contains(allowed, tool)
+	}
+}
+
+// provenanceItem is something that was put in the prompt of the current
+// answer: a retrieved chunk, a search result or a tool's output. /why lists them.
+type provenanceItem struct {
+	Kind  string // "rag", "search" or "tool"
+	Title string
+	Ref   string  // URL, namespace or command
+	Score float64 // similarity of retrieved chunks; 0 when not scored
+	Text  string
+}
+
+// addProvenance records items under "provenance" for the current answer.
+func addProvenance(shared *flyt.SharedStore, items ...provenanceItem) {
+	value, _ := shared.Get("provenance")
+	list, _ := value.([]provenanceItem)
+	shared.Set("provenance", append(list, items...))
+}
+
+// groundingSourcePattern matches the source lines CallLLMWithSearch appends to answers.
+var groundingSourcePattern = regexp.MustCompile(`(?m)^\d+\. (.*) \((\S+)\)$`)
+
+// groundingSources lists the search results a grounded answer was based on.
+func groundingSources(answer string) []provenanceItem {
+	_, sources, ok := strings.Cut(answer, "\n---\n**Sources:**\n")
+	if !ok {
+		return nil
+	}
+	var items []provenanceItem
+	for _, m := range groundingSourcePattern.FindAllStringSubmatch(sources, -1) {
+		items = append(items, provenanceItem{Kind: "search", Title: m[1], Ref: m[2]})
+	}
+	return items
+}
+
 // CreateAnswerNode creates a node that generates an answer using LLM
 func CreateAnswerNode() flyt.Node {
 	return flyt.NewNode(
@@ -36,10 +100,37 @@ func CreateAnswerNode() flyt.Node {
 				return nil, fmt.Errorf("no context found in shared store")
 			}
 
+			retrieved, _ := shared.Get("retrieved")
+
+			// With provider-side threads the provider already holds the history.
+			threadID := ""
+			if useThreads, _ := shared.Get("use_threads"); useThreads == true {
+				if h.ID == "" {
+					h.ID = utils.NewConversationID()
+					saveHistory(shared, h)
+				}
+				threadID = h.ID
+			}
+			// A stream handler set by the caller (terminal or HTTP) receives the answer as it is generated.
+			emit, _ := shared.Get("stream_events")
+			if threadID != "" {
+				emit = nil
+			}
+			// The model router (-router) does not apply to threads, which stay with one model.
+			router, _ := shared.Get("router")
+			if threadID != "" {
+				router = nil
+			}
+
 			return map[string]any{
-				"question": question,
-				"history":  h.Conversations,
-				"context":  context,
+				"question":  question,
+				"history":   h.ForPrompt(),
+				"context":   context,
+				"retrieved": retrieved,
+				"thread":    threadID,
+				"stream":    emit,
+				"model":     turnModel(shared),
+				"router":    router,
 			}, nil
 		}),
 		flyt.WithExecFunc(func(ctx context.Context, prepResult any) (any, error) {
@@ -47,25 +138,42 @@ func CreateAnswerNode() flyt.Node {
 			question := data["question"].(string)
 			history := data["history"].([]utils.Conversation)
 			context := data["context"].(string)
-			fmt.Println("🔎 Generating answer with LLM... CreateAnswerNode")
+			config := utils.NodeConfig("answer")
+			config.Model = data["model"].(string)
+			utils.PrintStatus("🔎 Generating answer with %s... CreateAnswerNode", config.Model)
 
 			// Call LLM to get the answer
 			// Build prompt including a short serialized history if present
 			if context == "" {
 				context = " you are a helpful assistant. "
 			}
-			prompt := fmt.Sprintf("Context: %s\nAnswer this question: %s", context, question)
-			if len(history) > 0 {
-				// Serialize recent history entries into a simple text block
-				var b strings.Builder
-				for i, c := range history {
-					b.WriteString(fmt.Sprintf("%d. User: %s\n   AI: %v\n", i+1, c.User, c.AI))
-				}
-				prompt = fmt.Sprintf("Context: %s\nHistory:\n%s\nAnswer this question: %s", context, b.String(), question)
+			retrieved, _ := data["retrieved"].(string)
+			thread := data["thread"].(string)
+			// Earlier turns are sent as native messages rather than pasted into the prompt.
+			if thread == "" {
+				config.History = history
+			}
+			config.System = context
+			prompt, err := utils.BuildPrompt(utils.PromptParts{
+				Retrieved: strings.TrimRight(retrieved, "\n"),
+				Question:  question,
+			}, config.Model)
+			if err != nil {
+				return nil, err
+			}
+			if thread != "" {
+				return utils.CallLLMInThread(ctx, thread, prompt, config)
+			}
+			if router, ok := data["router"].(*utils.ModelRouter); ok {
+				return routeAnswer(ctx, router, question, prompt, config, data["stream"])
+			}
+			if emit, ok := data["stream"].(utils.StreamHandler); ok {
+				// The handler shows the answer as it arrives; the caller then skips displaying it again.
+				return streamAnswer(ctx, prompt, config, emit)
 			}
 
 			// Call LLM helper in utils
-			response, err := utils.CallLLM(prompt)
+			response, err := utils.CallLLMWithConfig(ctx, prompt, config, false)
 			if err != nil {
 				return nil, err
 			}
@@ -74,9 +182,19 @@ func CreateAnswerNode() flyt.Node {
 		}),
 		flyt.WithPostFunc(func(ctx context.Context, shared *flyt.SharedStore, prepResult, execResult any) (flyt.Action, error) {
 			// Store the answer and append to history using helpers
-			shared.Set("answer", execResult)
+			_, streamed := prepResult.(map[string]any)["stream"].(utils.StreamHandler)
+			if routed, ok := execResult.(routedAnswer); ok {
+				// A kept cheap answer was not streamed.
+				execResult, streamed = routed.answer, streamed && routed.outcome != "cheap"
+			}
+			shared.Set("answer_streamed", streamed)
 			q, _ := shared.Get("question")
 			conv := utils.Conversation{User: q.(string), AI: execResult}
+			if partial, ok := execResult.(truncatedAnswer); ok {
+				conv.AI, conv.Truncated = string(partial), true
+				utils.PrintWarning("⚠️  The answer was cut off; /continue picks up where it stopped.")
+			}
+			shared.Set("answer", conv.AI)
 
 			h := utils.GetHistory(shared)
 			h.Conversations = append(h.Conversations, conv)
@@ -87,6 +205,88 @@ func CreateAnswerNode() flyt.Node {
 	)
 }
 
+// routedAnswer is an answer chosen by the model router, with where it came from.
+type routedAnswer struct {
+	answer  any
+	outcome string // "cheap", "escalated" or "strong"
+}
+
+// routeAnswer answers prompt with the router's cheap model when question is
+// simple and the answer passes utils.CheckCheapAnswer, otherwise with
+// config.Model, streamed through emit when one is given. The cheap answer is
+// never streamed, so an escalation does not show two answers.
+func routeAnswer(ctx context.Context, router *utils.ModelRouter, question, prompt string, config *utils.LLMConfig, emit any) (any, error) {
+	strong := config.Model
+	size := utils.CountTokens(prompt+config.System) + utils.CountTokens(utils.FormatHistory(config.History))
+	outcome := "strong"
+	model, reason := router.Route(question, strong)
+	if model != strong {
+		utils.PrintStatus("🧭 Trying %s (%s)...", model, reason)
+		cheap := *config
+		// Its own persona name, so an "answer" persona's model does not replace the cheap one.
+		cheap.Model, cheap.Node = model, "cheap_answer"
+		answer, err := utils.CallLLMWithConfig(ctx, prompt, &cheap, false)
+		if err == nil {
+			err = utils.CheckCheapAnswer(answer)
+		}
+		if err == nil {
+			router.Record("cheap", strong, size, utils.CountTokens(answer))
+			return routedAnswer{answer: answer, outcome: "cheap"}, nil
+		}
+		utils.PrintStatus("⤴️  Escalating to %s: %v", strong, err)
+		router.Record("escalated", strong, size, utils.CountTokens(answer))
+		outcome = "escalated"
+	} else {
+		router.Record("strong", strong, size, 0)
+	}
+
+	var answer any
+	var err error
+	if handler, ok := emit.(utils.StreamHandler); ok {
+		answer, err = streamAnswer(ctx, prompt, config, handler)
+	} else {
+		answer, err = utils.CallLLMWithConfig(ctx, prompt, config, false)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return routedAnswer{answer: answer, outcome: outcome}, nil
+}
+
+// truncatedAnswer is the part of an answer that streamed before the stream
+// was cut off or stopped at the token limit.
+type truncatedAnswer string
+
+// streamAnswer streams the answer to prompt through emit and returns its
+// text, or the part received so far as a truncatedAnswer when the stream
+// breaks or hits the output limit after some text arrived.
+func streamAnswer(ctx context.Context, prompt string, config *utils.LLMConfig, emit utils.StreamHandler) (any, error) {
+	var answer strings.Builder
+	finish := ""
+	err := utils.CallLLMStreamEvents(ctx, prompt, config, func(ev utils.StreamEvent) error {
+		switch ev := ev.(type) {
+		case utils.TextDelta:
+			answer.WriteString(ev.Text)
+		case utils.Done:
+			finish = ev.FinishReason
+		}
+		return emit(ev)
+	})
+	switch {
+	case err != nil && answer.Len() == 0:
+		return nil, err
+	case err != nil && ctx.Err() != nil:
+		// Stopped with Ctrl+C; the signal handler has said so.
+		return truncatedAnswer(answer.String()), nil
+	case err != nil:
+		utils.PrintWarning("\n⚠️  The answer stream stopped early: %v", err)
+		return truncatedAnswer(answer.String()), nil
+	case finish == "MAX_TOKENS" || finish == "length":
+		return truncatedAnswer(answer.String()), nil
+	}
+	return answer.String(), nil
+}
+
 func CreateSearchAnswerNode() flyt.Node {
 	return flyt.NewNode(
 		flyt.WithPrepFunc(func(ctx context.Context, shared *flyt.SharedStore) (any, error) {
@@ -105,7 +305,7 @@ func CreateSearchAnswerNode() flyt.Node {
 
 			return map[string]any{
 				"question": question,
-				"history":  h.Conversations,
+				"history":  h.ForPrompt(),
 				"context":  context,
 			}, nil
 		}),
@@ -114,24 +314,31 @@ func CreateSearchAnswerNode() flyt.Node {
 			question := data["question"].(string)
 			history := data["history"].([]utils.Conversation)
 			context := data["context"].(string)
-			fmt.Println("🔎 Generating answer with LLM... CreateSearchAnswerNode")
+			utils.PrintStatus("🔎 Generating answer with LLM... CreateSearchAnswerNode")
 
 			// Build prompt including a short serialized history if present
 			if context == "" {
 				context = " you are a helpful assistant. "
 			}
-			prompt := fmt.Sprintf("Context: %s\nAnswer this question: %s", context, question)
-			if len(history) > 0 {
-				// Serialize recent history entries into a simple text block
-				var b strings.Builder
-				for i, c := range history {
-					b.WriteString(fmt.Sprintf("%d. User: %s\n   AI: %v\n", i+1, c.User, c.AI))
+			prompt, err := utils.BuildPrompt(utils.PromptParts{History: utils.FormatHistory(history), Question: question}, utils.DefaultModel)
+			if err != nil {
+				return nil, err
+			}
+			config := utils.NodeConfig("search_answer")
+			config.System = context
+
+			if searchProvider == "duckduckgo" {
+				// Search ourselves and hand the results to the model, which works with any provider.
+				results, err := utils.SearchWebDuckDuckGo(ctx, question)
+				if err != nil {
+					return nil, err
 				}
-				prompt = fmt.Sprintf("Context: %s\nHistory:\n%s\nAnswer this question: %s", context, b.String(), question)
+				prompt = fmt.Sprintf("Web search results:\n%s\n%s", utils.GuardUntrusted("the web search results", utils.FormatSearchResults(results)), prompt)
+				return utils.CallLLMWithConfig(ctx, prompt, config, false)
 			}
 
 			// Call LLM helper in utils
-			response, err := utils.CallLLMWithSearch(prompt)
+			response, err := utils.CallLLMWithConfig(ctx, prompt, config, true)
 			if err != nil {
 				return nil, err
 			}
@@ -141,6 +348,7 @@ func CreateSearchAnswerNode() flyt.Node {
 		flyt.WithPostFunc(func(ctx context.Context, shared *flyt.SharedStore, prepResult, execResult any) (flyt.Action, error) {
 			// Store the answer and append to history using helpers
 			shared.Set("answer", execResult)
+			addProvenance(shared, groundingSources(execResult.(string))...)
 			q, _ := shared.Get("question")
 			conv := utils.Conversation{User: q.(string), AI: execResult}
 
@@ -153,6 +361,38 @@ func CreateSearchAnswerNode() flyt.Node {
 	)
 }
 
+// withoutImages copies history leaving out the images in paths, which go
+// with the current question.
+func withoutImages(history []utils.Conversation, paths []string) []utils.Conversation {
+	out := make([]utils.Conversation, len(history))
+	for i, c := range history {
+		c.Images = nil
+		for _, img := range history[i].Images {
+			if !slices.Contains(paths, img) {
+				c.Images = append(c.Images, img)
+			}
+		}
+		out[i] = c
+	}
+	return out
+}
+
+// newImages returns the paths no earlier turn recorded, so images attached
+// to every question are kept in the history only once.
+func newImages(history []utils.Conversation, paths []string) []string {
+	var fresh []string
+	for _, p := range paths {
+		seen := false
+		for _, c := range history {
+			seen = seen || slices.Contains(c.Images, p)
+		}
+		if !seen {
+			fresh = append(fresh, p)
+		}
+	}
+	return fresh
+}
+
 func CreateImageAnswerNode() flyt.Node {
 	return flyt.NewNode(
 		flyt.WithPrepFunc(func(ctx context.Context, shared *flyt.SharedStore) (any, error) {
@@ -175,7 +415,7 @@ func CreateImageAnswerNode() flyt.Node {
 
 			return map[string]any{
 				"question":    question,
-				"history":     h.Conversations,
+				"history":     h.ForPrompt(),
 				"context":     context,
 				"image_paths": imagePaths,
 			}, nil
@@ -187,24 +427,36 @@ func CreateImageAnswerNode() flyt.Node {
 			context := data["context"].(string)
 			imagePaths := data["image_paths"].([]string)
 
-			fmt.Println("🔎 Generating answer with LLM... CreateImageAnswerNode")
+			utils.PrintStatus("🔎 Generating answer with LLM... CreateImageAnswerNode")
 
 			// Build prompt including a short serialized history if present
 			if context == "" {
 				context = " you are a helpful assistant. "
 			}
-			prompt := fmt.Sprintf("Context: %s\nAnswer this request: %s", context, question)
-			if len(history) > 0 {
-				// Serialize recent history entries into a simple text block
-				var b strings.Builder
-				for i, c := range history {
-					b.WriteString(fmt.Sprintf("%d. User: %s\n   AI: %v\n", i+1, c.User, c.AI))
+			config := utils.NodeConfig("image_answer")
+			config.System = context
+			// Text-only models cannot see the images, so send their extracted text instead
+			if !utils.ModelSupportsVision(utils.DefaultModel) {
+				prompt, err := utils.BuildPrompt(utils.PromptParts{History: utils.FormatHistory(history), Question: question}, utils.DefaultModel)
+				if err != nil {
+					return nil, err
 				}
-				prompt = fmt.Sprintf("Context: %s\nHistory:\n%s\nAnswer this question: %s", context, b.String(), question)
+				utils.PrintStatus("📝 %s has no vision support, extracting text from images...", utils.DefaultModel)
+				imageText, err := utils.ExtractImageText(ctx, imagePaths)
+				if err != nil {
+					return nil, err
+				}
+				prompt = fmt.Sprintf("%s\n\nText extracted from the attached images:\n%s", prompt, imageText)
+				return utils.CallLLMWithConfig(ctx, prompt, config, false)
 			}
 
-			// Call LLM helper in utils
-			response, err := utils.CallLLMWithImages(prompt, imagePaths)
+			// Earlier turns go as native messages; images attached again now are sent once, with the question.
+			prompt, err := utils.BuildPrompt(utils.PromptParts{Question: question}, utils.DefaultModel)
+			if err != nil {
+				return nil, err
+			}
+			config.History = withoutImages(history, imagePaths)
+			response, err := utils.CallLLMWithImagesConfig(ctx, prompt, imagePaths, config)
 			if err != nil {
 				return nil, err
 			}
@@ -215,9 +467,10 @@ func CreateImageAnswerNode() flyt.Node {
 			// Store the answer and append to history using helpers
 			shared.Set("answer", execResult)
 			q, _ := shared.Get("question")
-			conv := utils.Conversation{User: q.(string), AI: execResult}
-
 			h := utils.GetHistory(shared)
+			imagePaths := prepResult.(map[string]any)["image_paths"].([]string)
+			conv := utils.Conversation{User: q.(string), AI: execResult, Images: newImages(h.Conversations, imagePaths)}
+
 			h.Conversations = append(h.Conversations, conv)
 			saveHistory(shared, h)
 
@@ -236,14 +489,18 @@ func CreateAnalyzeNode() flyt.Node {
 			}
 			searchResults, _ := shared.Get("search_results")
 			image_paths, _ := shared.Get("image_paths")
+			confirm, _ := shared.Get("confirm")
 
 			return map[string]any{
 				"question":       question,
 				"search_results": searchResults,
 				"image_paths":    image_paths,
+				"allowed":        allowedTools(shared),
+				"can_confirm":    confirm != nil,
 			}, nil
 		}), flyt.WithExecFunc(func(ctx context.Context, prepResult any) (any, error) {
 			data := prepResult.(map[string]any)
+			allowed := data["allowed"].(func(string) bool)
 
 			// Simple logic to decide next action
 			// In a real implementation, this could use an LLM to make decisions
@@ -252,28 +509,478 @@ func CreateAnalyzeNode() flyt.Node {
 			// 	return "search", nil
 			// }
 
-			fmt.Println("🔎 Analyzing inputs to decide next action...")
+			utils.PrintStatus("🔎 Analyzing inputs to decide next action...")
 
-			if v, ok := data["image_paths"]; ok && v != nil {
+			if v, ok := data["image_paths"]; ok && v != nil && allowed("images") {
 				if imgs, ok := v.([]string); ok && len(imgs) > 0 {
 					return "analyze_images", nil
 				}
 			}
+
+			// With -tool-choice model the model picks the lookup tools itself,
+			// and with react it reasons step by step; file edits, which need
+			// review, are still routed here.
+			if toolChoice == "model" || toolChoice == "react" {
+				if _, ok := utils.DetectEditQuestion(data["question"].(string), utils.WorkspaceRoot(".")); ok && allowed("edit") {
+					return "edit", nil
+				}
+				if toolChoice == "react" {
+					return "react", nil
+				}
+				if len(offeredTools(allowed, data["can_confirm"].(bool))) == 0 {
+					return "answer", nil
+				}
+				return "tool_use", nil
+			}
+
+			// Pasted YouTube links are answered from the video's transcript
+			if _, _, ok := utils.FindYouTubeURL(data["question"].(string)); ok && allowed("youtube") {
+				return "youtube", nil
+			}
+			// Availability questions are answered from the user's calendar
+			if _, ok := utils.CalendarFromEnv(); ok && allowed("calendar") && calendarQuestionPattern.MatchString(data["question"].(string)) {
+				return "calendar", nil
+			}
+			// Requests to change named files are made in a sandbox, for review
+			if _, ok := utils.DetectEditQuestion(data["question"].(string), utils.WorkspaceRoot(".")); ok && allowed("edit") {
+				return "edit", nil
+			}
+			// "Where is X defined / who calls X" is answered from the workspace's code index
+			if _, ok := utils.DetectSymbolQuestion(data["question"].(string)); ok && allowed("symbol") {
+				return "symbol", nil
+			}
+			// Usage questions about installed programs are grounded in their local docs
+			if _, ok := utils.DetectCommandQuestion(data["question"].(string)); ok && allowed("man") {
+				return "man", nil
+			}
 			// prompt := fmt.Sprintf("Answer this question: %s", question)
 			// if data["context"] != nil {
 			// 	prompt = fmt.Sprintf("Context: %s\n\nAnswer this question: %s", data["context"], question)
 			// }
 
 			// We have search results, process them
+			if !allowed("search") {
+				return "answer", nil
+			}
 			return "search", nil
 		}),
 		flyt.WithPostFunc(func(ctx context.Context, shared *flyt.SharedStore, prepResult, execResult any) (flyt.Action, error) {
 			action := execResult.(string)
+			if action == "react" {
+				shared.Set("react_steps", []reactStep(nil))
+			}
+			// Edits of a turn that failed before their review are dropped.
+			if leftover, _ := shared.Get("sandbox"); leftover != nil && leftover.(*utils.Sandbox) != nil {
+				leftover.(*utils.Sandbox).Discard()
+				shared.Set("sandbox", nil)
+			}
 			return flyt.Action(action), nil
 		}),
 	)
 }
 
+// reactStep is one iteration of the -tool-choice react loop: the model's
+// reasoning, the action it chose and what the action returned.
+type reactStep struct {
+	Thought     string `json:"thought"`
+	Action      string `json:"action"`
+	Input       string `json:"input"`
+	Observation string `json:"-"`
+}
+
+// reactActions are the actions the react loop's model chooses from.
+var reactActions = []string{"search", "run_shell", "ask_user", "answer", "finish"}
+
+// reactObservationChars bounds each observation in the reasoning prompt.
+const reactObservationChars = 4000
+
+// userAsker asks the user a question in the middle of a turn. The chat sets
+// one under "ask_user"; elsewhere an ask_user action ends the turn with the
+// question instead.
+type userAsker func(ctx context.Context, question string) (string, error)
+
+// reactSteps returns the steps of the current react loop.
+func reactSteps(shared *flyt.SharedStore) []reactStep {
+	value, _ := shared.Get("react_steps")
+	steps, _ := value.([]reactStep)
+	return steps
+}
+
+// formatReActSteps writes the steps so far for the model.
+func formatReActSteps(steps []reactStep) string {
+	var b strings.Builder
+	for i, step := range steps {
+		fmt.Fprintf(&b, "Step %d\nThought: %s\nAction: %s(%s)\n", i+1, step.Thought, step.Action, step.Input)
+		if step.Observation != "" {
+			fmt.Fprintf(&b, "Observation: %s\n", TruncateString(step.Observation, reactObservationChars))
+		}
+		b.WriteString("\n")
+	}
+	return b.String()
+}
+
+// CreateReasonNode is the reasoning half of the react loop: the model reads
+// the question and the steps so far and chooses the next action. After
+// maxReActSteps steps it has to answer with what it found.
+func CreateReasonNode() flyt.Node {
+	return flyt.NewNode(
+		flyt.WithPrepFunc(func(ctx context.Context, shared *flyt.SharedStore) (any, error) {
+			question, ok := shared.Get("question")
+			if !ok {
+				return nil, fmt.Errorf("no question found in shared store")
+			}
+			system, _ := shared.Get("context")
+			asker, _ := shared.Get("ask_user")
+			confirm, _ := shared.Get("confirm")
+			return map[string]any{
+				"question": question,
+				"history":  utils.GetHistory(shared).ForPrompt(),
+				"context":  system,
+				"steps":    reactSteps(shared),
+				"search":   allowedTools(shared)("search"),
+				"shell":    allowedTools(shared)("run_shell") && confirm != nil,
+				"can_ask":  asker != nil,
+			}, nil
+		}),
+		flyt.WithExecFunc(func(ctx context.Context, prepResult any) (any, error) {
+			data := prepResult.(map[string]any)
+			steps := data["steps"].([]reactStep)
+			canSearch := data["search"].(bool)
+			canRun := data["shell"].(bool)
+			if len(steps) >= maxReActSteps {
+				utils.PrintStatus("⏹️  Reached the limit of %d steps; answering with what was found.", maxReActSteps)
+				return reactStep{Thought: "I have used all my steps and must answer now.", Action: "answer"}, nil
+			}
+
+			actions := slices.DeleteFunc(slices.Clone(reactActions), func(a string) bool {
+				return a == "search" && !canSearch || a == "run_shell" && !canRun
+			})
+			var b strings.Builder
+			b.WriteString("Answer the user's question by reasoning step by step. At each step, think about what you know and what is missing, then choose one action:\n")
+			if canSearch {
+				b.WriteString("- search: search the web. input is the query.\n")
+			}
+			if canRun {
+				b.WriteString("- run_shell: run a shell command in the user's workspace, after they confirm it, and see its output. input is the command.\n")
+			}
+			b.WriteString(`- ask_user: ask the user one clarifying question, only when the question is ambiguous and a reasonable assumption would not do. input is the question.
+- answer: you have what you need; the final answer will be written from your observations. input is empty.
+- finish: reply to the user with input as the complete final answer, for questions that need no lookup.
+`)
+			fmt.Fprintf(&b, "You have %d step(s) left. Do not repeat a search that already ran.\n\n", maxReActSteps-len(steps))
+			if history := data["history"].([]utils.Conversation); len(history) > 0 {
+				fmt.Fprintf(&b, "History:\n%s\n", utils.FormatHistory(history))
+			}
+			fmt.Fprintf(&b, "Question: %s\n\n", data["question"])
+			if len(steps) > 0 {
+				fmt.Fprintf(&b, "Steps so far:\n%s", formatReActSteps(steps))
+			}
+			b.WriteString(`Reply with JSON: {"thought": "...", "action": "...", "input": "..."}.`)
+
+			schema := map[string]any{
+				"type": "object",
+				"properties": map[string]any{
+					"thought": map[string]any{"type": "string"},
+					"action":  map[string]any{"type": "string", "enum": actions},
+					"input":   map[string]any{"type": "string"},
+				},
+				"required": []string{"thought", "action", "input"},
+			}
+			config := utils.NodeConfig("react")
+			config.System, _ = data["context"].(string)
+			var step reactStep
+			if err := utils.CallLLMStructuredWithConfig(ctx, b.String(), schema, &step, config); err != nil {
+				return nil, err
+			}
+
+			utils.PrintStatus("💭 Step %d: %s", len(steps)+1, step.Thought)
+			// The schema's enum already rejected other actions.
+			switch {
+			case step.Action != "answer" && strings.TrimSpace(step.Input) == "":
+				step.Observation = fmt.Sprintf("%s needs an input.", step.Action)
+			case step.Action == "ask_user" && !data["can_ask"].(bool):
+				// Nobody can reply mid-turn; the question is the answer, and
+				// the user replies in the next turn.
+				step.Action = "finish"
+			}
+			if step.Observation != "" {
+				utils.PrintStatus("⚠️  %s", step.Observation)
+			} else {
+				utils.PrintStatus("➡️  %s(%s)", step.Action, step.Input)
+			}
+			return step, nil
+		}),
+		flyt.WithPostFunc(func(ctx context.Context, shared *flyt.SharedStore, prepResult, execResult any) (flyt.Action, error) {
+			step := execResult.(reactStep)
+			shared.Set("react_steps", append(reactSteps(shared), step))
+			if step.Observation != "" {
+				// The model is told what went wrong and chooses again.
+				return "reason", nil
+			}
+			return flyt.Action(step.Action), nil
+		}),
+	)
+}
+
+// observe records the observation of the react loop's last step.
+func observe(shared *flyt.SharedStore, observation string) {
+	steps := reactSteps(shared)
+	steps[len(steps)-1].Observation = observation
+	shared.Set("react_steps", steps)
+	utils.PrintStatus("👀 %s", TruncateString(strings.Join(strings.Fields(observation), " "), 160))
+}
+
+// CreateReActSearchNode runs the react loop's search action with the
+// registry's web_search tool.
+func CreateReActSearchNode() flyt.Node {
+	return flyt.NewNode(
+		flyt.WithPrepFunc(func(ctx context.Context, shared *flyt.SharedStore) (any, error) {
+			steps := reactSteps(shared)
+			return steps[len(steps)-1].Input, nil
+		}),
+		flyt.WithExecFunc(func(ctx context.Context, prepResult any) (any, error) {
+			search, ok := agentRegistry.Get("web_search")
+			if !ok {
+				return "web search is not available", nil
+			}
+			results, err := search.Execute(ctx, map[string]any{"query": prepResult.(string)})
+			if err != nil {
+				// The model can rephrase or answer without it.
+				return "search failed: " + err.Error(), nil
+			}
+			return results, nil
+		}),
+		flyt.WithPostFunc(func(ctx context.Context, shared *flyt.SharedStore, prepResult, execResult any) (flyt.Action, error) {
+			observe(shared, execResult.(string))
+			addProvenance(shared, provenanceItem{Kind: "tool", Title: "web_search", Ref: "query=" + prepResult.(string), Text: execResult.(string)})
+			return "reason", nil
+		}),
+	)
+}
+
+// CreateReActShellNode runs the react loop's run_shell action once the user
+// confirms the command, and makes its output, or the refusal, the observation.
+// Changed files wait in the turn's sandbox for the user's review.
+func CreateReActShellNode() flyt.Node {
+	return flyt.NewNode(
+		flyt.WithPrepFunc(func(ctx context.Context, shared *flyt.SharedStore) (any, error) {
+			steps := reactSteps(shared)
+			confirm, _ := shared.Get("confirm")
+			// The loop's commands share one sandbox, reviewed after the answer.
+			sandbox, _ := shared.Get("sandbox")
+			if sandbox == nil || sandbox.(*utils.Sandbox) == nil {
+				created, err := utils.NewSandbox(utils.WorkspaceRoot("."))
+				if err != nil {
+					return nil, err
+				}
+				shared.Set("sandbox", created)
+				sandbox = created
+			}
+			return map[string]any{"command": steps[len(steps)-1].Input, "confirm": confirm, "sandbox": sandbox}, nil
+		}),
+		flyt.WithExecFunc(func(ctx context.Context, prepResult any) (any, error) {
+			data := prepResult.(map[string]any)
+			ctx = utils.WithSandbox(ctx, data["sandbox"].(*utils.Sandbox))
+			if confirm, ok := data["confirm"].(utils.Confirm); ok {
+				ctx = utils.WithConfirm(ctx, confirm)
+			}
+			output, err := runShell(ctx, data["command"].(string))
+			if err != nil {
+				// The model can try another command or answer without it.
+				return "not run: " + err.Error(), nil
+			}
+			return output, nil
+		}),
+		flyt.WithPostFunc(func(ctx context.Context, shared *flyt.SharedStore, prepResult, execResult any) (flyt.Action, error) {
+			observe(shared, execResult.(string))
+			addProvenance(shared, provenanceItem{Kind: "tool", Title: "run_shell", Ref: prepResult.(map[string]any)["command"].(string), Text: execResult.(string)})
+			return "reason", nil
+		}),
+	)
+}
+
+// CreateAskUserNode puts the react loop's clarifying question to the user
+// and makes the reply the observation.
+func CreateAskUserNode() flyt.Node {
+	return flyt.NewNode(
+		flyt.WithPrepFunc(func(ctx context.Context, shared *flyt.SharedStore) (any, error) {
+			steps := reactSteps(shared)
+			asker, _ := shared.Get("ask_user")
+			return map[string]any{"question": steps[len(steps)-1].Input, "ask": asker}, nil
+		}),
+		flyt.WithExecFunc(func(ctx context.Context, prepResult any) (any, error) {
+			data := prepResult.(map[string]any)
+			reply, err := data["ask"].(userAsker)(ctx, data["question"].(string))
+			if err != nil {
+				return nil, fmt.Errorf("failed to read the reply: %w", err)
+			}
+			if strings.TrimSpace(reply) == "" {
+				return "The user did not reply; make a reasonable assumption and say which.", nil
+			}
+			return "The user replied: " + reply, nil
+		}),
+		flyt.WithPostFunc(func(ctx context.Context, shared *flyt.SharedStore, prepResult, execResult any) (flyt.Action, error) {
+			observe(shared, execResult.(string))
+			return "reason", nil
+		}),
+	)
+}
+
+// CreateReActAnswerNode ends the react loop: finish replies with the
+// model's input, and answer writes the answer from the observations.
+func CreateReActAnswerNode() flyt.Node {
+	return flyt.NewNode(
+		flyt.WithPrepFunc(func(ctx context.Context, shared *flyt.SharedStore) (any, error) {
+			question, ok := shared.Get("question")
+			if !ok {
+				return nil, fmt.Errorf("no question found in shared store")
+			}
+			system, _ := shared.Get("context")
+			emit, _ := shared.Get("stream_events")
+			return map[string]any{
+				"question": question,
+				"history":  utils.GetHistory(shared).ForPrompt(),
+				"context":  system,
+				"steps":    reactSteps(shared),
+				"stream":   emit,
+				"model":    turnModel(shared),
+			}, nil
+		}),
+		flyt.WithExecFunc(func(ctx context.Context, prepResult any) (any, error) {
+			data := prepResult.(map[string]any)
+			steps := data["steps"].([]reactStep)
+			if last := steps[len(steps)-1]; last.Action == "finish" {
+				return last.Input, nil
+			}
+
+			config := utils.NodeConfig("react_answer")
+			config.Model = data["model"].(string)
+			config.System, _ = data["context"].(string)
+			config.History = data["history"].([]utils.Conversation)
+			prompt := fmt.Sprintf(`Answer the question from the research below, citing the sources you use. If it did not find everything, say what is missing rather than guessing.
+
+Research:
+%s
+Question: %s`, formatReActSteps(steps[:len(steps)-1]), data["question"])
+			utils.PrintStatus("🔎 Writing the answer from %d step(s) with %s...", len(steps)-1, config.Model)
+			if emit, ok := data["stream"].(utils.StreamHandler); ok {
+				return streamAnswer(ctx, prompt, config, emit)
+			}
+			return utils.CallLLMWithConfig(ctx, prompt, config, false)
+		}),
+		flyt.WithPostFunc(func(ctx context.Context, shared *flyt.SharedStore, prepResult, execResult any) (flyt.Action, error) {
+			_, streamed := prepResult.(map[string]any)["stream"].(utils.StreamHandler)
+			q, _ := shared.Get("question")
+			conv := utils.Conversation{User: q.(string), AI: execResult}
+			if partial, ok := execResult.(truncatedAnswer); ok {
+				conv.AI, conv.Truncated = string(partial), true
+				utils.PrintWarning("⚠️  The answer was cut off; /continue picks up where it stopped.")
+			}
+			// A finish reply was not streamed.
+			steps := reactSteps(shared)
+			shared.Set("answer_streamed", streamed && steps[len(steps)-1].Action != "finish")
+			shared.Set("answer", conv.AI)
+
+			h := utils.GetHistory(shared)
+			h.Conversations = append(h.Conversations, conv)
+			saveHistory(shared, h)
+			return flyt.DefaultAction, nil
+		}),
+	)
+}
+
+// CreateToolUseNode answers with function calling: the allowed tools of
+// agentRegistry are declared to the model, which decides which to call, if
+// any, and answers from their results.
+func CreateToolUseNode() flyt.Node {
+	return flyt.NewNode(
+		flyt.WithPrepFunc(func(ctx context.Context, shared *flyt.SharedStore) (any, error) {
+			question, ok := shared.Get("question")
+			if !ok {
+				return nil, fmt.Errorf("no question found in shared store")
+			}
+			context, _ := shared.Get("context")
+			confirm, _ := shared.Get("confirm")
+			return map[string]any{
+				"question": question,
+				"history":  utils.GetHistory(shared).ForPrompt(),
+				"context":  context,
+				"tools":    offeredTools(allowedTools(shared), confirm != nil),
+				"confirm":  confirm,
+			}, nil
+		}),
+		flyt.WithExecFunc(func(ctx context.Context, prepResult any) (any, error) {
+			data := prepResult.(map[string]any)
+			question := data["question"].(string)
+			history := data["history"].([]utils.Conversation)
+			system, _ := data["context"].(string)
+			tools := data["tools"].([]utils.Tool)
+			// Tools that change files, like run_shell, change a sandbox the
+			// user reviews after the answer.
+			var sandbox *utils.Sandbox
+			if confirm, ok := data["confirm"].(utils.Confirm); ok {
+				var err error
+				if sandbox, err = utils.NewSandbox(utils.WorkspaceRoot(".")); err != nil {
+					return nil, err
+				}
+				ctx = utils.WithSandbox(utils.WithConfirm(ctx, confirm), sandbox)
+			}
+
+			config := utils.NodeConfig("tool_use")
+			config.System = system
+			var sources []provenanceItem
+			utils.Offer(config, tools, func(call utils.ToolCall, result utils.ToolResult) {
+				if !result.IsError {
+					sources = append(sources, provenanceItem{Kind: "tool", Title: call.Name, Ref: formatArgs(call.Args), Text: result.Content})
+				}
+			})
+
+			prompt := fmt.Sprintf("Use the tools when they help; answer directly when they do not.\n\nQuestion: %s", question)
+			if len(history) > 0 {
+				prompt = fmt.Sprintf("History:\n%s\n%s", utils.FormatHistory(history), prompt)
+			}
+			utils.PrintStatus("🧰 Letting the model choose among %d tool(s)...", len(tools))
+			answer, err := utils.CallLLMWithConfig(ctx, prompt, config, false)
+			if sandbox != nil && (err != nil || len(sandbox.Changed()) == 0) {
+				sandbox.Discard()
+				sandbox = nil
+			}
+			if err != nil {
+				return nil, err
+			}
+			return map[string]any{"answer": answer, "sources": sources, "sandbox": sandbox}, nil
+		}),
+		flyt.WithPostFunc(func(ctx context.Context, shared *flyt.SharedStore, prepResult, execResult any) (flyt.Action, error) {
+			result := execResult.(map[string]any)
+			shared.Set("answer", result["answer"])
+			shared.Set("sandbox", result["sandbox"])
+			addProvenance(shared, result["sources"].([]provenanceItem)...)
+			q, _ := shared.Get("question")
+			conv := utils.Conversation{User: q.(string), AI: result["answer"]}
+
+			h := utils.GetHistory(shared)
+			h.Conversations = append(h.Conversations, conv)
+			saveHistory(shared, h)
+
+			return flyt.DefaultAction, nil
+		}),
+	)
+}
+
+// formatArgs renders tool call arguments as key=value pairs for /why.
+func formatArgs(args map[string]any) string {
+	keys := make([]string, 0, len(args))
+	for k := range args {
+		keys = append(keys, k)
+	}
+	slices.Sort(keys)
+	parts := make([]string, len(keys))
+	for i, k := range keys {
+		parts[i] = fmt.Sprintf("%s=%v", k, args[k])
+	}
+	return strings.Join(parts, " ")
+}
+
 // CreateSearchNode creates a node that performs web search
 func CreateSearchNode() flyt.Node {
 	return flyt.NewNode(
@@ -296,7 +1003,7 @@ func CreateSearchNode() flyt.Node {
 			question := data["question"]
 			apiKey := data["apiKey"]
 
-			fmt.Println("🔎 Performing web search with SerpApi...")
+			utils.PrintStatus("🔎 Performing web search with SerpApi...")
 
 			// 1. Construct the URL with query parameters for a GET request
 			baseURL := "https://serpapi.com/search.json"
@@ -307,8 +1014,12 @@ func CreateSearchNode() flyt.Node {
 
 			fullURL := baseURL + "?" + params.Encode()
 
-			// 2. Make the HTTP GET request
-			resp, err := http.Get(fullURL)
+			// 2. Make the HTTP GET request, retrying when rate limited
+			req, err := http.NewRequestWithContext(ctx, "GET", fullURL, nil)
+			if err != nil {
+				return nil, fmt.Errorf("failed to create search request: %w", err)
+			}
+			resp, err := utils.DoWithRetry(http.DefaultClient, req)
 			if err != nil {
 				return nil, fmt.Errorf("failed to make search request: %w", err)
 			}
@@ -403,10 +1114,32 @@ func CreateProcessNode() flyt.Node {
 	)
 }
 
+// batchPrompt is a batch item read from -batch-file that is sent to the LLM.
+type batchPrompt string
+
 // CreateLoadItemsNode creates a node that loads items for batch processing
 func CreateLoadItemsNode() flyt.Node {
 	return flyt.NewNode(
+		flyt.WithPrepFunc(func(ctx context.Context, shared *flyt.SharedStore) (any, error) {
+			path, _ := shared.Get("batch_file")
+			return path, nil
+		}),
 		flyt.WithExecFunc(func(ctx context.Context, prepResult any) (any, error) {
+			// With -batch-file every non-empty line is a prompt for the LLM.
+			if path, _ := prepResult.(string); path != "" {
+				content, err := os.ReadFile(path)
+				if err != nil {
+					return nil, fmt.Errorf("failed to read batch file: %w", err)
+				}
+				var items []any
+				for _, line := range strings.Split(string(content), "\n") {
+					if line = strings.TrimSpace(line); line != "" {
+						items = append(items, batchPrompt(line))
+					}
+				}
+				return items, nil
+			}
+
 			// Load items from a source (file, API, database, etc.)
 			// For demo, create some sample items
 			items := []string{
@@ -429,20 +1162,75 @@ func CreateLoadItemsNode() flyt.Node {
 // CreateBatchProcessNode creates a node that processes items in batch
 func CreateBatchProcessNode() flyt.Node {
 	processFunc := func(ctx context.Context, item any) (any, error) {
+		// Prompts from a batch file are answered one request per item.
+		if prompt, ok := item.(batchPrompt); ok {
+			config := utils.NodeConfig("batch_process")
+			config.Priority = utils.PriorityBackground
+			return utils.CallLLMWithConfig(ctx, string(prompt), config, false)
+		}
 		// Process each item
 		itemStr := item.(string)
 		return fmt.Sprintf("Processed: %s", itemStr), nil
 	}
 
 	// Use Flyt's built-in batch node
-	return flyt.NewBatchNode(processFunc, true) // true for concurrent processing
+	config := flyt.DefaultBatchConfig()
+	config.MaxConcurrency = 4
+	return flyt.NewBatchNodeWithConfig(processFunc, true, config) // true for concurrent processing
 }
 
-// CreateAggregateResultsNode creates a node that aggregates batch results
-func CreateAggregateResultsNode() flyt.Node {
+// CreateBatchAPINode submits every prompt as a single asynchronous Gemini batch
+// job, billed at the discounted batch rate, and waits for its results.
+func CreateBatchAPINode() flyt.Node {
 	return flyt.NewNode(
 		flyt.WithPrepFunc(func(ctx context.Context, shared *flyt.SharedStore) (any, error) {
-			results, ok := shared.Get(flyt.KeyResults)
+			items, ok := shared.Get(flyt.KeyItems)
+			if !ok {
+				return nil, fmt.Errorf("no items found in shared store")
+			}
+			var prompts []string
+			for _, item := range items.([]any) {
+				prompt, ok := item.(batchPrompt)
+				if !ok {
+					return nil, fmt.Errorf("-batch-api needs prompts from -batch-file")
+				}
+				prompts = append(prompts, string(prompt))
+			}
+			return prompts, nil
+		}),
+		flyt.WithExecFunc(func(ctx context.Context, prepResult any) (any, error) {
+			prompts := prepResult.([]string)
+			name, err := utils.SubmitBatch(ctx, prompts, utils.NodeConfig("batch_api"))
+			if err != nil {
+				return nil, err
+			}
+			utils.PrintStatus("📦 Submitted %d prompt(s) as batch job %s; polling every %s...", len(prompts), name, utils.BatchPollInterval)
+
+			start := time.Now()
+			answers, err := utils.WaitForBatch(ctx, name, func(state string) {
+				utils.PrintStatus("📦 %s: %s (%s elapsed)", name, state, time.Since(start).Round(time.Second))
+			})
+			if err != nil {
+				return nil, err
+			}
+			results := make([]any, len(answers))
+			for i, a := range answers {
+				results[i] = a
+			}
+			return results, nil
+		}),
+		flyt.WithPostFunc(func(ctx context.Context, shared *flyt.SharedStore, prepResult, execResult any) (flyt.Action, error) {
+			shared.Set(flyt.KeyResults, execResult)
+			return flyt.DefaultAction, nil
+		}),
+	)
+}
+
+// CreateAggregateResultsNode creates a node that aggregates batch results
+func CreateAggregateResultsNode() flyt.Node {
+	return flyt.NewNode(
+		flyt.WithPrepFunc(func(ctx context.Context, shared *flyt.SharedStore) (any, error) {
+			results, ok := shared.Get(flyt.KeyResults)
 			if !ok {
 				return nil, fmt.Errorf("no results found")
 			}
@@ -468,3 +1256,2672 @@ func CreateAggregateResultsNode() flyt.Node {
 		}),
 	)
 }
+
+// CreateDataPlanNode asks the LLM which aggregations answer the question,
+// showing it only the schema and a few sample rows of the loaded data.
+func CreateDataPlanNode() flyt.Node {
+	return flyt.NewNode(
+		flyt.WithPrepFunc(func(ctx context.Context, shared *flyt.SharedStore) (any, error) {
+			question, ok := shared.Get("question")
+			if !ok {
+				return nil, fmt.Errorf("no question found in shared store")
+			}
+			df, ok := shared.Get("dataframe")
+			if !ok {
+				return nil, fmt.Errorf("no dataframe found in shared store")
+			}
+
+			return map[string]any{
+				"question":  question,
+				"dataframe": df,
+			}, nil
+		}),
+		flyt.WithExecFunc(func(ctx context.Context, prepResult any) (any, error) {
+			data := prepResult.(map[string]any)
+			question := data["question"].(string)
+			df := data["dataframe"].(*utils.DataFrame)
+
+			utils.PrintStatus("🧮 Planning data queries...")
+
+			prompt := fmt.Sprintf(`You answer questions about a table without seeing all of it.
+Schema:
+%s
+Sample rows:
+%s
+Question: %s
+
+Reply with only a JSON array of queries that will be executed locally. Each query is an object with:
+- "operation": one of count, sum, avg, min, max, distinct, rows
+- "column": the column to aggregate (optional for count)
+- "group_by": optional column to group by
+- "filters": optional list of {"column", "op" (=, !=, >, >=, <, <=, contains), "value"}
+- "sort_desc": optional, sort grouped results descending
+- "limit": optional maximum number of result rows; at most 200 rows and 100 distinct values are returned, so aggregate rather than list
+Reply with [] if the question can be answered from the schema alone.`, df.Schema(), df.Head(5).Markdown(), question)
+
+			config := utils.NodeConfig("data_plan")
+			config.Format = utils.FormatJSON
+			response, err := utils.CallLLMWithConfig(ctx, prompt, config, false)
+			if err != nil {
+				return nil, err
+			}
+
+			var queries []utils.DataQuery
+			if err := json.Unmarshal([]byte(utils.ExtractJSON(response)), &queries); err != nil {
+				return nil, fmt.Errorf("could not parse query plan: %w", err)
+			}
+			return queries, nil
+		}),
+		flyt.WithPostFunc(func(ctx context.Context, shared *flyt.SharedStore, prepResult, execResult any) (flyt.Action, error) {
+			shared.Set("data_queries", execResult)
+			return flyt.DefaultAction, nil
+		}),
+	)
+}
+
+// CreateDataQueryNode executes the planned queries against the local data.
+func CreateDataQueryNode() flyt.Node {
+	return flyt.NewNode(
+		flyt.WithPrepFunc(func(ctx context.Context, shared *flyt.SharedStore) (any, error) {
+			df, _ := shared.Get("dataframe")
+			queries, _ := shared.Get("data_queries")
+			return map[string]any{
+				"dataframe": df,
+				"queries":   queries,
+			}, nil
+		}),
+		flyt.WithExecFunc(func(ctx context.Context, prepResult any) (any, error) {
+			data := prepResult.(map[string]any)
+			df := data["dataframe"].(*utils.DataFrame)
+			queries := data["queries"].([]utils.DataQuery)
+
+			var results strings.Builder
+			for i, q := range queries {
+				fmt.Printf("   %d. %s\n", i+1, q)
+				out, err := df.Run(q)
+				if err != nil {
+					// Let the answer node see the failure instead of aborting the turn.
+					results.WriteString(fmt.Sprintf("Query %d (%s) failed: %v\n\n", i+1, q, err))
+					continue
+				}
+				results.WriteString(fmt.Sprintf("Query %d (%s):\n%s\n", i+1, q, out.Markdown()))
+			}
+			return results.String(), nil
+		}),
+		flyt.WithPostFunc(func(ctx context.Context, shared *flyt.SharedStore, prepResult, execResult any) (flyt.Action, error) {
+			shared.Set("data_results", execResult)
+			return flyt.DefaultAction, nil
+		}),
+	)
+}
+
+// CreateDataAnswerNode answers the question from the locally computed results.
+func CreateDataAnswerNode() flyt.Node {
+	return flyt.NewNode(
+		flyt.WithPrepFunc(func(ctx context.Context, shared *flyt.SharedStore) (any, error) {
+			question, _ := shared.Get("question")
+			df, _ := shared.Get("dataframe")
+			results, _ := shared.Get("data_results")
+			h := utils.GetHistory(shared)
+
+			return map[string]any{
+				"question":  question,
+				"dataframe": df,
+				"results":   results,
+				"history":   h.ForPrompt(),
+			}, nil
+		}),
+		flyt.WithExecFunc(func(ctx context.Context, prepResult any) (any, error) {
+			data := prepResult.(map[string]any)
+			question := data["question"].(string)
+			df := data["dataframe"].(*utils.DataFrame)
+			results := data["results"].(string)
+			history := data["history"].([]utils.Conversation)
+
+			utils.PrintStatus("🔎 Generating answer with LLM... CreateDataAnswerNode")
+
+			prompt := fmt.Sprintf("Schema:\n%s\nQuery results computed locally over all rows:\n%s\nAnswer this question using only these results, and say so when a result you rely on was truncated: %s",
+				df.Schema(), results, question)
+			if len(history) > 0 {
+				prompt = fmt.Sprintf("History:\n%s\n%s", utils.FormatHistory(history), prompt)
+			}
+
+			answer, err := utils.CallLLMAs(ctx, "data_answer", prompt)
+			if err != nil {
+				return nil, err
+			}
+			return map[string]any{
+				"answer":  answer,
+				"sources": []provenanceItem{{Kind: "tool", Title: "query results computed over the data", Text: results}},
+			}, nil
+		}),
+		flyt.WithPostFunc(func(ctx context.Context, shared *flyt.SharedStore, prepResult, execResult any) (flyt.Action, error) {
+			result := execResult.(map[string]any)
+			shared.Set("answer", result["answer"])
+			addProvenance(shared, result["sources"].([]provenanceItem)...)
+			q, _ := shared.Get("question")
+			conv := utils.Conversation{User: q.(string), AI: result["answer"]}
+
+			h := utils.GetHistory(shared)
+			h.Conversations = append(h.Conversations, conv)
+			saveHistory(shared, h)
+
+			return flyt.DefaultAction, nil
+		}),
+	)
+}
+
+// logChunk is one slice of a log file handed to the map step of the log flow.
+// Only its position is kept; the map step reads the text itself.
+type logChunk struct {
+	Index    int
+	Total    int
+	Path     string
+	Section  utils.LogSection
+	Question string
+}
+
+// logChunkChars bounds each map call so large logs fit comfortably in context.
+const logChunkChars = 40000
+
+// CreateLoadLogChunksNode splits the log file into line-aligned chunks and,
+// when more than shared "log_confirm_chunks" of them are not cached yet, asks
+// before analyzing them.
+func CreateLoadLogChunksNode() flyt.Node {
+	return flyt.NewNode(
+		flyt.WithPrepFunc(func(ctx context.Context, shared *flyt.SharedStore) (any, error) {
+			question, ok := shared.Get("question")
+			if !ok {
+				return nil, fmt.Errorf("no question found in shared store")
+			}
+			path, ok := shared.Get("log_path")
+			if !ok {
+				return nil, fmt.Errorf("no log file found in shared store")
+			}
+			threshold, _ := shared.Get("log_confirm_chunks")
+			confirm, _ := shared.Get("confirm")
+			return map[string]any{
+				"question":  question.(string),
+				"path":      path.(string),
+				"threshold": threshold,
+				"confirm":   confirm,
+			}, nil
+		}),
+		flyt.WithExecFunc(func(ctx context.Context, prepResult any) (any, error) {
+			data := prepResult.(map[string]any)
+			path, question := data["path"].(string), data["question"].(string)
+			sections, err := utils.SplitLogFile(path, logChunkChars)
+			if err != nil {
+				return nil, fmt.Errorf("failed to read log file: %w", err)
+			}
+
+			chunks := make([]any, len(sections))
+			uncached := 0
+			for i, section := range sections {
+				chunk := logChunk{Index: i + 1, Total: len(sections), Path: path, Section: section, Question: question}
+				chunks[i] = chunk
+				if _, ok := cachedLogFindings(chunk); !ok {
+					uncached++
+				}
+			}
+			utils.PrintStatus("📜 Split %s into %d chunk(s), %d already analyzed", path, len(chunks), len(chunks)-uncached)
+
+			if threshold, _ := data["threshold"].(int); threshold > 0 && uncached > threshold {
+				if confirm, ok := data["confirm"].(utils.Confirm); ok {
+					ctx = utils.WithConfirm(ctx, confirm)
+				}
+				ok, err := utils.Confirmed(ctx, fmt.Sprintf("Analyzing %s takes %d model calls for its chunks, plus the summaries. Go ahead?", path, uncached))
+				if err != nil {
+					return nil, fmt.Errorf("%s has %d chunks to analyze, more than -log-confirm-chunks %d: %w", path, uncached, threshold, err)
+				}
+				if !ok {
+					return nil, fmt.Errorf("log analysis cancelled")
+				}
+			}
+			return chunks, nil
+		}),
+		flyt.WithPostFunc(func(ctx context.Context, shared *flyt.SharedStore, prepResult, execResult any) (flyt.Action, error) {
+			shared.Set("log_chunks", execResult)
+			return flyt.DefaultAction, nil
+		}),
+	)
+}
+
+// readLogChunk returns the text of chunk and where its findings are cached.
+func readLogChunk(chunk logChunk) (text, cachePath string, err error) {
+	text, err = utils.ReadLogSection(chunk.Path, chunk.Section, 2*logChunkChars)
+	if err != nil {
+		return "", "", err
+	}
+	return text, utils.LogCachePath(chunk.Path, chunk.Question, text), nil
+}
+
+// cachedLogFindings returns the findings of an earlier run for chunk, if any.
+func cachedLogFindings(chunk logChunk) (string, bool) {
+	_, cachePath, err := readLogChunk(chunk)
+	if err != nil {
+		return "", false
+	}
+	findings, err := os.ReadFile(cachePath)
+	if err != nil {
+		return "", false
+	}
+	return string(findings), true
+}
+
+// CreateLogMapNode extracts errors and anomalies from every chunk
+// concurrently. Findings are cached per file, so asking again about a log
+// that only grew analyzes just the new chunks.
+func CreateLogMapNode() flyt.Node {
+	processFunc := func(ctx context.Context, item any) (any, error) {
+		chunk := item.(logChunk)
+		text, cachePath, err := readLogChunk(chunk)
+		if err != nil {
+			return nil, fmt.Errorf("chunk %d: %w", chunk.Index, err)
+		}
+		findings, err := os.ReadFile(cachePath)
+		if err != nil {
+			prompt := fmt.Sprintf(`You are analyzing part %d of %d of a log file. The user asks: %s
+
+List every error, warning, exception, restart or other anomaly in this part as bullet points.
+Keep the original timestamp at the start of each bullet and quote the key message verbatim.
+Group repeated messages into one bullet with a count. Reply with NONE if nothing is relevant.
+
+Log:
+%s`, chunk.Index, chunk.Total, chunk.Question, text)
+
+			answer, err := utils.CallLLMAs(ctx, "log_map", prompt)
+			if err != nil {
+				return nil, fmt.Errorf("chunk %d: %w", chunk.Index, err)
+			}
+			findings = []byte(answer)
+			err = os.MkdirAll(filepath.Dir(cachePath), 0700)
+			if err == nil {
+				err = os.WriteFile(cachePath, findings, 0600)
+			}
+			if err != nil {
+				utils.PrintWarning("Could not cache the findings of chunk %d: %v", chunk.Index, err)
+			}
+		}
+		return fmt.Sprintf("Part %d/%d:\n%s", chunk.Index, chunk.Total, findings), nil
+	}
+
+	config := flyt.DefaultBatchConfig()
+	config.ItemsKey = "log_chunks"
+	config.ResultsKey = "log_findings"
+	config.MaxConcurrency = 4
+	return flyt.NewBatchNodeWithConfig(processFunc, true, config)
+}
+
+// condenseLogFindings merges the findings in rounds, consecutive parts that
+// together fit in one prompt at a time, until all of them fit in one.
+func condenseLogFindings(ctx context.Context, question string, findings []string) ([]string, error) {
+	for round := 1; ; round++ {
+		var groups [][]string
+		size := 0
+		for _, f := range findings {
+			if len(groups) == 0 || size+len(f) > logChunkChars {
+				groups = append(groups, nil)
+				size = 0
+			}
+			groups[len(groups)-1] = append(groups[len(groups)-1], f)
+			size += len(f)
+		}
+		if len(groups) <= 1 {
+			return findings, nil
+		}
+
+		utils.PrintStatus("🔎 Condensing %d findings into %d (round %d)...", len(findings), len(groups), round)
+		condensed := make([]string, len(groups))
+		for i, group := range groups {
+			if len(group) == 1 {
+				// A single part too large to merge with its neighbours
+				// cannot shrink by merging; keep it as it is.
+				condensed[i] = group[0]
+				continue
+			}
+			prompt := fmt.Sprintf(`These are the anomalies extracted from consecutive parts of a log file. The user asks: %s
+
+%s
+Merge them into one bullet list in time order. Keep every timestamp and quoted message that matters
+for the question, merge repeated messages into one bullet with a total count, and start with the
+range of parts covered, e.g. "Parts 3-7:".`, question, strings.Join(group, "\n\n"))
+			merged, err := utils.CallLLMAs(ctx, "log_reduce", prompt)
+			if err != nil {
+				return nil, err
+			}
+			condensed[i] = merged
+		}
+		if len(condensed) == len(findings) {
+			return condensed, nil
+		}
+		findings = condensed
+	}
+}
+
+// CreateLogReduceNode correlates the per-chunk findings into a root-cause
+// summary, condensing them first when there are too many for one prompt.
+func CreateLogReduceNode() flyt.Node {
+	return flyt.NewNode(
+		flyt.WithPrepFunc(func(ctx context.Context, shared *flyt.SharedStore) (any, error) {
+			question, _ := shared.Get("question")
+			findings, ok := shared.Get("log_findings")
+			if !ok {
+				return nil, fmt.Errorf("no log findings found in shared store")
+			}
+			return map[string]any{
+				"question": question,
+				"findings": findings,
+			}, nil
+		}),
+		flyt.WithExecFunc(func(ctx context.Context, prepResult any) (any, error) {
+			data := prepResult.(map[string]any)
+			question := data["question"].(string)
+			var findings []string
+			for _, f := range data["findings"].([]any) {
+				findings = append(findings, fmt.Sprint(f))
+			}
+
+			findings, err := condenseLogFindings(ctx, question, findings)
+			if err != nil {
+				return nil, err
+			}
+
+			utils.PrintStatus("🔎 Correlating findings... CreateLogReduceNode")
+
+			var b strings.Builder
+			for _, f := range findings {
+				b.WriteString(f + "\n\n")
+			}
+
+			prompt := fmt.Sprintf(`These are the anomalies extracted from consecutive parts of a log file:
+
+%s
+Question: %s
+
+Correlate them into a root-cause analysis:
+1. A short timeline of the relevant events with their timestamps.
+2. The most likely root cause and the evidence for it.
+3. Secondary or cascading failures.
+4. Suggested next steps to confirm and fix.`, b.String(), question)
+
+			return utils.CallLLMAs(ctx, "log_reduce", prompt)
+		}),
+		flyt.WithPostFunc(func(ctx context.Context, shared *flyt.SharedStore, prepResult, execResult any) (flyt.Action, error) {
+			shared.Set("answer", execResult)
+			q, _ := shared.Get("question")
+			conv := utils.Conversation{User: q.(string), AI: execResult}
+
+			h := utils.GetHistory(shared)
+			h.Conversations = append(h.Conversations, conv)
+			saveHistory(shared, h)
+
+			return flyt.DefaultAction, nil
+		}),
+	)
+}
+
+// CreateGenerateCandidateNode asks the LLM for an answer that a later node
+// validates locally. On retries the previous attempt and its validation error
+// are fed back so the model can correct itself.
+func CreateGenerateCandidateNode() flyt.Node {
+	return flyt.NewNode(
+		flyt.WithPrepFunc(func(ctx context.Context, shared *flyt.SharedStore) (any, error) {
+			prompt, ok := shared.Get("generate_prompt")
+			if !ok {
+				return nil, fmt.Errorf("no generate_prompt found in shared store")
+			}
+			candidate, _ := shared.Get("candidate")
+			validationErr, _ := shared.Get("validation_error")
+
+			return map[string]any{
+				"prompt":           prompt,
+				"candidate":        candidate,
+				"validation_error": validationErr,
+			}, nil
+		}),
+		flyt.WithExecFunc(func(ctx context.Context, prepResult any) (any, error) {
+			data := prepResult.(map[string]any)
+			prompt := data["prompt"].(string)
+
+			if validationErr, ok := data["validation_error"].(string); ok && validationErr != "" {
+				fmt.Fprintf(os.Stderr, "🔁 Validation failed (%s), asking for a fix...\n", validationErr)
+				prompt = fmt.Sprintf("%s\n\nYour previous answer was:\n```\n%v\n```\nIt failed local validation with this error:\n%s\nReturn a corrected answer in the same format.",
+					prompt, data["candidate"], validationErr)
+			} else {
+				fmt.Fprintln(os.Stderr, "🔎 Generating answer with LLM... CreateGenerateCandidateNode")
+			}
+
+			return utils.CallLLMAs(ctx, "generate_candidate", prompt)
+		}),
+		flyt.WithPostFunc(func(ctx context.Context, shared *flyt.SharedStore, prepResult, execResult any) (flyt.Action, error) {
+			reply := execResult.(string)
+			shared.Set("candidate_reply", reply)
+			shared.Set("candidate", utils.ExtractCodeBlock(reply))
+			return flyt.DefaultAction, nil
+		}),
+	)
+}
+
+// CreateValidateCandidateNode checks the generated candidate with validate and
+// routes to "retry" until it passes or maxAttempts is reached ("valid"/"failed").
+func CreateValidateCandidateNode(validate func(candidate string) error, maxAttempts int) flyt.Node {
+	return flyt.NewNode(
+		flyt.WithPrepFunc(func(ctx context.Context, shared *flyt.SharedStore) (any, error) {
+			candidate, ok := shared.Get("candidate")
+			if !ok {
+				return nil, fmt.Errorf("no candidate found in shared store")
+			}
+			return candidate, nil
+		}),
+		flyt.WithExecFunc(func(ctx context.Context, prepResult any) (any, error) {
+			// A validation failure is a result, not an exec error, so it is not retried blindly.
+			if err := validate(prepResult.(string)); err != nil {
+				return err.Error(), nil
+			}
+			return "", nil
+		}),
+		flyt.WithPostFunc(func(ctx context.Context, shared *flyt.SharedStore, prepResult, execResult any) (flyt.Action, error) {
+			return validationAction(shared, execResult.(string), maxAttempts), nil
+		}),
+	)
+}
+
+// validationAction records a validation result and picks the route: "valid",
+// "retry", or "failed" once maxAttempts candidates have been rejected.
+func validationAction(shared *flyt.SharedStore, validationErr string, maxAttempts int) flyt.Action {
+	shared.Set("validation_error", validationErr)
+	if validationErr == "" {
+		return "valid"
+	}
+
+	attempts, _ := shared.Get("validation_attempts")
+	n, _ := attempts.(int)
+	n++
+	shared.Set("validation_attempts", n)
+	if n >= maxAttempts {
+		return "failed"
+	}
+	return "retry"
+}
+
+// CreateManHelpNode answers usage questions about an installed program using
+// its local man page, without running it, so the answer matches the installed version.
+func CreateManHelpNode() flyt.Node {
+	return flyt.NewNode(
+		flyt.WithPrepFunc(func(ctx context.Context, shared *flyt.SharedStore) (any, error) {
+			question, ok := shared.Get("question")
+			if !ok {
+				return nil, fmt.Errorf("no question found in shared store")
+			}
+			command, ok := utils.DetectCommandQuestion(question.(string))
+			if !ok {
+				return nil, fmt.Errorf("no installed program mentioned in the question")
+			}
+
+			h := utils.GetHistory(shared)
+			context, _ := shared.Get("context")
+
+			return map[string]any{
+				"question": question,
+				"command":  command,
+				"history":  h.ForPrompt(),
+				"context":  context,
+			}, nil
+		}),
+		flyt.WithExecFunc(func(ctx context.Context, prepResult any) (any, error) {
+			data := prepResult.(map[string]any)
+			question := data["question"].(string)
+			command := data["command"].(string)
+			history := data["history"].([]utils.Conversation)
+			context, _ := data["context"].(string)
+
+			utils.PrintStatus("📖 Reading local documentation for %s...", command)
+			help, err := utils.NamedCommandHelp(command)
+			if err != nil {
+				return nil, err
+			}
+			help = utils.GuardUntrusted("the documentation of "+command, help)
+
+			prompt := fmt.Sprintf("Documentation of the %s installed on this machine:\n%s\n\nUsing only options supported by this installed version, answer this question: %s",
+				command, help, question)
+			if len(history) > 0 {
+				prompt = fmt.Sprintf("History:\n%s\n%s", utils.FormatHistory(history), prompt)
+			}
+			config := utils.NodeConfig("man_help")
+			config.System = context
+
+			answer, err := utils.CallLLMWithConfig(ctx, prompt, config, false)
+			if err != nil {
+				return nil, err
+			}
+			return map[string]any{
+				"answer":  answer,
+				"sources": []provenanceItem{{Kind: "tool", Title: "local documentation", Ref: command, Text: help}},
+			}, nil
+		}),
+		flyt.WithPostFunc(func(ctx context.Context, shared *flyt.SharedStore, prepResult, execResult any) (flyt.Action, error) {
+			result := execResult.(map[string]any)
+			shared.Set("answer", result["answer"])
+			addProvenance(shared, result["sources"].([]provenanceItem)...)
+			q, _ := shared.Get("question")
+			conv := utils.Conversation{User: q.(string), AI: result["answer"]}
+
+			h := utils.GetHistory(shared)
+			h.Conversations = append(h.Conversations, conv)
+			saveHistory(shared, h)
+
+			return flyt.DefaultAction, nil
+		}),
+	)
+}
+
+// CreateSymbolAnswerNode answers where a symbol is defined and who uses it
+// from the find_symbol lookup over the current workspace, so locations are
+// read from the code rather than guessed.
+func CreateSymbolAnswerNode() flyt.Node {
+	return flyt.NewNode(
+		flyt.WithPrepFunc(func(ctx context.Context, shared *flyt.SharedStore) (any, error) {
+			question, ok := shared.Get("question")
+			if !ok {
+				return nil, fmt.Errorf("no question found in shared store")
+			}
+			symbol, ok := utils.DetectSymbolQuestion(question.(string))
+			if !ok {
+				return nil, fmt.Errorf("no symbol to look up in the question")
+			}
+
+			h := utils.GetHistory(shared)
+			context, _ := shared.Get("context")
+
+			return map[string]any{
+				"question":  question,
+				"symbol":    symbol,
+				"workspace": utils.WorkspaceRoot("."),
+				"history":   h.ForPrompt(),
+				"context":   context,
+			}, nil
+		}),
+		flyt.WithExecFunc(func(ctx context.Context, prepResult any) (any, error) {
+			data := prepResult.(map[string]any)
+			question := data["question"].(string)
+			history := data["history"].([]utils.Conversation)
+			context, _ := data["context"].(string)
+			call := utils.ToolCall{ID: "call_1", Name: utils.SymbolTool.Name, Args: map[string]any{"symbol": data["symbol"], "references": true}}
+
+			utils.PrintStatus("🔧 Calling %s for %s in %s...", call.Name, data["symbol"], data["workspace"])
+			result := utils.RunSymbolTool(ctx, data["workspace"].(string), call)
+			if result.IsError {
+				return nil, fmt.Errorf("%s: %s", call.Name, result.Content)
+			}
+			result.Content = utils.GuardUntrusted("the "+call.Name+" result", result.Content)
+
+			prompt := fmt.Sprintf("Result of looking the symbol up in the code index of the workspace:\n%s\n\nUsing only these locations, and saying so when the symbol was not found, answer this question: %s",
+				result.Content, question)
+			if len(history) > 0 {
+				prompt = fmt.Sprintf("History:\n%s\n%s", utils.FormatHistory(history), prompt)
+			}
+			config := utils.NodeConfig("symbol_answer")
+			config.System = context
+
+			answer, err := utils.CallLLMWithConfig(ctx, prompt, config, false)
+			if err != nil {
+				return nil, err
+			}
+			return map[string]any{
+				"answer":  answer,
+				"sources": []provenanceItem{{Kind: "tool", Title: call.Name, Ref: data["symbol"].(string), Text: result.Content}},
+			}, nil
+		}),
+		flyt.WithPostFunc(func(ctx context.Context, shared *flyt.SharedStore, prepResult, execResult any) (flyt.Action, error) {
+			result := execResult.(map[string]any)
+			shared.Set("answer", result["answer"])
+			addProvenance(shared, result["sources"].([]provenanceItem)...)
+			q, _ := shared.Get("question")
+			conv := utils.Conversation{User: q.(string), AI: result["answer"]}
+
+			h := utils.GetHistory(shared)
+			h.Conversations = append(h.Conversations, conv)
+			saveHistory(shared, h)
+
+			return flyt.DefaultAction, nil
+		}),
+	)
+}
+
+// maxEditAttempts is how many diffs are asked for per file before giving up
+// on it.
+const maxEditAttempts = 3
+
+// CreateEditFilesNode makes the requested change to the files the question
+// names in a sandbox copy of the workspace, and answers with the combined
+// diff; nothing on disk changes until the user applies it.
+func CreateEditFilesNode() flyt.Node {
+	return flyt.NewNode(
+		flyt.WithPrepFunc(func(ctx context.Context, shared *flyt.SharedStore) (any, error) {
+			question, ok := shared.Get("question")
+			if !ok {
+				return nil, fmt.Errorf("no question found in shared store")
+			}
+			workspace := utils.WorkspaceRoot(".")
+			files, ok := utils.DetectEditQuestion(question.(string), workspace)
+			if !ok {
+				return nil, fmt.Errorf("no file to edit in the question")
+			}
+			return map[string]any{
+				"question":  question,
+				"workspace": workspace,
+				"files":     files,
+				"history":   utils.GetHistory(shared).ForPrompt(),
+			}, nil
+		}),
+		flyt.WithExecFunc(func(ctx context.Context, prepResult any) (any, error) {
+			data := prepResult.(map[string]any)
+			question := data["question"].(string)
+			files := data["files"].([]string)
+			history := data["history"].([]utils.Conversation)
+
+			sandbox, err := utils.NewSandbox(data["workspace"].(string))
+			if err != nil {
+				return nil, err
+			}
+			var sources []provenanceItem
+			var failed []string
+			for _, file := range files {
+				content, err := sandbox.ReadFile(file)
+				if err != nil {
+					sandbox.Discard()
+					return nil, err
+				}
+				utils.PrintStatus("✏️  Editing %s in a sandbox...", file)
+				prompt := fmt.Sprintf("Here is %s:\n```\n%s\n```\n\nThe whole request, which may also concern other files: %s\n"+
+					"Make the part of the change that belongs in %s. Reply with only a unified diff (--- a/%s, +++ b/%s, @@ hunks with 3 lines of context) in one code block, or an empty code block when this file needs no change.",
+					file, content, question, file, file, file)
+				if len(history) > 0 {
+					prompt = fmt.Sprintf("History:\n%s\n%s", utils.FormatHistory(history), prompt)
+				}
+				var patched string
+				for attempt := 1; ; attempt++ {
+					reply, err := utils.CallLLMAs(ctx, "edit_files", prompt)
+					if err != nil {
+						sandbox.Discard()
+						return nil, err
+					}
+					diff := utils.ExtractCodeBlock(reply)
+					if strings.TrimSpace(diff) == "" {
+						patched = string(content)
+						break
+					}
+					if patched, err = utils.ApplyUnifiedDiff(string(content), diff); err == nil {
+						break
+					}
+					if attempt == maxEditAttempts {
+						failed = append(failed, fmt.Sprintf("%s (%v)", file, err))
+						patched = string(content)
+						break
+					}
+					prompt += fmt.Sprintf("\n\nYour diff did not apply: %v\nReply with a corrected diff.", err)
+				}
+				if err := sandbox.WriteFile(file, []byte(patched)); err != nil {
+					sandbox.Discard()
+					return nil, err
+				}
+				sources = append(sources, provenanceItem{Kind: "tool", Title: "sandboxed edit", Ref: filepath.Join(sandbox.Root, file)})
+			}
+
+			diff, err := sandbox.Diff()
+			if err != nil {
+				sandbox.Discard()
+				return nil, err
+			}
+			var answer strings.Builder
+			if diff == "" {
+				answer.WriteString("No changes were needed.")
+				sandbox.Discard()
+				sandbox = nil
+			} else {
+				fmt.Fprintf(&answer, "Proposed changes to %s, made in a sandbox and not applied yet:\n\n```diff\n%s```", strings.Join(sandbox.Changed(), ", "), diff)
+			}
+			if len(failed) > 0 {
+				fmt.Fprintf(&answer, "\n\nNo working diff after %d attempts for: %s.", maxEditAttempts, strings.Join(failed, "; "))
+			}
+			return map[string]any{
+				"answer":  answer.String(),
+				"sandbox": sandbox,
+				"sources": sources,
+			}, nil
+		}),
+		flyt.WithPostFunc(func(ctx context.Context, shared *flyt.SharedStore, prepResult, execResult any) (flyt.Action, error) {
+			result := execResult.(map[string]any)
+			shared.Set("answer", result["answer"])
+			shared.Set("sandbox", result["sandbox"])
+			addProvenance(shared, result["sources"].([]provenanceItem)...)
+			q, _ := shared.Get("question")
+			conv := utils.Conversation{User: q.(string), AI: result["answer"]}
+
+			h := utils.GetHistory(shared)
+			h.Conversations = append(h.Conversations, conv)
+			saveHistory(shared, h)
+
+			return flyt.DefaultAction, nil
+		}),
+	)
+}
+
+// CreateFollowUpNode asks a cheap model for up to three follow-up questions
+// to the last answer and stores them under "follow_ups".
+func CreateFollowUpNode() flyt.Node {
+	return flyt.NewNode(
+		flyt.WithPrepFunc(func(ctx context.Context, shared *flyt.SharedStore) (any, error) {
+			question, _ := shared.Get("question")
+			answer, ok := shared.Get("answer")
+			if !ok {
+				return nil, fmt.Errorf("no answer found in shared store")
+			}
+			return map[string]any{
+				"question": question,
+				"answer":   answer,
+			}, nil
+		}),
+		flyt.WithExecFunc(func(ctx context.Context, prepResult any) (any, error) {
+			data := prepResult.(map[string]any)
+			prompt := fmt.Sprintf(`A user asked: %s
+
+They got this answer:
+%s
+
+Suggest 2 or 3 short follow-up questions the user is likely to ask next, written from the user's point of view.
+Reply with only a JSON array of strings.`, data["question"], data["answer"])
+
+			config := utils.NodeConfig("follow_up")
+			config.Model = utils.FollowUpModel
+			config.Format = utils.FormatJSON
+			reply, err := utils.CallLLMWithConfig(ctx, prompt, config, false)
+			if err != nil {
+				return nil, err
+			}
+			var followUps []string
+			if err := json.Unmarshal([]byte(utils.ExtractJSON(reply)), &followUps); err != nil {
+				return nil, fmt.Errorf("could not parse follow-up suggestions: %w", err)
+			}
+			if len(followUps) > 3 {
+				followUps = followUps[:3]
+			}
+			return followUps, nil
+		}),
+		flyt.WithPostFunc(func(ctx context.Context, shared *flyt.SharedStore, prepResult, execResult any) (flyt.Action, error) {
+			shared.Set("follow_ups", execResult)
+			return flyt.DefaultAction, nil
+		}),
+	)
+}
+
+// CreateSummarizeHistoryNode condenses the conversation so far into a single
+// pinned turn, so a new topic can start without losing the essentials.
+func CreateSummarizeHistoryNode() flyt.Node {
+	return flyt.NewNode(
+		flyt.WithPrepFunc(func(ctx context.Context, shared *flyt.SharedStore) (any, error) {
+			h := utils.GetHistory(shared)
+			if len(h.Conversations) == 0 {
+				return nil, fmt.Errorf("no history to summarize")
+			}
+			return h.ForPrompt(), nil
+		}),
+		flyt.WithExecFunc(func(ctx context.Context, prepResult any) (any, error) {
+			history := prepResult.([]utils.Conversation)
+			utils.PrintStatus("🗜️  Summarizing the conversation so far...")
+			prompt := fmt.Sprintf("Summarize this conversation in a few bullet points. Keep decisions, requirements, names and numbers that later questions may depend on.\n\n%s",
+				utils.FormatHistory(history))
+			return utils.CallLLMAs(ctx, "summarize_history", prompt)
+		}),
+		flyt.WithPostFunc(func(ctx context.Context, shared *flyt.SharedStore, prepResult, execResult any) (flyt.Action, error) {
+			h := utils.GetHistory(shared)
+			h.Summary = ""
+			h.Conversations = []utils.Conversation{{
+				User:   "Summary of the earlier conversation",
+				AI:     execResult,
+				Pinned: true,
+			}}
+			saveHistory(shared, h)
+			return flyt.DefaultAction, nil
+		}),
+	)
+}
+
+// CreateCompactHistoryNode folds the older turns of a long conversation (see
+// History.ToSummarize) into the history's running summary, which is sent in
+// their place. The turns stay in the saved transcript.
+func CreateCompactHistoryNode() flyt.Node {
+	return flyt.NewNode(
+		flyt.WithPrepFunc(func(ctx context.Context, shared *flyt.SharedStore) (any, error) {
+			h := utils.GetHistory(shared)
+			fold := h.ToSummarize()
+			turns := make([]utils.Conversation, len(fold))
+			for i, n := range fold {
+				turns[i] = h.Conversations[n]
+			}
+			return map[string]any{"summary": h.Summary, "turns": turns, "fold": fold}, nil
+		}),
+		flyt.WithExecFunc(func(ctx context.Context, prepResult any) (any, error) {
+			data := prepResult.(map[string]any)
+			turns := data["turns"].([]utils.Conversation)
+			if len(turns) == 0 {
+				return "", nil
+			}
+			utils.PrintStatus("🗜️  Summarizing %d older turn(s) to keep the prompt small...", len(turns))
+			var earlier string
+			if summary := data["summary"].(string); summary != "" {
+				earlier = fmt.Sprintf("Summary of the conversation before these turns:\n%s\n\n", summary)
+			}
+			prompt := fmt.Sprintf(`%sConversation turns:
+%s
+Write an updated summary of the whole conversation so far in bullet points, to be read in place of these turns.
+Keep decisions, requirements, names, numbers, code identifiers and open questions that later questions may depend on;
+drop greetings and detours. Reply with only the summary.`, earlier, utils.FormatHistory(turns))
+			return utils.CallLLMAs(ctx, "summarize_history", prompt)
+		}),
+		flyt.WithPostFunc(func(ctx context.Context, shared *flyt.SharedStore, prepResult, execResult any) (flyt.Action, error) {
+			summary := strings.TrimSpace(execResult.(string))
+			if summary == "" {
+				return flyt.DefaultAction, nil
+			}
+			h := utils.GetHistory(shared)
+			for _, n := range prepResult.(map[string]any)["fold"].([]int) {
+				h.Conversations[n].Summarized = true
+			}
+			h.Summary = summary
+			saveHistory(shared, h)
+			return flyt.DefaultAction, nil
+		}),
+	)
+}
+
+// prChunk is one piece of one file's diff, reviewed as a single batch item.
+type prChunk struct {
+	Path      string
+	Title     string
+	Numbered  string
+	Lines     map[int]bool
+	Index     int
+	Total     int
+	Directive string
+}
+
+// prChunkChars bounds each file review request; larger diffs are split between hunks.
+const prChunkChars = 30000
+
+// CreateLoadPRNode fetches a pull request from GitHub and splits its diff into per-file chunks
+func CreateLoadPRNode() flyt.Node {
+	return flyt.NewNode(
+		flyt.WithPrepFunc(func(ctx context.Context, shared *flyt.SharedStore) (any, error) {
+			repo, _ := shared.Get("pr_repo")
+			number, _ := shared.Get("pr_number")
+			question, _ := shared.Get("question")
+			return map[string]any{"repo": repo, "number": number, "question": question}, nil
+		}),
+		flyt.WithExecFunc(func(ctx context.Context, prepResult any) (any, error) {
+			data := prepResult.(map[string]any)
+			repo, number := data["repo"].(string), data["number"].(int)
+			directive, _ := data["question"].(string)
+
+			utils.PrintStatus("🐙 Fetching %s#%d...", repo, number)
+			pr, files, err := utils.FetchPullRequest(repo, number)
+			if err != nil {
+				return nil, err
+			}
+
+			var chunks []any
+			for _, f := range files {
+				if f.Patch == "" {
+					utils.PrintWarning("Skipping %s: no textual diff (binary or too large)", f.Filename)
+					continue
+				}
+				pieces := utils.SplitPatchHunks(f.Patch, prChunkChars)
+				for i, piece := range pieces {
+					numbered, lines := utils.NumberPatch(piece)
+					chunks = append(chunks, prChunk{
+						Path: f.Filename, Title: pr.Title, Numbered: numbered, Lines: lines,
+						Index: i + 1, Total: len(pieces), Directive: directive,
+					})
+				}
+			}
+			utils.PrintStatus("📂 %d changed file(s), %d chunk(s) to review", len(files), len(chunks))
+			return map[string]any{"pr": pr, "chunks": chunks}, nil
+		}),
+		flyt.WithPostFunc(func(ctx context.Context, shared *flyt.SharedStore, prepResult, execResult any) (flyt.Action, error) {
+			result := execResult.(map[string]any)
+			shared.Set("pull_request", result["pr"])
+			shared.Set("pr_chunks", result["chunks"])
+			return flyt.DefaultAction, nil
+		}),
+	)
+}
+
+// CreatePRReviewFileNode reviews every diff chunk concurrently and returns structured line comments
+func CreatePRReviewFileNode() flyt.Node {
+	processFunc := func(ctx context.Context, item any) (any, error) {
+		chunk := item.(prChunk)
+		directive := ""
+		if chunk.Directive != "" {
+			directive = "Reviewer focus: " + chunk.Directive + "\n"
+		}
+		prompt := fmt.Sprintf(`You are reviewing part %d of %d of the diff of %s in the pull request %q.
+%sEach added or unchanged line is prefixed with its line number in the new file (L<number>); removed lines have no number.
+
+Report bugs, security problems, missing error handling and confusing code in the changed lines. Skip style nits and praise.
+Reply with only a JSON array (empty if there is nothing worth saying) of objects:
+{"line": <new-file line number>, "severity": "critical" | "major" | "minor", "body": "<the comment, with a suggested fix>"}
+
+%s`, chunk.Index, chunk.Total, chunk.Path, chunk.Title, directive, chunk.Numbered)
+
+		config := utils.NodeConfig("pr_review_file")
+		config.Priority = utils.PriorityBackground
+		config.Format = utils.FormatJSON
+		reply, err := utils.CallLLMWithConfig(ctx, prompt, config, false)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", chunk.Path, err)
+		}
+
+		var comments []utils.ReviewComment
+		if err := json.Unmarshal([]byte(utils.ExtractJSON(reply)), &comments); err != nil {
+			return nil, fmt.Errorf("%s: review is not valid JSON: %w", chunk.Path, err)
+		}
+		// Comments must point at a line in the diff, or GitHub rejects the whole review.
+		var valid []utils.ReviewComment
+		for _, c := range comments {
+			if chunk.Lines[c.Line] && strings.TrimSpace(c.Body) != "" {
+				c.Path = chunk.Path
+				valid = append(valid, c)
+			}
+		}
+		return valid, nil
+	}
+
+	config := flyt.DefaultBatchConfig()
+	config.ItemsKey = "pr_chunks"
+	config.ResultsKey = "pr_comments"
+	config.MaxConcurrency = 4
+	return flyt.NewBatchNodeWithConfig(processFunc, true, config)
+}
+
+// CreatePRReportNode prints the collected review comments and posts them to GitHub when requested
+func CreatePRReportNode() flyt.Node {
+	return flyt.NewNode(
+		flyt.WithPrepFunc(func(ctx context.Context, shared *flyt.SharedStore) (any, error) {
+			results, _ := shared.Get("pr_comments")
+			pr, _ := shared.Get("pull_request")
+			repo, _ := shared.Get("pr_repo")
+			number, _ := shared.Get("pr_number")
+			post, _ := shared.Get("pr_post")
+			return map[string]any{"results": results, "pr": pr, "repo": repo, "number": number, "post": post}, nil
+		}),
+		flyt.WithExecFunc(func(ctx context.Context, prepResult any) (any, error) {
+			data := prepResult.(map[string]any)
+			results, _ := data["results"].([]any)
+
+			var comments []utils.ReviewComment
+			for _, r := range results {
+				comments = append(comments, r.([]utils.ReviewComment)...)
+			}
+			sort.SliceStable(comments, func(i, j int) bool {
+				if comments[i].Path != comments[j].Path {
+					return comments[i].Path < comments[j].Path
+				}
+				return comments[i].Line < comments[j].Line
+			})
+
+			var b strings.Builder
+			summary := fmt.Sprintf("Automated review: %d comment(s).", len(comments))
+			b.WriteString("## " + summary + "\n")
+			lastPath := ""
+			for _, c := range comments {
+				if c.Path != lastPath {
+					fmt.Fprintf(&b, "\n### %s\n", c.Path)
+					lastPath = c.Path
+				}
+				fmt.Fprintf(&b, "- **L%d** (%s): %s\n", c.Line, c.Severity, c.Body)
+			}
+
+			if post, _ := data["post"].(bool); post && len(comments) > 0 {
+				pr := data["pr"].(*utils.PullRequest)
+				repo, number := data["repo"].(string), data["number"].(int)
+				if err := utils.PostPRReview(repo, number, pr.Head.SHA, summary, comments); err != nil {
+					return nil, fmt.Errorf("failed to post review: %w", err)
+				}
+				fmt.Fprintf(&b, "\nPosted as a review on %s#%d.\n", repo, number)
+			}
+			return b.String(), nil
+		}),
+		flyt.WithPostFunc(func(ctx context.Context, shared *flyt.SharedStore, prepResult, execResult any) (flyt.Action, error) {
+			shared.Set("answer", execResult)
+			return flyt.DefaultAction, nil
+		}),
+	)
+}
+
+// issueTriage is the suggested classification of one issue.
+type issueTriage struct {
+	Issue       utils.Issue `json:"-"`
+	Type        string      `json:"type"`
+	Priority    string      `json:"priority"`
+	Labels      []string    `json:"labels"`
+	DuplicateOf []int       `json:"duplicate_of"`
+	Response    string      `json:"response"`
+}
+
+// issueItem is one issue to classify, with its duplicate candidates and the repository's labels.
+type issueItem struct {
+	Issue      utils.Issue
+	Duplicates []utils.Issue
+	Labels     []string
+}
+
+// CreateLoadIssuesNode fetches open issues and finds likely duplicates with embeddings
+func CreateLoadIssuesNode() flyt.Node {
+	return flyt.NewNode(
+		flyt.WithPrepFunc(func(ctx context.Context, shared *flyt.SharedStore) (any, error) {
+			tracker, ok := shared.Get("issue_tracker")
+			if !ok {
+				return nil, fmt.Errorf("no issue tracker found in shared store")
+			}
+			limit, _ := shared.Get("issue_limit")
+			return map[string]any{"tracker": tracker, "limit": limit}, nil
+		}),
+		flyt.WithExecFunc(func(ctx context.Context, prepResult any) (any, error) {
+			data := prepResult.(map[string]any)
+			tracker := data["tracker"].(utils.IssueTracker)
+
+			issues, err := tracker.OpenIssues(data["limit"].(int))
+			if err != nil {
+				return nil, err
+			}
+			utils.PrintStatus("🐛 Triaging %d open issue(s)...", len(issues))
+			if len(issues) == 0 {
+				return []any{}, nil
+			}
+
+			duplicates, err := utils.FindDuplicateIssues(ctx, issues)
+			if err != nil {
+				utils.PrintWarning("Duplicate detection skipped: %v", err)
+			}
+			byNumber := map[int]utils.Issue{}
+			labelSet := map[string]bool{}
+			for _, issue := range issues {
+				byNumber[issue.Number] = issue
+				for _, l := range issue.Labels {
+					labelSet[l] = true
+				}
+			}
+			var labels []string
+			for l := range labelSet {
+				labels = append(labels, l)
+			}
+			sort.Strings(labels)
+
+			items := make([]any, len(issues))
+			for i, issue := range issues {
+				item := issueItem{Issue: issue, Labels: labels}
+				for _, n := range duplicates[issue.Number] {
+					item.Duplicates = append(item.Duplicates, byNumber[n])
+				}
+				items[i] = item
+			}
+			return items, nil
+		}),
+		flyt.WithPostFunc(func(ctx context.Context, shared *flyt.SharedStore, prepResult, execResult any) (flyt.Action, error) {
+			shared.Set("issue_items", execResult)
+			return flyt.DefaultAction, nil
+		}),
+	)
+}
+
+// CreateClassifyIssueNode classifies every issue concurrently and drafts a first response
+func CreateClassifyIssueNode() flyt.Node {
+	processFunc := func(ctx context.Context, item any) (any, error) {
+		issue := item.(issueItem)
+		var dupes strings.Builder
+		for _, d := range issue.Duplicates {
+			fmt.Fprintf(&dupes, "#%d %s\n%s\n\n", d.Number, d.Title, TruncateString(d.Body, 500))
+		}
+		if dupes.Len() == 0 {
+			dupes.WriteString("none\n")
+		}
+		prompt := fmt.Sprintf(`Triage this issue.
+
+Issue #%d: %s
+%s
+
+Earlier issues that may be duplicates:
+%s
+Labels already used in the repository: %s
+
+Reply with only a JSON object:
+{"type": "bug" | "feature" | "question", "priority": "high" | "medium" | "low",
+ "labels": [<labels to add, preferring existing ones>], "duplicate_of": [<numbers of real duplicates from the list above>],
+ "response": "<a short, friendly first reply to the reporter: ask for missing details, or point to the duplicate>"}`,
+			issue.Issue.Number, issue.Issue.Title, TruncateString(issue.Issue.Body, 4000), dupes.String(), strings.Join(issue.Labels, ", "))
+
+		config := utils.NodeConfig("classify_issue")
+		config.Priority = utils.PriorityBackground
+		config.Format = utils.FormatJSON
+		reply, err := utils.CallLLMWithConfig(ctx, prompt, config, false)
+		if err != nil {
+			return nil, fmt.Errorf("issue #%d: %w", issue.Issue.Number, err)
+		}
+		var triage issueTriage
+		if err := json.Unmarshal([]byte(utils.ExtractJSON(reply)), &triage); err != nil {
+			return nil, fmt.Errorf("issue #%d: triage is not valid JSON: %w", issue.Issue.Number, err)
+		}
+		triage.Issue = issue.Issue
+		return triage, nil
+	}
+
+	config := flyt.DefaultBatchConfig()
+	config.ItemsKey = "issue_items"
+	config.ResultsKey = "issue_triage"
+	config.MaxConcurrency = 4
+	return flyt.NewBatchNodeWithConfig(processFunc, true, config)
+}
+
+// CreateTriageReportNode formats the triage suggestions as a report, highest priority first
+func CreateTriageReportNode() flyt.Node {
+	priorityRank := map[string]int{"high": 0, "medium": 1, "low": 2}
+	return flyt.NewNode(
+		flyt.WithPrepFunc(func(ctx context.Context, shared *flyt.SharedStore) (any, error) {
+			results, _ := shared.Get("issue_triage")
+			return results, nil
+		}),
+		flyt.WithExecFunc(func(ctx context.Context, prepResult any) (any, error) {
+			results, _ := prepResult.([]any)
+			triages := make([]issueTriage, len(results))
+			for i, r := range results {
+				triages[i] = r.(issueTriage)
+			}
+			sort.SliceStable(triages, func(i, j int) bool {
+				return priorityRank[triages[i].Priority] < priorityRank[triages[j].Priority]
+			})
+
+			var b strings.Builder
+			fmt.Fprintf(&b, "## Triage of %d issue(s)\n", len(triages))
+			for _, t := range triages {
+				fmt.Fprintf(&b, "\n### #%d %s\n%s · %s priority", t.Issue.Number, t.Issue.Title, t.Type, t.Priority)
+				if len(t.Labels) > 0 {
+					fmt.Fprintf(&b, " · labels: %s", strings.Join(t.Labels, ", "))
+				}
+				for _, d := range t.DuplicateOf {
+					fmt.Fprintf(&b, " · duplicate of #%d", d)
+				}
+				fmt.Fprintf(&b, "\n\n> %s\n", strings.ReplaceAll(strings.TrimSpace(t.Response), "\n", "\n> "))
+			}
+			return map[string]any{"report": b.String(), "triages": triages}, nil
+		}),
+		flyt.WithPostFunc(func(ctx context.Context, shared *flyt.SharedStore, prepResult, execResult any) (flyt.Action, error) {
+			result := execResult.(map[string]any)
+			shared.Set("answer", result["report"])
+			shared.Set("triages", result["triages"])
+			return flyt.DefaultAction, nil
+		}),
+	)
+}
+
+// feedSummary is the summary of one new feed item.
+type feedSummary struct {
+	Item    utils.FeedItem
+	Summary string
+}
+
+// CreateFetchFeedsNode fetches every feed and keeps only the items not seen in an earlier digest
+func CreateFetchFeedsNode() flyt.Node {
+	return flyt.NewNode(
+		flyt.WithPrepFunc(func(ctx context.Context, shared *flyt.SharedStore) (any, error) {
+			urls, ok := shared.Get("feed_urls")
+			if !ok {
+				return nil, fmt.Errorf("no feeds found in shared store")
+			}
+			state, ok := shared.Get("feed_state")
+			if !ok {
+				return nil, fmt.Errorf("no feed state found in shared store")
+			}
+			maxPerFeed, _ := shared.Get("feed_max_items")
+			return map[string]any{"urls": urls, "state": state, "max": maxPerFeed}, nil
+		}),
+		flyt.WithExecFunc(func(ctx context.Context, prepResult any) (any, error) {
+			data := prepResult.(map[string]any)
+			state := data["state"].(*utils.FeedState)
+			maxPerFeed, _ := data["max"].(int)
+
+			var items []any
+			for _, url := range data["urls"].([]string) {
+				title, feedItems, err := utils.FetchFeed(url)
+				if err != nil {
+					utils.PrintWarning("%v", err)
+					continue
+				}
+				fresh := 0
+				for _, item := range feedItems {
+					if item.Feed == "" {
+						item.Feed = url
+					}
+					if state.IsSeen(item) || (maxPerFeed > 0 && fresh >= maxPerFeed) {
+						continue
+					}
+					items = append(items, item)
+					fresh++
+				}
+				utils.PrintStatus("📰 %s: %d new item(s)", title, fresh)
+			}
+			return items, nil
+		}),
+		flyt.WithPostFunc(func(ctx context.Context, shared *flyt.SharedStore, prepResult, execResult any) (flyt.Action, error) {
+			shared.Set("feed_items", execResult)
+			return flyt.DefaultAction, nil
+		}),
+	)
+}
+
+// CreateSummarizeFeedItemNode summarizes every new feed item concurrently
+func CreateSummarizeFeedItemNode() flyt.Node {
+	processFunc := func(ctx context.Context, item any) (any, error) {
+		entry := item.(utils.FeedItem)
+		prompt := fmt.Sprintf(`Summarize this article from %q in 2-3 sentences for a news digest. State the key facts plainly, without hype.
+
+Title: %s
+%s`, entry.Feed, entry.Title, TruncateString(entry.Content, 8000))
+
+		config := utils.NodeConfig("summarize_feed_item")
+		config.Priority = utils.PriorityBackground
+		summary, err := utils.CallLLMWithConfig(ctx, prompt, config, false)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", entry.Title, err)
+		}
+		return feedSummary{Item: entry, Summary: strings.TrimSpace(summary)}, nil
+	}
+
+	config := flyt.DefaultBatchConfig()
+	config.ItemsKey = "feed_items"
+	config.ResultsKey = "feed_summaries"
+	config.MaxConcurrency = 4
+	return flyt.NewBatchNodeWithConfig(processFunc, true, config)
+}
+
+// CreateFeedDigestNode writes the digest, grouped by feed, and marks its items as seen
+func CreateFeedDigestNode() flyt.Node {
+	return flyt.NewNode(
+		flyt.WithPrepFunc(func(ctx context.Context, shared *flyt.SharedStore) (any, error) {
+			results, _ := shared.Get("feed_summaries")
+			return results, nil
+		}),
+		flyt.WithExecFunc(func(ctx context.Context, prepResult any) (any, error) {
+			results, _ := prepResult.([]any)
+			if len(results) == 0 {
+				return map[string]any{"digest": "No new items.", "items": []utils.FeedItem{}}, nil
+			}
+
+			byFeed := map[string][]feedSummary{}
+			var feeds []string
+			var items []utils.FeedItem
+			var overview strings.Builder
+			for _, r := range results {
+				s := r.(feedSummary)
+				if _, ok := byFeed[s.Item.Feed]; !ok {
+					feeds = append(feeds, s.Item.Feed)
+				}
+				byFeed[s.Item.Feed] = append(byFeed[s.Item.Feed], s)
+				items = append(items, s.Item)
+				fmt.Fprintf(&overview, "- %s: %s\n", s.Item.Title, s.Summary)
+			}
+
+			utils.PrintStatus("🗞️  Writing the digest... CreateFeedDigestNode")
+			highlights, err := utils.CallLLMAs(ctx, "feed_digest", "Write 3-5 bullet points with the main themes and most important news across these summaries:\n\n"+overview.String())
+			if err != nil {
+				return nil, err
+			}
+
+			var b strings.Builder
+			fmt.Fprintf(&b, "# Digest — %s\n\n## Highlights\n%s\n", time.Now().Format("2006-01-02"), strings.TrimSpace(highlights))
+			for _, feed := range feeds {
+				fmt.Fprintf(&b, "\n## %s\n", feed)
+				for _, s := range byFeed[feed] {
+					fmt.Fprintf(&b, "\n**[%s](%s)**\n%s\n", s.Item.Title, s.Item.Link, s.Summary)
+				}
+			}
+			return map[string]any{"digest": b.String(), "items": items}, nil
+		}),
+		flyt.WithPostFunc(func(ctx context.Context, shared *flyt.SharedStore, prepResult, execResult any) (flyt.Action, error) {
+			result := execResult.(map[string]any)
+			shared.Set("answer", result["digest"])
+
+			state, _ := shared.Get("feed_state")
+			feedState := state.(*utils.FeedState)
+			feedState.MarkSeen(result["items"].([]utils.FeedItem))
+			if err := feedState.Save(); err != nil {
+				return flyt.DefaultAction, fmt.Errorf("failed to save feed state: %w", err)
+			}
+			return flyt.DefaultAction, nil
+		}),
+	)
+}
+
+// maxTranscriptChars bounds the transcript sent with a question; about two hours of speech.
+const maxTranscriptChars = 120000
+
+// calendarQuestionPattern spots questions about the user's availability or schedule.
+var calendarQuestionPattern = regexp.MustCompile(`(?i)\b(am i (free|busy|available)|free (time|slot)|availability|my (calendar|schedule|agenda|meetings?)|book (a|an|some) )`)
+
+// CreateRouteNode sends questions that a dedicated node handles better — a
+// pasted YouTube link, or a schedule question when a calendar is configured —
+// to that node, and everything else on.
+func CreateRouteNode() flyt.Node {
+	return flyt.NewNode(
+		flyt.WithPrepFunc(func(ctx context.Context, shared *flyt.SharedStore) (any, error) {
+			question, ok := shared.Get("question")
+			if !ok {
+				return nil, fmt.Errorf("no question found in shared store")
+			}
+			return map[string]any{"question": question, "allowed": allowedTools(shared)}, nil
+		}),
+		flyt.WithExecFunc(func(ctx context.Context, prepResult any) (any, error) {
+			data := prepResult.(map[string]any)
+			question := data["question"].(string)
+			allowed := data["allowed"].(func(string) bool)
+			if _, _, ok := utils.FindYouTubeURL(question); ok && allowed("youtube") {
+				return "youtube", nil
+			}
+			if _, ok := utils.CalendarFromEnv(); ok && allowed("calendar") && calendarQuestionPattern.MatchString(question) {
+				return "calendar", nil
+			}
+			return string(flyt.DefaultAction), nil
+		}),
+		flyt.WithPostFunc(func(ctx context.Context, shared *flyt.SharedStore, prepResult, execResult any) (flyt.Action, error) {
+			return flyt.Action(execResult.(string)), nil
+		}),
+	)
+}
+
+// CreateYouTubeAnswerNode answers questions about a pasted YouTube video from
+// its transcript, falling back to Gemini watching the video when there are no captions.
+// A bare link produces a chaptered summary.
+func CreateYouTubeAnswerNode() flyt.Node {
+	return flyt.NewNode(
+		flyt.WithPrepFunc(func(ctx context.Context, shared *flyt.SharedStore) (any, error) {
+			question, ok := shared.Get("question")
+			if !ok {
+				return nil, fmt.Errorf("no question found in shared store")
+			}
+			return map[string]any{
+				"question": question,
+				"history":  utils.GetHistory(shared).ForPrompt(),
+			}, nil
+		}),
+		flyt.WithExecFunc(func(ctx context.Context, prepResult any) (any, error) {
+			data := prepResult.(map[string]any)
+			question := data["question"].(string)
+			history := data["history"].([]utils.Conversation)
+
+			url, id, _ := utils.FindYouTubeURL(question)
+			task := strings.TrimSpace(strings.Replace(question, url, "", 1))
+			if task == "" {
+				task = "Summarize this video as chapters: a timestamp and a short title for each section, followed by a one-paragraph overview."
+			}
+			task += "\nCite timestamps (m:ss) for the moments you refer to."
+			if len(history) > 0 {
+				task = fmt.Sprintf("History:\n%s\n%s", utils.FormatHistory(history), task)
+			}
+
+			utils.PrintStatus("📺 Fetching the transcript of %s...", id)
+			transcript, err := utils.FetchYouTubeTranscript(id)
+			if err != nil {
+				utils.PrintWarning("No transcript (%v); letting the model watch the video instead", err)
+				watchURL := "https://www.youtube.com/watch?v=" + id
+				answer, err := utils.CallLLMWithVideo(ctx, task, watchURL, utils.NodeConfig("youtube_answer"))
+				if err != nil {
+					return nil, err
+				}
+				return map[string]any{
+					"answer":  answer,
+					"sources": []provenanceItem{{Kind: "tool", Title: "video watched by the model (no transcript)", Ref: watchURL}},
+				}, nil
+			}
+			transcript = TruncateString(transcript, maxTranscriptChars)
+			transcript = utils.GuardUntrusted("the video transcript", transcript)
+			answer, err := utils.CallLLMAs(ctx, "youtube_answer", fmt.Sprintf("Transcript of the YouTube video %s:\n%s\n\n%s", url, transcript, task))
+			if err != nil {
+				return nil, err
+			}
+			return map[string]any{
+				"answer":  answer,
+				"sources": []provenanceItem{{Kind: "tool", Title: "YouTube transcript", Ref: url, Text: transcript}},
+			}, nil
+		}),
+		flyt.WithPostFunc(func(ctx context.Context, shared *flyt.SharedStore, prepResult, execResult any) (flyt.Action, error) {
+			result := execResult.(map[string]any)
+			shared.Set("answer", result["answer"])
+			addProvenance(shared, result["sources"].([]provenanceItem)...)
+			q, _ := shared.Get("question")
+			conv := utils.Conversation{User: q.(string), AI: result["answer"]}
+
+			h := utils.GetHistory(shared)
+			h.Conversations = append(h.Conversations, conv)
+			saveHistory(shared, h)
+
+			return flyt.DefaultAction, nil
+		}),
+	)
+}
+
+// audioSegment is one piece of a long recording, transcribed and summarized as a batch item.
+type audioSegment struct {
+	Index       int
+	Total       int
+	Path        string
+	Offset      int
+	Checkpoint  string
+	Transcriber string
+	Question    string
+	Transcript  string
+}
+
+// CreateLoadAudioSegmentsNode splits the recording into segments, reusing earlier splits
+func CreateLoadAudioSegmentsNode() flyt.Node {
+	return flyt.NewNode(
+		flyt.WithPrepFunc(func(ctx context.Context, shared *flyt.SharedStore) (any, error) {
+			question, ok := shared.Get("question")
+			if !ok {
+				return nil, fmt.Errorf("no question found in shared store")
+			}
+			path, ok := shared.Get("audio_path")
+			if !ok {
+				return nil, fmt.Errorf("no audio file found in shared store")
+			}
+			transcriber, _ := shared.Get("audio_transcriber")
+			return map[string]any{"question": question, "path": path, "transcriber": transcriber}, nil
+		}),
+		flyt.WithExecFunc(func(ctx context.Context, prepResult any) (any, error) {
+			data := prepResult.(map[string]any)
+			path := data["path"].(string)
+			transcriber, _ := data["transcriber"].(string)
+
+			dir, err := utils.AudioCheckpointDir(path)
+			if err != nil {
+				return nil, err
+			}
+			paths, err := utils.SplitAudio(path, dir)
+			if err != nil {
+				return nil, err
+			}
+
+			segments := make([]any, len(paths))
+			for i, p := range paths {
+				segments[i] = audioSegment{
+					Index: i + 1, Total: len(paths), Path: p,
+					Offset:      i * utils.AudioSegmentSeconds,
+					Checkpoint:  strings.TrimSuffix(p, ".mp3") + "." + transcriber + ".txt",
+					Transcriber: transcriber,
+					Question:    data["question"].(string),
+				}
+			}
+			utils.PrintStatus("🎧 %s: %d segment(s) (checkpoints in %s)", path, len(segments), dir)
+			return segments, nil
+		}),
+		flyt.WithPostFunc(func(ctx context.Context, shared *flyt.SharedStore, prepResult, execResult any) (flyt.Action, error) {
+			shared.Set("audio_segments", execResult)
+			return flyt.DefaultAction, nil
+		}),
+	)
+}
+
+// CreateTranscribeSegmentNode transcribes segments concurrently. Each finished
+// transcript is checkpointed, so an interrupted run resumes where it stopped.
+func CreateTranscribeSegmentNode() flyt.Node {
+	processFunc := func(ctx context.Context, item any) (any, error) {
+		segment := item.(audioSegment)
+		if cached, err := os.ReadFile(segment.Checkpoint); err == nil {
+			segment.Transcript = string(cached)
+			return segment, nil
+		}
+
+		utils.PrintStatus("📝 Transcribing segment %d/%d...", segment.Index, segment.Total)
+		config := utils.NodeConfig("transcribe_segment")
+		config.Priority = utils.PriorityBackground
+		transcript, err := utils.TranscribeAudio(ctx, segment.Path, segment.Transcriber, config)
+		if err != nil {
+			return nil, fmt.Errorf("segment %d: %w", segment.Index, err)
+		}
+		segment.Transcript = utils.ShiftTimestamps(transcript, segment.Offset)
+		if err := os.WriteFile(segment.Checkpoint, []byte(segment.Transcript), 0644); err != nil {
+			utils.PrintWarning("Could not checkpoint segment %d: %v", segment.Index, err)
+		}
+		return segment, nil
+	}
+
+	config := flyt.DefaultBatchConfig()
+	config.ItemsKey = "audio_segments"
+	config.ResultsKey = "audio_transcripts"
+	config.MaxConcurrency = 4
+	return flyt.NewBatchNodeWithConfig(processFunc, true, config)
+}
+
+// CreateAudioMapNode takes timestamped notes on every transcribed segment concurrently
+func CreateAudioMapNode() flyt.Node {
+	processFunc := func(ctx context.Context, item any) (any, error) {
+		segment := item.(audioSegment)
+		prompt := fmt.Sprintf(`This is part %d of %d of a recording's transcript. The listener asks: %s
+
+Write concise notes on this part as bullet points: topics discussed, claims, decisions, names and numbers.
+Start every bullet with the timestamp ([m:ss] or [h:mm:ss]) where it is said. Keep anything relevant to the question.
+
+Transcript:
+%s`, segment.Index, segment.Total, segment.Question, segment.Transcript)
+
+		config := utils.NodeConfig("audio_map")
+		config.Priority = utils.PriorityBackground
+		notes, err := utils.CallLLMWithConfig(ctx, prompt, config, false)
+		if err != nil {
+			return nil, fmt.Errorf("segment %d: %w", segment.Index, err)
+		}
+		return fmt.Sprintf("Part %d/%d (from %s):\n%s", segment.Index, segment.Total, utils.FormatTimestamp(float64(segment.Offset)), notes), nil
+	}
+
+	config := flyt.DefaultBatchConfig()
+	config.ItemsKey = "audio_transcripts"
+	config.ResultsKey = "audio_notes"
+	config.MaxConcurrency = 4
+	return flyt.NewBatchNodeWithConfig(processFunc, true, config)
+}
+
+// CreateAudioReduceNode merges the per-segment notes into one timestamped summary
+func CreateAudioReduceNode() flyt.Node {
+	return flyt.NewNode(
+		flyt.WithPrepFunc(func(ctx context.Context, shared *flyt.SharedStore) (any, error) {
+			question, _ := shared.Get("question")
+			notes, ok := shared.Get("audio_notes")
+			if !ok {
+				return nil, fmt.Errorf("no audio notes found in shared store")
+			}
+			return map[string]any{"question": question, "notes": notes}, nil
+		}),
+		flyt.WithExecFunc(func(ctx context.Context, prepResult any) (any, error) {
+			data := prepResult.(map[string]any)
+			utils.PrintStatus("🔎 Combining notes... CreateAudioReduceNode")
+
+			var b strings.Builder
+			for _, n := range data["notes"].([]any) {
+				b.WriteString(fmt.Sprintf("%v\n\n", n))
+			}
+			prompt := fmt.Sprintf(`These are notes on consecutive parts of a long recording:
+
+%s
+Request: %s
+
+Answer the request from the notes. Unless asked otherwise, give a short overview, then the key points in order,
+each with its timestamp, then any action items or open questions. Keep the timestamps exactly as written.`, b.String(), data["question"])
+
+			return utils.CallLLMAs(ctx, "audio_reduce", prompt)
+		}),
+		flyt.WithPostFunc(func(ctx context.Context, shared *flyt.SharedStore, prepResult, execResult any) (flyt.Action, error) {
+			shared.Set("answer", execResult)
+			q, _ := shared.Get("question")
+			conv := utils.Conversation{User: q.(string), AI: execResult}
+
+			h := utils.GetHistory(shared)
+			h.Conversations = append(h.Conversations, conv)
+			saveHistory(shared, h)
+
+			return flyt.DefaultAction, nil
+		}),
+	)
+}
+
+// emailDraft is a reply the model drafted, waiting for the user's approval.
+type emailDraft struct {
+	Email utils.Email
+	Body  string
+}
+
+// maxEmailBodyChars bounds each message in the prompt; long newsletters are cut.
+const maxEmailBodyChars = 6000
+
+var draftMarkerPattern = regexp.MustCompile(`(?m)^=== DRAFT REPLY TO EMAIL (\d+) ===\s*$`)
+
+// CreateEmailAnswerNode answers questions about the fetched emails and drafts
+// replies when asked. Drafts are only proposed here; saving them is up to the caller.
+func CreateEmailAnswerNode() flyt.Node {
+	return flyt.NewNode(
+		flyt.WithPrepFunc(func(ctx context.Context, shared *flyt.SharedStore) (any, error) {
+			question, ok := shared.Get("question")
+			if !ok {
+				return nil, fmt.Errorf("no question found in shared store")
+			}
+			emails, ok := shared.Get("emails")
+			if !ok {
+				return nil, fmt.Errorf("no emails found in shared store")
+			}
+			return map[string]any{
+				"question": question,
+				"emails":   emails,
+				"history":  utils.GetHistory(shared).ForPrompt(),
+			}, nil
+		}),
+		flyt.WithExecFunc(func(ctx context.Context, prepResult any) (any, error) {
+			data := prepResult.(map[string]any)
+			emails := data["emails"].([]utils.Email)
+			history := data["history"].([]utils.Conversation)
+			utils.PrintStatus("📧 Reading %d email(s)... CreateEmailAnswerNode", len(emails))
+
+			prompt := fmt.Sprintf(`You are an email assistant. These are the user's recent emails, grouped by thread:
+
+%s
+`, utils.FormatEmails(emails, maxEmailBodyChars))
+			if len(history) > 0 {
+				prompt += fmt.Sprintf("History:\n%s\n", utils.FormatHistory(history))
+			}
+			prompt += fmt.Sprintf(`Request: %s
+
+Refer to emails by their number. If the request asks for a reply to be drafted, write your answer first,
+then end with a line "=== DRAFT REPLY TO EMAIL <number> ===" followed by only the body of the reply
+(greeting to sign-off, no subject or headers).`, data["question"])
+
+			reply, err := utils.CallLLMAs(ctx, "email_answer", prompt)
+			if err != nil {
+				return nil, err
+			}
+
+			var draft *emailDraft
+			if loc := draftMarkerPattern.FindStringSubmatchIndex(reply); loc != nil {
+				n, _ := strconv.Atoi(reply[loc[2]:loc[3]])
+				if n >= 1 && n <= len(emails) {
+					draft = &emailDraft{Email: emails[n-1], Body: strings.TrimSpace(reply[loc[1]:])}
+					reply = strings.TrimSpace(reply[:loc[0]]) + fmt.Sprintf("\n\n**Draft reply to email %d:**\n\n%s", n, draft.Body)
+				}
+			}
+			return map[string]any{"answer": reply, "draft": draft}, nil
+		}),
+		flyt.WithPostFunc(func(ctx context.Context, shared *flyt.SharedStore, prepResult, execResult any) (flyt.Action, error) {
+			result := execResult.(map[string]any)
+			shared.Set("answer", result["answer"])
+			shared.Set("email_draft", result["draft"])
+			q, _ := shared.Get("question")
+			conv := utils.Conversation{User: q.(string), AI: result["answer"]}
+
+			h := utils.GetHistory(shared)
+			h.Conversations = append(h.Conversations, conv)
+			saveHistory(shared, h)
+
+			return flyt.DefaultAction, nil
+		}),
+	)
+}
+
+// calendarLookahead is how far ahead availability questions look.
+const calendarLookahead = 14 * 24 * time.Hour
+
+// Working hours used to find free slots.
+const (
+	workDayStart = 9
+	workDayEnd   = 18
+)
+
+var createEventMarkerPattern = regexp.MustCompile(`(?m)^=== CREATE EVENT ===\s*$`)
+
+// CreateCalendarAnswerNode answers schedule questions from the user's real
+// calendar. It may propose an event, which the caller creates only after approval.
+func CreateCalendarAnswerNode() flyt.Node {
+	return flyt.NewNode(
+		flyt.WithPrepFunc(func(ctx context.Context, shared *flyt.SharedStore) (any, error) {
+			question, ok := shared.Get("question")
+			if !ok {
+				return nil, fmt.Errorf("no question found in shared store")
+			}
+			return map[string]any{
+				"question": question,
+				"history":  utils.GetHistory(shared).ForPrompt(),
+			}, nil
+		}),
+		flyt.WithExecFunc(func(ctx context.Context, prepResult any) (any, error) {
+			data := prepResult.(map[string]any)
+			history := data["history"].([]utils.Conversation)
+			source, _ := utils.CalendarFromEnv()
+
+			now := time.Now()
+			utils.PrintStatus("📅 Reading your calendar... CreateCalendarAnswerNode")
+			events, slots, calendarText, err := readCalendar(source, now)
+			if err != nil {
+				return nil, err
+			}
+
+			var b strings.Builder
+			fmt.Fprintf(&b, "Now: %s\n\nEvents in the next two weeks:\n%s", now.Format("Monday 2006-01-02 15:04 MST"), calendarText)
+			if len(history) > 0 {
+				fmt.Fprintf(&b, "\nHistory:\n%s", utils.FormatHistory(history))
+			}
+			fmt.Fprintf(&b, `
+Question: %s
+
+Answer from the calendar above only; do not invent events. When asked for free time, pick from the free slots.
+If the user asks to create or book an event, answer first, then end with a line "=== CREATE EVENT ===" followed by
+only a JSON object {"summary": "...", "start": "<RFC 3339 with offset>", "end": "<RFC 3339 with offset>"}.`, data["question"])
+
+			reply, err := utils.CallLLMAs(ctx, "calendar_answer", b.String())
+			if err != nil {
+				return nil, err
+			}
+
+			var proposal *utils.CalendarEvent
+			if loc := createEventMarkerPattern.FindStringIndex(reply); loc != nil {
+				var raw struct {
+					Summary string    `json:"summary"`
+					Start   time.Time `json:"start"`
+					End     time.Time `json:"end"`
+				}
+				if err := json.Unmarshal([]byte(utils.ExtractJSON(reply[loc[1]:])), &raw); err == nil && raw.End.After(raw.Start) {
+					proposal = &utils.CalendarEvent{Summary: raw.Summary, Start: raw.Start, End: raw.End}
+				}
+				reply = strings.TrimSpace(reply[:loc[0]])
+				if proposal != nil {
+					reply += fmt.Sprintf("\n\n**Proposed event:** %s, %s–%s", proposal.Summary, proposal.Start.Local().Format("Mon 2006-01-02 15:04"), proposal.End.Local().Format("15:04"))
+				}
+			}
+			return map[string]any{
+				"answer":  reply,
+				"event":   proposal,
+				"sources": []provenanceItem{{Kind: "tool", Title: fmt.Sprintf("calendar: %d events, %d free slots", len(events), len(slots)), Text: calendarText}},
+			}, nil
+		}),
+		flyt.WithPostFunc(func(ctx context.Context, shared *flyt.SharedStore, prepResult, execResult any) (flyt.Action, error) {
+			result := execResult.(map[string]any)
+			shared.Set("answer", result["answer"])
+			shared.Set("calendar_event", result["event"])
+			addProvenance(shared, result["sources"].([]provenanceItem)...)
+			q, _ := shared.Get("question")
+			conv := utils.Conversation{User: q.(string), AI: result["answer"]}
+
+			h := utils.GetHistory(shared)
+			h.Conversations = append(h.Conversations, conv)
+			saveHistory(shared, h)
+
+			return flyt.DefaultAction, nil
+		}),
+	)
+}
+
+// readCalendar lists the events of the next calendarLookahead and the free
+// slots between them, as text guarded as untrusted: event titles come from
+// whoever sent the invitation.
+func readCalendar(source utils.CalendarSource, now time.Time) ([]utils.CalendarEvent, []utils.TimeSlot, string, error) {
+	events, err := source.Events(now, now.Add(calendarLookahead))
+	if err != nil {
+		return nil, nil, "", err
+	}
+	slots := utils.FreeSlots(events, now, now.Add(calendarLookahead), workDayStart, workDayEnd, 30*time.Minute)
+
+	var b strings.Builder
+	for _, e := range events {
+		if e.AllDay {
+			fmt.Fprintf(&b, "- %s (all day): %s\n", e.Start.Format("Mon 2006-01-02"), e.Summary)
+		} else {
+			fmt.Fprintf(&b, "- %s–%s: %s\n", e.Start.Local().Format("Mon 2006-01-02 15:04"), e.End.Local().Format("15:04"), e.Summary)
+		}
+	}
+	fmt.Fprintf(&b, "\nFree slots of 30 minutes or more on weekdays between %d:00 and %d:00:\n", workDayStart, workDayEnd)
+	for _, slot := range slots {
+		fmt.Fprintf(&b, "- %s–%s (%s)\n", slot.Start.Local().Format("Mon 2006-01-02 15:04"), slot.End.Local().Format("15:04"), slot.End.Sub(slot.Start).Round(time.Minute))
+	}
+	return events, slots, utils.GuardUntrusted("your calendar", b.String()), nil
+}
+
+// CreateTicketDraftNode turns a described problem, or the conversation so far, into a structured ticket
+func CreateTicketDraftNode() flyt.Node {
+	return flyt.NewNode(
+		flyt.WithPrepFunc(func(ctx context.Context, shared *flyt.SharedStore) (any, error) {
+			request, _ := shared.Get("ticket_request")
+			return map[string]any{
+				"request": request,
+				"history": utils.GetHistory(shared).ForPrompt(),
+			}, nil
+		}),
+		flyt.WithExecFunc(func(ctx context.Context, prepResult any) (any, error) {
+			data := prepResult.(map[string]any)
+			request, _ := data["request"].(string)
+			history := data["history"].([]utils.Conversation)
+			if strings.TrimSpace(request) == "" && len(history) == 0 {
+				return nil, fmt.Errorf("describe the problem, or discuss it first and then run /ticket")
+			}
+			utils.PrintStatus("🎫 Drafting a ticket... CreateTicketDraftNode")
+
+			source := "Problem description: " + request
+			if len(history) > 0 {
+				source = fmt.Sprintf("Conversation:\n%s\n%s", utils.FormatHistory(history), source)
+			}
+			prompt := fmt.Sprintf(`%s
+
+Write an issue-tracker ticket for this. Reply with only a JSON object:
+{"title": "<short, specific, under 80 characters>", "type": "bug" | "task",
+ "summary": "<2-4 sentences of context>",
+ "steps": [<steps to reproduce, for bugs>], "expected": "<for bugs>", "actual": "<for bugs>",
+ "severity": "critical" | "major" | "minor" | "trivial",
+ "acceptance_criteria": [<testable statements that must hold when the ticket is done>]}
+Only use facts from the text above; leave out fields you cannot fill.`, source)
+
+			config := utils.NodeConfig("ticket_draft")
+			config.Format = utils.FormatJSON
+			reply, err := utils.CallLLMWithConfig(ctx, prompt, config, false)
+			if err != nil {
+				return nil, err
+			}
+			var ticket utils.Ticket
+			if err := json.Unmarshal([]byte(utils.ExtractJSON(reply)), &ticket); err != nil {
+				return nil, fmt.Errorf("ticket is not valid JSON: %w", err)
+			}
+			return ticket, nil
+		}),
+		flyt.WithPostFunc(func(ctx context.Context, shared *flyt.SharedStore, prepResult, execResult any) (flyt.Action, error) {
+			shared.Set("ticket", execResult)
+			return flyt.DefaultAction, nil
+		}),
+	)
+}
+
+// CreateFlashcardsNode turns the document in shared "flashcard_source" (a
+// text file, or a PDF or image sent as an attachment) or, without one, the
+// conversation into question-and-answer cards, using structured output.
+func CreateFlashcardsNode() flyt.Node {
+	return flyt.NewNode(
+		flyt.WithPrepFunc(func(ctx context.Context, shared *flyt.SharedStore) (any, error) {
+			source, _ := shared.Get("flashcard_source")
+			return map[string]any{
+				"source":  source,
+				"history": utils.GetHistory(shared).ForPrompt(),
+			}, nil
+		}),
+		flyt.WithExecFunc(func(ctx context.Context, prepResult any) (any, error) {
+			data := prepResult.(map[string]any)
+			path, _ := data["source"].(string)
+			history := data["history"].([]utils.Conversation)
+
+			var material string
+			var attachments []string
+			switch {
+			case path != "":
+				if _, err := utils.MIMETypeForPath(path); err == nil {
+					attachments = []string{path}
+					material = "The attached document."
+					break
+				}
+				text, err := os.ReadFile(path)
+				if err != nil {
+					return nil, err
+				}
+				if !utf8.Valid(text) {
+					return nil, fmt.Errorf("%s is not a text file (PDFs and images are sent as attachments)", path)
+				}
+				material = fmt.Sprintf("Document %s:\n\"\"\"\n%s\n\"\"\"", filepath.Base(path), text)
+			case len(history) > 0:
+				material = "Conversation:\n" + utils.FormatHistory(history)
+			default:
+				return nil, fmt.Errorf("ask a few questions first, or give a document: /flashcards notes.md")
+			}
+			utils.PrintStatus("🗂️ Writing flashcards... CreateFlashcardsNode")
+
+			prompt := fmt.Sprintf(`%s
+
+Write flashcards for studying the material above.
+- Each card tests one fact, definition or idea; the front is a question, the back its answer.
+- Keep backs short enough to recall from memory: a word, a phrase or one or two sentences.
+- Cover the important points rather than trivia; write between 5 and 30 cards depending on how much there is.
+- tags are one or two lowercase topic words per card.
+Only use what the material says.`, material)
+
+			config := utils.NodeConfig("flashcards")
+			config.Format = utils.FormatJSON
+			config.Schema = utils.FlashcardsSchema
+			var reply string
+			var err error
+			if len(attachments) > 0 {
+				reply, err = utils.CallLLMWithImagesConfig(ctx, prompt, attachments, config)
+			} else {
+				reply, err = utils.CallLLMWithConfig(ctx, prompt, config, false)
+			}
+			if err != nil {
+				return nil, err
+			}
+			return utils.ParseFlashcards(reply)
+		}),
+		flyt.WithPostFunc(func(ctx context.Context, shared *flyt.SharedStore, prepResult, execResult any) (flyt.Action, error) {
+			shared.Set("flashcards", execResult)
+			return flyt.DefaultAction, nil
+		}),
+	)
+}
+
+// quizNamespace is the knowledge-base namespace documents given to quiz mode are indexed under.
+const quizNamespace = "quiz"
+
+// CreateQuizIndexNode indexes the documents in shared "quiz_paths" into the
+// knowledge base and leaves the passages to quiz on, in random order, in
+// "quiz_passages": those of the documents, or without any, the whole index.
+func CreateQuizIndexNode() flyt.Node {
+	return flyt.NewNode(
+		flyt.WithPrepFunc(func(ctx context.Context, shared *flyt.SharedStore) (any, error) {
+			paths, _ := shared.Get("quiz_paths")
+			index, _ := shared.Get("kb_index")
+			return map[string]any{"paths": paths, "index": index}, nil
+		}),
+		flyt.WithExecFunc(func(ctx context.Context, prepResult any) (any, error) {
+			data := prepResult.(map[string]any)
+			paths, _ := data["paths"].([]string)
+			index := data["index"].(*utils.KBIndex)
+			docIDs := map[string]bool{}
+			for _, path := range paths {
+				content, err := os.ReadFile(path)
+				if err != nil {
+					return nil, err
+				}
+				if !utf8.Valid(content) {
+					return nil, fmt.Errorf("%s is not a text file", path)
+				}
+				id, err := filepath.Abs(path)
+				if err != nil {
+					return nil, err
+				}
+				utils.PrintStatus("📚 Indexing %s...", path)
+				doc := utils.KBDocument{ID: id, Title: filepath.Base(path), URL: id, Content: string(content), Modified: time.Now()}
+				if err := index.Upsert(ctx, quizNamespace, doc); err != nil {
+					return nil, err
+				}
+				docIDs[id] = true
+			}
+			if len(paths) > 0 {
+				if err := index.Save(); err != nil {
+					return nil, err
+				}
+			}
+
+			var passages []utils.KBChunk
+			for _, c := range index.Chunks {
+				// Very short chunks (a heading, a stray line) make poor questions.
+				if (len(docIDs) == 0 || docIDs[c.DocID]) && len(c.Text) >= 200 {
+					passages = append(passages, c)
+				}
+			}
+			if len(passages) == 0 {
+				return nil, fmt.Errorf("nothing to quiz on: give a document, or index one with the kb subcommand")
+			}
+			rand.Shuffle(len(passages), func(i, j int) { passages[i], passages[j] = passages[j], passages[i] })
+			return passages, nil
+		}),
+		flyt.WithPostFunc(func(ctx context.Context, shared *flyt.SharedStore, prepResult, execResult any) (flyt.Action, error) {
+			shared.Set("quiz_passages", execResult)
+			shared.Set("quiz_score", utils.QuizScore{})
+			return flyt.DefaultAction, nil
+		}),
+	)
+}
+
+// CreateQuizQuestionNode asks the model for a question on the next passage
+// and leaves it in shared "quiz_question", with the passage in "quiz_passage".
+func CreateQuizQuestionNode() flyt.Node {
+	return flyt.NewNode(
+		flyt.WithPrepFunc(func(ctx context.Context, shared *flyt.SharedStore) (any, error) {
+			value, _ := shared.Get("quiz_passages")
+			passages := value.([]utils.KBChunk)
+			score, _ := shared.Get("quiz_score")
+			return passages[score.(utils.QuizScore).Asked%len(passages)], nil
+		}),
+		flyt.WithExecFunc(func(ctx context.Context, prepResult any) (any, error) {
+			passage := prepResult.(utils.KBChunk)
+			utils.PrintStatus("❓ Writing a question... CreateQuizQuestionNode")
+			prompt := fmt.Sprintf(`Passage from %s:
+"""
+%s
+"""
+
+Write one quiz question about the most important idea in this passage. It should make the student explain or apply
+the idea in a sentence or two, not recall a trivial detail, and be answerable from the passage alone.
+answer is the answer the passage supports.`, passage.Label(), passage.Text)
+
+			config := utils.NodeConfig("quiz_question")
+			config.Format = utils.FormatJSON
+			config.Schema = utils.QuizQuestionSchema
+			reply, err := utils.CallLLMWithConfig(ctx, prompt, config, false)
+			if err != nil {
+				return nil, err
+			}
+			var question utils.QuizQuestion
+			if err := utils.DecodeStructured(reply, utils.QuizQuestionSchema, &question); err != nil {
+				return nil, err
+			}
+			return question, nil
+		}),
+		flyt.WithPostFunc(func(ctx context.Context, shared *flyt.SharedStore, prepResult, execResult any) (flyt.Action, error) {
+			shared.Set("quiz_passage", prepResult)
+			shared.Set("quiz_question", execResult)
+			return flyt.DefaultAction, nil
+		}),
+	)
+}
+
+// CreateQuizGradeNode grades the answer in shared "quiz_answer" against the
+// passage of the question and leaves the verdict in "quiz_grade". Earlier
+// tries at the same question ("quiz_attempts") are shown to the grader, so
+// its follow-up questions lead on from them.
+func CreateQuizGradeNode() flyt.Node {
+	return flyt.NewNode(
+		flyt.WithPrepFunc(func(ctx context.Context, shared *flyt.SharedStore) (any, error) {
+			passage, _ := shared.Get("quiz_passage")
+			question, _ := shared.Get("quiz_question")
+			answer, _ := shared.Get("quiz_answer")
+			attempts, _ := shared.Get("quiz_attempts")
+			return map[string]any{"passage": passage, "question": question, "answer": answer, "attempts": attempts}, nil
+		}),
+		flyt.WithExecFunc(func(ctx context.Context, prepResult any) (any, error) {
+			data := prepResult.(map[string]any)
+			passage := data["passage"].(utils.KBChunk)
+			question := data["question"].(utils.QuizQuestion)
+			attempts, _ := data["attempts"].([]string)
+			utils.PrintStatus("📝 Grading... CreateQuizGradeNode")
+
+			var earlier strings.Builder
+			for i, a := range attempts {
+				fmt.Fprintf(&earlier, "Try %d: %s\n", i+1, a)
+			}
+			prompt := fmt.Sprintf(`You are a Socratic tutor grading a student's answer against the source passage.
+
+Passage:
+"""
+%s
+"""
+
+Question: %s
+Expected answer: %s
+%s
+Student's answer: %s
+
+verdict is correct when the answer gets the idea right in any wording, partial when it is on the way but misses or
+confuses part of it, incorrect otherwise. Judge only by the passage. feedback says briefly what was right and what
+was missing, without giving the answer away unless the verdict is correct. For partial and incorrect answers,
+follow_up is one guiding question that leads the student toward what they missed.`,
+				passage.Text, question.Question, question.Answer, earlier.String(), data["answer"])
+
+			config := utils.NodeConfig("quiz_grade")
+			config.Format = utils.FormatJSON
+			config.Schema = utils.QuizGradeSchema
+			reply, err := utils.CallLLMWithConfig(ctx, prompt, config, false)
+			if err != nil {
+				return nil, err
+			}
+			var grade utils.QuizGrade
+			if err := utils.DecodeStructured(reply, utils.QuizGradeSchema, &grade); err != nil {
+				return nil, err
+			}
+			return grade, nil
+		}),
+		flyt.WithPostFunc(func(ctx context.Context, shared *flyt.SharedStore, prepResult, execResult any) (flyt.Action, error) {
+			shared.Set("quiz_grade", execResult)
+			return flyt.DefaultAction, nil
+		}),
+	)
+}
+
+// kbResults and kbMinScore bound what retrieval adds to a prompt.
+const (
+	kbResults  = 5
+	kbMinScore = 0.55
+)
+
+// CreateRetrieveNode looks up the question in the knowledge-base index, when
+// one is loaded, and leaves the most relevant passages for the answer node.
+func CreateRetrieveNode() flyt.Node {
+	return flyt.NewNode(
+		flyt.WithPrepFunc(func(ctx context.Context, shared *flyt.SharedStore) (any, error) {
+			question, ok := shared.Get("question")
+			if !ok {
+				return nil, fmt.Errorf("no question found in shared store")
+			}
+			index, _ := shared.Get("kb_index")
+			namespaces, _ := shared.Get("kb_namespaces")
+			return map[string]any{"question": question, "index": index, "namespaces": namespaces}, nil
+		}),
+		flyt.WithExecFunc(func(ctx context.Context, prepResult any) (any, error) {
+			data := prepResult.(map[string]any)
+			index, ok := data["index"].(*utils.KBIndex)
+			if !ok || index == nil {
+				return map[string]any{"text": ""}, nil
+			}
+			namespaces, _ := data["namespaces"].([]string)
+			results, err := index.Search(ctx, data["question"].(string), kbResults, namespaces...)
+			if err != nil {
+				utils.PrintWarning("Knowledge-base search failed: %v", err)
+				return map[string]any{"text": ""}, nil
+			}
+
+			var b strings.Builder
+			var sources []provenanceItem
+			for _, r := range results {
+				if r.Score < kbMinScore {
+					break
+				}
+				sources = append(sources, provenanceItem{Kind: "rag", Title: r.Label(), Ref: r.Namespace + " " + r.URL, Score: r.Score, Text: r.Text})
+				fmt.Fprintf(&b, "[%d] %s (%s) %s\n%s\n\n", len(sources), r.Label(), r.Namespace, r.URL, r.Text)
+			}
+			if len(sources) > 0 {
+				utils.PrintStatus("📚 Found %d relevant passage(s) in the knowledge base", len(sources))
+			}
+			return map[string]any{"text": b.String(), "sources": sources}, nil
+		}),
+		flyt.WithPostFunc(func(ctx context.Context, shared *flyt.SharedStore, prepResult, execResult any) (flyt.Action, error) {
+			result := execResult.(map[string]any)
+			shared.Set("retrieved", result["text"])
+			sources, _ := result["sources"].([]provenanceItem)
+			addProvenance(shared, sources...)
+			return flyt.DefaultAction, nil
+		}),
+	)
+}
+
+// planRiskRank orders plan risks, highest first.
+var planRiskRank = map[string]int{"high": 0, "medium": 1, "low": 2}
+
+// planGroup is the changes to one resource type in a terraform plan.
+type planGroup struct {
+	Type    string
+	Changes []utils.ResourceChange
+}
+
+// planFinding is the assessed risk of one resource change.
+type planFinding struct {
+	Address     string   `json:"address"`
+	Type        string   `json:"type"`
+	Action      string   `json:"action"`
+	Destructive bool     `json:"destructive"`
+	Risk        string   `json:"risk"`
+	Reason      string   `json:"reason"`
+	Attributes  []string `json:"changed_attributes,omitempty"`
+}
+
+// planReport is the structured result of the plan explanation, for CI gating.
+type planReport struct {
+	TerraformVersion string            `json:"terraform_version"`
+	Counts           map[string]int    `json:"counts"`
+	Destructive      int               `json:"destructive"`
+	MaxRisk          string            `json:"max_risk"`
+	Summaries        map[string]string `json:"summaries"` // by resource type
+	Changes          []planFinding     `json:"changes"`
+}
+
+// CreateLoadPlanNode parses the terraform JSON plan in "plan_json" and groups its changes by resource type
+func CreateLoadPlanNode() flyt.Node {
+	return flyt.NewNode(
+		flyt.WithPrepFunc(func(ctx context.Context, shared *flyt.SharedStore) (any, error) {
+			data, ok := shared.Get("plan_json")
+			if !ok {
+				return nil, fmt.Errorf("no plan found in shared store")
+			}
+			return data, nil
+		}),
+		flyt.WithExecFunc(func(ctx context.Context, prepResult any) (any, error) {
+			plan, err := utils.ParseTerraformPlan(prepResult.([]byte))
+			if err != nil {
+				return nil, err
+			}
+			byType := plan.Changes()
+			types := make([]string, 0, len(byType))
+			for t := range byType {
+				types = append(types, t)
+			}
+			sort.Strings(types)
+			groups := make([]any, len(types))
+			for i, t := range types {
+				groups[i] = planGroup{Type: t, Changes: byType[t]}
+			}
+			utils.PrintStatus("📋 Plan changes %d resource type(s)", len(groups))
+			return map[string]any{"plan": plan, "groups": groups}, nil
+		}),
+		flyt.WithPostFunc(func(ctx context.Context, shared *flyt.SharedStore, prepResult, execResult any) (flyt.Action, error) {
+			result := execResult.(map[string]any)
+			shared.Set("terraform_plan", result["plan"])
+			shared.Set("plan_groups", result["groups"])
+			return flyt.DefaultAction, nil
+		}),
+	)
+}
+
+// CreatePlanRiskNode assesses the changes to every resource type concurrently
+func CreatePlanRiskNode() flyt.Node {
+	processFunc := func(ctx context.Context, item any) (any, error) {
+		group := item.(planGroup)
+		var changes strings.Builder
+		for _, rc := range group.Changes {
+			changes.WriteString(rc.Describe() + "\n")
+		}
+		prompt := fmt.Sprintf(`You are reviewing a terraform plan before it is applied. These are the planned changes to %s resources (attribute values are omitted):
+%s
+Rate the operational risk of each change: "high" for data loss, downtime, or weakened security (deleting or replacing stateful resources, opening network access, changing IAM); "medium" for changes that may disrupt service; "low" otherwise.
+Reply with only a JSON object:
+{"summary": "<one or two sentences on what these changes do>",
+ "changes": [{"address": "<address>", "risk": "high" | "medium" | "low", "reason": "<why, in one sentence>"}]}`, group.Type, changes.String())
+
+		config := utils.NodeConfig("plan_risk")
+		config.Priority = utils.PriorityBackground
+		config.Format = utils.FormatJSON
+		reply, err := utils.CallLLMWithConfig(ctx, prompt, config, false)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", group.Type, err)
+		}
+		var assessment struct {
+			Summary string `json:"summary"`
+			Changes []struct {
+				Address string `json:"address"`
+				Risk    string `json:"risk"`
+				Reason  string `json:"reason"`
+			} `json:"changes"`
+		}
+		if err := json.Unmarshal([]byte(utils.ExtractJSON(reply)), &assessment); err != nil {
+			return nil, fmt.Errorf("%s: assessment is not valid JSON: %w", group.Type, err)
+		}
+
+		// Every change gets a finding, whatever the model left out; destruction is never low risk.
+		findings := make([]planFinding, len(group.Changes))
+		for i, rc := range group.Changes {
+			findings[i] = planFinding{Address: rc.Address, Type: rc.Type, Action: rc.Action(), Destructive: rc.Destructive(), Risk: "medium", Attributes: rc.ChangedAttributes()}
+			for _, a := range assessment.Changes {
+				if _, known := planRiskRank[a.Risk]; a.Address == rc.Address && known {
+					findings[i].Risk, findings[i].Reason = a.Risk, a.Reason
+				}
+			}
+			if findings[i].Destructive && findings[i].Risk == "low" {
+				findings[i].Risk = "medium"
+			}
+		}
+		return map[string]any{"type": group.Type, "summary": assessment.Summary, "findings": findings}, nil
+	}
+
+	config := flyt.DefaultBatchConfig()
+	config.ItemsKey = "plan_groups"
+	config.ResultsKey = "plan_assessments"
+	config.MaxConcurrency = 4
+	return flyt.NewBatchNodeWithConfig(processFunc, true, config)
+}
+
+// CreatePlanReportNode ranks the findings by risk, destructive changes first,
+// and builds the markdown report and its structured form
+func CreatePlanReportNode() flyt.Node {
+	return flyt.NewNode(
+		flyt.WithPrepFunc(func(ctx context.Context, shared *flyt.SharedStore) (any, error) {
+			plan, _ := shared.Get("terraform_plan")
+			results, _ := shared.Get("plan_assessments")
+			return map[string]any{"plan": plan, "results": results}, nil
+		}),
+		flyt.WithExecFunc(func(ctx context.Context, prepResult any) (any, error) {
+			data := prepResult.(map[string]any)
+			plan := data["plan"].(*utils.TerraformPlan)
+			results, _ := data["results"].([]any)
+
+			report := planReport{TerraformVersion: plan.TerraformVersion, Counts: map[string]int{}, MaxRisk: "none", Summaries: map[string]string{}}
+			for _, r := range results {
+				assessment := r.(map[string]any)
+				report.Summaries[assessment["type"].(string)] = assessment["summary"].(string)
+				report.Changes = append(report.Changes, assessment["findings"].([]planFinding)...)
+			}
+			sort.SliceStable(report.Changes, func(i, j int) bool {
+				a, b := report.Changes[i], report.Changes[j]
+				if planRiskRank[a.Risk] != planRiskRank[b.Risk] {
+					return planRiskRank[a.Risk] < planRiskRank[b.Risk]
+				}
+				return a.Destructive && !b.Destructive
+			})
+			for _, f := range report.Changes {
+				report.Counts[f.Action]++
+				if f.Destructive {
+					report.Destructive++
+				}
+			}
+			if len(report.Changes) > 0 {
+				report.MaxRisk = report.Changes[0].Risk
+			}
+
+			var b strings.Builder
+			fmt.Fprintf(&b, "## Terraform plan: %d change(s), highest risk %s\n", len(report.Changes), report.MaxRisk)
+			for _, action := range []string{"create", "update", "replace", "delete"} {
+				if n := report.Counts[action]; n > 0 {
+					fmt.Fprintf(&b, "- %s: %d\n", action, n)
+				}
+			}
+			if report.Destructive > 0 {
+				fmt.Fprintf(&b, "\n**⚠️ %d destructive change(s): resources will be deleted or replaced.**\n", report.Destructive)
+			}
+			if len(report.Changes) == 0 {
+				b.WriteString("\nNo infrastructure changes.\n")
+				return map[string]any{"markdown": b.String(), "report": report}, nil
+			}
+			b.WriteString("\n### Changes by risk\n")
+			for _, f := range report.Changes {
+				marker := ""
+				if f.Destructive {
+					marker = " ⚠️"
+				}
+				fmt.Fprintf(&b, "- **%s** `%s` %s%s: %s\n", f.Risk, f.Address, f.Action, marker, f.Reason)
+			}
+			types := make([]string, 0, len(report.Summaries))
+			for t := range report.Summaries {
+				types = append(types, t)
+			}
+			sort.Strings(types)
+			b.WriteString("\n### By resource type\n")
+			for _, t := range types {
+				fmt.Fprintf(&b, "- `%s`: %s\n", t, report.Summaries[t])
+			}
+			return map[string]any{"markdown": b.String(), "report": report}, nil
+		}),
+		flyt.WithPostFunc(func(ctx context.Context, shared *flyt.SharedStore, prepResult, execResult any) (flyt.Action, error) {
+			result := execResult.(map[string]any)
+			shared.Set("answer", result["markdown"])
+			shared.Set("plan_report", result["report"])
+			return flyt.DefaultAction, nil
+		}),
+	)
+}
+
+// securityChunk is a part of a source file for the model to review, with
+// the static findings in it as hints.
+type securityChunk struct {
+	Path      string
+	Symbol    string
+	StartLine int
+	EndLine   int
+	Numbered  string
+	Static    []utils.SecurityFinding
+}
+
+// securityChunkChars bounds each security review request; larger files are split along declarations.
+const securityChunkChars = 16000
+
+// CreateLoadSecurityFilesNode reads the source files under "security_paths",
+// runs the static checks on each, and splits them into chunks to review
+func CreateLoadSecurityFilesNode() flyt.Node {
+	return flyt.NewNode(
+		flyt.WithPrepFunc(func(ctx context.Context, shared *flyt.SharedStore) (any, error) {
+			paths, _ := shared.Get("security_paths")
+			return paths, nil
+		}),
+		flyt.WithExecFunc(func(ctx context.Context, prepResult any) (any, error) {
+
+			var chunks []any
+			var static []utils.SecurityFinding
+			files := 0
+			add := func(path, language string, content []byte) {
+				files++
+				findings := utils.StaticSecurityScan(path, language, string(content))
+				static = append(static, findings...)
+				for _, c := range utils.ChunkCode(string(content), language, securityChunkChars) {
+					chunk := securityChunk{Path: path, Symbol: c.Symbol, StartLine: c.StartLine, EndLine: c.EndLine}
+					var numbered strings.Builder
+					for i, line := range strings.Split(strings.TrimSuffix(c.Text, "\n"), "\n") {
+						fmt.Fprintf(&numbered, "L%d: %s\n", c.StartLine+i, line)
+					}
+					chunk.Numbered = numbered.String()
+					for _, f := range findings {
+						if f.Line >= c.StartLine && f.Line <= c.EndLine {
+							chunk.Static = append(chunk.Static, f)
+						}
+					}
+					chunks = append(chunks, chunk)
+				}
+			}
+			for _, root := range prepResult.([]string) {
+				info, err := os.Stat(root)
+				if err != nil {
+					return nil, err
+				}
+				if info.IsDir() {
+					if err := utils.WalkSourceFiles(root, add); err != nil {
+						return nil, err
+					}
+					continue
+				}
+				language := utils.CodeLanguage(root)
+				if language == "" {
+					utils.PrintWarning("Skipping %s: not a source file", root)
+					continue
+				}
+				content, err := os.ReadFile(root)
+				if err != nil {
+					return nil, err
+				}
+				add(root, language, content)
+			}
+			utils.PrintStatus("🛡️  %d file(s), %d static finding(s), %d chunk(s) to review", files, len(static), len(chunks))
+			return map[string]any{"chunks": chunks, "static": static}, nil
+		}),
+		flyt.WithPostFunc(func(ctx context.Context, shared *flyt.SharedStore, prepResult, execResult any) (flyt.Action, error) {
+			result := execResult.(map[string]any)
+			shared.Set("security_chunks", result["chunks"])
+			shared.Set("security_static", result["static"])
+			return flyt.DefaultAction, nil
+		}),
+	)
+}
+
+// CreateSecurityReviewFileNode has the model review every chunk concurrently, pointed at the static findings
+func CreateSecurityReviewFileNode() flyt.Node {
+	processFunc := func(ctx context.Context, item any) (any, error) {
+		chunk := item.(securityChunk)
+		hints := "none"
+		if len(chunk.Static) > 0 {
+			var b strings.Builder
+			for _, f := range chunk.Static {
+				fmt.Fprintf(&b, "\n- L%d %s: %s", f.Line, f.RuleID, f.Message)
+			}
+			hints = b.String()
+		}
+		prompt := fmt.Sprintf(`You are doing a security review of %s (lines %d-%d). Each line is prefixed with its line number (L<number>).
+Local pattern checks flagged these lines; confirm or dismiss each, explaining why: %s
+
+Look for injection, broken authentication or authorization, secrets in code, unsafe deserialization, path traversal, SSRF, insecure crypto or TLS, and missing input validation. Skip style and general bugs.
+Reply with only a JSON array (empty if there is nothing exploitable) of objects:
+{"line": <line number>, "severity": "high" | "medium" | "low", "rule": "<short kebab-case category, e.g. sql-injection>", "message": "<the problem and how to fix it>"}
+
+%s`, chunk.Path, chunk.StartLine, chunk.EndLine, hints, chunk.Numbered)
+
+		config := utils.NodeConfig("security_review_file")
+		config.Priority = utils.PriorityBackground
+		config.Format = utils.FormatJSON
+		reply, err := utils.CallLLMWithConfig(ctx, prompt, config, false)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", chunk.Path, err)
+		}
+		var found []struct {
+			Line     int    `json:"line"`
+			Severity string `json:"severity"`
+			Rule     string `json:"rule"`
+			Message  string `json:"message"`
+		}
+		if err := json.Unmarshal([]byte(utils.ExtractJSON(reply)), &found); err != nil {
+			return nil, fmt.Errorf("%s: review is not valid JSON: %w", chunk.Path, err)
+		}
+		var findings []utils.SecurityFinding
+		for _, f := range found {
+			if f.Line < chunk.StartLine || f.Line > chunk.EndLine || strings.TrimSpace(f.Message) == "" {
+				continue
+			}
+			switch f.Severity {
+			case "high", "medium", "low":
+			default:
+				f.Severity = "medium"
+			}
+			findings = append(findings, utils.SecurityFinding{RuleID: "llm/" + f.Rule, Path: chunk.Path, Line: f.Line, Severity: f.Severity, Message: f.Message, Source: "llm"})
+		}
+		return findings, nil
+	}
+
+	config := flyt.DefaultBatchConfig()
+	config.ItemsKey = "security_chunks"
+	config.ResultsKey = "security_reviews"
+	config.MaxConcurrency = 4
+	return flyt.NewBatchNodeWithConfig(processFunc, true, config)
+}
+
+// CreateSecurityReportNode merges the static and model findings, drops
+// duplicates, and builds the markdown report and the SARIF log
+func CreateSecurityReportNode() flyt.Node {
+	return flyt.NewNode(
+		flyt.WithPrepFunc(func(ctx context.Context, shared *flyt.SharedStore) (any, error) {
+			static, _ := shared.Get("security_static")
+			results, _ := shared.Get("security_reviews")
+			return map[string]any{"static": static, "results": results}, nil
+		}),
+		flyt.WithExecFunc(func(ctx context.Context, prepResult any) (any, error) {
+			data := prepResult.(map[string]any)
+			findings, _ := data["static"].([]utils.SecurityFinding)
+			results, _ := data["results"].([]any)
+			for _, r := range results {
+				findings = append(findings, r.([]utils.SecurityFinding)...)
+			}
+			findings = utils.DedupeFindings(findings)
+
+			sarif, err := utils.SARIF(findings, "ai-security-review")
+			if err != nil {
+				return nil, err
+			}
+			counts := map[string]int{}
+			for _, f := range findings {
+				counts[f.Severity]++
+			}
+			var b strings.Builder
+			fmt.Fprintf(&b, "## Security review: %d finding(s) (%d high, %d medium, %d low)\n", len(findings), counts["high"], counts["medium"], counts["low"])
+			lastSeverity := ""
+			for _, f := range findings {
+				if f.Severity != lastSeverity {
+					fmt.Fprintf(&b, "\n### %s\n", strings.ToUpper(f.Severity[:1])+f.Severity[1:])
+					lastSeverity = f.Severity
+				}
+				fmt.Fprintf(&b, "- `%s:%d` **%s** (%s): %s\n", f.Path, f.Line, f.RuleID, f.Source, f.Message)
+			}
+			return map[string]any{"report": b.String(), "findings": findings, "sarif": sarif}, nil
+		}),
+		flyt.WithPostFunc(func(ctx context.Context, shared *flyt.SharedStore, prepResult, execResult any) (flyt.Action, error) {
+			result := execResult.(map[string]any)
+			shared.Set("answer", result["report"])
+			shared.Set("security_findings", result["findings"])
+			shared.Set("security_sarif", result["sarif"])
+			return flyt.DefaultAction, nil
+		}),
+	)
+}
+
+// releaseNoteStyles are the built-in release-note styles; -style also takes a
+// file with instructions of its own.
+var releaseNoteStyles = map[string]string{
+	"keepachangelog": "Keep a Changelog format: a \"## [<version>] - <date>\" heading, then \"### Added\", \"### Changed\", \"### Fixed\", \"### Removed\" and \"### Security\" sections as needed, one terse bullet per change.",
+	"github":         "GitHub release format: a one-paragraph highlight of the most important changes, then \"## What's Changed\" with a \"### <section>\" per group, one bullet per change written for users.",
+	"compact":        "A single flat bullet list, most important changes first, each bullet one short sentence. No headings.",
+}
+
+// CreateLoadReleaseCommitsNode reads the commits between "release_from" and
+// "release_to" in "release_repo", groups them by type, and builds the drafting prompt
+func CreateLoadReleaseCommitsNode() flyt.Node {
+	return flyt.NewNode(
+		flyt.WithPrepFunc(func(ctx context.Context, shared *flyt.SharedStore) (any, error) {
+			to, ok := shared.Get("release_to")
+			if !ok {
+				return nil, fmt.Errorf("no release range found in shared store")
+			}
+			repo, _ := shared.Get("release_repo")
+			from, _ := shared.Get("release_from")
+			style, _ := shared.Get("release_style")
+			dir, _ := repo.(string)
+			if dir == "" {
+				dir = "."
+			}
+			fromRef, _ := from.(string)
+			styleText, _ := style.(string)
+			if styleText == "" {
+				styleText = releaseNoteStyles["github"]
+			}
+			return map[string]string{"repo": dir, "from": fromRef, "to": to.(string), "style": styleText}, nil
+		}),
+		flyt.WithExecFunc(func(ctx context.Context, prepResult any) (any, error) {
+			data := prepResult.(map[string]string)
+			commits, err := utils.LoadGitCommits(data["repo"], data["from"], data["to"])
+			if err != nil {
+				return nil, err
+			}
+			rangeName := data["to"]
+			if data["from"] != "" {
+				rangeName = data["from"] + ".." + data["to"]
+			}
+			utils.PrintStatus("📋 %d change(s) in %s", len(commits), rangeName)
+			if len(commits) == 0 {
+				return map[string]any{"commits": commits}, nil
+			}
+
+			groups := utils.GroupCommits(commits)
+			var changes strings.Builder
+			for _, section := range utils.ChangeTypes {
+				if len(groups[section.Type]) == 0 {
+					continue
+				}
+				fmt.Fprintf(&changes, "%s:\n", section.Heading)
+				for _, c := range groups[section.Type] {
+					fmt.Fprintf(&changes, "- %s", c.Short)
+					if c.PR != "" {
+						fmt.Fprintf(&changes, " #%s", c.PR)
+					}
+					fmt.Fprintf(&changes, " %s (%s)\n", c.Subject, c.Author)
+					if c.Body != "" {
+						fmt.Fprintf(&changes, "  %s\n", strings.ReplaceAll(TruncateString(c.Body, 400), "\n", "\n  "))
+					}
+				}
+			}
+
+			prompt := fmt.Sprintf(`Draft release notes for %s from these changes, already grouped by type (short commit hash, pull request if any, subject, author):
+
+%s
+Style: %s
+
+Rules:
+- Every bullet describes changes from the list and ends with the short hash of each commit it covers in parentheses, e.g. "(%s)"; cite the pull request too when there is one, e.g. "(#12, %s)".
+- Never cite a hash or pull request that is not in the list, and never describe a change that is not in the list.
+- You may merge related commits into one bullet and leave out changes users do not notice (tests, CI, formatting).
+- Return only the release notes as markdown, without code fences.`,
+				rangeName, changes.String(), data["style"], commits[0].Short, commits[0].Short)
+			return map[string]any{"commits": commits, "prompt": prompt}, nil
+		}),
+		flyt.WithPostFunc(func(ctx context.Context, shared *flyt.SharedStore, prepResult, execResult any) (flyt.Action, error) {
+			result := execResult.(map[string]any)
+			commits := result["commits"].([]utils.GitCommit)
+			shared.Set("release_commits", commits)
+			if len(commits) == 0 {
+				shared.Set("candidate", "No changes.")
+				return "empty", nil
+			}
+			shared.Set("generate_prompt", result["prompt"])
+			return flyt.DefaultAction, nil
+		}),
+	)
+}
+
+// CreateVerifyReleaseNotesNode checks that every bullet of the drafted notes
+// maps to a commit in the range, routing to "retry" like CreateValidateCandidateNode
+func CreateVerifyReleaseNotesNode(maxAttempts int) flyt.Node {
+	return flyt.NewNode(
+		flyt.WithPrepFunc(func(ctx context.Context, shared *flyt.SharedStore) (any, error) {
+			candidate, ok := shared.Get("candidate")
+			if !ok {
+				return nil, fmt.Errorf("no candidate found in shared store")
+			}
+			commits, _ := shared.Get("release_commits")
+			return map[string]any{"notes": candidate, "commits": commits}, nil
+		}),
+		flyt.WithExecFunc(func(ctx context.Context, prepResult any) (any, error) {
+			data := prepResult.(map[string]any)
+			commits, _ := data["commits"].([]utils.GitCommit)
+			if err := utils.VerifyReleaseNotes(data["notes"].(string), commits); err != nil {
+				return err.Error(), nil
+			}
+			return "", nil
+		}),
+		flyt.WithPostFunc(func(ctx context.Context, shared *flyt.SharedStore, prepResult, execResult any) (flyt.Action, error) {
+			return validationAction(shared, execResult.(string), maxAttempts), nil
+		}),
+	)
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"strings"
+
+	"flyt-project-template/utils"
+
+	"github.com/mark3labs/flyt"
+)
+
+// quizTries is how many answers a question takes: the first, then one more
+// after the tutor's guiding question.
+const quizTries = 2
+
+// runQuiz asks questions on the passages prepared by CreateQuizSetupFlow,
+// grades each free-text answer against its passage and keeps the score in
+// shared "quiz_score". questions 0 goes on until the user quits.
+func runQuiz(ctx context.Context, reader *bufio.Reader, shared *flyt.SharedStore, questions int) {
+	fmt.Println("Answer in your own words; \"skip\" shows the answer, \"quit\" ends the quiz.")
+	defer func() {
+		score, _ := shared.Get("quiz_score")
+		fmt.Printf("\n🏁 Final score: %s\n", score)
+	}()
+	for round := 1; questions <= 0 || round <= questions; round++ {
+		if err := CreateQuizQuestionFlow().Run(ctx, shared); err != nil {
+			utils.PrintWarning("Could not write a question: %v", err)
+			return
+		}
+		value, _ := shared.Get("quiz_question")
+		question := value.(utils.QuizQuestion)
+		fmt.Printf("\n%s %s\n", utils.Paint(utils.StyleAI, fmt.Sprintf("❓ Question %d:", round)), question.Question)
+
+		shared.Set("quiz_attempts", []string(nil))
+		var attempts []string
+		grade := utils.QuizGrade{Verdict: "incorrect"}
+		for try := 1; try <= quizTries; try++ {
+			fmt.Print(utils.Paint(utils.StyleUser, "You:") + " ")
+			line, err := reader.ReadString('\n')
+			answer := strings.TrimSpace(line)
+			if err != nil || answer == "quit" || answer == "exit" {
+				return
+			}
+			if answer == "" || answer == "skip" {
+				break
+			}
+			shared.Set("quiz_answer", answer)
+			if err := CreateQuizGradeFlow().Run(ctx, shared); err != nil {
+				utils.PrintWarning("Could not grade the answer: %v", err)
+				break
+			}
+			value, _ := shared.Get("quiz_grade")
+			grade = value.(utils.QuizGrade)
+			fmt.Printf("%s %s\n", map[string]string{"correct": "✅", "partial": "🟡", "incorrect": "❌"}[grade.Verdict], grade.Feedback)
+			if grade.Verdict == "correct" || grade.FollowUp == "" || try == quizTries {
+				break
+			}
+			fmt.Printf("🤔 %s\n", grade.FollowUp)
+			attempts = append(attempts, answer)
+			shared.Set("quiz_attempts", attempts)
+		}
+		if grade.Verdict != "correct" {
+			passage, _ := shared.Get("quiz_passage")
+			fmt.Printf("💡 %s\n   (from %s)\n", question.Answer, passage.(utils.KBChunk).Label())
+		}
+
+		value, _ = shared.Get("quiz_score")
+		score := value.(utils.QuizScore)
+		score.Asked++
+		score.Points += grade.Points()
+		shared.Set("quiz_score", score)
+		utils.PrintStatus("Score: %s", score)
+	}
+}
@@ -0,0 +1,838 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"os/signal"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+	"unicode/utf8"
+
+	"flyt-project-template/utils"
+)
+
+// server exposes the daemon's flows over HTTP for editor extensions, with
+// per-workspace sessions and documents kept in sync by the client.
+type server struct {
+	daemon *daemon
+
+	mu         sync.Mutex
+	workspaces map[string]*workspace
+	cancels    map[string]context.CancelFunc
+	// maxDocBytes bounds the uploaded documents of all workspaces; the least
+	// recently used are evicted beyond it, and clients PUT them again.
+	maxDocBytes  int64
+	docEvictions int
+	// state shares documents and sessions between replicas (-state).
+	state    utils.StateStore
+	stateTTL time.Duration
+	// users, with -users, authenticates requests and enforces each user's limits.
+	users *userAccounts
+	// token, without -users, is the bearer token every request must send;
+	// it is written to tokenPath ("" with -token-file off).
+	token, tokenPath string
+	// allowedHosts and allowedOrigins are the host names and browser origins
+	// a request may name besides localhost and IP addresses (-allow-host,
+	// -allow-origin).
+	allowedHosts, allowedOrigins map[string]bool
+}
+
+// maxRequestBody bounds a request's JSON body, such as a document uploaded whole.
+const maxRequestBody = 64 << 20
+
+// defaultMaxDocumentMemory bounds the documents a server keeps.
+const defaultMaxDocumentMemory = 256 << 20
+
+// workspace holds the documents an editor has uploaded for one project,
+// in docs or, with -state, in the shared store.
+type workspace struct {
+	mu    sync.Mutex
+	docs  map[string]*document
+	name  string
+	state utils.StateStore
+	ttl   time.Duration
+}
+
+type document struct {
+	Version int    `json:"version"`
+	Content string `json:"content"`
+	used    time.Time
+}
+
+// position is a zero-based line and character (Unicode code point) offset, as in LSP.
+type position struct {
+	Line      int `json:"line"`
+	Character int `json:"character"`
+}
+
+// textChange replaces Range with Text; without a range it replaces the whole document.
+type textChange struct {
+	Range *struct {
+		Start position `json:"start"`
+		End   position `json:"end"`
+	} `json:"range,omitempty"`
+	Text string `json:"text"`
+}
+
+type documentSync struct {
+	Path    string       `json:"path"`
+	Version int          `json:"version"`
+	Content string       `json:"content,omitempty"`
+	Changes []textChange `json:"changes,omitempty"`
+}
+
+// serverRequest is the body of /v1/ask and /v1/complete.
+type serverRequest struct {
+	// RequestID names the request so POST /v1/cancel can stop it.
+	RequestID string `json:"request_id,omitempty"`
+	Workspace string `json:"workspace"`
+	Question  string `json:"question"`
+	Mode      string `json:"mode,omitempty"`
+	// Paths lists uploaded documents to include as context.
+	Paths []string `json:"paths,omitempty"`
+	// Stream sends the answer of /v1/ask as server-sent events.
+	Stream bool `json:"stream,omitempty"`
+	// Path and Position locate the cursor for /v1/complete.
+	Path     string   `json:"path,omitempty"`
+	Position position `json:"position"`
+}
+
+// completionContextLines is how many lines around the cursor /v1/complete sends.
+const completionContextLines = 60
+
+// runServe starts the HTTP server.
+func runServe(args []string) error {
+	fs, model := newSubcommandFlags("serve")
+	addr := fs.String("addr", "127.0.0.1:8765", "Address to listen on")
+	maxSessionMemory := utils.ByteSize(defaultMaxSessionMemory)
+	fs.Var(&maxSessionMemory, "max-session-memory", "Most memory the workspaces' session histories may hold (e.g. 64MiB, 0 for no limit); the least recently used are evicted first")
+	maxDocumentMemory := utils.ByteSize(defaultMaxDocumentMemory)
+	fs.Var(&maxDocumentMemory, "max-document-memory", "Most memory uploaded documents may hold (e.g. 256MiB, 0 for no limit); the least recently used are evicted first")
+	shutdownTimeout := fs.Duration("shutdown-timeout", 30*time.Second, "On SIGTERM or Ctrl-C, how long in-flight requests may run before they are cancelled")
+	persistSessions := fs.Bool("persist-sessions", false, "Save the workspaces' sessions in the conversation store (-save-dir, CONVERSATION_STORE) on shutdown and restore each on its first use")
+	saveDir := fs.String("save-dir", conversationsDir, "Directory conversations are saved in")
+	stateURL := fs.String("state", "", "Keep sessions, documents and the -rpm counter in a store shared by replicas behind a load balancer: redis://[:password@]host:port[/db] or postgres://[user[:password]@]host[:port][/db][?sslmode=...]; empty keeps them in this process")
+	stateTTL := fs.Duration("state-ttl", 24*time.Hour, "With -state, how long sessions and documents are kept after their last change")
+	rpm := fs.Int("rpm", 0, "Requests per minute allowed by your API quota, shared by every replica with -state (0 means unlimited)")
+	usersPath := fs.String("users", "", "JSON user table giving each user an API key and per-user rpm, daily and monthly cost limits; requests must then carry a key (empty lets anyone in)")
+	limitsFailOpen := fs.Bool("limits-fail-open", false, "With -users, let requests through without checking the users' limits while the store holding them cannot be reached (by default users with limits get 503)")
+	tokenFile := fs.String("token-file", "", "Without -users, where to write the bearer token requests must send, readable only by you; empty picks one for -addr next to the admin socket, off lets any local process in")
+	allowHosts := fs.String("allow-host", "", "Comma-separated host names requests may be addressed to besides localhost and IP addresses, e.g. the server's DNS name")
+	allowOrigins := fs.String("allow-origin", "", "Comma-separated browser origins allowed to call the API, e.g. https://app.example.com; requests from other web pages are refused")
+	adminSocket := fs.String("admin-socket", "", "Unix socket for the admin subcommand, authenticated by a token written next to it; empty picks one for -addr in $XDG_RUNTIME_DIR or a private temporary directory, off disables it")
+	if err := parseWithSettings(fs, args); err != nil {
+		return err
+	}
+	utils.DefaultModel = *model
+	if *stateURL != "" && *persistSessions {
+		return fmt.Errorf("-persist-sessions is for a single server; with -state the sessions are already kept in the shared store")
+	}
+
+	s := &server{
+		daemon:      newDaemon(int64(maxSessionMemory)),
+		workspaces:  map[string]*workspace{},
+		cancels:     map[string]context.CancelFunc{},
+		maxDocBytes: int64(maxDocumentMemory),
+	}
+	utils.DefaultScheduler = utils.NewScheduler(*rpm, 2)
+	if *stateURL != "" {
+		state, err := utils.OpenStateStore(*stateURL)
+		if err != nil {
+			return err
+		}
+		defer state.Close()
+		s.state, s.stateTTL = state, *stateTTL
+		s.daemon.state, s.daemon.stateTTL = state, *stateTTL
+		utils.DefaultScheduler.Share(state, *rpm)
+		log.Printf("🔗 Sharing sessions, documents and the rate limit through %s", redactURL(*stateURL))
+	}
+	if *usersPath != "" {
+		users, err := openUserAccounts(*usersPath, s.state)
+		if err != nil {
+			return err
+		}
+		users.failOpen = *limitsFailOpen
+		s.users = users
+		log.Printf("🔑 Requests need an API key from %s", *usersPath)
+	}
+	if s.users == nil && *tokenFile != "off" {
+		path := *tokenFile
+		var err error
+		if path == "" {
+			path, err = runtimeFileFor("server", *addr, ".token")
+		}
+		if err == nil {
+			s.token, err = writeToken(path)
+		}
+		if err != nil {
+			return fmt.Errorf("failed to write the API token (-token-file off serves without one): %w", err)
+		}
+		s.tokenPath = path
+		defer os.Remove(path)
+		log.Printf("🔑 Requests need the token in %s (Authorization: Bearer <token>)", path)
+	}
+	s.allowedHosts, s.allowedOrigins = commaSet(strings.ToLower(*allowHosts)), commaSet(*allowOrigins)
+	if *persistSessions {
+		conversationsDir = *saveDir
+		store, err := openStorage()
+		if err != nil {
+			return err
+		}
+		s.daemon.store = store
+	}
+	utils.RegisterMemoryPool("documents", s.documentsMemoryStat)
+	if *adminSocket != "off" {
+		// The API is an extra; a server that cannot offer it still serves.
+		socket := *adminSocket
+		var err error
+		if socket == "" {
+			socket, err = adminSocketPath(*addr)
+		}
+		var stopAdmin func()
+		if err == nil {
+			stopAdmin, err = s.listenAdmin(socket)
+		}
+		if err != nil {
+			log.Printf("⚠️  No admin API: %v", err)
+		} else {
+			defer stopAdmin()
+		}
+	}
+
+	// Requests run under requests, which is cancelled only when draining
+	// takes longer than -shutdown-timeout.
+	requests, cancelRequests := context.WithCancel(context.Background())
+	defer cancelRequests()
+	srv := &http.Server{
+		Addr:        *addr,
+		Handler:     s.routes(),
+		BaseContext: func(net.Listener) context.Context { return requests },
+	}
+	signals, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	served := make(chan error, 1)
+	go func() { served <- srv.ListenAndServe() }()
+	log.Printf("🌐 Serving on http://%s (model %s)", *addr, utils.DefaultModel)
+
+	select {
+	case err := <-served:
+		return err
+	case <-signals.Done():
+	}
+	// A second signal kills the server at once.
+	stop()
+	return s.drain(srv, *shutdownTimeout, cancelRequests)
+}
+
+// redactURL hides the password in a store URL for logging.
+func redactURL(raw string) string {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return "the state store"
+	}
+	return u.Redacted()
+}
+
+// drain shuts srv down for an orchestrator: it stops accepting connections,
+// lets in-flight requests (streams included) finish for up to timeout,
+// cancels those still running, and then saves the sessions.
+func (s *server) drain(srv *http.Server, timeout time.Duration, cancelRequests context.CancelFunc) error {
+	log.Printf("🌐 Draining: refusing new requests, waiting up to %s for those in flight...", timeout)
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	if err := srv.Shutdown(ctx); err != nil {
+		log.Printf("⚠️ Requests still running after %s were cancelled", timeout)
+		cancelRequests()
+		srv.Close()
+	}
+
+	if s.daemon.store != nil {
+		saved, err := s.daemon.saveSessions()
+		log.Printf("💾 Saved %d session(s)", saved)
+		if err != nil {
+			return fmt.Errorf("failed to save sessions: %w", err)
+		}
+	}
+	log.Println("🌐 Server stopped.")
+	return nil
+}
+
+func (s *server) routes() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /v1/ask", s.limited(s.handleAsk))
+	mux.HandleFunc("POST /v1/complete", s.limited(s.handleComplete))
+	mux.HandleFunc("POST /v1/cancel", s.handleCancel)
+	mux.HandleFunc("GET /v1/workspaces/{ws}/documents", s.handleListDocuments)
+	mux.HandleFunc("PUT /v1/workspaces/{ws}/documents", s.handleSyncDocument)
+	mux.HandleFunc("PATCH /v1/workspaces/{ws}/documents", s.handleSyncDocument)
+	mux.HandleFunc("DELETE /v1/workspaces/{ws}/documents", s.handleDeleteDocument)
+	mux.HandleFunc("GET /v1/memstats", s.handleMemStats)
+	mux.HandleFunc("GET /v1/usage", s.handleUsage)
+	switch {
+	case s.users != nil:
+		return s.guard(s.authenticate(mux))
+	case s.token != "":
+		return s.guard(s.requireToken(mux))
+	default:
+		return s.guard(mux)
+	}
+}
+
+// guard refuses requests a web page could have sent: those addressed to a
+// host name other than localhost or one allowed with -allow-host, as after
+// DNS rebinding, and those from a browser origin not allowed with
+// -allow-origin. It also bounds request bodies by maxRequestBody.
+func (s *server) guard(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host := r.Host
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		}
+		host = strings.ToLower(strings.Trim(host, "[]"))
+		if host != "localhost" && net.ParseIP(host) == nil && !s.allowedHosts[host] {
+			writeError(w, http.StatusMisdirectedRequest, "requests for host %q are refused; allow it with -allow-host", host)
+			return
+		}
+		if origin := r.Header.Get("Origin"); origin != "" && !s.allowedOrigins[origin] {
+			writeError(w, http.StatusForbidden, "requests from the web page at %s are refused; allow it with -allow-origin", origin)
+			return
+		}
+		if r.ContentLength > maxRequestBody {
+			writeError(w, http.StatusRequestEntityTooLarge, "the request body is larger than %d bytes", maxRequestBody)
+			return
+		}
+		r.Body = http.MaxBytesReader(w, r.Body, maxRequestBody)
+		next.ServeHTTP(w, r)
+	})
+}
+
+// requireToken lets only requests with s.token through.
+func (s *server) requireToken(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		sent, _ := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if subtle.ConstantTimeCompare([]byte(sent), []byte(s.token)) != 1 {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="ai_wraper"`)
+			writeError(w, http.StatusUnauthorized, "the token in %s is required (Authorization: Bearer <token>)", s.tokenPath)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// commaSet returns the non-empty items of a comma-separated list.
+func commaSet(list string) map[string]bool {
+	set := map[string]bool{}
+	for _, item := range strings.Split(list, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			set[item] = true
+		}
+	}
+	return set
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, format string, a ...any) {
+	writeJSON(w, status, map[string]string{"error": fmt.Sprintf(format, a...)})
+}
+
+func (s *server) workspace(name string) *workspace {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	ws, ok := s.workspaces[name]
+	if !ok {
+		ws = &workspace{docs: map[string]*document{}, name: name, state: s.state, ttl: s.stateTTL}
+		s.workspaces[name] = ws
+	}
+	return ws
+}
+
+// handleSyncDocument uploads a document (PUT, full content) or applies
+// incremental changes to it (PATCH). Versions must increase by one per PATCH;
+// on a mismatch the server answers 409 and the client should PUT the full text.
+func (s *server) handleSyncDocument(w http.ResponseWriter, r *http.Request) {
+	var req documentSync
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Path == "" {
+		writeError(w, http.StatusBadRequest, "body must be JSON with a path")
+		return
+	}
+	ws := s.workspace(scoped(r, r.PathValue("ws")))
+	// Runs after the workspace is unlocked.
+	defer s.evictDocuments()
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+
+	if r.Method == http.MethodPut {
+		if err := ws.put(r.Context(), req.Path, &document{Version: req.Version, Content: req.Content}); err != nil {
+			writeError(w, http.StatusServiceUnavailable, "%v", err)
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]any{"path": req.Path, "version": req.Version})
+		return
+	}
+
+	doc, err := ws.get(r.Context(), req.Path)
+	if err != nil {
+		writeError(w, http.StatusServiceUnavailable, "%v", err)
+		return
+	}
+	if doc == nil {
+		writeError(w, http.StatusNotFound, "unknown document %s; PUT it first", req.Path)
+		return
+	}
+	if req.Version != doc.Version+1 {
+		writeJSON(w, http.StatusConflict, map[string]any{"error": "version mismatch, resend the full document", "version": doc.Version})
+		return
+	}
+	content := doc.Content
+	for _, change := range req.Changes {
+		if change.Range == nil {
+			content = change.Text
+			continue
+		}
+		start, ok1 := byteOffset(content, change.Range.Start)
+		end, ok2 := byteOffset(content, change.Range.End)
+		if !ok1 || !ok2 || start > end {
+			writeJSON(w, http.StatusConflict, map[string]any{"error": "change is out of range, resend the full document", "version": doc.Version})
+			return
+		}
+		content = content[:start] + change.Text + content[end:]
+	}
+	doc.Content, doc.Version = content, req.Version
+	if err := ws.put(r.Context(), req.Path, doc); err != nil {
+		writeError(w, http.StatusServiceUnavailable, "%v", err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"path": req.Path, "version": doc.Version})
+}
+
+// sharedKey is the key of a document in the shared store.
+func (ws *workspace) sharedKey(path string) string {
+	return ws.sharedPrefix() + path
+}
+
+func (ws *workspace) sharedPrefix() string {
+	return "doc:" + url.PathEscape(ws.name) + ":"
+}
+
+// get returns the document at path, or nil. Callers hold ws.mu.
+func (ws *workspace) get(ctx context.Context, path string) (*document, error) {
+	if ws.state == nil {
+		doc := ws.docs[path]
+		if doc != nil {
+			doc.used = time.Now()
+		}
+		return doc, nil
+	}
+	data, ok, err := ws.state.Get(ctx, ws.sharedKey(path))
+	if err != nil || !ok {
+		return nil, err
+	}
+	var doc document
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return nil, fmt.Errorf("corrupt shared document %s: %w", path, err)
+	}
+	return &doc, nil
+}
+
+// put stores doc at path. Callers hold ws.mu.
+func (ws *workspace) put(ctx context.Context, path string, doc *document) error {
+	doc.used = time.Now()
+	if ws.state == nil {
+		ws.docs[path] = doc
+		return nil
+	}
+	data, err := json.Marshal(doc)
+	if err != nil {
+		return err
+	}
+	return ws.state.Set(ctx, ws.sharedKey(path), data, ws.ttl)
+}
+
+// byteOffset converts a line/character position in text to a byte offset.
+func byteOffset(text string, p position) (int, bool) {
+	offset := 0
+	for line := 0; line < p.Line; line++ {
+		nl := strings.IndexByte(text[offset:], '\n')
+		if nl < 0 {
+			return 0, false
+		}
+		offset += nl + 1
+	}
+	for i := 0; i < p.Character; i++ {
+		if offset >= len(text) || text[offset] == '\n' {
+			return 0, false
+		}
+		_, size := utf8.DecodeRuneInString(text[offset:])
+		offset += size
+	}
+	return offset, true
+}
+
+func (s *server) handleListDocuments(w http.ResponseWriter, r *http.Request) {
+	ws := s.workspace(scoped(r, r.PathValue("ws")))
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+	docs := map[string]int{}
+	if ws.state == nil {
+		for path, doc := range ws.docs {
+			docs[path] = doc.Version
+		}
+		writeJSON(w, http.StatusOK, map[string]any{"documents": docs})
+		return
+	}
+	keys, err := ws.state.Keys(r.Context(), ws.sharedPrefix())
+	if err != nil {
+		writeError(w, http.StatusServiceUnavailable, "%v", err)
+		return
+	}
+	for _, key := range keys {
+		path := strings.TrimPrefix(key, ws.sharedPrefix())
+		// A document that expired since it was listed is left out.
+		if doc, err := ws.get(r.Context(), path); err == nil && doc != nil {
+			docs[path] = doc.Version
+		}
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"documents": docs})
+}
+
+func (s *server) handleDeleteDocument(w http.ResponseWriter, r *http.Request) {
+	path := r.URL.Query().Get("path")
+	ws := s.workspace(scoped(r, r.PathValue("ws")))
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+	if ws.state != nil {
+		if err := ws.state.Delete(r.Context(), ws.sharedKey(path)); err != nil {
+			writeError(w, http.StatusServiceUnavailable, "%v", err)
+			return
+		}
+	}
+	delete(ws.docs, path)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// documentsMemoryStat accounts for the documents of every workspace.
+func (s *server) documentsMemoryStat() utils.MemoryStat {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	stat := utils.MemoryStat{Limit: s.maxDocBytes, Evictions: s.docEvictions}
+	for _, ws := range s.workspaces {
+		ws.mu.Lock()
+		for path, doc := range ws.docs {
+			stat.Entries++
+			stat.Bytes += int64(len(path) + len(doc.Content))
+		}
+		ws.mu.Unlock()
+	}
+	return stat
+}
+
+// evictDocuments drops the least recently used documents until all
+// workspaces hold at most maxDocBytes. Callers must not hold a workspace's lock.
+func (s *server) evictDocuments() {
+	if s.maxDocBytes <= 0 {
+		return
+	}
+	type entry struct {
+		ws   *workspace
+		path string
+		doc  *document
+		size int64
+		used time.Time
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var entries []entry
+	var total int64
+	for _, ws := range s.workspaces {
+		ws.mu.Lock()
+		for path, doc := range ws.docs {
+			size := int64(len(path) + len(doc.Content))
+			entries = append(entries, entry{ws, path, doc, size, doc.used})
+			total += size
+		}
+		ws.mu.Unlock()
+	}
+	if total <= s.maxDocBytes {
+		return
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].used.Before(entries[j].used) })
+	for _, e := range entries {
+		if total <= s.maxDocBytes {
+			break
+		}
+		e.ws.mu.Lock()
+		if e.ws.docs[e.path] == e.doc {
+			delete(e.ws.docs, e.path)
+			s.docEvictions++
+		}
+		e.ws.mu.Unlock()
+		total -= e.size
+		log.Printf("🧹 Evicted document %s to stay under %s", e.path, utils.FormatBytes(s.maxDocBytes))
+	}
+}
+
+// handleMemStats reports the memory of the sessions and documents and the Go runtime.
+func (s *server) handleMemStats(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]any{"stores": utils.MemoryStats(), "runtime": utils.ReadRuntimeMemory()})
+}
+
+// documentsContext formats the requested documents of a workspace as prompt context.
+func (ws *workspace) documentsContext(ctx context.Context, paths []string) (string, error) {
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+	sort.Strings(paths)
+	var b strings.Builder
+	for _, path := range paths {
+		doc, err := ws.get(ctx, path)
+		if err != nil {
+			return "", err
+		}
+		if doc == nil {
+			return "", fmt.Errorf("unknown document %s", path)
+		}
+		fmt.Fprintf(&b, "File %s:\n```\n%s\n```\n", path, doc.Content)
+	}
+	return b.String(), nil
+}
+
+// handleAsk answers a question in the workspace's session, with the listed documents as context.
+func (s *server) handleAsk(w http.ResponseWriter, r *http.Request) {
+	var req serverRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Workspace == "" || strings.TrimSpace(req.Question) == "" {
+		writeError(w, http.StatusBadRequest, "body must be JSON with workspace and question")
+		return
+	}
+	docs, err := s.workspace(scoped(r, req.Workspace)).documentsContext(r.Context(), req.Paths)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "%v", err)
+		return
+	}
+
+	if req.Stream {
+		s.streamAsk(w, r, req, docs)
+		return
+	}
+	s.runCancellable(w, r, req.RequestID, func(ctx context.Context) (any, error) {
+		answer, err := s.daemon.answer(ctx, daemonRequest{
+			Question: req.Question,
+			Mode:     req.Mode,
+			Session:  "workspace:" + scoped(r, req.Workspace),
+		}, " you are a helpful assistant. \n"+docs)
+		return map[string]string{"answer": answer}, err
+	})
+}
+
+// streamAsk answers like handleAsk but writes the stream events as
+// server-sent events ("event: text_delta", "data: {...}"), ending with done,
+// or with an error event if the request fails or is cancelled.
+func (s *server) streamAsk(w http.ResponseWriter, r *http.Request, req serverRequest, docs string) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeError(w, http.StatusInternalServerError, "streaming is not supported by this connection")
+		return
+	}
+	ctx, cancel := context.WithCancel(r.Context())
+	defer cancel()
+	defer s.trackCancel(scoped(r, req.RequestID), cancel)()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	// The flow writes from its own goroutine; closed stops it once the handler returns.
+	var mu sync.Mutex
+	closed, sent := false, false
+	write := func(name string, data any) error {
+		mu.Lock()
+		defer mu.Unlock()
+		if closed || ctx.Err() != nil {
+			return context.Canceled
+		}
+		payload, err := json.Marshal(data)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(w, "event: %s\ndata: %s\n\n", name, payload)
+		flusher.Flush()
+		return nil
+	}
+	emit := func(ev utils.StreamEvent) error {
+		mu.Lock()
+		sent = true
+		mu.Unlock()
+		return write(ev.EventName(), ev)
+	}
+
+	type result struct {
+		answer string
+		err    error
+	}
+	done := make(chan result, 1)
+	go func() {
+		answer, err := s.daemon.answer(ctx, daemonRequest{
+			Question: req.Question,
+			Mode:     req.Mode,
+			Session:  "workspace:" + scoped(r, req.Workspace),
+			events:   emit,
+		}, " you are a helpful assistant. \n"+docs)
+		done <- result{answer, err}
+	}()
+
+	select {
+	case <-ctx.Done():
+		// The client is usually gone; tell it anyway in case this was /v1/cancel.
+		mu.Lock()
+		closed = true
+		fmt.Fprint(w, "event: error\ndata: {\"error\":\"request was cancelled\"}\n\n")
+		flusher.Flush()
+		mu.Unlock()
+	case res := <-done:
+		switch {
+		case res.err != nil:
+			write("error", map[string]string{"error": res.err.Error()})
+		case !sent:
+			// Flows whose answer node does not stream (search, agent) send the answer whole.
+			write(utils.TextDelta{}.EventName(), utils.TextDelta{Text: res.answer})
+			write(utils.Done{}.EventName(), utils.Done{})
+		}
+		mu.Lock()
+		closed = true
+		mu.Unlock()
+	}
+}
+
+// handleComplete returns a short, inline-completion style answer for the cursor position.
+func (s *server) handleComplete(w http.ResponseWriter, r *http.Request) {
+	var req serverRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Workspace == "" || req.Path == "" {
+		writeError(w, http.StatusBadRequest, "body must be JSON with workspace, path and position")
+		return
+	}
+	ws := s.workspace(scoped(r, req.Workspace))
+	ws.mu.Lock()
+	doc, err := ws.get(r.Context(), req.Path)
+	var content string
+	if doc != nil {
+		content = doc.Content
+	}
+	ws.mu.Unlock()
+	if err != nil {
+		writeError(w, http.StatusServiceUnavailable, "%v", err)
+		return
+	}
+	if doc == nil {
+		writeError(w, http.StatusNotFound, "unknown document %s", req.Path)
+		return
+	}
+	cursor, ok := byteOffset(content, req.Position)
+	if !ok {
+		writeError(w, http.StatusBadRequest, "position is outside the document")
+		return
+	}
+
+	before := content[:cursor]
+	if lines := strings.Split(before, "\n"); len(lines) > completionContextLines {
+		before = strings.Join(lines[len(lines)-completionContextLines:], "\n")
+	}
+	after := content[cursor:]
+	if lines := strings.Split(after, "\n"); len(lines) > completionContextLines {
+		after = strings.Join(lines[:completionContextLines], "\n")
+	}
+
+	s.runCancellable(w, r, req.RequestID, func(ctx context.Context) (any, error) {
+		instruction := req.Question
+		if instruction == "" {
+			instruction = "Continue the code at the cursor."
+		}
+		prompt := fmt.Sprintf(`Inline completion for %s. The cursor is between BEFORE and AFTER.
+BEFORE:
+%s
+AFTER:
+%s
+
+%s Reply with only the text to insert (at most a few lines, no explanation) in one code block.`, req.Path, before, after, instruction)
+		config := utils.DefaultLLMConfig()
+		config.MaxTokens = 256
+		reply, err := utils.CallLLMWithConfig(ctx, prompt, config, false)
+		return map[string]string{"text": utils.ExtractCodeBlock(reply)}, err
+	})
+}
+
+// runCancellable runs work and writes its result, unless the client disconnects
+// or POST /v1/cancel names requestID first. Either cancels the context work
+// runs with, which stops its LLM calls.
+func (s *server) runCancellable(w http.ResponseWriter, r *http.Request, requestID string, work func(ctx context.Context) (any, error)) {
+	ctx, cancel := context.WithCancel(r.Context())
+	defer cancel()
+	defer s.trackCancel(scoped(r, requestID), cancel)()
+
+	type result struct {
+		value any
+		err   error
+	}
+	done := make(chan result, 1)
+	go func() {
+		value, err := work(ctx)
+		done <- result{value, err}
+	}()
+
+	select {
+	case <-ctx.Done():
+		writeError(w, http.StatusRequestTimeout, "request %s was cancelled", requestID)
+	case res := <-done:
+		if res.err != nil {
+			writeError(w, http.StatusBadGateway, "%v", res.err)
+			return
+		}
+		writeJSON(w, http.StatusOK, res.value)
+	}
+}
+
+// trackCancel lets POST /v1/cancel stop the request named requestID until
+// the returned function is called. Unnamed requests cannot be cancelled.
+func (s *server) trackCancel(requestID string, cancel context.CancelFunc) func() {
+	if requestID == "" {
+		return func() {}
+	}
+	s.mu.Lock()
+	s.cancels[requestID] = cancel
+	s.mu.Unlock()
+	return func() {
+		s.mu.Lock()
+		delete(s.cancels, requestID)
+		s.mu.Unlock()
+	}
+}
+
+// handleCancel cancels the in-flight request with the given request_id.
+func (s *server) handleCancel(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		RequestID string `json:"request_id"`
+	}
+	json.NewDecoder(r.Body).Decode(&req)
+	s.mu.Lock()
+	cancel, ok := s.cancels[scoped(r, req.RequestID)]
+	s.mu.Unlock()
+	if !ok {
+		writeError(w, http.StatusNotFound, "no running request %q", req.RequestID)
+		return
+	}
+	cancel()
+	writeJSON(w, http.StatusOK, map[string]bool{"cancelled": true})
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"flyt-project-template/utils"
+)
+
+// setting is a default that can be set in the config file (key), on the
+// command line (flag) or in the environment (env).
+type setting struct {
+	key  string
+	flag string
+	env  string
+}
+
+// settings are the defaults the config file may set.
+var settings = []setting{
+	{key: "model", flag: "model", env: "AI_WRAPER_MODEL"},
+	{key: "provider", flag: "provider", env: "AI_WRAPER_PROVIDER"},
+	{key: "base_url", flag: "base-url", env: "AI_WRAPER_BASE_URL"},
+	{key: "temperature", flag: "temperature", env: "AI_WRAPER_TEMPERATURE"},
+	{key: "search_provider", flag: "search", env: "AI_WRAPER_SEARCH_PROVIDER"},
+	{key: "save_dir", flag: "save-dir", env: "AI_WRAPER_SAVE_DIR"},
+	{key: "tool_choice", flag: "tool-choice", env: "AI_WRAPER_TOOL_CHOICE"},
+	{key: "max_steps", flag: "max-steps", env: "AI_WRAPER_MAX_STEPS"},
+	{key: "renderer", flag: "renderer", env: "AI_WRAPER_RENDERER"},
+	{key: "history_tokens", flag: "history-tokens", env: "AI_WRAPER_HISTORY_TOKENS"},
+	{key: "summarize_at", flag: "summarize-at", env: "AI_WRAPER_SUMMARIZE_AT"},
+	{key: "retries", flag: "retries", env: "AI_WRAPER_RETRIES"},
+	{key: "max_session_memory", flag: "max-session-memory", env: "AI_WRAPER_MAX_SESSION_MEMORY"},
+	{key: "max_document_memory", flag: "max-document-memory", env: "AI_WRAPER_MAX_DOCUMENT_MEMORY"},
+	{key: "state", flag: "state", env: "AI_WRAPER_STATE"},
+	{key: "users", flag: "users", env: "AI_WRAPER_USERS"},
+	{key: "max_kb_memory", flag: "max-kb-memory", env: "AI_WRAPER_MAX_KB_MEMORY"},
+}
+
+// explicitFlags are the flags parseWithSettings took from the command line
+// or the environment, which also win over a resumed conversation's settings.
+var explicitFlags = map[string]bool{}
+
+// parseWithSettings parses args into fs, taking each setting from the
+// environment, else the command line, else the config file (utils.ConfigPath),
+// else the flag's default. Settings fs has no flag for are ignored.
+func parseWithSettings(fs *flag.FlagSet, args []string) error {
+	path := utils.ConfigPath()
+	file, err := utils.LoadConfigFile(path)
+	if err != nil {
+		return err
+	}
+	known := map[string]bool{}
+	for _, s := range settings {
+		known[s.key] = true
+	}
+	for key := range file {
+		if !known[key] {
+			return fmt.Errorf("%s: unknown setting %q", path, key)
+		}
+	}
+
+	set := func(name, value, source string) error {
+		if fs.Lookup(name) == nil {
+			return nil
+		}
+		if err := fs.Set(name, value); err != nil {
+			return fmt.Errorf("%s: invalid value %q for -%s: %w", source, value, name, err)
+		}
+		return nil
+	}
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	fs.Visit(func(f *flag.Flag) { explicitFlags[f.Name] = true })
+	for _, s := range settings {
+		if value, ok := file[s.key]; ok && !explicitFlags[s.flag] {
+			if err := set(s.flag, value, path+": "+s.key); err != nil {
+				return err
+			}
+		}
+	}
+	for _, s := range settings {
+		if value := os.Getenv(s.env); value != "" {
+			if err := set(s.flag, value, s.env); err != nil {
+				return err
+			}
+			explicitFlags[s.flag] = true
+		}
+	}
+	return nil
+}
@@ -0,0 +1,575 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"flyt-project-template/utils"
+)
+
+// Storage keeps saved conversations. A key identifies one conversation in
+// the store: its file with JSON storage, <name>_<timestamp> with SQLite.
+type Storage interface {
+	// Save writes c under key, or under a new key when key is empty, and returns the key.
+	Save(key string, c savedConversation) (string, error)
+	Load(key string) (savedConversation, error)
+	// Find resolves a name as for -resume: an exact key, or the newest
+	// conversation saved under the name without its timestamp.
+	Find(name string) (string, error)
+	// List returns every conversation, newest first.
+	List() ([]storedConversation, error)
+	Delete(key string) error
+	// Rename gives the conversation a new name, keeping its timestamp, and returns its new key.
+	Rename(key, name string) (string, error)
+}
+
+// storedConversation is a conversation as listed by a Storage.
+type storedConversation struct {
+	Key     string
+	Updated time.Time
+	savedConversation
+}
+
+// conversationStore is where conversations are saved, chosen by openStorage.
+var conversationStore Storage = jsonStorage{dir: conversationsDir}
+
+// openStorage returns the store selected by CONVERSATION_STORE: "json"
+// (the default, one file per conversation) or "sqlite".
+func openStorage() (Storage, error) {
+	switch kind := os.Getenv("CONVERSATION_STORE"); kind {
+	case "", "json":
+		return jsonStorage{dir: conversationsDir}, nil
+	case "sqlite":
+		path := os.Getenv("CONVERSATION_DB")
+		if path == "" {
+			path = filepath.Join(conversationsDir, "conversations.db")
+		}
+		return openSQLiteStorage(path, jsonStorage{dir: conversationsDir})
+	default:
+		return nil, fmt.Errorf("unknown CONVERSATION_STORE %q (use json or sqlite)", kind)
+	}
+}
+
+// jsonStorage saves each conversation as an indented JSON file in dir.
+type jsonStorage struct {
+	dir string
+}
+
+// Save replaces the file atomically, so an interrupted save keeps the last one.
+func (s jsonStorage) Save(key string, c savedConversation) (string, error) {
+	// Marshal the conversation into a nicely formatted JSON.
+	jsonData, err := json.MarshalIndent(c, "", "  ")
+	if err != nil {
+		return "", fmt.Errorf("marshalling history to JSON: %w", err)
+	}
+
+	fileName := key
+	if fileName == "" {
+		// Ensure the Conversations directory exists.
+		if err := os.MkdirAll(s.dir, 0755); err != nil {
+			return "", fmt.Errorf("creating directory %s: %w", s.dir, err)
+		}
+		fileName = filepath.Join(s.dir, newConversationKey(c.Name)+".json")
+	}
+
+	// Write the JSON data next to the file and move it into place.
+	tmp := fileName + ".tmp"
+	if err := os.WriteFile(tmp, jsonData, 0644); err != nil {
+		return "", fmt.Errorf("writing conversation to file: %w", err)
+	}
+	if err := os.Rename(tmp, fileName); err != nil {
+		return "", fmt.Errorf("writing conversation to file: %w", err)
+	}
+	return fileName, nil
+}
+
+// Load reads a saved conversation, unsealing -idle-seal copies. Older files
+// only have the name in the file name.
+func (s jsonStorage) Load(path string) (savedConversation, error) {
+	var saved savedConversation
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return saved, err
+	}
+	if plain := plainConversationPath(path); plain != path {
+		if data, err = utils.Unseal(data, path, os.Getenv("CONVERSATION_KEY")); err != nil {
+			return saved, err
+		}
+	}
+	if err := json.Unmarshal(data, &saved); err != nil {
+		return saved, fmt.Errorf("parsing %s: %w", path, err)
+	}
+	if saved.Name == "" {
+		saved.Name = conversationTimestamp.ReplaceAllString(strings.TrimSuffix(filepath.Base(plainConversationPath(path)), ".json"), "")
+	}
+	return saved, nil
+}
+
+// Find also accepts a path to a file anywhere.
+func (s jsonStorage) Find(arg string) (string, error) {
+	if info, err := os.Stat(arg); err == nil && !info.IsDir() {
+		return arg, nil
+	}
+	name := strings.TrimSuffix(strings.ReplaceAll(arg, " ", "_"), ".json")
+	var matches []string
+	for _, ext := range conversationExts {
+		exact := filepath.Join(s.dir, name+ext)
+		if _, err := os.Stat(exact); err == nil {
+			return exact, nil
+		}
+		found, _ := filepath.Glob(filepath.Join(s.dir, sqlGlobEscape(name)+"_"+conversationTimestampGlob+ext))
+		matches = append(matches, found...)
+	}
+	// Timestamps sort chronologically, so the last match is the newest.
+	if len(matches) == 0 {
+		return "", fmt.Errorf("no saved conversation %q (looked for a file and in %s/)", arg, s.dir)
+	}
+	sort.Strings(matches)
+	return matches[len(matches)-1], nil
+}
+
+// List orders by modification time, which is the last save, including after -resume.
+func (s jsonStorage) List() ([]storedConversation, error) {
+	paths, _ := filepath.Glob(filepath.Join(s.dir, "*.json"))
+	var list []storedConversation
+	for _, path := range paths {
+		info, err := os.Stat(path)
+		if err != nil {
+			continue
+		}
+		saved, err := s.Load(path)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "⚠️  %v\n", err)
+			continue
+		}
+		list = append(list, storedConversation{path, info.ModTime(), saved})
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Updated.After(list[j].Updated) })
+	return list, nil
+}
+
+func (s jsonStorage) Delete(path string) error {
+	return os.Remove(path)
+}
+
+func (s jsonStorage) Rename(path, name string) (string, error) {
+	saved, err := s.Load(path)
+	if err != nil {
+		return "", err
+	}
+	saved.Name = name
+	data, err := json.MarshalIndent(saved, "", "  ")
+	if err != nil {
+		return "", err
+	}
+
+	// Keep the timestamp so the file still sorts by when it was started.
+	base := strings.ReplaceAll(saved.Name, " ", "_")
+	if m := conversationTimestamp.FindStringSubmatch(strings.TrimSuffix(filepath.Base(path), ".json")); m != nil {
+		base += "_" + m[1]
+	}
+	newPath := filepath.Join(filepath.Dir(path), base+".json")
+	if newPath != path {
+		if _, err := os.Stat(newPath); err == nil {
+			return "", fmt.Errorf("%s already exists", newPath)
+		}
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return "", err
+	}
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		return "", err
+	}
+	if err := os.Rename(path, newPath); err != nil {
+		return "", err
+	}
+	// Renaming is not activity; keep the conversation's place in the list.
+	os.Chtimes(newPath, info.ModTime(), info.ModTime())
+	return newPath, nil
+}
+
+// sqliteStorage keeps conversations in a SQLite database through the sqlite3
+// command-line shell, so no cgo driver is needed. Values are bound as
+// statement parameters (see sqlArgs), and each save writes, in one
+// transaction, only the turns that changed.
+type sqliteStorage struct {
+	path string
+}
+
+const sqliteSchema = `
+CREATE TABLE IF NOT EXISTS conversations (
+	id         INTEGER PRIMARY KEY,
+	key        TEXT NOT NULL UNIQUE,
+	name       TEXT NOT NULL,
+	context    TEXT NOT NULL DEFAULT '',
+	history_id TEXT NOT NULL DEFAULT '',
+	summary    TEXT NOT NULL DEFAULT '',
+	settings   TEXT NOT NULL DEFAULT 'null', -- JSON, see conversationSettings
+	recap      TEXT NOT NULL DEFAULT 'null', -- JSON, see sessionRecap
+	updated    TEXT NOT NULL
+);
+CREATE TABLE IF NOT EXISTS messages (
+	conversation_id INTEGER NOT NULL REFERENCES conversations(id) ON DELETE CASCADE,
+	turn       INTEGER NOT NULL,
+	user       TEXT NOT NULL,
+	ai         TEXT NOT NULL, -- JSON, answers are not always strings
+	pinned     INTEGER NOT NULL DEFAULT 0,
+	muted      INTEGER NOT NULL DEFAULT 0,
+	truncated  INTEGER NOT NULL DEFAULT 0,
+	images     TEXT NOT NULL DEFAULT '[]', -- JSON array of image paths
+	summarized INTEGER NOT NULL DEFAULT 0,
+	PRIMARY KEY (conversation_id, turn)
+);
+CREATE TABLE IF NOT EXISTS metadata (
+	key   TEXT PRIMARY KEY,
+	value TEXT NOT NULL
+);
+INSERT OR IGNORE INTO metadata (key, value) VALUES ('schema_version', '5');
+`
+
+// sqliteMigrations bring a database from the schema version in the key to the next one.
+var sqliteMigrations = map[string]string{
+	"1": `ALTER TABLE messages ADD COLUMN images TEXT NOT NULL DEFAULT '[]';
+UPDATE metadata SET value = '2' WHERE key = 'schema_version';`,
+	"2": `ALTER TABLE conversations ADD COLUMN summary TEXT NOT NULL DEFAULT '';
+ALTER TABLE messages ADD COLUMN summarized INTEGER NOT NULL DEFAULT 0;
+UPDATE metadata SET value = '3' WHERE key = 'schema_version';`,
+	"3": `ALTER TABLE conversations ADD COLUMN settings TEXT NOT NULL DEFAULT 'null';
+UPDATE metadata SET value = '4' WHERE key = 'schema_version';`,
+	"4": `ALTER TABLE conversations ADD COLUMN recap TEXT NOT NULL DEFAULT 'null';
+UPDATE metadata SET value = '5' WHERE key = 'schema_version';`,
+}
+
+// openSQLiteStorage creates the database at path if needed. On first use it
+// imports the JSON conversations of legacy, which are left in place.
+func openSQLiteStorage(path string, legacy jsonStorage) (Storage, error) {
+	if _, err := exec.LookPath("sqlite3"); err != nil {
+		return nil, fmt.Errorf("CONVERSATION_STORE=sqlite needs the sqlite3 command-line shell")
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, err
+	}
+	s := sqliteStorage{path: path}
+	if _, err := s.run(sqliteSchema, nil); err != nil {
+		return nil, fmt.Errorf("creating %s: %w", path, err)
+	}
+	if err := s.migrate(); err != nil {
+		return nil, fmt.Errorf("upgrading %s: %w", path, err)
+	}
+
+	var imported []struct{ Value string }
+	if err := s.query("SELECT value FROM metadata WHERE key = 'json_imported';", nil, &imported); err != nil {
+		return nil, err
+	}
+	if len(imported) > 0 {
+		return s, nil
+	}
+	list, err := legacy.List()
+	if err != nil {
+		return nil, err
+	}
+	var sql strings.Builder
+	args := sqlArgs{"@imported": time.Now().Format(time.RFC3339)}
+	sql.WriteString("BEGIN;\n")
+	for i, c := range list {
+		key := strings.TrimSuffix(filepath.Base(c.Key), ".json")
+		if err := s.writeSave(&sql, args, fmt.Sprintf("@c%d_", i), key, c.savedConversation, c.Updated); err != nil {
+			return nil, err
+		}
+	}
+	sql.WriteString("INSERT INTO metadata (key, value) VALUES ('json_imported', @imported);\nCOMMIT;\n")
+	if _, err := s.run(sql.String(), args); err != nil {
+		return nil, fmt.Errorf("importing JSON conversations: %w", err)
+	}
+	if len(list) > 0 {
+		fmt.Fprintf(os.Stderr, "📦 Imported %d conversations from %s/ into %s\n", len(list), legacy.dir, path)
+	}
+	return s, nil
+}
+
+// migrate applies sqliteMigrations until the schema is current.
+func (s sqliteStorage) migrate() error {
+	for {
+		var rows []struct{ Value string }
+		if err := s.query("SELECT value FROM metadata WHERE key = 'schema_version';", nil, &rows); err != nil {
+			return err
+		}
+		if len(rows) == 0 {
+			return nil
+		}
+		migration, ok := sqliteMigrations[rows[0].Value]
+		if !ok {
+			return nil
+		}
+		if _, err := s.run("BEGIN;\n"+migration+"\nCOMMIT;\n", nil); err != nil {
+			return err
+		}
+	}
+}
+
+func (s sqliteStorage) Save(key string, c savedConversation) (string, error) {
+	if key == "" {
+		key = newConversationKey(c.Name)
+	}
+	var sql strings.Builder
+	args := sqlArgs{}
+	sql.WriteString("BEGIN;\n")
+	if err := s.writeSave(&sql, args, "@", key, c, time.Now()); err != nil {
+		return "", err
+	}
+	sql.WriteString("COMMIT;\n")
+	if _, err := s.run(sql.String(), args); err != nil {
+		return "", fmt.Errorf("saving conversation: %w", err)
+	}
+	return key, nil
+}
+
+// writeSave appends the statements that store c under key to sql, and their
+// values to args under parameter names starting with prefix. Turns that did
+// not change are left as they are.
+func (s sqliteStorage) writeSave(sql *strings.Builder, args sqlArgs, prefix, key string, c savedConversation, updated time.Time) error {
+	settings, err := json.Marshal(c.Settings)
+	if err != nil {
+		return fmt.Errorf("encoding settings: %w", err)
+	}
+	recap, err := json.Marshal(c.Recap)
+	if err != nil {
+		return fmt.Errorf("encoding recap: %w", err)
+	}
+	for name, value := range map[string]any{"key": key, "name": c.Name, "context": c.Context, "history_id": c.ID, "summary": c.Summary,
+		"settings": string(settings), "recap": string(recap), "updated": updated.Format(time.RFC3339), "turns": len(c.Conversations)} {
+		args[prefix+name] = value
+	}
+	sql.WriteString(strings.ReplaceAll(`INSERT INTO conversations (key, name, context, history_id, summary, settings, recap, updated) VALUES (@key, @name, @context, @history_id, @summary, @settings, @recap, @updated)
+	ON CONFLICT (key) DO UPDATE SET name = excluded.name, context = excluded.context, history_id = excluded.history_id, summary = excluded.summary, settings = excluded.settings, recap = excluded.recap, updated = excluded.updated;
+DELETE FROM messages WHERE conversation_id = (SELECT id FROM conversations WHERE key = @key) AND turn > @turns;
+`, "@", prefix))
+	for i, turn := range c.Conversations {
+		ai, err := json.Marshal(turn.AI)
+		if err != nil {
+			return fmt.Errorf("encoding turn %d: %w", i+1, err)
+		}
+		images, err := json.Marshal(turn.Images)
+		if err != nil {
+			return fmt.Errorf("encoding turn %d: %w", i+1, err)
+		}
+		t := fmt.Sprintf("%st%d_", prefix, i+1)
+		for name, value := range map[string]any{"turn": i + 1, "user": turn.User, "ai": string(ai), "pinned": sqlBool(turn.Pinned), "muted": sqlBool(turn.Muted),
+			"truncated": sqlBool(turn.Truncated), "images": string(images), "summarized": sqlBool(turn.Summarized)} {
+			args[t+name] = value
+		}
+		sql.WriteString(strings.NewReplacer("@key", prefix+"key", "@", t).Replace(`INSERT INTO messages (conversation_id, turn, user, ai, pinned, muted, truncated, images, summarized)
+	VALUES ((SELECT id FROM conversations WHERE key = @key), @turn, @user, @ai, @pinned, @muted, @truncated, @images, @summarized)
+	ON CONFLICT (conversation_id, turn) DO UPDATE SET user = excluded.user, ai = excluded.ai, pinned = excluded.pinned, muted = excluded.muted,
+		truncated = excluded.truncated, images = excluded.images, summarized = excluded.summarized
+	WHERE (user, ai, pinned, muted, truncated, images, summarized) IS NOT (excluded.user, excluded.ai, excluded.pinned, excluded.muted, excluded.truncated, excluded.images, excluded.summarized);
+`))
+	}
+	return nil
+}
+
+func (s sqliteStorage) Load(key string) (savedConversation, error) {
+	var saved savedConversation
+	var rows []struct {
+		Name      string `json:"name"`
+		Context   string `json:"context"`
+		HistoryID string `json:"history_id"`
+		Summary   string `json:"summary"`
+		Settings  string `json:"settings"`
+		Recap     string `json:"recap"`
+	}
+	if err := s.query("SELECT name, context, history_id, summary, settings, recap FROM conversations WHERE key = @key;", sqlArgs{"@key": key}, &rows); err != nil {
+		return saved, err
+	}
+	if len(rows) == 0 {
+		return saved, fmt.Errorf("no saved conversation %q in %s", key, s.path)
+	}
+	saved.Name, saved.Context, saved.ID, saved.Summary = rows[0].Name, rows[0].Context, rows[0].HistoryID, rows[0].Summary
+	if err := json.Unmarshal([]byte(rows[0].Settings), &saved.Settings); err != nil {
+		return saved, fmt.Errorf("decoding settings of %s: %w", key, err)
+	}
+	if err := json.Unmarshal([]byte(rows[0].Recap), &saved.Recap); err != nil {
+		return saved, fmt.Errorf("decoding recap of %s: %w", key, err)
+	}
+
+	var messages []struct {
+		User       string `json:"user"`
+		AI         string `json:"ai"`
+		Pinned     int    `json:"pinned"`
+		Muted      int    `json:"muted"`
+		Truncated  int    `json:"truncated"`
+		Images     string `json:"images"`
+		Summarized int    `json:"summarized"`
+	}
+	err := s.query(`SELECT user, ai, pinned, muted, truncated, images, summarized FROM messages
+	WHERE conversation_id = (SELECT id FROM conversations WHERE key = @key) ORDER BY turn;`, sqlArgs{"@key": key}, &messages)
+	if err != nil {
+		return saved, err
+	}
+	for _, m := range messages {
+		turn := utils.Conversation{User: m.User, Pinned: m.Pinned != 0, Muted: m.Muted != 0, Truncated: m.Truncated != 0, Summarized: m.Summarized != 0}
+		if err := json.Unmarshal([]byte(m.AI), &turn.AI); err != nil {
+			return saved, fmt.Errorf("parsing an answer of %s: %w", key, err)
+		}
+		if err := json.Unmarshal([]byte(m.Images), &turn.Images); err != nil {
+			return saved, fmt.Errorf("parsing the images of %s: %w", key, err)
+		}
+		saved.Conversations = append(saved.Conversations, turn)
+	}
+	return saved, nil
+}
+
+func (s sqliteStorage) Find(arg string) (string, error) {
+	name := strings.ReplaceAll(arg, " ", "_")
+	// Keys end in sortable timestamps, so the greatest match is the newest.
+	var rows []struct {
+		Key string `json:"key"`
+	}
+	err := s.query(`SELECT key FROM conversations WHERE key = @name OR key GLOB @pattern
+	ORDER BY key = @name DESC, key DESC LIMIT 1;`, sqlArgs{"@name": name, "@pattern": sqlGlobEscape(name) + "_" + conversationTimestampGlob}, &rows)
+	if err != nil {
+		return "", err
+	}
+	if len(rows) == 0 {
+		return "", fmt.Errorf("no saved conversation %q in %s", arg, s.path)
+	}
+	return rows[0].Key, nil
+}
+
+func (s sqliteStorage) List() ([]storedConversation, error) {
+	var rows []struct {
+		Key     string `json:"key"`
+		Name    string `json:"name"`
+		Updated string `json:"updated"`
+		Turns   int    `json:"turns"`
+		First   string `json:"first"`
+	}
+	err := s.query(`SELECT c.key, c.name, c.updated,
+		(SELECT count(*) FROM messages m WHERE m.conversation_id = c.id) AS turns,
+		coalesce((SELECT user FROM messages m WHERE m.conversation_id = c.id ORDER BY turn LIMIT 1), '') AS first
+	FROM conversations c ORDER BY c.updated DESC;`, nil, &rows)
+	if err != nil {
+		return nil, err
+	}
+	list := make([]storedConversation, 0, len(rows))
+	for _, r := range rows {
+		updated, _ := time.Parse(time.RFC3339, r.Updated)
+		// Listing needs only the turn count and first question, not every answer.
+		saved := savedConversation{Name: r.Name}
+		saved.Conversations = make([]utils.Conversation, r.Turns)
+		if r.Turns > 0 {
+			saved.Conversations[0].User = r.First
+		}
+		list = append(list, storedConversation{r.Key, updated, saved})
+	}
+	return list, nil
+}
+
+func (s sqliteStorage) Delete(key string) error {
+	_, err := s.run("DELETE FROM conversations WHERE key = @key;", sqlArgs{"@key": key})
+	return err
+}
+
+func (s sqliteStorage) Rename(key, name string) (string, error) {
+	newKey := strings.ReplaceAll(name, " ", "_")
+	if m := conversationTimestamp.FindStringSubmatch(key); m != nil {
+		newKey += "_" + m[1]
+	}
+	if newKey != key {
+		if _, err := s.Find(newKey); err == nil {
+			return "", fmt.Errorf("%s already exists", newKey)
+		}
+	}
+	_, err := s.run("UPDATE conversations SET key = @new_key, name = @name WHERE key = @key;", sqlArgs{"@new_key": newKey, "@name": name, "@key": key})
+	return newKey, err
+}
+
+// sqlArgs are the values of the @name parameters of a script, strings or
+// ints. The sqlite3 shell binds every statement's parameters from its
+// temp.sqlite_parameters table, so the values never become SQL: they are
+// only written into that table, as hex blob literals.
+type sqlArgs map[string]any
+
+// run executes sql with the sqlite3 shell, binding args, and returns its JSON
+// output.
+func (s sqliteStorage) run(sql string, args sqlArgs) ([]byte, error) {
+	var script strings.Builder
+	script.WriteString("PRAGMA foreign_keys = ON;\n")
+	if len(args) > 0 {
+		script.WriteString(".parameter init\n")
+		for _, name := range slices.Sorted(maps.Keys(args)) {
+			if !sqlParamName.MatchString(name) {
+				return nil, fmt.Errorf("invalid SQL parameter name %q", name)
+			}
+			switch v := args[name].(type) {
+			case int:
+				fmt.Fprintf(&script, "INSERT INTO temp.sqlite_parameters (key, value) VALUES ('%s', %d);\n", name, v)
+			case string:
+				fmt.Fprintf(&script, "INSERT INTO temp.sqlite_parameters (key, value) VALUES ('%s', CAST(X'%x' AS TEXT));\n", name, v)
+			default:
+				return nil, fmt.Errorf("unsupported type %T of SQL parameter %s", v, name)
+			}
+		}
+	}
+	script.WriteString(sql)
+	cmd := exec.Command("sqlite3", "-bail", "-json", s.path)
+	cmd.Stdin = strings.NewReader(script.String())
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("sqlite3: %v: %s", err, strings.TrimSpace(stderr.String()))
+	}
+	return out, nil
+}
+
+// query runs a SELECT and decodes its rows into out, a pointer to a slice.
+func (s sqliteStorage) query(sql string, args sqlArgs, out any) error {
+	data, err := s.run(sql, args)
+	if err != nil {
+		return err
+	}
+	if len(bytes.TrimSpace(data)) == 0 {
+		// The shell prints nothing for an empty result.
+		return nil
+	}
+	if err := json.Unmarshal(data, out); err != nil {
+		return fmt.Errorf("parsing sqlite3 output: %w", err)
+	}
+	return nil
+}
+
+var sqlParamName = regexp.MustCompile(`^@[A-Za-z0-9_]+$`)
+
+func sqlBool(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// sqlGlobEscape makes GLOB, or filepath.Glob, match the characters of s
+// literally.
+func sqlGlobEscape(s string) string {
+	var b strings.Builder
+	for _, r := range s {
+		switch r {
+		case '*', '?', '[':
+			b.WriteString("[" + string(r) + "]")
+		default:
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,1266 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"flyt-project-template/utils"
+
+	"github.com/mark3labs/flyt"
+)
+
+// subcommand is a one-shot command run instead of the interactive chat loop.
+type subcommand struct {
+	usage string
+	run   func(args []string) error
+}
+
+var subcommands map[string]subcommand
+
+func init() {
+	subcommands = map[string]subcommand{
+		"jq-help":       {usage: `jq-help "what to extract" < doc.json`, run: runJQHelp},
+		"config":        {usage: "config explain [question] | config convert <json|yaml|terraform|...> < doc.yaml", run: runConfig},
+		"regex":         {usage: `regex "description" -match example [-match ...] [-no-match counterexample ...]`, run: runRegex},
+		"how":           {usage: `how "find files >100MB modified this week" [-no-history]`, run: runHow},
+		"sql":           {usage: `sql [-db URL|file.db] [-plan] "question"  (checked with EXPLAIN on the read-only database in SQL_DATABASE)`, run: runSQL},
+		"formula":       {usage: `formula [-sheets] [-columns "A: date, B: amount"] "description"  (Excel, or Google Sheets with -sheets)`, run: runFormula},
+		"cron":          {usage: `cron "description" -at "2025-01-06 09:00" [-at ...] [-not-at ...]`, run: runCron},
+		"admin":         {usage: "admin [-addr 127.0.0.1:8765 | -socket path] users add <name> [-rpm n] [-daily-usd x] [-monthly-usd x] [-admin] | users list | sessions list | sessions kill <name> | cache purge | limits set <name> [-rpm n] [-daily-usd x] [-monthly-usd x] [-admin=true|false]", run: runAdmin},
+		"daemon":        {usage: "daemon [-socket path] [-model name]", run: runDaemon},
+		"editor":        {usage: "editor  (JSON-lines protocol on stdin/stdout for editor plugins, see editors/nvim)", run: runEditor},
+		"hook":          {usage: "hook install [-force] [-timeout 20s] | hook uninstall", run: runHook},
+		"serve":         {usage: "serve [-addr 127.0.0.1:8765] [-model name]  (HTTP API for editor extensions)", run: runServe},
+		"digest":        {usage: "digest -feeds feeds.txt | -feed URL [...] [-out digest.md] [-state file]  (summarize new RSS/Atom items)", run: runDigest},
+		"kb":            {usage: `kb sync [-sources config/kb_sources.json] [-source name] [-every 1h] | kb status | kb search [-from ns,...] "query"`, run: runKB},
+		"history":       {usage: "history list | history show <name> | history delete [-y] <name> | history rename <name> <new name>  (saved conversations; -save-dir dir)", run: runHistory},
+		"ask":           {usage: `ask [-agent] [-model name] [-session name] "question"  (or the question on stdin; uses the daemon when one runs)`, run: runAsk},
+		"release-notes": {usage: "release-notes [-repo dir] [-style github|keepachangelog|compact|style.txt] [-out file] [from..to | from]  (defaults to the latest tag..HEAD)", run: runReleaseNotes},
+		"action-items":  {usage: "action-items [-format table|json|csv] [-out file] [-date YYYY-MM-DD] [-tracker jira|linear] [transcript.txt]  (meeting transcript on stdin without a file)", run: runActionItems},
+		"report":        {usage: "report weekly [-days 7] [-out report.md] [-email lead@example.com] [-topics=false] [-usage-log file]  (usage, topics and spend as Markdown)", run: runReport},
+		"plan":          {usage: "plan [-json] [-fail-on destructive|high|medium|low] [plan.json]  (explain `terraform show -json` output; reads stdin without a file)", run: runPlan},
+	}
+}
+
+// Exit statuses of one-shot commands, besides 0 for success.
+const (
+	exitFailed      = 1   // the model, a tool or the input failed
+	exitUsage       = 2   // nothing to do, such as an empty question
+	exitInterrupted = 130 // stopped with Ctrl+C, as shells report SIGINT
+)
+
+// exitCodeError makes a subcommand exit with code instead of exitFailed.
+type exitCodeError struct {
+	code int
+	err  error
+}
+
+func (e exitCodeError) Error() string { return e.err.Error() }
+func (e exitCodeError) Unwrap() error { return e.err }
+
+// printSubcommandUsage lists the available subcommands after the flag defaults.
+func printSubcommandUsage() {
+	names := make([]string, 0, len(subcommands))
+	for name := range subcommands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	fmt.Fprintln(flag.CommandLine.Output(), "\nSubcommands:")
+	for _, name := range names {
+		fmt.Fprintf(flag.CommandLine.Output(), "  %s\n", subcommands[name].usage)
+	}
+}
+
+// newSubcommandFlags creates a flag set with the options every subcommand shares.
+func newSubcommandFlags(name string) (*flag.FlagSet, *string) {
+	fs := flag.NewFlagSet(name, flag.ExitOnError)
+	model := fs.String("model", "gemini-2.5-flash", "LLM model to use")
+	fs.BoolVar(&utils.ReadOnly, "readonly", utils.ReadOnly, readOnlyUsage)
+	return fs, model
+}
+
+// readStdinDocument reads and parses the JSON or YAML document piped on stdin.
+func readStdinDocument() (string, any, string, error) {
+	data, err := io.ReadAll(os.Stdin)
+	if err != nil {
+		return "", nil, "", fmt.Errorf("failed to read stdin: %w", err)
+	}
+	text := string(data)
+	value, format, err := utils.ParseDocument(text)
+	if err != nil {
+		return "", nil, "", fmt.Errorf("the document on stdin does not parse: %w", err)
+	}
+	return text, value, format, nil
+}
+
+// runValidatedGeneration runs the generate → validate loop and returns the
+// accepted candidate together with the model's full reply.
+func runValidatedGeneration(prompt string, validate func(string) error, maxAttempts int) (string, string, error) {
+	shared := flyt.NewSharedStore()
+	shared.Set("generate_prompt", prompt)
+
+	if err := CreateValidatedGenerationFlow(validate, maxAttempts).Run(context.Background(), shared); err != nil {
+		return "", "", err
+	}
+
+	candidate, _ := shared.Get("candidate")
+	reply, _ := shared.Get("candidate_reply")
+	if validationErr, _ := shared.Get("validation_error"); validationErr != "" {
+		return candidate.(string), reply.(string), fmt.Errorf("no valid answer after %d attempts: %v", maxAttempts, validationErr)
+	}
+	return candidate.(string), reply.(string), nil
+}
+
+// shellQuote quotes s as one sh word, so that a printed command can be
+// pasted into a shell even when s holds single quotes.
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+// runJQHelp writes a jq filter for a question about the document on stdin and,
+// when jq is installed, verifies it by running it against the document.
+func runJQHelp(args []string) error {
+	fs, model := newSubcommandFlags("jq-help")
+	if err := parseWithSettings(fs, args); err != nil {
+		return err
+	}
+	utils.DefaultModel = *model
+	question := strings.Join(fs.Args(), " ")
+	if question == "" {
+		return fmt.Errorf("usage: %s", subcommands["jq-help"].usage)
+	}
+
+	_, value, format, err := readStdinDocument()
+	if err != nil {
+		return err
+	}
+	// jq only reads JSON, so YAML input is normalized first.
+	doc, err := json.MarshalIndent(value, "", "  ")
+	if err != nil {
+		return fmt.Errorf("could not convert %s document to JSON: %w", format, err)
+	}
+
+	prompt := fmt.Sprintf(`Write a jq filter for this JSON document that does the following: %s
+
+Document (possibly truncated):
+%s
+
+Reply with the filter alone in a code block, followed by a one-paragraph explanation.`, question, TruncateString(string(doc), 8000))
+
+	_, jqErr := exec.LookPath("jq")
+	hasJQ := jqErr == nil
+	var output string
+	validate := func(filter string) error {
+		if !hasJQ {
+			return nil
+		}
+		cmd := exec.Command("jq", filter)
+		cmd.Stdin = bytes.NewReader(doc)
+		var stdout, stderr bytes.Buffer
+		cmd.Stdout, cmd.Stderr = &stdout, &stderr
+		if err := cmd.Run(); err != nil {
+			return fmt.Errorf("jq: %s", strings.TrimSpace(stderr.String()))
+		}
+		output = stdout.String()
+		return nil
+	}
+
+	filter, reply, err := runValidatedGeneration(prompt, validate, 3)
+	if err != nil {
+		return err
+	}
+
+	// The explanation follows the code block in the reply.
+	explanation := reply
+	if parts := strings.SplitN(reply, "```", 3); len(parts) == 3 {
+		explanation = parts[2]
+	}
+	fmt.Printf("jq %s\n\n%s\n", shellQuote(filter), strings.TrimSpace(explanation))
+	if !hasJQ {
+		fmt.Fprintln(os.Stderr, "\n⚠️  jq is not installed, the filter was not verified.")
+		return nil
+	}
+	fmt.Printf("\n✅ Verified with jq, output:\n%s", TruncateString(output, 4000))
+	return nil
+}
+
+// runConfig explains or converts the JSON/YAML document on stdin.
+func runConfig(args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("usage: %s", subcommands["config"].usage)
+	}
+	fs, model := newSubcommandFlags("config " + args[0])
+	if err := parseWithSettings(fs, args[1:]); err != nil {
+		return err
+	}
+	utils.DefaultModel = *model
+
+	text, _, format, err := readStdinDocument()
+	if err != nil {
+		return err
+	}
+
+	switch args[0] {
+	case "explain":
+		question := strings.Join(fs.Args(), " ")
+		if question == "" {
+			question = "Explain what this document does, section by section, and point out anything risky, deprecated or unusual."
+		}
+
+		shared := flyt.NewSharedStore()
+		shared.Set("history", utils.History{})
+		shared.Set("context", fmt.Sprintf("The user provided this %s document, which parses correctly:\n```%s\n%s\n```", format, format, text))
+		shared.Set("question", question)
+		if err := CreateQAFlow().Run(context.Background(), shared); err != nil {
+			return err
+		}
+		answer, _ := shared.Get("answer")
+		if err := displayAnswer(answer.(string)); err != nil {
+			fmt.Println(answer)
+		}
+		return nil
+
+	case "convert":
+		if fs.NArg() != 1 {
+			return fmt.Errorf("usage: config convert <target format>")
+		}
+		target := fs.Arg(0)
+		prompt := fmt.Sprintf(`Convert this %s document to %s, preserving every setting and its meaning.
+Reply with only the converted document in a single code block.
+
+%s`, format, target, text)
+
+		validated := false
+		validate := func(candidate string) error {
+			ok, err := utils.ValidateDocument(candidate, target)
+			validated = ok
+			return err
+		}
+
+		converted, _, err := runValidatedGeneration(prompt, validate, 3)
+		if err != nil {
+			return err
+		}
+		fmt.Println(converted)
+		if !validated {
+			fmt.Fprintf(os.Stderr, "⚠️  No local validator for %q, the output was not checked.\n", target)
+		}
+		return nil
+
+	default:
+		return fmt.Errorf("unknown config command %q: use explain or convert", args[0])
+	}
+}
+
+// stringList is a repeatable string flag.
+type stringList []string
+
+func (l *stringList) String() string     { return strings.Join(*l, ", ") }
+func (l *stringList) Set(v string) error { *l = append(*l, v); return nil }
+
+// runRegex generates a regular expression and verifies it against the
+// user's examples before showing it, retrying with the failures.
+func runRegex(args []string) error {
+	fs, model := newSubcommandFlags("regex")
+	var matches, nonMatches stringList
+	fs.Var(&matches, "match", "String the regex must match (repeatable)")
+	fs.Var(&nonMatches, "no-match", "String the regex must not match (repeatable)")
+	if err := parseWithSettings(fs, args); err != nil {
+		return err
+	}
+	utils.DefaultModel = *model
+
+	description := strings.Join(fs.Args(), " ")
+	if description == "" || len(matches)+len(nonMatches) == 0 {
+		return fmt.Errorf("usage: %s", subcommands["regex"].usage)
+	}
+
+	prompt := fmt.Sprintf(`Write a regular expression in RE2 syntax (Go regexp, no lookarounds or backreferences) that: %s
+It must match each of: %q
+It must not match any of: %q
+Reply with the bare pattern alone in a code block (no slashes or quotes), followed by a short explanation.`,
+		description, []string(matches), []string(nonMatches))
+
+	validate := func(pattern string) error {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return fmt.Errorf("does not compile: %v", err)
+		}
+		var failures []string
+		for _, m := range matches {
+			if !re.MatchString(m) {
+				failures = append(failures, fmt.Sprintf("should match %q but does not", m))
+			}
+		}
+		for _, m := range nonMatches {
+			if re.MatchString(m) {
+				failures = append(failures, fmt.Sprintf("should not match %q but does", m))
+			}
+		}
+		if len(failures) > 0 {
+			return fmt.Errorf("%s", strings.Join(failures, "; "))
+		}
+		return nil
+	}
+
+	pattern, _, err := runValidatedGeneration(prompt, validate, 4)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("%s\n\n✅ Verified against %d matching and %d non-matching example(s).\n", pattern, len(matches), len(nonMatches))
+	return nil
+}
+
+// runSQL writes a query for a question about the configured database and
+// only shows it as ready once the database accepts its EXPLAIN.
+func runSQL(args []string) error {
+	fs, model := newSubcommandFlags("sql")
+	dsn := fs.String("db", os.Getenv("SQL_DATABASE"), "Database to check against: a SQLite file, postgres:// or mysql:// URL (default $SQL_DATABASE)")
+	showPlan := fs.Bool("plan", false, "Also print the query plan")
+	if err := parseWithSettings(fs, args); err != nil {
+		return err
+	}
+	utils.DefaultModel = *model
+	question := strings.Join(fs.Args(), " ")
+	if question == "" {
+		return fmt.Errorf("usage: %s", subcommands["sql"].usage)
+	}
+	db, err := utils.ParseSQLDatabase(*dsn)
+	if err != nil {
+		return err
+	}
+	schema, err := db.Schema()
+	if err != nil {
+		return fmt.Errorf("could not read the schema: %w", err)
+	}
+
+	prompt := fmt.Sprintf(`Write one read-only %s query (SELECT or WITH) that answers: %s
+Database schema:
+%s
+Use only these tables and columns. Reply with the query alone in a code block, followed by a one-sentence explanation.`,
+		db.Dialect(), question, TruncateString(schema, 30000))
+
+	var plan string
+	validate := func(query string) error {
+		plan, err = db.Explain(query)
+		return err
+	}
+	query, _, err := runValidatedGeneration(prompt, validate, 4)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("%s\n\n✅ Ready: the %s database accepted its EXPLAIN (the query was not run).\n", query, db.Dialect())
+	if *showPlan {
+		fmt.Printf("\n%s\n", plan)
+	}
+	return nil
+}
+
+// runFormula writes a spreadsheet formula and checks its syntax and function
+// names before showing it as ready.
+func runFormula(args []string) error {
+	fs, model := newSubcommandFlags("formula")
+	sheets := fs.Bool("sheets", false, "Write for Google Sheets instead of Excel")
+	columns := fs.String("columns", "", "What the sheet's columns hold, e.g. \"A: date, B: amount, C: category\"")
+	if err := parseWithSettings(fs, args); err != nil {
+		return err
+	}
+	utils.DefaultModel = *model
+	description := strings.Join(fs.Args(), " ")
+	if description == "" {
+		return fmt.Errorf("usage: %s", subcommands["formula"].usage)
+	}
+
+	app := "Excel"
+	if *sheets {
+		app = "Google Sheets"
+	}
+	prompt := fmt.Sprintf("Write a single %s formula, starting with =, that: %s\n", app, description)
+	if *columns != "" {
+		prompt += fmt.Sprintf("The sheet's columns: %s\n", *columns)
+	}
+	prompt += "Use commas between arguments. Reply with the formula alone in a code block, followed by a one-sentence explanation."
+
+	formula, _, err := runValidatedGeneration(prompt, utils.ParseFormula, 4)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("%s\n\n✅ Ready: the syntax and function names check out for %s.\n", formula, app)
+	return nil
+}
+
+// parseExampleTime accepts the date formats users are likely to type for -at.
+func parseExampleTime(s string) (time.Time, error) {
+	for _, layout := range []string{time.RFC3339, "2006-01-02T15:04", "2006-01-02 15:04", "2006-01-02"} {
+		if t, err := time.ParseInLocation(layout, s, time.Local); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("cannot parse time %q, use YYYY-MM-DD HH:MM", s)
+}
+
+// runCron generates a cron expression and verifies it against times at which
+// it must and must not fire before showing it, retrying with the failures.
+func runCron(args []string) error {
+	fs, model := newSubcommandFlags("cron")
+	var at, notAt stringList
+	fs.Var(&at, "at", "Time the schedule must fire at, YYYY-MM-DD HH:MM (repeatable)")
+	fs.Var(&notAt, "not-at", "Time the schedule must not fire at (repeatable)")
+	if err := parseWithSettings(fs, args); err != nil {
+		return err
+	}
+	utils.DefaultModel = *model
+
+	description := strings.Join(fs.Args(), " ")
+	if description == "" {
+		return fmt.Errorf("usage: %s", subcommands["cron"].usage)
+	}
+
+	var fireTimes, quietTimes []time.Time
+	for _, s := range at {
+		t, err := parseExampleTime(s)
+		if err != nil {
+			return err
+		}
+		fireTimes = append(fireTimes, t)
+	}
+	for _, s := range notAt {
+		t, err := parseExampleTime(s)
+		if err != nil {
+			return err
+		}
+		quietTimes = append(quietTimes, t)
+	}
+
+	prompt := fmt.Sprintf(`Write a standard five-field cron expression (minute hour day-of-month month day-of-week) for: %s
+Reply with the expression alone in a code block, followed by a short explanation.`, description)
+
+	validate := func(expr string) error {
+		schedule, err := utils.ParseCron(expr)
+		if err != nil {
+			return err
+		}
+		var failures []string
+		for _, t := range fireTimes {
+			if !schedule.Matches(t) {
+				failures = append(failures, fmt.Sprintf("should fire at %s (%s) but does not", t.Format("2006-01-02 15:04"), t.Weekday()))
+			}
+		}
+		for _, t := range quietTimes {
+			if schedule.Matches(t) {
+				failures = append(failures, fmt.Sprintf("should not fire at %s (%s) but does", t.Format("2006-01-02 15:04"), t.Weekday()))
+			}
+		}
+		if len(failures) > 0 {
+			return fmt.Errorf("%s", strings.Join(failures, "; "))
+		}
+		return nil
+	}
+
+	expr, _, err := runValidatedGeneration(prompt, validate, 4)
+	if err != nil {
+		return err
+	}
+
+	schedule, _ := utils.ParseCron(expr)
+	fmt.Printf("%s\n\n✅ Verified against %d firing and %d quiet time(s). Next runs:\n", expr, len(fireTimes), len(quietTimes))
+	t := time.Now()
+	for i := 0; i < 5; i++ {
+		if t = schedule.Next(t); t.IsZero() {
+			break
+		}
+		fmt.Printf("   • %s\n", t.Format("Mon 2006-01-02 15:04"))
+	}
+	return nil
+}
+
+// runHow turns a task description into a single shell command, explains it,
+// optionally runs it after confirmation and records it in the shell history.
+func runHow(args []string) error {
+	fs, model := newSubcommandFlags("how")
+	noHistory := fs.Bool("no-history", false, "Do not record the command in the shell history file (commands you decline to run are recorded commented out)")
+	if err := parseWithSettings(fs, args); err != nil {
+		return err
+	}
+	utils.DefaultModel = *model
+
+	task := strings.Join(fs.Args(), " ")
+	if task == "" {
+		return fmt.Errorf("usage: %s", subcommands["how"].usage)
+	}
+
+	shell := utils.UserShell()
+	prompt := fmt.Sprintf(`Give a single ready-to-run %s command for %s that does the following: %s
+Prefer standard tools that are installed by default. Use pipes or && if needed, but keep it on one line.
+Reply with the command alone in a code block, followed by a short explanation of each part and any risks.`, shell, runtime.GOOS, task)
+
+	validate := func(command string) error {
+		if command == "" || strings.Contains(command, "\n") {
+			return fmt.Errorf("expected exactly one line with a command")
+		}
+		// Escapes or bidi overrides could make the printed command differ
+		// from what runs, and would land in the history file.
+		if err := utils.ShellSafe(command); err != nil {
+			return err
+		}
+		// Syntax check only: -n parses without executing.
+		if shell == "bash" || shell == "zsh" || shell == "sh" {
+			var stderr bytes.Buffer
+			check := exec.Command(shell, "-n", "-c", command)
+			check.Stderr = &stderr
+			if err := check.Run(); err != nil {
+				return fmt.Errorf("%s syntax error: %s", shell, strings.TrimSpace(stderr.String()))
+			}
+		}
+		return nil
+	}
+
+	command, reply, err := runValidatedGeneration(prompt, validate, 3)
+	if err != nil {
+		return err
+	}
+
+	explanation := reply
+	if parts := strings.SplitN(reply, "```", 3); len(parts) == 3 {
+		explanation = parts[2]
+	}
+	fmt.Printf("\n  %s\n\n%s\n", command, strings.TrimSpace(explanation))
+
+	run := false
+	if !utils.ReadOnly {
+		fmt.Print("\nRun it now? [y/N]: ")
+		answer, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+		answer = strings.ToLower(strings.TrimSpace(answer))
+		run = answer == "y" || answer == "yes"
+	}
+
+	if !*noHistory {
+		// A command that was not run is recorded as a comment, so recalling
+		// it does nothing until the # is removed.
+		entry := command
+		if !run {
+			entry = "# " + command
+		}
+		if path, err := utils.AppendShellHistory(shell, entry, time.Now()); err != nil {
+			fmt.Fprintf(os.Stderr, "⚠️  Could not record the command: %v\n", err)
+		} else {
+			fmt.Fprintf(os.Stderr, "📝 Added to %s (new shells can recall it with ↑)\n", path)
+		}
+	}
+	if !run {
+		return nil
+	}
+
+	cmd := exec.Command(shell, "-c", command)
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+	return cmd.Run()
+}
+
+// runDigest summarizes the feed items published since the last run. It is
+// meant to be scheduled, e.g. from crontab, with -out pointing at a file.
+func runDigest(args []string) error {
+	fs, model := newSubcommandFlags("digest")
+	var feeds stringList
+	fs.Var(&feeds, "feed", "RSS or Atom feed URL (repeatable)")
+	feedsFile := fs.String("feeds", "", "File with one feed URL per line (# starts a comment)")
+	statePath := fs.String("state", utils.DefaultFeedStatePath(), "File that remembers items already included in a digest")
+	maxItems := fs.Int("max-per-feed", 10, "Maximum new items per feed in one digest (0 means no limit)")
+	outPath := fs.String("out", "", "Write the digest to this file instead of stdout")
+	if err := parseWithSettings(fs, args); err != nil {
+		return err
+	}
+	utils.DefaultModel = *model
+
+	if *feedsFile != "" {
+		data, err := os.ReadFile(*feedsFile)
+		if err != nil {
+			return fmt.Errorf("failed to read feeds file: %w", err)
+		}
+		for _, line := range strings.Split(string(data), "\n") {
+			if line = strings.TrimSpace(line); line != "" && !strings.HasPrefix(line, "#") {
+				feeds = append(feeds, line)
+			}
+		}
+	}
+	if len(feeds) == 0 {
+		return fmt.Errorf("usage: %s", subcommands["digest"].usage)
+	}
+
+	state, err := utils.LoadFeedState(*statePath)
+	if err != nil {
+		return err
+	}
+	shared := flyt.NewSharedStore()
+	shared.Set("feed_urls", []string(feeds))
+	shared.Set("feed_state", state)
+	shared.Set("feed_max_items", *maxItems)
+	if err := CreateFeedDigestFlow().Run(context.Background(), shared); err != nil {
+		return err
+	}
+
+	answer, _ := shared.Get("answer")
+	digest := answer.(string)
+	if *outPath == "" {
+		fmt.Println(digest)
+		return nil
+	}
+	if err := os.WriteFile(*outPath, []byte(digest+"\n"), 0644); err != nil {
+		return fmt.Errorf("failed to write digest: %w", err)
+	}
+	fmt.Fprintf(os.Stderr, "🗞️  Digest written to %s\n", *outPath)
+	return nil
+}
+
+// runKB syncs knowledge-base sources into the retrieval index and inspects it.
+func runKB(args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("usage: %s", subcommands["kb"].usage)
+	}
+	fs, model := newSubcommandFlags("kb " + args[0])
+	indexPath := fs.String("index", utils.DefaultKBIndexPath(), "Retrieval index file")
+	sourcesPath := fs.String("sources", "config/kb_sources.json", "JSON list of sources to sync")
+	only := fs.String("source", "", "Sync only the source with this name")
+	every := fs.Duration("every", 0, "Keep running and sync again at this interval (e.g. 1h)")
+	from := fs.String("from", "", "Comma-separated namespaces to search (default: all)")
+	if err := parseWithSettings(fs, args[1:]); err != nil {
+		return err
+	}
+	utils.DefaultModel = *model
+	ctx := context.Background()
+
+	index, err := utils.LoadKBIndex(*indexPath)
+	if err != nil {
+		return err
+	}
+
+	switch args[0] {
+	case "sync":
+		sources, err := utils.LoadKBSources(*sourcesPath)
+		if err != nil {
+			return err
+		}
+		for {
+			for _, source := range sources {
+				if *only != "" && source.Name != *only {
+					continue
+				}
+				n, err := index.Sync(ctx, source)
+				if err != nil {
+					if *every == 0 {
+						return err
+					}
+					fmt.Fprintf(os.Stderr, "⚠️  %v\n", err)
+					continue
+				}
+				fmt.Printf("📚 %s: %d document(s) updated\n", source.Name, n)
+			}
+			if *every == 0 {
+				return nil
+			}
+			time.Sleep(*every)
+		}
+
+	case "status":
+		counts := index.Namespaces()
+		names := make([]string, 0, len(counts))
+		for name := range counts {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			fmt.Printf("%-20s %5d document(s)  last sync %s\n", name, counts[name], index.LastSync[name].Format("2006-01-02 15:04"))
+		}
+		fmt.Printf("%d chunk(s) in %s\n", len(index.Chunks), *indexPath)
+		return nil
+
+	case "search":
+		query := strings.Join(fs.Args(), " ")
+		if query == "" {
+			return fmt.Errorf("usage: %s", subcommands["kb"].usage)
+		}
+		var namespaces []string
+		if *from != "" {
+			namespaces = strings.Split(*from, ",")
+		}
+		results, err := index.Search(ctx, query, 5, namespaces...)
+		if err != nil {
+			return err
+		}
+		for _, r := range results {
+			fmt.Printf("%.2f  %s (%s)\n      %s\n      %s\n", r.Score, r.Label(), r.Namespace, r.URL, TruncateString(strings.ReplaceAll(r.Text, "\n", " "), 160))
+		}
+		return nil
+
+	default:
+		return fmt.Errorf("usage: %s", subcommands["kb"].usage)
+	}
+}
+
+// runHistory manages the saved conversations in conversationStore.
+// A name is a key (file) or a conversation name, as for -resume.
+func runHistory(args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("usage: %s", subcommands["history"].usage)
+	}
+	fs := flag.NewFlagSet("history "+args[0], flag.ExitOnError)
+	yes := fs.Bool("y", false, "Delete without asking")
+	saveDir := fs.String("save-dir", conversationsDir, "Directory conversations are saved in")
+	if err := parseWithSettings(fs, args[1:]); err != nil {
+		return err
+	}
+	conversationsDir = *saveDir
+	var err error
+	if conversationStore, err = openStorage(); err != nil {
+		return err
+	}
+
+	switch args[0] {
+	case "list":
+		entries, err := conversationStore.List()
+		if err != nil {
+			return err
+		}
+		if len(entries) == 0 {
+			fmt.Println("No saved conversations.")
+			return nil
+		}
+
+		table := utils.MarkdownTable{Header: []string{"Saved", "Name", "Turns", "First question", "Key"}, Align: make([]int, 5)}
+		table.Align[2] = utils.AlignRight
+		for _, e := range entries {
+			first := ""
+			if len(e.Conversations) > 0 {
+				first = strings.Join(strings.Fields(e.Conversations[0].User), " ")
+			}
+			table.Rows = append(table.Rows, []string{
+				e.Updated.Format("2006-01-02 15:04"), e.Name, strconv.Itoa(len(e.Conversations)),
+				TruncateString(first, 50), filepath.Base(e.Key),
+			})
+		}
+		fmt.Print(table.Render(utils.TerminalWidth()))
+		return nil
+
+	case "show":
+		key, err := conversationStore.Find(strings.Join(fs.Args(), " "))
+		if err != nil {
+			return err
+		}
+		saved, err := conversationStore.Load(key)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("%s (%s)\n", saved.Name, key)
+		for i, c := range saved.Conversations {
+			fmt.Printf("\n%s\n%s\n\n%s\n%v\n", utils.Paint(utils.StyleUser, fmt.Sprintf("You (%d):", i+1)), c.User, utils.Paint(utils.StyleAI, "AI:"), c.AI)
+		}
+		return nil
+
+	case "delete":
+		key, err := conversationStore.Find(strings.Join(fs.Args(), " "))
+		if err != nil {
+			return err
+		}
+		if !*yes {
+			fmt.Printf("Delete %s? [y/N]: ", key)
+			answer, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+			answer = strings.ToLower(strings.TrimSpace(answer))
+			if answer != "y" && answer != "yes" {
+				return nil
+			}
+		}
+		if err := conversationStore.Delete(key); err != nil {
+			return err
+		}
+		fmt.Printf("🗑️  Deleted %s\n", key)
+		return nil
+
+	case "rename":
+		if fs.NArg() != 2 {
+			return fmt.Errorf("usage: %s", subcommands["history"].usage)
+		}
+		key, err := conversationStore.Find(fs.Arg(0))
+		if err != nil {
+			return err
+		}
+		newKey, err := conversationStore.Rename(key, fs.Arg(1))
+		if err != nil {
+			return err
+		}
+		fmt.Printf("✏️  Renamed to %s\n", newKey)
+		return nil
+	}
+	return fmt.Errorf("usage: %s", subcommands["history"].usage)
+}
+
+// runPlan explains a terraform JSON plan and, with -fail-on, fails when it is
+// riskier than allowed so CI can stop before apply.
+func runPlan(args []string) error {
+	fs, model := newSubcommandFlags("plan")
+	asJSON := fs.Bool("json", false, "Print the report as JSON instead of markdown")
+	failOn := fs.String("fail-on", "", "Exit with an error if any change is destructive, or at least this risk (high, medium, low)")
+	if err := parseWithSettings(fs, args); err != nil {
+		return err
+	}
+	utils.DefaultModel = *model
+	if _, ok := planRiskRank[*failOn]; !ok && *failOn != "" && *failOn != "destructive" {
+		return fmt.Errorf("unknown -fail-on %q (use destructive, high, medium or low)", *failOn)
+	}
+
+	var data []byte
+	var err error
+	switch fs.NArg() {
+	case 0:
+		data, err = io.ReadAll(os.Stdin)
+	case 1:
+		data, err = os.ReadFile(fs.Arg(0))
+	default:
+		return fmt.Errorf("usage: %s", subcommands["plan"].usage)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read the plan: %w", err)
+	}
+
+	shared := flyt.NewSharedStore()
+	shared.Set("plan_json", data)
+	if err := CreatePlanExplainFlow().Run(context.Background(), shared); err != nil {
+		return err
+	}
+	value, _ := shared.Get("plan_report")
+	report := value.(planReport)
+	if *asJSON {
+		out, err := json.MarshalIndent(report, "", "  ")
+		if err != nil {
+			return err
+		}
+		fmt.Println(string(out))
+	} else {
+		answer, _ := shared.Get("answer")
+		fmt.Println(answer)
+	}
+
+	switch {
+	case *failOn == "destructive" && report.Destructive > 0:
+		return fmt.Errorf("the plan has %d destructive change(s)", report.Destructive)
+	case *failOn != "" && *failOn != "destructive" && report.MaxRisk != "none" && planRiskRank[report.MaxRisk] <= planRiskRank[*failOn]:
+		return fmt.Errorf("the plan has %s-risk changes (-fail-on %s)", report.MaxRisk, *failOn)
+	}
+	return nil
+}
+
+// runReleaseNotes drafts release notes for a git range and verifies that every
+// listed change cites a commit of the range.
+func runReleaseNotes(args []string) error {
+	fs, model := newSubcommandFlags("release-notes")
+	repo := fs.String("repo", ".", "Git repository to read")
+	style := fs.String("style", "github", "Release-note style (github, keepachangelog, compact) or a file with style instructions")
+	outPath := fs.String("out", "", "Write the notes to this file instead of stdout")
+	if err := parseWithSettings(fs, args); err != nil {
+		return err
+	}
+	utils.DefaultModel = *model
+
+	var from, to string
+	switch fs.NArg() {
+	case 0:
+		to = "HEAD"
+		from = utils.LatestGitTag(*repo, to)
+	case 1:
+		var ok bool
+		from, to, ok = strings.Cut(fs.Arg(0), "..")
+		if !ok || to == "" {
+			to = "HEAD"
+		}
+	default:
+		return fmt.Errorf("usage: %s", subcommands["release-notes"].usage)
+	}
+
+	styleText, ok := releaseNoteStyles[*style]
+	if !ok {
+		data, err := os.ReadFile(*style)
+		if err != nil {
+			return fmt.Errorf("unknown -style %q (use github, keepachangelog, compact or a file): %w", *style, err)
+		}
+		styleText = strings.TrimSpace(string(data))
+	}
+
+	shared := flyt.NewSharedStore()
+	shared.Set("release_repo", *repo)
+	shared.Set("release_from", from)
+	shared.Set("release_to", to)
+	shared.Set("release_style", styleText)
+	const maxAttempts = 3
+	if err := CreateReleaseNotesFlow(maxAttempts).Run(context.Background(), shared); err != nil {
+		return err
+	}
+	notes, _ := shared.Get("candidate")
+	if validationErr, _ := shared.Get("validation_error"); validationErr != nil && validationErr != "" {
+		return fmt.Errorf("no verified release notes after %d attempts: %v", maxAttempts, validationErr)
+	}
+
+	if *outPath != "" {
+		if err := os.WriteFile(*outPath, []byte(notes.(string)+"\n"), 0644); err != nil {
+			return err
+		}
+		utils.PrintStatus("📝 Release notes written to %s", *outPath)
+		return nil
+	}
+	fmt.Println(notes)
+	return nil
+}
+
+// runActionItems extracts the action items of a meeting transcript, checked
+// against utils.ActionItemsTemplate's schema, and prints, saves or files them.
+func runActionItems(args []string) error {
+	fs, model := newSubcommandFlags("action-items")
+	format := fs.String("format", "table", "Output format: table, json or csv")
+	outPath := fs.String("out", "", "Write the items to this file instead of stdout")
+	date := fs.String("date", time.Now().Format("2006-01-02"), "Date of the meeting, for relative deadlines such as \"by Friday\"")
+	tracker := fs.String("tracker", "", "Also create a task per item in jira or linear (credentials as for /ticket)")
+	if err := parseWithSettings(fs, args); err != nil {
+		return err
+	}
+	utils.DefaultModel = *model
+	if *format != "table" && *format != "json" && *format != "csv" {
+		return fmt.Errorf("unknown -format %q (use table, json or csv)", *format)
+	}
+	if *tracker != "" && !utils.TicketTrackerConfigured(*tracker) {
+		return fmt.Errorf("-tracker %s: set its credentials first (see README)", *tracker)
+	}
+
+	var data []byte
+	var err error
+	switch fs.NArg() {
+	case 0:
+		data, err = io.ReadAll(os.Stdin)
+	case 1:
+		data, err = os.ReadFile(fs.Arg(0))
+	default:
+		return fmt.Errorf("usage: %s", subcommands["action-items"].usage)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read the transcript: %w", err)
+	}
+	transcript := strings.TrimSpace(string(data))
+	if transcript == "" {
+		return fmt.Errorf("the transcript is empty")
+	}
+
+	prompt, err := utils.ActionItemsTemplate.Render(transcript, *date)
+	if err != nil {
+		return err
+	}
+	var items []utils.ActionItem
+	_, _, err = runValidatedGeneration(prompt, func(candidate string) error {
+		items, err = utils.ParseActionItems(candidate, transcript)
+		return err
+	}, 3)
+	if err != nil {
+		return err
+	}
+
+	var out string
+	switch *format {
+	case "json":
+		encoded, err := json.MarshalIndent(items, "", "  ")
+		if err != nil {
+			return err
+		}
+		out = string(encoded) + "\n"
+	case "csv":
+		out = utils.ActionItemsTable(items).CSV()
+	default:
+		if len(items) == 0 {
+			out = "No action items.\n"
+		} else {
+			out = utils.ActionItemsTable(items).Render(utils.TerminalWidth())
+		}
+	}
+	if *outPath != "" {
+		if err := os.WriteFile(*outPath, []byte(out), 0644); err != nil {
+			return err
+		}
+		utils.PrintStatus("📝 %d action item(s) written to %s", len(items), *outPath)
+	} else {
+		fmt.Print(out)
+	}
+
+	if *tracker == "" {
+		return nil
+	}
+	for _, item := range items {
+		ticket := utils.ActionItemTicket(item)
+		description, err := utils.RenderTicket(ticket, *tracker)
+		if err != nil {
+			return err
+		}
+		created, err := utils.CreateTicket(ticket, *tracker, description)
+		if err != nil {
+			return fmt.Errorf("could not file %q: %w", item.Task, err)
+		}
+		fmt.Fprintf(os.Stderr, "🎫 Created %s\n", created)
+	}
+	return nil
+}
+
+// runReport compiles the usage log and the conversations saved in the last
+// days into a Markdown report, written to stdout or -out and, with -email,
+// saved as a draft addressed to a recipient.
+func runReport(args []string) error {
+	if len(args) == 0 || args[0] != "weekly" {
+		return fmt.Errorf("usage: %s", subcommands["report"].usage)
+	}
+	fs, model := newSubcommandFlags("report weekly")
+	days := fs.Int("days", 7, "Number of days the report covers")
+	outPath := fs.String("out", "", "Write the report to this file instead of stdout")
+	email := fs.String("email", "", "Also save the report as a draft to this address in the IMAP drafts folder (IMAP_* settings as for -mode email)")
+	topics := fs.Bool("topics", true, "Group the questions asked into topics with the model; false counts frequent words instead")
+	usageLog := fs.String("usage-log", utils.UsageLogPath, "Usage log to read")
+	saveDir := fs.String("save-dir", conversationsDir, "Directory conversations are saved in")
+	if err := parseWithSettings(fs, args[1:]); err != nil {
+		return err
+	}
+	if *days < 1 {
+		return fmt.Errorf("-days must be at least 1")
+	}
+	utils.DefaultModel = *model
+	conversationsDir = *saveDir
+	var err error
+	if conversationStore, err = openStorage(); err != nil {
+		return err
+	}
+
+	until := time.Now()
+	since := until.AddDate(0, 0, -*days)
+	records, err := utils.LoadUsageLog(*usageLog, since)
+	if err != nil {
+		return fmt.Errorf("failed to read usage log: %w", err)
+	}
+	entries, err := conversationStore.List()
+	if err != nil {
+		return err
+	}
+	var recent []storedConversation
+	for _, entry := range entries {
+		if !entry.Updated.Before(since) {
+			recent = append(recent, entry)
+		}
+	}
+
+	report := weeklyReport(context.Background(), since, until, records, recent, *topics)
+	if *outPath != "" {
+		if err := os.WriteFile(*outPath, []byte(report), 0644); err != nil {
+			return err
+		}
+		utils.PrintStatus("📝 Report written to %s", *outPath)
+	} else {
+		fmt.Print(report)
+	}
+	if *email != "" {
+		config, err := utils.IMAPConfigFromEnv()
+		if err != nil {
+			return err
+		}
+		client, err := utils.DialIMAP(config)
+		if err != nil {
+			return err
+		}
+		defer client.Close()
+		subject := fmt.Sprintf("ai_wraper report %s – %s", since.Format("Jan 2"), until.Format("Jan 2, 2006"))
+		if err := client.AppendDraft(config.Drafts, utils.ComposeMessage(config.User, *email, subject, report)); err != nil {
+			return err
+		}
+		fmt.Fprintf(os.Stderr, "📝 Draft to %s saved to %s\n", *email, config.Drafts)
+	}
+	return nil
+}
+
+// weeklyReport renders the report of the calls and conversations between
+// since and until.
+func weeklyReport(ctx context.Context, since, until time.Time, records []utils.UsageRecord, conversations []storedConversation, groupTopics bool) string {
+	usage := utils.UsageByModel{}
+	daily := map[string]utils.UsageByModel{}
+	for _, r := range records {
+		call := utils.UsageByModel{r.Model: {Calls: 1, PromptTokens: r.PromptTokens, OutputTokens: r.OutputTokens}}
+		usage.Add(call)
+		day := r.Time.Format("2006-01-02")
+		if daily[day] == nil {
+			daily[day] = utils.UsageByModel{}
+		}
+		daily[day].Add(call)
+	}
+	var questions []string
+	for _, c := range conversations {
+		for _, turn := range c.History.Conversations {
+			if q := strings.TrimSpace(turn.User); q != "" {
+				questions = append(questions, q)
+			}
+		}
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "# ai_wraper report: %s – %s\n\n", since.Format("Mon Jan 2"), until.Format("Mon Jan 2, 2006"))
+
+	b.WriteString("## Summary\n\n")
+	total := usage.Total()
+	cost, known := usage.Cost()
+	spent := fmt.Sprintf("$%.4f", cost)
+	if !known {
+		spent += " plus models without known pricing"
+	}
+	periodDays := until.Sub(since).Hours() / 24
+	fmt.Fprintf(&b, "- **Spent:** %s (about $%.4f per 30 days at this rate)\n", spent, cost/periodDays*30)
+	fmt.Fprintf(&b, "- **LLM calls:** %d, %d input and %d output tokens\n", total.Calls, total.PromptTokens, total.OutputTokens)
+	fmt.Fprintf(&b, "- **Conversations:** %d with %d questions\n", len(conversations), len(questions))
+	if busiest, calls := busiestDay(daily); calls > 0 {
+		fmt.Fprintf(&b, "- **Busiest day:** %s (%d calls)\n", busiest, calls)
+	}
+
+	if len(usage) > 0 {
+		b.WriteString("\n## Usage by model\n\n| Model | Calls | Input tokens | Output tokens | Cost |\n|---|---:|---:|---:|---:|\n")
+		models := make([]string, 0, len(usage))
+		for model := range usage {
+			models = append(models, model)
+		}
+		sort.Slice(models, func(i, j int) bool { return usage[models[i]].Calls > usage[models[j]].Calls })
+		for _, model := range models {
+			t := usage[model]
+			fmt.Fprintf(&b, "| %s | %d | %d | %d | %s |\n", model, t.Calls, t.PromptTokens, t.OutputTokens, reportCost(utils.UsageByModel{model: t}))
+		}
+
+		b.WriteString("\n## Usage by day\n\n| Day | Calls | Tokens | Cost |\n|---|---:|---:|---:|\n")
+		for day := since; !day.After(until); day = day.AddDate(0, 0, 1) {
+			u := daily[day.Format("2006-01-02")]
+			t := u.Total()
+			fmt.Fprintf(&b, "| %s | %d | %d | %s |\n", day.Format("Mon Jan 2"), t.Calls, t.PromptTokens+t.OutputTokens, reportCost(u))
+		}
+	}
+
+	if len(questions) > 0 {
+		b.WriteString("\n## Frequent topics\n\n")
+		b.WriteString(reportTopics(ctx, questions, groupTopics))
+	}
+
+	var decisions, todos []string
+	for _, c := range conversations {
+		if c.Recap != nil {
+			decisions = append(decisions, c.Recap.Decisions...)
+			todos = append(todos, c.Recap.TODOs...)
+		}
+	}
+	if len(decisions) > 0 || len(todos) > 0 {
+		b.WriteString("\n## Decisions and TODOs from session recaps\n\n")
+		for _, decision := range decisions {
+			fmt.Fprintf(&b, "- ✔ %s\n", decision)
+		}
+		for _, todo := range todos {
+			fmt.Fprintf(&b, "- [ ] %s\n", todo)
+		}
+	}
+	if len(records) == 0 {
+		b.WriteString("\n_No LLM calls were logged in this period; usage is logged from this version on._\n")
+	}
+	return b.String()
+}
+
+// busiestDay returns the day with the most calls.
+func busiestDay(daily map[string]utils.UsageByModel) (string, int) {
+	busiest, calls := "", 0
+	for day, u := range daily {
+		if n := u.Total().Calls; n > calls || (n == calls && day < busiest) {
+			busiest, calls = day, n
+		}
+	}
+	if t, err := time.Parse("2006-01-02", busiest); err == nil {
+		busiest = t.Format("Mon Jan 2")
+	}
+	return busiest, calls
+}
+
+// reportCost formats the cost of u for a report table.
+func reportCost(u utils.UsageByModel) string {
+	cost, known := u.Cost()
+	if !known {
+		return "unknown"
+	}
+	return fmt.Sprintf("$%.4f", cost)
+}
+
+// maxTopicQuestions bounds the questions sent to the model to name topics.
+const maxTopicQuestions = 200
+
+// reportTopics lists what the questions were about: grouped by the model
+// when groupTopics is set, and as the most frequent words otherwise or when
+// the model fails.
+func reportTopics(ctx context.Context, questions []string, groupTopics bool) string {
+	if groupTopics {
+		var list strings.Builder
+		for i, q := range questions[:min(len(questions), maxTopicQuestions)] {
+			fmt.Fprintf(&list, "%d. %s\n", i+1, TruncateString(strings.Join(strings.Fields(q), " "), 200))
+		}
+		prompt := fmt.Sprintf(`Here are questions a team asked an AI assistant this week:
+
+%s
+Group them into at most 8 topics, most frequent first. Reply only with a Markdown list, one line per topic in the form
+- **Topic** (N questions): one short sentence on what was asked.`, list.String())
+		topics, err := utils.CallLLM(ctx, prompt)
+		if err == nil && strings.TrimSpace(topics) != "" {
+			return strings.TrimSpace(topics) + "\n"
+		}
+		utils.PrintWarning("⚠️  Could not group topics (%v); listing frequent words instead", err)
+	}
+
+	counts := map[string]int{}
+	for _, q := range questions {
+		seen := map[string]bool{}
+		for _, word := range strings.FieldsFunc(strings.ToLower(q), func(r rune) bool {
+			return !unicode.IsLetter(r) && !unicode.IsDigit(r) && r != '-' && r != '_'
+		}) {
+			if len([]rune(word)) < 4 || reportStopWords[word] || seen[word] {
+				continue
+			}
+			seen[word] = true
+			counts[word]++
+		}
+	}
+	words := make([]string, 0, len(counts))
+	for word := range counts {
+		words = append(words, word)
+	}
+	sort.Slice(words, func(i, j int) bool {
+		if counts[words[i]] != counts[words[j]] {
+			return counts[words[i]] > counts[words[j]]
+		}
+		return words[i] < words[j]
+	})
+	var b strings.Builder
+	for _, word := range words[:min(len(words), 10)] {
+		fmt.Fprintf(&b, "- %s (%d questions)\n", word, counts[word])
+	}
+	return b.String()
+}
+
+// reportStopWords are common words that say nothing about a topic.
+var reportStopWords = map[string]bool{
+	"what": true, "when": true, "where": true, "which": true, "with": true, "this": true, "that": true,
+	"from": true, "have": true, "does": true, "there": true, "about": true, "would": true, "could": true,
+	"should": true, "into": true, "your": true, "them": true, "they": true, "then": true, "than": true,
+	"also": true, "some": true, "like": true, "just": true, "make": true, "please": true, "want": true,
+	"need": true, "using": true, "will": true, "been": true, "were": true, "here": true, "these": true,
+	"those": true, "their": true, "what's": true, "explain": true, "tell": true, "show": true, "give": true,
+}
@@ -0,0 +1,277 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"flyt-project-template/utils"
+)
+
+// agentRegistry holds the tools CreateToolUseNode offers the model with
+// -tool-choice model. Adding a tool is one Register call here; the flows
+// pick it up from the registry.
+var agentRegistry = utils.NewToolRegistry()
+
+// toolAllowlist maps the tools that stand for one of agentTools to that
+// name, so -tools and /tools allow them together with the routes of
+// -tool-choice rules. Other tools are allowed by their own name.
+var toolAllowlist = map[string]string{
+	"web_search":         "search",
+	"find_symbol":        "symbol",
+	"command_docs":       "man",
+	"youtube_transcript": "youtube",
+	"read_calendar":      "calendar",
+}
+
+// allowlistName is the name -tools uses for the registered tool name.
+func allowlistName(tool string) string {
+	if name, ok := toolAllowlist[tool]; ok {
+		return name
+	}
+	return tool
+}
+
+// toolChoices are the names -tools and /tools accept: agentTools and the
+// registered tools that do not stand for one of them.
+func toolChoices() []string {
+	choices := append([]string(nil), agentTools...)
+	for _, name := range agentRegistry.Names() {
+		if _, ok := toolAllowlist[name]; !ok {
+			choices = append(choices, name)
+		}
+	}
+	return choices
+}
+
+// confirmedTools act only after the user agrees, so they are offered only
+// where someone can be asked.
+var confirmedTools = []string{"run_shell"}
+
+// shellTimeout is how long a run_shell command may run.
+const shellTimeout = time.Minute
+
+// offeredTools returns the registered tools that are available and allowed,
+// leaving out confirmedTools unless canConfirm.
+func offeredTools(allowed func(string) bool, canConfirm bool) []utils.Tool {
+	var tools []utils.Tool
+	for _, t := range agentRegistry.Tools() {
+		if !canConfirm && slices.Contains(confirmedTools, t.Name()) {
+			continue
+		}
+		if allowed(allowlistName(t.Name())) {
+			tools = append(tools, t)
+		}
+	}
+	return tools
+}
+
+// stringArg returns a string argument of a tool call, which must be present.
+func stringArg(args map[string]any, name string) (string, error) {
+	value, _ := args[name].(string)
+	if strings.TrimSpace(value) == "" {
+		return "", fmt.Errorf("missing argument %q", name)
+	}
+	return value, nil
+}
+
+// runShell runs a command in the turn's sandbox once the user agrees to it.
+// The output comes back to the model whatever the exit status, so it can
+// react to failures; changed files wait for the user's review.
+func runShell(ctx context.Context, command string) (string, error) {
+	if err := utils.CheckWritable("running shell commands"); err != nil {
+		return "", err
+	}
+	if err := utils.ShellSafe(command); err != nil {
+		return "", err
+	}
+	sandbox, ok := utils.SandboxFrom(ctx)
+	if !ok {
+		return "", fmt.Errorf("there is no sandbox to run commands in here")
+	}
+	where := "a sandboxed copy of " + sandbox.Root
+	switch {
+	case !utils.HasShellSandbox() && !utils.AllowUnsandboxedShell:
+		return "", utils.ErrNoShellSandbox
+	case !utils.HasShellSandbox():
+		where = "a copy of " + sandbox.Root + ", UNSANDBOXED"
+	}
+	ok, err := utils.Confirmed(ctx, fmt.Sprintf("Run %q in %s?", command, where))
+	if err != nil {
+		return "", err
+	}
+	if !ok {
+		return "", fmt.Errorf("the user declined to run it")
+	}
+	utils.PrintStatus("🐚 Running %q", command)
+	result, err := utils.RunSandboxed(ctx, sandbox, command, shellTimeout)
+	if err != nil {
+		return "", err
+	}
+	return utils.GuardUntrusted("the output of the command", result.Format(command)), nil
+}
+
+// calendarTool reads the calendar configured in the environment.
+type calendarTool struct{}
+
+func (calendarTool) Name() string { return "read_calendar" }
+func (calendarTool) Description() string {
+	return "List the user's calendar events for the next two weeks and the free slots between them."
+}
+func (calendarTool) JSONSchema() map[string]any {
+	return map[string]any{"type": "object", "properties": map[string]any{}}
+}
+func (calendarTool) Available() bool {
+	_, ok := utils.CalendarFromEnv()
+	return ok
+}
+func (calendarTool) Execute(ctx context.Context, args map[string]any) (string, error) {
+	source, _ := utils.CalendarFromEnv()
+	now := time.Now()
+	_, _, text, err := readCalendar(source, now)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("Now: %s\n\n%s", now.Format("Monday 2006-01-02 15:04 MST"), text), nil
+}
+
+func init() {
+	agentRegistry.MustRegister(
+		utils.FuncTool{
+			ToolName:        "web_search",
+			ToolDescription: "Search the web for current information, news, documentation or facts you are unsure of.",
+			Schema: map[string]any{
+				"type":       "object",
+				"properties": map[string]any{"query": map[string]any{"type": "string", "description": "Search query"}},
+				"required":   []string{"query"},
+			},
+			Run: func(ctx context.Context, args map[string]any) (string, error) {
+				query, err := stringArg(args, "query")
+				if err != nil {
+					return "", err
+				}
+				results, err := utils.SearchWebDuckDuckGo(ctx, query)
+				if err != nil {
+					return "", err
+				}
+				return utils.GuardUntrusted("the web search results", utils.FormatSearchResults(results)), nil
+			},
+		},
+		utils.FuncTool{
+			ToolName:        utils.SymbolTool.Name,
+			ToolDescription: utils.SymbolTool.Description,
+			Schema:          utils.SymbolTool.Parameters,
+			Run: func(ctx context.Context, args map[string]any) (string, error) {
+				result := utils.RunSymbolTool(ctx, utils.WorkspaceRoot("."), utils.ToolCall{Name: utils.SymbolTool.Name, Args: args})
+				if result.IsError {
+					return "", fmt.Errorf("%s", result.Content)
+				}
+				return utils.GuardUntrusted("the "+utils.SymbolTool.Name+" result", result.Content), nil
+			},
+		},
+		utils.FuncTool{
+			ToolName:        "command_docs",
+			ToolDescription: "Read the man page of a program installed on this machine, to answer with the options its installed version supports. The program is not run.",
+			Schema: map[string]any{
+				"type":       "object",
+				"properties": map[string]any{"command": map[string]any{"type": "string", "description": "Program name, e.g. tar or git"}},
+				"required":   []string{"command"},
+			},
+			Run: func(ctx context.Context, args map[string]any) (string, error) {
+				command, err := stringArg(args, "command")
+				if err != nil {
+					return "", err
+				}
+				help, err := utils.CommandHelp(command)
+				if err != nil {
+					return "", err
+				}
+				return utils.GuardUntrusted("the documentation of "+command, help), nil
+			},
+		},
+		utils.FuncTool{
+			ToolName:        "youtube_transcript",
+			ToolDescription: "Fetch the transcript of a YouTube video, with timestamps.",
+			Schema: map[string]any{
+				"type":       "object",
+				"properties": map[string]any{"url": map[string]any{"type": "string", "description": "Link to the video"}},
+				"required":   []string{"url"},
+			},
+			Run: func(ctx context.Context, args map[string]any) (string, error) {
+				link, err := stringArg(args, "url")
+				if err != nil {
+					return "", err
+				}
+				_, id, ok := utils.FindYouTubeURL(link)
+				if !ok {
+					return "", fmt.Errorf("%q is not a YouTube link", link)
+				}
+				transcript, err := utils.FetchYouTubeTranscript(id)
+				if err != nil {
+					return "", err
+				}
+				return utils.GuardUntrusted("the video transcript", TruncateString(transcript, maxTranscriptChars)), nil
+			},
+		},
+		calendarTool{},
+		utils.FuncTool{
+			ToolName:        "calculator",
+			ToolDescription: "Evaluate an arithmetic expression exactly, e.g. (1.07^10 - 1) * 2500 or sqrt(2) / 3. Supports + - * / % ^, parentheses, pi, e, sqrt, abs, exp, ln, log, log2, sin, cos, tan, floor, ceil, round, min, max and pow.",
+			Schema: map[string]any{
+				"type":       "object",
+				"properties": map[string]any{"expression": map[string]any{"type": "string", "description": "The expression to evaluate"}},
+				"required":   []string{"expression"},
+			},
+			Run: func(ctx context.Context, args map[string]any) (string, error) {
+				expr, err := stringArg(args, "expression")
+				if err != nil {
+					return "", err
+				}
+				v, err := utils.Calculate(expr)
+				if err != nil {
+					return "", err
+				}
+				return strconv.FormatFloat(v, 'g', -1, 64), nil
+			},
+		},
+		utils.FuncTool{
+			ToolName:        "read_file",
+			ToolDescription: "Read a text file in the current workspace, given its path relative to the workspace root.",
+			Schema: map[string]any{
+				"type":       "object",
+				"properties": map[string]any{"path": map[string]any{"type": "string", "description": "Path relative to the workspace root, e.g. cmd/main.go"}},
+				"required":   []string{"path"},
+			},
+			Run: func(ctx context.Context, args map[string]any) (string, error) {
+				path, err := stringArg(args, "path")
+				if err != nil {
+					return "", err
+				}
+				content, err := utils.ReadWorkspaceFile(utils.WorkspaceRoot("."), path)
+				if err != nil {
+					return "", err
+				}
+				return utils.GuardUntrusted("the file "+path, content), nil
+			},
+		},
+		utils.FuncTool{
+			ToolName:        "run_shell",
+			ToolDescription: "Run a shell command in the current workspace and get its exit status, stdout and stderr. The user confirms each command first; in a sandbox it can only write inside the workspace and has no network. Prefer read_file and the other tools when they suffice.",
+			Schema: map[string]any{
+				"type":       "object",
+				"properties": map[string]any{"command": map[string]any{"type": "string", "description": "A single sh command line, e.g. go test ./..."}},
+				"required":   []string{"command"},
+			},
+			Run: func(ctx context.Context, args map[string]any) (string, error) {
+				command, err := stringArg(args, "command")
+				if err != nil {
+					return "", err
+				}
+				return runShell(ctx, command)
+			},
+		},
+	)
+}
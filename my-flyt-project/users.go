@@ -0,0 +1,509 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"flyt-project-template/utils"
+)
+
+// userTable is the -users file of a server: who may use it, with which API
+// key, and within which limits.
+type userTable struct {
+	Users []serverUser `json:"users"`
+}
+
+type serverUser struct {
+	Name string `json:"name"`
+	// KeySHA256 is the hex SHA-256 of the user's API key; the key itself is
+	// not stored.
+	KeySHA256 string `json:"key_sha256"`
+	// RPM bounds the user's requests per minute, DailyUSD and MonthlyUSD
+	// their spending per UTC day and month; 0 means no limit.
+	RPM        int     `json:"rpm,omitempty"`
+	DailyUSD   float64 `json:"daily_usd,omitempty"`
+	MonthlyUSD float64 `json:"monthly_usd,omitempty"`
+	// Admin users see every user's usage.
+	Admin bool `json:"admin,omitempty"`
+}
+
+// hashAPIKey is how API keys are kept in the user table.
+func hashAPIKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// loadUserTable reads and checks the user table at path.
+func loadUserTable(path string) (*userTable, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var table userTable
+	if err := json.Unmarshal(data, &table); err != nil {
+		return nil, fmt.Errorf("invalid user table %s: %w", path, err)
+	}
+	if err := table.check(path); err != nil {
+		return nil, err
+	}
+	return &table, nil
+}
+
+// check reports the first problem of a table read from path.
+func (table *userTable) check(path string) error {
+	names, keys := map[string]bool{}, map[string]bool{}
+	for i, u := range table.Users {
+		switch {
+		case u.Name == "" || strings.ContainsAny(u.Name, "/: \t"):
+			return fmt.Errorf("user %d in %s: name must be non-empty without /, : or spaces", i+1, path)
+		case names[u.Name]:
+			return fmt.Errorf("user %s is listed twice in %s", u.Name, path)
+		case len(u.KeySHA256) != sha256.Size*2 || strings.Trim(strings.ToLower(u.KeySHA256), "0123456789abcdef") != "":
+			return fmt.Errorf("user %s in %s: key_sha256 must be the hex SHA-256 of the API key", u.Name, path)
+		case keys[strings.ToLower(u.KeySHA256)]:
+			return fmt.Errorf("user %s in %s shares an API key with another user", u.Name, path)
+		case u.RPM < 0 || u.DailyUSD < 0 || u.MonthlyUSD < 0:
+			return fmt.Errorf("user %s in %s: limits cannot be negative", u.Name, path)
+		}
+		names[u.Name], keys[strings.ToLower(u.KeySHA256)] = true, true
+	}
+	return nil
+}
+
+// userAccounts authenticates a server's requests against its -users file,
+// which it reads again when it changes, and enforces and accounts each
+// user's limits in a StateStore: the shared one with -state, so replicas
+// enforce them together, or one in memory restored from the usage log.
+type userAccounts struct {
+	path  string
+	store utils.StateStore
+	// failOpen lets requests through when the store cannot be reached
+	// (-limits-fail-open); otherwise users with limits are refused.
+	failOpen bool
+	// writing serializes the admin API's changes to the file.
+	writing sync.Mutex
+
+	mu      sync.Mutex
+	modTime time.Time
+	byKey   map[string]serverUser
+}
+
+// openUserAccounts loads the user table at path. Without a shared store the
+// month's spending is restored from the usage log, so a restart does not
+// reset the budgets.
+func openUserAccounts(path string, shared utils.StateStore) (*userAccounts, error) {
+	a := &userAccounts{path: path, store: shared}
+	if err := a.reload(); err != nil {
+		return nil, err
+	}
+	if shared == nil {
+		a.store = utils.NewMemoryStateStore()
+		records, err := utils.LoadUsageLog(utils.UsageLogPath, monthStart(time.Now()))
+		if err != nil {
+			return nil, fmt.Errorf("failed to restore usage from the usage log: %w", err)
+		}
+		for _, record := range records {
+			if record.User != "" {
+				a.charge(context.Background(), record)
+			}
+		}
+	}
+	return a, nil
+}
+
+// reload reads the user table again if the file changed. A table that no
+// longer loads is reported and the previous one is kept.
+func (a *userAccounts) reload() error {
+	info, err := os.Stat(a.path)
+	if err != nil {
+		return err
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if info.ModTime().Equal(a.modTime) {
+		return nil
+	}
+	table, err := loadUserTable(a.path)
+	if err != nil {
+		return err
+	}
+	a.byKey = make(map[string]serverUser, len(table.Users))
+	for _, u := range table.Users {
+		a.byKey[strings.ToLower(u.KeySHA256)] = u
+	}
+	a.modTime = info.ModTime()
+	return nil
+}
+
+// update applies change to the user table file, which is replaced
+// atomically, and loads the result.
+func (a *userAccounts) update(change func(table *userTable) error) error {
+	a.writing.Lock()
+	defer a.writing.Unlock()
+	table, err := loadUserTable(a.path)
+	if err != nil {
+		return err
+	}
+	if err := change(table); err != nil {
+		return err
+	}
+	if err := table.check(a.path); err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(table, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := a.path + ".tmp"
+	if err := os.WriteFile(tmp, append(data, '\n'), 0600); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, a.path); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	a.mu.Lock()
+	a.modTime = time.Time{}
+	a.mu.Unlock()
+	return a.reload()
+}
+
+// addUser adds u with a new API key, which is returned; only its hash is kept.
+func (a *userAccounts) addUser(u serverUser) (string, error) {
+	secret := make([]byte, 24)
+	if _, err := rand.Read(secret); err != nil {
+		return "", err
+	}
+	key := hex.EncodeToString(secret)
+	u.KeySHA256 = hashAPIKey(key)
+	err := a.update(func(table *userTable) error {
+		table.Users = append(table.Users, u)
+		return nil
+	})
+	return key, err
+}
+
+// userLimits changes some of a user's limits; nil fields are kept.
+type userLimits struct {
+	RPM        *int     `json:"rpm,omitempty"`
+	DailyUSD   *float64 `json:"daily_usd,omitempty"`
+	MonthlyUSD *float64 `json:"monthly_usd,omitempty"`
+	Admin      *bool    `json:"admin,omitempty"`
+}
+
+// setLimits applies limits to the named user and returns the user.
+func (a *userAccounts) setLimits(name string, limits userLimits) (serverUser, error) {
+	var updated serverUser
+	err := a.update(func(table *userTable) error {
+		i := slices.IndexFunc(table.Users, func(u serverUser) bool { return u.Name == name })
+		if i < 0 {
+			return fmt.Errorf("no user %s", name)
+		}
+		u := &table.Users[i]
+		if limits.RPM != nil {
+			u.RPM = *limits.RPM
+		}
+		if limits.DailyUSD != nil {
+			u.DailyUSD = *limits.DailyUSD
+		}
+		if limits.MonthlyUSD != nil {
+			u.MonthlyUSD = *limits.MonthlyUSD
+		}
+		if limits.Admin != nil {
+			u.Admin = *limits.Admin
+		}
+		updated = *u
+		return nil
+	})
+	return updated, err
+}
+
+// authenticate returns the user whose API key r carries as a bearer token.
+func (a *userAccounts) authenticate(r *http.Request) (serverUser, bool) {
+	if err := a.reload(); err != nil {
+		log.Printf("⚠️ Keeping the previous user table: %v", err)
+	}
+	key, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok || key == "" {
+		return serverUser{}, false
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	u, ok := a.byKey[hashAPIKey(strings.TrimSpace(key))]
+	return u, ok
+}
+
+// users returns the users in the table, in no particular order.
+func (a *userAccounts) users() []serverUser {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	users := make([]serverUser, 0, len(a.byKey))
+	for _, u := range a.byKey {
+		users = append(users, u)
+	}
+	return users
+}
+
+// quotaError refuses a request until retryAfter.
+type quotaError struct {
+	reason     string
+	retryAfter time.Time
+}
+
+func (e *quotaError) Error() string { return e.reason }
+
+// limitsUnavailableError refuses a request of a user with limits while they
+// cannot be checked.
+type limitsUnavailableError struct{ err error }
+
+func (e *limitsUnavailableError) Error() string {
+	return fmt.Sprintf("your limits cannot be checked right now: %v", e.err)
+}
+
+func monthStart(t time.Time) time.Time {
+	t = t.UTC()
+	return time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, time.UTC)
+}
+
+// usagePeriod names the counters of a UTC day or month, which are kept a
+// while after it ends.
+type usagePeriod struct {
+	key string
+	ttl time.Duration
+}
+
+// usagePeriods returns the day and the month t is in.
+func usagePeriods(t time.Time) [2]usagePeriod {
+	t = t.UTC()
+	return [2]usagePeriod{
+		{"day:" + t.Format("2006-01-02"), 8 * 24 * time.Hour},
+		{"month:" + t.Format("2006-01"), 62 * 24 * time.Hour},
+	}
+}
+
+// microUSD is how spending is counted in the store, whose counters are integers.
+const microUSD = 1e6
+
+// admit counts a request of u against their limits, or refuses it with a
+// quotaError. While the store cannot be reached, a user with limits is
+// refused with a limitsUnavailableError, unless a.failOpen; a user without
+// any is let through.
+func (a *userAccounts) admit(ctx context.Context, u serverUser) error {
+	now := time.Now().UTC()
+	unavailable := func(err error) error {
+		if a.failOpen || (u.DailyUSD <= 0 && u.MonthlyUSD <= 0 && u.RPM <= 0) {
+			log.Printf("user limits unavailable, not applied: %v", err)
+			return nil
+		}
+		log.Printf("user limits unavailable, refusing %s: %v", u.Name, err)
+		return &limitsUnavailableError{err}
+	}
+	usage, err := a.usage(ctx, u.Name, now)
+	if err != nil {
+		return unavailable(err)
+	}
+	if u.DailyUSD > 0 && usage.Today.CostUSD >= u.DailyUSD {
+		return &quotaError{fmt.Sprintf("%s has spent today's budget of $%g", u.Name, u.DailyUSD), now.Truncate(24 * time.Hour).Add(24 * time.Hour)}
+	}
+	if u.MonthlyUSD > 0 && usage.Month.CostUSD >= u.MonthlyUSD {
+		return &quotaError{fmt.Sprintf("%s has spent this month's budget of $%g", u.Name, u.MonthlyUSD), monthStart(now).AddDate(0, 1, 0)}
+	}
+	if u.RPM > 0 {
+		minute := now.Truncate(time.Minute)
+		n, err := a.store.Incr(ctx, "user_rpm:"+u.Name+":"+strconv.FormatInt(minute.Unix(), 10), 1, 2*time.Minute)
+		if err != nil {
+			if err := unavailable(err); err != nil {
+				return err
+			}
+		} else if n > int64(u.RPM) {
+			return &quotaError{fmt.Sprintf("%s is limited to %d requests per minute", u.Name, u.RPM), minute.Add(time.Minute)}
+		}
+	}
+	for _, period := range usagePeriods(now) {
+		a.store.Incr(ctx, "usage:"+u.Name+":"+period.key+":requests", 1, period.ttl)
+	}
+	return nil
+}
+
+// charge adds one call's tokens and cost to its user's usage.
+func (a *userAccounts) charge(ctx context.Context, record utils.UsageRecord) {
+	ctx = context.WithoutCancel(ctx)
+	counts := []struct {
+		field string
+		n     int64
+	}{
+		{"calls", 1},
+		{"prompt_tokens", int64(record.PromptTokens)},
+		{"output_tokens", int64(record.OutputTokens)},
+		{"cost", int64(math.Round(record.Cost() * microUSD))},
+	}
+	for _, period := range usagePeriods(record.Time) {
+		for _, c := range counts {
+			if _, err := a.store.Incr(ctx, "usage:"+record.User+":"+period.key+":"+c.field, c.n, period.ttl); err != nil {
+				log.Printf("⚠️ Usage of %s not recorded: %v", record.User, err)
+				return
+			}
+		}
+	}
+}
+
+// periodUsage is a user's usage in a day or month.
+type periodUsage struct {
+	Requests     int64   `json:"requests"`
+	Calls        int64   `json:"calls"`
+	PromptTokens int64   `json:"prompt_tokens"`
+	OutputTokens int64   `json:"output_tokens"`
+	CostUSD      float64 `json:"cost_usd"`
+}
+
+type userUsage struct {
+	User  string      `json:"user"`
+	Today periodUsage `json:"today"`
+	Month periodUsage `json:"month"`
+	// Limits are the user's limits, 0 meaning none.
+	Limits struct {
+		RPM        int     `json:"rpm"`
+		DailyUSD   float64 `json:"daily_usd"`
+		MonthlyUSD float64 `json:"monthly_usd"`
+	} `json:"limits"`
+}
+
+// usage reads name's usage today and this month.
+func (a *userAccounts) usage(ctx context.Context, name string, now time.Time) (userUsage, error) {
+	usage := userUsage{User: name}
+	for i, period := range usagePeriods(now) {
+		p := &usage.Today
+		if i == 1 {
+			p = &usage.Month
+		}
+		for _, c := range []struct {
+			field string
+			into  *int64
+		}{
+			{"requests", &p.Requests},
+			{"calls", &p.Calls},
+			{"prompt_tokens", &p.PromptTokens},
+			{"output_tokens", &p.OutputTokens},
+		} {
+			n, err := a.store.Incr(ctx, "usage:"+name+":"+period.key+":"+c.field, 0, period.ttl)
+			if err != nil {
+				return usage, err
+			}
+			*c.into = n
+		}
+		cost, err := a.store.Incr(ctx, "usage:"+name+":"+period.key+":cost", 0, period.ttl)
+		if err != nil {
+			return usage, err
+		}
+		p.CostUSD = float64(cost) / microUSD
+	}
+	return usage, nil
+}
+
+// withLimits fills in u's limits.
+func (usage userUsage) withLimits(u serverUser) userUsage {
+	usage.Limits.RPM, usage.Limits.DailyUSD, usage.Limits.MonthlyUSD = u.RPM, u.DailyUSD, u.MonthlyUSD
+	return usage
+}
+
+type serverUserKey struct{}
+
+// requestUser returns the user a request was authenticated as, if the
+// server has -users.
+func requestUser(r *http.Request) (serverUser, bool) {
+	u, ok := r.Context().Value(serverUserKey{}).(serverUser)
+	return u, ok
+}
+
+// scoped keeps users apart: with -users, workspace names and request IDs
+// are prefixed with the user's name, so one user cannot read or cancel
+// another's.
+func scoped(r *http.Request, name string) string {
+	if u, ok := requestUser(r); ok && name != "" {
+		return u.Name + "/" + name
+	}
+	return name
+}
+
+// authenticate lets only requests with a known API key through.
+func (s *server) authenticate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		u, ok := s.users.authenticate(r)
+		if !ok {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="ai_wraper"`)
+			writeError(w, http.StatusUnauthorized, "a valid API key is required (Authorization: Bearer <key>)")
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), serverUserKey{}, u)))
+	})
+}
+
+// limited counts a request that calls the model against its user's limits,
+// and charges the calls it makes to them.
+func (s *server) limited(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		u, ok := requestUser(r)
+		if !ok {
+			h(w, r)
+			return
+		}
+		if err := s.users.admit(r.Context(), u); err != nil {
+			if _, ok := err.(*limitsUnavailableError); ok {
+				w.Header().Set("Retry-After", "10")
+				writeError(w, http.StatusServiceUnavailable, "%v", err)
+				return
+			}
+			if q, ok := err.(*quotaError); ok {
+				w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(time.Until(q.retryAfter).Seconds()))))
+			}
+			writeError(w, http.StatusTooManyRequests, "%v", err)
+			return
+		}
+		ctx := utils.WithUsageAccount(r.Context(), u.Name, func(record utils.UsageRecord) {
+			s.users.charge(r.Context(), record)
+		})
+		h(w, r.WithContext(ctx))
+	}
+}
+
+// handleUsage reports the caller's usage and limits; admins get every user's.
+func (s *server) handleUsage(w http.ResponseWriter, r *http.Request) {
+	u, ok := requestUser(r)
+	if !ok {
+		writeError(w, http.StatusNotFound, "usage is accounted per user; start the server with -users")
+		return
+	}
+	users := []serverUser{u}
+	if u.Admin {
+		users = s.users.users()
+	}
+	now := time.Now()
+	report := make([]userUsage, 0, len(users))
+	for _, user := range users {
+		usage, err := s.users.usage(r.Context(), user.Name, now)
+		if err != nil {
+			writeError(w, http.StatusServiceUnavailable, "%v", err)
+			return
+		}
+		report = append(report, usage.withLimits(user))
+	}
+	if !u.Admin {
+		writeJSON(w, http.StatusOK, report[0])
+		return
+	}
+	slices.SortFunc(report, func(a, b userUsage) int { return strings.Compare(a.User, b.User) })
+	writeJSON(w, http.StatusOK, map[string]any{"users": report})
+}
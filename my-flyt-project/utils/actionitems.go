@@ -0,0 +1,175 @@
+package utils
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"text/template"
+)
+
+// ExtractionTemplate bundles a prompt template with the JSON Schema its reply
+// must match, so a structured extraction can be validated and retried.
+type ExtractionTemplate struct {
+	Name   string
+	Prompt string // text/template over .Input, .Date and .Schema
+	Schema map[string]any
+}
+
+// Render fills in the prompt for input. date anchors relative dates such as "by Friday".
+func (t ExtractionTemplate) Render(input, date string) (string, error) {
+	schema, err := json.MarshalIndent(t.Schema, "", "  ")
+	if err != nil {
+		return "", err
+	}
+	tmpl, err := template.New(t.Name).Parse(t.Prompt)
+	if err != nil {
+		return "", fmt.Errorf("invalid %s template: %w", t.Name, err)
+	}
+	var b strings.Builder
+	err = tmpl.Execute(&b, map[string]string{"Input": input, "Date": date, "Schema": string(schema)})
+	if err != nil {
+		return "", fmt.Errorf("failed to render %s template: %w", t.Name, err)
+	}
+	return b.String(), nil
+}
+
+// Validate parses reply as JSON and checks it against the schema.
+func (t ExtractionTemplate) Validate(reply string) (any, error) {
+	var value any
+	if err := json.Unmarshal([]byte(ExtractJSON(reply)), &value); err != nil {
+		return nil, fmt.Errorf("not valid JSON: %w", err)
+	}
+	if err := ValidateJSONSchema(value, t.Schema); err != nil {
+		return nil, err
+	}
+	return value, nil
+}
+
+// ActionItem is a follow-up task agreed in a meeting.
+type ActionItem struct {
+	Task     string `json:"task"`
+	Owner    string `json:"owner"`           // a name from the transcript, or "unassigned"
+	Due      string `json:"due,omitempty"`   // YYYY-MM-DD, empty when no date was given
+	Priority string `json:"priority"`        // high, medium or low
+	Quote    string `json:"quote,omitempty"` // what was said, as evidence
+}
+
+// ActionItemsTemplate turns a meeting transcript into action items.
+var ActionItemsTemplate = ExtractionTemplate{
+	Name: "action-items",
+	Prompt: `Extract the action items from this meeting transcript. The meeting took place on {{.Date}}.
+
+Transcript:
+"""
+{{.Input}}
+"""
+
+Rules:
+- Only include tasks someone agreed or was asked to do; skip discussion and decisions without a follow-up.
+- owner is the person's name as it appears in the transcript, or "unassigned" when nobody took it.
+- due is the deadline as YYYY-MM-DD, resolving relative dates ("by Friday", "next week") from the meeting date; leave it out when no deadline was mentioned.
+- priority is high when it blocks others or is urgent, low when it is a nice-to-have, otherwise medium.
+- quote is the shortest sentence from the transcript that shows the item.
+
+Reply with only a JSON object matching this JSON Schema:
+{{.Schema}}`,
+	Schema: map[string]any{
+		"type":                 "object",
+		"additionalProperties": false,
+		"required":             []string{"action_items"},
+		"properties": map[string]any{
+			"action_items": map[string]any{
+				"type": "array",
+				"items": map[string]any{
+					"type":                 "object",
+					"additionalProperties": false,
+					"required":             []string{"task", "owner", "priority"},
+					"properties": map[string]any{
+						"task":     map[string]any{"type": "string", "minLength": 3},
+						"owner":    map[string]any{"type": "string", "minLength": 1},
+						"due":      map[string]any{"type": "string", "pattern": `^\d{4}-\d{2}-\d{2}$`},
+						"priority": map[string]any{"type": "string", "enum": []string{"high", "medium", "low"}},
+						"quote":    map[string]any{"type": "string"},
+					},
+				},
+			},
+		},
+	},
+}
+
+// SessionRecapTemplate turns a chat transcript into a recap to pick the
+// conversation up from later.
+var SessionRecapTemplate = ExtractionTemplate{
+	Name: "session-recap",
+	Prompt: `Write a recap of this conversation between a user and an AI assistant, ending on {{.Date}}, for the user to pick it up from later.
+
+Conversation:
+"""
+{{.Input}}
+"""
+
+Rules:
+- summary is 2-4 sentences on what was discussed and where it was left.
+- decisions are what the user settled on (a chosen approach, a value, a library); leave the list empty when nothing was decided.
+- todos are the open tasks: things the user said they would do, asked to do later, or that were left unfinished, each starting with a verb.
+
+Reply with only a JSON object matching this JSON Schema:
+{{.Schema}}`,
+	Schema: map[string]any{
+		"type":                 "object",
+		"additionalProperties": false,
+		"required":             []string{"summary", "decisions", "todos"},
+		"properties": map[string]any{
+			"summary":   map[string]any{"type": "string", "minLength": 10},
+			"decisions": map[string]any{"type": "array", "items": map[string]any{"type": "string", "minLength": 3}},
+			"todos":     map[string]any{"type": "array", "items": map[string]any{"type": "string", "minLength": 3}},
+		},
+	},
+}
+
+// ParseActionItems validates reply with ActionItemsTemplate and checks that
+// every owner is named in transcript, so invented owners are caught.
+func ParseActionItems(reply, transcript string) ([]ActionItem, error) {
+	if _, err := ActionItemsTemplate.Validate(reply); err != nil {
+		return nil, err
+	}
+	var parsed struct {
+		ActionItems []ActionItem `json:"action_items"`
+	}
+	if err := json.Unmarshal([]byte(ExtractJSON(reply)), &parsed); err != nil {
+		return nil, err
+	}
+	lower := strings.ToLower(transcript)
+	var problems []string
+	for i, item := range parsed.ActionItems {
+		if item.Owner != "unassigned" && !strings.Contains(lower, strings.ToLower(item.Owner)) {
+			problems = append(problems, fmt.Sprintf("$.action_items[%d].owner: %q is not named in the transcript", i, item.Owner))
+		}
+	}
+	if len(problems) > 0 {
+		return nil, fmt.Errorf("%s", strings.Join(problems, "\n"))
+	}
+	return parsed.ActionItems, nil
+}
+
+// ActionItemsTable lays items out as a table, for the terminal or (with CSV) a spreadsheet.
+func ActionItemsTable(items []ActionItem) MarkdownTable {
+	table := MarkdownTable{Header: []string{"task", "owner", "due", "priority", "quote"}, Align: make([]int, 5)}
+	for _, item := range items {
+		table.Rows = append(table.Rows, []string{item.Task, item.Owner, item.Due, item.Priority, item.Quote})
+	}
+	return table
+}
+
+// ActionItemTicket turns an item into a task for CreateTicket.
+func ActionItemTicket(item ActionItem) Ticket {
+	severity := map[string]string{"high": "major", "medium": "minor", "low": "trivial"}[item.Priority]
+	summary := fmt.Sprintf("Owner: %s", item.Owner)
+	if item.Due != "" {
+		summary += fmt.Sprintf("\nDue: %s", item.Due)
+	}
+	if item.Quote != "" {
+		summary += fmt.Sprintf("\nFrom the meeting: %q", item.Quote)
+	}
+	return Ticket{Title: truncateRunes(item.Task, 80), Type: "task", Summary: summary, Severity: severity}
+}
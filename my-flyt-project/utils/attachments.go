@@ -0,0 +1,153 @@
+package utils
+
+import (
+	"fmt"
+	"image"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// MaxInlineRequestBytes is the largest request Gemini accepts with inline
+// (base64) attachments. Larger files go through the File API.
+const MaxInlineRequestBytes = 20 << 20
+
+// Attachment describes a file that will be sent alongside a prompt.
+type Attachment struct {
+	Path        string
+	MIMEType    string
+	Width       int
+	Height      int
+	Pages       int
+	Size        int64
+	EncodedSize int64
+}
+
+// String formats the attachment for the pre-send preview.
+func (a Attachment) String() string {
+	details := a.MIMEType
+	switch {
+	case a.Pages > 0:
+		details += fmt.Sprintf(", %d page(s)", a.Pages)
+	case a.Width > 0:
+		details += fmt.Sprintf(", %dx%d px", a.Width, a.Height)
+	}
+	return fmt.Sprintf("%s (%s, %s encoded)", a.Path, details, FormatBytes(a.EncodedSize))
+}
+
+// MIMETypeForPath resolves the MIME type of a supported attachment from its extension.
+func MIMETypeForPath(path string) (string, error) {
+	ext := strings.ToLower(filepath.Ext(path))
+	switch ext {
+	case ".jpg", ".jpeg":
+		return "image/jpeg", nil
+	case ".png":
+		return "image/png", nil
+	case ".webp":
+		return "image/webp", nil
+	case ".heic":
+		return "image/heic", nil
+	case ".heif":
+		return "image/heif", nil
+	case ".pdf":
+		return "application/pdf", nil
+	case ".mp4":
+		return "video/mp4", nil
+	case ".mov":
+		return "video/quicktime", nil
+	case ".webm":
+		return "video/webm", nil
+	case ".mpeg", ".mpg":
+		return "video/mpeg", nil
+	default:
+		return "", fmt.Errorf("unsupported attachment type %q for %s: use .jpg, .png, .webp, .heic, .heif, .pdf, .mp4, .mov, .webm or .mpeg", ext, path)
+	}
+}
+
+// InspectAttachment reads the metadata of an attachment without loading it into a request.
+func InspectAttachment(path string) (Attachment, error) {
+	a := Attachment{Path: path}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		return a, fmt.Errorf("cannot attach %s: %w", path, err)
+	}
+	if info.IsDir() {
+		return a, fmt.Errorf("cannot attach %s: it is a directory, pass individual files instead", path)
+	}
+	a.Size = info.Size()
+	a.EncodedSize = base64Len(a.Size)
+
+	a.MIMEType, err = MIMETypeForPath(path)
+	if err != nil {
+		return a, err
+	}
+
+	if a.MIMEType == "application/pdf" {
+		a.Pages, err = CountPDFPages(path)
+		if err != nil {
+			return a, err
+		}
+		return a, nil
+	}
+	// Videos are sent as they are, and may be too large to read here.
+	if strings.HasPrefix(a.MIMEType, "video/") {
+		return a, nil
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return a, fmt.Errorf("cannot attach %s: %w", path, err)
+	}
+	defer f.Close()
+	if cfg, _, err := image.DecodeConfig(f); err == nil {
+		a.Width, a.Height = cfg.Width, cfg.Height
+	}
+
+	// Report what will actually be uploaded after pre-processing.
+	data, mimeType, err := PrepareImage(path)
+	if err != nil {
+		return a, err
+	}
+	a.MIMEType = mimeType
+	a.EncodedSize = base64Len(int64(len(data)))
+	return a, nil
+}
+
+// ValidateAttachments inspects every path and rejects unsupported files or
+// files larger than the File API accepts. Attachments beyond the inline
+// request limit are uploaded by the Gemini provider; other providers refuse
+// them when sending.
+func ValidateAttachments(paths []string) ([]Attachment, error) {
+	attachments := make([]Attachment, 0, len(paths))
+	for _, path := range paths {
+		a, err := InspectAttachment(path)
+		if err != nil {
+			return nil, err
+		}
+		if a.Size > MaxFileAPIBytes {
+			return nil, fmt.Errorf("%s is %s, above the %s File API limit: compress it or split it",
+				path, FormatBytes(a.Size), FormatBytes(MaxFileAPIBytes))
+		}
+		attachments = append(attachments, a)
+	}
+	return attachments, nil
+}
+
+// FormatBytes renders a byte count in human-readable units.
+func FormatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
+
+func base64Len(n int64) int64 {
+	return (n + 2) / 3 * 4
+}
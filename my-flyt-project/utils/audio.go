@@ -0,0 +1,159 @@
+package utils
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// AudioSegmentSeconds is the length of the pieces long audio is split into
+// before transcription. Ten minutes of mono 64 kbps MP3 is about 5 MB, well
+// under the inline request limit.
+var AudioSegmentSeconds = 600
+
+// AudioCheckpointDir returns the cache directory for an audio file, keyed by
+// its content, where segments and finished transcripts are kept between runs.
+func AudioCheckpointDir(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", fmt.Errorf("failed to read %s: %w", path, err)
+	}
+	cache, err := os.UserCacheDir()
+	if err != nil {
+		cache = os.TempDir()
+	}
+	return filepath.Join(cache, "ai_wraper", "audio", hex.EncodeToString(h.Sum(nil))[:16]), nil
+}
+
+// SplitAudio cuts path into mono MP3 segments of AudioSegmentSeconds in dir
+// with ffmpeg and returns them in order. Existing segments are reused.
+func SplitAudio(path, dir string) ([]string, error) {
+	pattern := filepath.Join(dir, "segment-*.mp3")
+	if segments, _ := filepath.Glob(pattern); len(segments) > 0 {
+		if _, err := os.Stat(filepath.Join(dir, "segments.done")); err == nil {
+			sort.Strings(segments)
+			return segments, nil
+		}
+	}
+	if _, err := exec.LookPath("ffmpeg"); err != nil {
+		return nil, fmt.Errorf("ffmpeg is not installed")
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+
+	cmd := exec.Command("ffmpeg", "-y", "-loglevel", "error", "-i", path,
+		"-vn", "-ac", "1", "-c:a", "libmp3lame", "-b:a", "64k",
+		"-f", "segment", "-segment_time", strconv.Itoa(AudioSegmentSeconds),
+		filepath.Join(dir, "segment-%04d.mp3"))
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return nil, fmt.Errorf("ffmpeg failed: %s", strings.TrimSpace(string(out)))
+	}
+	// The marker makes an interrupted split start over instead of leaving a truncated last segment.
+	if err := os.WriteFile(filepath.Join(dir, "segments.done"), nil, 0644); err != nil {
+		return nil, err
+	}
+	segments, err := filepath.Glob(pattern)
+	sort.Strings(segments)
+	return segments, err
+}
+
+// TranscribeAudio transcribes one segment with "gemini" or a local "whisper"
+// (the openai-whisper CLI), as "[m:ss] text" lines relative to the segment start.
+func TranscribeAudio(ctx context.Context, path, transcriber string, config *LLMConfig) (string, error) {
+	switch transcriber {
+	case "", "gemini":
+		return transcribeWithGemini(ctx, path, config)
+	case "whisper":
+		return transcribeWithWhisper(path)
+	default:
+		return "", fmt.Errorf("unknown transcriber %q (use gemini or whisper)", transcriber)
+	}
+}
+
+func transcribeWithGemini(ctx context.Context, path string, config *LLMConfig) (string, error) {
+	apiKey, err := getGEMINIAPIKey()
+	if err != nil {
+		return "", err
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	parts := []map[string]any{
+		{"text": "Transcribe this audio verbatim. Start a new line at every change of speaker or about every 30 seconds, prefixed with the time from the start of the audio as [m:ss]. Label speakers (Speaker 1, Speaker 2, or names when they are said). Reply with only the transcript."},
+		{"inline_data": map[string]any{"mime_type": "audio/mp3", "data": base64.StdEncoding.EncodeToString(data)}},
+	}
+	return callLLMWithParts(ctx, apiKey, parts, config)
+}
+
+var vttCuePattern = regexp.MustCompile(`^(?:(\d+):)?(\d{2}):(\d{2})\.\d{3} -->`)
+
+func transcribeWithWhisper(path string) (string, error) {
+	if _, err := exec.LookPath("whisper"); err != nil {
+		return "", fmt.Errorf("whisper is not installed (pip install openai-whisper)")
+	}
+	dir, err := os.MkdirTemp("", "ai-whisper-*")
+	if err != nil {
+		return "", err
+	}
+	defer os.RemoveAll(dir)
+
+	cmd := exec.Command("whisper", path, "--output_format", "vtt", "--output_dir", dir, "--verbose", "False")
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return "", fmt.Errorf("whisper failed: %s", strings.TrimSpace(string(out)))
+	}
+	vtt, err := os.Open(filepath.Join(dir, strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))+".vtt"))
+	if err != nil {
+		return "", fmt.Errorf("whisper wrote no transcript: %w", err)
+	}
+	defer vtt.Close()
+
+	var b strings.Builder
+	scanner := bufio.NewScanner(vtt)
+	stamp := ""
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if m := vttCuePattern.FindStringSubmatch(line); m != nil {
+			h, _ := strconv.Atoi(m[1])
+			mins, _ := strconv.Atoi(m[2])
+			secs, _ := strconv.Atoi(m[3])
+			stamp = FormatTimestamp(float64(h*3600 + mins*60 + secs))
+			continue
+		}
+		if line != "" && stamp != "" {
+			fmt.Fprintf(&b, "[%s] %s\n", stamp, line)
+			stamp = ""
+		}
+	}
+	return b.String(), scanner.Err()
+}
+
+var timestampPattern = regexp.MustCompile(`\[(?:(\d+):)?(\d{1,2}):(\d{2})\]`)
+
+// ShiftTimestamps adds offset seconds to every [m:ss] or [h:mm:ss] timestamp in text.
+func ShiftTimestamps(text string, offset int) string {
+	return timestampPattern.ReplaceAllStringFunc(text, func(stamp string) string {
+		m := timestampPattern.FindStringSubmatch(stamp)
+		h, _ := strconv.Atoi(m[1])
+		mins, _ := strconv.Atoi(m[2])
+		secs, _ := strconv.Atoi(m[3])
+		return "[" + FormatTimestamp(float64(h*3600+mins*60+secs+offset)) + "]"
+	})
+}
@@ -0,0 +1,192 @@
+package utils
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// BatchPollInterval is how often a submitted batch job is checked for completion.
+var BatchPollInterval = 30 * time.Second
+
+const geminiAPIBase = "https://generativelanguage.googleapis.com/v1beta/"
+
+// SubmitBatch submits prompts as one asynchronous Gemini batch job, billed at the
+// discounted batch rate, and returns the job name (for example "batches/123").
+func SubmitBatch(ctx context.Context, prompts []string, config *LLMConfig) (string, error) {
+	config = withPersona(config)
+	sys := loadSystemInstructions()
+	requests := make([]map[string]any, len(prompts))
+	for i, prompt := range prompts {
+		request := map[string]any{
+			"contents": []map[string]any{
+				{
+					"role":  "user",
+					"parts": []map[string]string{{"text": prompt}},
+				},
+			},
+			"generationConfig": map[string]any{
+				"temperature": config.Temperature,
+			},
+		}
+		if sys != "" {
+			request["systemInstruction"] = map[string]any{
+				"parts": []map[string]string{{"text": sys}},
+			}
+		}
+		if config.MaxTokens > 0 {
+			request["generationConfig"].(map[string]any)["maxOutputTokens"] = config.MaxTokens
+		}
+		requests[i] = map[string]any{
+			"request":  request,
+			"metadata": map[string]string{"key": strconv.Itoa(i)},
+		}
+	}
+
+	body := map[string]any{
+		"batch": map[string]any{
+			"display_name": fmt.Sprintf("flyt-batch-%d", time.Now().Unix()),
+			"input_config": map[string]any{
+				"requests": map[string]any{"requests": requests},
+			},
+		},
+	}
+	release, err := DefaultScheduler.Acquire(ctx, PriorityBackground)
+	if err != nil {
+		return "", err
+	}
+	defer release()
+
+	var op struct {
+		Name string `json:"name"`
+	}
+	if err := geminiJSON(ctx, "POST", "models/"+config.Model+":batchGenerateContent", body, &op); err != nil {
+		return "", err
+	}
+	if op.Name == "" {
+		return "", fmt.Errorf("batch submission returned no job name")
+	}
+	return op.Name, nil
+}
+
+// WaitForBatch polls the batch job until it finishes and returns the answers in
+// the order of the submitted prompts. onState is called whenever the state changes.
+func WaitForBatch(ctx context.Context, name string, onState func(state string)) ([]string, error) {
+	lastState := ""
+	for {
+		var op struct {
+			Done     bool `json:"done"`
+			Metadata struct {
+				State string `json:"state"`
+			} `json:"metadata"`
+			Error *struct {
+				Message string `json:"message"`
+			} `json:"error"`
+			Response struct {
+				InlinedResponses struct {
+					InlinedResponses []struct {
+						Metadata struct {
+							Key string `json:"key"`
+						} `json:"metadata"`
+						Response struct {
+							Candidates []struct {
+								Content struct {
+									Parts []struct {
+										Text string `json:"text"`
+									} `json:"parts"`
+								} `json:"content"`
+							} `json:"candidates"`
+						} `json:"response"`
+						Error *struct {
+							Message string `json:"message"`
+						} `json:"error"`
+					} `json:"inlinedResponses"`
+				} `json:"inlinedResponses"`
+			} `json:"response"`
+		}
+		if err := geminiJSON(ctx, "GET", name, nil, &op); err != nil {
+			return nil, err
+		}
+		if state := op.Metadata.State; state != lastState && onState != nil {
+			onState(state)
+			lastState = state
+		}
+
+		switch {
+		case op.Error != nil:
+			return nil, fmt.Errorf("batch %s failed: %s", name, op.Error.Message)
+		case strings.HasSuffix(op.Metadata.State, "_FAILED"), strings.HasSuffix(op.Metadata.State, "_CANCELLED"), strings.HasSuffix(op.Metadata.State, "_EXPIRED"):
+			return nil, fmt.Errorf("batch %s ended in state %s", name, op.Metadata.State)
+		case op.Done:
+			inlined := op.Response.InlinedResponses.InlinedResponses
+			answers := make([]string, len(inlined))
+			for i, r := range inlined {
+				idx := i
+				if n, err := strconv.Atoi(r.Metadata.Key); err == nil && n >= 0 && n < len(answers) {
+					idx = n
+				}
+				switch {
+				case r.Error != nil:
+					answers[idx] = "error: " + r.Error.Message
+				case len(r.Response.Candidates) > 0 && len(r.Response.Candidates[0].Content.Parts) > 0:
+					answers[idx] = r.Response.Candidates[0].Content.Parts[0].Text
+				}
+			}
+			return answers, nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, fmt.Errorf("stopped waiting for batch %s (it keeps running server-side): %w", name, ctx.Err())
+		case <-time.After(BatchPollInterval):
+		}
+	}
+}
+
+// geminiJSON sends a JSON request to the Gemini REST API and decodes the JSON response into out.
+func geminiJSON(ctx context.Context, method, path string, body any, out any) error {
+	apiKey, err := getGEMINIAPIKey()
+	if err != nil {
+		return err
+	}
+
+	var reader io.Reader
+	if body != nil {
+		jsonData, err := json.Marshal(body)
+		if err != nil {
+			return fmt.Errorf("failed to marshal request: %w", err)
+		}
+		reader = bytes.NewBuffer(jsonData)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, geminiAPIBase+path+"?key="+apiKey, reader)
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	client := &http.Client{Timeout: 60 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to make request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("failed to read response: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("API request failed with status %d: %s", resp.StatusCode, string(respBody))
+	}
+	if err := json.Unmarshal(respBody, out); err != nil {
+		return fmt.Errorf("failed to parse response: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,253 @@
+package utils
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// Calculate evaluates an arithmetic expression: numbers, + - * / % and ^
+// (power), parentheses, the constants pi and e, and the functions sqrt,
+// abs, exp, ln, log (base 10), log2, sin, cos, tan, floor, ceil, round, min,
+// max and pow. Models are unreliable at arithmetic; this is exact to float64.
+func Calculate(expr string) (float64, error) {
+	c := &calculator{src: expr}
+	c.next()
+	v, err := c.sum()
+	if err != nil {
+		return 0, err
+	}
+	if c.tok != "" {
+		return 0, fmt.Errorf("unexpected %q at position %d", c.tok, c.start+1)
+	}
+	if math.IsNaN(v) || math.IsInf(v, 0) {
+		return 0, fmt.Errorf("the result is not a finite number")
+	}
+	return v, nil
+}
+
+// calculator is a recursive-descent parser over src; tok is the current
+// token ("" at the end) and start its offset.
+type calculator struct {
+	src        string
+	pos, start int
+	tok        string
+}
+
+func (c *calculator) next() {
+	for c.pos < len(c.src) && c.src[c.pos] == ' ' {
+		c.pos++
+	}
+	c.start = c.pos
+	if c.pos >= len(c.src) {
+		c.tok = ""
+		return
+	}
+	r := rune(c.src[c.pos])
+	end := c.pos + 1
+	switch {
+	case unicode.IsDigit(r) || r == '.':
+		for end < len(c.src) && (unicode.IsDigit(rune(c.src[end])) || c.src[end] == '.' || c.src[end] == '_') {
+			end++
+		}
+		// An exponent, as in 1e-3.
+		if end < len(c.src) && (c.src[end] == 'e' || c.src[end] == 'E') {
+			exp := end + 1
+			if exp < len(c.src) && (c.src[exp] == '+' || c.src[exp] == '-') {
+				exp++
+			}
+			if exp < len(c.src) && unicode.IsDigit(rune(c.src[exp])) {
+				for end = exp; end < len(c.src) && unicode.IsDigit(rune(c.src[end])); end++ {
+				}
+			}
+		}
+	case unicode.IsLetter(r):
+		for end < len(c.src) && (unicode.IsLetter(rune(c.src[end])) || unicode.IsDigit(rune(c.src[end]))) {
+			end++
+		}
+	case r == '*' && end < len(c.src) && c.src[end] == '*':
+		end++ // ** is the same as ^
+	}
+	c.tok, c.pos = c.src[c.start:end], end
+}
+
+func (c *calculator) errorf(format string, a ...any) error {
+	return fmt.Errorf("%s at position %d", fmt.Sprintf(format, a...), c.start+1)
+}
+
+func (c *calculator) sum() (float64, error) {
+	v, err := c.product()
+	for err == nil && (c.tok == "+" || c.tok == "-") {
+		op := c.tok
+		c.next()
+		var w float64
+		if w, err = c.product(); op == "+" {
+			v += w
+		} else {
+			v -= w
+		}
+	}
+	return v, err
+}
+
+func (c *calculator) product() (float64, error) {
+	v, err := c.unary()
+	for err == nil && (c.tok == "*" || c.tok == "/" || c.tok == "%") {
+		op := c.tok
+		c.next()
+		var w float64
+		if w, err = c.unary(); err != nil {
+			break
+		}
+		switch {
+		case op == "*":
+			v *= w
+		case w == 0:
+			return 0, fmt.Errorf("division by zero")
+		case op == "/":
+			v /= w
+		default:
+			v = math.Mod(v, w)
+		}
+	}
+	return v, err
+}
+
+func (c *calculator) unary() (float64, error) {
+	switch c.tok {
+	case "-":
+		c.next()
+		v, err := c.unary()
+		return -v, err
+	case "+":
+		c.next()
+		return c.unary()
+	}
+	return c.power()
+}
+
+// power is right-associative: 2^3^2 is 2^9.
+func (c *calculator) power() (float64, error) {
+	base, err := c.primary()
+	if err != nil || (c.tok != "^" && c.tok != "**") {
+		return base, err
+	}
+	c.next()
+	exp, err := c.unary()
+	return math.Pow(base, exp), err
+}
+
+var calcFunctions = map[string]func(args []float64) (float64, error){
+	"sqrt":  calcUnary(math.Sqrt),
+	"abs":   calcUnary(math.Abs),
+	"exp":   calcUnary(math.Exp),
+	"ln":    calcUnary(math.Log),
+	"log":   calcUnary(math.Log10),
+	"log2":  calcUnary(math.Log2),
+	"sin":   calcUnary(math.Sin),
+	"cos":   calcUnary(math.Cos),
+	"tan":   calcUnary(math.Tan),
+	"floor": calcUnary(math.Floor),
+	"ceil":  calcUnary(math.Ceil),
+	"round": calcUnary(math.Round),
+	"pow": func(args []float64) (float64, error) {
+		if len(args) != 2 {
+			return 0, fmt.Errorf("pow takes 2 arguments")
+		}
+		return math.Pow(args[0], args[1]), nil
+	},
+	"min": func(args []float64) (float64, error) {
+		if len(args) == 0 {
+			return 0, fmt.Errorf("min needs an argument")
+		}
+		v := args[0]
+		for _, a := range args[1:] {
+			v = math.Min(v, a)
+		}
+		return v, nil
+	},
+	"max": func(args []float64) (float64, error) {
+		if len(args) == 0 {
+			return 0, fmt.Errorf("max needs an argument")
+		}
+		v := args[0]
+		for _, a := range args[1:] {
+			v = math.Max(v, a)
+		}
+		return v, nil
+	},
+}
+
+func calcUnary(f func(float64) float64) func([]float64) (float64, error) {
+	return func(args []float64) (float64, error) {
+		if len(args) != 1 {
+			return 0, fmt.Errorf("takes 1 argument")
+		}
+		return f(args[0]), nil
+	}
+}
+
+func (c *calculator) primary() (float64, error) {
+	tok := c.tok
+	switch {
+	case tok == "":
+		return 0, c.errorf("unexpected end of expression")
+	case tok == "(":
+		c.next()
+		v, err := c.sum()
+		if err != nil {
+			return 0, err
+		}
+		if c.tok != ")" {
+			return 0, c.errorf("missing )")
+		}
+		c.next()
+		return v, nil
+	case unicode.IsDigit(rune(tok[0])) || tok[0] == '.':
+		v, err := strconv.ParseFloat(strings.ReplaceAll(tok, "_", ""), 64)
+		if err != nil {
+			return 0, c.errorf("invalid number %q", tok)
+		}
+		c.next()
+		return v, nil
+	case unicode.IsLetter(rune(tok[0])):
+		name := strings.ToLower(tok)
+		c.next()
+		switch name {
+		case "pi":
+			return math.Pi, nil
+		case "e":
+			return math.E, nil
+		}
+		f, ok := calcFunctions[name]
+		if !ok {
+			return 0, c.errorf("unknown name %q", tok)
+		}
+		if c.tok != "(" {
+			return 0, c.errorf("%s needs (", name)
+		}
+		c.next()
+		var args []float64
+		for c.tok != ")" {
+			v, err := c.sum()
+			if err != nil {
+				return 0, err
+			}
+			args = append(args, v)
+			if c.tok == "," {
+				c.next()
+			} else if c.tok != ")" {
+				return 0, c.errorf("expected , or )")
+			}
+		}
+		c.next()
+		v, err := f(args)
+		if err != nil {
+			return 0, fmt.Errorf("%s: %w", name, err)
+		}
+		return v, nil
+	}
+	return 0, c.errorf("unexpected %q", tok)
+}
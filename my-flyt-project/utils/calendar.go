@@ -0,0 +1,465 @@
+package utils
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// CalendarEvent is one (occurrence of an) event.
+type CalendarEvent struct {
+	Summary string
+	Start   time.Time
+	End     time.Time
+	AllDay  bool
+}
+
+// CalendarSource reads events from CALDAV_URL (a calendar collection, with
+// CALDAV_USER and CALDAV_PASSWORD) or from CALENDAR_ICS, an iCalendar URL
+// such as Google Calendar's secret address, or a local .ics file.
+type CalendarSource struct {
+	CalDAVURL string
+	User      string
+	Password  string
+	ICS       string
+}
+
+// CalendarFromEnv returns the configured calendar, or false when there is none.
+func CalendarFromEnv() (CalendarSource, bool) {
+	source := CalendarSource{
+		CalDAVURL: os.Getenv("CALDAV_URL"),
+		User:      os.Getenv("CALDAV_USER"),
+		Password:  os.Getenv("CALDAV_PASSWORD"),
+		ICS:       os.Getenv("CALENDAR_ICS"),
+	}
+	return source, source.CalDAVURL != "" || source.ICS != ""
+}
+
+// CanCreate reports whether events can be written back (CalDAV only).
+func (s CalendarSource) CanCreate() bool {
+	return s.CalDAVURL != ""
+}
+
+// Events returns the events overlapping [from, to), recurring ones expanded, sorted by start.
+func (s CalendarSource) Events(from, to time.Time) ([]CalendarEvent, error) {
+	var events []CalendarEvent
+	var err error
+	if s.CalDAVURL != "" {
+		events, err = s.calDAVEvents(from, to)
+	} else {
+		var data []byte
+		data, err = s.readICS()
+		if err == nil {
+			events = ParseICS(string(data), from, to)
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(events, func(i, j int) bool { return events[i].Start.Before(events[j].Start) })
+	return events, nil
+}
+
+func (s CalendarSource) readICS() ([]byte, error) {
+	if !strings.HasPrefix(s.ICS, "http://") && !strings.HasPrefix(s.ICS, "https://") {
+		return os.ReadFile(s.ICS)
+	}
+	client := &http.Client{Timeout: 30 * time.Second}
+	resp, err := client.Get(s.ICS)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch calendar: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to fetch calendar: status %d", resp.StatusCode)
+	}
+	return io.ReadAll(resp.Body)
+}
+
+func (s CalendarSource) calDAV(method, url string, body []byte, headers map[string]string) (*http.Response, []byte, error) {
+	req, err := http.NewRequest(method, url, bytes.NewReader(body))
+	if err != nil {
+		return nil, nil, err
+	}
+	if s.User != "" {
+		req.SetBasicAuth(s.User, s.Password)
+	}
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+	client := &http.Client{Timeout: 30 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, nil, fmt.Errorf("CalDAV %s failed: %w", method, err)
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	return resp, data, err
+}
+
+// calDAVEvents asks the server for the events in range, expanded into occurrences.
+func (s CalendarSource) calDAVEvents(from, to time.Time) ([]CalendarEvent, error) {
+	start, end := from.UTC().Format("20060102T150405Z"), to.UTC().Format("20060102T150405Z")
+	query := fmt.Sprintf(`<?xml version="1.0" encoding="utf-8"?>
+<C:calendar-query xmlns:D="DAV:" xmlns:C="urn:ietf:params:xml:ns:caldav">
+  <D:prop><C:calendar-data><C:expand start="%s" end="%s"/></C:calendar-data></D:prop>
+  <C:filter><C:comp-filter name="VCALENDAR"><C:comp-filter name="VEVENT">
+    <C:time-range start="%s" end="%s"/>
+  </C:comp-filter></C:comp-filter></C:filter>
+</C:calendar-query>`, start, end, start, end)
+
+	resp, data, err := s.calDAV("REPORT", s.CalDAVURL, []byte(query), map[string]string{
+		"Depth":        "1",
+		"Content-Type": "application/xml; charset=utf-8",
+	})
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != 207 {
+		return nil, fmt.Errorf("CalDAV REPORT failed with status %d: %s", resp.StatusCode, truncateBody(data))
+	}
+
+	var multistatus struct {
+		Responses []struct {
+			CalendarData string `xml:"propstat>prop>calendar-data"`
+		} `xml:"response"`
+	}
+	if err := xml.Unmarshal(data, &multistatus); err != nil {
+		return nil, fmt.Errorf("failed to parse CalDAV response: %w", err)
+	}
+	var events []CalendarEvent
+	for _, r := range multistatus.Responses {
+		events = append(events, ParseICS(r.CalendarData, from, to)...)
+	}
+	return events, nil
+}
+
+// truncateBody shortens a response body for an error message.
+func truncateBody(body []byte) string {
+	if len(body) > 300 {
+		return string(body[:300]) + "..."
+	}
+	return string(body)
+}
+
+// CreateEvent writes a new event to the CalDAV calendar.
+func (s CalendarSource) CreateEvent(event CalendarEvent) error {
+	if err := CheckWritable("creating a calendar event"); err != nil {
+		return err
+	}
+	if !s.CanCreate() {
+		return fmt.Errorf("the calendar is read-only; set CALDAV_URL to create events")
+	}
+	id := make([]byte, 16)
+	rand.Read(id)
+	uid := hex.EncodeToString(id)
+	ics := strings.Join([]string{
+		"BEGIN:VCALENDAR",
+		"VERSION:2.0",
+		"PRODID:-//ai_wraper//EN",
+		"BEGIN:VEVENT",
+		"UID:" + uid,
+		"DTSTAMP:" + time.Now().UTC().Format("20060102T150405Z"),
+		"DTSTART:" + event.Start.UTC().Format("20060102T150405Z"),
+		"DTEND:" + event.End.UTC().Format("20060102T150405Z"),
+		"SUMMARY:" + strings.NewReplacer(`\`, `\\`, ",", `\,`, ";", `\;`, "\n", `\n`).Replace(event.Summary),
+		"END:VEVENT",
+		"END:VCALENDAR",
+		"",
+	}, "\r\n")
+
+	url := strings.TrimSuffix(s.CalDAVURL, "/") + "/" + uid + ".ics"
+	resp, data, err := s.calDAV("PUT", url, []byte(ics), map[string]string{
+		"Content-Type":  "text/calendar; charset=utf-8",
+		"If-None-Match": "*",
+	})
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusCreated && resp.StatusCode != http.StatusNoContent {
+		return fmt.Errorf("CalDAV PUT failed with status %d: %s", resp.StatusCode, truncateBody(data))
+	}
+	return nil
+}
+
+// icsProperty is one content line: NAME;PARAM=V:VALUE.
+type icsProperty struct {
+	Name   string
+	Params map[string]string
+	Value  string
+}
+
+func parseICSLine(line string) icsProperty {
+	colon := strings.Index(line, ":")
+	if colon < 0 {
+		return icsProperty{Name: strings.ToUpper(line)}
+	}
+	head, value := line[:colon], line[colon+1:]
+	parts := strings.Split(head, ";")
+	prop := icsProperty{Name: strings.ToUpper(parts[0]), Params: map[string]string{}, Value: value}
+	for _, p := range parts[1:] {
+		if k, v, ok := strings.Cut(p, "="); ok {
+			prop.Params[strings.ToUpper(k)] = strings.Trim(v, `"`)
+		}
+	}
+	return prop
+}
+
+// parseICSTime reads a DATE or DATE-TIME value, honoring TZID and UTC ("Z").
+func parseICSTime(prop icsProperty) (time.Time, bool, error) {
+	if prop.Params["VALUE"] == "DATE" || len(prop.Value) == 8 {
+		t, err := time.ParseInLocation("20060102", prop.Value, time.Local)
+		return t, true, err
+	}
+	if strings.HasSuffix(prop.Value, "Z") {
+		t, err := time.Parse("20060102T150405Z", prop.Value)
+		return t, false, err
+	}
+	loc := time.Local
+	if tzid := prop.Params["TZID"]; tzid != "" {
+		if l, err := time.LoadLocation(tzid); err == nil {
+			loc = l
+		}
+	}
+	t, err := time.ParseInLocation("20060102T150405", prop.Value, loc)
+	return t, false, err
+}
+
+// parseICSDuration reads the common forms of an iCalendar duration (P1D, PT1H30M, P1W).
+func parseICSDuration(s string) time.Duration {
+	var d time.Duration
+	num := ""
+	for _, r := range strings.TrimPrefix(strings.TrimPrefix(s, "+"), "P") {
+		switch {
+		case r >= '0' && r <= '9':
+			num += string(r)
+		case r == 'T':
+		default:
+			n, _ := strconv.Atoi(num)
+			num = ""
+			switch r {
+			case 'W':
+				d += time.Duration(n) * 7 * 24 * time.Hour
+			case 'D':
+				d += time.Duration(n) * 24 * time.Hour
+			case 'H':
+				d += time.Duration(n) * time.Hour
+			case 'M':
+				d += time.Duration(n) * time.Minute
+			case 'S':
+				d += time.Duration(n) * time.Second
+			}
+		}
+	}
+	return d
+}
+
+// maxOccurrences bounds recurrence expansion of a single event.
+const maxOccurrences = 5000
+
+// ParseICS returns the events of an iCalendar document that overlap [from, to).
+// Recurring events (RRULE with FREQ, INTERVAL, COUNT, UNTIL and weekly BYDAY,
+// minus EXDATEs) are expanded into occurrences.
+func ParseICS(data string, from, to time.Time) []CalendarEvent {
+	// Unfold continuation lines.
+	data = strings.NewReplacer("\r\n ", "", "\r\n\t", "", "\n ", "", "\n\t", "").Replace(data)
+
+	var events []CalendarEvent
+	var props []icsProperty
+	inEvent := false
+	for _, line := range strings.Split(data, "\n") {
+		line = strings.TrimRight(line, "\r")
+		switch {
+		case line == "BEGIN:VEVENT":
+			inEvent, props = true, nil
+		case line == "END:VEVENT":
+			inEvent = false
+			events = append(events, expandEvent(props, from, to)...)
+		case inEvent:
+			props = append(props, parseICSLine(line))
+		}
+	}
+	return events
+}
+
+func expandEvent(props []icsProperty, from, to time.Time) []CalendarEvent {
+	var event CalendarEvent
+	var duration time.Duration
+	var rrule string
+	var hasEnd, cancelled bool
+	exdates := map[time.Time]bool{}
+	for _, p := range props {
+		switch p.Name {
+		case "SUMMARY":
+			event.Summary = strings.NewReplacer(`\,`, ",", `\;`, ";", `\n`, "\n", `\\`, `\`).Replace(p.Value)
+		case "DTSTART":
+			event.Start, event.AllDay, _ = parseICSTime(p)
+		case "DTEND":
+			event.End, _, _ = parseICSTime(p)
+			hasEnd = true
+		case "DURATION":
+			duration = parseICSDuration(p.Value)
+		case "RRULE":
+			rrule = p.Value
+		case "EXDATE":
+			for _, v := range strings.Split(p.Value, ",") {
+				if t, _, err := parseICSTime(icsProperty{Params: p.Params, Value: v}); err == nil {
+					exdates[t.UTC()] = true
+				}
+			}
+		case "STATUS":
+			cancelled = strings.EqualFold(p.Value, "CANCELLED")
+		case "TRANSP":
+			// Events marked "show as free" do not block time.
+			cancelled = cancelled || strings.EqualFold(p.Value, "TRANSPARENT")
+		}
+	}
+	if event.Start.IsZero() || cancelled {
+		return nil
+	}
+	switch {
+	case hasEnd:
+		duration = event.End.Sub(event.Start)
+	case duration == 0 && event.AllDay:
+		duration = 24 * time.Hour
+	}
+
+	overlaps := func(start time.Time) bool {
+		return start.Before(to) && start.Add(duration).After(from)
+	}
+	occurrence := func(start time.Time) CalendarEvent {
+		e := event
+		e.Start, e.End = start, start.Add(duration)
+		return e
+	}
+
+	if rrule == "" {
+		if overlaps(event.Start) {
+			return []CalendarEvent{occurrence(event.Start)}
+		}
+		return nil
+	}
+
+	rule := map[string]string{}
+	for _, part := range strings.Split(rrule, ";") {
+		if k, v, ok := strings.Cut(part, "="); ok {
+			rule[strings.ToUpper(k)] = v
+		}
+	}
+	interval, _ := strconv.Atoi(rule["INTERVAL"])
+	if interval < 1 {
+		interval = 1
+	}
+	count, _ := strconv.Atoi(rule["COUNT"])
+	until := to
+	if u := rule["UNTIL"]; u != "" {
+		if t, _, err := parseICSTime(icsProperty{Value: u, Params: map[string]string{}}); err == nil && t.Before(until) {
+			until = t.Add(time.Second)
+		}
+	}
+	weekdays := map[string]time.Weekday{"SU": time.Sunday, "MO": time.Monday, "TU": time.Tuesday, "WE": time.Wednesday, "TH": time.Thursday, "FR": time.Friday, "SA": time.Saturday}
+	var byDay []time.Weekday
+	for _, d := range strings.Split(rule["BYDAY"], ",") {
+		if wd, ok := weekdays[d]; ok {
+			byDay = append(byDay, wd)
+		}
+	}
+
+	var events []CalendarEvent
+	emitted := 0
+	emit := func(start time.Time) bool {
+		if start.Before(event.Start) {
+			return true
+		}
+		if !start.Before(until) || (count > 0 && emitted >= count) {
+			return false
+		}
+		emitted++
+		if !exdates[start.UTC()] && overlaps(start) {
+			events = append(events, occurrence(start))
+		}
+		return true
+	}
+
+	for i := 0; i < maxOccurrences; i++ {
+		var periodStart time.Time
+		switch rule["FREQ"] {
+		case "DAILY":
+			periodStart = event.Start.AddDate(0, 0, i*interval)
+		case "WEEKLY":
+			periodStart = event.Start.AddDate(0, 0, 7*i*interval)
+		case "MONTHLY":
+			periodStart = event.Start.AddDate(0, i*interval, 0)
+		case "YEARLY":
+			periodStart = event.Start.AddDate(i*interval, 0, 0)
+		default:
+			return events
+		}
+		if rule["FREQ"] == "WEEKLY" && len(byDay) > 0 {
+			// Occurrences on each listed weekday of the (Monday-based) week of periodStart.
+			weekStart := periodStart.AddDate(0, 0, -((int(periodStart.Weekday()) + 6) % 7))
+			for offset := 0; offset < 7; offset++ {
+				day := weekStart.AddDate(0, 0, offset)
+				for _, wd := range byDay {
+					if day.Weekday() == wd && !emit(day) {
+						return events
+					}
+				}
+			}
+			continue
+		}
+		if !emit(periodStart) {
+			return events
+		}
+	}
+	return events
+}
+
+// TimeSlot is a free interval.
+type TimeSlot struct {
+	Start time.Time
+	End   time.Time
+}
+
+// FreeSlots returns the gaps of at least minLength between events within
+// working hours [dayStart, dayEnd) on weekdays, from from to to.
+func FreeSlots(events []CalendarEvent, from, to time.Time, dayStart, dayEnd int, minLength time.Duration) []TimeSlot {
+	var slots []TimeSlot
+	for day := time.Date(from.Year(), from.Month(), from.Day(), 0, 0, 0, 0, from.Location()); day.Before(to); day = day.AddDate(0, 0, 1) {
+		if day.Weekday() == time.Saturday || day.Weekday() == time.Sunday {
+			continue
+		}
+		cursor := day.Add(time.Duration(dayStart) * time.Hour)
+		end := day.Add(time.Duration(dayEnd) * time.Hour)
+		if cursor.Before(from) {
+			cursor = from
+		}
+		if end.After(to) {
+			end = to
+		}
+		for _, e := range events {
+			if !e.End.After(cursor) || !e.Start.Before(end) {
+				continue
+			}
+			if e.Start.Sub(cursor) >= minLength {
+				slots = append(slots, TimeSlot{cursor, e.Start})
+			}
+			if e.End.After(cursor) {
+				cursor = e.End
+			}
+		}
+		if end.Sub(cursor) >= minLength {
+			slots = append(slots, TimeSlot{cursor, end})
+		}
+	}
+	return slots
+}
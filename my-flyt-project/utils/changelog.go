@@ -0,0 +1,207 @@
+package utils
+
+import (
+	"bytes"
+	"fmt"
+	"os/exec"
+	"regexp"
+	"strings"
+)
+
+// GitCommit is one change in a release range: a commit on the first-parent
+// history, which for a merge-based repository is the pull request's merge.
+type GitCommit struct {
+	Hash     string `json:"hash"`
+	Short    string `json:"short"`
+	Author   string `json:"author"`
+	Subject  string `json:"subject"`
+	Body     string `json:"body,omitempty"`
+	Type     string `json:"type"` // see ChangeTypes
+	Scope    string `json:"scope,omitempty"`
+	Breaking bool   `json:"breaking,omitempty"`
+	PR       string `json:"pr,omitempty"` // pull request number, without "#"
+}
+
+// ChangeTypes are the release-note sections, in the order they are listed,
+// with their headings.
+var ChangeTypes = []struct{ Type, Heading string }{
+	{"breaking", "Breaking Changes"},
+	{"feat", "Features"},
+	{"fix", "Bug Fixes"},
+	{"perf", "Performance"},
+	{"refactor", "Refactoring"},
+	{"docs", "Documentation"},
+	{"chore", "Maintenance"},
+	{"other", "Other Changes"},
+}
+
+var (
+	conventionalRe = regexp.MustCompile(`^(\w+)(?:\(([^)]*)\))?(!)?:\s*(.+)$`)
+	mergePRRe      = regexp.MustCompile(`^Merge pull request #(\d+)`)
+	squashPRRe     = regexp.MustCompile(`\s*\(#(\d+)\)\s*$`)
+	commitHashRe   = regexp.MustCompile(`\b[0-9a-f]{7,40}\b`)
+	prRefRe        = regexp.MustCompile(`#(\d+)\b`)
+)
+
+// conventionalTypes maps conventional-commit types to a ChangeTypes section.
+var conventionalTypes = map[string]string{
+	"feat": "feat", "feature": "feat",
+	"fix": "fix", "bugfix": "fix",
+	"perf":     "perf",
+	"refactor": "refactor",
+	"docs":     "docs", "doc": "docs",
+	"chore": "chore", "build": "chore", "ci": "chore", "test": "chore", "tests": "chore", "style": "chore", "deps": "chore",
+	"revert": "other",
+}
+
+// LoadGitCommits lists the first-parent commits in from..to of the repository
+// in dir. An empty from means everything reachable from to.
+func LoadGitCommits(dir, from, to string) ([]GitCommit, error) {
+	for _, ref := range []string{from, to} {
+		if ref == "" {
+			continue
+		}
+		if err := exec.Command("git", "-C", dir, "rev-parse", "--verify", "--quiet", ref+"^{commit}").Run(); err != nil {
+			return nil, fmt.Errorf("unknown git ref %q", ref)
+		}
+	}
+	rangeArg := to
+	if from != "" {
+		rangeArg = from + ".." + to
+	}
+	out, err := exec.Command("git", "-C", dir, "log", "--first-parent", "--format=%H%x1f%h%x1f%an%x1f%s%x1f%b%x1e", rangeArg).Output()
+	if err != nil {
+		return nil, fmt.Errorf("git log failed: %w", err)
+	}
+
+	var commits []GitCommit
+	for _, record := range bytes.Split(out, []byte{0x1e}) {
+		fields := strings.SplitN(strings.TrimSpace(string(record)), "\x1f", 5)
+		if len(fields) < 5 {
+			continue
+		}
+		commits = append(commits, classifyCommit(GitCommit{
+			Hash:    fields[0],
+			Short:   fields[1],
+			Author:  fields[2],
+			Subject: fields[3],
+			Body:    strings.TrimSpace(fields[4]),
+		}))
+	}
+	return commits, nil
+}
+
+// LatestGitTag is the most recent tag reachable from ref, or "" when there is none.
+func LatestGitTag(dir, ref string) string {
+	out, err := exec.Command("git", "-C", dir, "describe", "--tags", "--abbrev=0", ref).Output()
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(out))
+}
+
+// classifyCommit fills in the type, scope, breaking flag and pull request of c.
+// A merged pull request takes its subject from the merge message's body.
+func classifyCommit(c GitCommit) GitCommit {
+	if m := mergePRRe.FindStringSubmatch(c.Subject); m != nil {
+		c.PR = m[1]
+		if title, rest, _ := strings.Cut(c.Body, "\n"); title != "" {
+			c.Subject, c.Body = strings.TrimSpace(title), strings.TrimSpace(rest)
+		}
+	} else if m := squashPRRe.FindStringSubmatch(c.Subject); m != nil {
+		c.PR = m[1]
+		c.Subject = strings.TrimSpace(strings.TrimSuffix(c.Subject, m[0]))
+	}
+
+	c.Type = "other"
+	if m := conventionalRe.FindStringSubmatch(c.Subject); m != nil {
+		if t, ok := conventionalTypes[strings.ToLower(m[1])]; ok {
+			c.Type, c.Scope, c.Breaking = t, m[2], m[3] == "!"
+		}
+	} else {
+		// Plain imperative subjects: guess from the verb.
+		verb, _, _ := strings.Cut(strings.ToLower(c.Subject), " ")
+		switch verb {
+		case "add", "adds", "added", "support", "introduce", "implement", "allow":
+			c.Type = "feat"
+		case "fix", "fixes", "fixed", "correct", "handle", "prevent":
+			c.Type = "fix"
+		case "refactor", "simplify", "rename", "move", "extract":
+			c.Type = "refactor"
+		case "document", "docs", "doc":
+			c.Type = "docs"
+		case "bump", "update", "upgrade", "chore":
+			c.Type = "chore"
+		}
+	}
+	if strings.Contains(c.Body, "BREAKING CHANGE") {
+		c.Breaking = true
+	}
+	return c
+}
+
+// GroupCommits groups commits by section (breaking changes get their own),
+// keeping their order within a section.
+func GroupCommits(commits []GitCommit) map[string][]GitCommit {
+	groups := map[string][]GitCommit{}
+	for _, c := range commits {
+		t := c.Type
+		if c.Breaking {
+			t = "breaking"
+		}
+		groups[t] = append(groups[t], c)
+	}
+	return groups
+}
+
+// VerifyReleaseNotes checks that every bullet of notes cites at least one
+// commit hash, and that every hash and pull request cited belongs to commits.
+// The error lists the offending bullets so the model can fix them.
+func VerifyReleaseNotes(notes string, commits []GitCommit) error {
+	prs := map[string]bool{}
+	for _, c := range commits {
+		if c.PR != "" {
+			prs[c.PR] = true
+		}
+	}
+	known := func(hash string) bool {
+		for _, c := range commits {
+			if strings.HasPrefix(c.Hash, hash) {
+				return true
+			}
+		}
+		return false
+	}
+
+	var problems []string
+	bullets := 0
+	for _, line := range strings.Split(notes, "\n") {
+		trimmed := strings.TrimSpace(line)
+		if !strings.HasPrefix(trimmed, "- ") && !strings.HasPrefix(trimmed, "* ") {
+			continue
+		}
+		bullets++
+		hashes := commitHashRe.FindAllString(trimmed, -1)
+		if len(hashes) == 0 {
+			problems = append(problems, fmt.Sprintf("%q cites no commit hash", trimmed))
+			continue
+		}
+		for _, h := range hashes {
+			if !known(h) {
+				problems = append(problems, fmt.Sprintf("%q cites %s, which is not a commit in the range", trimmed, h))
+			}
+		}
+		for _, m := range prRefRe.FindAllStringSubmatch(trimmed, -1) {
+			if !prs[m[1]] {
+				problems = append(problems, fmt.Sprintf("%q cites #%s, which is not a pull request in the range", trimmed, m[1]))
+			}
+		}
+	}
+	if bullets == 0 && len(commits) > 0 {
+		return fmt.Errorf("the notes list no changes as bullets")
+	}
+	if len(problems) > 0 {
+		return fmt.Errorf("%d problem(s):\n%s", len(problems), strings.Join(problems, "\n"))
+	}
+	return nil
+}
@@ -0,0 +1,39 @@
+package utils
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// CopyToClipboard places text on the system clipboard using the first
+// clipboard tool available for the current session.
+func CopyToClipboard(text string) error {
+	var tools [][]string
+	if os.Getenv("WAYLAND_DISPLAY") != "" {
+		tools = append(tools, []string{"wl-copy"})
+	}
+	tools = append(tools,
+		[]string{"xclip", "-selection", "clipboard"},
+		[]string{"xsel", "--clipboard", "--input"},
+		[]string{"pbcopy"},
+		[]string{"clip.exe"},
+	)
+
+	for _, tool := range tools {
+		if _, err := exec.LookPath(tool[0]); err != nil {
+			continue
+		}
+		cmd := exec.Command(tool[0], tool[1:]...)
+		cmd.Stdin = strings.NewReader(text)
+		var stderr bytes.Buffer
+		cmd.Stderr = &stderr
+		if err := cmd.Run(); err != nil {
+			return fmt.Errorf("%s failed: %v: %s", tool[0], err, strings.TrimSpace(stderr.String()))
+		}
+		return nil
+	}
+	return fmt.Errorf("no clipboard tool found (install wl-clipboard, xclip or xsel)")
+}
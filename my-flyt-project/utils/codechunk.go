@@ -0,0 +1,223 @@
+package utils
+
+import (
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// CodeChunk is a piece of a source file aligned to its declarations, so a
+// retrieved passage names the function or type it comes from.
+type CodeChunk struct {
+	Symbol    string // e.g. "Server.Start" or "class Parser"; empty for unrecognized code
+	StartLine int    // 1-based, inclusive
+	EndLine   int
+	Text      string
+}
+
+// codeLanguages maps file extensions to the language names ChunkCode knows.
+var codeLanguages = map[string]string{
+	".go": "go", ".py": "python", ".rb": "ruby", ".rs": "rust",
+	".js": "javascript", ".jsx": "javascript", ".mjs": "javascript", ".cjs": "javascript",
+	".ts": "typescript", ".tsx": "typescript",
+	".java": "java", ".kt": "kotlin", ".cs": "csharp", ".swift": "swift", ".php": "php",
+	".c": "c", ".h": "c", ".cc": "cpp", ".cpp": "cpp", ".hpp": "cpp",
+	".sh": "shell", ".sql": "sql", ".proto": "protobuf",
+	".md": "markdown", ".yaml": "yaml", ".yml": "yaml", ".toml": "toml",
+}
+
+// CodeLanguage returns the language of the file at path, or "" when it is
+// not a source or text file ChunkCode handles.
+func CodeLanguage(path string) string {
+	return codeLanguages[strings.ToLower(filepath.Ext(path))]
+}
+
+// definitionPatterns match the first line of a top-level definition. The
+// last non-empty submatch is the symbol name.
+var definitionPatterns = map[string]*regexp.Regexp{
+	"python":     regexp.MustCompile(`^(?:async\s+)?(def|class)\s+(\w+)`),
+	"ruby":       regexp.MustCompile(`^(def|class|module)\s+([\w:.]+)`),
+	"rust":       regexp.MustCompile(`^(?:pub(?:\([\w:]+\))?\s+)?(?:async\s+|unsafe\s+|const\s+)*(fn|struct|enum|trait|impl|mod|type)\b(?:<[^>]*>)?\s*([\w:]+(?:\s+for\s+\w+)?)`),
+	"javascript": regexp.MustCompile(`^(?:export\s+)?(?:default\s+)?(?:async\s+)?(function\*?|class|const|let|var)\s+(\w+)`),
+	"typescript": regexp.MustCompile(`^(?:export\s+)?(?:default\s+)?(?:declare\s+)?(?:abstract\s+)?(?:async\s+)?(function\*?|class|interface|type|enum|const|let|var)\s+(\w+)`),
+}
+
+// ChunkCode splits src, written in language (see CodeLanguage), into chunks
+// of at most about maxChars that follow its top-level declarations. Go is
+// parsed with go/parser; Python, Ruby, Rust, JavaScript and TypeScript are
+// split where top-level definitions start. Comments and decorators stay with
+// the definition below them. Other languages, and Go that does not parse,
+// are split between lines without symbols. Declarations longer than maxChars
+// are split between lines and keep their symbol.
+func ChunkCode(src, language string, maxChars int) []CodeChunk {
+	var chunks []CodeChunk
+	if language == "go" {
+		chunks = chunkGo(src)
+	}
+	if chunks == nil {
+		if pattern, ok := definitionPatterns[language]; ok {
+			chunks = chunkByDefinitions(src, pattern)
+		}
+	}
+	if chunks == nil {
+		chunks = []CodeChunk{{StartLine: 1, EndLine: len(sourceLines(src)), Text: src}}
+	}
+
+	var out []CodeChunk
+	for _, c := range chunks {
+		if strings.TrimSpace(c.Text) == "" {
+			continue
+		}
+		if len(c.Text) <= maxChars || maxChars <= 0 {
+			out = append(out, c)
+			continue
+		}
+		line := c.StartLine
+		pieces := ChunkLines(c.Text, maxChars)
+		for i, piece := range pieces {
+			n := strings.Count(strings.TrimSuffix(piece, "\n"), "\n") + 1
+			symbol := c.Symbol
+			if symbol != "" {
+				symbol = fmt.Sprintf("%s (part %d/%d)", c.Symbol, i+1, len(pieces))
+			}
+			out = append(out, CodeChunk{Symbol: symbol, StartLine: line, EndLine: line + n - 1, Text: piece})
+			line += n
+		}
+	}
+	return out
+}
+
+// chunkGo makes one chunk per top-level declaration, with its doc comment,
+// and one for the package clause and imports. It returns nil if src does not parse.
+func chunkGo(src string) []CodeChunk {
+	fset := token.NewFileSet()
+	file, err := parser.ParseFile(fset, "", src, parser.ParseComments)
+	if err != nil {
+		return nil
+	}
+	lines := sourceLines(src)
+	var starts []int
+	var symbols []string
+	for _, decl := range file.Decls {
+		pos := decl.Pos()
+		var symbol string
+		switch d := decl.(type) {
+		case *ast.FuncDecl:
+			if d.Doc != nil {
+				pos = d.Doc.Pos()
+			}
+			symbol = d.Name.Name
+			if d.Recv != nil && len(d.Recv.List) > 0 {
+				symbol = receiverType(d.Recv.List[0].Type) + "." + symbol
+			}
+		case *ast.GenDecl:
+			if d.Doc != nil {
+				pos = d.Doc.Pos()
+			}
+			if d.Tok == token.IMPORT {
+				continue // stays with the package clause
+			}
+			var names []string
+			for _, spec := range d.Specs {
+				switch s := spec.(type) {
+				case *ast.TypeSpec:
+					names = append(names, s.Name.Name)
+				case *ast.ValueSpec:
+					for _, n := range s.Names {
+						names = append(names, n.Name)
+					}
+				}
+			}
+			symbol = d.Tok.String() + " " + strings.Join(names, ", ")
+		}
+		starts = append(starts, fset.Position(pos).Line)
+		symbols = append(symbols, symbol)
+	}
+	return splitAtLines(lines, starts, symbols, "package "+file.Name.Name)
+}
+
+// receiverType names a method's receiver type without the pointer or type parameters.
+func receiverType(expr ast.Expr) string {
+	switch t := expr.(type) {
+	case *ast.StarExpr:
+		return receiverType(t.X)
+	case *ast.IndexExpr:
+		return receiverType(t.X)
+	case *ast.IndexListExpr:
+		return receiverType(t.X)
+	case *ast.Ident:
+		return t.Name
+	}
+	return "?"
+}
+
+// chunkByDefinitions starts a chunk at every unindented line pattern
+// matches, pulling the comment and decorator lines just above it along.
+func chunkByDefinitions(src string, pattern *regexp.Regexp) []CodeChunk {
+	lines := sourceLines(src)
+	var starts []int
+	var symbols []string
+	for i, line := range lines {
+		m := pattern.FindStringSubmatch(line)
+		if m == nil {
+			continue
+		}
+		start := i
+		for start > 0 && isLeadingLine(lines[start-1]) {
+			start--
+		}
+		if len(starts) > 0 && start+1 <= starts[len(starts)-1] {
+			start = i // the lines above already belong to the previous definition
+		}
+		starts = append(starts, start+1)
+		symbols = append(symbols, m[len(m)-2]+" "+m[len(m)-1])
+	}
+	if starts == nil {
+		return nil
+	}
+	return splitAtLines(lines, starts, symbols, "")
+}
+
+// sourceLines splits src into lines that keep their newline.
+func sourceLines(src string) []string {
+	lines := strings.SplitAfter(src, "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return lines
+}
+
+// isLeadingLine reports whether line is a comment or decorator that belongs
+// to the definition following it.
+func isLeadingLine(line string) bool {
+	t := strings.TrimSpace(line)
+	for _, prefix := range []string{"#", "//", "/*", "*", "@"} {
+		if strings.HasPrefix(t, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// splitAtLines cuts lines into chunks beginning at the 1-based starts. The
+// lines before the first start form a chunk named header.
+func splitAtLines(lines []string, starts []int, symbols []string, header string) []CodeChunk {
+	var chunks []CodeChunk
+	add := func(from, to int, symbol string) {
+		if from < to {
+			chunks = append(chunks, CodeChunk{Symbol: symbol, StartLine: from + 1, EndLine: to, Text: strings.Join(lines[from:to], "")})
+		}
+	}
+	prev := 0
+	prevSymbol := header
+	for i, start := range starts {
+		add(prev, start-1, prevSymbol)
+		prev, prevSymbol = start-1, symbols[i]
+	}
+	add(prev, len(lines), prevSymbol)
+	return chunks
+}
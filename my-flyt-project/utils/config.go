@@ -0,0 +1,58 @@
+package utils
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+// ConfigPath is the config file: AI_WRAPER_CONFIG, or ~/.ai_wraper/config.yaml.
+func ConfigPath() string {
+	if path := os.Getenv("AI_WRAPER_CONFIG"); path != "" {
+		return path
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(home, ".ai_wraper", "config.yaml")
+}
+
+// LoadConfigFile reads the flat "key: value" mapping in the YAML file at
+// path. A missing file is an empty config.
+func LoadConfigFile(path string) (map[string]string, error) {
+	if path == "" {
+		return nil, nil
+	}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read config file: %w", err)
+	}
+	docs, err := ParseYAML(string(data))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	if len(docs) == 0 || docs[0] == nil {
+		return nil, nil
+	}
+	mapping, ok := docs[0].(map[string]any)
+	if !ok {
+		return nil, fmt.Errorf("%s: expected a mapping of settings", path)
+	}
+	values := make(map[string]string, len(mapping))
+	for key, value := range mapping {
+		switch value.(type) {
+		case map[string]any, []any:
+			return nil, fmt.Errorf("%s: %s must be a single value", path, key)
+		case nil:
+			continue
+		}
+		values[key] = fmt.Sprint(value)
+	}
+	return values, nil
+}
@@ -0,0 +1,389 @@
+package utils
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"image"
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ModelPricing holds the USD price per one million tokens for a model.
+type ModelPricing struct {
+	InputPerMillion  float64
+	OutputPerMillion float64
+}
+
+// modelPricing lists the public list prices for the models we commonly use.
+// Longer names must come before their prefixes (flash-lite before flash).
+var modelPricing = []struct {
+	prefix  string
+	pricing ModelPricing
+}{
+	{"gemini-2.5-pro", ModelPricing{InputPerMillion: 1.25, OutputPerMillion: 10.00}},
+	{"gemini-2.5-flash-lite", ModelPricing{InputPerMillion: 0.10, OutputPerMillion: 0.40}},
+	{"gemini-2.5-flash", ModelPricing{InputPerMillion: 0.30, OutputPerMillion: 2.50}},
+	{"gemini-2.0-flash-lite", ModelPricing{InputPerMillion: 0.075, OutputPerMillion: 0.30}},
+	{"gemini-2.0-flash", ModelPricing{InputPerMillion: 0.10, OutputPerMillion: 0.40}},
+}
+
+// expectedOutputTokens is a rough guess at the answer length used when
+// estimating the cost of a request before it is sent.
+const expectedOutputTokens = 800
+
+// Gemini bills images by 768x768 tiles; small images count as a single tile.
+const (
+	tokensPerImageTile = 258
+	imageTileSize      = 768
+	smallImageSize     = 384
+	tokensPerPDFPage   = 258
+)
+
+// PricingForModel returns the pricing for the given model name.
+func PricingForModel(model string) (ModelPricing, bool) {
+	for _, p := range modelPricing {
+		if strings.HasPrefix(model, p.prefix) {
+			return p.pricing, true
+		}
+	}
+	return ModelPricing{}, false
+}
+
+// PromptEstimate describes the expected size and cost of a pending request.
+type PromptEstimate struct {
+	Model            string
+	TextTokens       int
+	AttachmentTokens int
+	OutputTokens     int
+	Cost             float64
+	PricingKnown     bool
+}
+
+// InputTokens returns the estimated number of tokens sent to the model.
+func (e PromptEstimate) InputTokens() int {
+	return e.TextTokens + e.AttachmentTokens
+}
+
+// String formats the estimate for display in the terminal.
+func (e PromptEstimate) String() string {
+	if !e.PricingKnown {
+		return fmt.Sprintf("~%d input tokens (no pricing for %s)", e.InputTokens(), e.Model)
+	}
+	return fmt.Sprintf("~%d input tokens, ≈$%.4f with %s", e.InputTokens(), e.Cost, e.Model)
+}
+
+// geminiContextWindow is the input limit of the Gemini 2.x models, in tokens.
+const geminiContextWindow = 1_048_576
+
+// ContextWindowForModel returns the model's context window in tokens, or 0 if unknown.
+func ContextWindowForModel(model string) int {
+	if strings.HasPrefix(model, "gemini-") {
+		return geminiContextWindow
+	}
+	return 0
+}
+
+// PromptComponent is one labelled part of an outgoing prompt and its estimated size.
+type PromptComponent struct {
+	Name   string
+	Tokens int
+}
+
+// SystemInstructionsTokens estimates the tokens the system instructions add to every request.
+func SystemInstructionsTokens() int {
+	return CountTokens(loadSystemInstructions())
+}
+
+// FormatPromptBreakdown renders prompt components as a table of token counts,
+// percentages and bars, followed by the total against the model's context window.
+func FormatPromptBreakdown(components []PromptComponent, model string) string {
+	total := 0
+	for _, c := range components {
+		total += c.Tokens
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "%-12s %9s %7s\n", "component", "tokens", "share")
+	for _, c := range components {
+		share := 0.0
+		if total > 0 {
+			share = float64(c.Tokens) / float64(total) * 100
+		}
+		fmt.Fprintf(&b, "%-12s %9d %6.1f%% %s\n", c.Name, c.Tokens, share, strings.Repeat("█", int(share/4+0.5)))
+	}
+	fmt.Fprintf(&b, "%-12s %9d", "total", total)
+	if window := ContextWindowForModel(model); window > 0 {
+		fmt.Fprintf(&b, "  (%.1f%% of the %d-token context window of %s)", float64(total)/float64(window)*100, window, model)
+	}
+	b.WriteString("\n")
+	return b.String()
+}
+
+// EstimatePromptCost estimates the tokens and dollar cost of sending text and
+// attachments to model. The system instructions are included in the count.
+func EstimatePromptCost(model, text string, attachments []string) (PromptEstimate, error) {
+	if sys := loadSystemInstructions(); sys != "" {
+		text = sys + "\n" + text
+	}
+
+	estimate := PromptEstimate{
+		Model:        model,
+		TextTokens:   CountTokens(text),
+		OutputTokens: expectedOutputTokens,
+	}
+
+	for _, path := range attachments {
+		tokens, err := EstimateAttachmentTokens(path)
+		if err != nil {
+			return estimate, err
+		}
+		estimate.AttachmentTokens += tokens
+	}
+
+	pricing, ok := PricingForModel(model)
+	estimate.PricingKnown = ok
+	if ok {
+		estimate.Cost = float64(estimate.InputTokens())/1e6*pricing.InputPerMillion +
+			float64(estimate.OutputTokens)/1e6*pricing.OutputPerMillion
+	}
+	return estimate, nil
+}
+
+// EstimateAttachmentTokens estimates the tokens an attached file will consume.
+func EstimateAttachmentTokens(path string) (int, error) {
+	if strings.ToLower(filepath.Ext(path)) == ".pdf" {
+		pages, err := CountPDFPages(path)
+		if err != nil {
+			return 0, err
+		}
+		return pages * tokensPerPDFPage, nil
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, fmt.Errorf("failed to open attachment %s: %w", path, err)
+	}
+	defer f.Close()
+
+	cfg, _, err := image.DecodeConfig(f)
+	if err != nil {
+		// Formats the standard library cannot decode (webp, heic) are
+		// counted as a single tile.
+		return tokensPerImageTile, nil
+	}
+	return imageTokens(cfg.Width, cfg.Height), nil
+}
+
+func imageTokens(width, height int) int {
+	// Large photos are downscaled by PrepareImage before upload.
+	if longest := max(width, height); MaxImageDimension > 0 && longest > MaxImageDimension {
+		width = width * MaxImageDimension / longest
+		height = height * MaxImageDimension / longest
+	}
+	if width <= smallImageSize && height <= smallImageSize {
+		return tokensPerImageTile
+	}
+	tilesX := (width + imageTileSize - 1) / imageTileSize
+	tilesY := (height + imageTileSize - 1) / imageTileSize
+	return tilesX * tilesY * tokensPerImageTile
+}
+
+// CountPDFPages returns the number of pages in a PDF by counting page objects.
+// It does not parse compressed object streams, so it is an approximation.
+func CountPDFPages(path string) (int, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return 0, fmt.Errorf("failed to read PDF %s: %w", path, err)
+	}
+	pages := bytes.Count(data, []byte("/Type /Page")) + bytes.Count(data, []byte("/Type/Page"))
+	pages -= bytes.Count(data, []byte("/Type /Pages")) + bytes.Count(data, []byte("/Type/Pages"))
+	if pages < 1 {
+		pages = 1
+	}
+	return pages, nil
+}
+
+// TokenUsage counts the tokens the API reported for a number of calls.
+type TokenUsage struct {
+	Calls        int
+	PromptTokens int
+	OutputTokens int
+}
+
+// UsageByModel is token usage per model name.
+type UsageByModel map[string]TokenUsage
+
+// Add adds other into u.
+func (u UsageByModel) Add(other UsageByModel) {
+	for model, t := range other {
+		sum := u[model]
+		sum.Calls += t.Calls
+		sum.PromptTokens += t.PromptTokens
+		sum.OutputTokens += t.OutputTokens
+		u[model] = sum
+	}
+}
+
+// Total sums the usage of every model.
+func (u UsageByModel) Total() TokenUsage {
+	var total TokenUsage
+	for _, t := range u {
+		total.Calls += t.Calls
+		total.PromptTokens += t.PromptTokens
+		total.OutputTokens += t.OutputTokens
+	}
+	return total
+}
+
+// Cost prices the usage; the bool is false when a model has no known pricing,
+// in which case its tokens are left out of the cost.
+func (u UsageByModel) Cost() (float64, bool) {
+	cost, known := 0.0, true
+	for model, t := range u {
+		pricing, ok := PricingForModel(model)
+		if !ok {
+			known = false
+			continue
+		}
+		cost += float64(t.PromptTokens)/1e6*pricing.InputPerMillion + float64(t.OutputTokens)/1e6*pricing.OutputPerMillion
+	}
+	return cost, known
+}
+
+// String summarizes the usage on one line, e.g. "1200 in / 350 out tokens, ≈$0.0013".
+func (u UsageByModel) String() string {
+	total := u.Total()
+	s := fmt.Sprintf("%d in / %d out tokens", total.PromptTokens, total.OutputTokens)
+	if cost, known := u.Cost(); known {
+		s += fmt.Sprintf(", ≈$%.4f", cost)
+	} else if cost > 0 {
+		s += fmt.Sprintf(", ≈$%.4f + unpriced models", cost)
+	}
+	return s
+}
+
+var (
+	usageMu      sync.Mutex
+	pendingUsage = UsageByModel{}
+)
+
+// UsageLogPath is the file every call's usage is appended to, for reports;
+// empty keeps no log.
+var UsageLogPath = DefaultUsageLogPath()
+
+// UsageRecord is one call in the usage log.
+type UsageRecord struct {
+	Time         time.Time `json:"time"`
+	Model        string    `json:"model"`
+	PromptTokens int       `json:"prompt_tokens"`
+	OutputTokens int       `json:"output_tokens"`
+	// User is the server user the call was made for, if any.
+	User string `json:"user,omitempty"`
+}
+
+// Cost prices the call; unpriced models cost nothing.
+func (r UsageRecord) Cost() float64 {
+	cost, _ := UsageByModel{r.Model: {Calls: 1, PromptTokens: r.PromptTokens, OutputTokens: r.OutputTokens}}.Cost()
+	return cost
+}
+
+// usageAccount is the user calls made with a context are charged to.
+type usageAccount struct {
+	user   string
+	charge func(UsageRecord)
+}
+
+type usageAccountKey struct{}
+
+// WithUsageAccount charges the calls made with the returned context to user:
+// their log records name the user, and charge is called with each of them.
+// Identical concurrent calls are only shared between requests of the same
+// user.
+func WithUsageAccount(ctx context.Context, user string, charge func(UsageRecord)) context.Context {
+	return context.WithValue(ctx, usageAccountKey{}, usageAccount{user, charge})
+}
+
+// DefaultUsageLogPath returns where usage is logged between runs.
+func DefaultUsageLogPath() string {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		dir = os.TempDir()
+	}
+	return filepath.Join(dir, "ai_wraper", "usage.jsonl")
+}
+
+// RecordUsage adds the token counts the API reported for one call with model,
+// appends them to the usage log and charges them to ctx's usage account.
+func RecordUsage(ctx context.Context, model string, promptTokens, outputTokens int) {
+	record := UsageRecord{Time: time.Now(), Model: model, PromptTokens: promptTokens, OutputTokens: outputTokens}
+	account, _ := ctx.Value(usageAccountKey{}).(usageAccount)
+	record.User = account.user
+	usageMu.Lock()
+	pendingUsage.Add(UsageByModel{model: {Calls: 1, PromptTokens: promptTokens, OutputTokens: outputTokens}})
+	if UsageLogPath != "" {
+		appendUsageRecord(record)
+	}
+	usageMu.Unlock()
+	if account.charge != nil {
+		account.charge(record)
+	}
+}
+
+// appendUsageRecord writes record as one JSON line. The log is only
+// bookkeeping, so a failure to write it does not fail the call.
+func appendUsageRecord(record UsageRecord) {
+	line, err := json.Marshal(record)
+	if err != nil {
+		return
+	}
+	if err := os.MkdirAll(filepath.Dir(UsageLogPath), 0700); err != nil {
+		return
+	}
+	f, err := os.OpenFile(UsageLogPath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+	f.Write(append(line, '\n'))
+}
+
+// LoadUsageLog returns the calls in the usage log at path made at or after
+// since. A missing log has no calls; malformed lines are skipped.
+func LoadUsageLog(path string, since time.Time) ([]UsageRecord, error) {
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var records []UsageRecord
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var record UsageRecord
+		if json.Unmarshal(scanner.Bytes(), &record) != nil || record.Time.Before(since) {
+			continue
+		}
+		records = append(records, record)
+	}
+	return records, scanner.Err()
+}
+
+// TakeUsage returns the usage recorded since the last TakeUsage and resets it.
+func TakeUsage() UsageByModel {
+	usageMu.Lock()
+	defer usageMu.Unlock()
+	taken := pendingUsage
+	pendingUsage = UsageByModel{}
+	return taken
+}
@@ -0,0 +1,168 @@
+package utils
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// CronSchedule is a parsed standard five-field cron expression
+// (minute hour day-of-month month day-of-week).
+type CronSchedule struct {
+	minute, hour, dom, month, dow uint64
+	domStar, dowStar              bool
+}
+
+var cronMacros = map[string]string{
+	"@yearly":   "0 0 1 1 *",
+	"@annually": "0 0 1 1 *",
+	"@monthly":  "0 0 1 * *",
+	"@weekly":   "0 0 * * 0",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@hourly":   "0 * * * *",
+}
+
+var cronMonthNames = map[string]int{
+	"jan": 1, "feb": 2, "mar": 3, "apr": 4, "may": 5, "jun": 6,
+	"jul": 7, "aug": 8, "sep": 9, "oct": 10, "nov": 11, "dec": 12,
+}
+
+var cronDayNames = map[string]int{
+	"sun": 0, "mon": 1, "tue": 2, "wed": 3, "thu": 4, "fri": 5, "sat": 6,
+}
+
+// ParseCron parses a five-field cron expression or one of the @ macros.
+func ParseCron(expr string) (*CronSchedule, error) {
+	expr = strings.TrimSpace(expr)
+	if macro, ok := cronMacros[strings.ToLower(expr)]; ok {
+		expr = macro
+	}
+	fields := strings.Fields(expr)
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("cron: expected 5 fields (minute hour day month weekday), got %d in %q", len(fields), expr)
+	}
+
+	s := &CronSchedule{}
+	var err error
+	if s.minute, err = parseCronField(fields[0], 0, 59, nil); err != nil {
+		return nil, fmt.Errorf("cron: minute: %w", err)
+	}
+	if s.hour, err = parseCronField(fields[1], 0, 23, nil); err != nil {
+		return nil, fmt.Errorf("cron: hour: %w", err)
+	}
+	if s.dom, err = parseCronField(fields[2], 1, 31, nil); err != nil {
+		return nil, fmt.Errorf("cron: day of month: %w", err)
+	}
+	if s.month, err = parseCronField(fields[3], 1, 12, cronMonthNames); err != nil {
+		return nil, fmt.Errorf("cron: month: %w", err)
+	}
+	if s.dow, err = parseCronField(fields[4], 0, 7, cronDayNames); err != nil {
+		return nil, fmt.Errorf("cron: day of week: %w", err)
+	}
+	// 7 is an alias for Sunday.
+	if s.dow&(1<<7) != 0 {
+		s.dow |= 1
+	}
+	s.domStar = fields[2] == "*" || fields[2] == "?"
+	s.dowStar = fields[4] == "*" || fields[4] == "?"
+	return s, nil
+}
+
+func parseCronField(field string, lo, hi int, names map[string]int) (uint64, error) {
+	var bits uint64
+	for _, part := range strings.Split(field, ",") {
+		rangePart, stepPart, hasStep := strings.Cut(part, "/")
+		step := 1
+		if hasStep {
+			n, err := strconv.Atoi(stepPart)
+			if err != nil || n <= 0 {
+				return 0, fmt.Errorf("invalid step %q", stepPart)
+			}
+			step = n
+		}
+
+		start, end := lo, hi
+		switch {
+		case rangePart == "*" || rangePart == "?":
+		case strings.Contains(rangePart, "-"):
+			a, b, _ := strings.Cut(rangePart, "-")
+			var err error
+			if start, err = cronValue(a, names); err != nil {
+				return 0, err
+			}
+			if end, err = cronValue(b, names); err != nil {
+				return 0, err
+			}
+		default:
+			v, err := cronValue(rangePart, names)
+			if err != nil {
+				return 0, err
+			}
+			start = v
+			if !hasStep {
+				end = v
+			}
+		}
+		if start < lo || end > hi || start > end {
+			return 0, fmt.Errorf("%q is out of range %d-%d", part, lo, hi)
+		}
+		for v := start; v <= end; v += step {
+			bits |= 1 << uint(v)
+		}
+	}
+	return bits, nil
+}
+
+func cronValue(s string, names map[string]int) (int, error) {
+	if v, ok := names[strings.ToLower(s)]; ok {
+		return v, nil
+	}
+	v, err := strconv.Atoi(s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid value %q", s)
+	}
+	return v, nil
+}
+
+// Matches reports whether the schedule fires at t (to the minute).
+func (s *CronSchedule) Matches(t time.Time) bool {
+	if s.minute&(1<<uint(t.Minute())) == 0 || s.hour&(1<<uint(t.Hour())) == 0 || s.month&(1<<uint(t.Month())) == 0 {
+		return false
+	}
+	return s.dayMatches(t)
+}
+
+// dayMatches applies cron's rule that a restricted day-of-month and
+// day-of-week are OR-ed together.
+func (s *CronSchedule) dayMatches(t time.Time) bool {
+	domOK := s.dom&(1<<uint(t.Day())) != 0
+	dowOK := s.dow&(1<<uint(t.Weekday())) != 0
+	if s.domStar || s.dowStar {
+		return domOK && dowOK
+	}
+	return domOK || dowOK
+}
+
+// Next returns the first time strictly after t at which the schedule fires,
+// or the zero time if it never fires within five years.
+func (s *CronSchedule) Next(t time.Time) time.Time {
+	t = t.Truncate(time.Minute).Add(time.Minute)
+	limit := t.AddDate(5, 0, 0)
+	for t.Before(limit) {
+		switch {
+		case s.month&(1<<uint(t.Month())) == 0:
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, t.Location())
+		case !s.dayMatches(t):
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, t.Location())
+		case s.hour&(1<<uint(t.Hour())) == 0:
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, t.Location())
+		case s.minute&(1<<uint(t.Minute())) == 0:
+			t = t.Add(time.Minute)
+		default:
+			return t
+		}
+	}
+	return time.Time{}
+}
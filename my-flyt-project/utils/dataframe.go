@@ -0,0 +1,503 @@
+package utils
+
+import (
+	"archive/zip"
+	"encoding/csv"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// DataFrame is a small in-memory table loaded from a CSV or XLSX file.
+// All cells are kept as strings and converted to numbers on demand.
+type DataFrame struct {
+	Columns []string
+	Rows    [][]string
+	// Omitted counts the result rows dropped by the row cap.
+	Omitted int
+}
+
+// Query results are what the model sees, so they are capped however the
+// query was phrased.
+const (
+	maxResultRows     = 200
+	maxDistinctValues = 100
+)
+
+// DataFilter restricts the rows a DataQuery operates on.
+type DataFilter struct {
+	Column string `json:"column"`
+	Op     string `json:"op"` // =, !=, >, >=, <, <=, contains
+	Value  string `json:"value"`
+}
+
+// DataQuery is an aggregation proposed by the model and executed locally.
+type DataQuery struct {
+	Operation string       `json:"operation"` // count, sum, avg, min, max, distinct, rows
+	Column    string       `json:"column,omitempty"`
+	GroupBy   string       `json:"group_by,omitempty"`
+	Filters   []DataFilter `json:"filters,omitempty"`
+	SortDesc  bool         `json:"sort_desc,omitempty"`
+	Limit     int          `json:"limit,omitempty"`
+}
+
+// String describes the query in a compact human-readable form.
+func (q DataQuery) String() string {
+	s := q.Operation
+	if q.Column != "" {
+		s += "(" + q.Column + ")"
+	}
+	if q.GroupBy != "" {
+		s += " by " + q.GroupBy
+	}
+	for _, f := range q.Filters {
+		s += fmt.Sprintf(" where %s %s %q", f.Column, f.Op, f.Value)
+	}
+	return s
+}
+
+// LoadDataFrame reads a .csv, .tsv or .xlsx file. The first row is the header.
+func LoadDataFrame(path string) (*DataFrame, error) {
+	var records [][]string
+	var err error
+
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".csv", ".tsv":
+		records, err = readCSV(path)
+	case ".xlsx":
+		records, err = readXLSX(path)
+	default:
+		return nil, fmt.Errorf("unsupported data file %s: use .csv, .tsv or .xlsx", path)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(records) == 0 {
+		return nil, fmt.Errorf("data file %s is empty", path)
+	}
+
+	df := &DataFrame{Columns: records[0]}
+	for _, r := range records[1:] {
+		row := make([]string, len(df.Columns))
+		copy(row, r)
+		df.Rows = append(df.Rows, row)
+	}
+	return df, nil
+}
+
+func readCSV(path string) ([][]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open %s: %w", path, err)
+	}
+	defer f.Close()
+
+	r := csv.NewReader(f)
+	r.FieldsPerRecord = -1
+	if strings.ToLower(filepath.Ext(path)) == ".tsv" {
+		r.Comma = '\t'
+	}
+	records, err := r.ReadAll()
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", path, err)
+	}
+	return records, nil
+}
+
+// readXLSX reads the first worksheet of an Office Open XML workbook.
+func readXLSX(path string) ([][]string, error) {
+	zr, err := zip.OpenReader(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open %s: %w", path, err)
+	}
+	defer zr.Close()
+
+	var sharedStrings []string
+	var sheets []*zip.File
+	for _, f := range zr.File {
+		switch {
+		case f.Name == "xl/sharedStrings.xml":
+			sharedStrings, err = readSharedStrings(f)
+			if err != nil {
+				return nil, err
+			}
+		case strings.HasPrefix(f.Name, "xl/worksheets/sheet") && strings.HasSuffix(f.Name, ".xml"):
+			sheets = append(sheets, f)
+		}
+	}
+	if len(sheets) == 0 {
+		return nil, fmt.Errorf("no worksheets found in %s", path)
+	}
+	sort.Slice(sheets, func(i, j int) bool { return sheets[i].Name < sheets[j].Name })
+
+	rc, err := sheets[0].Open()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", sheets[0].Name, err)
+	}
+	defer rc.Close()
+
+	var sheet struct {
+		Rows []struct {
+			Cells []struct {
+				Ref    string `xml:"r,attr"`
+				Type   string `xml:"t,attr"`
+				Value  string `xml:"v"`
+				Inline string `xml:"is>t"`
+			} `xml:"c"`
+		} `xml:"sheetData>row"`
+	}
+	if err := xml.NewDecoder(rc).Decode(&sheet); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", sheets[0].Name, err)
+	}
+
+	records := make([][]string, 0, len(sheet.Rows))
+	for _, row := range sheet.Rows {
+		var record []string
+		for i, c := range row.Cells {
+			col := i
+			if c.Ref != "" {
+				col = columnIndex(c.Ref)
+			}
+			for len(record) <= col {
+				record = append(record, "")
+			}
+			switch c.Type {
+			case "s":
+				idx, err := strconv.Atoi(c.Value)
+				if err == nil && idx < len(sharedStrings) {
+					record[col] = sharedStrings[idx]
+				}
+			case "inlineStr":
+				record[col] = c.Inline
+			default:
+				record[col] = c.Value
+			}
+		}
+		records = append(records, record)
+	}
+	return records, nil
+}
+
+func readSharedStrings(f *zip.File) ([]string, error) {
+	rc, err := f.Open()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read shared strings: %w", err)
+	}
+	defer rc.Close()
+
+	var sst struct {
+		Items []struct {
+			Text string `xml:"t"`
+			Runs []struct {
+				Text string `xml:"t"`
+			} `xml:"r"`
+		} `xml:"si"`
+	}
+	if err := xml.NewDecoder(rc).Decode(&sst); err != nil && err != io.EOF {
+		return nil, fmt.Errorf("failed to parse shared strings: %w", err)
+	}
+
+	out := make([]string, len(sst.Items))
+	for i, item := range sst.Items {
+		out[i] = item.Text
+		for _, r := range item.Runs {
+			out[i] += r.Text
+		}
+	}
+	return out, nil
+}
+
+// columnIndex converts a cell reference such as "AB12" to a zero-based column.
+func columnIndex(ref string) int {
+	col := 0
+	for _, r := range ref {
+		if r < 'A' || r > 'Z' {
+			break
+		}
+		col = col*26 + int(r-'A'+1)
+	}
+	return col - 1
+}
+
+// column returns the index of the named column, ignoring case.
+func (df *DataFrame) column(name string) (int, error) {
+	for i, c := range df.Columns {
+		if strings.EqualFold(strings.TrimSpace(c), strings.TrimSpace(name)) {
+			return i, nil
+		}
+	}
+	return -1, fmt.Errorf("unknown column %q (columns: %s)", name, strings.Join(df.Columns, ", "))
+}
+
+func parseNumber(s string) (float64, bool) {
+	s = strings.ReplaceAll(strings.TrimSpace(s), ",", "")
+	if s == "" {
+		return 0, false
+	}
+	f, err := strconv.ParseFloat(s, 64)
+	return f, err == nil
+}
+
+// Schema describes every column with its inferred type and fill rate.
+func (df *DataFrame) Schema() string {
+	var b strings.Builder
+	b.WriteString(fmt.Sprintf("%d rows, %d columns:\n", len(df.Rows), len(df.Columns)))
+	for i, name := range df.Columns {
+		filled, numeric := 0, 0
+		for _, row := range df.Rows {
+			if strings.TrimSpace(row[i]) == "" {
+				continue
+			}
+			filled++
+			if _, ok := parseNumber(row[i]); ok {
+				numeric++
+			}
+		}
+		kind := "text"
+		if filled > 0 && numeric == filled {
+			kind = "number"
+		}
+		b.WriteString(fmt.Sprintf("- %s (%s, %d non-empty)\n", name, kind, filled))
+	}
+	return b.String()
+}
+
+// Head returns a frame containing the first n rows.
+func (df *DataFrame) Head(n int) *DataFrame {
+	if n > len(df.Rows) {
+		n = len(df.Rows)
+	}
+	return &DataFrame{Columns: df.Columns, Rows: df.Rows[:n]}
+}
+
+// Markdown renders the frame as a markdown table.
+func (df *DataFrame) Markdown() string {
+	var b strings.Builder
+	b.WriteString("| " + strings.Join(df.Columns, " | ") + " |\n")
+	b.WriteString("|" + strings.Repeat(" --- |", len(df.Columns)) + "\n")
+	for _, row := range df.Rows {
+		b.WriteString("| " + strings.Join(row, " | ") + " |\n")
+	}
+	if df.Omitted > 0 {
+		b.WriteString(fmt.Sprintf("\n(truncated: %d more rows not shown)\n", df.Omitted))
+	}
+	return b.String()
+}
+
+func (f DataFilter) matches(cell string) (bool, error) {
+	switch f.Op {
+	case "=", "==", "":
+		return strings.EqualFold(strings.TrimSpace(cell), strings.TrimSpace(f.Value)), nil
+	case "!=":
+		return !strings.EqualFold(strings.TrimSpace(cell), strings.TrimSpace(f.Value)), nil
+	case "contains":
+		return strings.Contains(strings.ToLower(cell), strings.ToLower(f.Value)), nil
+	case ">", ">=", "<", "<=":
+		a, okA := parseNumber(cell)
+		b, okB := parseNumber(f.Value)
+		if !okA || !okB {
+			// Fall back to lexical comparison (works for ISO dates).
+			c := strings.Compare(strings.TrimSpace(cell), strings.TrimSpace(f.Value))
+			a, b = float64(c), 0
+		}
+		switch f.Op {
+		case ">":
+			return a > b, nil
+		case ">=":
+			return a >= b, nil
+		case "<":
+			return a < b, nil
+		default:
+			return a <= b, nil
+		}
+	default:
+		return false, fmt.Errorf("unsupported filter operator %q", f.Op)
+	}
+}
+
+// Run executes q against the frame and returns the result as a new frame.
+func (df *DataFrame) Run(q DataQuery) (*DataFrame, error) {
+	rows := df.Rows
+	for _, f := range q.Filters {
+		idx, err := df.column(f.Column)
+		if err != nil {
+			return nil, err
+		}
+		var kept [][]string
+		for _, row := range rows {
+			ok, err := f.matches(row[idx])
+			if err != nil {
+				return nil, err
+			}
+			if ok {
+				kept = append(kept, row)
+			}
+		}
+		rows = kept
+	}
+
+	op := strings.ToLower(q.Operation)
+	if op == "rows" {
+		out := &DataFrame{Columns: df.Columns, Rows: rows}
+		return out.limit(q.Limit), nil
+	}
+
+	valueCol := -1
+	if q.Column != "" {
+		idx, err := df.column(q.Column)
+		if err != nil {
+			return nil, err
+		}
+		valueCol = idx
+	} else if op != "count" {
+		return nil, fmt.Errorf("operation %q needs a column", q.Operation)
+	}
+
+	groupCol := -1
+	if q.GroupBy != "" {
+		idx, err := df.column(q.GroupBy)
+		if err != nil {
+			return nil, err
+		}
+		groupCol = idx
+	}
+
+	groups := map[string][][]string{}
+	var order []string
+	for _, row := range rows {
+		key := ""
+		if groupCol >= 0 {
+			key = row[groupCol]
+		}
+		if _, ok := groups[key]; !ok {
+			order = append(order, key)
+		}
+		groups[key] = append(groups[key], row)
+	}
+	if groupCol < 0 && len(order) == 0 {
+		order = []string{""}
+	}
+
+	type result struct {
+		key   string
+		value float64
+		text  string
+	}
+	results := make([]result, 0, len(order))
+	for _, key := range order {
+		v, text, err := aggregate(op, groups[key], valueCol)
+		if err != nil {
+			return nil, err
+		}
+		results = append(results, result{key: key, value: v, text: text})
+	}
+
+	if groupCol >= 0 && op != "distinct" {
+		sort.SliceStable(results, func(i, j int) bool {
+			if q.SortDesc {
+				return results[i].value > results[j].value
+			}
+			return results[i].value < results[j].value
+		})
+	}
+
+	label := op
+	if q.Column != "" {
+		label = fmt.Sprintf("%s(%s)", op, df.Columns[valueCol])
+	}
+	out := &DataFrame{Columns: []string{label}}
+	if groupCol >= 0 {
+		out.Columns = []string{df.Columns[groupCol], label}
+	}
+	for _, r := range results {
+		if groupCol >= 0 {
+			out.Rows = append(out.Rows, []string{r.key, r.text})
+		} else {
+			out.Rows = append(out.Rows, []string{r.text})
+		}
+	}
+	return out.limit(q.Limit), nil
+}
+
+func aggregate(op string, rows [][]string, col int) (float64, string, error) {
+	if op == "count" {
+		n := len(rows)
+		if col >= 0 {
+			n = 0
+			for _, row := range rows {
+				if strings.TrimSpace(row[col]) != "" {
+					n++
+				}
+			}
+		}
+		return float64(n), strconv.Itoa(n), nil
+	}
+
+	if op == "distinct" {
+		seen := map[string]bool{}
+		var values []string
+		for _, row := range rows {
+			if !seen[row[col]] {
+				seen[row[col]] = true
+				values = append(values, row[col])
+			}
+		}
+		text := values
+		if len(values) > maxDistinctValues {
+			text = append(values[:maxDistinctValues:maxDistinctValues], fmt.Sprintf("… (truncated: %d more)", len(values)-maxDistinctValues))
+		}
+		return float64(len(values)), strings.Join(text, ", "), nil
+	}
+
+	var sum, minV, maxV float64
+	n := 0
+	for _, row := range rows {
+		v, ok := parseNumber(row[col])
+		if !ok {
+			continue
+		}
+		if n == 0 || v < minV {
+			minV = v
+		}
+		if n == 0 || v > maxV {
+			maxV = v
+		}
+		sum += v
+		n++
+	}
+	if n == 0 {
+		return math.NaN(), "n/a", nil
+	}
+
+	var v float64
+	switch op {
+	case "sum":
+		v = sum
+	case "avg", "mean":
+		v = sum / float64(n)
+	case "min":
+		v = minV
+	case "max":
+		v = maxV
+	default:
+		return 0, "", fmt.Errorf("unsupported operation %q", op)
+	}
+	return v, strconv.FormatFloat(v, 'f', -1, 64), nil
+}
+
+// limit keeps the first n rows, and never more than maxResultRows.
+func (df *DataFrame) limit(n int) *DataFrame {
+	if n <= 0 || n > maxResultRows {
+		n = maxResultRows
+	}
+	if n < len(df.Rows) {
+		return &DataFrame{Columns: df.Columns, Rows: df.Rows[:n], Omitted: len(df.Rows) - n}
+	}
+	return df
+}
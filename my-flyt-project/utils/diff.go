@@ -0,0 +1,51 @@
+package utils
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// ApplyUnifiedDiff applies a unified diff for a single file to content with
+// patch(1) and returns the patched text. File names in the diff headers are ignored.
+func ApplyUnifiedDiff(content, diff string) (string, error) {
+	if _, err := exec.LookPath("patch"); err != nil {
+		return "", fmt.Errorf("patch is not installed")
+	}
+	if !strings.Contains(diff, "@@") {
+		return "", fmt.Errorf("not a unified diff: no @@ hunk headers")
+	}
+
+	dir, err := os.MkdirTemp("", "ai-diff-*")
+	if err != nil {
+		return "", fmt.Errorf("could not create temp dir: %w", err)
+	}
+	defer os.RemoveAll(dir)
+
+	original := filepath.Join(dir, "original")
+	patched := filepath.Join(dir, "patched")
+	if err := os.WriteFile(original, []byte(content), 0600); err != nil {
+		return "", fmt.Errorf("could not write temp file: %w", err)
+	}
+
+	var stderr bytes.Buffer
+	cmd := exec.Command("patch", "--batch", "--silent", "--no-backup-if-mismatch", "-r", "-", "-o", patched, original)
+	if !strings.HasSuffix(diff, "\n") {
+		diff += "\n"
+	}
+	cmd.Stdin = strings.NewReader(diff)
+	cmd.Stdout = &stderr
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return "", fmt.Errorf("diff does not apply: %s", strings.TrimSpace(stderr.String()))
+	}
+
+	out, err := os.ReadFile(patched)
+	if err != nil {
+		return "", fmt.Errorf("could not read patched file: %w", err)
+	}
+	return string(out), nil
+}
@@ -0,0 +1,67 @@
+package utils
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os/exec"
+	"strings"
+)
+
+// ParseDocument parses a JSON or YAML document and reports which format it was.
+// Multi-document YAML is returned as a slice of documents.
+func ParseDocument(text string) (any, string, error) {
+	trimmed := strings.TrimSpace(text)
+	if trimmed == "" {
+		return nil, "", fmt.Errorf("empty document")
+	}
+
+	if strings.HasPrefix(trimmed, "{") || strings.HasPrefix(trimmed, "[") {
+		var v any
+		if err := json.Unmarshal([]byte(trimmed), &v); err == nil {
+			return v, "json", nil
+		} else if strings.HasPrefix(trimmed, "{\"") {
+			return nil, "json", fmt.Errorf("invalid JSON: %w", err)
+		}
+	}
+
+	docs, err := ParseYAML(text)
+	if err != nil {
+		return nil, "yaml", err
+	}
+	if len(docs) == 1 {
+		return docs[0], "yaml", nil
+	}
+	return docs, "yaml", nil
+}
+
+// ValidateDocument checks that text parses as the given format. Formats
+// without a local parser are checked with their CLI when it is installed;
+// the returned bool reports whether any validation actually happened.
+func ValidateDocument(text, format string) (bool, error) {
+	switch strings.ToLower(format) {
+	case "json":
+		var v any
+		if err := json.Unmarshal([]byte(text), &v); err != nil {
+			return true, fmt.Errorf("invalid JSON: %w", err)
+		}
+		return true, nil
+	case "yaml", "yml":
+		_, err := ParseYAML(text)
+		return true, err
+	case "terraform", "tf", "hcl":
+		if _, err := exec.LookPath("terraform"); err != nil {
+			return false, nil
+		}
+		cmd := exec.Command("terraform", "fmt", "-")
+		cmd.Stdin = strings.NewReader(text)
+		var stderr bytes.Buffer
+		cmd.Stderr = &stderr
+		if err := cmd.Run(); err != nil {
+			return true, fmt.Errorf("terraform fmt rejected the output: %s", strings.TrimSpace(stderr.String()))
+		}
+		return true, nil
+	default:
+		return false, nil
+	}
+}
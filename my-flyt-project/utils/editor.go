@@ -0,0 +1,49 @@
+package utils
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// EditorCommand is the editor EditText opens: $VISUAL, then $EDITOR, then vi.
+// It may include arguments, such as "code --wait".
+func EditorCommand() []string {
+	for _, name := range []string{"VISUAL", "EDITOR"} {
+		if fields := strings.Fields(os.Getenv(name)); len(fields) > 0 {
+			return fields
+		}
+	}
+	return []string{"vi"}
+}
+
+// EditText opens initial in the user's editor in a temporary Markdown file
+// and returns the text once the editor exits, with surrounding blank space
+// trimmed.
+func EditText(initial string) (string, error) {
+	tmp, err := os.CreateTemp("", "ai-prompt-*.md")
+	if err != nil {
+		return "", fmt.Errorf("could not create temp file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.WriteString(initial); err != nil {
+		tmp.Close()
+		return "", fmt.Errorf("could not write to temp file: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return "", fmt.Errorf("could not close temp file: %w", err)
+	}
+
+	editor := EditorCommand()
+	cmd := exec.Command(editor[0], append(editor[1:], tmp.Name())...)
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+	if err := cmd.Run(); err != nil {
+		return "", fmt.Errorf("%s failed: %w", editor[0], err)
+	}
+	text, err := os.ReadFile(tmp.Name())
+	if err != nil {
+		return "", fmt.Errorf("could not read temp file: %w", err)
+	}
+	return strings.TrimSpace(string(text)), nil
+}
@@ -0,0 +1,141 @@
+package utils
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"time"
+)
+
+// EmbeddingModel is the Gemini model used to embed text.
+var EmbeddingModel = "gemini-embedding-001"
+
+// maxEmbedBatch is the most texts batchEmbedContents accepts in one request.
+const maxEmbedBatch = 100
+
+// EmbedTexts returns one embedding vector per text, in order.
+func EmbedTexts(ctx context.Context, texts []string) ([][]float64, error) {
+	apiKey, err := getGEMINIAPIKey()
+	if err != nil {
+		return nil, err
+	}
+
+	vectors := make([][]float64, 0, len(texts))
+	for start := 0; start < len(texts); start += maxEmbedBatch {
+		end := min(start+maxEmbedBatch, len(texts))
+		requests := make([]map[string]any, 0, end-start)
+		for _, text := range texts[start:end] {
+			requests = append(requests, map[string]any{
+				"model": "models/" + EmbeddingModel,
+				"content": map[string]any{
+					"parts": []map[string]string{{"text": text}},
+				},
+			})
+		}
+		batch, err := embedBatch(ctx, apiKey, requests)
+		if err != nil {
+			return nil, err
+		}
+		vectors = append(vectors, batch...)
+	}
+	return vectors, nil
+}
+
+func embedBatch(ctx context.Context, apiKey string, requests []map[string]any) ([][]float64, error) {
+	jsonData, err := json.Marshal(map[string]any{"requests": requests})
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal request: %w", err)
+	}
+
+	url := fmt.Sprintf("https://generativelanguage.googleapis.com/v1beta/models/%s:batchEmbedContents?key=%s", EmbeddingModel, apiKey)
+	req, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewBuffer(jsonData))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	client := &http.Client{Timeout: 30 * time.Second}
+	resp, err := DoWithRetry(client, req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to make request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read response: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("embedding request failed with status %d: %s", resp.StatusCode, string(body))
+	}
+
+	var result struct {
+		Embeddings []struct {
+			Values []float64 `json:"values"`
+		} `json:"embeddings"`
+	}
+	if err := json.Unmarshal(body, &result); err != nil {
+		return nil, fmt.Errorf("failed to parse response: %w", err)
+	}
+	if len(result.Embeddings) != len(requests) {
+		return nil, fmt.Errorf("expected %d embeddings, got %d", len(requests), len(result.Embeddings))
+	}
+
+	vectors := make([][]float64, len(result.Embeddings))
+	for i, e := range result.Embeddings {
+		vectors[i] = e.Values
+	}
+	return vectors, nil
+}
+
+// CosineSimilarity returns the cosine of the angle between a and b (0 if either is empty).
+func CosineSimilarity(a, b []float64) float64 {
+	var dot, normA, normB float64
+	for i := range min(len(a), len(b)) {
+		dot += a[i] * b[i]
+		normA += a[i] * a[i]
+		normB += b[i] * b[i]
+	}
+	if normA == 0 || normB == 0 {
+		return 0
+	}
+	return dot / (math.Sqrt(normA) * math.Sqrt(normB))
+}
+
+// TopicChangeThreshold is the similarity below which a question is treated as a new topic.
+var TopicChangeThreshold = 0.6
+
+// topicWindow is how many recent turns a new question is compared against.
+const topicWindow = 3
+
+// TopicSimilarity compares question with the most recent turns of history and
+// returns the highest similarity to any of them.
+func TopicSimilarity(ctx context.Context, history []Conversation, question string) (float64, error) {
+	recent := history[max(len(history)-topicWindow, 0):]
+	texts := []string{question}
+	for _, c := range recent {
+		texts = append(texts, c.User+"\n"+truncateRunes(fmt.Sprint(c.AI), 1000))
+	}
+	vectors, err := EmbedTexts(ctx, texts)
+	if err != nil {
+		return 0, err
+	}
+
+	best := 0.0
+	for _, v := range vectors[1:] {
+		best = max(best, CosineSimilarity(vectors[0], v))
+	}
+	return best, nil
+}
+
+func truncateRunes(s string, n int) string {
+	r := []rune(s)
+	if len(r) <= n {
+		return s
+	}
+	return string(r[:n])
+}
@@ -0,0 +1,57 @@
+package utils
+
+// StreamEvent is one piece of a streamed reply. Every provider's Stream emits
+// the same events, so the terminal, the HTTP server and any other consumer
+// handle them alike whichever backend produced them. The concrete types are
+// TextDelta, ToolCallStart, ToolResult, Citation, UsageUpdate and Done.
+type StreamEvent interface {
+	// EventName is the kind of event, used as the SSE event field.
+	EventName() string
+}
+
+// StreamHandler consumes stream events; returning an error stops the stream.
+type StreamHandler func(StreamEvent) error
+
+// TextDelta is the next piece of the reply's text.
+type TextDelta struct {
+	Text string `json:"text"`
+}
+
+// ToolCallStart announces that the model asked to run a tool.
+type ToolCallStart struct {
+	Call ToolCall `json:"call"`
+}
+
+// Citation is a source the reply is grounded in.
+type Citation struct {
+	Title string `json:"title"`
+	URI   string `json:"uri"`
+}
+
+// UsageUpdate reports the tokens used so far by the request.
+type UsageUpdate struct {
+	PromptTokens int `json:"prompt_tokens"`
+	OutputTokens int `json:"output_tokens"`
+}
+
+// Done ends the stream. FinishReason is the provider's, e.g. STOP or length.
+type Done struct {
+	FinishReason string `json:"finish_reason,omitempty"`
+}
+
+func (TextDelta) EventName() string     { return "text_delta" }
+func (ToolCallStart) EventName() string { return "tool_call_start" }
+func (ToolResult) EventName() string    { return "tool_result" }
+func (Citation) EventName() string      { return "citation" }
+func (UsageUpdate) EventName() string   { return "usage" }
+func (Done) EventName() string          { return "done" }
+
+// TextOnly adapts a handler of plain text chunks, ignoring the other events.
+func TextOnly(onChunk func(string) error) StreamHandler {
+	return func(ev StreamEvent) error {
+		if delta, ok := ev.(TextDelta); ok {
+			return onChunk(delta.Text)
+		}
+		return nil
+	}
+}
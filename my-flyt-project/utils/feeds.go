@@ -0,0 +1,213 @@
+package utils
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"html"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// FeedItem is one entry of an RSS or Atom feed.
+type FeedItem struct {
+	Feed      string
+	ID        string
+	Title     string
+	Link      string
+	Published string
+	Content   string
+}
+
+type rssDocument struct {
+	Channel struct {
+		Title string `xml:"title"`
+		Items []struct {
+			Title       string `xml:"title"`
+			Link        string `xml:"link"`
+			GUID        string `xml:"guid"`
+			PubDate     string `xml:"pubDate"`
+			Description string `xml:"description"`
+			Encoded     string `xml:"http://purl.org/rss/1.0/modules/content/ encoded"`
+		} `xml:"item"`
+	} `xml:"channel"`
+}
+
+type atomDocument struct {
+	Title   string `xml:"title"`
+	Entries []struct {
+		Title string `xml:"title"`
+		ID    string `xml:"id"`
+		Links []struct {
+			Href string `xml:"href,attr"`
+			Rel  string `xml:"rel,attr"`
+		} `xml:"link"`
+		Updated   string `xml:"updated"`
+		Published string `xml:"published"`
+		Summary   string `xml:"summary"`
+		Content   string `xml:"content"`
+	} `xml:"entry"`
+}
+
+// FetchFeed downloads and parses an RSS 2.0 or Atom feed.
+func FetchFeed(url string) (string, []FeedItem, error) {
+	client := &http.Client{Timeout: 30 * time.Second}
+	resp, err := client.Get(url)
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to fetch %s: %w", url, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", nil, fmt.Errorf("failed to fetch %s: status %d", url, resp.StatusCode)
+	}
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to read %s: %w", url, err)
+	}
+	return ParseFeed(data)
+}
+
+// ParseFeed parses an RSS 2.0 or Atom document and returns the feed title and its items.
+func ParseFeed(data []byte) (string, []FeedItem, error) {
+	var root struct{ XMLName xml.Name }
+	if err := xml.Unmarshal(data, &root); err != nil {
+		return "", nil, fmt.Errorf("not a feed: %w", err)
+	}
+
+	var items []FeedItem
+	switch root.XMLName.Local {
+	case "rss":
+		var doc rssDocument
+		if err := xml.Unmarshal(data, &doc); err != nil {
+			return "", nil, fmt.Errorf("invalid RSS: %w", err)
+		}
+		for _, i := range doc.Channel.Items {
+			id := i.GUID
+			if id == "" {
+				id = i.Link
+			}
+			content := i.Encoded
+			if content == "" {
+				content = i.Description
+			}
+			items = append(items, FeedItem{Feed: doc.Channel.Title, ID: id, Title: i.Title, Link: i.Link, Published: i.PubDate, Content: StripHTML(content)})
+		}
+		return doc.Channel.Title, items, nil
+	case "feed":
+		var doc atomDocument
+		if err := xml.Unmarshal(data, &doc); err != nil {
+			return "", nil, fmt.Errorf("invalid Atom: %w", err)
+		}
+		for _, e := range doc.Entries {
+			item := FeedItem{Feed: doc.Title, ID: e.ID, Title: e.Title, Published: e.Published, Content: StripHTML(e.Content)}
+			if item.Published == "" {
+				item.Published = e.Updated
+			}
+			if item.Content == "" {
+				item.Content = StripHTML(e.Summary)
+			}
+			for _, l := range e.Links {
+				if l.Rel == "" || l.Rel == "alternate" {
+					item.Link = l.Href
+					break
+				}
+			}
+			if item.ID == "" {
+				item.ID = item.Link
+			}
+			items = append(items, item)
+		}
+		return doc.Title, items, nil
+	default:
+		return "", nil, fmt.Errorf("not a feed: unexpected <%s> root element", root.XMLName.Local)
+	}
+}
+
+var (
+	htmlTagPattern    = regexp.MustCompile(`(?s)<script.*?</script>|<style.*?</style>|<[^>]+>`)
+	whitespacePattern = regexp.MustCompile(`\s+`)
+)
+
+// StripHTML turns an HTML fragment into plain text.
+func StripHTML(s string) string {
+	s = htmlTagPattern.ReplaceAllString(s, " ")
+	return strings.TrimSpace(whitespacePattern.ReplaceAllString(html.UnescapeString(s), " "))
+}
+
+// FeedState remembers which feed items were already included in a digest.
+type FeedState struct {
+	path string
+	Seen map[string]time.Time `json:"seen"`
+}
+
+// feedStateRetention is how long seen item IDs are kept; older feed items rarely reappear.
+const feedStateRetention = 90 * 24 * time.Hour
+
+// DefaultFeedStatePath returns where feed state is kept between runs.
+func DefaultFeedStatePath() string {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		dir = os.TempDir()
+	}
+	return filepath.Join(dir, "ai_wraper", "feeds_seen.json")
+}
+
+// LoadFeedState reads the state file at path; a missing file is an empty state.
+func LoadFeedState(path string) (*FeedState, error) {
+	state := &FeedState{path: path, Seen: map[string]time.Time{}}
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return state, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read feed state: %w", err)
+	}
+	if err := json.Unmarshal(data, state); err != nil {
+		return nil, fmt.Errorf("failed to parse feed state %s: %w", path, err)
+	}
+	if state.Seen == nil {
+		state.Seen = map[string]time.Time{}
+	}
+	return state, nil
+}
+
+// key identifies an item across feeds.
+func (s *FeedState) key(item FeedItem) string {
+	return item.Feed + "\x00" + item.ID
+}
+
+// IsSeen reports whether item was already included in a digest.
+func (s *FeedState) IsSeen(item FeedItem) bool {
+	_, ok := s.Seen[s.key(item)]
+	return ok
+}
+
+// MarkSeen records items as included in a digest.
+func (s *FeedState) MarkSeen(items []FeedItem) {
+	now := time.Now()
+	for _, item := range items {
+		s.Seen[s.key(item)] = now
+	}
+}
+
+// Save writes the state, dropping entries older than the retention period.
+func (s *FeedState) Save() error {
+	for key, seen := range s.Seen {
+		if time.Since(seen) > feedStateRetention {
+			delete(s.Seen, key)
+		}
+	}
+	if err := os.MkdirAll(filepath.Dir(s.path), 0755); err != nil {
+		return fmt.Errorf("failed to create %s: %w", filepath.Dir(s.path), err)
+	}
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(s.path, data, 0644)
+}
@@ -0,0 +1,92 @@
+package utils
+
+import (
+	"encoding/json"
+	"fmt"
+	"html"
+	"strings"
+)
+
+// Flashcard is one question-and-answer card for spaced repetition.
+type Flashcard struct {
+	Front string   `json:"front"`
+	Back  string   `json:"back"`
+	Tags  []string `json:"tags,omitempty"`
+}
+
+// FlashcardsSchema is the structured-output schema for a deck of cards.
+var FlashcardsSchema = map[string]any{
+	"type":     "object",
+	"required": []string{"cards"},
+	"properties": map[string]any{
+		"cards": map[string]any{
+			"type":     "array",
+			"minItems": 1,
+			"items": map[string]any{
+				"type":     "object",
+				"required": []string{"front", "back"},
+				"properties": map[string]any{
+					"front": map[string]any{"type": "string", "description": "A question that tests one fact or idea", "minLength": 1},
+					"back":  map[string]any{"type": "string", "description": "The answer, short enough to recall", "minLength": 1},
+					"tags":  map[string]any{"type": "array", "items": map[string]any{"type": "string"}},
+				},
+			},
+		},
+	},
+}
+
+// ParseFlashcards checks reply against FlashcardsSchema and returns its
+// cards, without repeated questions.
+func ParseFlashcards(reply string) ([]Flashcard, error) {
+	var value any
+	if err := json.Unmarshal([]byte(ExtractJSON(reply)), &value); err != nil {
+		return nil, fmt.Errorf("flashcards are not valid JSON: %w", err)
+	}
+	if err := ValidateJSONSchema(value, FlashcardsSchema); err != nil {
+		return nil, fmt.Errorf("flashcards %w", err)
+	}
+	var deck struct {
+		Cards []Flashcard `json:"cards"`
+	}
+	if err := json.Unmarshal([]byte(ExtractJSON(reply)), &deck); err != nil {
+		return nil, err
+	}
+	seen := map[string]bool{}
+	cards := deck.Cards[:0]
+	for _, c := range deck.Cards {
+		key := strings.ToLower(strings.TrimSpace(c.Front))
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+		cards = append(cards, c)
+	}
+	return cards, nil
+}
+
+// AnkiText formats cards as an Anki import file (File > Import): tab-separated
+// front, back and tags, with header lines selecting the deck and note type.
+func AnkiText(cards []Flashcard, deck string) string {
+	var b strings.Builder
+	b.WriteString("#separator:tab\n#html:true\n#notetype:Basic\n")
+	if deck != "" {
+		fmt.Fprintf(&b, "#deck:%s\n", ankiField(deck))
+	}
+	b.WriteString("#tags column:3\n")
+	for _, c := range cards {
+		tags := make([]string, len(c.Tags))
+		for i, t := range c.Tags {
+			// Anki tags cannot contain spaces.
+			tags[i] = strings.Join(strings.Fields(t), "_")
+		}
+		fmt.Fprintf(&b, "%s\t%s\t%s\n", ankiField(c.Front), ankiField(c.Back), strings.Join(tags, " "))
+	}
+	return b.String()
+}
+
+// ankiField escapes text for an HTML field on one line.
+func ankiField(s string) string {
+	s = html.EscapeString(strings.TrimSpace(s))
+	s = strings.ReplaceAll(s, "\t", " ")
+	return strings.ReplaceAll(s, "\n", "<br>")
+}
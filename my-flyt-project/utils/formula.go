@@ -0,0 +1,240 @@
+package utils
+
+import (
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// formulaFunctions are the spreadsheet functions ParseFormula accepts: those
+// of Excel and Google Sheets, then (last line) Google Sheets only.
+var formulaFunctions = map[string]bool{}
+
+func init() {
+	for _, name := range strings.Fields(`
+		ABS AND AVERAGE AVERAGEIF AVERAGEIFS CEILING CHOOSE COLUMN COLUMNS CONCAT CONCATENATE COUNT COUNTA COUNTBLANK
+		COUNTIF COUNTIFS DATE DATEDIF DATEVALUE DAY DAYS EDATE EOMONTH EXACT FALSE FILTER FIND FLOOR HLOOKUP HOUR IF
+		IFERROR IFNA IFS INDEX INDIRECT INT ISBLANK ISERROR ISNA ISNUMBER ISTEXT LARGE LEFT LEN LOWER MATCH MAX MAXIFS
+		MEDIAN MID MIN MINIFS MINUTE MOD MONTH NETWORKDAYS NOT NOW OFFSET OR PROPER RAND RANDBETWEEN RANK REPLACE REPT
+		RIGHT ROUND ROUNDDOWN ROUNDUP ROW ROWS SEARCH SECOND SEQUENCE SMALL SORT STDEV SUBSTITUTE SUBTOTAL SUM SUMIF
+		SUMIFS SUMPRODUCT SWITCH TEXT TEXTJOIN TIME TODAY TRIM TRUE UNIQUE UPPER VALUE VLOOKUP WEEKDAY WEEKNUM WORKDAY
+		XLOOKUP XMATCH YEAR LET LAMBDA LOG LOG10 LN EXP POWER SQRT PI SIGN PRODUCT QUOTIENT FIXED DOLLAR CHAR CODE
+		ISOWEEKNUM YEARFRAC HYPERLINK TRANSPOSE VSTACK HSTACK TAKE DROP CHOOSECOLS CHOOSEROWS SORTBY
+		ARRAYFORMULA QUERY REGEXMATCH REGEXEXTRACT REGEXREPLACE SPLIT JOIN IMPORTRANGE GOOGLEFINANCE SPARKLINE`) {
+		formulaFunctions[name] = true
+	}
+}
+
+var formulaTokenRe = regexp.MustCompile(`^(?:` +
+	`(?P<str>"(?:[^"]|"")*")` +
+	`|(?P<ref>(?:(?:'(?:[^']|'')+'|[A-Za-z_][\w.]*)!)?\$?[A-Za-z]{1,3}\$?\d+(?::\$?[A-Za-z]{1,3}\$?\d+)?|(?:(?:'(?:[^']|'')+'|[A-Za-z_][\w.]*)!)?\$?[A-Za-z]{1,3}:\$?[A-Za-z]{1,3}|\$?\d+:\$?\d+)` +
+	`|(?P<num>\d+(?:\.\d+)?(?:[eE][+-]?\d+)?%?|\.\d+)` +
+	`|(?P<name>[A-Za-z_][\w.]*)` +
+	`|(?P<op><>|<=|>=|[-+*/^&=<>%])` +
+	`|(?P<punct>[(),;{}])` +
+	`)`)
+
+type formulaToken struct {
+	kind, text string
+	pos        int
+}
+
+// tokenizeFormula splits s from byte start; positions are 1-based in s.
+func tokenizeFormula(s string, start int) ([]formulaToken, error) {
+	var tokens []formulaToken
+	names := formulaTokenRe.SubexpNames()
+	for pos := start; pos < len(s); {
+		if s[pos] == ' ' || s[pos] == '\t' || s[pos] == '\n' {
+			pos++
+			continue
+		}
+		m := formulaTokenRe.FindStringSubmatchIndex(s[pos:])
+		if m == nil {
+			if s[pos] == '"' || s[pos] == '\'' {
+				return nil, fmt.Errorf("unterminated quote at position %d", pos+1)
+			}
+			return nil, fmt.Errorf("unexpected %q at position %d", s[pos:pos+1], pos+1)
+		}
+		for i := 1; i < len(names); i++ {
+			if m[2*i] >= 0 {
+				kind := names[i]
+				if kind == "ref" && strings.HasPrefix(s[pos+m[1]:], "(") {
+					kind = "name" // a function such as LOG10 or ATAN2
+				}
+				tokens = append(tokens, formulaToken{kind: kind, text: s[pos+m[2*i] : pos+m[2*i+1]], pos: pos + 1})
+				break
+			}
+		}
+		pos += m[1]
+	}
+	return tokens, nil
+}
+
+// formulaParser is a recursive-descent parser over formula tokens.
+type formulaParser struct {
+	tokens []formulaToken
+	i      int
+}
+
+func (p *formulaParser) peek() formulaToken {
+	if p.i < len(p.tokens) {
+		return p.tokens[p.i]
+	}
+	return formulaToken{kind: "end", text: "end of formula"}
+}
+
+func (p *formulaParser) errorf(t formulaToken, format string, a ...any) error {
+	if t.kind == "end" {
+		return fmt.Errorf(format, a...)
+	}
+	return fmt.Errorf("%s at position %d", fmt.Sprintf(format, a...), t.pos)
+}
+
+// expression parses an operand with binary operators, lowest precedence first.
+func (p *formulaParser) expression() error {
+	return p.binary(0)
+}
+
+var formulaPrecedence = [][]string{
+	{"=", "<>", "<", ">", "<=", ">="},
+	{"&"},
+	{"+", "-"},
+	{"*", "/"},
+	{"^"},
+}
+
+func (p *formulaParser) binary(level int) error {
+	if level == len(formulaPrecedence) {
+		return p.unary()
+	}
+	if err := p.binary(level + 1); err != nil {
+		return err
+	}
+	for t := p.peek(); t.kind == "op" && slices.Contains(formulaPrecedence[level], t.text); t = p.peek() {
+		p.i++
+		if err := p.binary(level + 1); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (p *formulaParser) unary() error {
+	if t := p.peek(); t.kind == "op" && (t.text == "-" || t.text == "+") {
+		p.i++
+		return p.unary()
+	}
+	if err := p.primary(); err != nil {
+		return err
+	}
+	for t := p.peek(); t.kind == "op" && t.text == "%"; t = p.peek() {
+		p.i++
+	}
+	return nil
+}
+
+func (p *formulaParser) primary() error {
+	t := p.peek()
+	p.i++
+	switch t.kind {
+	case "str", "num", "ref":
+		return nil
+	case "name":
+		name := strings.ToUpper(t.text)
+		if p.peek().text != "(" {
+			// TRUE, FALSE, a named range, or a LET or LAMBDA variable.
+			return nil
+		}
+		if !formulaFunctions[strings.TrimPrefix(name, "_XLFN.")] {
+			return p.errorf(t, "unknown function %s", t.text)
+		}
+		return p.call(name)
+	case "punct":
+		switch t.text {
+		case "(":
+			if err := p.expression(); err != nil {
+				return err
+			}
+			return p.expect(")")
+		case "{":
+			return p.array()
+		}
+	}
+	p.i--
+	return p.errorf(t, "unexpected %s", t.text)
+}
+
+func (p *formulaParser) call(name string) error {
+	p.i++ // "("
+	if p.peek().text == ")" {
+		p.i++
+		return nil
+	}
+	for {
+		if t := p.peek(); t.text == "," || t.text == ";" {
+			// An omitted argument, e.g. VLOOKUP(a, b, 2, ).
+		} else if err := p.expression(); err != nil {
+			return err
+		}
+		switch t := p.peek(); t.text {
+		case ",", ";":
+			p.i++
+		case ")":
+			p.i++
+			return nil
+		default:
+			return p.errorf(t, "expected , or ) in %s(...), found %s", name, t.text)
+		}
+	}
+}
+
+func (p *formulaParser) array() error {
+	for {
+		if err := p.expression(); err != nil {
+			return err
+		}
+		switch t := p.peek(); t.text {
+		case ",", ";":
+			p.i++
+		case "}":
+			p.i++
+			return nil
+		default:
+			return p.errorf(t, "expected , ; or } in array, found %s", t.text)
+		}
+	}
+}
+
+func (p *formulaParser) expect(text string) error {
+	if t := p.peek(); t.text != text {
+		return p.errorf(t, "expected %s, found %s", text, t.text)
+	}
+	p.i++
+	return nil
+}
+
+// ParseFormula checks the syntax of a spreadsheet formula (Excel or Google
+// Sheets, starting with "="): balanced parentheses and quotes, well-formed
+// references and operators, and known function names.
+func ParseFormula(formula string) error {
+	formula = strings.TrimSpace(formula)
+	if !strings.HasPrefix(formula, "=") {
+		return fmt.Errorf("a formula starts with =")
+	}
+	tokens, err := tokenizeFormula(formula, 1)
+	if err != nil {
+		return err
+	}
+	if len(tokens) == 0 {
+		return fmt.Errorf("the formula is empty")
+	}
+	p := &formulaParser{tokens: tokens}
+	if err := p.expression(); err != nil {
+		return err
+	}
+	if t := p.peek(); t.kind != "end" {
+		return p.errorf(t, "unexpected %s", t.text)
+	}
+	return nil
+}
@@ -0,0 +1,530 @@
+package utils
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+type GroundingChunk struct {
+	Web struct {
+		URI   string `json:"uri"`
+		Title string `json:"title"`
+	} `json:"web"`
+}
+
+type GroundingMetadata struct {
+	GroundingChunks []GroundingChunk `json:"groundingChunks"`
+}
+
+func getGEMINIAPIKey() (string, error) {
+	apiKey := os.Getenv("GEMINI_API_KEY")
+	if apiKey == "" {
+		return "", fmt.Errorf("GEMINI_API_KEY environment variable not set")
+	}
+	return apiKey, nil
+}
+
+// geminiUsage is the usageMetadata of a response.
+type geminiUsage struct {
+	PromptTokenCount     int `json:"promptTokenCount"`
+	CandidatesTokenCount int `json:"candidatesTokenCount"`
+}
+
+func (u geminiUsage) record(ctx context.Context, model string) {
+	if u.PromptTokenCount > 0 || u.CandidatesTokenCount > 0 {
+		RecordUsage(ctx, model, u.PromptTokenCount, u.CandidatesTokenCount)
+	}
+}
+
+// GeminiProvider talks to the Gemini generateContent API with GEMINI_API_KEY.
+type GeminiProvider struct{}
+
+// Generate sends prompt, with the system instructions and, when useSearch is
+// set, Google Search grounding; sources are appended to the answer.
+func (GeminiProvider) Generate(ctx context.Context, prompt string, config *LLMConfig, useSearch bool) (string, error) {
+	apiKey, err := getGEMINIAPIKey()
+	if err != nil {
+		return "", err
+	}
+	if len(config.Tools) > 0 {
+		return geminiToolLoop(ctx, prompt, config)
+	}
+
+	release, err := DefaultScheduler.Acquire(ctx, config.Priority)
+	if err != nil {
+		return "", err
+	}
+	defer release()
+
+	requestBody := geminiRequestBody(prompt, config, useSearch)
+
+	jsonData, err := json.Marshal(requestBody)
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal request: %w", err)
+	}
+
+	url := fmt.Sprintf("https://generativelanguage.googleapis.com/v1beta/models/%s:generateContent?key=%s", config.Model, apiKey)
+	req, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewBuffer(jsonData))
+	if err != nil {
+		return "", fmt.Errorf("failed to create request: %w", err)
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+
+	client := &http.Client{
+		Timeout: 60 * time.Second, // Increased timeout for potential search
+	}
+
+	resp, err := DoWithRetry(client, req)
+	if err != nil {
+		return "", fmt.Errorf("failed to make request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return "", fmt.Errorf("failed to read response: %w", err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("API request failed with status %d: %s", resp.StatusCode, string(body))
+	}
+
+	var result struct {
+		Candidates []struct {
+			Content struct {
+				Parts []struct {
+					Text string `json:"text"`
+				} `json:"parts"`
+			} `json:"content"`
+			GroundingMetadata GroundingMetadata `json:"groundingMetadata"`
+		} `json:"candidates"`
+		UsageMetadata geminiUsage `json:"usageMetadata"`
+	}
+
+	if err := json.Unmarshal(body, &result); err != nil {
+		return "", fmt.Errorf("failed to parse response: %w", err)
+	}
+	result.UsageMetadata.record(ctx, config.Model)
+
+	if len(result.Candidates) == 0 || len(result.Candidates[0].Content.Parts) == 0 {
+		return "", fmt.Errorf("no response from API")
+	}
+
+	answerText := result.Candidates[0].Content.Parts[0].Text
+
+	if len(result.Candidates[0].GroundingMetadata.GroundingChunks) > 0 {
+		var builder strings.Builder
+		builder.WriteString(answerText) // Start with the answer
+		builder.WriteString("\n\n---\n**Sources:**\n")
+
+		// Loop through the sources and format them
+		for i, chunk := range result.Candidates[0].GroundingMetadata.GroundingChunks {
+			builder.WriteString(fmt.Sprintf("%d. %s (%s)\n", i+1, chunk.Web.Title, chunk.Web.URI))
+		}
+		return builder.String(), nil
+	}
+	return answerText, nil
+}
+
+// geminiToolLoop lets the model call config.Tools: each reply with
+// functionCall parts is replayed with a functionResponse for every call, until
+// the model answers in text. Search grounding cannot be combined with
+// function declarations, so it is left out.
+func geminiToolLoop(ctx context.Context, prompt string, config *LLMConfig) (string, error) {
+	dialect := ToolDialects["gemini"]
+	requestBody := geminiRequestBody(prompt, config, false)
+	requestBody["tools"] = dialect.Declarations(config.Tools)
+	contents := requestBody["contents"].([]map[string]any)
+
+	for round := 0; ; round++ {
+		if round == MaxToolRounds {
+			// Out of rounds: the model must answer from the results it has.
+			requestBody["toolConfig"] = map[string]any{"functionCallingConfig": map[string]any{"mode": "NONE"}}
+		}
+		requestBody["contents"] = contents
+
+		var result struct {
+			Candidates []struct {
+				Content json.RawMessage `json:"content"`
+			} `json:"candidates"`
+			UsageMetadata geminiUsage `json:"usageMetadata"`
+		}
+		release, err := DefaultScheduler.Acquire(ctx, config.Priority)
+		if err != nil {
+			return "", err
+		}
+		err = geminiJSON(ctx, "POST", "models/"+config.Model+":generateContent", requestBody, &result)
+		release()
+		if err != nil {
+			return "", err
+		}
+		result.UsageMetadata.record(ctx, config.Model)
+		if len(result.Candidates) == 0 || len(result.Candidates[0].Content) == 0 {
+			return "", fmt.Errorf("no response from API")
+		}
+		content := result.Candidates[0].Content
+
+		calls, err := dialect.ParseCalls(content)
+		if err != nil {
+			return "", err
+		}
+		if len(calls) == 0 {
+			return geminiContentText(content)
+		}
+		// The model's turn is replayed as it came, with any thought
+		// signatures, rather than rebuilt from the calls.
+		var turn map[string]any
+		if err := json.Unmarshal(content, &turn); err != nil {
+			return "", fmt.Errorf("failed to parse response: %w", err)
+		}
+		contents = append(contents, turn)
+		contents = append(contents, dialect.ResultTurns(runToolCalls(ctx, config, calls))...)
+	}
+}
+
+// geminiContentText joins the text parts of a content, leaving out thoughts.
+func geminiContentText(content json.RawMessage) (string, error) {
+	var parsed struct {
+		Parts []struct {
+			Text    string `json:"text"`
+			Thought bool   `json:"thought"`
+		} `json:"parts"`
+	}
+	if err := json.Unmarshal(content, &parsed); err != nil {
+		return "", fmt.Errorf("failed to parse response: %w", err)
+	}
+	var b strings.Builder
+	for _, p := range parsed.Parts {
+		if !p.Thought {
+			b.WriteString(p.Text)
+		}
+	}
+	if b.Len() == 0 {
+		return "", fmt.Errorf("no response from API")
+	}
+	return b.String(), nil
+}
+
+// geminiRequestBody builds a generateContent request for prompt, preceded by
+// the turns in config.History.
+func geminiRequestBody(prompt string, config *LLMConfig, useSearch bool) map[string]any {
+	contents := append(geminiHistoryContents(config.History), map[string]any{
+		"role":  "user",
+		"parts": []map[string]any{{"text": prompt}},
+	})
+	requestBody := geminiContentsBody(contents, config)
+
+	// Grounding with Google Search (not available together with JSON mode).
+	if useSearch && config.Format != FormatJSON {
+		requestBody["tools"] = []map[string]any{
+			{
+				"google_search": map[string]any{}, // This enables the tool
+			},
+		}
+	}
+	return requestBody
+}
+
+// geminiContentsBody wraps contents with the generation settings and system
+// instruction of config.
+func geminiContentsBody(contents []map[string]any, config *LLMConfig) map[string]any {
+	generationConfig := map[string]any{
+		"temperature": config.Temperature,
+	}
+	if config.MaxTokens > 0 {
+		generationConfig["maxOutputTokens"] = config.MaxTokens
+	}
+	// JSON replies are enforced natively; Markdown has no MIME type, so it is
+	// asked for in the system instruction.
+	if config.Format == FormatJSON {
+		generationConfig["responseMimeType"] = "application/json"
+		if config.Schema != nil {
+			generationConfig["responseSchema"] = geminiSchema(config.Schema)
+		}
+	}
+
+	requestBody := map[string]any{
+		"contents":         contents,
+		"generationConfig": generationConfig,
+	}
+
+	if sys := systemPrompt(config); sys != "" {
+		// Gemini supports a top-level systemInstruction field containing parts.
+		requestBody["systemInstruction"] = map[string]any{
+			"parts": []map[string]string{
+				{"text": sys},
+			},
+		}
+	}
+
+	return requestBody
+}
+
+// geminiHistoryContents turns earlier turns into alternating user and model
+// contents. Images of earlier questions are sent again so the model can
+// still refer to them; one that can no longer be read is replaced by a note.
+func geminiHistoryContents(history []Conversation) []map[string]any {
+	contents := make([]map[string]any, 0, 2*len(history))
+	for _, c := range history {
+		parts := []map[string]any{{"text": c.User}}
+		for _, path := range c.Images {
+			imageData, mimeType, err := PrepareImage(path)
+			if err != nil {
+				parts = append(parts, map[string]any{"text": fmt.Sprintf("[image %s is no longer available]", path)})
+				continue
+			}
+			parts = append(parts, map[string]any{"inline_data": map[string]any{
+				"mime_type": mimeType,
+				"data":      base64.StdEncoding.EncodeToString(imageData),
+			}})
+		}
+		contents = append(contents,
+			map[string]any{"role": "user", "parts": parts},
+			map[string]any{"role": "model", "parts": []map[string]any{{"text": c.answerText()}}})
+	}
+	return contents
+}
+
+// GenerateWithImages sends the images alongside prompt: inline while they
+// fit in MaxInlineRequestBytes, and through the File API beyond that, such as
+// for long videos. Inline files are base64-encoded as the request is written
+// and uploads are streamed from disk, so large files are never held in memory.
+func (GeminiProvider) GenerateWithImages(ctx context.Context, prompt string, imagePaths []string, config *LLMConfig) (string, error) {
+	apiKey, err := getGEMINIAPIKey()
+	if err != nil {
+		return "", err
+	}
+
+	parts := []map[string]any{{"text": prompt}}
+	nonce := newPlaceholderNonce()
+	var inline []attachmentSource
+	var inlineBytes int64
+	for _, path := range imagePaths {
+		// Images are transcoded, stripped and downscaled; other files are read as they are sent.
+		a, err := openAttachment(path)
+		if err != nil {
+			return "", err
+		}
+		if encoded := base64Len(a.Size); inlineBytes+encoded <= MaxInlineRequestBytes {
+			parts = append(parts, map[string]any{"inline_data": map[string]any{
+				"mime_type": a.MIMEType,
+				"data":      inlinePlaceholder(nonce, len(inline)),
+			}})
+			inline = append(inline, a)
+			inlineBytes += encoded
+			continue
+		}
+		file, err := uploadToFileAPI(ctx, apiKey, a)
+		if err != nil {
+			return "", err
+		}
+		parts = append(parts, map[string]any{"file_data": map[string]any{
+			"mime_type": file.MIMEType,
+			"file_uri":  file.URI,
+		}})
+	}
+
+	return callLLMWithAttachments(ctx, apiKey, parts, nonce, inline, config)
+}
+
+// Stream calls streamGenerateContent and emits the events of the reply as
+// the server-sent events arrive.
+func (GeminiProvider) Stream(ctx context.Context, prompt string, config *LLMConfig, emit StreamHandler) error {
+	apiKey, err := getGEMINIAPIKey()
+	if err != nil {
+		return err
+	}
+
+	release, err := DefaultScheduler.Acquire(ctx, config.Priority)
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	jsonData, err := json.Marshal(geminiRequestBody(prompt, config, false))
+	if err != nil {
+		return fmt.Errorf("failed to marshal request: %w", err)
+	}
+	url := fmt.Sprintf("https://generativelanguage.googleapis.com/v1beta/models/%s:streamGenerateContent?alt=sse&key=%s", config.Model, apiKey)
+	req, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewBuffer(jsonData))
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	// No overall timeout: long answers keep streaming for a while.
+	client := &http.Client{Transport: &http.Transport{ResponseHeaderTimeout: 60 * time.Second}}
+
+	resp, err := DoWithRetry(client, req)
+	if err != nil {
+		return fmt.Errorf("failed to make request: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("API request failed with status %d: %s", resp.StatusCode, string(body))
+	}
+	return readGeminiStream(resp.Body, emit)
+}
+
+// readGeminiStream parses the "data: {...}" events of a streamed response.
+// Grounding sources and usage come with the last chunks; Done is emitted
+// once the body ends.
+func readGeminiStream(r io.Reader, emit StreamHandler) error {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), 10*1024*1024)
+	var finishReason string
+	calls := 0
+	for scanner.Scan() {
+		data, ok := strings.CutPrefix(scanner.Text(), "data: ")
+		if !ok {
+			continue
+		}
+		var event struct {
+			Candidates []struct {
+				Content struct {
+					Parts []struct {
+						Text         string `json:"text"`
+						FunctionCall *struct {
+							ID   string         `json:"id"`
+							Name string         `json:"name"`
+							Args map[string]any `json:"args"`
+						} `json:"functionCall"`
+					} `json:"parts"`
+				} `json:"content"`
+				FinishReason      string            `json:"finishReason"`
+				GroundingMetadata GroundingMetadata `json:"groundingMetadata"`
+			} `json:"candidates"`
+			UsageMetadata *geminiUsage `json:"usageMetadata"`
+		}
+		if err := json.Unmarshal([]byte(data), &event); err != nil {
+			return fmt.Errorf("failed to parse stream event: %w", err)
+		}
+		var events []StreamEvent
+		for _, c := range event.Candidates {
+			for _, part := range c.Content.Parts {
+				switch {
+				case part.FunctionCall != nil:
+					calls++
+					id := part.FunctionCall.ID
+					if id == "" {
+						id = fmt.Sprintf("call_%d", calls)
+					}
+					events = append(events, ToolCallStart{Call: ToolCall{ID: id, Name: part.FunctionCall.Name, Args: part.FunctionCall.Args}})
+				case part.Text != "":
+					events = append(events, TextDelta{Text: part.Text})
+				}
+			}
+			for _, chunk := range c.GroundingMetadata.GroundingChunks {
+				events = append(events, Citation{Title: chunk.Web.Title, URI: chunk.Web.URI})
+			}
+			if c.FinishReason != "" {
+				finishReason = c.FinishReason
+			}
+		}
+		if u := event.UsageMetadata; u != nil {
+			events = append(events, UsageUpdate{PromptTokens: u.PromptTokenCount, OutputTokens: u.CandidatesTokenCount})
+		}
+		for _, ev := range events {
+			if err := emit(ev); err != nil {
+				return err
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("stream interrupted: %w", err)
+	}
+	return emit(Done{FinishReason: finishReason})
+}
+
+// callLLMWithParts sends one user turn made of the given content parts
+// (text, inline data or file references) and returns the text of the reply.
+func callLLMWithParts(ctx context.Context, apiKey string, parts []map[string]any, config *LLMConfig) (string, error) {
+	return callLLMWithAttachments(ctx, apiKey, parts, "", nil, config)
+}
+
+// callLLMWithAttachments is callLLMWithParts for parts whose inline data
+// holds inlinePlaceholder(nonce, i) in place of inline[i], which is encoded
+// into the request as it is written.
+func callLLMWithAttachments(ctx context.Context, apiKey string, parts []map[string]any, nonce string, inline []attachmentSource, config *LLMConfig) (string, error) {
+	release, err := DefaultScheduler.Acquire(ctx, config.Priority)
+	if err != nil {
+		return "", err
+	}
+	defer release()
+
+	requestBody := geminiContentsBody(append(geminiHistoryContents(config.History), map[string]any{
+		"role":  "user",
+		"parts": parts,
+	}), config)
+
+	url := fmt.Sprintf("https://generativelanguage.googleapis.com/v1beta/models/%s:generateContent?key=%s", config.Model, apiKey)
+	var req *http.Request
+	if len(inline) == 0 {
+		jsonData, err := json.Marshal(requestBody)
+		if err != nil {
+			return "", fmt.Errorf("failed to marshal request: %w", err)
+		}
+		req, err = http.NewRequestWithContext(ctx, "POST", url, bytes.NewBuffer(jsonData))
+		if err != nil {
+			return "", fmt.Errorf("failed to create request: %w", err)
+		}
+	} else {
+		body, err := writeInlineBody(requestBody, nonce, inline)
+		if err != nil {
+			return "", err
+		}
+		defer os.Remove(body.Name())
+		defer body.Close()
+		if req, err = newFileRequest(ctx, "POST", url, body); err != nil {
+			return "", err
+		}
+	}
+	req.Header.Set("Content-Type", "application/json")
+	client := &http.Client{Timeout: 180 * time.Second} // Increased timeout for image uploads and video understanding
+
+	resp, err := DoWithRetry(client, req)
+	if err != nil {
+		return "", fmt.Errorf("failed to make request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return "", fmt.Errorf("failed to read response: %w", err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("API request failed with status %d: %s", resp.StatusCode, string(body))
+	}
+
+	var result struct {
+		Candidates []struct {
+			Content struct {
+				Parts []struct {
+					Text string `json:"text"`
+				} `json:"parts"`
+			} `json:"content"`
+		} `json:"candidates"`
+		UsageMetadata geminiUsage `json:"usageMetadata"`
+	}
+	if err := json.Unmarshal(body, &result); err != nil {
+		return "", fmt.Errorf("failed to parse response: %w", err)
+	}
+	result.UsageMetadata.record(ctx, config.Model)
+
+	if len(result.Candidates) == 0 || len(result.Candidates[0].Content.Parts) == 0 {
+		return "", fmt.Errorf("no response from API")
+	}
+
+	return result.Candidates[0].Content.Parts[0].Text, nil
+}
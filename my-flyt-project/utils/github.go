@@ -0,0 +1,186 @@
+package utils
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// GitHubAPI is the base URL of the GitHub REST API (override for GitHub Enterprise).
+var GitHubAPI = "https://api.github.com"
+
+// GitHubRequest calls the GitHub REST API, authenticating with GITHUB_TOKEN when it
+// is set, and decodes the JSON response into out (which may be nil).
+func GitHubRequest(method, path string, body any, out any) error {
+	if method != "GET" {
+		if err := CheckWritable("GitHub " + method + " " + path); err != nil {
+			return err
+		}
+	}
+	var reader io.Reader
+	if body != nil {
+		jsonData, err := json.Marshal(body)
+		if err != nil {
+			return fmt.Errorf("failed to marshal request: %w", err)
+		}
+		reader = bytes.NewBuffer(jsonData)
+	}
+
+	req, err := http.NewRequest(method, GitHubAPI+path, reader)
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Accept", "application/vnd.github+json")
+	req.Header.Set("X-GitHub-Api-Version", "2022-11-28")
+	if token := os.Getenv("GITHUB_TOKEN"); token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	client := &http.Client{Timeout: 30 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to make request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("failed to read response: %w", err)
+	}
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("GitHub %s %s failed with status %d: %s", method, path, resp.StatusCode, string(respBody))
+	}
+	if out == nil {
+		return nil
+	}
+	if err := json.Unmarshal(respBody, out); err != nil {
+		return fmt.Errorf("failed to parse response: %w", err)
+	}
+	return nil
+}
+
+// PullRequest is the part of a GitHub pull request the review flow needs.
+type PullRequest struct {
+	Title string `json:"title"`
+	Body  string `json:"body"`
+	Head  struct {
+		SHA string `json:"sha"`
+	} `json:"head"`
+}
+
+// PRFile is one changed file of a pull request with its unified diff.
+type PRFile struct {
+	Filename string `json:"filename"`
+	Status   string `json:"status"`
+	Patch    string `json:"patch"`
+}
+
+// FetchPullRequest returns the pull request and all its changed files.
+func FetchPullRequest(repo string, number int) (*PullRequest, []PRFile, error) {
+	var pr PullRequest
+	if err := GitHubRequest("GET", fmt.Sprintf("/repos/%s/pulls/%d", repo, number), nil, &pr); err != nil {
+		return nil, nil, err
+	}
+
+	var files []PRFile
+	for page := 1; ; page++ {
+		var batch []PRFile
+		if err := GitHubRequest("GET", fmt.Sprintf("/repos/%s/pulls/%d/files?per_page=100&page=%d", repo, number, page), nil, &batch); err != nil {
+			return nil, nil, err
+		}
+		files = append(files, batch...)
+		if len(batch) < 100 {
+			break
+		}
+	}
+	return &pr, files, nil
+}
+
+// ReviewComment is a line comment on the new version of a file.
+type ReviewComment struct {
+	Path     string `json:"path"`
+	Line     int    `json:"line"`
+	Severity string `json:"severity"`
+	Body     string `json:"body"`
+}
+
+// PostPRReview posts a non-blocking ("COMMENT") review with line comments.
+func PostPRReview(repo string, number int, commitID, body string, comments []ReviewComment) error {
+	apiComments := make([]map[string]any, len(comments))
+	for i, c := range comments {
+		apiComments[i] = map[string]any{
+			"path": c.Path,
+			"line": c.Line,
+			"side": "RIGHT",
+			"body": fmt.Sprintf("**%s**: %s", c.Severity, c.Body),
+		}
+	}
+	return GitHubRequest("POST", fmt.Sprintf("/repos/%s/pulls/%d/reviews", repo, number), map[string]any{
+		"commit_id": commitID,
+		"body":      body,
+		"event":     "COMMENT",
+		"comments":  apiComments,
+	}, nil)
+}
+
+var hunkHeaderPattern = regexp.MustCompile(`^@@ -\d+(?:,\d+)? \+(\d+)(?:,\d+)? @@`)
+
+// NumberPatch prefixes every added or context line of a unified diff with its
+// line number in the new file (e.g. "L42 +foo"), so reviews can cite lines,
+// and returns the set of lines a review comment may point at.
+func NumberPatch(patch string) (string, map[int]bool) {
+	var b strings.Builder
+	lines := map[int]bool{}
+	newLine := 0
+	for _, line := range strings.Split(strings.TrimSuffix(patch, "\n"), "\n") {
+		if m := hunkHeaderPattern.FindStringSubmatch(line); m != nil {
+			newLine, _ = strconv.Atoi(m[1])
+			b.WriteString(line + "\n")
+			continue
+		}
+		if strings.HasPrefix(line, "-") || strings.HasPrefix(line, "\\") {
+			b.WriteString("      " + line + "\n")
+			continue
+		}
+		lines[newLine] = true
+		fmt.Fprintf(&b, "L%-4d %s\n", newLine, line)
+		newLine++
+	}
+	return b.String(), lines
+}
+
+// SplitPatchHunks splits a unified diff into pieces of at most maxChars,
+// breaking only between hunks (a single oversized hunk stays whole).
+func SplitPatchHunks(patch string, maxChars int) []string {
+	var hunks []string
+	for _, line := range strings.Split(patch, "\n") {
+		if strings.HasPrefix(line, "@@") || len(hunks) == 0 {
+			hunks = append(hunks, "")
+		}
+		hunks[len(hunks)-1] += line + "\n"
+	}
+
+	var chunks []string
+	current := ""
+	for _, h := range hunks {
+		if current != "" && len(current)+len(h) > maxChars {
+			chunks = append(chunks, current)
+			current = ""
+		}
+		current += h
+	}
+	if current != "" {
+		chunks = append(chunks, current)
+	}
+	return chunks
+}
@@ -0,0 +1,282 @@
+package utils
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"image"
+	"image/draw"
+	"image/jpeg"
+	"image/png"
+	"os"
+	"os/exec"
+	"path/filepath"
+)
+
+// MaxImageDimension is the longest side, in pixels, an image may have before it
+// is downscaled for upload. It can be set by the application after parsing flags.
+// Zero disables downscaling.
+var MaxImageDimension = 2048
+
+const jpegQuality = 90
+
+// PrepareImage loads an attachment and returns the bytes to upload together
+// with their MIME type. HEIC/HEIF images are transcoded to JPEG, and refused
+// when no converter is installed rather than uploaded with their EXIF and GPS
+// metadata. JPEG and PNG metadata is stripped by re-encoding, and photos larger
+// than MaxImageDimension are downscaled. WebP images keep their pixels but lose
+// their EXIF and XMP chunks. Other formats (pdf) are passed through unchanged.
+func PrepareImage(path string) ([]byte, string, error) {
+	mimeType, err := MIMETypeForPath(path)
+	if err != nil {
+		return nil, "", err
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to read image file %s: %w", path, err)
+	}
+
+	if mimeType == "image/heic" || mimeType == "image/heif" {
+		converted, err := transcodeHEIC(path)
+		if err != nil {
+			// The API accepts HEIC, but the original would carry the
+			// photo's location along.
+			return nil, "", fmt.Errorf("cannot remove the metadata of %s: %w", path, err)
+		}
+		data, mimeType = converted, "image/jpeg"
+	}
+
+	if mimeType == "image/webp" {
+		stripped, err := stripWebPMetadata(data)
+		if err != nil {
+			return nil, "", fmt.Errorf("cannot remove the metadata of %s: %w", path, err)
+		}
+		return stripped, mimeType, nil
+	}
+
+	if mimeType != "image/jpeg" && mimeType != "image/png" {
+		return data, mimeType, nil
+	}
+
+	img, format, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to decode image %s: %w", path, err)
+	}
+	if format == "jpeg" {
+		img = applyEXIFOrientation(img, jpegOrientation(data))
+	}
+	img = downscale(img, MaxImageDimension)
+
+	// Re-encoding drops EXIF and any other embedded metadata.
+	var buf bytes.Buffer
+	if format == "png" {
+		err = png.Encode(&buf, img)
+	} else {
+		err = jpeg.Encode(&buf, img, &jpeg.Options{Quality: jpegQuality})
+		mimeType = "image/jpeg"
+	}
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to encode image %s: %w", path, err)
+	}
+	return buf.Bytes(), mimeType, nil
+}
+
+// transcodeHEIC converts a HEIC/HEIF file to JPEG using the first converter found on PATH.
+func transcodeHEIC(path string) ([]byte, error) {
+	tmp, err := os.CreateTemp("", "ai-heic-*.jpg")
+	if err != nil {
+		return nil, fmt.Errorf("could not create temp file: %w", err)
+	}
+	out := tmp.Name()
+	tmp.Close()
+	defer os.Remove(out)
+
+	converters := [][]string{
+		{"heif-convert", path, out},
+		{"magick", path, out},
+		{"convert", path, out},
+		{"sips", "-s", "format", "jpeg", path, "--out", out},
+	}
+	for _, args := range converters {
+		if _, err := exec.LookPath(args[0]); err != nil {
+			continue
+		}
+		if output, err := exec.Command(args[0], args[1:]...).CombinedOutput(); err != nil {
+			return nil, fmt.Errorf("%s failed: %v: %s", args[0], err, bytes.TrimSpace(output))
+		}
+		return os.ReadFile(out)
+	}
+	return nil, fmt.Errorf("no HEIC converter found (install libheif, ImageMagick or use macOS sips) for %s", filepath.Base(path))
+}
+
+// stripWebPMetadata drops the EXIF and XMP chunks of a WebP file and clears
+// their flags in the VP8X header.
+func stripWebPMetadata(data []byte) ([]byte, error) {
+	if len(data) < 12 || string(data[:4]) != "RIFF" || string(data[8:12]) != "WEBP" {
+		return nil, fmt.Errorf("not a WebP file")
+	}
+	out := append([]byte(nil), data[:12]...)
+	for i := 12; i < len(data); {
+		if i+8 > len(data) {
+			return nil, fmt.Errorf("truncated WebP chunk header")
+		}
+		size := int(binary.LittleEndian.Uint32(data[i+4:]))
+		end := i + 8 + size
+		if end > len(data) {
+			return nil, fmt.Errorf("truncated WebP chunk %q", data[i:i+4])
+		}
+		if size%2 == 1 && end < len(data) {
+			end++ // chunks are padded to an even size
+		}
+		switch string(data[i : i+4]) {
+		case "EXIF", "XMP ":
+		case "VP8X":
+			chunk := append([]byte(nil), data[i:end]...)
+			if len(chunk) > 8 {
+				chunk[8] &^= 0x08 | 0x04 // the EXIF and XMP flags
+			}
+			out = append(out, chunk...)
+		default:
+			out = append(out, data[i:end]...)
+		}
+		i = end
+	}
+	binary.LittleEndian.PutUint32(out[4:], uint32(len(out)-8))
+	return out, nil
+}
+
+// downscale shrinks img with a box filter so its longest side is at most maxDim.
+func downscale(img image.Image, maxDim int) image.Image {
+	b := img.Bounds()
+	w, h := b.Dx(), b.Dy()
+	if maxDim <= 0 || (w <= maxDim && h <= maxDim) {
+		return img
+	}
+
+	dw, dh := maxDim, h*maxDim/w
+	if h > w {
+		dw, dh = w*maxDim/h, maxDim
+	}
+	dw, dh = max(dw, 1), max(dh, 1)
+
+	src := image.NewNRGBA(image.Rect(0, 0, w, h))
+	draw.Draw(src, src.Bounds(), img, b.Min, draw.Src)
+	dst := image.NewNRGBA(image.Rect(0, 0, dw, dh))
+
+	for y := 0; y < dh; y++ {
+		y0, y1 := y*h/dh, max((y+1)*h/dh, y*h/dh+1)
+		for x := 0; x < dw; x++ {
+			x0, x1 := x*w/dw, max((x+1)*w/dw, x*w/dw+1)
+			var r, g, bl, a, n int
+			for sy := y0; sy < y1; sy++ {
+				row := src.Pix[sy*src.Stride:]
+				for sx := x0; sx < x1; sx++ {
+					p := row[sx*4 : sx*4+4]
+					r += int(p[0])
+					g += int(p[1])
+					bl += int(p[2])
+					a += int(p[3])
+					n++
+				}
+			}
+			i := y*dst.Stride + x*4
+			dst.Pix[i] = uint8(r / n)
+			dst.Pix[i+1] = uint8(g / n)
+			dst.Pix[i+2] = uint8(bl / n)
+			dst.Pix[i+3] = uint8(a / n)
+		}
+	}
+	return dst
+}
+
+// jpegOrientation returns the EXIF orientation tag of a JPEG, or 1 if absent.
+func jpegOrientation(data []byte) int {
+	if len(data) < 4 || data[0] != 0xFF || data[1] != 0xD8 {
+		return 1
+	}
+	for i := 2; i+4 <= len(data); {
+		if data[i] != 0xFF {
+			return 1
+		}
+		marker := data[i+1]
+		size := int(binary.BigEndian.Uint16(data[i+2:]))
+		if marker == 0xDA || i+2+size > len(data) {
+			return 1 // start of scan: no more metadata segments
+		}
+		segment := data[i+4 : i+2+size]
+		if marker == 0xE1 && bytes.HasPrefix(segment, []byte("Exif\x00\x00")) {
+			return tiffOrientation(segment[6:])
+		}
+		i += 2 + size
+	}
+	return 1
+}
+
+func tiffOrientation(tiff []byte) int {
+	if len(tiff) < 8 {
+		return 1
+	}
+	var order binary.ByteOrder
+	switch string(tiff[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return 1
+	}
+	ifd := int(order.Uint32(tiff[4:]))
+	if ifd+2 > len(tiff) {
+		return 1
+	}
+	entries := int(order.Uint16(tiff[ifd:]))
+	for e := 0; e < entries; e++ {
+		off := ifd + 2 + e*12
+		if off+12 > len(tiff) {
+			return 1
+		}
+		if order.Uint16(tiff[off:]) == 0x0112 {
+			return int(order.Uint16(tiff[off+8:]))
+		}
+	}
+	return 1
+}
+
+// applyEXIFOrientation rotates/flips img so it displays upright once the EXIF
+// orientation tag has been stripped.
+func applyEXIFOrientation(img image.Image, orientation int) image.Image {
+	if orientation <= 1 || orientation > 8 {
+		return img
+	}
+	b := img.Bounds()
+	w, h := b.Dx(), b.Dy()
+	dw, dh := w, h
+	if orientation >= 5 {
+		dw, dh = h, w
+	}
+	dst := image.NewNRGBA(image.Rect(0, 0, dw, dh))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			var dx, dy int
+			switch orientation {
+			case 2:
+				dx, dy = w-1-x, y
+			case 3:
+				dx, dy = w-1-x, h-1-y
+			case 4:
+				dx, dy = x, h-1-y
+			case 5:
+				dx, dy = y, x
+			case 6:
+				dx, dy = h-1-y, x
+			case 7:
+				dx, dy = h-1-y, w-1-x
+			case 8:
+				dx, dy = y, w-1-x
+			}
+			dst.Set(dx, dy, img.At(b.Min.X+x, b.Min.Y+y))
+		}
+	}
+	return dst
+}
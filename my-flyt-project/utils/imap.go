@@ -0,0 +1,414 @@
+package utils
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/tls"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net"
+	"net/mail"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Email is a message fetched from an IMAP mailbox.
+type Email struct {
+	UID        uint32
+	From       string
+	To         string
+	Subject    string
+	Date       time.Time
+	MessageID  string
+	InReplyTo  string
+	References string
+	Body       string
+}
+
+// IMAPClient is a minimal IMAP4rev1 client. Mailboxes are opened with
+// EXAMINE and bodies fetched with BODY.PEEK, so reading never changes flags;
+// the only write is AppendDraft.
+type IMAPClient struct {
+	conn net.Conn
+	r    *bufio.Reader
+	tag  int
+}
+
+// imapResponse is one untagged server response and the literals it carried.
+type imapResponse struct {
+	Line     string
+	Literals [][]byte
+}
+
+// IMAPConfig is read from IMAP_HOST (host or host:port, TLS), IMAP_USER,
+// IMAP_PASSWORD and IMAP_DRAFTS (default "Drafts").
+type IMAPConfig struct {
+	Addr     string
+	User     string
+	Password string
+	Drafts   string
+}
+
+// IMAPConfigFromEnv reads the IMAP settings from the environment.
+func IMAPConfigFromEnv() (IMAPConfig, error) {
+	config := IMAPConfig{
+		Addr:     os.Getenv("IMAP_HOST"),
+		User:     os.Getenv("IMAP_USER"),
+		Password: os.Getenv("IMAP_PASSWORD"),
+		Drafts:   os.Getenv("IMAP_DRAFTS"),
+	}
+	if config.Addr == "" || config.User == "" || config.Password == "" {
+		return config, fmt.Errorf("set IMAP_HOST, IMAP_USER and IMAP_PASSWORD")
+	}
+	if !strings.Contains(config.Addr, ":") {
+		config.Addr += ":993"
+	}
+	if config.Drafts == "" {
+		config.Drafts = "Drafts"
+	}
+	return config, nil
+}
+
+// DialIMAP connects over TLS and logs in.
+func DialIMAP(config IMAPConfig) (*IMAPClient, error) {
+	host, _, _ := net.SplitHostPort(config.Addr)
+	conn, err := tls.DialWithDialer(&net.Dialer{Timeout: 15 * time.Second}, "tcp", config.Addr, &tls.Config{ServerName: host})
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to %s: %w", config.Addr, err)
+	}
+	c := &IMAPClient{conn: conn, r: bufio.NewReader(conn)}
+	if _, err := c.readLine(); err != nil { // server greeting
+		conn.Close()
+		return nil, err
+	}
+	if _, err := c.command("LOGIN %s %s", imapQuote(config.User), imapQuote(config.Password)); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("login failed: %w", err)
+	}
+	return c, nil
+}
+
+// Close logs out and closes the connection.
+func (c *IMAPClient) Close() error {
+	c.command("LOGOUT")
+	return c.conn.Close()
+}
+
+func imapQuote(s string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(s) + `"`
+}
+
+var imapLiteralPattern = regexp.MustCompile(`\{(\d+)\}$`)
+
+// readLine reads one response line, including any literals embedded in it.
+func (c *IMAPClient) readLine() (imapResponse, error) {
+	var resp imapResponse
+	for {
+		c.conn.SetReadDeadline(time.Now().Add(60 * time.Second))
+		part, err := c.r.ReadString('\n')
+		if err != nil {
+			return resp, fmt.Errorf("IMAP connection: %w", err)
+		}
+		part = strings.TrimRight(part, "\r\n")
+		resp.Line += part
+		m := imapLiteralPattern.FindStringSubmatch(part)
+		if m == nil {
+			return resp, nil
+		}
+		n, _ := strconv.Atoi(m[1])
+		literal := make([]byte, n)
+		if _, err := io.ReadFull(c.r, literal); err != nil {
+			return resp, fmt.Errorf("IMAP connection: %w", err)
+		}
+		resp.Literals = append(resp.Literals, literal)
+	}
+}
+
+// command sends a tagged command and returns the untagged responses, or an
+// error when the server does not answer OK.
+func (c *IMAPClient) command(format string, args ...any) ([]imapResponse, error) {
+	tag, err := c.send(format, args...)
+	if err != nil {
+		return nil, err
+	}
+	return c.collect(tag)
+}
+
+func (c *IMAPClient) send(format string, args ...any) (string, error) {
+	c.tag++
+	tag := fmt.Sprintf("A%d", c.tag)
+	c.conn.SetWriteDeadline(time.Now().Add(30 * time.Second))
+	_, err := fmt.Fprintf(c.conn, "%s %s\r\n", tag, fmt.Sprintf(format, args...))
+	return tag, err
+}
+
+func (c *IMAPClient) collect(tag string) ([]imapResponse, error) {
+	var untagged []imapResponse
+	for {
+		resp, err := c.readLine()
+		if err != nil {
+			return nil, err
+		}
+		if strings.HasPrefix(resp.Line, tag+" ") {
+			status := strings.TrimPrefix(resp.Line, tag+" ")
+			if !strings.HasPrefix(status, "OK") {
+				return nil, fmt.Errorf("IMAP: %s", status)
+			}
+			return untagged, nil
+		}
+		untagged = append(untagged, resp)
+	}
+}
+
+// Examine opens a mailbox read-only.
+func (c *IMAPClient) Examine(mailbox string) error {
+	_, err := c.command("EXAMINE %s", imapQuote(mailbox))
+	return err
+}
+
+// SearchRecent returns the UIDs of messages from the last days days that
+// contain text (anywhere, when text is not empty), newest last.
+func (c *IMAPClient) SearchRecent(days int, text string) ([]uint32, error) {
+	criteria := "SINCE " + time.Now().AddDate(0, 0, -days).Format("02-Jan-2006")
+	if text != "" {
+		criteria += " TEXT " + imapQuote(text)
+	}
+	responses, err := c.command("UID SEARCH %s", criteria)
+	if err != nil {
+		return nil, err
+	}
+	var uids []uint32
+	for _, resp := range responses {
+		fields := strings.Fields(resp.Line)
+		if len(fields) < 2 || fields[1] != "SEARCH" {
+			continue
+		}
+		for _, f := range fields[2:] {
+			if uid, err := strconv.ParseUint(f, 10, 32); err == nil {
+				uids = append(uids, uint32(uid))
+			}
+		}
+	}
+	sort.Slice(uids, func(i, j int) bool { return uids[i] < uids[j] })
+	return uids, nil
+}
+
+var fetchUIDPattern = regexp.MustCompile(`UID (\d+)`)
+
+// Fetch downloads and parses the given messages without marking them read.
+func (c *IMAPClient) Fetch(uids []uint32) ([]Email, error) {
+	if len(uids) == 0 {
+		return nil, nil
+	}
+	set := make([]string, len(uids))
+	for i, uid := range uids {
+		set[i] = strconv.FormatUint(uint64(uid), 10)
+	}
+	responses, err := c.command("UID FETCH %s (UID BODY.PEEK[])", strings.Join(set, ","))
+	if err != nil {
+		return nil, err
+	}
+
+	var emails []Email
+	for _, resp := range responses {
+		if len(resp.Literals) == 0 {
+			continue
+		}
+		email, err := ParseEmail(resp.Literals[0])
+		if err != nil {
+			continue
+		}
+		if m := fetchUIDPattern.FindStringSubmatch(resp.Line); m != nil {
+			uid, _ := strconv.ParseUint(m[1], 10, 32)
+			email.UID = uint32(uid)
+		}
+		emails = append(emails, email)
+	}
+	sort.Slice(emails, func(i, j int) bool { return emails[i].Date.Before(emails[j].Date) })
+	return emails, nil
+}
+
+// AppendDraft stores a message in the drafts mailbox with the \Draft flag.
+func (c *IMAPClient) AppendDraft(mailbox string, message []byte) error {
+	if err := CheckWritable("saving a draft"); err != nil {
+		return err
+	}
+	tag, err := c.send("APPEND %s (\\Draft) {%d}", imapQuote(mailbox), len(message))
+	if err != nil {
+		return err
+	}
+	resp, err := c.readLine()
+	if err != nil {
+		return err
+	}
+	if !strings.HasPrefix(resp.Line, "+") {
+		return fmt.Errorf("IMAP: server refused the draft: %s", resp.Line)
+	}
+	if _, err := c.conn.Write(append(message, '\r', '\n')); err != nil {
+		return err
+	}
+	_, err = c.collect(tag)
+	return err
+}
+
+// ParseEmail parses a raw RFC 5322 message, keeping its plain-text body.
+func ParseEmail(raw []byte) (Email, error) {
+	msg, err := mail.ReadMessage(bytes.NewReader(raw))
+	if err != nil {
+		return Email{}, err
+	}
+	dec := new(mime.WordDecoder)
+	header := func(name string) string {
+		value := msg.Header.Get(name)
+		if decoded, err := dec.DecodeHeader(value); err == nil {
+			return decoded
+		}
+		return value
+	}
+	email := Email{
+		From:       header("From"),
+		To:         header("To"),
+		Subject:    header("Subject"),
+		MessageID:  msg.Header.Get("Message-Id"),
+		InReplyTo:  msg.Header.Get("In-Reply-To"),
+		References: msg.Header.Get("References"),
+	}
+	email.Date, _ = msg.Header.Date()
+	email.Body = strings.TrimSpace(textBody(msg.Header.Get("Content-Type"), msg.Header.Get("Content-Transfer-Encoding"), msg.Body))
+	return email, nil
+}
+
+// textBody returns the text/plain part of a (possibly multipart) body,
+// falling back to stripped HTML.
+func textBody(contentType, encoding string, body io.Reader) string {
+	mediaType, params, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		mediaType = "text/plain"
+	}
+	switch strings.ToLower(encoding) {
+	case "quoted-printable":
+		body = quotedprintable.NewReader(body)
+	case "base64":
+		body = base64.NewDecoder(base64.StdEncoding, body)
+	}
+
+	if strings.HasPrefix(mediaType, "multipart/") {
+		reader := multipart.NewReader(body, params["boundary"])
+		var html string
+		for {
+			part, err := reader.NextPart()
+			if err != nil {
+				break
+			}
+			// NextPart already undoes quoted-printable, so only base64 is passed on.
+			partEncoding := part.Header.Get("Content-Transfer-Encoding")
+			if strings.EqualFold(partEncoding, "quoted-printable") {
+				partEncoding = ""
+			}
+			text := textBody(part.Header.Get("Content-Type"), partEncoding, part)
+			partType, _, _ := mime.ParseMediaType(part.Header.Get("Content-Type"))
+			switch {
+			case partType == "text/html" && html == "":
+				html = text
+			case text != "" && partType != "text/html":
+				return text
+			}
+		}
+		return html
+	}
+
+	data, _ := io.ReadAll(body)
+	switch mediaType {
+	case "text/plain":
+		return string(data)
+	case "text/html":
+		return StripHTML(string(data))
+	}
+	return ""
+}
+
+// ThreadSubject normalizes a subject so replies and forwards group with the original.
+func ThreadSubject(subject string) string {
+	for {
+		trimmed := strings.TrimSpace(subject)
+		lower := strings.ToLower(trimmed)
+		switch {
+		case strings.HasPrefix(lower, "re:"), strings.HasPrefix(lower, "fw:"):
+			subject = trimmed[3:]
+		case strings.HasPrefix(lower, "fwd:"):
+			subject = trimmed[4:]
+		default:
+			return trimmed
+		}
+	}
+}
+
+// FormatEmails lists messages grouped by thread, oldest first, as prompt context.
+func FormatEmails(emails []Email, maxBodyChars int) string {
+	var threads []string
+	byThread := map[string][]int{}
+	for i, e := range emails {
+		key := ThreadSubject(e.Subject)
+		if _, ok := byThread[key]; !ok {
+			threads = append(threads, key)
+		}
+		byThread[key] = append(byThread[key], i)
+	}
+
+	var b strings.Builder
+	for _, thread := range threads {
+		fmt.Fprintf(&b, "=== Thread: %s ===\n", thread)
+		for _, i := range byThread[thread] {
+			e := emails[i]
+			body := e.Body
+			if len(body) > maxBodyChars {
+				body = body[:maxBodyChars] + "\n[...]"
+			}
+			fmt.Fprintf(&b, "[Email %d] From: %s\nTo: %s\nDate: %s\nSubject: %s\n\n%s\n\n", i+1, e.From, e.To, e.Date.Format("Mon 2006-01-02 15:04"), e.Subject, body)
+		}
+	}
+	return b.String()
+}
+
+// ComposeReply builds an RFC 5322 reply to original from the given address.
+func ComposeReply(original Email, from, body string) []byte {
+	subject := original.Subject
+	if !strings.HasPrefix(strings.ToLower(subject), "re:") {
+		subject = "Re: " + subject
+	}
+	to := original.From
+	references := strings.TrimSpace(original.References + " " + original.MessageID)
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "From: %s\r\n", from)
+	fmt.Fprintf(&b, "To: %s\r\n", to)
+	fmt.Fprintf(&b, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", subject))
+	fmt.Fprintf(&b, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	if original.MessageID != "" {
+		fmt.Fprintf(&b, "In-Reply-To: %s\r\n", original.MessageID)
+		fmt.Fprintf(&b, "References: %s\r\n", references)
+	}
+	b.WriteString("MIME-Version: 1.0\r\nContent-Type: text/plain; charset=utf-8\r\nContent-Transfer-Encoding: 8bit\r\n\r\n")
+	b.WriteString(strings.ReplaceAll(strings.ReplaceAll(body, "\r\n", "\n"), "\n", "\r\n"))
+	return []byte(b.String())
+}
+
+// ComposeMessage builds a new plain-text RFC 5322 message.
+func ComposeMessage(from, to, subject, body string) []byte {
+	var b strings.Builder
+	fmt.Fprintf(&b, "From: %s\r\n", from)
+	fmt.Fprintf(&b, "To: %s\r\n", to)
+	fmt.Fprintf(&b, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", subject))
+	fmt.Fprintf(&b, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	b.WriteString("MIME-Version: 1.0\r\nContent-Type: text/plain; charset=utf-8\r\nContent-Transfer-Encoding: 8bit\r\n\r\n")
+	b.WriteString(strings.ReplaceAll(strings.ReplaceAll(body, "\r\n", "\n"), "\n", "\r\n"))
+	return []byte(b.String())
+}
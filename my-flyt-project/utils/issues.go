@@ -0,0 +1,210 @@
+package utils
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+// Issue is an open issue on GitHub or GitLab.
+type Issue struct {
+	Number int
+	Title  string
+	Body   string
+	URL    string
+	Labels []string
+}
+
+// IssueTracker reads and triages issues of one repository.
+type IssueTracker interface {
+	OpenIssues(limit int) ([]Issue, error)
+	AddLabels(number int, labels []string) error
+	Comment(number int, body string) error
+}
+
+// NewIssueTracker returns a tracker for repo ("owner/name" on GitHub,
+// "group/project" on GitLab). forge is "github" or "gitlab".
+func NewIssueTracker(forge, repo string) (IssueTracker, error) {
+	if !strings.Contains(repo, "/") {
+		return nil, fmt.Errorf("repository must look like owner/name, got %q", repo)
+	}
+	switch forge {
+	case "", "github":
+		return gitHubTracker{repo: repo}, nil
+	case "gitlab":
+		return gitLabTracker{project: url.PathEscape(repo)}, nil
+	default:
+		return nil, fmt.Errorf("unknown forge %q (use github or gitlab)", forge)
+	}
+}
+
+type gitHubTracker struct{ repo string }
+
+func (t gitHubTracker) OpenIssues(limit int) ([]Issue, error) {
+	var issues []Issue
+	for page := 1; len(issues) < limit; page++ {
+		var batch []struct {
+			Number  int    `json:"number"`
+			Title   string `json:"title"`
+			Body    string `json:"body"`
+			HTMLURL string `json:"html_url"`
+			Labels  []struct {
+				Name string `json:"name"`
+			} `json:"labels"`
+			PullRequest *struct{} `json:"pull_request"`
+		}
+		if err := GitHubRequest("GET", fmt.Sprintf("/repos/%s/issues?state=open&per_page=100&page=%d", t.repo, page), nil, &batch); err != nil {
+			return nil, err
+		}
+		for _, i := range batch {
+			if i.PullRequest != nil {
+				continue // the issues endpoint also lists pull requests
+			}
+			issue := Issue{Number: i.Number, Title: i.Title, Body: i.Body, URL: i.HTMLURL}
+			for _, l := range i.Labels {
+				issue.Labels = append(issue.Labels, l.Name)
+			}
+			issues = append(issues, issue)
+		}
+		if len(batch) < 100 {
+			break
+		}
+	}
+	if len(issues) > limit {
+		issues = issues[:limit]
+	}
+	return issues, nil
+}
+
+func (t gitHubTracker) AddLabels(number int, labels []string) error {
+	return GitHubRequest("POST", fmt.Sprintf("/repos/%s/issues/%d/labels", t.repo, number), map[string]any{"labels": labels}, nil)
+}
+
+func (t gitHubTracker) Comment(number int, body string) error {
+	return GitHubRequest("POST", fmt.Sprintf("/repos/%s/issues/%d/comments", t.repo, number), map[string]any{"body": body}, nil)
+}
+
+type gitLabTracker struct{ project string }
+
+// gitLabRequest calls the GitLab REST API at GITLAB_URL (default gitlab.com),
+// authenticating with GITLAB_TOKEN when it is set.
+func gitLabRequest(method, path string, body any, out any) error {
+	if method != "GET" {
+		if err := CheckWritable("GitLab " + method + " " + path); err != nil {
+			return err
+		}
+	}
+	base := os.Getenv("GITLAB_URL")
+	if base == "" {
+		base = "https://gitlab.com"
+	}
+	var reader io.Reader
+	if body != nil {
+		jsonData, err := json.Marshal(body)
+		if err != nil {
+			return fmt.Errorf("failed to marshal request: %w", err)
+		}
+		reader = bytes.NewBuffer(jsonData)
+	}
+
+	req, err := http.NewRequest(method, strings.TrimSuffix(base, "/")+"/api/v4"+path, reader)
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	if token := os.Getenv("GITLAB_TOKEN"); token != "" {
+		req.Header.Set("PRIVATE-TOKEN", token)
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	client := &http.Client{Timeout: 30 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to make request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("failed to read response: %w", err)
+	}
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("GitLab %s %s failed with status %d: %s", method, path, resp.StatusCode, string(respBody))
+	}
+	if out == nil {
+		return nil
+	}
+	if err := json.Unmarshal(respBody, out); err != nil {
+		return fmt.Errorf("failed to parse response: %w", err)
+	}
+	return nil
+}
+
+func (t gitLabTracker) OpenIssues(limit int) ([]Issue, error) {
+	var issues []Issue
+	for page := 1; len(issues) < limit; page++ {
+		var batch []struct {
+			IID         int      `json:"iid"`
+			Title       string   `json:"title"`
+			Description string   `json:"description"`
+			WebURL      string   `json:"web_url"`
+			Labels      []string `json:"labels"`
+		}
+		if err := gitLabRequest("GET", fmt.Sprintf("/projects/%s/issues?state=opened&per_page=100&page=%d", t.project, page), nil, &batch); err != nil {
+			return nil, err
+		}
+		for _, i := range batch {
+			issues = append(issues, Issue{Number: i.IID, Title: i.Title, Body: i.Description, URL: i.WebURL, Labels: i.Labels})
+		}
+		if len(batch) < 100 {
+			break
+		}
+	}
+	if len(issues) > limit {
+		issues = issues[:limit]
+	}
+	return issues, nil
+}
+
+func (t gitLabTracker) AddLabels(number int, labels []string) error {
+	return gitLabRequest("PUT", fmt.Sprintf("/projects/%s/issues/%d", t.project, number), map[string]any{"add_labels": strings.Join(labels, ",")}, nil)
+}
+
+func (t gitLabTracker) Comment(number int, body string) error {
+	return gitLabRequest("POST", fmt.Sprintf("/projects/%s/issues/%d/notes", t.project, number), map[string]any{"body": body}, nil)
+}
+
+// DuplicateThreshold is the cosine similarity above which two issues are
+// reported as likely duplicates.
+var DuplicateThreshold = 0.85
+
+// FindDuplicateIssues embeds every issue and returns, for each issue number,
+// the earlier-filed issues that look like the same report.
+func FindDuplicateIssues(ctx context.Context, issues []Issue) (map[int][]int, error) {
+	texts := make([]string, len(issues))
+	for i, issue := range issues {
+		texts[i] = truncateRunes(issue.Title+"\n\n"+issue.Body, 2000)
+	}
+	vectors, err := EmbedTexts(ctx, texts)
+	if err != nil {
+		return nil, err
+	}
+
+	duplicates := map[int][]int{}
+	for i := range issues {
+		for j := range issues {
+			if issues[j].Number < issues[i].Number && CosineSimilarity(vectors[i], vectors[j]) >= DuplicateThreshold {
+				duplicates[issues[i].Number] = append(duplicates[issues[i].Number], issues[j].Number)
+			}
+		}
+	}
+	return duplicates, nil
+}
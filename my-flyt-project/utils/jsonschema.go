@@ -0,0 +1,167 @@
+package utils
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+)
+
+// ValidateJSONSchema checks value, as decoded by encoding/json, against the
+// subset of JSON Schema used for model replies: type, properties, required,
+// additionalProperties (false), items, enum, pattern, minLength, minItems,
+// minimum and maximum. The error lists every violation with its path.
+func ValidateJSONSchema(value any, schema map[string]any) error {
+	var problems []string
+	validateSchemaAt("$", value, schema, &problems)
+	if len(problems) > 0 {
+		return fmt.Errorf("does not match the schema:\n%s", strings.Join(problems, "\n"))
+	}
+	return nil
+}
+
+func validateSchemaAt(path string, value any, schema map[string]any, problems *[]string) {
+	fail := func(format string, a ...any) {
+		*problems = append(*problems, path+": "+fmt.Sprintf(format, a...))
+	}
+
+	if t, ok := schema["type"]; ok {
+		var types []string
+		switch t := t.(type) {
+		case string:
+			types = []string{t}
+		case []string:
+			types = t
+		case []any:
+			for _, v := range t {
+				types = append(types, fmt.Sprint(v))
+			}
+		}
+		if !slices.ContainsFunc(types, func(t string) bool { return jsonTypeMatches(t, value) }) {
+			fail("expected %s, got %s", strings.Join(types, " or "), jsonTypeName(value))
+			return
+		}
+	}
+	if enum, ok := schemaList(schema["enum"]); ok {
+		if !slices.ContainsFunc(enum, func(e any) bool { return fmt.Sprint(e) == fmt.Sprint(value) }) {
+			fail("%s is not one of %v", jsonText(value), enum)
+		}
+	}
+
+	switch v := value.(type) {
+	case string:
+		if pattern, ok := schema["pattern"].(string); ok {
+			if re, err := regexp.Compile(pattern); err == nil && !re.MatchString(v) {
+				fail("%q does not match %s", v, pattern)
+			}
+		}
+		if n, ok := schemaNumber(schema["minLength"]); ok && float64(len([]rune(v))) < n {
+			fail("shorter than %v characters", n)
+		}
+	case float64:
+		if n, ok := schemaNumber(schema["minimum"]); ok && v < n {
+			fail("%v is less than %v", v, n)
+		}
+		if n, ok := schemaNumber(schema["maximum"]); ok && v > n {
+			fail("%v is more than %v", v, n)
+		}
+	case []any:
+		if n, ok := schemaNumber(schema["minItems"]); ok && float64(len(v)) < n {
+			fail("has fewer than %v items", n)
+		}
+		if items, ok := schema["items"].(map[string]any); ok {
+			for i, item := range v {
+				validateSchemaAt(fmt.Sprintf("%s[%d]", path, i), item, items, problems)
+			}
+		}
+	case map[string]any:
+		properties, _ := schema["properties"].(map[string]any)
+		if required, ok := schemaList(schema["required"]); ok {
+			for _, name := range required {
+				if _, present := v[fmt.Sprint(name)]; !present {
+					fail("missing required property %q", name)
+				}
+			}
+		}
+		names := make([]string, 0, len(v))
+		for name := range v {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			sub, known := properties[name].(map[string]any)
+			if !known {
+				if schema["additionalProperties"] == false {
+					fail("unexpected property %q", name)
+				}
+				continue
+			}
+			validateSchemaAt(path+"."+name, v[name], sub, problems)
+		}
+	}
+}
+
+func jsonTypeMatches(t string, value any) bool {
+	switch t {
+	case "integer":
+		f, ok := value.(float64)
+		return ok && f == float64(int64(f))
+	case "number":
+		_, ok := value.(float64)
+		return ok
+	}
+	return jsonTypeName(value) == t
+}
+
+func jsonTypeName(value any) string {
+	switch value.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case float64:
+		return "number"
+	case string:
+		return "string"
+	case []any:
+		return "array"
+	case map[string]any:
+		return "object"
+	}
+	return fmt.Sprintf("%T", value)
+}
+
+// schemaList accepts both Go-literal ([]string) and decoded ([]any) lists.
+func schemaList(v any) ([]any, bool) {
+	switch v := v.(type) {
+	case []any:
+		return v, true
+	case []string:
+		list := make([]any, len(v))
+		for i, s := range v {
+			list[i] = s
+		}
+		return list, true
+	}
+	return nil, false
+}
+
+func schemaNumber(v any) (float64, bool) {
+	switch v := v.(type) {
+	case int:
+		return float64(v), true
+	case float64:
+		return v, true
+	}
+	return 0, false
+}
+
+func jsonText(v any) string {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Sprint(v)
+	}
+	return string(data)
+}
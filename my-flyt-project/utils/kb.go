@@ -0,0 +1,265 @@
+package utils
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// KBDocument is a document pulled from a knowledge-base source.
+type KBDocument struct {
+	ID       string
+	Title    string
+	URL      string
+	Content  string
+	Modified time.Time
+	// Language is set for source files (see CodeLanguage), which are chunked
+	// along their declarations instead of by size.
+	Language string
+}
+
+// KBConnector pulls documents from one knowledge base (Confluence, Notion,
+// Google Drive, a local repository). Changed returns the documents modified after since; a zero
+// since asks for everything.
+type KBConnector interface {
+	Changed(since time.Time) ([]KBDocument, error)
+}
+
+// KBSource configures one connector. Name is also the namespace its
+// documents are indexed under. Credentials come from the environment.
+type KBSource struct {
+	Name string `json:"name"`
+	Type string `json:"type"` // confluence, notion, gdrive or repo
+	// Space is the Confluence space key; Folder the Google Drive folder ID;
+	// Path the directory of a repo source.
+	Space  string `json:"space,omitempty"`
+	Folder string `json:"folder,omitempty"`
+	Path   string `json:"path,omitempty"`
+}
+
+// LoadKBSources reads the JSON list of sources at path.
+func LoadKBSources(path string) ([]KBSource, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read sources: %w", err)
+	}
+	var sources []KBSource
+	if err := json.Unmarshal(data, &sources); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", path, err)
+	}
+	for _, s := range sources {
+		if s.Name == "" {
+			return nil, fmt.Errorf("%s: every source needs a name", path)
+		}
+	}
+	return sources, nil
+}
+
+// Connector returns the connector for the source's type.
+func (s KBSource) Connector() (KBConnector, error) {
+	switch s.Type {
+	case "confluence":
+		return newConfluenceConnector(s.Space)
+	case "notion":
+		return newNotionConnector()
+	case "gdrive":
+		return newDriveConnector(s.Folder)
+	case "repo":
+		return newRepoConnector(s.Path)
+	default:
+		return nil, fmt.Errorf("source %s: unknown type %q (use confluence, notion, gdrive or repo)", s.Name, s.Type)
+	}
+}
+
+// KBChunk is an embedded piece of a document.
+type KBChunk struct {
+	Namespace string    `json:"namespace"`
+	DocID     string    `json:"doc_id"`
+	Title     string    `json:"title"`
+	URL       string    `json:"url"`
+	Symbol    string    `json:"symbol,omitempty"` // declaration a code chunk holds
+	Text      string    `json:"text"`
+	Vector    []float64 `json:"vector"`
+}
+
+// Label is the chunk's title, followed by its symbol for code.
+func (c KBChunk) Label() string {
+	if c.Symbol == "" {
+		return c.Title
+	}
+	return c.Title + " › " + c.Symbol
+}
+
+// KBIndex is the local retrieval index: embedded chunks of every synced
+// document, and when each source was last synced.
+type KBIndex struct {
+	path     string
+	LastSync map[string]time.Time `json:"last_sync"`
+	Chunks   []KBChunk            `json:"chunks"`
+}
+
+// kbChunkChars is the size of indexed chunks, small enough to retrieve precisely.
+const kbChunkChars = 1500
+
+// DefaultKBIndexPath returns where the index is kept.
+func DefaultKBIndexPath() string {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		dir = os.TempDir()
+	}
+	return filepath.Join(dir, "ai_wraper", "kb_index.json")
+}
+
+// LoadKBIndex reads the index at path; a missing file is an empty index.
+func LoadKBIndex(path string) (*KBIndex, error) {
+	index := &KBIndex{path: path, LastSync: map[string]time.Time{}}
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return index, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read index: %w", err)
+	}
+	if err := json.Unmarshal(data, index); err != nil {
+		return nil, fmt.Errorf("failed to parse index %s: %w", path, err)
+	}
+	if index.LastSync == nil {
+		index.LastSync = map[string]time.Time{}
+	}
+	return index, nil
+}
+
+// Save writes the index atomically.
+func (x *KBIndex) Save() error {
+	if err := os.MkdirAll(filepath.Dir(x.path), 0755); err != nil {
+		return err
+	}
+	data, err := json.Marshal(x)
+	if err != nil {
+		return err
+	}
+	tmp := x.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, x.path)
+}
+
+// Upsert replaces the chunks of doc in namespace with freshly embedded ones.
+func (x *KBIndex) Upsert(ctx context.Context, namespace string, doc KBDocument) error {
+	var texts []string
+	var chunks []KBChunk
+	if doc.Language != "" {
+		// The header names the file, symbol and lines, so they are embedded and cited with the code.
+		for _, c := range ChunkCode(doc.Content, doc.Language, kbChunkChars) {
+			chunk := KBChunk{Namespace: namespace, DocID: doc.ID, Title: doc.Title, URL: fmt.Sprintf("%s#L%d-L%d", doc.URL, c.StartLine, c.EndLine), Symbol: c.Symbol}
+			texts = append(texts, fmt.Sprintf("%s (lines %d-%d)\n%s", chunk.Label(), c.StartLine, c.EndLine, c.Text))
+			chunks = append(chunks, chunk)
+		}
+	} else {
+		for _, text := range ChunkText(doc.Content, kbChunkChars) {
+			texts = append(texts, doc.Title+"\n"+text)
+			chunks = append(chunks, KBChunk{Namespace: namespace, DocID: doc.ID, Title: doc.Title, URL: doc.URL})
+		}
+	}
+	var vectors [][]float64
+	if len(texts) > 0 {
+		var err error
+		if vectors, err = EmbedTexts(ctx, texts); err != nil {
+			return fmt.Errorf("failed to embed %s: %w", doc.Title, err)
+		}
+	}
+
+	kept := x.Chunks[:0]
+	for _, c := range x.Chunks {
+		if c.Namespace != namespace || c.DocID != doc.ID {
+			kept = append(kept, c)
+		}
+	}
+	x.Chunks = kept
+	for i, chunk := range chunks {
+		chunk.Text, chunk.Vector = texts[i], vectors[i]
+		x.Chunks = append(x.Chunks, chunk)
+	}
+	return nil
+}
+
+// Sync pulls the documents changed since the source's last sync into the
+// index and returns how many were updated. The index is saved after each
+// source, so an interrupted sync keeps its progress.
+func (x *KBIndex) Sync(ctx context.Context, source KBSource) (int, error) {
+	connector, err := source.Connector()
+	if err != nil {
+		return 0, err
+	}
+	started := time.Now()
+	docs, err := connector.Changed(x.LastSync[source.Name])
+	if err != nil {
+		return 0, fmt.Errorf("source %s: %w", source.Name, err)
+	}
+	for _, doc := range docs {
+		if err := x.Upsert(ctx, source.Name, doc); err != nil {
+			return 0, err
+		}
+	}
+	x.LastSync[source.Name] = started
+	return len(docs), x.Save()
+}
+
+// KBResult is a retrieved chunk and its similarity to the query.
+type KBResult struct {
+	KBChunk
+	Score float64
+}
+
+// Search returns the k chunks most similar to query, only from the given
+// namespaces when any are given.
+func (x *KBIndex) Search(ctx context.Context, query string, k int, namespaces ...string) ([]KBResult, error) {
+	allowed := map[string]bool{}
+	for _, ns := range namespaces {
+		allowed[ns] = true
+	}
+	var candidates []KBChunk
+	for _, c := range x.Chunks {
+		if len(allowed) == 0 || allowed[c.Namespace] {
+			candidates = append(candidates, c)
+		}
+	}
+	if len(candidates) == 0 {
+		return nil, nil
+	}
+
+	vectors, err := EmbedTexts(ctx, []string{query})
+	if err != nil {
+		return nil, err
+	}
+	results := make([]KBResult, 0, len(candidates))
+	for _, c := range candidates {
+		results = append(results, KBResult{KBChunk: c, Score: CosineSimilarity(vectors[0], c.Vector)})
+	}
+	sort.Slice(results, func(i, j int) bool { return results[i].Score > results[j].Score })
+	if len(results) > k {
+		results = results[:k]
+	}
+	return results, nil
+}
+
+// Namespaces counts the indexed documents per namespace.
+func (x *KBIndex) Namespaces() map[string]int {
+	docs := map[string]map[string]bool{}
+	for _, c := range x.Chunks {
+		if docs[c.Namespace] == nil {
+			docs[c.Namespace] = map[string]bool{}
+		}
+		docs[c.Namespace][c.DocID] = true
+	}
+	counts := map[string]int{}
+	for ns, ids := range docs {
+		counts[ns] = len(ids)
+	}
+	return counts
+}
@@ -0,0 +1,359 @@
+package utils
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// kbRequest performs an authenticated JSON (or plain-text, when out is a
+// *string) request for a connector.
+func kbRequest(method, url, auth string, headers map[string]string, body any, out any) error {
+	var reader io.Reader
+	if body != nil {
+		jsonData, err := json.Marshal(body)
+		if err != nil {
+			return fmt.Errorf("failed to marshal request: %w", err)
+		}
+		reader = bytes.NewBuffer(jsonData)
+	}
+	req, err := http.NewRequest(method, url, reader)
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Authorization", auth)
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+	client := &http.Client{Timeout: 60 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to make request: %w", err)
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("failed to read response: %w", err)
+	}
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("%s %s failed with status %d: %s", method, req.URL.Path, resp.StatusCode, truncateBody(data))
+	}
+	if text, ok := out.(*string); ok {
+		*text = string(data)
+		return nil
+	}
+	return json.Unmarshal(data, out)
+}
+
+// confluenceConnector reads a Confluence Cloud space with CONFLUENCE_URL
+// (https://your-site.atlassian.net), CONFLUENCE_EMAIL and CONFLUENCE_API_TOKEN.
+type confluenceConnector struct {
+	base, auth, space string
+}
+
+func newConfluenceConnector(space string) (KBConnector, error) {
+	base, email, token := os.Getenv("CONFLUENCE_URL"), os.Getenv("CONFLUENCE_EMAIL"), os.Getenv("CONFLUENCE_API_TOKEN")
+	if base == "" || email == "" || token == "" || space == "" {
+		return nil, fmt.Errorf("confluence needs a space and CONFLUENCE_URL, CONFLUENCE_EMAIL and CONFLUENCE_API_TOKEN")
+	}
+	auth := "Basic " + base64.StdEncoding.EncodeToString([]byte(email+":"+token))
+	return confluenceConnector{base: strings.TrimSuffix(base, "/"), auth: auth, space: space}, nil
+}
+
+func (c confluenceConnector) Changed(since time.Time) ([]KBDocument, error) {
+	cql := fmt.Sprintf(`space = "%s" and type = page`, c.space)
+	if !since.IsZero() {
+		cql += fmt.Sprintf(` and lastmodified >= "%s"`, since.Format("2006/01/02 15:04"))
+	}
+	var docs []KBDocument
+	for start := 0; ; start += 50 {
+		var page struct {
+			Results []struct {
+				ID    string `json:"id"`
+				Title string `json:"title"`
+				Body  struct {
+					Storage struct {
+						Value string `json:"value"`
+					} `json:"storage"`
+				} `json:"body"`
+				Version struct {
+					When time.Time `json:"when"`
+				} `json:"version"`
+				Links struct {
+					WebUI string `json:"webui"`
+				} `json:"_links"`
+			} `json:"results"`
+			Size int `json:"size"`
+		}
+		u := fmt.Sprintf("%s/wiki/rest/api/content/search?cql=%s&expand=body.storage,version&limit=50&start=%d", c.base, url.QueryEscape(cql), start)
+		if err := kbRequest("GET", u, c.auth, nil, nil, &page); err != nil {
+			return nil, err
+		}
+		for _, r := range page.Results {
+			docs = append(docs, KBDocument{
+				ID: r.ID, Title: r.Title, URL: c.base + "/wiki" + r.Links.WebUI,
+				Content: StripHTML(r.Body.Storage.Value), Modified: r.Version.When,
+			})
+		}
+		if page.Size < 50 {
+			return docs, nil
+		}
+	}
+}
+
+// notionConnector reads every page shared with the integration whose token is NOTION_TOKEN.
+type notionConnector struct{ auth string }
+
+var notionHeaders = map[string]string{"Notion-Version": "2022-06-28"}
+
+func newNotionConnector() (KBConnector, error) {
+	token := os.Getenv("NOTION_TOKEN")
+	if token == "" {
+		return nil, fmt.Errorf("notion needs NOTION_TOKEN")
+	}
+	return notionConnector{auth: "Bearer " + token}, nil
+}
+
+func (c notionConnector) Changed(since time.Time) ([]KBDocument, error) {
+	var docs []KBDocument
+	cursor := ""
+	for {
+		body := map[string]any{
+			"filter":    map[string]string{"property": "object", "value": "page"},
+			"sort":      map[string]string{"direction": "descending", "timestamp": "last_edited_time"},
+			"page_size": 100,
+		}
+		if cursor != "" {
+			body["start_cursor"] = cursor
+		}
+		var page struct {
+			Results []struct {
+				ID             string                     `json:"id"`
+				URL            string                     `json:"url"`
+				LastEditedTime time.Time                  `json:"last_edited_time"`
+				Properties     map[string]json.RawMessage `json:"properties"`
+			} `json:"results"`
+			HasMore    bool   `json:"has_more"`
+			NextCursor string `json:"next_cursor"`
+		}
+		if err := kbRequest("POST", "https://api.notion.com/v1/search", c.auth, notionHeaders, body, &page); err != nil {
+			return nil, err
+		}
+		for _, r := range page.Results {
+			// Results are newest first, so the first unchanged page ends the sync.
+			if !since.IsZero() && r.LastEditedTime.Before(since) {
+				return docs, nil
+			}
+			content, err := c.pageText(r.ID)
+			if err != nil {
+				return nil, err
+			}
+			docs = append(docs, KBDocument{ID: r.ID, Title: notionTitle(r.Properties), URL: r.URL, Content: content, Modified: r.LastEditedTime})
+		}
+		if !page.HasMore {
+			return docs, nil
+		}
+		cursor = page.NextCursor
+	}
+}
+
+// notionTitle finds the title property of a page.
+func notionTitle(properties map[string]json.RawMessage) string {
+	for _, raw := range properties {
+		var prop struct {
+			Type  string `json:"type"`
+			Title []struct {
+				PlainText string `json:"plain_text"`
+			} `json:"title"`
+		}
+		if json.Unmarshal(raw, &prop) == nil && prop.Type == "title" {
+			var b strings.Builder
+			for _, t := range prop.Title {
+				b.WriteString(t.PlainText)
+			}
+			return b.String()
+		}
+	}
+	return "Untitled"
+}
+
+// pageText concatenates the text of a page's top-level blocks.
+func (c notionConnector) pageText(id string) (string, error) {
+	var b strings.Builder
+	cursor := ""
+	for {
+		u := fmt.Sprintf("https://api.notion.com/v1/blocks/%s/children?page_size=100", id)
+		if cursor != "" {
+			u += "&start_cursor=" + url.QueryEscape(cursor)
+		}
+		var page struct {
+			Results    []map[string]json.RawMessage `json:"results"`
+			HasMore    bool                         `json:"has_more"`
+			NextCursor string                       `json:"next_cursor"`
+		}
+		if err := kbRequest("GET", u, c.auth, notionHeaders, nil, &page); err != nil {
+			return "", err
+		}
+		for _, block := range page.Results {
+			var blockType string
+			json.Unmarshal(block["type"], &blockType)
+			var content struct {
+				RichText []struct {
+					PlainText string `json:"plain_text"`
+				} `json:"rich_text"`
+			}
+			if json.Unmarshal(block[blockType], &content) != nil {
+				continue
+			}
+			for _, t := range content.RichText {
+				b.WriteString(t.PlainText)
+			}
+			b.WriteString("\n")
+		}
+		if !page.HasMore {
+			return b.String(), nil
+		}
+		cursor = page.NextCursor
+	}
+}
+
+// driveConnector reads Google Docs and text files with the OAuth access
+// token in GOOGLE_DRIVE_TOKEN, optionally limited to one folder.
+type driveConnector struct{ auth, folder string }
+
+func newDriveConnector(folder string) (KBConnector, error) {
+	token := os.Getenv("GOOGLE_DRIVE_TOKEN")
+	if token == "" {
+		return nil, fmt.Errorf("gdrive needs GOOGLE_DRIVE_TOKEN (an OAuth access token with drive.readonly)")
+	}
+	return driveConnector{auth: "Bearer " + token, folder: folder}, nil
+}
+
+func (c driveConnector) Changed(since time.Time) ([]KBDocument, error) {
+	query := "trashed = false and (mimeType = 'application/vnd.google-apps.document' or mimeType = 'text/plain' or mimeType = 'text/markdown')"
+	if c.folder != "" {
+		query += fmt.Sprintf(" and '%s' in parents", c.folder)
+	}
+	if !since.IsZero() {
+		query += fmt.Sprintf(" and modifiedTime > '%s'", since.UTC().Format(time.RFC3339))
+	}
+
+	var docs []KBDocument
+	pageToken := ""
+	for {
+		u := "https://www.googleapis.com/drive/v3/files?pageSize=100&fields=nextPageToken,files(id,name,mimeType,modifiedTime,webViewLink)&q=" + url.QueryEscape(query)
+		if pageToken != "" {
+			u += "&pageToken=" + url.QueryEscape(pageToken)
+		}
+		var page struct {
+			Files []struct {
+				ID           string    `json:"id"`
+				Name         string    `json:"name"`
+				MimeType     string    `json:"mimeType"`
+				ModifiedTime time.Time `json:"modifiedTime"`
+				WebViewLink  string    `json:"webViewLink"`
+			} `json:"files"`
+			NextPageToken string `json:"nextPageToken"`
+		}
+		if err := kbRequest("GET", u, c.auth, nil, nil, &page); err != nil {
+			return nil, err
+		}
+		for _, f := range page.Files {
+			download := fmt.Sprintf("https://www.googleapis.com/drive/v3/files/%s?alt=media", f.ID)
+			if f.MimeType == "application/vnd.google-apps.document" {
+				download = fmt.Sprintf("https://www.googleapis.com/drive/v3/files/%s/export?mimeType=text/plain", f.ID)
+			}
+			var content string
+			if err := kbRequest("GET", download, c.auth, nil, nil, &content); err != nil {
+				return nil, err
+			}
+			docs = append(docs, KBDocument{ID: f.ID, Title: f.Name, URL: f.WebViewLink, Content: content, Modified: f.ModifiedTime})
+		}
+		if page.NextPageToken == "" {
+			return docs, nil
+		}
+		pageToken = page.NextPageToken
+	}
+}
+
+// repoConnector indexes the source files of a local checkout, skipping
+// dependency and build directories.
+type repoConnector struct{ dir string }
+
+// repoSkipDirs are directories that hold generated or vendored code.
+var repoSkipDirs = map[string]bool{
+	".git": true, "node_modules": true, "vendor": true, "dist": true, "build": true,
+	"target": true, "__pycache__": true, ".venv": true, "venv": true,
+}
+
+// repoMaxFileSize leaves out generated files and data too large to be worth indexing.
+const repoMaxFileSize = 512 * 1024
+
+func newRepoConnector(dir string) (KBConnector, error) {
+	if dir == "" {
+		return nil, fmt.Errorf("repo needs a path")
+	}
+	if strings.HasPrefix(dir, "~/") {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return nil, err
+		}
+		dir = filepath.Join(home, dir[2:])
+	}
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return nil, err
+	}
+	info, err := os.Stat(dir)
+	if err != nil {
+		return nil, err
+	}
+	if !info.IsDir() {
+		return nil, fmt.Errorf("%s is not a directory", dir)
+	}
+	return repoConnector{dir: dir}, nil
+}
+
+func (c repoConnector) Changed(since time.Time) ([]KBDocument, error) {
+	var docs []KBDocument
+	err := filepath.WalkDir(c.dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			if path != c.dir && (repoSkipDirs[d.Name()] || strings.HasPrefix(d.Name(), ".")) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		language := CodeLanguage(path)
+		if language == "" {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil || info.Size() > repoMaxFileSize || !info.ModTime().After(since) {
+			return err
+		}
+		content, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		rel, _ := filepath.Rel(c.dir, path)
+		rel = filepath.ToSlash(rel)
+		docs = append(docs, KBDocument{ID: rel, Title: rel, URL: "file://" + filepath.ToSlash(path), Content: string(content), Modified: info.ModTime(), Language: language})
+		return nil
+	})
+	return docs, err
+}
@@ -0,0 +1,310 @@
+package utils
+
+import (
+	"regexp"
+	"strings"
+	"unicode/utf8"
+)
+
+var (
+	displayMathPattern = regexp.MustCompile(`(?s)\$\$(.+?)\$\$|\\\[(.+?)\\\]`)
+	inlineMathPattern  = regexp.MustCompile(`\\\((.+?)\\\)|\$([^\s$](?:[^$\n]*[^\s$])?)\$`)
+	inlineCodePattern  = regexp.MustCompile("`[^`\n]+`")
+)
+
+var latexSymbols = map[string]string{
+	// Greek
+	"alpha": "α", "beta": "β", "gamma": "γ", "delta": "δ", "epsilon": "ε", "varepsilon": "ε", "zeta": "ζ",
+	"eta": "η", "theta": "θ", "vartheta": "ϑ", "iota": "ι", "kappa": "κ", "lambda": "λ", "mu": "μ",
+	"nu": "ν", "xi": "ξ", "pi": "π", "varpi": "ϖ", "rho": "ρ", "sigma": "σ", "tau": "τ", "upsilon": "υ",
+	"phi": "φ", "varphi": "φ", "chi": "χ", "psi": "ψ", "omega": "ω",
+	"Gamma": "Γ", "Delta": "Δ", "Theta": "Θ", "Lambda": "Λ", "Xi": "Ξ", "Pi": "Π", "Sigma": "Σ",
+	"Upsilon": "Υ", "Phi": "Φ", "Psi": "Ψ", "Omega": "Ω",
+	// Operators and relations
+	"times": "×", "cdot": "·", "div": "÷", "pm": "±", "mp": "∓", "ast": "∗", "circ": "∘", "bullet": "•",
+	"leq": "≤", "le": "≤", "geq": "≥", "ge": "≥", "neq": "≠", "ne": "≠", "approx": "≈", "equiv": "≡",
+	"sim": "∼", "simeq": "≃", "cong": "≅", "propto": "∝", "ll": "≪", "gg": "≫", "mid": "|",
+	"parallel": "∥", "perp": "⊥", "angle": "∠", "degree": "°",
+	// Big operators and calculus
+	"sum": "∑", "prod": "∏", "int": "∫", "iint": "∬", "oint": "∮", "partial": "∂", "nabla": "∇",
+	"infty": "∞", "lim": "lim", "log": "log", "ln": "ln", "exp": "exp", "sin": "sin", "cos": "cos",
+	"tan": "tan", "max": "max", "min": "min", "det": "det",
+	// Arrows
+	"to": "→", "rightarrow": "→", "leftarrow": "←", "leftrightarrow": "↔", "Rightarrow": "⇒",
+	"Leftarrow": "⇐", "Leftrightarrow": "⇔", "implies": "⟹", "iff": "⟺", "mapsto": "↦",
+	"uparrow": "↑", "downarrow": "↓",
+	// Sets and logic
+	"in": "∈", "notin": "∉", "ni": "∋", "subset": "⊂", "subseteq": "⊆", "supset": "⊃", "supseteq": "⊇",
+	"cup": "∪", "cap": "∩", "setminus": "∖", "emptyset": "∅", "varnothing": "∅", "forall": "∀",
+	"exists": "∃", "neg": "¬", "lnot": "¬", "land": "∧", "wedge": "∧", "lor": "∨", "vee": "∨",
+	// Dots, spacing and delimiters
+	"ldots": "…", "cdots": "⋯", "vdots": "⋮", "dots": "…", "quad": "  ", "qquad": "    ",
+	"langle": "⟨", "rangle": "⟩", "lfloor": "⌊", "rfloor": "⌋", "lceil": "⌈", "rceil": "⌉",
+	"lvert": "|", "rvert": "|", "vert": "|", "Vert": "‖", "prime": "′", "hbar": "ℏ", "ell": "ℓ",
+}
+
+// Commands whose only effect is sizing or styling; their argument is kept as-is.
+var latexIgnored = map[string]bool{
+	"left": true, "right": true, "big": true, "Big": true, "bigl": true, "bigr": true, "Bigl": true,
+	"Bigr": true, "displaystyle": true, "limits": true, "nolimits": true,
+}
+
+var latexTextCommands = map[string]bool{
+	"text": true, "textrm": true, "textbf": true, "textit": true, "mathrm": true, "mathbf": true,
+	"mathit": true, "mathsf": true, "mathtt": true, "operatorname": true, "boldsymbol": true, "mbox": true,
+}
+
+var latexAccents = map[string]string{
+	"hat": "̂", "bar": "̄", "overline": "̅", "vec": "⃗", "dot": "̇",
+	"ddot": "̈", "tilde": "̃",
+}
+
+var superscripts = map[rune]rune{
+	'0': '⁰', '1': '¹', '2': '²', '3': '³', '4': '⁴', '5': '⁵', '6': '⁶', '7': '⁷', '8': '⁸', '9': '⁹',
+	'+': '⁺', '-': '⁻', '=': '⁼', '(': '⁽', ')': '⁾', 'n': 'ⁿ', 'i': 'ⁱ', 'a': 'ᵃ', 'b': 'ᵇ', 'c': 'ᶜ',
+	'd': 'ᵈ', 'e': 'ᵉ', 'f': 'ᶠ', 'g': 'ᵍ', 'h': 'ʰ', 'j': 'ʲ', 'k': 'ᵏ', 'l': 'ˡ', 'm': 'ᵐ', 'o': 'ᵒ',
+	'p': 'ᵖ', 'r': 'ʳ', 's': 'ˢ', 't': 'ᵗ', 'u': 'ᵘ', 'v': 'ᵛ', 'w': 'ʷ', 'x': 'ˣ', 'y': 'ʸ', 'z': 'ᶻ',
+	'T': 'ᵀ', '−': '⁻', '′': '′',
+}
+
+var subscripts = map[rune]rune{
+	'0': '₀', '1': '₁', '2': '₂', '3': '₃', '4': '₄', '5': '₅', '6': '₆', '7': '₇', '8': '₈', '9': '₉',
+	'+': '₊', '-': '₋', '=': '₌', '(': '₍', ')': '₎', 'a': 'ₐ', 'e': 'ₑ', 'h': 'ₕ', 'i': 'ᵢ', 'j': 'ⱼ',
+	'k': 'ₖ', 'l': 'ₗ', 'm': 'ₘ', 'n': 'ₙ', 'o': 'ₒ', 'p': 'ₚ', 'r': 'ᵣ', 's': 'ₛ', 't': 'ₜ', 'u': 'ᵤ',
+	'v': 'ᵥ', 'x': 'ₓ',
+}
+
+var doubleStruck = map[rune]string{
+	'R': "ℝ", 'N': "ℕ", 'Z': "ℤ", 'Q': "ℚ", 'C': "ℂ", 'P': "ℙ", 'H': "ℍ",
+}
+
+// RenderLaTeX replaces LaTeX math in a markdown answer ($...$, $$...$$, \(...\)
+// and \[...\]) with a Unicode approximation that reads well in a terminal.
+// Code blocks and inline code are left untouched.
+func RenderLaTeX(markdown string) string {
+	parts := strings.Split(markdown, "```")
+	for i := range parts {
+		if i%2 == 1 {
+			continue // inside a fenced code block
+		}
+		parts[i] = renderMathOutsideInlineCode(parts[i])
+	}
+	return strings.Join(parts, "```")
+}
+
+func renderMathOutsideInlineCode(text string) string {
+	var b strings.Builder
+	last := 0
+	for _, loc := range inlineCodePattern.FindAllStringIndex(text, -1) {
+		b.WriteString(renderMath(text[last:loc[0]]))
+		b.WriteString(text[loc[0]:loc[1]])
+		last = loc[1]
+	}
+	b.WriteString(renderMath(text[last:]))
+	return b.String()
+}
+
+func renderMath(text string) string {
+	text = displayMathPattern.ReplaceAllStringFunc(text, func(m string) string {
+		sub := displayMathPattern.FindStringSubmatch(m)
+		body := sub[1] + sub[2]
+		return "\n    " + strings.ReplaceAll(strings.TrimSpace(LaTeXToUnicode(body)), "\n", "\n    ") + "\n"
+	})
+	return inlineMathPattern.ReplaceAllStringFunc(text, func(m string) string {
+		sub := inlineMathPattern.FindStringSubmatch(m)
+		body := sub[1] + sub[2]
+		// "$5 and $10" is money, not math.
+		if sub[2] != "" && !looksLikeMath(body) {
+			return m
+		}
+		return LaTeXToUnicode(body)
+	})
+}
+
+func looksLikeMath(s string) bool {
+	if strings.ContainsAny(s, `\^_`) {
+		return true
+	}
+	// Single-letter variables such as $x$ or $n$.
+	return utf8.RuneCountInString(s) <= 2 && strings.IndexFunc(s, func(r rune) bool {
+		return (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z')
+	}) == 0
+}
+
+// LaTeXToUnicode converts the body of a math expression to Unicode text.
+func LaTeXToUnicode(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); {
+		switch c := s[i]; c {
+		case '\\':
+			name, next := readLaTeXCommand(s, i+1)
+			i = next
+			b.WriteString(renderLaTeXCommand(name, s, &i))
+		case '^', '_':
+			arg, next := readLaTeXArg(s, i+1)
+			i = next
+			table := superscripts
+			if c == '_' {
+				table = subscripts
+			}
+			b.WriteString(script(LaTeXToUnicode(arg), table, string(c)))
+		case '{', '}':
+			i++
+		case '&':
+			b.WriteString(" ")
+			i++
+		case '~':
+			b.WriteString(" ")
+			i++
+		default:
+			b.WriteByte(c)
+			i++
+		}
+	}
+	return b.String()
+}
+
+func renderLaTeXCommand(name string, s string, i *int) string {
+	switch {
+	case name == "frac" || name == "dfrac" || name == "tfrac":
+		num, next := readLaTeXArg(s, *i)
+		den, next := readLaTeXArg(s, next)
+		*i = next
+		return fraction(LaTeXToUnicode(num), LaTeXToUnicode(den))
+	case name == "sqrt":
+		index := ""
+		if *i < len(s) && s[*i] == '[' {
+			if end := strings.IndexByte(s[*i:], ']'); end > 0 {
+				index = s[*i+1 : *i+end]
+				*i += end + 1
+			}
+		}
+		arg, next := readLaTeXArg(s, *i)
+		*i = next
+		root := "√"
+		switch index {
+		case "3":
+			root = "∛"
+		case "4":
+			root = "∜"
+		case "":
+		default:
+			root = script(index, superscripts, "^") + "√"
+		}
+		return root + wrap(LaTeXToUnicode(arg))
+	case latexTextCommands[name]:
+		arg, next := readLaTeXArg(s, *i)
+		*i = next
+		if strings.HasPrefix(name, "text") || name == "mbox" {
+			return arg
+		}
+		return LaTeXToUnicode(arg)
+	case name == "mathbb":
+		arg, next := readLaTeXArg(s, *i)
+		*i = next
+		var b strings.Builder
+		for _, r := range arg {
+			if ds, ok := doubleStruck[r]; ok {
+				b.WriteString(ds)
+			} else {
+				b.WriteRune(r)
+			}
+		}
+		return b.String()
+	case latexAccents[name] != "":
+		arg, next := readLaTeXArg(s, *i)
+		*i = next
+		return LaTeXToUnicode(arg) + latexAccents[name]
+	case name == "begin" || name == "end":
+		_, next := readLaTeXArg(s, *i)
+		*i = next
+		return ""
+	case latexIgnored[name]:
+		return ""
+	case name == "\\":
+		return "\n"
+	case name == "," || name == ";" || name == ":" || name == " ":
+		return " "
+	case name == "!":
+		return ""
+	}
+	if sym, ok := latexSymbols[name]; ok {
+		return sym
+	}
+	// Escaped characters such as \{ \} \% \$ and unknown commands keep their text.
+	return name
+}
+
+// readLaTeXCommand reads a command name starting at i: a run of letters or a single other character.
+func readLaTeXCommand(s string, i int) (string, int) {
+	start := i
+	for i < len(s) && ((s[i] >= 'a' && s[i] <= 'z') || (s[i] >= 'A' && s[i] <= 'Z')) {
+		i++
+	}
+	if i == start && i < len(s) {
+		return s[i : i+1], i + 1
+	}
+	return s[start:i], i
+}
+
+// readLaTeXArg reads a braced group, a command or a single character starting at i.
+func readLaTeXArg(s string, i int) (string, int) {
+	for i < len(s) && s[i] == ' ' {
+		i++
+	}
+	if i >= len(s) {
+		return "", i
+	}
+	switch s[i] {
+	case '{':
+		depth := 0
+		for j := i; j < len(s); j++ {
+			switch s[j] {
+			case '{':
+				depth++
+			case '}':
+				depth--
+				if depth == 0 {
+					return s[i+1 : j], j + 1
+				}
+			}
+		}
+		return s[i+1:], len(s)
+	case '\\':
+		_, next := readLaTeXCommand(s, i+1)
+		return s[i:next], next
+	default:
+		_, size := utf8.DecodeRuneInString(s[i:])
+		return s[i : i+size], i + size
+	}
+}
+
+// script renders text as super/subscript characters, falling back to
+// marker(text) when a character has no Unicode equivalent.
+func script(text string, table map[rune]rune, marker string) string {
+	var b strings.Builder
+	for _, r := range text {
+		mapped, ok := table[r]
+		if !ok {
+			if utf8.RuneCountInString(text) == 1 {
+				return marker + text
+			}
+			return marker + "(" + text + ")"
+		}
+		b.WriteRune(mapped)
+	}
+	return b.String()
+}
+
+func fraction(num, den string) string {
+	return wrap(num) + "/" + wrap(den)
+}
+
+// wrap parenthesizes anything but a number or single symbol so a/b and √x stay unambiguous.
+func wrap(s string) string {
+	s = strings.TrimSpace(s)
+	if utf8.RuneCountInString(s) <= 1 || strings.Trim(s, "0123456789.") == "" {
+		return s
+	}
+	return "(" + s + ")"
+}
@@ -0,0 +1,460 @@
+package utils
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"slices"
+	"strings"
+	"sync"
+	"unicode"
+	"unicode/utf8"
+)
+
+// LineEditor reads chat input from the terminal with line editing: the
+// arrow keys move through the text and recall earlier questions, and Ctrl+R
+// searches them. Enter starts a new line; Ctrl+D, or EOF alone on a line,
+// finishes the input.
+type LineEditor struct {
+	history []string
+
+	lines  [][]rune
+	row    int // cursor line
+	col    int // cursor column in runes
+	cols   int // terminal width
+	drawn  int // terminal row of the cursor, from the top of the input
+	recall int // history entry shown, len(history) for the draft
+	draft  [][]rune
+
+	searching bool
+	query     string
+	match     int // history entry found by the search, -1 for none
+}
+
+// ttyState is the stty setting to restore, while the editor has the
+// terminal in non-canonical mode.
+var ttyState struct {
+	sync.Mutex
+	saved string
+}
+
+// NewLineEditor returns an editor, or nil when stdin or stdout is not a
+// terminal that stty can configure.
+func NewLineEditor() *LineEditor {
+	if !IsTerminal(os.Stdin) || !IsTerminal(os.Stdout) || os.Getenv("TERM") == "dumb" {
+		return nil
+	}
+	if _, err := stty("-g"); err != nil {
+		return nil
+	}
+	return &LineEditor{}
+}
+
+// AddHistory records an input for recall, skipping blanks and repeats of the
+// last entry.
+func (e *LineEditor) AddHistory(input string) {
+	input = strings.TrimSpace(input)
+	if input == "" || (len(e.history) > 0 && e.history[len(e.history)-1] == input) {
+		return
+	}
+	e.history = append(e.history, input)
+}
+
+// stty runs stty on the controlling terminal and returns its output.
+func stty(args ...string) (string, error) {
+	tty, err := os.Open("/dev/tty")
+	if err != nil {
+		return "", err
+	}
+	defer tty.Close()
+	cmd := exec.Command("stty", args...)
+	cmd.Stdin = tty
+	out, err := cmd.Output()
+	return strings.TrimSpace(string(out)), err
+}
+
+// RestoreTerminal puts the terminal back the way the line editor found it.
+// It is safe to call at any time, so exit paths can always call it.
+func RestoreTerminal() {
+	ttyState.Lock()
+	defer ttyState.Unlock()
+	if ttyState.saved != "" {
+		stty(ttyState.saved)
+		ttyState.saved = ""
+	}
+}
+
+// ReadInput reads one input. It returns "" with no error when Ctrl+D is
+// pressed on empty input.
+func (e *LineEditor) ReadInput() (string, error) {
+	fmt.Println("(Enter your text. Type EOF on a new line or press Ctrl+D to finish; ↑/↓ recall, Ctrl+R searches)")
+	saved, err := stty("-g")
+	if err != nil {
+		return "", err
+	}
+	ttyState.Lock()
+	ttyState.saved = saved
+	ttyState.Unlock()
+	// Ctrl+C still raises SIGINT; only line buffering and echo are off.
+	if _, err := stty("-icanon", "-echo", "min", "1", "time", "0"); err != nil {
+		RestoreTerminal()
+		return "", err
+	}
+	defer RestoreTerminal()
+
+	e.lines = [][]rune{{}}
+	e.row, e.col, e.drawn = 0, 0, 0
+	e.recall, e.draft = len(e.history), nil
+	e.searching = false
+	e.cols = 80
+	if _, cols := terminalSize(); cols > 0 {
+		e.cols = cols
+	}
+
+	in := make([]byte, 1)
+	var pending []byte
+	for {
+		if _, err := os.Stdin.Read(in); err != nil {
+			if err == io.EOF {
+				return e.finish(), nil
+			}
+			return "", err
+		}
+		b := in[0]
+		if len(pending) > 0 || b >= utf8.RuneSelf {
+			// Collect the bytes of a multi-byte character.
+			pending = append(pending, b)
+			if !utf8.FullRune(pending) {
+				continue
+			}
+			r, _ := utf8.DecodeRune(pending)
+			pending = pending[:0]
+			e.insert(r)
+		} else if done := e.key(b); done {
+			return e.finish(), nil
+		}
+		e.render()
+	}
+}
+
+// finish moves below the input and returns its text without a final EOF line.
+func (e *LineEditor) finish() string {
+	e.searching = false
+	e.render()
+	fmt.Print("\n")
+	var text []string
+	for _, line := range e.lines {
+		text = append(text, string(line))
+	}
+	if n := len(text); n > 0 && strings.TrimSpace(text[n-1]) == "EOF" {
+		text = text[:n-1]
+	}
+	return strings.Join(text, "\n")
+}
+
+// key handles one ASCII byte and reports whether the input is finished.
+func (e *LineEditor) key(b byte) bool {
+	if e.searching {
+		return e.searchKey(b)
+	}
+	switch b {
+	case 4: // Ctrl+D
+		return true
+	case '\r', '\n':
+		if strings.TrimSpace(string(e.lines[e.row])) == "EOF" && e.row == len(e.lines)-1 {
+			return true
+		}
+		line := e.lines[e.row]
+		rest := slices.Clone(line[e.col:])
+		e.lines[e.row] = line[:e.col]
+		e.lines = slices.Insert(e.lines, e.row+1, rest)
+		e.row, e.col = e.row+1, 0
+	case 127, 8: // Backspace
+		switch {
+		case e.col > 0:
+			e.lines[e.row] = slices.Delete(e.lines[e.row], e.col-1, e.col)
+			e.col--
+		case e.row > 0:
+			e.col = len(e.lines[e.row-1])
+			e.lines[e.row-1] = append(e.lines[e.row-1], e.lines[e.row]...)
+			e.lines = slices.Delete(e.lines, e.row, e.row+1)
+			e.row--
+		}
+	case 1: // Ctrl+A
+		e.col = 0
+	case 5: // Ctrl+E
+		e.col = len(e.lines[e.row])
+	case 2: // Ctrl+B
+		e.left()
+	case 6: // Ctrl+F
+		e.right()
+	case 11: // Ctrl+K
+		e.lines[e.row] = e.lines[e.row][:e.col]
+	case 21: // Ctrl+U
+		e.lines[e.row] = slices.Clone(e.lines[e.row][e.col:])
+		e.col = 0
+	case 23: // Ctrl+W
+		start := e.col
+		for start > 0 && unicode.IsSpace(e.lines[e.row][start-1]) {
+			start--
+		}
+		for start > 0 && !unicode.IsSpace(e.lines[e.row][start-1]) {
+			start--
+		}
+		e.lines[e.row] = slices.Delete(e.lines[e.row], start, e.col)
+		e.col = start
+	case 16: // Ctrl+P
+		e.up()
+	case 14: // Ctrl+N
+		e.down()
+	case 18: // Ctrl+R
+		e.searching, e.query, e.match = true, "", -1
+	case '\t':
+		for range 4 {
+			e.insert(' ')
+		}
+	case 27:
+		e.escape()
+	default:
+		if b >= ' ' {
+			e.insert(rune(b))
+		}
+	}
+	return false
+}
+
+// escape reads and handles the rest of an escape sequence.
+func (e *LineEditor) escape() {
+	seq := e.readSequence()
+	switch seq {
+	case "[A", "OA":
+		e.up()
+	case "[B", "OB":
+		e.down()
+	case "[C", "OC":
+		e.right()
+	case "[D", "OD":
+		e.left()
+	case "[H", "OH", "[1~", "[7~":
+		e.col = 0
+	case "[F", "OF", "[4~", "[8~":
+		e.col = len(e.lines[e.row])
+	case "[3~": // Delete
+		switch {
+		case e.col < len(e.lines[e.row]):
+			e.lines[e.row] = slices.Delete(e.lines[e.row], e.col, e.col+1)
+		case e.row < len(e.lines)-1:
+			e.lines[e.row] = append(e.lines[e.row], e.lines[e.row+1]...)
+			e.lines = slices.Delete(e.lines, e.row+1, e.row+2)
+		}
+	}
+}
+
+// readSequence reads the bytes after ESC up to the end of a CSI or SS3
+// sequence, such as "[A" or "[3~".
+func (e *LineEditor) readSequence() string {
+	var seq bytes.Buffer
+	in := make([]byte, 1)
+	for {
+		if _, err := os.Stdin.Read(in); err != nil {
+			return seq.String()
+		}
+		seq.WriteByte(in[0])
+		switch {
+		case seq.Len() == 1 && in[0] != '[' && in[0] != 'O':
+			return seq.String() // Alt+key, ignored
+		case seq.Len() == 2 && seq.Bytes()[0] == 'O':
+			return seq.String()
+		case seq.Len() > 1 && in[0] >= 0x40 && in[0] <= 0x7e:
+			return seq.String()
+		case seq.Len() > 16:
+			return seq.String()
+		}
+	}
+}
+
+func (e *LineEditor) insert(r rune) {
+	if e.searching {
+		e.query += string(r)
+		e.search(len(e.history) - 1)
+		return
+	}
+	e.lines[e.row] = slices.Insert(e.lines[e.row], e.col, r)
+	e.col++
+}
+
+func (e *LineEditor) left() {
+	switch {
+	case e.col > 0:
+		e.col--
+	case e.row > 0:
+		e.row--
+		e.col = len(e.lines[e.row])
+	}
+}
+
+func (e *LineEditor) right() {
+	switch {
+	case e.col < len(e.lines[e.row]):
+		e.col++
+	case e.row < len(e.lines)-1:
+		e.row, e.col = e.row+1, 0
+	}
+}
+
+// up moves to the line above, or recalls the previous question from the
+// first line.
+func (e *LineEditor) up() {
+	if e.row > 0 {
+		e.row--
+		e.col = min(e.col, len(e.lines[e.row]))
+		return
+	}
+	if e.recall > 0 {
+		if e.recall == len(e.history) {
+			e.draft = e.lines
+		}
+		e.recall--
+		e.show(e.history[e.recall])
+	}
+}
+
+// down moves to the line below, or recalls the next question (and finally
+// the draft) from the last line.
+func (e *LineEditor) down() {
+	if e.row < len(e.lines)-1 {
+		e.row++
+		e.col = min(e.col, len(e.lines[e.row]))
+		return
+	}
+	switch {
+	case e.recall < len(e.history)-1:
+		e.recall++
+		e.show(e.history[e.recall])
+	case e.recall == len(e.history)-1:
+		e.recall++
+		e.lines = e.draft
+		e.row = len(e.lines) - 1
+		e.col = len(e.lines[e.row])
+	}
+}
+
+// show replaces the input with text and puts the cursor at its end.
+func (e *LineEditor) show(text string) {
+	e.lines = nil
+	for line := range strings.SplitSeq(text, "\n") {
+		e.lines = append(e.lines, []rune(line))
+	}
+	e.row = len(e.lines) - 1
+	e.col = len(e.lines[e.row])
+}
+
+// searchKey handles a byte during Ctrl+R search and reports whether the
+// input is finished.
+func (e *LineEditor) searchKey(b byte) bool {
+	switch b {
+	case 18: // Ctrl+R: the next older match
+		if e.match > 0 {
+			e.search(e.match - 1)
+		}
+	case 127, 8:
+		if e.query != "" {
+			_, size := utf8.DecodeLastRuneInString(e.query)
+			e.query = e.query[:len(e.query)-size]
+			e.search(len(e.history) - 1)
+		}
+	case 7: // Ctrl+G cancels
+		e.searching = false
+	case 4:
+		e.accept()
+		return true
+	case 27:
+		e.accept()
+		e.escape()
+	default:
+		if b >= ' ' {
+			e.insert(rune(b))
+			return false
+		}
+		e.accept()
+		if b != '\r' && b != '\n' {
+			return e.key(b)
+		}
+	}
+	return false
+}
+
+// search finds the newest history entry, from index from down, containing
+// the query.
+func (e *LineEditor) search(from int) {
+	for i := min(from, len(e.history)-1); i >= 0; i-- {
+		if strings.Contains(strings.ToLower(e.history[i]), strings.ToLower(e.query)) {
+			e.match = i
+			return
+		}
+	}
+	e.match = -1
+}
+
+// accept ends the search, putting the match in the input to edit.
+func (e *LineEditor) accept() {
+	e.searching = false
+	if e.match >= 0 {
+		e.recall = e.match
+		e.show(e.history[e.match])
+	}
+}
+
+// rowsOf is the number of terminal rows a line of width runes takes.
+func (e *LineEditor) rowsOf(width int) int {
+	return width/e.cols + 1
+}
+
+// render redraws the input and places the cursor.
+func (e *LineEditor) render() {
+	var b strings.Builder
+	if e.drawn > 0 {
+		fmt.Fprintf(&b, "\x1b[%dA", e.drawn)
+	}
+	b.WriteString("\r\x1b[J")
+
+	lines := e.lines
+	row, col := e.row, e.col
+	if e.searching {
+		status := fmt.Sprintf("(reverse-i-search)`%s': ", e.query)
+		if e.match >= 0 {
+			status += strings.ReplaceAll(e.history[e.match], "\n", " ⏎ ")
+		} else if e.query != "" {
+			status = "(failed " + status[1:]
+		}
+		lines = append(slices.Clone(lines), []rune(status))
+		row, col = len(lines)-1, len([]rune(status))
+	}
+
+	total, target := 0, 0
+	for i, line := range lines {
+		if i > 0 {
+			b.WriteString("\n")
+		}
+		b.WriteString(string(line))
+		if len(line) > 0 && len(line)%e.cols == 0 {
+			// Move past the pending wrap so every line takes rowsOf rows.
+			b.WriteString(" \b\x1b[K")
+		}
+		if i == row {
+			target = total + col/e.cols
+		}
+		total += e.rowsOf(len(line))
+	}
+	if up := total - 1 - target; up > 0 {
+		fmt.Fprintf(&b, "\x1b[%dA", up)
+	}
+	b.WriteString("\r")
+	if c := col % e.cols; c > 0 {
+		fmt.Fprintf(&b, "\x1b[%dC", c)
+	}
+	e.drawn = target
+	fmt.Print(b.String())
+}
@@ -1,17 +1,14 @@
 package utils
 
 import (
-	"bytes"
-	"encoding/base64"
-	"encoding/json"
+	"context"
+	"errors"
 	"fmt"
-	"io"
+	"io/fs"
 	"log"
-	"net/http"
 	"os"
 	"path/filepath"
 	"strings"
-	"time"
 )
 
 // LLMConfig holds configuration for LLM calls
@@ -19,26 +16,36 @@ type LLMConfig struct {
 	Model       string  `json:"model"`
 	Temperature float64 `json:"temperature"`
 	MaxTokens   int     `json:"max_tokens,omitempty"`
+	// Priority decides who waits when requests share a quota (see DefaultScheduler).
+	Priority Priority `json:"-"`
+	// Format is the kind of reply wanted; each provider asks for it its own way.
+	Format ResponseFormat `json:"-"`
+	// Schema optionally constrains FormatJSON replies to a JSON Schema.
+	Schema map[string]any `json:"-"`
+	// History holds earlier turns, sent as native user and model messages
+	// (with their images) before the prompt instead of as text in it.
+	History []Conversation `json:"-"`
+	// System is the conversation's system prompt (its "context"), sent after
+	// the system instructions file as the provider's system instruction.
+	System string `json:"-"`
+	// Node names the flow node making the call, whose persona (see Personas)
+	// the provider layer applies.
+	Node string `json:"-"`
+	// Tools are declared to the model, which may call them instead of
+	// answering; each call is run with RunTool and its result sent back
+	// until the model answers (at most MaxToolRounds times).
+	Tools   []ToolSpec `json:"-"`
+	RunTool ToolRunner `json:"-"`
 }
 
-type GroundingChunk struct {
-	Web struct {
-		URI   string `json:"uri"`
-		Title string `json:"title"`
-	} `json:"web"`
-}
-
-type GroundingMetadata struct {
-	GroundingChunks []GroundingChunk `json:"groundingChunks"`
-}
+// ResponseFormat is the kind of reply requested from the model.
+type ResponseFormat string
 
-func getGEMINIAPIKey() (string, error) {
-	apiKey := os.Getenv("GEMINI_API_KEY")
-	if apiKey == "" {
-		return "", fmt.Errorf("GEMINI_API_KEY environment variable not set")
-	}
-	return apiKey, nil
-}
+const (
+	FormatMarkdown ResponseFormat = "markdown"
+	FormatJSON     ResponseFormat = "json"
+	FormatText     ResponseFormat = "text"
+)
 
 // DefaultLLMConfig returns default configuration for Gemini
 func DefaultLLMConfig() *LLMConfig {
@@ -52,8 +59,9 @@ func DefaultLLMConfig() *LLMConfig {
 
 	return &LLMConfig{
 		Model:       model,
-		Temperature: 0.7,
+		Temperature: DefaultTemperature,
 		MaxTokens:   0, // Use model default
+		Format:      FormatMarkdown,
 	}
 }
 
@@ -61,6 +69,12 @@ func DefaultLLMConfig() *LLMConfig {
 // It can be set by the application (for example in `main.go`) after parsing flags.
 var DefaultModel string
 
+// DefaultTemperature is the temperature of default configs (-temperature).
+var DefaultTemperature = 0.7
+
+// FollowUpModel is the cheap model used to suggest follow-up questions after an answer.
+var FollowUpModel = "gemini-2.5-flash-lite"
+
 // Default path to system instructions (can be overridden with SYSTEM_INSTRUCTIONS_PATH).
 const defaultSystemInstructionsPath = "config/system_instructions.md"
 
@@ -73,266 +87,142 @@ func loadSystemInstructions() string {
 		path = defaultSystemInstructionsPath
 	}
 
-	data, err := os.ReadFile(path)
-	if err != nil {
+	// The project context is sent even without system instructions, e.g.
+	// when run in another project that has an AI.md.
+	var text string
+	if data, err := os.ReadFile(path); err != nil {
 		// Non-fatal: log once and proceed without system instructions
 		log.Printf("system instructions not loaded from %s: %v", path, err)
-		return ""
+	} else {
+		text = strings.TrimSpace(string(data))
+	}
+	if ProjectContext != "" {
+		text = strings.TrimSpace(text + "\n\n" + ProjectContext)
 	}
-	text := strings.TrimSpace(string(data))
 	return text
 }
 
-// CallLLM calls the Gemini API with the given prompt
-func CallLLM(prompt string) (string, error) {
-	return CallLLMWithConfig(prompt, DefaultLLMConfig(), false) // 'false' for useSearch
-}
+// ProjectContextFiles are the project instruction files LoadProjectContext looks for, in order.
+var ProjectContextFiles = []string{"AI.md", ".ai_context"}
 
-func CallLLMWithSearch(prompt string) (string, error) {
-	return CallLLMWithConfig(prompt, DefaultLLMConfig(), true) // 'true' for useSearch
-}
-
-func CallLLMWithConfig(prompt string, config *LLMConfig, useSearch bool) (string, error) {
-	var builder strings.Builder
-	builder.WriteString(prompt)
-	builder.WriteString("\n always answer using markdown format.")
-	prompt = builder.String()
+// ProjectContext holds the project's instructions, sent after the system
+// instructions in every request.
+var ProjectContext string
 
-	apiKey, err := getGEMINIAPIKey()
-	if err != nil {
-		return "", err
-	}
-
-	// Prepare request body for Gemini API
-	// Try to attach system instructions if present.
-	sys := loadSystemInstructions()
-	requestBody := map[string]any{
-		"contents": []map[string]any{
-			{
-				"role": "user",
-				"parts": []map[string]string{
-					{"text": prompt},
-				},
-			},
-		},
-		"generationConfig": map[string]any{
-			"temperature": config.Temperature,
-		},
-	}
+// maxProjectContextChars keeps a large project file from crowding out the conversation.
+const maxProjectContextChars = 20000
 
-	if sys != "" {
-		// Gemini supports a top-level systemInstruction field containing parts.
-		requestBody["systemInstruction"] = map[string]any{
-			"parts": []map[string]string{
-				{"text": sys},
-			},
+// LoadProjectContext reads the first of ProjectContextFiles found in dir into
+// ProjectContext and returns its path, or "" when dir has none.
+func LoadProjectContext(dir string) (string, error) {
+	for _, name := range ProjectContextFiles {
+		path := filepath.Join(dir, name)
+		data, err := os.ReadFile(path)
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
 		}
-	}
-
-	// THE KEY CHANGE: If useSearch is true, add the "tools" section to the request
-	if useSearch {
-		requestBody["tools"] = []map[string]any{
-			{
-				"google_search": map[string]any{}, // This enables the tool
-			},
+		if err != nil {
+			return "", fmt.Errorf("failed to read project context: %w", err)
 		}
-	}
-
-	if config.MaxTokens > 0 {
-		genConfig := requestBody["generationConfig"].(map[string]any)
-		genConfig["maxOutputTokens"] = config.MaxTokens
-	}
-
-	jsonData, err := json.Marshal(requestBody)
-	if err != nil {
-		return "", fmt.Errorf("failed to marshal request: %w", err)
-	}
-
-	url := fmt.Sprintf("https://generativelanguage.googleapis.com/v1beta/models/%s:generateContent?key=%s", config.Model, apiKey)
-	req, err := http.NewRequest("POST", url, bytes.NewBuffer(jsonData))
-	if err != nil {
-		return "", fmt.Errorf("failed to create request: %w", err)
-	}
-
-	req.Header.Set("Content-Type", "application/json")
-
-	client := &http.Client{
-		Timeout: 60 * time.Second, // Increased timeout for potential search
-	}
-
-	resp, err := client.Do(req)
-	if err != nil {
-		return "", fmt.Errorf("failed to make request: %w", err)
-	}
-	defer resp.Body.Close()
-
-	body, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return "", fmt.Errorf("failed to read response: %w", err)
-	}
-
-	if resp.StatusCode != http.StatusOK {
-		return "", fmt.Errorf("API request failed with status %d: %s", resp.StatusCode, string(body))
-	}
-
-	var result struct {
-		Candidates []struct {
-			Content struct {
-				Parts []struct {
-					Text string `json:"text"`
-				} `json:"parts"`
-			} `json:"content"`
-			GroundingMetadata GroundingMetadata `json:"groundingMetadata"`
-		} `json:"candidates"`
-	}
-
-	if err := json.Unmarshal(body, &result); err != nil {
-		return "", fmt.Errorf("failed to parse response: %w", err)
-	}
-
-	if len(result.Candidates) == 0 || len(result.Candidates[0].Content.Parts) == 0 {
-		return "", fmt.Errorf("no response from API")
-	}
-
-	answerText := result.Candidates[0].Content.Parts[0].Text
-
-	if len(result.Candidates[0].GroundingMetadata.GroundingChunks) > 0 {
-		var builder strings.Builder
-		builder.WriteString(answerText) // Start with the answer
-		builder.WriteString("\n\n---\n**Sources:**\n")
-
-		// Loop through the sources and format them
-		for i, chunk := range result.Candidates[0].GroundingMetadata.GroundingChunks {
-			builder.WriteString(fmt.Sprintf("%d. %s (%s)\n", i+1, chunk.Web.Title, chunk.Web.URI))
+		text := strings.TrimSpace(string(data))
+		if len([]rune(text)) > maxProjectContextChars {
+			log.Printf("%s is longer than %d characters; only the start is used", path, maxProjectContextChars)
+			text = truncateRunes(text, maxProjectContextChars)
 		}
-		return builder.String(), nil
+		ProjectContext = fmt.Sprintf("Project instructions from %s:\n%s", name, text)
+		return path, nil
 	}
-	return answerText, nil
-
+	return "", nil
 }
 
-func CallLLMWithImages(prompt string, imagePaths []string) (string, error) {
-	apiKey, err := getGEMINIAPIKey()
-	if err != nil {
-		return "", err
-	}
-
-	config := DefaultLLMConfig()
-
-	// The key new logic starts here: we build a "parts" array containing
-	// the text and all the encoded images.
-	parts := []map[string]any{
-		{"text": prompt}, // Start with the text prompt
-	}
-
-	for _, path := range imagePaths {
-		// 1. Read the raw image file data
-		imageData, err := os.ReadFile(path)
-		if err != nil {
-			return "", fmt.Errorf("failed to read image file %s: %w", path, err)
-		}
-
-		// 2. Base64 encode the image data
-		encodedString := base64.StdEncoding.EncodeToString(imageData)
+// LLMProvider is a model backend. Implementations take a slot from
+// DefaultScheduler for each request, honouring config.Priority.
+type LLMProvider interface {
+	// Generate answers a text prompt, grounded in web search when useSearch is set.
+	Generate(ctx context.Context, prompt string, config *LLMConfig, useSearch bool) (string, error)
+	// GenerateWithImages answers a prompt about the images at imagePaths.
+	GenerateWithImages(ctx context.Context, prompt string, imagePaths []string, config *LLMConfig) (string, error)
+	// Stream answers a prompt, passing the reply to emit as StreamEvents
+	// as it arrives and ending with Done.
+	Stream(ctx context.Context, prompt string, config *LLMConfig, emit StreamHandler) error
+}
 
-		// 3. Determine the MIME type from the file extension
-		mimeType := ""
-		ext := strings.ToLower(filepath.Ext(path))
-		switch ext {
-		case ".jpg", ".jpeg":
-			mimeType = "image/jpeg"
-		case ".png":
-			mimeType = "image/png"
-		case ".webp":
-			mimeType = "image/webp"
-		case ".heic":
-			mimeType = "image/heic"
-		case ".heif":
-			mimeType = "image/heif"
-		default:
-			return "", fmt.Errorf("unsupported image type: %s", ext)
-		}
+// DefaultProvider is the backend behind the CallLLM functions.
+var DefaultProvider LLMProvider = GeminiProvider{}
 
-		// 4. Create the image part structure for the JSON request
-		imagePart := map[string]any{
-			"inline_data": map[string]any{
-				"mime_type": mimeType,
-				"data":      encodedString,
-			},
-		}
-		parts = append(parts, imagePart)
+// systemPrompt joins the system instructions, config.System and the
+// instruction for config.Format, for providers that have no native way to request it.
+func systemPrompt(config *LLMConfig) string {
+	var parts []string
+	if sys := loadSystemInstructions(); sys != "" {
+		parts = append(parts, sys)
 	}
-
-	// Now we build the final request body with our multi-part content
-	requestBody := map[string]any{
-		"contents": []map[string]any{
-			{
-				"role":  "user",
-				"parts": parts, // Use the parts array we just built
-			},
-		},
-		"generationConfig": map[string]any{
-			"temperature": config.Temperature,
-		},
+	if sys := strings.TrimSpace(config.System); sys != "" {
+		parts = append(parts, sys)
 	}
-	// ... (The rest of the function is standard HTTP request logic, similar to before) ...
-	jsonData, err := json.Marshal(requestBody)
-	if err != nil {
-		return "", fmt.Errorf("failed to marshal request: %w", err)
+	switch config.Format {
+	case FormatMarkdown:
+		parts = append(parts, "Always answer using Markdown formatting.")
+	case FormatJSON:
+		parts = append(parts, "Reply with only valid JSON, without code fences or commentary.")
 	}
+	return strings.Join(parts, "\n\n")
+}
 
-	url := fmt.Sprintf("https://generativelanguage.googleapis.com/v1beta/models/%s:generateContent?key=%s", config.Model, apiKey)
-	req, err := http.NewRequest("POST", url, bytes.NewBuffer(jsonData))
-	if err != nil {
-		return "", fmt.Errorf("failed to create request: %w", err)
-	}
-	req.Header.Set("Content-Type", "application/json")
-	client := &http.Client{Timeout: 90 * time.Second} // Increased timeout for image uploads
-
-	resp, err := client.Do(req)
-	if err != nil {
-		return "", fmt.Errorf("failed to make request: %w", err)
-	}
-	defer resp.Body.Close()
-
-	body, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return "", fmt.Errorf("failed to read response: %w", err)
-	}
+// CallLLM calls the default provider with the given prompt
+func CallLLM(ctx context.Context, prompt string) (string, error) {
+	return CallLLMWithConfig(ctx, prompt, DefaultLLMConfig(), false) // 'false' for useSearch
+}
 
-	if resp.StatusCode != http.StatusOK {
-		return "", fmt.Errorf("API request failed with status %d: %s", resp.StatusCode, string(body))
-	}
+func CallLLMWithSearch(ctx context.Context, prompt string) (string, error) {
+	return CallLLMWithConfig(ctx, prompt, DefaultLLMConfig(), true) // 'true' for useSearch
+}
 
-	var result struct {
-		Candidates []struct {
-			Content struct {
-				Parts []struct {
-					Text string `json:"text"`
-				} `json:"parts"`
-			} `json:"content"`
-		} `json:"candidates"`
-	}
-	if err := json.Unmarshal(body, &result); err != nil {
-		return "", fmt.Errorf("failed to parse response: %w", err)
-	}
+// CallLLMWithConfig calls the default provider with config. Identical calls
+// for the same usage account made while one is in flight share its reply
+// instead of calling again; calls offering Tools never do, since their tool
+// runs belong to the caller.
+func CallLLMWithConfig(ctx context.Context, prompt string, config *LLMConfig, useSearch bool) (string, error) {
+	config = withPersona(config)
+	key, ok := flightKey(ctx, prompt, config, useSearch)
+	if !ok || len(config.Tools) > 0 {
+		return DefaultProvider.Generate(ctx, prompt, config, useSearch)
+	}
+	return llmFlights.Do(ctx, key, func(ctx context.Context) (string, error) {
+		return DefaultProvider.Generate(ctx, prompt, config, useSearch)
+	})
+}
 
-	if len(result.Candidates) == 0 || len(result.Candidates[0].Content.Parts) == 0 {
-		return "", fmt.Errorf("no response from API")
-	}
+func CallLLMWithImages(ctx context.Context, prompt string, imagePaths []string) (string, error) {
+	return CallLLMWithImagesConfig(ctx, prompt, imagePaths, DefaultLLMConfig())
+}
 
-	return result.Candidates[0].Content.Parts[0].Text, nil
+// CallLLMWithImagesConfig sends images alongside a prompt using the given config.
+func CallLLMWithImagesConfig(ctx context.Context, prompt string, imagePaths []string, config *LLMConfig) (string, error) {
+	return DefaultProvider.GenerateWithImages(ctx, prompt, imagePaths, withPersona(config))
 }
 
-// CallLLMStreaming calls the Gemini API with streaming response
-// This is useful for long responses where you want to show progress
-func CallLLMStreaming(prompt string, onChunk func(string) error) error {
-	// Implementation would handle streaming responses (e.g., using server-sent events)
-	// For now, we'll use the regular call as in the original code
-	response, err := CallLLM(prompt)
-	if err != nil {
-		return err
-	}
+// CallLLMStreaming calls the default provider and passes the answer to
+// onChunk piece by piece as it is generated.
+func CallLLMStreaming(ctx context.Context, prompt string, onChunk func(string) error) error {
+	return CallLLMStreamEvents(ctx, prompt, DefaultLLMConfig(), TextOnly(onChunk))
+}
 
-	return onChunk(response)
+// CallLLMStreamEvents calls the default provider and passes every stream
+// event, not only the text, to emit.
+func CallLLMStreamEvents(ctx context.Context, prompt string, config *LLMConfig, emit StreamHandler) error {
+	config = withPersona(config)
+	// Usage updates are running totals; the last one counts.
+	var usage *UsageUpdate
+	defer func() {
+		if usage != nil {
+			RecordUsage(ctx, config.Model, usage.PromptTokens, usage.OutputTokens)
+		}
+	}()
+	return DefaultProvider.Stream(ctx, prompt, config, func(ev StreamEvent) error {
+		if u, ok := ev.(UsageUpdate); ok {
+			usage = &u
+		}
+		return emit(ev)
+	})
 }
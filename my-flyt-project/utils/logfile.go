@@ -0,0 +1,106 @@
+package utils
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// LogSection is a line-aligned byte range of a log file.
+type LogSection struct {
+	Offset int64
+	Size   int64
+}
+
+// SplitLogFile reads path as a stream and cuts it into line-aligned sections
+// of at most maxBytes, without holding the file in memory. A single line
+// longer than maxBytes is a section of its own; blank sections are skipped.
+func SplitLogFile(path string, maxBytes int64) ([]LogSection, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var sections []LogSection
+	var start, offset, lineStart int64
+	sectionBlank, lineBlank := true, true
+	endLine := func() {
+		if lineStart > start && offset-start > maxBytes {
+			if !sectionBlank {
+				sections = append(sections, LogSection{Offset: start, Size: lineStart - start})
+			}
+			start, sectionBlank = lineStart, true
+		}
+		sectionBlank = sectionBlank && lineBlank
+		lineStart, lineBlank = offset, true
+	}
+
+	r := bufio.NewReaderSize(f, 64<<10)
+	for {
+		fragment, err := r.ReadSlice('\n')
+		offset += int64(len(fragment))
+		for _, c := range fragment {
+			if c != ' ' && c != '\t' && c != '\r' && c != '\n' {
+				lineBlank = false
+				break
+			}
+		}
+		switch {
+		case err == nil:
+			endLine()
+		case errors.Is(err, bufio.ErrBufferFull):
+			// The line goes on in the next fragment.
+		case err == io.EOF:
+			if offset > lineStart {
+				endLine()
+			}
+			if offset > start && !sectionBlank {
+				sections = append(sections, LogSection{Offset: start, Size: offset - start})
+			}
+			return sections, nil
+		default:
+			return nil, fmt.Errorf("failed to read %s: %w", path, err)
+		}
+	}
+}
+
+// ReadLogSection returns the text of s in path, cut at maxBytes.
+func ReadLogSection(path string, s LogSection, maxBytes int64) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	size := min(s.Size, maxBytes)
+	b := make([]byte, size)
+	if n, err := f.ReadAt(b, s.Offset); n < len(b) {
+		return "", fmt.Errorf("failed to read %s: %w", path, err)
+	}
+	if size < s.Size {
+		return string(b) + fmt.Sprintf("\n[%d more bytes of this line cut]\n", s.Size-size), nil
+	}
+	return string(b), nil
+}
+
+// LogCachePath returns where the findings for text, a section of the log
+// file path, are cached for question. Keying by content keeps the findings
+// of unchanged sections valid when the log grows.
+func LogCachePath(path, question, text string) string {
+	cache, err := os.UserCacheDir()
+	if err != nil {
+		cache = os.TempDir()
+	}
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		abs = path
+	}
+	file := sha256.Sum256([]byte(abs))
+	key := sha256.Sum256([]byte(question + "\x00" + text))
+	return filepath.Join(cache, "ai_wraper", "logs", hex.EncodeToString(file[:])[:16], hex.EncodeToString(key[:])[:32]+".txt")
+}
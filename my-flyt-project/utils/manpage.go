@@ -0,0 +1,167 @@
+package utils
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// maxHelpChars bounds how much documentation is put into a prompt.
+const maxHelpChars = 24000
+
+var (
+	commandNamePattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._+-]*$`)
+	backtickPattern    = regexp.MustCompile("`([A-Za-z0-9][A-Za-z0-9._+-]*)[^`]*`")
+	// usagePatterns name a program explicitly, as "man rsync" or "the rsync
+	// command"; looser phrasings like "with X" match ordinary questions.
+	usagePatterns = []*regexp.Regexp{
+		regexp.MustCompile(`(?i)\bman\s+([A-Za-z0-9][A-Za-z0-9._+-]*)`),
+		regexp.MustCompile(`(?i)\bthe\s+([A-Za-z0-9][A-Za-z0-9._+-]*)\s+(?:command|program|tool|utility)\b`),
+	}
+	// Words that are also program names but almost always plain English in questions.
+	commonWords = map[string]bool{
+		"a": true, "an": true, "the": true, "it": true, "this": true, "that": true, "my": true,
+		"test": true, "time": true, "which": true, "yes": true, "more": true, "less": true,
+		"true": true, "false": true, "who": true, "what": true, "when": true, "where": true,
+		"help": true, "install": true, "file": true, "make": true, "go": true,
+	}
+	// Programs that may act on their own instead of printing usage; only
+	// their man page is read.
+	neverExecuted = map[string]bool{
+		"reboot": true, "shutdown": true, "halt": true, "poweroff": true, "init": true, "telinit": true,
+		"kill": true, "killall": true, "pkill": true, "dd": true, "yes": true,
+	}
+)
+
+// DetectCommandQuestion returns the installed program that a usage question
+// names in backticks or explicitly, e.g. "how do I use `rsync` to mirror a
+// folder" or "what does the rsync command do" → "rsync".
+func DetectCommandQuestion(question string) (string, bool) {
+	var candidates []string
+	for _, m := range backtickPattern.FindAllStringSubmatch(question, -1) {
+		candidates = append(candidates, m[1])
+	}
+	for _, re := range usagePatterns {
+		for _, m := range re.FindAllStringSubmatch(question, -1) {
+			candidates = append(candidates, m[1])
+		}
+	}
+
+	for _, c := range candidates {
+		if commonWords[strings.ToLower(c)] || !commandNamePattern.MatchString(c) {
+			continue
+		}
+		if _, err := exec.LookPath(c); err == nil {
+			return c, true
+		}
+	}
+	return "", false
+}
+
+// CommandHelp returns the man page of a locally installed program, so
+// answers match what is actually installed. The program itself is never
+// run: even --help can act on its own in some programs, and the name may
+// come from the model.
+func CommandHelp(name string) (string, error) {
+	return commandHelp(name, false)
+}
+
+// NamedCommandHelp is CommandHelp for a program the user named explicitly:
+// when it has no man page, its --help output is used instead. See runHelp
+// for how the program is run.
+func NamedCommandHelp(name string) (string, error) {
+	return commandHelp(name, true)
+}
+
+func commandHelp(name string, allowRun bool) (string, error) {
+	if !commandNamePattern.MatchString(name) {
+		return "", fmt.Errorf("invalid command name %q", name)
+	}
+	path, err := exec.LookPath(name)
+	if err != nil {
+		return "", fmt.Errorf("%s is not installed: %w", name, err)
+	}
+
+	doc := stripOverstrike(runMan("-P", "cat", name))
+	if strings.TrimSpace(doc) == "" && allowRun {
+		doc = runHelp(name, path)
+	}
+	if strings.TrimSpace(doc) == "" {
+		if allowRun {
+			return "", fmt.Errorf("no man page or --help output found for %s", name)
+		}
+		return "", fmt.Errorf("no man page found for %s", name)
+	}
+	if len(doc) > maxHelpChars {
+		doc = doc[:maxHelpChars] + "\n[documentation truncated]"
+	}
+	return doc, nil
+}
+
+// runMan runs man with a short timeout and returns its output, or "" if it
+// fails.
+func runMan(args ...string) string {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, "man", args...)
+	cmd.Env = append(os.Environ(), "MANWIDTH=100", "MANPAGER=cat", "PAGER=cat")
+	out, err := cmd.Output()
+	if err != nil {
+		return ""
+	}
+	return string(out)
+}
+
+// runHelp runs the program at path with --help, without a shell, and returns
+// its output, or "" if it printed nothing. The program gets no stdin, an
+// empty temporary directory to run in, none of the environment beyond PATH
+// and LANG, 5 seconds and 64 KiB of output. Programs in neverExecuted are
+// not run.
+func runHelp(name, path string) string {
+	if neverExecuted[name] || strings.HasPrefix(name, "mkfs") {
+		return ""
+	}
+	dir, err := os.MkdirTemp("", "ai-help-*")
+	if err != nil {
+		return ""
+	}
+	defer os.RemoveAll(dir)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, path, "--help")
+	cmd.Dir = dir
+	cmd.Env = []string{"PATH=" + os.Getenv("PATH"), "LANG=" + os.Getenv("LANG"), "HOME=" + dir, "TERM=dumb"}
+	cmd.WaitDelay = time.Second
+	var out cappedBuffer
+	cmd.Stdout, cmd.Stderr = &out, &out
+	// Many tools exit non-zero for --help but still print usage.
+	cmd.Run()
+	if ctx.Err() != nil {
+		return ""
+	}
+	return out.String()
+}
+
+// stripOverstrike removes the backspace sequences man uses for bold and underline.
+func stripOverstrike(s string) string {
+	if !strings.Contains(s, "\b") {
+		return s
+	}
+	var out []rune
+	for _, r := range s {
+		if r == '\b' {
+			if len(out) > 0 {
+				out = out[:len(out)-1]
+			}
+			continue
+		}
+		out = append(out, r)
+	}
+	return string(out)
+}
@@ -0,0 +1,202 @@
+package utils
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+var (
+	mdHeading  = regexp.MustCompile(`^(#{1,6})\s+(.*?)\s*#*\s*$`)
+	mdFence    = regexp.MustCompile("^\\s*(```|~~~)\\s*(\\S*)")
+	mdRule     = regexp.MustCompile(`^\s*(?:(?:-\s*){3,}|(?:\*\s*){3,}|(?:_\s*){3,})$`)
+	mdQuote    = regexp.MustCompile(`^\s*>\s?(.*)$`)
+	mdListItem = regexp.MustCompile(`^(\s*)([-*+]|\d+[.)])\s+(.*)$`)
+	mdTask     = regexp.MustCompile(`^\[([ xX])\]\s+`)
+	mdCodeSpan = regexp.MustCompile("`([^`]+)`")
+	mdLink     = regexp.MustCompile(`\[([^\]]+)\]\(([^)\s]+)\)`)
+	mdBold     = regexp.MustCompile(`\*\*([^*]+?)\*\*|__([^_]+?)__`)
+	mdItalic   = regexp.MustCompile(`\*([^*\s][^*]*?)\*|\b_([^_\s][^_]*?)_\b`)
+)
+
+// RenderMarkdown formats a markdown answer for the terminal: headings, lists,
+// quotes, rules, code blocks and inline emphasis, code and links, with
+// paragraphs wrapped to width. It uses the theme's colors when color is
+// enabled and only layout otherwise. Tables drawn by RenderMarkdownTables are
+// kept as they are.
+func RenderMarkdown(markdown string, width int) string {
+	width = max(width, 20)
+	var out []string
+	var paragraph []string
+	flush := func() {
+		if len(paragraph) > 0 {
+			out = append(out, wrapStyled(renderInline(strings.Join(paragraph, " ")), width, "", "")...)
+			paragraph = nil
+		}
+	}
+	// blank separates blocks with one empty line.
+	blank := func() {
+		if len(out) > 0 && out[len(out)-1] != "" {
+			out = append(out, "")
+		}
+	}
+
+	lines := strings.Split(strings.ReplaceAll(markdown, "\r\n", "\n"), "\n")
+	for i := 0; i < len(lines); i++ {
+		line := lines[i]
+		trimmed := strings.TrimSpace(line)
+		switch {
+		case trimmed == "":
+			flush()
+			blank()
+
+		case mdFence.MatchString(line):
+			flush()
+			fence := mdFence.FindStringSubmatch(line)[1]
+			if lang := mdFence.FindStringSubmatch(line)[2]; lang != "" {
+				out = append(out, Paint(StyleStatus, "  "+lang))
+			}
+			for i++; i < len(lines) && !strings.HasPrefix(strings.TrimSpace(lines[i]), fence); i++ {
+				out = append(out, Paint(StyleCode, "    "+strings.TrimRight(lines[i], " \t")))
+			}
+
+		case strings.IndexAny(trimmed, "┌│├└") == 0:
+			flush()
+			out = append(out, line)
+
+		case mdHeading.MatchString(line):
+			flush()
+			blank()
+			m := mdHeading.FindStringSubmatch(line)
+			text := renderInline(m[2])
+			out = append(out, Paint(StyleHeading, text))
+			if len(m[1]) <= 2 {
+				rule := "═"
+				if len(m[1]) == 2 {
+					rule = "─"
+				}
+				out = append(out, Paint(StyleHeading, strings.Repeat(rule, min(visibleWidth(text), width))))
+			}
+
+		case mdRule.MatchString(line):
+			flush()
+			out = append(out, Paint(StyleStatus, strings.Repeat("─", width)))
+
+		case mdQuote.MatchString(line):
+			flush()
+			var quote []string
+			for ; i < len(lines) && mdQuote.MatchString(lines[i]); i++ {
+				quote = append(quote, mdQuote.FindStringSubmatch(lines[i])[1])
+			}
+			i--
+			bar := Paint(StyleStatus, "│ ")
+			out = append(out, wrapStyled(renderInline(strings.Join(quote, " ")), width, bar, bar)...)
+
+		case mdListItem.MatchString(line):
+			flush()
+			m := mdListItem.FindStringSubmatch(line)
+			indent := strings.Repeat("  ", len(strings.ReplaceAll(m[1], "\t", "    "))/2)
+			text := m[3]
+			// Indented lines that are not list items continue the item.
+			for i+1 < len(lines) && strings.TrimSpace(lines[i+1]) != "" && strings.HasPrefix(lines[i+1], " ") &&
+				!mdListItem.MatchString(lines[i+1]) && !mdFence.MatchString(lines[i+1]) {
+				i++
+				text += " " + strings.TrimSpace(lines[i])
+			}
+			marker := m[2]
+			switch {
+			case marker == "-" || marker == "*" || marker == "+":
+				marker = "•"
+				if indent != "" {
+					marker = "◦"
+				}
+			}
+			if t := mdTask.FindStringSubmatch(text); t != nil {
+				marker = "☐"
+				if t[1] != " " {
+					marker = "☑"
+				}
+				text = text[len(t[0]):]
+			}
+			first := indent + marker + " "
+			out = append(out, wrapStyled(renderInline(text), width, first, strings.Repeat(" ", utf8.RuneCountInString(first)))...)
+
+		default:
+			paragraph = append(paragraph, trimmed)
+		}
+	}
+	flush()
+	for len(out) > 0 && out[len(out)-1] == "" {
+		out = out[:len(out)-1]
+	}
+	return strings.Join(out, "\n") + "\n"
+}
+
+// renderInline styles code spans, links, bold and italic text.
+func renderInline(text string) string {
+	color := ColorEnabled()
+	// Code spans are set aside first so their contents are not styled.
+	var spans []string
+	text = mdCodeSpan.ReplaceAllStringFunc(text, func(s string) string {
+		code := mdCodeSpan.FindStringSubmatch(s)[1]
+		if color {
+			code = Paint(StyleCode, code)
+		} else {
+			code = "`" + code + "`"
+		}
+		spans = append(spans, code)
+		return "\x00" + strconv.Itoa(len(spans)-1) + "\x00"
+	})
+	text = mdLink.ReplaceAllStringFunc(text, func(s string) string {
+		m := mdLink.FindStringSubmatch(s)
+		if m[1] == m[2] {
+			return Paint(StyleCitation, m[2])
+		}
+		return m[1] + " " + Paint(StyleCitation, "("+m[2]+")")
+	})
+	text = mdBold.ReplaceAllStringFunc(text, func(s string) string {
+		m := mdBold.FindStringSubmatch(s)
+		return sgr("1", m[1]+m[2])
+	})
+	text = mdItalic.ReplaceAllStringFunc(text, func(s string) string {
+		m := mdItalic.FindStringSubmatch(s)
+		return sgr("3", m[1]+m[2])
+	})
+	for i, code := range spans {
+		text = strings.Replace(text, "\x00"+strconv.Itoa(i)+"\x00", code, 1)
+	}
+	return text
+}
+
+// sgr wraps text in an ANSI SGR sequence when color is enabled.
+func sgr(code, text string) string {
+	if !ColorEnabled() {
+		return text
+	}
+	return "\x1b[" + code + "m" + text + "\x1b[0m"
+}
+
+// visibleWidth is the number of runes in text, not counting ANSI sequences.
+func visibleWidth(text string) int {
+	return utf8.RuneCountInString(ansiPattern.ReplaceAllString(text, ""))
+}
+
+// wrapStyled word-wraps styled text to width, starting the first line with
+// first and the others with rest.
+func wrapStyled(text string, width int, first, rest string) []string {
+	var lines []string
+	prefix := first
+	line := ""
+	for _, word := range strings.Fields(text) {
+		if line != "" && visibleWidth(prefix+line)+1+visibleWidth(word) > width {
+			lines = append(lines, prefix+line)
+			prefix, line = rest, ""
+		}
+		if line != "" {
+			line += " "
+		}
+		line += word
+	}
+	return append(lines, prefix+line)
+}
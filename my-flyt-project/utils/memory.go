@@ -0,0 +1,200 @@
+package utils
+
+import (
+	"fmt"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// MemoryStat is the accounted size of one in-memory store. Sizes are
+// estimates of the data held (text and vectors), not of Go's overhead.
+type MemoryStat struct {
+	Name      string `json:"name"`
+	Entries   int    `json:"entries"`
+	Bytes     int64  `json:"bytes"`
+	Limit     int64  `json:"limit,omitempty"` // 0 means no limit
+	Evictions int    `json:"evictions"`
+}
+
+var memoryPools struct {
+	sync.Mutex
+	names []string
+	stats map[string]func() MemoryStat
+}
+
+// RegisterMemoryPool adds a store to MemoryStats; stat is called each time
+// the stats are read. Registering a name again replaces it.
+func RegisterMemoryPool(name string, stat func() MemoryStat) {
+	memoryPools.Lock()
+	defer memoryPools.Unlock()
+	if memoryPools.stats == nil {
+		memoryPools.stats = map[string]func() MemoryStat{}
+	}
+	if _, ok := memoryPools.stats[name]; !ok {
+		memoryPools.names = append(memoryPools.names, name)
+	}
+	memoryPools.stats[name] = stat
+}
+
+// MemoryStats returns the stats of every registered store, in the order
+// they were registered.
+func MemoryStats() []MemoryStat {
+	memoryPools.Lock()
+	names := append([]string(nil), memoryPools.names...)
+	funcs := make([]func() MemoryStat, len(names))
+	for i, name := range names {
+		funcs[i] = memoryPools.stats[name]
+	}
+	memoryPools.Unlock()
+
+	stats := make([]MemoryStat, len(funcs))
+	for i, stat := range funcs {
+		stats[i] = stat()
+		stats[i].Name = names[i]
+	}
+	return stats
+}
+
+// RuntimeMemory is what the Go runtime reports about the process's memory.
+type RuntimeMemory struct {
+	HeapBytes int64  `json:"heap_bytes"`
+	SysBytes  int64  `json:"sys_bytes"`
+	NumGC     uint32 `json:"num_gc"`
+}
+
+// ReadRuntimeMemory reads the process's heap and total memory from the runtime.
+func ReadRuntimeMemory() RuntimeMemory {
+	var m runtime.MemStats
+	runtime.ReadMemStats(&m)
+	return RuntimeMemory{HeapBytes: int64(m.HeapAlloc), SysBytes: int64(m.Sys), NumGC: m.NumGC}
+}
+
+// FormatMemoryStats renders stats and the runtime's figures as a table for /memstats.
+func FormatMemoryStats(stats []MemoryStat) string {
+	var b strings.Builder
+	b.WriteString("| Store | Entries | Size | Limit | Evictions |\n|---|---:|---:|---:|---:|\n")
+	for _, s := range stats {
+		limit := "none"
+		if s.Limit > 0 {
+			limit = FormatBytes(s.Limit)
+		}
+		fmt.Fprintf(&b, "| %s | %d | %s | %s | %d |\n", s.Name, s.Entries, FormatBytes(s.Bytes), limit, s.Evictions)
+	}
+	rt := ReadRuntimeMemory()
+	fmt.Fprintf(&b, "\nGo heap: %s in use, %s from the OS, %d GC cycles\n", FormatBytes(rt.HeapBytes), FormatBytes(rt.SysBytes), rt.NumGC)
+	return b.String()
+}
+
+// HistoryBytes estimates the memory the text of h holds.
+func HistoryBytes(h History) int64 {
+	n := int64(len(h.Summary) + len(h.ID))
+	for _, c := range h.Conversations {
+		n += int64(len(c.User) + len(c.answerText()))
+		for _, path := range c.Images {
+			n += int64(len(path))
+		}
+	}
+	return n
+}
+
+// MemoryBytes estimates the memory the index's chunks hold.
+func (x *KBIndex) MemoryBytes() int64 {
+	var n int64
+	for _, c := range x.Chunks {
+		n += chunkBytes(c)
+	}
+	return n
+}
+
+func chunkBytes(c KBChunk) int64 {
+	return int64(len(c.Namespace)+len(c.DocID)+len(c.Title)+len(c.URL)+len(c.Symbol)+len(c.Text)) + 8*int64(len(c.Vector))
+}
+
+// FitMemory drops whole namespaces from the loaded index, the least recently
+// synced first, until it holds at most limit bytes, and returns the dropped
+// namespaces. Only the copy in memory changes; the index must not be saved
+// afterwards.
+func (x *KBIndex) FitMemory(limit int64) []string {
+	if limit <= 0 {
+		return nil
+	}
+	sizes := map[string]int64{}
+	var total int64
+	for _, c := range x.Chunks {
+		n := chunkBytes(c)
+		sizes[c.Namespace] += n
+		total += n
+	}
+	namespaces := make([]string, 0, len(sizes))
+	for ns := range sizes {
+		namespaces = append(namespaces, ns)
+	}
+	sort.Slice(namespaces, func(i, j int) bool {
+		return x.LastSync[namespaces[i]].Before(x.LastSync[namespaces[j]])
+	})
+
+	dropped := map[string]bool{}
+	var names []string
+	for _, ns := range namespaces {
+		if total <= limit {
+			break
+		}
+		dropped[ns] = true
+		names = append(names, ns)
+		total -= sizes[ns]
+	}
+	if len(names) > 0 {
+		kept := x.Chunks[:0]
+		for _, c := range x.Chunks {
+			if !dropped[c.Namespace] {
+				kept = append(kept, c)
+			}
+		}
+		x.Chunks = kept
+	}
+	return names
+}
+
+// ByteSize is a flag value for a memory size such as 64MiB, 512MB or 1G; a
+// plain number is bytes and 0 means no limit.
+type ByteSize int64
+
+func (s ByteSize) String() string {
+	if s == 0 {
+		return "0"
+	}
+	return FormatBytes(int64(s))
+}
+
+func (s *ByteSize) Set(value string) error {
+	n, err := ParseByteSize(value)
+	if err != nil {
+		return err
+	}
+	*s = ByteSize(n)
+	return nil
+}
+
+// ParseByteSize parses a size with an optional unit: B, K/KB/KiB, M/MB/MiB
+// or G/GB/GiB. Units are powers of 1024 either way, as FormatBytes prints them.
+func ParseByteSize(value string) (int64, error) {
+	s := strings.ToUpper(strings.TrimSpace(value))
+	unit := int64(1)
+	for _, u := range []struct {
+		suffix string
+		size   int64
+	}{{"GIB", 1 << 30}, {"GB", 1 << 30}, {"G", 1 << 30}, {"MIB", 1 << 20}, {"MB", 1 << 20}, {"M", 1 << 20}, {"KIB", 1 << 10}, {"KB", 1 << 10}, {"K", 1 << 10}, {"B", 1}} {
+		if strings.HasSuffix(s, u.suffix) {
+			s, unit = strings.TrimSpace(strings.TrimSuffix(s, u.suffix)), u.size
+			break
+		}
+	}
+	n, err := strconv.ParseFloat(s, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid size %q (use e.g. 64MiB, 512MB or 1G)", value)
+	}
+	return int64(n * float64(unit)), nil
+}
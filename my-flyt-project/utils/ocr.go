@@ -0,0 +1,75 @@
+package utils
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log"
+	"os/exec"
+	"strings"
+)
+
+// OCRModel is the cheap vision-capable model used to read text out of images
+// when tesseract is not installed.
+var OCRModel = "gemini-2.5-flash-lite"
+
+// textOnlyModelPrefixes lists model families that reject image input.
+var textOnlyModelPrefixes = []string{
+	"gemini-1.0-pro",
+	"gemini-pro",
+	"gemma-3-1b",
+	"gemma-2",
+	"text-",
+}
+
+// ModelSupportsVision reports whether model accepts image parts.
+func ModelSupportsVision(model string) bool {
+	if strings.Contains(model, "vision") {
+		return true
+	}
+	for _, prefix := range textOnlyModelPrefixes {
+		if strings.HasPrefix(model, prefix) {
+			return false
+		}
+	}
+	return true
+}
+
+// ExtractImageText returns the text found in each image, using a local
+// tesseract install when available and a vision-capable model otherwise.
+func ExtractImageText(ctx context.Context, imagePaths []string) (string, error) {
+	var builder strings.Builder
+	for i, path := range imagePaths {
+		text, err := ocrImage(ctx, path)
+		if err != nil {
+			return "", err
+		}
+		builder.WriteString(fmt.Sprintf("--- Image %d (%s) ---\n%s\n", i+1, path, strings.TrimSpace(text)))
+	}
+	return builder.String(), nil
+}
+
+func ocrImage(ctx context.Context, path string) (string, error) {
+	mimeType, err := MIMETypeForPath(path)
+	if err != nil {
+		return "", err
+	}
+
+	if _, err := exec.LookPath("tesseract"); err == nil && (mimeType == "image/png" || mimeType == "image/jpeg") {
+		var stderr bytes.Buffer
+		cmd := exec.CommandContext(ctx, "tesseract", path, "stdout")
+		cmd.Stderr = &stderr
+		out, err := cmd.Output()
+		if err == nil {
+			return string(out), nil
+		}
+		// Fall through to the model if tesseract cannot read the file.
+		log.Printf("tesseract failed on %s: %v: %s", path, err, strings.TrimSpace(stderr.String()))
+	}
+
+	config := DefaultLLMConfig()
+	config.Model = OCRModel
+	config.Temperature = 0
+	prompt := "Transcribe all text visible in this image exactly as written. If there is no text, briefly describe the image instead."
+	return CallLLMWithImagesConfig(ctx, prompt, []string{path}, config)
+}
@@ -0,0 +1,452 @@
+package utils
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// DefaultOpenAIBaseURL is the OpenAI API; Groq, Together, Ollama and other
+// compatible servers are used by passing their base URL instead.
+const DefaultOpenAIBaseURL = "https://api.openai.com/v1"
+
+// OpenAIProvider talks to any server implementing the OpenAI
+// /chat/completions API. The key is read from OPENAI_API_KEY; local servers
+// that need none can leave it unset.
+type OpenAIProvider struct {
+	BaseURL string
+	APIKey  string
+}
+
+// NewOpenAIProvider returns a provider for baseURL (DefaultOpenAIBaseURL when empty).
+func NewOpenAIProvider(baseURL string) OpenAIProvider {
+	if baseURL == "" {
+		baseURL = DefaultOpenAIBaseURL
+	}
+	return OpenAIProvider{BaseURL: strings.TrimSuffix(baseURL, "/"), APIKey: os.Getenv("OPENAI_API_KEY")}
+}
+
+// Generate sends prompt with the system instructions. Chat completions have
+// no built-in web search, so useSearch only logs a note.
+func (p OpenAIProvider) Generate(ctx context.Context, prompt string, config *LLMConfig, useSearch bool) (string, error) {
+	if useSearch {
+		log.Printf("web search grounding is not available with the OpenAI-compatible provider; answering without it")
+	}
+	if len(config.Tools) > 0 {
+		return p.toolLoop(ctx, prompt, config)
+	}
+	return p.complete(ctx, prompt, config)
+}
+
+// toolLoop lets the model call config.Tools: each assistant message with
+// tool_calls is followed by a tool message per call, until the model answers.
+func (p OpenAIProvider) toolLoop(ctx context.Context, prompt string, config *LLMConfig) (string, error) {
+	dialect := ToolDialects["openai"]
+	requestBody := chatRequestBody(prompt, config)
+	requestBody["tools"] = dialect.Declarations(config.Tools)
+	messages := requestBody["messages"].([]map[string]any)
+
+	for round := 0; ; round++ {
+		if round == MaxToolRounds {
+			// Out of rounds: the model must answer from the results it has.
+			requestBody["tool_choice"] = "none"
+		}
+		requestBody["messages"] = messages
+
+		var result struct {
+			Choices []struct {
+				Message json.RawMessage `json:"message"`
+			} `json:"choices"`
+			Usage *struct {
+				PromptTokens     int `json:"prompt_tokens"`
+				CompletionTokens int `json:"completion_tokens"`
+			} `json:"usage"`
+		}
+		release, err := DefaultScheduler.Acquire(ctx, config.Priority)
+		if err != nil {
+			return "", err
+		}
+		err = p.post(ctx, "/chat/completions", requestBody, &result)
+		release()
+		if err != nil {
+			return "", err
+		}
+		if u := result.Usage; u != nil {
+			RecordUsage(ctx, config.Model, u.PromptTokens, u.CompletionTokens)
+		}
+		if len(result.Choices) == 0 {
+			return "", fmt.Errorf("no response from API")
+		}
+		message := result.Choices[0].Message
+
+		calls, err := dialect.ParseCalls(message)
+		if err != nil {
+			return "", err
+		}
+		if len(calls) == 0 {
+			var reply struct {
+				Content string `json:"content"`
+			}
+			if err := json.Unmarshal(message, &reply); err != nil {
+				return "", fmt.Errorf("failed to parse response: %w", err)
+			}
+			return reply.Content, nil
+		}
+		messages = append(messages, dialect.CallTurn(calls))
+		messages = append(messages, dialect.ResultTurns(runToolCalls(ctx, config, calls))...)
+	}
+}
+
+// GenerateWithImages sends the images as data URLs alongside prompt.
+func (p OpenAIProvider) GenerateWithImages(ctx context.Context, prompt string, imagePaths []string, config *LLMConfig) (string, error) {
+	content := []map[string]any{{"type": "text", "text": prompt}}
+	for _, path := range imagePaths {
+		a, err := openAttachment(path)
+		if err != nil {
+			return "", err
+		}
+		if base64Len(a.Size) > MaxInlineRequestBytes {
+			return "", fmt.Errorf("%s is %s once encoded, too large to send inline; the gemini provider uploads it through the File API", path, FormatBytes(base64Len(a.Size)))
+		}
+		r, err := a.Open()
+		if err != nil {
+			return "", err
+		}
+		imageData, err := io.ReadAll(r)
+		r.Close()
+		if err != nil {
+			return "", fmt.Errorf("failed to read %s: %w", path, err)
+		}
+		content = append(content, map[string]any{
+			"type":      "image_url",
+			"image_url": map[string]string{"url": "data:" + a.MIMEType + ";base64," + base64.StdEncoding.EncodeToString(imageData)},
+		})
+	}
+	return p.complete(ctx, content, config)
+}
+
+// Stream requests a streamed chat completion and emits the events of the
+// reply as the server-sent chunks arrive.
+func (p OpenAIProvider) Stream(ctx context.Context, prompt string, config *LLMConfig, emit StreamHandler) error {
+	release, err := DefaultScheduler.Acquire(ctx, config.Priority)
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	requestBody := chatRequestBody(prompt, config)
+	requestBody["stream"] = true
+	requestBody["stream_options"] = map[string]any{"include_usage": true}
+	jsonData, err := json.Marshal(requestBody)
+	if err != nil {
+		return fmt.Errorf("failed to marshal request: %w", err)
+	}
+	req, err := http.NewRequestWithContext(ctx, "POST", p.BaseURL+"/chat/completions", bytes.NewBuffer(jsonData))
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if p.APIKey != "" {
+		req.Header.Set("Authorization", "Bearer "+p.APIKey)
+	}
+	// No overall timeout: long answers keep streaming for a while.
+	client := &http.Client{Transport: &http.Transport{ResponseHeaderTimeout: 60 * time.Second}}
+
+	resp, err := DoWithRetry(client, req)
+	if err != nil {
+		return fmt.Errorf("failed to make request: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("API request failed with status %d: %s", resp.StatusCode, string(body))
+	}
+	return readOpenAIStream(resp.Body, emit)
+}
+
+// readOpenAIStream parses the "data: {...}" chunks of a streamed chat
+// completion up to "data: [DONE]". Tool call arguments arrive in pieces, so
+// each call is emitted once the choice finishes.
+func readOpenAIStream(r io.Reader, emit StreamHandler) error {
+	type partialCall struct {
+		id, name string
+		args     strings.Builder
+	}
+	var calls []*partialCall
+	var finishReason string
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), 10*1024*1024)
+	for scanner.Scan() {
+		data, ok := strings.CutPrefix(scanner.Text(), "data: ")
+		if !ok {
+			continue
+		}
+		if data == "[DONE]" {
+			break
+		}
+		var chunk struct {
+			Choices []struct {
+				Delta struct {
+					Content   string `json:"content"`
+					ToolCalls []struct {
+						Index    int    `json:"index"`
+						ID       string `json:"id"`
+						Function struct {
+							Name      string `json:"name"`
+							Arguments string `json:"arguments"`
+						} `json:"function"`
+					} `json:"tool_calls"`
+				} `json:"delta"`
+				FinishReason string `json:"finish_reason"`
+			} `json:"choices"`
+			Usage *struct {
+				PromptTokens     int `json:"prompt_tokens"`
+				CompletionTokens int `json:"completion_tokens"`
+			} `json:"usage"`
+		}
+		if err := json.Unmarshal([]byte(data), &chunk); err != nil {
+			return fmt.Errorf("failed to parse stream event: %w", err)
+		}
+		for _, c := range chunk.Choices {
+			if c.Delta.Content != "" {
+				if err := emit(TextDelta{Text: c.Delta.Content}); err != nil {
+					return err
+				}
+			}
+			for _, tc := range c.Delta.ToolCalls {
+				for len(calls) <= tc.Index {
+					calls = append(calls, &partialCall{})
+				}
+				call := calls[tc.Index]
+				if tc.ID != "" {
+					call.id = tc.ID
+				}
+				if tc.Function.Name != "" {
+					call.name = tc.Function.Name
+				}
+				call.args.WriteString(tc.Function.Arguments)
+			}
+			if c.FinishReason != "" {
+				finishReason = c.FinishReason
+			}
+		}
+		if finishReason != "" && len(calls) > 0 {
+			for _, call := range calls {
+				args := map[string]any{}
+				if call.args.Len() > 0 {
+					if err := json.Unmarshal([]byte(call.args.String()), &args); err != nil {
+						return fmt.Errorf("invalid arguments for %s: %w", call.name, err)
+					}
+				}
+				if err := emit(ToolCallStart{Call: ToolCall{ID: call.id, Name: call.name, Args: args}}); err != nil {
+					return err
+				}
+			}
+			calls = nil
+		}
+		if u := chunk.Usage; u != nil {
+			if err := emit(UsageUpdate{PromptTokens: u.PromptTokens, OutputTokens: u.CompletionTokens}); err != nil {
+				return err
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("stream interrupted: %w", err)
+	}
+	return emit(Done{FinishReason: finishReason})
+}
+
+// complete sends one user message (a string or a list of content parts) and
+// returns the text of the reply.
+func (p OpenAIProvider) complete(ctx context.Context, content any, config *LLMConfig) (string, error) {
+	release, err := DefaultScheduler.Acquire(ctx, config.Priority)
+	if err != nil {
+		return "", err
+	}
+	defer release()
+
+	var result struct {
+		Choices []struct {
+			Message struct {
+				Content string `json:"content"`
+			} `json:"message"`
+		} `json:"choices"`
+		Usage *struct {
+			PromptTokens     int `json:"prompt_tokens"`
+			CompletionTokens int `json:"completion_tokens"`
+		} `json:"usage"`
+	}
+	if err := p.post(ctx, "/chat/completions", chatRequestBody(content, config), &result); err != nil {
+		return "", err
+	}
+	if u := result.Usage; u != nil {
+		RecordUsage(ctx, config.Model, u.PromptTokens, u.CompletionTokens)
+	}
+	if len(result.Choices) == 0 {
+		return "", fmt.Errorf("no response from API")
+	}
+	return result.Choices[0].Message.Content, nil
+}
+
+// chatRequestBody builds a chat completions request with the system
+// instructions and one user message.
+func chatRequestBody(content any, config *LLMConfig) map[string]any {
+	var messages []map[string]any
+	if sys := systemPrompt(config); sys != "" {
+		messages = append(messages, map[string]any{"role": "system", "content": sys})
+	}
+	for _, c := range config.History {
+		var user any = c.User
+		if len(c.Images) > 0 {
+			parts := []map[string]any{{"type": "text", "text": c.User}}
+			for _, path := range c.Images {
+				imageData, mimeType, err := PrepareImage(path)
+				if err != nil {
+					parts = append(parts, map[string]any{"type": "text", "text": fmt.Sprintf("[image %s is no longer available]", path)})
+					continue
+				}
+				parts = append(parts, map[string]any{
+					"type":      "image_url",
+					"image_url": map[string]string{"url": "data:" + mimeType + ";base64," + base64.StdEncoding.EncodeToString(imageData)},
+				})
+			}
+			user = parts
+		}
+		messages = append(messages,
+			map[string]any{"role": "user", "content": user},
+			map[string]any{"role": "assistant", "content": c.answerText()})
+	}
+	messages = append(messages, map[string]any{"role": "user", "content": content})
+	requestBody := map[string]any{
+		"model":       config.Model,
+		"messages":    messages,
+		"temperature": config.Temperature,
+	}
+	if config.MaxTokens > 0 {
+		requestBody["max_tokens"] = config.MaxTokens
+	}
+	if format := openAIResponseFormat(config); format != nil {
+		requestBody["response_format"] = format
+	}
+	return requestBody
+}
+
+// GenerateInThread continues a conversation stored by the Responses API
+// (POST /responses with previous_response_id); the thread ID is the ID of the
+// last response. Only OpenAI itself and a few compatible servers offer it.
+func (p OpenAIProvider) GenerateInThread(ctx context.Context, threadID, prompt string, config *LLMConfig) (string, string, error) {
+	release, err := DefaultScheduler.Acquire(ctx, config.Priority)
+	if err != nil {
+		return "", "", err
+	}
+	defer release()
+
+	requestBody := map[string]any{
+		"model":       config.Model,
+		"input":       prompt,
+		"store":       true,
+		"temperature": config.Temperature,
+	}
+	// Instructions are not carried over from earlier responses, so send them every turn.
+	if sys := systemPrompt(config); sys != "" {
+		requestBody["instructions"] = sys
+	}
+	if format := openAIResponseFormat(config); format != nil {
+		// The Responses API takes the chat completions format flattened under text.format.
+		if schema, ok := format["json_schema"].(map[string]any); ok {
+			format = map[string]any{"type": "json_schema", "name": schema["name"], "schema": schema["schema"]}
+		}
+		requestBody["text"] = map[string]any{"format": format}
+	}
+	if threadID != "" {
+		requestBody["previous_response_id"] = threadID
+	}
+	if config.MaxTokens > 0 {
+		requestBody["max_output_tokens"] = config.MaxTokens
+	}
+
+	var result struct {
+		ID     string `json:"id"`
+		Output []struct {
+			Type    string `json:"type"`
+			Content []struct {
+				Type string `json:"type"`
+				Text string `json:"text"`
+			} `json:"content"`
+		} `json:"output"`
+	}
+	if err := p.post(ctx, "/responses", requestBody, &result); err != nil {
+		return "", "", err
+	}
+	var b strings.Builder
+	for _, item := range result.Output {
+		for _, c := range item.Content {
+			if item.Type == "message" && c.Type == "output_text" {
+				b.WriteString(c.Text)
+			}
+		}
+	}
+	if b.Len() == 0 {
+		return "", "", fmt.Errorf("no response from API")
+	}
+	return b.String(), result.ID, nil
+}
+
+// openAIResponseFormat is the response_format for config.Format, or nil when
+// the system message asks for it instead. json_object and strict schemas
+// only allow an object at the top level, so replies expected to be arrays
+// (no schema, or a non-object one) rely on the instruction alone.
+func openAIResponseFormat(config *LLMConfig) map[string]any {
+	if config.Format != FormatJSON || config.Schema == nil || config.Schema["type"] != "object" {
+		return nil
+	}
+	return map[string]any{
+		"type":        "json_schema",
+		"json_schema": map[string]any{"name": "reply", "schema": config.Schema},
+	}
+}
+
+// post sends a JSON request to the API and decodes the reply into out.
+func (p OpenAIProvider) post(ctx context.Context, path string, requestBody any, out any) error {
+	jsonData, err := json.Marshal(requestBody)
+	if err != nil {
+		return fmt.Errorf("failed to marshal request: %w", err)
+	}
+	req, err := http.NewRequestWithContext(ctx, "POST", p.BaseURL+path, bytes.NewBuffer(jsonData))
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if p.APIKey != "" {
+		req.Header.Set("Authorization", "Bearer "+p.APIKey)
+	}
+	client := &http.Client{Timeout: 180 * time.Second}
+
+	resp, err := DoWithRetry(client, req)
+	if err != nil {
+		return fmt.Errorf("failed to make request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("failed to read response: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("API request failed with status %d: %s", resp.StatusCode, string(body))
+	}
+	if err := json.Unmarshal(body, out); err != nil {
+		return fmt.Errorf("failed to parse response: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,161 @@
+package utils
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"os/exec"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+var ansiPattern = regexp.MustCompile(`\x1b\[[0-9;?]*[A-Za-z]`)
+
+// IsTerminal reports whether f is attached to a terminal.
+func IsTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+// IsPiped reports whether f is a pipe or a redirected file, as stdin is in
+// `cat main.go | ai "explain this"`.
+func IsPiped(f *os.File) bool {
+	info, err := f.Stat()
+	return err == nil && (info.Mode()&os.ModeNamedPipe != 0 || info.Mode().IsRegular())
+}
+
+// OutputWidth, when positive, is used by TerminalWidth instead of the
+// terminal's width.
+var OutputWidth int
+
+// TerminalWidth returns OutputWidth when set, otherwise the width of the
+// controlling terminal, or 100 when it cannot be determined.
+func TerminalWidth() int {
+	if OutputWidth > 0 {
+		return OutputWidth
+	}
+	if cols, err := strconv.Atoi(os.Getenv("COLUMNS")); err == nil && cols > 0 {
+		return cols
+	}
+	if _, cols := terminalSize(); cols > 0 {
+		return cols
+	}
+	return 100
+}
+
+// TerminalHeight returns the height of the controlling terminal, or 0 when it cannot be determined.
+func TerminalHeight() int {
+	if lines, err := strconv.Atoi(os.Getenv("LINES")); err == nil && lines > 0 {
+		return lines
+	}
+	rows, _ := terminalSize()
+	return rows
+}
+
+func terminalSize() (rows, cols int) {
+	tty, err := os.Open("/dev/tty")
+	if err != nil {
+		return 0, 0
+	}
+	defer tty.Close()
+	cmd := exec.Command("stty", "size")
+	cmd.Stdin = tty
+	out, err := cmd.Output()
+	if err != nil {
+		return 0, 0
+	}
+	fmt.Sscan(string(out), &rows, &cols)
+	return rows, cols
+}
+
+// Page writes text to stdout. When stdout is a terminal and the text is taller
+// than it, the text goes through $PAGER, less, or a built-in pager instead.
+func Page(text string) error {
+	height := TerminalHeight()
+	if !IsTerminal(os.Stdout) || height == 0 || strings.Count(text, "\n") < height-1 {
+		_, err := fmt.Print(text)
+		return err
+	}
+
+	pager := strings.Fields(os.Getenv("PAGER"))
+	if len(pager) == 0 {
+		if _, err := exec.LookPath("less"); err == nil {
+			pager = []string{"less"}
+		}
+	}
+	if len(pager) > 0 {
+		cmd := exec.Command(pager[0], pager[1:]...)
+		cmd.Stdin = strings.NewReader(text)
+		cmd.Stdout = os.Stdout
+		cmd.Stderr = os.Stderr
+		// Like git: keep colors, and quit immediately if it fits after all.
+		if os.Getenv("LESS") == "" {
+			cmd.Env = append(os.Environ(), "LESS=FRX")
+		}
+		if err := cmd.Run(); err == nil {
+			return nil
+		}
+	}
+	return builtinPager(strings.Split(strings.TrimSuffix(text, "\n"), "\n"), height)
+}
+
+// builtinPager is a line-oriented pager for systems without less: Enter or
+// space pages down, b pages up, /text searches, n repeats the search, q quits.
+func builtinPager(lines []string, height int) error {
+	tty, err := os.Open("/dev/tty")
+	if err != nil {
+		_, err = fmt.Println(strings.Join(lines, "\n"))
+		return err
+	}
+	defer tty.Close()
+	input := bufio.NewReader(tty)
+
+	page := max(height-1, 1)
+	top, query := 0, ""
+	for {
+		end := min(top+page, len(lines))
+		for _, line := range lines[top:end] {
+			fmt.Println(line)
+		}
+		if end >= len(lines) {
+			return nil
+		}
+		fmt.Printf("-- lines %d-%d of %d (Enter next, b back, /text search, n next match, q quit) --", top+1, end, len(lines))
+		cmd, err := input.ReadString('\n')
+		if err != nil {
+			fmt.Println()
+			return nil
+		}
+		cmd = strings.TrimRight(cmd, "\r\n")
+		switch {
+		case cmd == "q":
+			return nil
+		case cmd == "b":
+			top = max(top-page, 0)
+		case strings.HasPrefix(cmd, "/") || cmd == "n":
+			if cmd != "n" {
+				query = strings.ToLower(cmd[1:])
+			}
+			if match := searchLines(lines, query, top+1); match >= 0 {
+				top = match
+			} else {
+				fmt.Printf("Pattern not found: %s\n", query)
+			}
+		default:
+			top = end
+		}
+	}
+}
+
+func searchLines(lines []string, query string, from int) int {
+	if query == "" {
+		return -1
+	}
+	for i := from; i < len(lines); i++ {
+		if strings.Contains(strings.ToLower(ansiPattern.ReplaceAllString(lines[i], "")), query) {
+			return i
+		}
+	}
+	return -1
+}
@@ -0,0 +1,120 @@
+package utils
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// Persona is the model and system prompt one node of a flow runs with, for
+// example a cheap model for summaries and a strong one for final answers.
+// Empty fields keep the node's defaults.
+type Persona struct {
+	Model       string
+	System      string
+	Temperature *float64
+}
+
+// Personas maps node names (LLMConfig.Node) to their persona.
+var Personas = map[string]Persona{}
+
+// defaultPersonasPath is the persona spec, overridden by PERSONAS_PATH.
+const defaultPersonasPath = "config/personas.yaml"
+
+// PersonasPath is PERSONAS_PATH, or config/personas.yaml.
+func PersonasPath() string {
+	if path := os.Getenv("PERSONAS_PATH"); path != "" {
+		return path
+	}
+	return defaultPersonasPath
+}
+
+// LoadPersonas reads the persona spec at path into Personas: a YAML mapping
+// from node name to model, system and temperature. A missing file leaves
+// every node on the defaults.
+func LoadPersonas(path string) error {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read personas: %w", err)
+	}
+	docs, err := ParseYAML(string(data))
+	if err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	if len(docs) == 0 || docs[0] == nil {
+		return nil
+	}
+	nodes, ok := docs[0].(map[string]any)
+	if !ok {
+		return fmt.Errorf("%s: expected a mapping of node names to personas", path)
+	}
+	personas := map[string]Persona{}
+	for node, value := range nodes {
+		fields, ok := value.(map[string]any)
+		if !ok {
+			return fmt.Errorf("%s: %s: expected model, system and temperature keys", path, node)
+		}
+		var p Persona
+		for key, v := range fields {
+			text := strings.TrimSpace(fmt.Sprint(v))
+			switch key {
+			case "model":
+				p.Model = text
+			case "system":
+				p.System = text
+			case "temperature":
+				t, err := strconv.ParseFloat(text, 64)
+				if err != nil {
+					return fmt.Errorf("%s: %s: invalid temperature %q", path, node, text)
+				}
+				p.Temperature = &t
+			default:
+				return fmt.Errorf("%s: %s: unknown key %q (use model, system or temperature)", path, node, key)
+			}
+		}
+		personas[node] = p
+	}
+	Personas = personas
+	return nil
+}
+
+// NodeConfig is DefaultLLMConfig for the node with the given name, so its
+// persona applies.
+func NodeConfig(node string) *LLMConfig {
+	config := DefaultLLMConfig()
+	config.Node = node
+	return config
+}
+
+// CallLLMAs calls the default provider with prompt as the given node.
+func CallLLMAs(ctx context.Context, node, prompt string) (string, error) {
+	return CallLLMWithConfig(ctx, prompt, NodeConfig(node), false)
+}
+
+// withPersona returns config with the persona of its node applied: the
+// persona's model and temperature replace the node's, and its system prompt
+// comes before the conversation's.
+func withPersona(config *LLMConfig) *LLMConfig {
+	p, ok := Personas[config.Node]
+	if !ok {
+		return config
+	}
+	applied := *config
+	if p.Model != "" {
+		applied.Model = p.Model
+	}
+	if p.Temperature != nil {
+		applied.Temperature = *p.Temperature
+	}
+	if p.System != "" {
+		applied.System = strings.TrimSpace(p.System + "\n\n" + config.System)
+	}
+	return &applied
+}
@@ -0,0 +1,403 @@
+package utils
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/pbkdf2"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// pgConfig is where and as whom a postgres:// URL connects. Like libpq, it
+// falls back to PGUSER, PGPASSWORD and PGDATABASE, and takes a Unix socket
+// directory as ?host=/run/postgresql.
+type pgConfig struct {
+	network, addr      string
+	user, password, db string
+	sslMode            string
+	serverName         string
+}
+
+func parsePgURL(u *url.URL) (pgConfig, error) {
+	c := pgConfig{network: "tcp", sslMode: u.Query().Get("sslmode")}
+	host, port := u.Hostname(), u.Port()
+	if h := u.Query().Get("host"); h != "" {
+		host = h
+	}
+	if host == "" {
+		host = "localhost"
+	}
+	if port == "" {
+		port = "5432"
+	}
+	if strings.HasPrefix(host, "/") {
+		c.network, c.addr = "unix", filepath.Join(host, ".s.PGSQL."+port)
+	} else {
+		c.addr, c.serverName = net.JoinHostPort(host, port), host
+	}
+	c.user = u.User.Username()
+	if c.user == "" {
+		c.user = os.Getenv("PGUSER")
+	}
+	if c.user == "" {
+		if current, err := user.Current(); err == nil {
+			c.user = current.Username
+		}
+	}
+	c.password, _ = u.User.Password()
+	if c.password == "" {
+		c.password = os.Getenv("PGPASSWORD")
+	}
+	if c.db = strings.Trim(u.Path, "/"); c.db == "" {
+		c.db = os.Getenv("PGDATABASE")
+	}
+	if c.db == "" {
+		c.db = c.user
+	}
+	switch c.sslMode {
+	case "":
+		c.sslMode = "prefer"
+		if c.network == "unix" {
+			c.sslMode = "disable"
+		}
+	case "disable", "prefer", "require", "verify-ca", "verify-full":
+	default:
+		return c, fmt.Errorf("unsupported sslmode %q", c.sslMode)
+	}
+	return c, nil
+}
+
+// pgConn is one connection speaking the Postgres frontend/backend protocol,
+// with only what the state store needs: authentication and simple queries.
+type pgConn struct {
+	conn net.Conn
+	r    *bufio.Reader
+}
+
+// pgError is an ErrorResponse from the server, after which the connection
+// is still usable.
+type pgError struct {
+	severity, code, message string
+}
+
+func (e pgError) Error() string {
+	return fmt.Sprintf("postgres: %s: %s (SQLSTATE %s)", e.severity, e.message, e.code)
+}
+
+func dialPg(ctx context.Context, c pgConfig) (*pgConn, error) {
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, c.network, c.addr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to Postgres at %s: %w", c.addr, err)
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	} else {
+		conn.SetDeadline(time.Now().Add(30 * time.Second))
+	}
+	if c.sslMode != "disable" {
+		if conn, err = pgStartTLS(conn, c); err != nil {
+			return nil, err
+		}
+	}
+	pc := &pgConn{conn: conn, r: bufio.NewReader(conn)}
+	if err := pc.startup(c); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return pc, nil
+}
+
+// pgStartTLS asks the server for TLS; only sslmode=prefer goes on in plain
+// text when it declines. require encrypts without checking the certificate,
+// as libpq does without a root certificate; verify-ca checks that a trusted
+// CA signed it, and verify-full also that it names the host.
+func pgStartTLS(conn net.Conn, c pgConfig) (net.Conn, error) {
+	request := binary.BigEndian.AppendUint32(binary.BigEndian.AppendUint32(nil, 8), 80877103)
+	reply := make([]byte, 1)
+	if _, err := conn.Write(request); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("postgres: %w", err)
+	}
+	if _, err := io.ReadFull(conn, reply); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("postgres: %w", err)
+	}
+	switch {
+	case reply[0] == 'S':
+		config := &tls.Config{ServerName: c.serverName}
+		switch c.sslMode {
+		case "prefer", "require":
+			config.InsecureSkipVerify = true
+		case "verify-ca":
+			config.InsecureSkipVerify = true
+			config.VerifyConnection = verifyPgCA
+		}
+		tlsConn := tls.Client(conn, config)
+		if err := tlsConn.Handshake(); err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("postgres: TLS: %w", err)
+		}
+		return tlsConn, nil
+	case reply[0] == 'N' && c.sslMode == "prefer":
+		return conn, nil
+	default:
+		conn.Close()
+		return nil, fmt.Errorf("postgres: the server at %s does not accept TLS (sslmode=%s)", c.addr, c.sslMode)
+	}
+}
+
+// verifyPgCA checks the server's certificate chain against the system's
+// roots without checking the host name, for sslmode=verify-ca.
+func verifyPgCA(state tls.ConnectionState) error {
+	if len(state.PeerCertificates) == 0 {
+		return errors.New("the server sent no certificate")
+	}
+	opts := x509.VerifyOptions{Intermediates: x509.NewCertPool()}
+	for _, cert := range state.PeerCertificates[1:] {
+		opts.Intermediates.AddCert(cert)
+	}
+	_, err := state.PeerCertificates[0].Verify(opts)
+	return err
+}
+
+// send writes one message: a type byte, unless 0, and the length-prefixed body.
+func (c *pgConn) send(kind byte, body []byte) error {
+	var b []byte
+	if kind != 0 {
+		b = append(b, kind)
+	}
+	b = binary.BigEndian.AppendUint32(b, uint32(len(body)+4))
+	_, err := c.conn.Write(append(b, body...))
+	return err
+}
+
+// receive reads one message from the server.
+func (c *pgConn) receive() (byte, []byte, error) {
+	var header [5]byte
+	if _, err := io.ReadFull(c.r, header[:]); err != nil {
+		return 0, nil, fmt.Errorf("postgres: %w", err)
+	}
+	size := binary.BigEndian.Uint32(header[1:])
+	if size < 4 || size > 1<<30 {
+		return 0, nil, fmt.Errorf("postgres: bad message length %d", size)
+	}
+	body := make([]byte, size-4)
+	if _, err := io.ReadFull(c.r, body); err != nil {
+		return 0, nil, fmt.Errorf("postgres: %w", err)
+	}
+	return header[0], body, nil
+}
+
+func parsePgError(body []byte) pgError {
+	var e pgError
+	for len(body) > 1 {
+		field := body[0]
+		value, rest, _ := bytes.Cut(body[1:], []byte{0})
+		switch field {
+		case 'S':
+			e.severity = string(value)
+		case 'C':
+			e.code = string(value)
+		case 'M':
+			e.message = string(value)
+		}
+		body = rest
+	}
+	return e
+}
+
+func cstring(s string) []byte { return append([]byte(s), 0) }
+
+// startup logs in with cleartext, MD5 or SCRAM-SHA-256 authentication, as
+// the server asks, and waits until it is ready for queries.
+func (c *pgConn) startup(config pgConfig) error {
+	body := binary.BigEndian.AppendUint32(nil, 3<<16)
+	for _, kv := range [][2]string{{"user", config.user}, {"database", config.db}, {"application_name", "ai_wraper"}} {
+		body = append(append(body, cstring(kv[0])...), cstring(kv[1])...)
+	}
+	if err := c.send(0, append(body, 0)); err != nil {
+		return fmt.Errorf("postgres: %w", err)
+	}
+	var scram *scramClient
+	for {
+		kind, body, err := c.receive()
+		if err != nil {
+			return err
+		}
+		switch kind {
+		case 'E':
+			return parsePgError(body)
+		case 'Z':
+			return nil
+		case 'R':
+		default:
+			// ParameterStatus, BackendKeyData and notices.
+			continue
+		}
+		if len(body) < 4 {
+			return errors.New("postgres: bad authentication message")
+		}
+		code, data := binary.BigEndian.Uint32(body), body[4:]
+		switch code {
+		case 0:
+		case 3:
+			err = c.send('p', cstring(config.password))
+		case 5:
+			inner := md5.Sum([]byte(config.password + config.user))
+			outer := md5.Sum(append([]byte(hex.EncodeToString(inner[:])), data...))
+			err = c.send('p', cstring("md5"+hex.EncodeToString(outer[:])))
+		case 10:
+			if !bytes.Contains(data, cstring("SCRAM-SHA-256")) {
+				return errors.New("postgres: the server offers no supported SASL mechanism")
+			}
+			if scram, err = newScramClient(config.password); err != nil {
+				return err
+			}
+			first := scram.clientFirst()
+			msg := append(cstring("SCRAM-SHA-256"), binary.BigEndian.AppendUint32(nil, uint32(len(first)))...)
+			err = c.send('p', append(msg, first...))
+		case 11:
+			var final string
+			if scram == nil {
+				return errors.New("postgres: unexpected SASL message")
+			}
+			if final, err = scram.clientFinal(string(data)); err == nil {
+				err = c.send('p', []byte(final))
+			}
+		case 12:
+			if scram == nil || !scram.verifyServer(string(data)) {
+				return errors.New("postgres: the server's SCRAM signature is wrong")
+			}
+		default:
+			return fmt.Errorf("postgres: unsupported authentication method %d", code)
+		}
+		if err != nil {
+			return fmt.Errorf("postgres: %w", err)
+		}
+	}
+}
+
+// query runs sql with the simple query protocol and returns the first
+// column of each row it returns, NULL as "".
+func (c *pgConn) query(ctx context.Context, sql string) ([]string, error) {
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		deadline = time.Now().Add(30 * time.Second)
+	}
+	c.conn.SetDeadline(deadline)
+	if err := c.send('Q', cstring(sql)); err != nil {
+		return nil, fmt.Errorf("postgres: %w", err)
+	}
+	var rows []string
+	var queryErr error
+	for {
+		kind, body, err := c.receive()
+		if err != nil {
+			return nil, err
+		}
+		switch kind {
+		case 'D':
+			if len(body) < 6 || binary.BigEndian.Uint16(body) == 0 {
+				rows = append(rows, "")
+				continue
+			}
+			size := int32(binary.BigEndian.Uint32(body[2:]))
+			if size < 0 || int(size) > len(body)-6 {
+				rows = append(rows, "")
+				continue
+			}
+			rows = append(rows, string(body[6:6+size]))
+		case 'E':
+			queryErr = parsePgError(body)
+		case 'Z':
+			return rows, queryErr
+		}
+	}
+}
+
+// scramClient holds the state of one SCRAM-SHA-256 exchange (RFC 5802 and
+// 7677). Postgres takes the user from the startup message, so the SCRAM
+// user name is empty.
+type scramClient struct {
+	password, nonce       string
+	clientFirstBare, auth string
+	salted                []byte
+}
+
+func newScramClient(password string) (*scramClient, error) {
+	nonce := make([]byte, 18)
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	s := &scramClient{password: password, nonce: base64.StdEncoding.EncodeToString(nonce)}
+	s.clientFirstBare = "n=,r=" + s.nonce
+	return s, nil
+}
+
+func (s *scramClient) clientFirst() string { return "n,," + s.clientFirstBare }
+
+func scramHMAC(key []byte, message string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(message))
+	return mac.Sum(nil)
+}
+
+func (s *scramClient) clientFinal(serverFirst string) (string, error) {
+	var nonce, salt string
+	iterations := 0
+	for _, attr := range strings.Split(serverFirst, ",") {
+		switch {
+		case strings.HasPrefix(attr, "r="):
+			nonce = attr[2:]
+		case strings.HasPrefix(attr, "s="):
+			salt = attr[2:]
+		case strings.HasPrefix(attr, "i="):
+			fmt.Sscanf(attr[2:], "%d", &iterations)
+		}
+	}
+	saltBytes, err := base64.StdEncoding.DecodeString(salt)
+	if err != nil || !strings.HasPrefix(nonce, s.nonce) || iterations <= 0 {
+		return "", errors.New("bad SCRAM challenge")
+	}
+	if s.salted, err = pbkdf2.Key(sha256.New, s.password, saltBytes, iterations, sha256.Size); err != nil {
+		return "", err
+	}
+	withoutProof := "c=biws,r=" + nonce
+	s.auth = s.clientFirstBare + "," + serverFirst + "," + withoutProof
+	clientKey := scramHMAC(s.salted, "Client Key")
+	storedKey := sha256.Sum256(clientKey)
+	proof := scramHMAC(storedKey[:], s.auth)
+	for i := range proof {
+		proof[i] ^= clientKey[i]
+	}
+	return withoutProof + ",p=" + base64.StdEncoding.EncodeToString(proof), nil
+}
+
+func (s *scramClient) verifyServer(serverFinal string) bool {
+	signature, ok := strings.CutPrefix(serverFinal, "v=")
+	if !ok || s.salted == nil {
+		return false
+	}
+	want := scramHMAC(scramHMAC(s.salted, "Server Key"), s.auth)
+	got, err := base64.StdEncoding.DecodeString(signature)
+	return err == nil && hmac.Equal(got, want)
+}
@@ -0,0 +1,287 @@
+package utils
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/md5"
+	"crypto/pbkdf2"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"io"
+	"net"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestParsePgURL(t *testing.T) {
+	t.Setenv("PGUSER", "envuser")
+	t.Setenv("PGPASSWORD", "envpass")
+	t.Setenv("PGDATABASE", "")
+	tests := []struct {
+		url  string
+		want pgConfig
+	}{
+		{"postgres://alice:pw@db.example:6543/app?sslmode=require",
+			pgConfig{network: "tcp", addr: "db.example:6543", user: "alice", password: "pw", db: "app", sslMode: "require", serverName: "db.example"}},
+		{"postgres://db.example",
+			pgConfig{network: "tcp", addr: "db.example:5432", user: "envuser", password: "envpass", db: "envuser", sslMode: "prefer", serverName: "db.example"}},
+		{"postgresql:///app?host=/run/postgresql",
+			pgConfig{network: "unix", addr: "/run/postgresql/.s.PGSQL.5432", user: "envuser", password: "envpass", db: "app", sslMode: "disable"}},
+	}
+	for _, tt := range tests {
+		u, _ := url.Parse(tt.url)
+		got, err := parsePgURL(u)
+		if err != nil {
+			t.Errorf("parsePgURL(%s): %v", tt.url, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("parsePgURL(%s) = %+v, want %+v", tt.url, got, tt.want)
+		}
+	}
+	u, _ := url.Parse("postgres://h/db?sslmode=allow")
+	if _, err := parsePgURL(u); err == nil {
+		t.Error("an unsupported sslmode was accepted")
+	}
+}
+
+// fakePg is a Postgres server with one user, enough for pgConn: it asks for
+// the configured authentication, then answers every query with rows, or an
+// error when the query contains FAIL.
+type fakePg struct {
+	auth     string // "scram", "md5", "cleartext" or "trust"
+	password string
+	rows     []string
+	// badServerSignature makes the SCRAM exchange end with a wrong signature.
+	badServerSignature bool
+	// noTLS makes the server decline TLS, and closeAtStart hang up at once.
+	noTLS, closeAtStart bool
+}
+
+func (f *fakePg) start(t *testing.T) string {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go f.serve(conn)
+		}
+	}()
+	return l.Addr().String()
+}
+
+func pgMessage(kind byte, body []byte) []byte {
+	b := binary.BigEndian.AppendUint32([]byte{kind}, uint32(len(body)+4))
+	return append(b, body...)
+}
+
+func pgAuth(code uint32, data []byte) []byte {
+	return pgMessage('R', append(binary.BigEndian.AppendUint32(nil, code), data...))
+}
+
+func pgFatal(message string) []byte {
+	return pgMessage('E', []byte("SFATAL\x00C28P01\x00M"+message+"\x00\x00"))
+}
+
+func (f *fakePg) serve(conn net.Conn) {
+	defer conn.Close()
+	if f.closeAtStart {
+		return
+	}
+	r := bufio.NewReader(conn)
+	readStartup := func() ([]byte, error) {
+		var size [4]byte
+		if _, err := io.ReadFull(r, size[:]); err != nil {
+			return nil, err
+		}
+		body := make([]byte, binary.BigEndian.Uint32(size[:])-4)
+		_, err := io.ReadFull(r, body)
+		return body, err
+	}
+	readMessage := func() (byte, []byte, error) {
+		kind, err := r.ReadByte()
+		if err != nil {
+			return 0, nil, err
+		}
+		body, err := readStartup()
+		return kind, body, err
+	}
+	startup, err := readStartup()
+	if err != nil {
+		return
+	}
+	if binary.BigEndian.Uint32(startup) == 80877103 {
+		if !f.noTLS {
+			// The tests only cover servers that decline TLS.
+			return
+		}
+		conn.Write([]byte("N"))
+		if startup, err = readStartup(); err != nil {
+			return
+		}
+	}
+	fields := bytes.Split(startup[4:], []byte{0})
+	user := ""
+	for i := 0; i+1 < len(fields); i += 2 {
+		if string(fields[i]) == "user" {
+			user = string(fields[i+1])
+		}
+	}
+
+	switch f.auth {
+	case "cleartext":
+		conn.Write(pgAuth(3, nil))
+		_, body, err := readMessage()
+		if err != nil || string(body) != f.password+"\x00" {
+			conn.Write(pgFatal("password authentication failed"))
+			return
+		}
+	case "md5":
+		salt := []byte{1, 2, 3, 4}
+		conn.Write(pgAuth(5, salt))
+		_, body, err := readMessage()
+		inner := md5.Sum([]byte(f.password + user))
+		outer := md5.Sum(append([]byte(hex.EncodeToString(inner[:])), salt...))
+		if err != nil || string(body) != "md5"+hex.EncodeToString(outer[:])+"\x00" {
+			conn.Write(pgFatal("password authentication failed"))
+			return
+		}
+	case "scram":
+		conn.Write(pgAuth(10, []byte("SCRAM-SHA-256\x00\x00")))
+		_, body, err := readMessage()
+		if err != nil {
+			return
+		}
+		mechanism, rest, _ := bytes.Cut(body, []byte{0})
+		if string(mechanism) != "SCRAM-SHA-256" || len(rest) < 4 {
+			conn.Write(pgFatal("bad SASL initial response"))
+			return
+		}
+		clientFirstBare := strings.TrimPrefix(string(rest[4:]), "n,,")
+		clientNonce := strings.TrimPrefix(clientFirstBare, "n=,r=")
+		salt := []byte("0123456789abcdef")
+		serverFirst := "r=" + clientNonce + "servernonce,s=" + base64.StdEncoding.EncodeToString(salt) + ",i=4096"
+		conn.Write(pgAuth(11, []byte(serverFirst)))
+		_, body, err = readMessage()
+		if err != nil {
+			return
+		}
+		withoutProof, proof, _ := strings.Cut(string(body), ",p=")
+		salted, _ := pbkdf2.Key(sha256.New, f.password, salt, 4096, sha256.Size)
+		clientKey := scramHMAC(salted, "Client Key")
+		storedKey := sha256.Sum256(clientKey)
+		auth := clientFirstBare + "," + serverFirst + "," + withoutProof
+		signature := scramHMAC(storedKey[:], auth)
+		got, _ := base64.StdEncoding.DecodeString(proof)
+		for i := range got {
+			if i < len(signature) {
+				got[i] ^= signature[i]
+			}
+		}
+		if sum := sha256.Sum256(got); sum != storedKey || withoutProof != "c=biws,r="+clientNonce+"servernonce" {
+			conn.Write(pgFatal("password authentication failed"))
+			return
+		}
+		serverSignature := scramHMAC(scramHMAC(salted, "Server Key"), auth)
+		if f.badServerSignature {
+			serverSignature[0] ^= 1
+		}
+		conn.Write(pgAuth(12, []byte("v="+base64.StdEncoding.EncodeToString(serverSignature))))
+	}
+	conn.Write(append(append(pgAuth(0, nil), pgMessage('S', []byte("server_version\x0016\x00"))...), pgMessage('Z', []byte("I"))...))
+
+	for {
+		kind, body, err := readMessage()
+		if err != nil || kind == 'X' {
+			return
+		}
+		var reply []byte
+		if strings.Contains(string(body), "FAIL") {
+			reply = pgMessage('E', []byte("SERROR\x00C42601\x00Msyntax error at FAIL\x00\x00"))
+		} else {
+			for _, row := range f.rows {
+				data := binary.BigEndian.AppendUint16(nil, 1)
+				data = binary.BigEndian.AppendUint32(data, uint32(len(row)))
+				reply = append(reply, pgMessage('D', append(data, row...))...)
+			}
+			// A NULL column.
+			reply = append(reply, pgMessage('D', []byte{0, 1, 0xff, 0xff, 0xff, 0xff})...)
+			reply = append(reply, pgMessage('C', []byte("SELECT 1\x00"))...)
+		}
+		conn.Write(append(reply, pgMessage('Z', []byte("I"))...))
+	}
+}
+
+func dialFakePg(t *testing.T, f *fakePg, password, sslMode string) (*pgConn, error) {
+	t.Helper()
+	addr := f.start(t)
+	return dialPg(context.Background(), pgConfig{network: "tcp", addr: addr, user: "alice", password: password, db: "app", sslMode: sslMode})
+}
+
+func TestPgAuthentication(t *testing.T) {
+	for _, auth := range []string{"scram", "md5", "cleartext", "trust"} {
+		t.Run(auth, func(t *testing.T) {
+			f := &fakePg{auth: auth, password: "s3cret", rows: []string{"one", "two"}, noTLS: true}
+			c, err := dialFakePg(t, f, "s3cret", "prefer")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer c.conn.Close()
+			rows, err := c.query(context.Background(), "SELECT 1")
+			if err != nil || strings.Join(rows, ",") != "one,two," {
+				t.Errorf("query = %q, %v", rows, err)
+			}
+			if auth == "trust" {
+				return
+			}
+			if _, err := dialFakePg(t, f, "wrong", "disable"); err == nil || !strings.Contains(err.Error(), "password authentication failed") {
+				t.Errorf("a wrong password gave %v", err)
+			}
+		})
+	}
+}
+
+func TestPgSCRAMServerSignature(t *testing.T) {
+	f := &fakePg{auth: "scram", password: "s3cret", badServerSignature: true}
+	if _, err := dialFakePg(t, f, "s3cret", "disable"); err == nil || !strings.Contains(err.Error(), "SCRAM signature") {
+		t.Errorf("a wrong server signature gave %v", err)
+	}
+}
+
+func TestPgQueryError(t *testing.T) {
+	f := &fakePg{auth: "trust", rows: []string{"ok"}}
+	c, err := dialFakePg(t, f, "", "disable")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.conn.Close()
+	_, err = c.query(context.Background(), "SELECT FAIL")
+	if pgErr, ok := err.(pgError); !ok || pgErr.code != "42601" || pgErr.message != "syntax error at FAIL" {
+		t.Fatalf("a failing query gave %#v", err)
+	}
+	// The connection is still in step after the error.
+	if rows, err := c.query(context.Background(), "SELECT 1"); err != nil || len(rows) != 2 || rows[0] != "ok" {
+		t.Errorf("query after an error = %q, %v", rows, err)
+	}
+}
+
+func TestPgStartTLS(t *testing.T) {
+	if _, err := dialFakePg(t, &fakePg{auth: "trust", noTLS: true}, "", "require"); err == nil || !strings.Contains(err.Error(), "does not accept TLS") {
+		t.Errorf("sslmode=require against a server without TLS gave %v", err)
+	}
+	// An I/O failure is reported as such, not as a server without TLS.
+	if _, err := dialFakePg(t, &fakePg{closeAtStart: true}, "", "require"); err == nil || strings.Contains(err.Error(), "does not accept TLS") {
+		t.Errorf("a closed connection gave %v", err)
+	}
+}
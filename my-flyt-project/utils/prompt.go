@@ -0,0 +1,50 @@
+package utils
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/template"
+)
+
+// PromptParts are the pieces a chat answer's prompt is assembled from.
+type PromptParts struct {
+	Context   string // left empty by chat answers, which send the context as LLMConfig.System
+	Retrieved string // knowledge-base passages numbered [n], empty when none
+	History   string // earlier turns formatted by FormatHistory, empty when none
+	Question  string
+}
+
+// DefaultPromptTemplate is the built-in layout of a chat prompt.
+const DefaultPromptTemplate = `{{if .Context}}Context: {{.Context}}
+{{end}}
+{{- if .Retrieved}}Relevant passages from the team's documents. Prefer them over general knowledge and cite them as [n]:
+{{.Retrieved}}
+{{end}}
+{{- if .History}}History:
+{{.History}}{{end}}Answer this question: {{.Question}}`
+
+// BuildPrompt assembles parts with the template for model. When the
+// PROMPT_TEMPLATES directory is set, <model>.tmpl and then default.tmpl in it
+// override DefaultPromptTemplate, so each model can get the framing it follows best.
+func BuildPrompt(parts PromptParts, model string) (string, error) {
+	text := DefaultPromptTemplate
+	if dir := os.Getenv("PROMPT_TEMPLATES"); dir != "" {
+		for _, name := range []string{model + ".tmpl", "default.tmpl"} {
+			if custom, err := os.ReadFile(filepath.Join(dir, name)); err == nil {
+				text = string(custom)
+				break
+			}
+		}
+	}
+	tmpl, err := template.New("prompt").Parse(text)
+	if err != nil {
+		return "", fmt.Errorf("invalid prompt template: %w", err)
+	}
+	var b strings.Builder
+	if err := tmpl.Execute(&b, parts); err != nil {
+		return "", fmt.Errorf("failed to assemble prompt: %w", err)
+	}
+	return b.String(), nil
+}
@@ -0,0 +1,71 @@
+package utils
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// QuizQuestion is a question about one passage, with the answer the passage gives.
+type QuizQuestion struct {
+	Question string `json:"question"`
+	Answer   string `json:"answer"`
+}
+
+// QuizQuestionSchema is the structured-output schema of a QuizQuestion.
+var QuizQuestionSchema = map[string]any{
+	"type":     "object",
+	"required": []string{"question", "answer"},
+	"properties": map[string]any{
+		"question": map[string]any{"type": "string", "minLength": 5},
+		"answer":   map[string]any{"type": "string", "minLength": 1},
+	},
+}
+
+// QuizGrade is the verdict on a free-text answer.
+type QuizGrade struct {
+	Verdict  string `json:"verdict"` // correct, partial or incorrect
+	Feedback string `json:"feedback"`
+	// FollowUp is a guiding question for another try, for partial and incorrect answers.
+	FollowUp string `json:"follow_up,omitempty"`
+}
+
+// QuizGradeSchema is the structured-output schema of a QuizGrade.
+var QuizGradeSchema = map[string]any{
+	"type":     "object",
+	"required": []string{"verdict", "feedback"},
+	"properties": map[string]any{
+		"verdict":   map[string]any{"type": "string", "enum": []string{"correct", "partial", "incorrect"}},
+		"feedback":  map[string]any{"type": "string"},
+		"follow_up": map[string]any{"type": "string"},
+	},
+}
+
+// Points is what the verdict adds to the score.
+func (g QuizGrade) Points() float64 {
+	return map[string]float64{"correct": 1, "partial": 0.5}[g.Verdict]
+}
+
+// QuizScore is the running score of a quiz session.
+type QuizScore struct {
+	Asked  int
+	Points float64
+}
+
+func (s QuizScore) String() string {
+	if s.Asked == 0 {
+		return "no questions answered"
+	}
+	return fmt.Sprintf("%g/%d (%.0f%%)", s.Points, s.Asked, 100*s.Points/float64(s.Asked))
+}
+
+// DecodeStructured checks reply against schema and unmarshals it into out.
+func DecodeStructured(reply string, schema map[string]any, out any) error {
+	var value any
+	if err := json.Unmarshal([]byte(ExtractJSON(reply)), &value); err != nil {
+		return fmt.Errorf("reply is not valid JSON: %w", err)
+	}
+	if err := ValidateJSONSchema(value, schema); err != nil {
+		return fmt.Errorf("reply %w", err)
+	}
+	return json.Unmarshal([]byte(ExtractJSON(reply)), out)
+}
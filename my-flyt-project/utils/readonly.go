@@ -0,0 +1,23 @@
+package utils
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ReadOnly disables the tools that change something outside the
+// conversation: file writes, shell commands, and HTTP requests that create or
+// modify data. It is set by -readonly, for flows over untrusted input, and
+// no config file or environment variable turns it off.
+var ReadOnly bool
+
+// ErrReadOnly is the error of a mutating tool called in read-only mode.
+var ErrReadOnly = errors.New("disabled by -readonly")
+
+// CheckWritable returns an error naming action when ReadOnly is set.
+func CheckWritable(action string) error {
+	if ReadOnly {
+		return fmt.Errorf("%s: %w", action, ErrReadOnly)
+	}
+	return nil
+}
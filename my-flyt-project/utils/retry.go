@@ -0,0 +1,90 @@
+package utils
+
+import (
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// MaxRetries is how many times a request that was rate limited or hit a
+// transient server error is retried before its error is returned.
+var MaxRetries = 3
+
+// RetryBaseDelay is the wait before the first retry; it doubles with each
+// further one, up to RetryMaxDelay.
+var (
+	RetryBaseDelay = time.Second
+	RetryMaxDelay  = 30 * time.Second
+)
+
+// retryable reports whether a response with this status may succeed if the
+// request is sent again.
+func retryable(status int) bool {
+	switch status {
+	case http.StatusTooManyRequests, http.StatusInternalServerError, http.StatusBadGateway,
+		http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
+
+// retryDelay is the wait before retry number attempt (from 0): the
+// Retry-After of the response when it gives one, otherwise the exponential
+// backoff with full jitter.
+func retryDelay(resp *http.Response, attempt int) time.Duration {
+	if resp != nil {
+		if after := resp.Header.Get("Retry-After"); after != "" {
+			if seconds, err := strconv.Atoi(after); err == nil && seconds >= 0 {
+				return min(time.Duration(seconds)*time.Second, RetryMaxDelay)
+			}
+			if at, err := http.ParseTime(after); err == nil {
+				return min(max(time.Until(at), 0), RetryMaxDelay)
+			}
+		}
+	}
+	backoff := min(RetryBaseDelay<<attempt, RetryMaxDelay)
+	if backoff <= 0 {
+		return 0
+	}
+	return rand.N(backoff) + 1
+}
+
+// DoWithRetry sends req with client and retries it on 429 and transient 5xx
+// responses and on network errors, up to MaxRetries times. The last response
+// is returned as is for the caller to check its status. Requests with a body
+// must be built with http.NewRequest from a bytes.Buffer or bytes.Reader so
+// the body can be sent again.
+func DoWithRetry(client *http.Client, req *http.Request) (*http.Response, error) {
+	for attempt := 0; ; attempt++ {
+		resp, err := client.Do(req)
+		if attempt >= MaxRetries || (err == nil && !retryable(resp.StatusCode)) || (req.Body != nil && req.GetBody == nil) {
+			return resp, err
+		}
+		if req.Context().Err() != nil {
+			return resp, err
+		}
+		delay := retryDelay(resp, attempt)
+		if err != nil {
+			PrintWarning("Request failed (%v); retrying in %s (%d/%d)", err, delay.Round(100*time.Millisecond), attempt+1, MaxRetries)
+		} else {
+			io.Copy(io.Discard, resp.Body)
+			resp.Body.Close()
+			PrintWarning("Request failed with status %d; retrying in %s (%d/%d)", resp.StatusCode, delay.Round(100*time.Millisecond), attempt+1, MaxRetries)
+		}
+		select {
+		case <-time.After(delay):
+		case <-req.Context().Done():
+			return nil, fmt.Errorf("request canceled while waiting to retry: %w", req.Context().Err())
+		}
+		if req.GetBody != nil {
+			body, err := req.GetBody()
+			if err != nil {
+				return nil, fmt.Errorf("failed to resend request body: %w", err)
+			}
+			req.Body = body
+		}
+	}
+}
@@ -0,0 +1,93 @@
+package utils
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// RouterLongQuestionTokens is the question size above which the router goes
+// straight to the strong model.
+const RouterLongQuestionTokens = 200
+
+// ModelRouter sends simple questions to a cheap model and the rest, or a
+// cheap answer that fails CheckCheapAnswer, to the strong one.
+type ModelRouter struct {
+	Cheap string
+	Stats RouterStats
+}
+
+// RouterStats counts where the router sent a session's questions and what
+// that saved against sending every one to the strong model.
+type RouterStats struct {
+	Cheap     int // answered by the cheap model
+	Escalated int // tried on the cheap model, answered by the strong one
+	Strong    int // sent straight to the strong model
+	Saved     float64
+}
+
+func (s RouterStats) String() string {
+	return fmt.Sprintf("%d cheap, %d escalated, %d strong; saved ≈$%.4f", s.Cheap, s.Escalated, s.Strong, s.Saved)
+}
+
+// codePattern matches what makes a question code-heavy: fences, stack
+// traces and lines that look like source.
+var codePattern = regexp.MustCompile("```" +
+	`|(?m)^\s*(?:func|def|class|import|package|public|private|#include|fn|let|const|var)\b` +
+	`|Traceback \(most recent call last\)|\bat [\w.$]+\([\w.]+:\d+\)|panic: |[{};]\s*$`)
+
+// Route picks the model for question: the strong one for long or code-heavy
+// questions, otherwise the cheap one. The reason is shown to the user.
+func (r *ModelRouter) Route(question, strong string) (model, reason string) {
+	switch {
+	case CountTokens(question) > RouterLongQuestionTokens:
+		return strong, "long question"
+	case len(codePattern.FindAllStringIndex(question, 3)) >= 2 || strings.Contains(question, "```"):
+		return strong, "code"
+	}
+	return r.Cheap, "simple question"
+}
+
+// hedgePattern matches answers where the model says it does not know.
+var hedgePattern = regexp.MustCompile(`(?i)\b(?:i'?m not (?:sure|certain)|i am not (?:sure|certain)|i don'?t know|i do not know|` +
+	`i (?:cannot|can'?t) (?:answer|help|determine|say)|unable to (?:answer|determine|provide)|as an ai\b|` +
+	`(?:no|insufficient|not enough) information)`)
+
+// CheckCheapAnswer is the guardrail a cheap answer must pass to be kept: it
+// is not empty, does not hedge, and its code blocks are closed.
+func CheckCheapAnswer(answer string) error {
+	answer = strings.TrimSpace(answer)
+	switch {
+	case len(answer) < 2:
+		return fmt.Errorf("empty answer")
+	case hedgePattern.MatchString(answer):
+		return fmt.Errorf("the answer is unsure")
+	case strings.Count(answer, "```")%2 != 0:
+		return fmt.Errorf("unclosed code block")
+	}
+	return nil
+}
+
+// Record adds one routed question to the stats. For answers kept from the
+// cheap model the saving is what the strong model would have cost; for
+// escalated ones the cheap attempt is a loss. Costs are estimated from the
+// prompt and answer sizes.
+func (r *ModelRouter) Record(outcome, strong string, promptTokens, answerTokens int) {
+	cost := func(model string) float64 {
+		pricing, ok := PricingForModel(model)
+		if !ok {
+			return 0
+		}
+		return float64(promptTokens)/1e6*pricing.InputPerMillion + float64(answerTokens)/1e6*pricing.OutputPerMillion
+	}
+	switch outcome {
+	case "cheap":
+		r.Stats.Cheap++
+		r.Stats.Saved += cost(strong) - cost(r.Cheap)
+	case "escalated":
+		r.Stats.Escalated++
+		r.Stats.Saved -= cost(r.Cheap)
+	default:
+		r.Stats.Strong++
+	}
+}
@@ -0,0 +1,411 @@
+package utils
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Sandbox is a copy-on-write shadow of a workspace: file edits go to a
+// temporary copy, and the real files change only when Apply is called, all
+// together. Discard throws the edits away.
+type Sandbox struct {
+	Root string
+	dir  string
+	// originals are the contents of the edited files when first touched, to
+	// diff against and to check nothing else changed them before Apply.
+	originals map[string][]byte
+	created   map[string]bool
+	// checkout is the writable copy of the workspace made by Checkout, and
+	// stats the size and time of each file in it when last synced.
+	checkout string
+	stats    map[string]fileStat
+}
+
+// fileStat is what Sync compares to notice a changed file without reading it.
+type fileStat struct {
+	size    int64
+	modTime time.Time
+}
+
+// MaxCheckoutBytes bounds the workspace Checkout copies.
+const MaxCheckoutBytes = 256 << 20
+
+// NewSandbox returns an empty sandbox over the workspace at root.
+func NewSandbox(root string) (*Sandbox, error) {
+	root, err := filepath.Abs(root)
+	if err != nil {
+		return nil, err
+	}
+	dir, err := os.MkdirTemp("", "ai-sandbox-*")
+	if err != nil {
+		return nil, fmt.Errorf("could not create sandbox: %w", err)
+	}
+	return &Sandbox{Root: root, dir: dir, originals: map[string][]byte{}, created: map[string]bool{}}, nil
+}
+
+// rel checks that path is inside the workspace and returns it relative to
+// the root.
+func (s *Sandbox) rel(path string) (string, error) {
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(s.Root, path)
+	}
+	rel, err := filepath.Rel(s.Root, filepath.Clean(path))
+	if err != nil || rel == "." || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("%s is outside the workspace %s", path, s.Root)
+	}
+	return rel, nil
+}
+
+// ReadFile returns the sandbox's version of path: the edited copy if there
+// is one, otherwise the workspace file.
+func (s *Sandbox) ReadFile(path string) ([]byte, error) {
+	rel, err := s.rel(path)
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := s.originals[rel]; ok {
+		return os.ReadFile(filepath.Join(s.dir, rel))
+	}
+	return os.ReadFile(filepath.Join(s.Root, rel))
+}
+
+// WriteFile writes content to the shadow copy of path, leaving the
+// workspace untouched.
+func (s *Sandbox) WriteFile(path string, content []byte) error {
+	rel, err := s.rel(path)
+	if err != nil {
+		return err
+	}
+	if _, ok := s.originals[rel]; !ok {
+		original, err := os.ReadFile(filepath.Join(s.Root, rel))
+		switch {
+		case errors.Is(err, os.ErrNotExist):
+			s.created[rel] = true
+		case err != nil:
+			return err
+		}
+		s.originals[rel] = original
+	}
+	shadow := filepath.Join(s.dir, rel)
+	if err := os.MkdirAll(filepath.Dir(shadow), 0700); err != nil {
+		return err
+	}
+	return os.WriteFile(shadow, content, 0600)
+}
+
+// Changed lists the files whose shadow copy differs from the original.
+func (s *Sandbox) Changed() []string {
+	var changed []string
+	for rel, original := range s.originals {
+		if content, err := os.ReadFile(filepath.Join(s.dir, rel)); err == nil && (s.created[rel] || !bytes.Equal(content, original)) {
+			changed = append(changed, rel)
+		}
+	}
+	slices.Sort(changed)
+	return changed
+}
+
+// Diff returns one unified diff of every changed file, with a/ and b/ paths
+// relative to the workspace as git prints them.
+func (s *Sandbox) Diff() (string, error) {
+	if _, err := exec.LookPath("diff"); err != nil {
+		return "", fmt.Errorf("diff is not installed")
+	}
+	var out strings.Builder
+	for _, rel := range s.Changed() {
+		original := filepath.Join(s.Root, rel)
+		from := "a/" + filepath.ToSlash(rel)
+		if s.created[rel] {
+			original, from = os.DevNull, os.DevNull
+		}
+		cmd := exec.Command("diff", "-u", "--label", from, "--label", "b/"+filepath.ToSlash(rel), original, filepath.Join(s.dir, rel))
+		diff, err := cmd.Output()
+		// diff exits with 1 when the files differ.
+		var exitErr *exec.ExitError
+		if err != nil && !(errors.As(err, &exitErr) && exitErr.ExitCode() == 1) {
+			return "", fmt.Errorf("could not diff %s: %w", rel, err)
+		}
+		out.Write(diff)
+	}
+	return out.String(), nil
+}
+
+// Apply writes every changed file to the workspace. It refuses when a file
+// was changed on disk since the sandbox read it, and writes each file to a
+// temporary name first and renames them only when all were written, so the
+// workspace gets either all edits or none.
+func (s *Sandbox) Apply() error {
+	if err := CheckWritable("applying file edits"); err != nil {
+		return err
+	}
+	changed := s.Changed()
+	for _, rel := range changed {
+		current, err := os.ReadFile(filepath.Join(s.Root, rel))
+		if s.created[rel] {
+			if err == nil {
+				return fmt.Errorf("%s was created since the edit was made; nothing applied", rel)
+			}
+			continue
+		}
+		if err != nil || !bytes.Equal(current, s.originals[rel]) {
+			return fmt.Errorf("%s changed since the edit was made; nothing applied", rel)
+		}
+	}
+
+	temps := map[string]string{}
+	cleanup := func() {
+		for _, tmp := range temps {
+			os.Remove(tmp)
+		}
+	}
+	for _, rel := range changed {
+		target := filepath.Join(s.Root, rel)
+		content, err := os.ReadFile(filepath.Join(s.dir, rel))
+		if err == nil {
+			err = os.MkdirAll(filepath.Dir(target), 0755)
+		}
+		mode := os.FileMode(0644)
+		if info, statErr := os.Stat(target); statErr == nil {
+			mode = info.Mode().Perm()
+		}
+		tmp := target + ".ai-sandbox.tmp"
+		if err == nil {
+			err = os.WriteFile(tmp, content, mode)
+		}
+		if err != nil {
+			cleanup()
+			return fmt.Errorf("could not write %s; nothing applied: %w", rel, err)
+		}
+		temps[rel] = tmp
+	}
+	for i, rel := range changed {
+		if err := os.Rename(temps[rel], filepath.Join(s.Root, rel)); err != nil {
+			cleanup()
+			// Put back the files already replaced.
+			for _, done := range changed[:i] {
+				if s.created[done] {
+					os.Remove(filepath.Join(s.Root, done))
+				} else {
+					os.WriteFile(filepath.Join(s.Root, done), s.originals[done], 0644)
+				}
+			}
+			return fmt.Errorf("could not replace %s; nothing applied: %w", rel, err)
+		}
+		delete(temps, rel)
+	}
+	return s.Discard()
+}
+
+// Discard deletes the shadow copy without touching the workspace.
+func (s *Sandbox) Discard() error {
+	s.originals, s.created = map[string][]byte{}, map[string]bool{}
+	if s.checkout != "" {
+		os.RemoveAll(s.checkout)
+		s.checkout, s.stats = "", nil
+	}
+	return os.RemoveAll(s.dir)
+}
+
+// mayHoldSecrets reports whether a file named base usually holds secrets:
+// .env files and private keys.
+func mayHoldSecrets(base string) bool {
+	return base == ".env" || strings.HasPrefix(base, ".env.") || (strings.HasPrefix(base, "id_") && !strings.HasSuffix(base, ".pub")) || strings.HasSuffix(base, ".pem") || strings.HasSuffix(base, ".key")
+}
+
+// Checkout returns a writable copy of the sandbox's version of the
+// workspace, made on first use, for programs that change files themselves.
+// Files that may hold secrets are left out. Sync turns what they changed
+// into edits of the sandbox.
+func (s *Sandbox) Checkout() (string, error) {
+	if s.checkout != "" {
+		return s.checkout, nil
+	}
+	dir, err := os.MkdirTemp("", "ai-checkout-*")
+	if err != nil {
+		return "", fmt.Errorf("could not create checkout: %w", err)
+	}
+	var total int64
+	err = filepath.WalkDir(s.Root, func(path string, d os.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, _ := filepath.Rel(s.Root, path)
+		target := filepath.Join(dir, rel)
+		switch {
+		case path == dir || path == s.dir:
+			// A workspace above the temporary directory holds the copies.
+			return filepath.SkipDir
+		case d.IsDir():
+			return os.MkdirAll(target, 0700)
+		case d.Type()&os.ModeSymlink != 0:
+			link, err := os.Readlink(path)
+			if err != nil {
+				return err
+			}
+			return os.Symlink(link, target)
+		case !d.Type().IsRegular() || mayHoldSecrets(d.Name()):
+			return nil
+		}
+		content, err := s.ReadFile(rel)
+		if err != nil {
+			return err
+		}
+		if total += int64(len(content)); total > MaxCheckoutBytes {
+			return fmt.Errorf("the workspace is over %d MiB", MaxCheckoutBytes>>20)
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		return os.WriteFile(target, content, info.Mode().Perm())
+	})
+	// Files the sandbox created are not in the workspace yet.
+	for rel := range s.created {
+		if err == nil && !mayHoldSecrets(filepath.Base(rel)) {
+			var content []byte
+			if content, err = s.ReadFile(rel); err == nil {
+				if err = os.MkdirAll(filepath.Dir(filepath.Join(dir, rel)), 0700); err == nil {
+					err = os.WriteFile(filepath.Join(dir, rel), content, 0644)
+				}
+			}
+		}
+	}
+	if err != nil {
+		os.RemoveAll(dir)
+		return "", fmt.Errorf("could not copy the workspace: %w", err)
+	}
+	s.checkout, s.stats = dir, map[string]fileStat{}
+	if _, _, err := s.Sync(); err != nil {
+		return "", err
+	}
+	return dir, nil
+}
+
+// Sync records the files changed in the checkout since the last sync as
+// edits, and returns them with the files deleted there, which the sandbox
+// cannot delete in the workspace. Files that may hold secrets are ignored.
+func (s *Sandbox) Sync() (changed, deleted []string, err error) {
+	seen := map[string]bool{}
+	err = filepath.WalkDir(s.checkout, func(path string, d os.DirEntry, err error) error {
+		if err != nil || !d.Type().IsRegular() || mayHoldSecrets(d.Name()) {
+			return err
+		}
+		rel, _ := filepath.Rel(s.checkout, path)
+		seen[rel] = true
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		stat := fileStat{info.Size(), info.ModTime()}
+		if old, ok := s.stats[rel]; ok && old == stat {
+			return nil
+		}
+		s.stats[rel] = stat
+		content, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		if current, err := s.ReadFile(rel); err == nil && bytes.Equal(current, content) {
+			return nil
+		}
+		changed = append(changed, rel)
+		return s.WriteFile(rel, content)
+	})
+	for rel := range s.stats {
+		if !seen[rel] {
+			deleted = append(deleted, rel)
+			delete(s.stats, rel)
+		}
+	}
+	slices.Sort(deleted)
+	return changed, deleted, err
+}
+
+var (
+	editQuestionPattern = regexp.MustCompile(`(?i)^\s*(?:please\s+|can you\s+|could you\s+)?(?:edit|change|modify|update|refactor|fix|rename|add|remove|delete|rewrite|implement|replace)\b`)
+	filePathPattern     = regexp.MustCompile(`[\w./-]+\.[A-Za-z0-9]+`)
+)
+
+// DetectEditQuestion returns the files, relative to workspace, that a
+// request to change code names, such as "rename parseConfig in config.go to
+// loadConfig". Only files that exist count.
+func DetectEditQuestion(question, workspace string) ([]string, bool) {
+	if !editQuestionPattern.MatchString(question) {
+		return nil, false
+	}
+	var files []string
+	for _, name := range filePathPattern.FindAllString(question, -1) {
+		name = strings.TrimRight(name, ".")
+		for _, path := range []string{name, filepath.Join(workspace, name)} {
+			info, err := os.Stat(path)
+			if err != nil || !info.Mode().IsRegular() {
+				continue
+			}
+			if abs, err := filepath.Abs(path); err == nil {
+				if rel, err := filepath.Rel(workspace, abs); err == nil && !strings.HasPrefix(rel, "..") && !slices.Contains(files, rel) {
+					files = append(files, rel)
+				}
+			}
+			break
+		}
+	}
+	return files, len(files) > 0
+}
+
+// MaxReadFileBytes bounds what ReadWorkspaceFile returns.
+const MaxReadFileBytes = 200_000
+
+// ReadWorkspaceFile reads a text file inside the workspace at root, for a
+// tool the model calls. Paths that leave the workspace, symbolic links
+// included, are refused, as are binary files and files that usually hold
+// secrets (.env and private keys). Long files are cut at MaxReadFileBytes.
+func ReadWorkspaceFile(root, path string) (string, error) {
+	root, err := filepath.EvalSymlinks(root)
+	if err != nil {
+		return "", err
+	}
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(root, path)
+	}
+	resolved, err := filepath.EvalSymlinks(filepath.Clean(path))
+	if err != nil {
+		return "", fmt.Errorf("cannot read %s: %w", path, err)
+	}
+	rel, err := filepath.Rel(root, resolved)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("%s is outside the workspace %s", path, root)
+	}
+	if mayHoldSecrets(filepath.Base(resolved)) {
+		return "", fmt.Errorf("%s may hold secrets and is not read", rel)
+	}
+	f, err := os.Open(resolved)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	if info, err := f.Stat(); err != nil || !info.Mode().IsRegular() {
+		return "", fmt.Errorf("%s is not a regular file", rel)
+	}
+	data := make([]byte, MaxReadFileBytes+1)
+	n, err := io.ReadFull(f, data)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return "", err
+	}
+	data = data[:n]
+	if bytes.IndexByte(data, 0) >= 0 {
+		return "", fmt.Errorf("%s is a binary file", rel)
+	}
+	if n > MaxReadFileBytes {
+		return string(data[:MaxReadFileBytes]) + fmt.Sprintf("\n[... %s is cut after %d bytes]", rel, MaxReadFileBytes), nil
+	}
+	return string(data), nil
+}
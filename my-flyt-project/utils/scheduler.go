@@ -0,0 +1,163 @@
+package utils
+
+import (
+	"context"
+	"log"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Priority orders LLM requests that share one API quota.
+type Priority int
+
+const (
+	// PriorityInteractive is for requests a user is waiting on, such as chat answers.
+	PriorityInteractive Priority = iota
+	// PriorityBackground is for batch and scheduled work; it yields to interactive requests.
+	PriorityBackground
+)
+
+// Scheduler spaces LLM requests to stay within a requests-per-minute quota.
+// Interactive requests always take the next free slot before background ones,
+// and background requests are further limited in how many run at once.
+type Scheduler struct {
+	mu                 sync.Mutex
+	interval           time.Duration
+	next               time.Time
+	maxBackground      int
+	backgroundInFlight int
+	waitingInteractive int
+	changed            chan struct{}
+	// shared, when set, counts every replica's requests against sharedRPM.
+	shared    StateStore
+	sharedRPM int64
+}
+
+// NewScheduler creates a Scheduler allowing requestsPerMinute requests (0 for no
+// limit) of which at most maxBackground background requests run concurrently (0 for no limit).
+func NewScheduler(requestsPerMinute, maxBackground int) *Scheduler {
+	s := &Scheduler{maxBackground: maxBackground, changed: make(chan struct{})}
+	if requestsPerMinute > 0 {
+		s.interval = time.Minute / time.Duration(requestsPerMinute)
+	}
+	return s
+}
+
+// Share makes the replicas that use store split one requestsPerMinute quota:
+// each request is also counted in the store's counter for the current
+// minute, and once the quota is spent it waits for the next minute. Each
+// replica still spaces its own requests as NewScheduler was told.
+func (s *Scheduler) Share(store StateStore, requestsPerMinute int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.shared, s.sharedRPM = store, int64(requestsPerMinute)
+}
+
+// waitShared waits until the shared quota has room for one more request. An
+// unreachable store lets the request through rather than stopping every
+// replica.
+func (s *Scheduler) waitShared(ctx context.Context) error {
+	s.mu.Lock()
+	store, limit := s.shared, s.sharedRPM
+	s.mu.Unlock()
+	if store == nil || limit <= 0 {
+		return nil
+	}
+	for {
+		minute := time.Now().Truncate(time.Minute)
+		n, err := store.Incr(ctx, "rpm:"+strconv.FormatInt(minute.Unix(), 10), 1, 2*time.Minute)
+		if err != nil {
+			log.Printf("shared rate limit unavailable, not applied: %v", err)
+			return nil
+		}
+		if n <= limit {
+			return nil
+		}
+		timer := time.NewTimer(time.Until(minute.Add(time.Minute)))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
+
+// DefaultScheduler is used by the CallLLM helpers. Replace it to change the quota.
+var DefaultScheduler = NewScheduler(0, 2)
+
+// Acquire waits for a slot for a request of priority p and returns a function
+// that must be called once the request has finished.
+func (s *Scheduler) Acquire(ctx context.Context, p Priority) (release func(), err error) {
+	s.mu.Lock()
+	if p == PriorityInteractive {
+		s.waitingInteractive++
+	}
+	for {
+		now := time.Now()
+		blocked := p == PriorityBackground &&
+			(s.waitingInteractive > 0 || (s.maxBackground > 0 && s.backgroundInFlight >= s.maxBackground))
+		if !blocked && !now.Before(s.next) {
+			break
+		}
+
+		wait := time.Hour
+		if !blocked {
+			wait = s.next.Sub(now)
+		}
+		changed := s.changed
+		s.mu.Unlock()
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			s.mu.Lock()
+			if p == PriorityInteractive {
+				s.waitingInteractive--
+				s.notify()
+			}
+			s.mu.Unlock()
+			return nil, ctx.Err()
+		case <-changed:
+		case <-timer.C:
+		}
+		timer.Stop()
+		s.mu.Lock()
+	}
+
+	if s.interval > 0 {
+		s.next = time.Now().Add(s.interval)
+	}
+	if p == PriorityInteractive {
+		s.waitingInteractive--
+	} else {
+		s.backgroundInFlight++
+	}
+	s.notify()
+	s.mu.Unlock()
+
+	var once sync.Once
+	release = func() {
+		once.Do(func() {
+			if p == PriorityBackground {
+				s.mu.Lock()
+				s.backgroundInFlight--
+				s.notify()
+				s.mu.Unlock()
+			}
+		})
+	}
+	if err := s.waitShared(ctx); err != nil {
+		release()
+		return nil, err
+	}
+	return release, nil
+}
+
+// notify wakes every waiter so it re-checks its condition. Callers hold s.mu.
+func (s *Scheduler) notify() {
+	close(s.changed)
+	s.changed = make(chan struct{})
+}
@@ -0,0 +1,115 @@
+package utils
+
+import (
+	"crypto/pbkdf2"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"math/bits"
+)
+
+// scryptKey derives a keyLen-byte key from password and salt with scrypt
+// (RFC 7914). N, the CPU and memory cost, must be a power of two above 1;
+// the memory used is 128*N*r bytes.
+func scryptKey(password, salt []byte, N, r, p, keyLen int) ([]byte, error) {
+	if N <= 1 || N&(N-1) != 0 {
+		return nil, fmt.Errorf("scrypt: N must be a power of two above 1")
+	}
+	if r <= 0 || p <= 0 || uint64(r)*uint64(p) >= 1<<30 || r > (1<<31-1)/128/p || N > (1<<31-1)/128/r {
+		return nil, fmt.Errorf("scrypt: parameters are too large")
+	}
+
+	b, err := pbkdf2.Key(sha256.New, string(password), salt, 1, p*128*r)
+	if err != nil {
+		return nil, err
+	}
+	x := make([]uint32, 32*r)
+	v := make([]uint32, 32*r*N)
+	scratch := make([]uint32, 32*r)
+	for i := 0; i < p; i++ {
+		block := b[i*128*r : (i+1)*128*r]
+		for j := range x {
+			x[j] = binary.LittleEndian.Uint32(block[j*4:])
+		}
+		scryptROMix(x, v, scratch, N, r)
+		for j, w := range x {
+			binary.LittleEndian.PutUint32(block[j*4:], w)
+		}
+	}
+	return pbkdf2.Key(sha256.New, string(password), b, 1, keyLen)
+}
+
+// scryptROMix mixes x, 32*r words, in place using v as the N-block table.
+func scryptROMix(x, v, scratch []uint32, N, r int) {
+	size := 32 * r
+	for i := 0; i < N; i++ {
+		copy(v[i*size:], x)
+		scryptBlockMix(x, scratch, r)
+	}
+	for i := 0; i < N; i++ {
+		j := int(x[size-16] & uint32(N-1)) // Integerify: the first word of the last 64-byte block
+		for k := range x {
+			x[k] ^= v[j*size+k]
+		}
+		scryptBlockMix(x, scratch, r)
+	}
+}
+
+// scryptBlockMix applies BlockMix with Salsa20/8 to the 2*r 64-byte blocks
+// of b, using y as scratch space of the same size.
+func scryptBlockMix(b, y []uint32, r int) {
+	var t [16]uint32
+	copy(t[:], b[(2*r-1)*16:])
+	for i := 0; i < 2*r; i++ {
+		for k := range t {
+			t[k] ^= b[i*16+k]
+		}
+		salsa208(&t)
+		// Even blocks go to the first half, odd ones to the second.
+		copy(y[(i/2+(i%2)*r)*16:], t[:])
+	}
+	copy(b, y)
+}
+
+// salsa208 applies the Salsa20/8 core to the 64-byte block in b.
+func salsa208(b *[16]uint32) {
+	x := *b
+	for i := 0; i < 8; i += 2 {
+		x[4] ^= bits.RotateLeft32(x[0]+x[12], 7)
+		x[8] ^= bits.RotateLeft32(x[4]+x[0], 9)
+		x[12] ^= bits.RotateLeft32(x[8]+x[4], 13)
+		x[0] ^= bits.RotateLeft32(x[12]+x[8], 18)
+		x[9] ^= bits.RotateLeft32(x[5]+x[1], 7)
+		x[13] ^= bits.RotateLeft32(x[9]+x[5], 9)
+		x[1] ^= bits.RotateLeft32(x[13]+x[9], 13)
+		x[5] ^= bits.RotateLeft32(x[1]+x[13], 18)
+		x[14] ^= bits.RotateLeft32(x[10]+x[6], 7)
+		x[2] ^= bits.RotateLeft32(x[14]+x[10], 9)
+		x[6] ^= bits.RotateLeft32(x[2]+x[14], 13)
+		x[10] ^= bits.RotateLeft32(x[6]+x[2], 18)
+		x[3] ^= bits.RotateLeft32(x[15]+x[11], 7)
+		x[7] ^= bits.RotateLeft32(x[3]+x[15], 9)
+		x[11] ^= bits.RotateLeft32(x[7]+x[3], 13)
+		x[15] ^= bits.RotateLeft32(x[11]+x[7], 18)
+
+		x[1] ^= bits.RotateLeft32(x[0]+x[3], 7)
+		x[2] ^= bits.RotateLeft32(x[1]+x[0], 9)
+		x[3] ^= bits.RotateLeft32(x[2]+x[1], 13)
+		x[0] ^= bits.RotateLeft32(x[3]+x[2], 18)
+		x[6] ^= bits.RotateLeft32(x[5]+x[4], 7)
+		x[7] ^= bits.RotateLeft32(x[6]+x[5], 9)
+		x[4] ^= bits.RotateLeft32(x[7]+x[6], 13)
+		x[5] ^= bits.RotateLeft32(x[4]+x[7], 18)
+		x[11] ^= bits.RotateLeft32(x[10]+x[9], 7)
+		x[8] ^= bits.RotateLeft32(x[11]+x[10], 9)
+		x[9] ^= bits.RotateLeft32(x[8]+x[11], 13)
+		x[10] ^= bits.RotateLeft32(x[9]+x[8], 18)
+		x[12] ^= bits.RotateLeft32(x[15]+x[14], 7)
+		x[13] ^= bits.RotateLeft32(x[12]+x[15], 9)
+		x[14] ^= bits.RotateLeft32(x[13]+x[12], 13)
+		x[15] ^= bits.RotateLeft32(x[14]+x[13], 18)
+	}
+	for i := range b {
+		b[i] += x[i]
+	}
+}
@@ -0,0 +1,132 @@
+package utils
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Sealed files are gzip-compressed, and additionally encrypted with
+// AES-256-GCM when a passphrase is given.
+const (
+	SealedExt    = ".gz"
+	EncryptedExt = ".gz.enc"
+)
+
+// Encrypted files start with a header: sealMagic, the scrypt cost as log2 N,
+// r and p, and the random salt. The GCM nonce and ciphertext follow, with the
+// header authenticated along with them.
+const (
+	sealMagic    = "AIWSEAL\x01"
+	sealSaltSize = 16
+	sealHeader   = len(sealMagic) + 3 + sealSaltSize
+)
+
+// The scrypt cost of new files: 32 MiB and about 0.1s per key. Files may ask
+// for more, up to the limits checked in sealCipher.
+const (
+	sealLogN = 15
+	sealR    = 8
+	sealP    = 1
+)
+
+// Seal compresses data and, when passphrase is not empty, encrypts the result
+// with a key derived from it by scrypt with a random salt.
+func Seal(data []byte, passphrase string) ([]byte, error) {
+	var b bytes.Buffer
+	zw := gzip.NewWriter(&b)
+	if _, err := zw.Write(data); err != nil {
+		return nil, err
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	if passphrase == "" {
+		return b.Bytes(), nil
+	}
+
+	header := append([]byte(sealMagic), sealLogN, sealR, sealP)
+	header = append(header, make([]byte, sealSaltSize)...)
+	if _, err := rand.Read(header[len(header)-sealSaltSize:]); err != nil {
+		return nil, err
+	}
+	gcm, err := sealCipher(passphrase, header)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	sealed := append(header, nonce...)
+	return gcm.Seal(sealed, nonce, b.Bytes(), header), nil
+}
+
+// Unseal reverses Seal. name is the file the data came from; its extension
+// tells whether it is encrypted.
+func Unseal(data []byte, name, passphrase string) ([]byte, error) {
+	if strings.HasSuffix(name, EncryptedExt) {
+		if passphrase == "" {
+			return nil, fmt.Errorf("%s is encrypted; set CONVERSATION_KEY to read it", name)
+		}
+		// Files written before the header existed have none.
+		var header []byte
+		if bytes.HasPrefix(data, []byte(sealMagic)) {
+			if len(data) < sealHeader {
+				return nil, fmt.Errorf("%s is too short to be encrypted", name)
+			}
+			header, data = data[:sealHeader], data[sealHeader:]
+		}
+		gcm, err := sealCipher(passphrase, header)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", name, err)
+		}
+		if len(data) < gcm.NonceSize() {
+			return nil, fmt.Errorf("%s is too short to be encrypted", name)
+		}
+		data, err = gcm.Open(nil, data[:gcm.NonceSize()], data[gcm.NonceSize():], header)
+		if err != nil {
+			return nil, fmt.Errorf("could not decrypt %s (wrong CONVERSATION_KEY?)", name)
+		}
+	}
+	zr, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("could not decompress %s: %w", name, err)
+	}
+	defer zr.Close()
+	return io.ReadAll(zr)
+}
+
+// sealCipher returns the cipher for passphrase and a file header. Without a
+// header, the key is the SHA-256 of the passphrase, as in the first
+// encrypted files.
+func sealCipher(passphrase string, header []byte) (cipher.AEAD, error) {
+	var key []byte
+	if header == nil {
+		sum := sha256.Sum256([]byte(passphrase))
+		key = sum[:]
+	} else {
+		params := header[len(sealMagic):]
+		logN, r, p := int(params[0]), int(params[1]), int(params[2])
+		// A crafted header must not make reading it take gigabytes.
+		if logN < 1 || logN > 20 || r < 1 || r > 16 || p < 1 || p > 16 {
+			return nil, fmt.Errorf("unsupported scrypt parameters N=2^%d r=%d p=%d", logN, r, p)
+		}
+		var err error
+		key, err = scryptKey([]byte(passphrase), params[3:], 1<<logN, r, p, 32)
+		if err != nil {
+			return nil, err
+		}
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
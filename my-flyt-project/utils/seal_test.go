@@ -0,0 +1,87 @@
+package utils
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"strings"
+	"testing"
+)
+
+// The test vectors of RFC 7914, section 12, except the one that needs 1 GiB.
+func TestScryptKey(t *testing.T) {
+	tests := []struct {
+		password, salt string
+		N, r, p        int
+		want           string
+	}{
+		{"", "", 16, 1, 1, "77d6576238657b203b19ca42c18a0497f16b4844e3074ae8dfdffa3fede21442fcd0069ded0948f8326a753a0fc81f17e8d3e0fb2e0d3628cf35e20c38d18906"},
+		{"password", "NaCl", 1024, 8, 16, "fdbabe1c9d3472007856e7190d01e9fe7c6ad7cbc8237830e77376634b3731622eaf30d92e22a3886ff109279d9830dac727afb94a83ee6d8360cbdfa2cc0640"},
+		{"pleaseletmein", "SodiumChloride", 16384, 8, 1, "7023bdcb3afd7348461c06cd81fd38ebfda8fbba904f8e3ea9b543f6545da1f2d5432955613f0fcf62d49705242a9af9e61e85dc0d651e40dfcf017b45575887"},
+	}
+	for _, tt := range tests {
+		got, err := scryptKey([]byte(tt.password), []byte(tt.salt), tt.N, tt.r, tt.p, 64)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if hex.EncodeToString(got) != tt.want {
+			t.Errorf("scryptKey(%q, %q, %d, %d, %d) = %x", tt.password, tt.salt, tt.N, tt.r, tt.p, got)
+		}
+	}
+	if _, err := scryptKey(nil, nil, 1000, 8, 1, 32); err == nil {
+		t.Error("an N that is not a power of two was accepted")
+	}
+}
+
+func TestSeal(t *testing.T) {
+	data := []byte(strings.Repeat("a conversation ", 100))
+	sealed, err := Seal(data, "pass")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.HasPrefix(sealed, []byte(sealMagic)) {
+		t.Fatalf("sealed data starts with %q", sealed[:len(sealMagic)])
+	}
+	got, err := Unseal(sealed, "c"+EncryptedExt, "pass")
+	if err != nil || !bytes.Equal(got, data) {
+		t.Fatalf("Unseal = %q, %v", got, err)
+	}
+	if _, err := Unseal(sealed, "c"+EncryptedExt, "wrong"); err == nil {
+		t.Error("a wrong passphrase was accepted")
+	}
+
+	// Two files with the same passphrase get different salts.
+	again, _ := Seal(data, "pass")
+	if bytes.Equal(sealed[len(sealMagic)+3:sealHeader], again[len(sealMagic)+3:sealHeader]) {
+		t.Error("the salt was reused")
+	}
+
+	// The header is authenticated.
+	tampered := append([]byte(nil), sealed...)
+	tampered[sealHeader-1] ^= 1
+	if _, err := Unseal(tampered, "c"+EncryptedExt, "pass"); err == nil {
+		t.Error("a changed salt was accepted")
+	}
+	tampered = append([]byte(nil), sealed...)
+	tampered[len(sealMagic)] = 40
+	if _, err := Unseal(tampered, "c"+EncryptedExt, "pass"); err == nil || !strings.Contains(err.Error(), "unsupported scrypt parameters") {
+		t.Errorf("an expensive header gave %v", err)
+	}
+}
+
+func TestUnsealLegacy(t *testing.T) {
+	// Files written before the header existed used the SHA-256 of the
+	// passphrase as the key.
+	compressed, _ := Seal([]byte("old"), "")
+	gcm, err := sealCipher("pass", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	rand.Read(nonce)
+	legacy := gcm.Seal(nonce, nonce, compressed, nil)
+	got, err := Unseal(legacy, "c"+EncryptedExt, "pass")
+	if err != nil || string(got) != "old" {
+		t.Fatalf("Unseal = %q, %v", got, err)
+	}
+}
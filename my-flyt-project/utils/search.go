@@ -1,6 +1,7 @@
 package utils
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
@@ -49,7 +50,7 @@ func SearchWeb(query string) ([]SearchResult, error) {
 
 // SearchWebDuckDuckGo performs a real web search using DuckDuckGo Instant Answer API
 // Note: This API is limited and may not return results for all queries
-func SearchWebDuckDuckGo(query string) ([]SearchResult, error) {
+func SearchWebDuckDuckGo(ctx context.Context, query string) ([]SearchResult, error) {
 	apiURL := fmt.Sprintf("https://api.duckduckgo.com/?q=%s&format=json&no_html=1&skip_disambig=1",
 		url.QueryEscape(query))
 
@@ -57,7 +58,11 @@ func SearchWebDuckDuckGo(query string) ([]SearchResult, error) {
 		Timeout: 10 * time.Second,
 	}
 
-	resp, err := client.Get(apiURL)
+	req, err := http.NewRequestWithContext(ctx, "GET", apiURL, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	resp, err := DoWithRetry(client, req)
 	if err != nil {
 		return nil, fmt.Errorf("failed to search: %w", err)
 	}
@@ -0,0 +1,174 @@
+package utils
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+)
+
+// SecurityFinding is a problem found by the static checks or the model's review.
+type SecurityFinding struct {
+	RuleID   string `json:"rule_id"` // e.g. "secret/aws-access-key" or "llm/sql-injection"
+	Path     string `json:"path"`
+	Line     int    `json:"line"`
+	Severity string `json:"severity"` // "high", "medium" or "low"
+	Message  string `json:"message"`
+	Source   string `json:"source"` // "static", "llm" or "static+llm"
+}
+
+// securityRule is a static check: a pattern over single lines, for the
+// languages listed (all when empty).
+type securityRule struct {
+	ID        string
+	Severity  string
+	Message   string
+	Pattern   *regexp.Regexp
+	Except    *regexp.Regexp // lines that also match are safe uses
+	Languages []string
+}
+
+// securityRules are the secret patterns and dangerous APIs checked locally.
+// They are cheap and precise enough to run on every file, and their hits are
+// given to the model as hints.
+var securityRules = []securityRule{
+	{ID: "secret/aws-access-key", Severity: "high", Message: "AWS access key ID", Pattern: regexp.MustCompile(`\b(?:AKIA|ASIA)[0-9A-Z]{16}\b`)},
+	{ID: "secret/private-key", Severity: "high", Message: "Private key", Pattern: regexp.MustCompile(`-----BEGIN (?:RSA |EC |DSA |OPENSSH |PGP )?PRIVATE KEY`)},
+	{ID: "secret/github-token", Severity: "high", Message: "GitHub token", Pattern: regexp.MustCompile(`\b(?:gh[pousr]_[A-Za-z0-9]{36,}|github_pat_[A-Za-z0-9_]{40,})\b`)},
+	{ID: "secret/slack-token", Severity: "high", Message: "Slack token", Pattern: regexp.MustCompile(`\bxox[abprs]-[A-Za-z0-9-]{10,}`)},
+	{ID: "secret/google-api-key", Severity: "high", Message: "Google API key", Pattern: regexp.MustCompile(`\bAIza[0-9A-Za-z_-]{35}\b`)},
+	{ID: "secret/stripe-key", Severity: "high", Message: "Stripe secret key", Pattern: regexp.MustCompile(`\b[sr]k_live_[0-9A-Za-z]{20,}\b`)},
+	{ID: "secret/hardcoded-credential", Severity: "medium", Message: "Hard-coded password, secret or API key", Pattern: regexp.MustCompile(`(?i)\b(?:password|passwd|secret|api_?key|access_?token|auth_?token)["']?\s*[:=]\s*["'][^"'\s]{8,}["']`)},
+
+	{ID: "go/tls-insecure-skip-verify", Severity: "high", Message: "TLS certificate verification disabled", Pattern: regexp.MustCompile(`InsecureSkipVerify:\s*true`), Languages: []string{"go"}},
+	{ID: "go/shell-command", Severity: "medium", Message: "Command run through a shell; check for injection", Pattern: regexp.MustCompile(`exec\.Command(?:Context)?\([^)]*"(?:ba|z)?sh",\s*"-c"`), Languages: []string{"go"}},
+	{ID: "go/sql-format", Severity: "high", Message: "SQL built with fmt.Sprintf; use query parameters", Pattern: regexp.MustCompile(`\.(?:Query|QueryRow|Exec)(?:Context)?\([^)]*fmt\.Sprintf`), Languages: []string{"go"}},
+	{ID: "go/unescaped-html", Severity: "medium", Message: "template.HTML bypasses escaping", Pattern: regexp.MustCompile(`template\.HTML\(`), Languages: []string{"go"}},
+	{ID: "weak-hash", Severity: "low", Message: "MD5 or SHA-1; unsafe for passwords or signatures", Pattern: regexp.MustCompile(`\b(?:md5|sha1)\.(?:New|Sum)|hashlib\.(?:md5|sha1)\(|createHash\(["'](?:md5|sha1)`)},
+
+	{ID: "python/eval", Severity: "high", Message: "eval/exec of dynamic code", Pattern: regexp.MustCompile(`(?:^|[^.\w])(?:eval|exec)\(`), Languages: []string{"python"}},
+	{ID: "python/pickle", Severity: "high", Message: "Unpickling can run arbitrary code", Pattern: regexp.MustCompile(`pickle\.loads?\(`), Languages: []string{"python"}},
+	{ID: "python/yaml-load", Severity: "medium", Message: "yaml.load without SafeLoader", Pattern: regexp.MustCompile(`yaml\.load\(`), Except: regexp.MustCompile(`SafeLoader|BaseLoader`), Languages: []string{"python"}},
+	{ID: "python/shell-true", Severity: "medium", Message: "subprocess with shell=True; check for injection", Pattern: regexp.MustCompile(`subprocess\.\w+\(.*shell\s*=\s*True`), Languages: []string{"python"}},
+	{ID: "tls-verify-disabled", Severity: "high", Message: "TLS certificate verification disabled", Pattern: regexp.MustCompile(`verify\s*=\s*False|rejectUnauthorized:\s*false|NODE_TLS_REJECT_UNAUTHORIZED`)},
+
+	{ID: "js/eval", Severity: "high", Message: "eval or new Function of dynamic code", Pattern: regexp.MustCompile(`(?:^|[^.\w])eval\(|new Function\(`), Languages: []string{"javascript", "typescript"}},
+	{ID: "js/inner-html", Severity: "medium", Message: "Assigning HTML directly; check for XSS", Pattern: regexp.MustCompile(`\.(?:innerHTML|outerHTML)\s*=|dangerouslySetInnerHTML`), Languages: []string{"javascript", "typescript"}},
+	{ID: "js/child-process-exec", Severity: "medium", Message: "child_process.exec runs a shell; check for injection", Pattern: regexp.MustCompile(`\bexec(?:Sync)?\(\s*[\x60"'][^\x60"']*\$\{|child_process.*\bexec\(`), Languages: []string{"javascript", "typescript"}},
+
+	{ID: "php/dangerous-call", Severity: "high", Message: "Dynamic code, shell or unserialize call", Pattern: regexp.MustCompile(`\b(?:eval|shell_exec|system|passthru|unserialize)\s*\(`), Languages: []string{"php"}},
+	{ID: "ruby/dangerous-call", Severity: "high", Message: "Dynamic code or shell call", Pattern: regexp.MustCompile(`\b(?:eval|instance_eval|system|Marshal\.load)\b|%x\(|\x60[^\x60]*#\{`), Languages: []string{"ruby"}},
+	{ID: "shell/curl-pipe", Severity: "medium", Message: "Downloaded script piped into a shell", Pattern: regexp.MustCompile(`(?:curl|wget)\b[^|]*\|\s*(?:sudo\s+)?(?:ba|z)?sh\b`), Languages: []string{"shell"}},
+}
+
+// StaticSecurityScan runs the static checks over content, a file of the
+// given language (see CodeLanguage) at path.
+func StaticSecurityScan(path, language, content string) []SecurityFinding {
+	var findings []SecurityFinding
+	for i, line := range strings.Split(content, "\n") {
+		for _, r := range securityRules {
+			if len(r.Languages) > 0 && !slices.Contains(r.Languages, language) {
+				continue
+			}
+			if r.Pattern.MatchString(line) && (r.Except == nil || !r.Except.MatchString(line)) {
+				findings = append(findings, SecurityFinding{RuleID: r.ID, Path: path, Line: i + 1, Severity: r.Severity, Message: r.Message, Source: "static"})
+			}
+		}
+	}
+	return findings
+}
+
+// securitySeverityRank orders severities, highest first.
+var securitySeverityRank = map[string]int{"high": 0, "medium": 1, "low": 2}
+
+// DedupeFindings merges findings on the same line: several hits of one rule
+// become one, and a model finding on a line a static rule flagged is folded
+// into that finding (keeping the higher severity and both explanations).
+// The result is sorted by severity, then path and line.
+func DedupeFindings(findings []SecurityFinding) []SecurityFinding {
+	type lineKey struct {
+		path string
+		line int
+	}
+	byLine := map[lineKey][]int{}
+	var out []SecurityFinding
+	for _, f := range findings {
+		key := lineKey{f.Path, f.Line}
+		merged := false
+		for _, i := range byLine[key] {
+			existing := &out[i]
+			if existing.RuleID != f.RuleID && existing.Source == f.Source {
+				continue
+			}
+			if securitySeverityRank[f.Severity] < securitySeverityRank[existing.Severity] {
+				existing.Severity = f.Severity
+			}
+			if existing.Source != f.Source {
+				existing.Source = "static+llm"
+				if !strings.Contains(existing.Message, f.Message) {
+					existing.Message += ". " + f.Message
+				}
+			}
+			merged = true
+			break
+		}
+		if !merged {
+			byLine[key] = append(byLine[key], len(out))
+			out = append(out, f)
+		}
+	}
+	sort.SliceStable(out, func(i, j int) bool {
+		a, b := out[i], out[j]
+		if securitySeverityRank[a.Severity] != securitySeverityRank[b.Severity] {
+			return securitySeverityRank[a.Severity] < securitySeverityRank[b.Severity]
+		}
+		if a.Path != b.Path {
+			return a.Path < b.Path
+		}
+		return a.Line < b.Line
+	})
+	return out
+}
+
+// SARIF encodes findings as a SARIF 2.1.0 log, the format code-scanning
+// dashboards (e.g. GitHub's) import.
+func SARIF(findings []SecurityFinding, toolName string) ([]byte, error) {
+	levels := map[string]string{"high": "error", "medium": "warning", "low": "note"}
+	var rules []map[string]any
+	ruleIndex := map[string]int{}
+	results := make([]map[string]any, 0, len(findings))
+	for _, f := range findings {
+		if _, ok := ruleIndex[f.RuleID]; !ok {
+			ruleIndex[f.RuleID] = len(rules)
+			rules = append(rules, map[string]any{"id": f.RuleID, "shortDescription": map[string]string{"text": f.RuleID}})
+		}
+		results = append(results, map[string]any{
+			"ruleId":    f.RuleID,
+			"ruleIndex": ruleIndex[f.RuleID],
+			"level":     levels[f.Severity],
+			"message":   map[string]string{"text": f.Message},
+			"locations": []map[string]any{{
+				"physicalLocation": map[string]any{
+					"artifactLocation": map[string]string{"uri": f.Path},
+					"region":           map[string]int{"startLine": max(f.Line, 1)},
+				},
+			}},
+			"properties": map[string]string{"source": f.Source},
+		})
+	}
+	log := map[string]any{
+		"version": "2.1.0",
+		"$schema": "https://json.schemastore.org/sarif-2.1.0.json",
+		"runs": []map[string]any{{
+			"tool":    map[string]any{"driver": map[string]any{"name": toolName, "rules": rules}},
+			"results": results,
+		}},
+	}
+	data, err := json.MarshalIndent(log, "", "  ")
+	if err != nil {
+		return nil, fmt.Errorf("encoding SARIF: %w", err)
+	}
+	return data, nil
+}
@@ -0,0 +1,200 @@
+package utils
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// maxShellOutput bounds what is kept of each of a command's stdout and stderr.
+const maxShellOutput = 64 << 10
+
+// AllowUnsandboxedShell lets RunSandboxed run commands without bubblewrap,
+// in the sandbox's copy of the workspace but otherwise unconfined. Only
+// -unsandboxed-shell sets it.
+var AllowUnsandboxedShell bool
+
+// ErrNoShellSandbox is the error of RunSandboxed without bubblewrap or
+// AllowUnsandboxedShell.
+var ErrNoShellSandbox = errors.New("bubblewrap (bwrap) is not installed, and commands are not run outside a sandbox without -unsandboxed-shell")
+
+// shellSystemDirs are the directories a sandboxed command sees, read-only,
+// besides the workspace copy.
+var shellSystemDirs = []string{"/usr", "/bin", "/sbin", "/lib", "/lib32", "/lib64", "/etc", "/opt", "/nix"}
+
+// ShellResult is what a command run by RunSandboxed printed and how it ended,
+// with the files it changed or deleted in the workspace copy.
+type ShellResult struct {
+	Stdout, Stderr   string
+	ExitCode         int
+	TimedOut         bool
+	Changed, Deleted []string
+}
+
+// Format renders the result for the model, with the command it ran.
+func (r ShellResult) Format(command string) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "$ %s\n", command)
+	switch {
+	case r.TimedOut:
+		b.WriteString("timed out and was killed\n")
+	default:
+		fmt.Fprintf(&b, "exit status %d\n", r.ExitCode)
+	}
+	if r.Stdout != "" {
+		fmt.Fprintf(&b, "--- stdout ---\n%s\n", strings.TrimRight(r.Stdout, "\n"))
+	}
+	if r.Stderr != "" {
+		fmt.Fprintf(&b, "--- stderr ---\n%s\n", strings.TrimRight(r.Stderr, "\n"))
+	}
+	if len(r.Changed) > 0 {
+		fmt.Fprintf(&b, "changed files, applied only if the user accepts them after the answer: %s\n", strings.Join(r.Changed, ", "))
+	}
+	if len(r.Deleted) > 0 {
+		fmt.Fprintf(&b, "deleted files, which will not be deleted in the workspace: %s\n", strings.Join(r.Deleted, ", "))
+	}
+	return b.String()
+}
+
+// cappedBuffer keeps the first maxShellOutput bytes written to it and notes
+// that the rest was dropped.
+type cappedBuffer struct {
+	strings.Builder
+	dropped bool
+}
+
+func (b *cappedBuffer) Write(p []byte) (int, error) {
+	if room := maxShellOutput - b.Len(); len(p) > room {
+		b.Builder.Write(p[:max(room, 0)])
+		b.dropped = true
+		return len(p), nil
+	}
+	return b.Builder.Write(p)
+}
+
+func (b *cappedBuffer) String() string {
+	if b.dropped {
+		return b.Builder.String() + "\n[output truncated]"
+	}
+	return b.Builder.String()
+}
+
+// HasShellSandbox reports whether RunSandboxed can isolate commands with
+// bubblewrap.
+func HasShellSandbox() bool {
+	_, err := exec.LookPath("bwrap")
+	return err == nil
+}
+
+// RunSandboxed runs command with sh in the sandbox's checkout of the
+// workspace and captures its output; the files it changes become edits of
+// the sandbox, applied only after review. Under bubblewrap the command sees
+// the checkout at the workspace's path, the system directories read-only,
+// and an empty home directory and /tmp; it has no network. Without
+// bubblewrap it refuses unless AllowUnsandboxedShell. Either way the command
+// gets no API keys or other secrets from the environment, no stdin, and is
+// killed after timeout.
+func RunSandboxed(ctx context.Context, sandbox *Sandbox, command string, timeout time.Duration) (ShellResult, error) {
+	if err := CheckWritable("running shell commands"); err != nil {
+		return ShellResult{}, err
+	}
+	sandboxed := HasShellSandbox()
+	if !sandboxed && !AllowUnsandboxedShell {
+		return ShellResult{}, ErrNoShellSandbox
+	}
+	dir, err := sandbox.Checkout()
+	if err != nil {
+		return ShellResult{}, err
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	args := []string{"sh", "-c", command}
+	if sandboxed {
+		bwrap := []string{"bwrap"}
+		for _, system := range shellSystemDirs {
+			bwrap = append(bwrap, "--ro-bind-try", system, system)
+		}
+		bwrap = append(bwrap, "--dev", "/dev", "--proc", "/proc", "--tmpfs", "/tmp")
+		if home := os.Getenv("HOME"); home != "" {
+			bwrap = append(bwrap, "--tmpfs", home)
+		}
+		bwrap = append(bwrap, "--bind", dir, sandbox.Root, "--chdir", sandbox.Root,
+			"--unshare-all", "--die-with-parent", "--new-session", "--")
+		args = append(bwrap, args...)
+	}
+	cmd := exec.CommandContext(ctx, args[0], args[1:]...)
+	cmd.Dir = dir
+	cmd.Env = []string{"PATH=" + os.Getenv("PATH"), "HOME=" + os.Getenv("HOME"), "LANG=" + os.Getenv("LANG"), "TERM=dumb"}
+	// Children that keep the output open must not hold up the result.
+	cmd.WaitDelay = time.Second
+	var stdout, stderr cappedBuffer
+	cmd.Stdout, cmd.Stderr = &stdout, &stderr
+
+	err = cmd.Run()
+	result := ShellResult{Stdout: stdout.String(), Stderr: stderr.String(), TimedOut: errors.Is(ctx.Err(), context.DeadlineExceeded)}
+	var exitErr *exec.ExitError
+	switch {
+	case err == nil, result.TimedOut:
+	case errors.As(err, &exitErr):
+		result.ExitCode = exitErr.ExitCode()
+	default:
+		return result, fmt.Errorf("could not run the command: %w", err)
+	}
+	if result.Changed, result.Deleted, err = sandbox.Sync(); err != nil {
+		return result, fmt.Errorf("could not record the changed files: %w", err)
+	}
+	return result, nil
+}
+
+// ShellSafe returns an error when command holds control or formatting
+// characters, such as newlines, terminal escapes or bidirectional
+// overrides, which could hide part of it when it is shown for confirmation.
+func ShellSafe(command string) error {
+	for _, r := range command {
+		if unicode.IsControl(r) || unicode.Is(unicode.Cf, r) {
+			return fmt.Errorf("the command contains the control or formatting character %U; give it on one line without them", r)
+		}
+	}
+	return nil
+}
+
+type sandboxKey struct{}
+
+// WithSandbox makes tools called with the returned context, such as
+// run_shell, change files in sandbox instead of the workspace.
+func WithSandbox(ctx context.Context, sandbox *Sandbox) context.Context {
+	return context.WithValue(ctx, sandboxKey{}, sandbox)
+}
+
+// SandboxFrom returns the sandbox set by WithSandbox.
+func SandboxFrom(ctx context.Context) (*Sandbox, bool) {
+	sandbox, ok := ctx.Value(sandboxKey{}).(*Sandbox)
+	return sandbox, ok && sandbox != nil
+}
+
+// Confirm asks the user whether to go ahead with what prompt describes.
+type Confirm func(ctx context.Context, prompt string) bool
+
+type confirmKey struct{}
+
+// WithConfirm lets tools called with the returned context ask the user
+// through confirm before they act.
+func WithConfirm(ctx context.Context, confirm Confirm) context.Context {
+	return context.WithValue(ctx, confirmKey{}, confirm)
+}
+
+// Confirmed asks ctx's user about prompt. Without anyone to ask, the answer
+// is an error rather than a yes.
+func Confirmed(ctx context.Context, prompt string) (bool, error) {
+	confirm, ok := ctx.Value(confirmKey{}).(Confirm)
+	if !ok || confirm == nil {
+		return false, errors.New("this needs the user's confirmation, and there is no one to ask here")
+	}
+	return confirm(ctx, prompt), nil
+}
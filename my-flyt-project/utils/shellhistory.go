@@ -0,0 +1,66 @@
+package utils
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// UserShell returns the name of the user's login shell (bash, zsh, fish, ...).
+func UserShell() string {
+	shell := filepath.Base(os.Getenv("SHELL"))
+	if shell == "" || shell == "." {
+		return "sh"
+	}
+	return shell
+}
+
+// AppendShellHistory records command in the history file of shell using that
+// shell's own format, so it can be recalled with the up arrow in new sessions.
+// It returns the path written to.
+func AppendShellHistory(shell, command string, at time.Time) (string, error) {
+	if err := CheckWritable("writing the shell history"); err != nil {
+		return "", err
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", fmt.Errorf("could not find home directory: %w", err)
+	}
+
+	var path, entry string
+	switch shell {
+	case "zsh":
+		path = envOr("HISTFILE", filepath.Join(home, ".zsh_history"))
+		// Extended history format: ": <start>:<elapsed>;<command>"
+		entry = fmt.Sprintf(": %d:0;%s\n", at.Unix(), command)
+	case "bash":
+		path = envOr("HISTFILE", filepath.Join(home, ".bash_history"))
+		// Timestamp comment lines are understood when HISTTIMEFORMAT is set and ignored otherwise.
+		entry = fmt.Sprintf("#%d\n%s\n", at.Unix(), command)
+	case "fish":
+		path = filepath.Join(home, ".local", "share", "fish", "fish_history")
+		entry = fmt.Sprintf("- cmd: %s\n  when: %d\n", strings.ReplaceAll(command, "\n", `\n`), at.Unix())
+	default:
+		return "", fmt.Errorf("unsupported shell %q for history recording", shell)
+	}
+
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return "", fmt.Errorf("could not open history file: %w", err)
+	}
+	defer f.Close()
+
+	if _, err := f.WriteString(entry); err != nil {
+		return "", fmt.Errorf("could not write history file: %w", err)
+	}
+	return path, nil
+}
+
+func envOr(key, fallback string) string {
+	if v := os.Getenv(key); v != "" {
+		return v
+	}
+	return fallback
+}
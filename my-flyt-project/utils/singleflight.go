@@ -0,0 +1,98 @@
+package utils
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"sync"
+)
+
+// flightGroup collapses identical calls made at the same time into one: the
+// first caller starts it and the others wait for its result. Server mode and
+// parallel workers often ask the same thing at once, and each duplicate
+// would otherwise be a separate paid request.
+type flightGroup struct {
+	mu    sync.Mutex
+	calls map[string]*flightCall
+}
+
+// flightCall is a call in progress and the callers waiting for it.
+type flightCall struct {
+	done    chan struct{}
+	result  string
+	err     error
+	waiters int
+	cancel  context.CancelFunc
+}
+
+// llmFlights collapses duplicate CallLLMWithConfig calls.
+var llmFlights = &flightGroup{calls: map[string]*flightCall{}}
+
+// Do runs fn once for all callers waiting on key at the same time and gives
+// each its result. fn's context is not tied to any one caller: a caller that
+// gives up stops waiting, and fn is canceled only when every caller has.
+func (g *flightGroup) Do(ctx context.Context, key string, fn func(context.Context) (string, error)) (string, error) {
+	g.mu.Lock()
+	call, ok := g.calls[key]
+	if !ok {
+		callCtx, cancel := context.WithCancel(context.WithoutCancel(ctx))
+		call = &flightCall{done: make(chan struct{}), cancel: cancel}
+		g.calls[key] = call
+		go func() {
+			call.result, call.err = fn(callCtx)
+			g.mu.Lock()
+			g.forget(key, call)
+			g.mu.Unlock()
+			cancel()
+			close(call.done)
+		}()
+	}
+	call.waiters++
+	g.mu.Unlock()
+
+	select {
+	case <-call.done:
+		return call.result, call.err
+	case <-ctx.Done():
+		g.mu.Lock()
+		call.waiters--
+		if call.waiters == 0 {
+			// Later callers must start afresh rather than join a canceled call.
+			g.forget(key, call)
+			call.cancel()
+		}
+		g.mu.Unlock()
+		return "", ctx.Err()
+	}
+}
+
+// forget removes call from g unless another call has already replaced it.
+// g.mu must be held.
+func (g *flightGroup) forget(key string, call *flightCall) {
+	if g.calls[key] == call {
+		delete(g.calls, key)
+	}
+}
+
+// flightKey identifies a request by everything that is sent to the model and
+// by the usage account it is charged to, so that one user's call is never
+// paid for by another.
+func flightKey(ctx context.Context, prompt string, config *LLMConfig, useSearch bool) (string, bool) {
+	account, _ := ctx.Value(usageAccountKey{}).(usageAccount)
+	data, err := json.Marshal(struct {
+		User      string
+		Prompt    string
+		Config    *LLMConfig
+		Format    ResponseFormat
+		Schema    map[string]any
+		History   []Conversation
+		System    string
+		UseSearch bool
+	}{account.user, prompt, config, config.Format, config.Schema, config.History, config.System, useSearch})
+	if err != nil {
+		return "", false
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:]), true
+}
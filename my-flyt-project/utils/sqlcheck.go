@@ -0,0 +1,157 @@
+package utils
+
+import (
+	"bytes"
+	"fmt"
+	"net/url"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// SQLDatabase is the read-only database generated queries are checked
+// against, reached through its command-line client (sqlite3, psql or mysql).
+type SQLDatabase struct {
+	Kind string // "sqlite", "postgres" or "mysql"
+	DSN  string // file path for sqlite, URL otherwise
+}
+
+// ParseSQLDatabase accepts a postgres:// or mysql:// URL, or a SQLite file
+// (optionally written sqlite://path).
+func ParseSQLDatabase(s string) (SQLDatabase, error) {
+	switch {
+	case s == "":
+		return SQLDatabase{}, fmt.Errorf("no database configured (set SQL_DATABASE or -db)")
+	case strings.HasPrefix(s, "postgres://"), strings.HasPrefix(s, "postgresql://"):
+		return SQLDatabase{Kind: "postgres", DSN: s}, nil
+	case strings.HasPrefix(s, "mysql://"):
+		return SQLDatabase{Kind: "mysql", DSN: s}, nil
+	case strings.Contains(s, "://") && !strings.HasPrefix(s, "sqlite://"):
+		return SQLDatabase{}, fmt.Errorf("unsupported database %q (use a SQLite file, postgres:// or mysql://)", s)
+	}
+	path := strings.TrimPrefix(s, "sqlite://")
+	if _, err := os.Stat(path); err != nil {
+		return SQLDatabase{}, fmt.Errorf("SQLite database: %w", err)
+	}
+	return SQLDatabase{Kind: "sqlite", DSN: path}, nil
+}
+
+// Dialect names the SQL dialect for prompts.
+func (db SQLDatabase) Dialect() string {
+	return map[string]string{"sqlite": "SQLite", "postgres": "PostgreSQL", "mysql": "MySQL"}[db.Kind]
+}
+
+// run sends sql to the database's client in a read-only session.
+func (db SQLDatabase) run(sql string) (string, error) {
+	var cmd *exec.Cmd
+	switch db.Kind {
+	case "sqlite":
+		cmd = exec.Command("sqlite3", "-readonly", "-bail", db.DSN)
+	case "postgres":
+		cmd = exec.Command("psql", db.DSN, "-X", "-q", "-A", "-t", "-F", "\t", "-v", "ON_ERROR_STOP=1")
+		sql = "SET SESSION CHARACTERISTICS AS TRANSACTION READ ONLY;\n" + sql
+	case "mysql":
+		u, err := url.Parse(db.DSN)
+		if err != nil {
+			return "", fmt.Errorf("invalid mysql URL: %w", err)
+		}
+		args := []string{"--batch", "--raw", "--skip-column-names", "-h", u.Hostname()}
+		if u.Port() != "" {
+			args = append(args, "-P", u.Port())
+		}
+		if u.User != nil {
+			args = append(args, "-u", u.User.Username())
+		}
+		cmd = exec.Command("mysql", append(args, strings.TrimPrefix(u.Path, "/"))...)
+		if password, ok := u.User.Password(); ok {
+			// Through the environment rather than argv, where other users could see it.
+			cmd.Env = append(os.Environ(), "MYSQL_PWD="+password)
+		}
+		sql = "SET SESSION TRANSACTION READ ONLY;\n" + sql
+	default:
+		return "", fmt.Errorf("unknown database kind %q", db.Kind)
+	}
+	cmd.Stdin = strings.NewReader(sql)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return "", fmt.Errorf("%s", msg)
+		}
+		return "", fmt.Errorf("%s client: %w", db.Kind, err)
+	}
+	return strings.TrimSpace(string(out)), nil
+}
+
+// Schema describes the database's tables and columns for a prompt.
+func (db SQLDatabase) Schema() (string, error) {
+	switch db.Kind {
+	case "sqlite":
+		return db.run(".schema")
+	case "postgres":
+		return db.run(`SELECT table_schema || '.' || table_name, column_name, data_type FROM information_schema.columns
+WHERE table_schema NOT IN ('pg_catalog', 'information_schema') ORDER BY table_schema, table_name, ordinal_position;`)
+	case "mysql":
+		return db.run(`SELECT table_name, column_name, column_type FROM information_schema.columns
+WHERE table_schema = DATABASE() ORDER BY table_name, ordinal_position;`)
+	}
+	return "", fmt.Errorf("unknown database kind %q", db.Kind)
+}
+
+// Explain dry-runs query: it must be a single read-only statement, and the
+// database must accept its EXPLAIN. The plan is returned.
+func (db SQLDatabase) Explain(query string) (string, error) {
+	statements := SplitSQLStatements(query)
+	if len(statements) != 1 {
+		return "", fmt.Errorf("expected exactly one statement, got %d", len(statements))
+	}
+	stmt := statements[0]
+	first, _, _ := strings.Cut(strings.ToUpper(strings.TrimLeft(stmt, "( \t\n")), " ")
+	first = strings.TrimSpace(first)
+	if first != "SELECT" && first != "WITH" && first != "VALUES" {
+		return "", fmt.Errorf("only read-only queries (SELECT or WITH) are allowed, not %s", first)
+	}
+	explain := "EXPLAIN "
+	if db.Kind == "sqlite" {
+		explain = "EXPLAIN QUERY PLAN "
+	}
+	return db.run(explain + stmt + ";")
+}
+
+// SplitSQLStatements splits sql on semicolons outside quotes and comments,
+// dropping empty statements.
+func SplitSQLStatements(sql string) []string {
+	var statements []string
+	var current strings.Builder
+	var quote byte
+	for i := 0; i < len(sql); i++ {
+		c := sql[i]
+		switch {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '\'' || c == '"' || c == '`':
+			quote = c
+		case c == '-' && i+1 < len(sql) && sql[i+1] == '-':
+			end := strings.IndexByte(sql[i:], '\n')
+			if end < 0 {
+				end = len(sql) - i
+			}
+			i += end - 1
+			continue
+		case c == ';':
+			if s := strings.TrimSpace(current.String()); s != "" {
+				statements = append(statements, s)
+			}
+			current.Reset()
+			continue
+		}
+		current.WriteByte(c)
+	}
+	if s := strings.TrimSpace(current.String()); s != "" {
+		statements = append(statements, s)
+	}
+	return statements
+}
@@ -0,0 +1,557 @@
+package utils
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// StateStore is key-value state shared by server replicas behind a load
+// balancer: sessions, documents and rate-limit counters. A ttl of 0 keeps a
+// key until it is deleted. NewMemoryStateStore keeps the same state in one
+// process.
+type StateStore interface {
+	// Get returns the value at key and whether it exists.
+	Get(ctx context.Context, key string) ([]byte, bool, error)
+	Set(ctx context.Context, key string, value []byte, ttl time.Duration) error
+	Delete(ctx context.Context, key string) error
+	// SetNX sets key to value, created with ttl, only if it does not exist,
+	// and reports whether it did.
+	SetNX(ctx context.Context, key string, value []byte, ttl time.Duration) (bool, error)
+	// DeleteIf deletes key only if it holds value, and reports whether it did.
+	DeleteIf(ctx context.Context, key string, value []byte) (bool, error)
+	// Incr adds n to the counter at key, created with ttl, and returns the
+	// new count; n of 0 reads the counter.
+	Incr(ctx context.Context, key string, n int64, ttl time.Duration) (int64, error)
+	// Keys lists the keys starting with prefix.
+	Keys(ctx context.Context, prefix string) ([]string, error)
+	Close() error
+}
+
+// OpenStateStore connects to the store at rawURL: redis://[:password@]host[:port][/db]
+// (rediss:// is not supported) or postgres://[user[:password]@]host[:port][/db][?sslmode=...].
+func OpenStateStore(rawURL string) (StateStore, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid state store URL: %w", err)
+	}
+	switch u.Scheme {
+	case "redis":
+		return openRedisStore(u)
+	case "postgres", "postgresql":
+		return openPostgresStore(u)
+	default:
+		return nil, fmt.Errorf("unsupported state store %q (use redis:// or postgres://)", u.Scheme)
+	}
+}
+
+// redisStore speaks RESP to a Redis server over a small pool of connections.
+type redisStore struct {
+	addr     string
+	password string
+	db       int
+	pool     chan *redisConn
+}
+
+type redisConn struct {
+	conn net.Conn
+	r    *bufio.Reader
+}
+
+// redisPoolSize bounds the idle connections kept open.
+const redisPoolSize = 8
+
+func openRedisStore(u *url.URL) (*redisStore, error) {
+	s := &redisStore{addr: u.Host, pool: make(chan *redisConn, redisPoolSize)}
+	if u.Port() == "" {
+		s.addr = net.JoinHostPort(u.Hostname(), "6379")
+	}
+	if password, ok := u.User.Password(); ok {
+		s.password = password
+	}
+	if path := strings.Trim(u.Path, "/"); path != "" {
+		db, err := strconv.Atoi(path)
+		if err != nil {
+			return nil, fmt.Errorf("invalid Redis database %q", path)
+		}
+		s.db = db
+	}
+	// Fail at startup rather than on the first request.
+	if _, err := s.do(context.Background(), "PING"); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// conn takes an idle connection from the pool or dials a new one.
+func (s *redisStore) conn(ctx context.Context) (*redisConn, error) {
+	select {
+	case c := <-s.pool:
+		return c, nil
+	default:
+	}
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", s.addr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to Redis at %s: %w", s.addr, err)
+	}
+	c := &redisConn{conn: conn, r: bufio.NewReader(conn)}
+	if s.password != "" {
+		if _, err := c.do(ctx, "AUTH", s.password); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+	if s.db != 0 {
+		if _, err := c.do(ctx, "SELECT", strconv.Itoa(s.db)); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+	return c, nil
+}
+
+// do runs one command. A connection that failed is closed instead of being
+// returned to the pool, since its replies may be out of step.
+func (s *redisStore) do(ctx context.Context, args ...string) (any, error) {
+	c, err := s.conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+	reply, err := c.do(ctx, args...)
+	var redisErr redisError
+	if err != nil && !errors.As(err, &redisErr) {
+		c.conn.Close()
+		return nil, err
+	}
+	select {
+	case s.pool <- c:
+	default:
+		c.conn.Close()
+	}
+	return reply, err
+}
+
+// redisError is an error reply from the server.
+type redisError string
+
+func (e redisError) Error() string { return "redis: " + string(e) }
+
+func (c *redisConn) do(ctx context.Context, args ...string) (any, error) {
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		deadline = time.Now().Add(30 * time.Second)
+	}
+	c.conn.SetDeadline(deadline)
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "*%d\r\n", len(args))
+	for _, arg := range args {
+		fmt.Fprintf(&b, "$%d\r\n%s\r\n", len(arg), arg)
+	}
+	if _, err := c.conn.Write(b.Bytes()); err != nil {
+		return nil, fmt.Errorf("redis: %w", err)
+	}
+	return readRESP(c.r)
+}
+
+// readRESP reads one reply: a string, int64, []byte (nil for a null bulk
+// string), []any or a redisError.
+func readRESP(r *bufio.Reader) (any, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return nil, fmt.Errorf("redis: %w", err)
+	}
+	line = strings.TrimSuffix(line, "\r\n")
+	if line == "" {
+		return nil, fmt.Errorf("redis: empty reply")
+	}
+	switch line[0] {
+	case '+':
+		return line[1:], nil
+	case '-':
+		return nil, redisError(line[1:])
+	case ':':
+		return strconv.ParseInt(line[1:], 10, 64)
+	case '$':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil || n < 0 {
+			return []byte(nil), err
+		}
+		data := make([]byte, n+2)
+		if _, err := io.ReadFull(r, data); err != nil {
+			return nil, fmt.Errorf("redis: %w", err)
+		}
+		return data[:n], nil
+	case '*':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil || n < 0 {
+			return []any(nil), err
+		}
+		items := make([]any, n)
+		for i := range items {
+			if items[i], err = readRESP(r); err != nil {
+				return nil, err
+			}
+		}
+		return items, nil
+	}
+	return nil, fmt.Errorf("redis: unexpected reply %q", line)
+}
+
+func (s *redisStore) Get(ctx context.Context, key string) ([]byte, bool, error) {
+	reply, err := s.do(ctx, "GET", key)
+	if err != nil {
+		return nil, false, err
+	}
+	data, _ := reply.([]byte)
+	return data, data != nil, nil
+}
+
+func (s *redisStore) Set(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	args := []string{"SET", key, string(value)}
+	if ttl > 0 {
+		args = append(args, "PX", strconv.FormatInt(ttl.Milliseconds(), 10))
+	}
+	_, err := s.do(ctx, args...)
+	return err
+}
+
+func (s *redisStore) Delete(ctx context.Context, key string) error {
+	_, err := s.do(ctx, "DEL", key)
+	return err
+}
+
+// redisIncrScript increments and sets the expiry of a new counter in one
+// step, so that no counter is left without one.
+const redisIncrScript = `local n = redis.call('INCRBY', KEYS[1], ARGV[1])
+if n == tonumber(ARGV[1]) and tonumber(ARGV[2]) > 0 then
+	redis.call('PEXPIRE', KEYS[1], ARGV[2])
+end
+return n`
+
+func (s *redisStore) Incr(ctx context.Context, key string, n int64, ttl time.Duration) (int64, error) {
+	reply, err := s.do(ctx, "EVAL", redisIncrScript, "1", key, strconv.FormatInt(n, 10), strconv.FormatInt(ttl.Milliseconds(), 10))
+	if err != nil {
+		return 0, err
+	}
+	count, ok := reply.(int64)
+	if !ok {
+		return 0, fmt.Errorf("redis: unexpected INCRBY reply %v", reply)
+	}
+	return count, nil
+}
+
+func (s *redisStore) SetNX(ctx context.Context, key string, value []byte, ttl time.Duration) (bool, error) {
+	args := []string{"SET", key, string(value), "NX"}
+	if ttl > 0 {
+		args = append(args, "PX", strconv.FormatInt(ttl.Milliseconds(), 10))
+	}
+	reply, err := s.do(ctx, args...)
+	return reply == "OK", err
+}
+
+// redisDeleteIfScript compares and deletes in one step.
+const redisDeleteIfScript = `if redis.call('GET', KEYS[1]) == ARGV[1] then
+	return redis.call('DEL', KEYS[1])
+end
+return 0`
+
+func (s *redisStore) DeleteIf(ctx context.Context, key string, value []byte) (bool, error) {
+	reply, err := s.do(ctx, "EVAL", redisDeleteIfScript, "1", key, string(value))
+	return reply == int64(1), err
+}
+
+// Keys uses SCAN, which does not block the server the way KEYS does.
+func (s *redisStore) Keys(ctx context.Context, prefix string) ([]string, error) {
+	pattern := redisGlobEscaper.Replace(prefix) + "*"
+	var keys []string
+	cursor := "0"
+	for {
+		reply, err := s.do(ctx, "SCAN", cursor, "MATCH", pattern, "COUNT", "500")
+		if err != nil {
+			return nil, err
+		}
+		parts, _ := reply.([]any)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("redis: unexpected SCAN reply")
+		}
+		next, _ := parts[0].([]byte)
+		batch, _ := parts[1].([]any)
+		for _, k := range batch {
+			if key, ok := k.([]byte); ok {
+				keys = append(keys, string(key))
+			}
+		}
+		if cursor = string(next); cursor == "0" {
+			return keys, nil
+		}
+	}
+}
+
+// redisGlobEscaper escapes the characters MATCH patterns treat specially.
+var redisGlobEscaper = strings.NewReplacer(`\`, `\\`, "*", `\*`, "?", `\?`, "[", `\[`, "]", `\]`)
+
+func (s *redisStore) Close() error {
+	for {
+		select {
+		case c := <-s.pool:
+			c.conn.Close()
+		default:
+			return nil
+		}
+	}
+}
+
+// postgresStore keeps state in one table, over a small pool of connections
+// like redisStore. Values are stored base64-encoded in a text column.
+type postgresStore struct {
+	config pgConfig
+	pool   chan *pgConn
+}
+
+const postgresStateSchema = `CREATE TABLE IF NOT EXISTS ai_wraper_state (
+	key text PRIMARY KEY,
+	value text NOT NULL DEFAULT '',
+	count bigint NOT NULL DEFAULT 0,
+	expires_at timestamptz
+);`
+
+func openPostgresStore(u *url.URL) (*postgresStore, error) {
+	config, err := parsePgURL(u)
+	if err != nil {
+		return nil, err
+	}
+	s := &postgresStore{config: config, pool: make(chan *pgConn, redisPoolSize)}
+	if _, err := s.run(context.Background(), postgresStateSchema); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// run executes sql on a pooled connection and returns the first column of
+// the rows. A connection that failed is closed instead of being returned to
+// the pool, as redisStore does.
+func (s *postgresStore) run(ctx context.Context, sql string) ([]string, error) {
+	var c *pgConn
+	select {
+	case c = <-s.pool:
+	default:
+		var err error
+		if c, err = dialPg(ctx, s.config); err != nil {
+			return nil, err
+		}
+	}
+	rows, err := c.query(ctx, sql)
+	var pgErr pgError
+	if err != nil && !errors.As(err, &pgErr) {
+		c.conn.Close()
+		return nil, err
+	}
+	select {
+	case s.pool <- c:
+	default:
+		c.conn.Close()
+	}
+	return rows, err
+}
+
+// pgQuote returns s as an SQL string literal.
+func pgQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", "''") + "'"
+}
+
+// pgExpiry is the expires_at value for ttl.
+func pgExpiry(ttl time.Duration) string {
+	if ttl <= 0 {
+		return "NULL"
+	}
+	return fmt.Sprintf("now() + interval '%d milliseconds'", ttl.Milliseconds())
+}
+
+const pgLive = "(expires_at IS NULL OR expires_at > now())"
+
+func (s *postgresStore) Get(ctx context.Context, key string) ([]byte, bool, error) {
+	rows, err := s.run(ctx, fmt.Sprintf("SELECT value FROM ai_wraper_state WHERE key = %s AND %s;", pgQuote(key), pgLive))
+	if err != nil || len(rows) == 0 {
+		return nil, false, err
+	}
+	data, err := base64.StdEncoding.DecodeString(rows[0])
+	if err != nil {
+		return nil, false, fmt.Errorf("corrupt state at %s: %w", key, err)
+	}
+	return data, true, nil
+}
+
+func (s *postgresStore) Set(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	_, err := s.run(ctx, fmt.Sprintf(`INSERT INTO ai_wraper_state (key, value, expires_at) VALUES (%s, %s, %s)
+ON CONFLICT (key) DO UPDATE SET value = EXCLUDED.value, count = 0, expires_at = EXCLUDED.expires_at;`,
+		pgQuote(key), pgQuote(base64.StdEncoding.EncodeToString(value)), pgExpiry(ttl)))
+	return err
+}
+
+func (s *postgresStore) Delete(ctx context.Context, key string) error {
+	_, err := s.run(ctx, fmt.Sprintf("DELETE FROM ai_wraper_state WHERE key = %s;", pgQuote(key)))
+	return err
+}
+
+// Incr starts an expired counter again from n, as Redis would after the
+// key expired.
+func (s *postgresStore) Incr(ctx context.Context, key string, n int64, ttl time.Duration) (int64, error) {
+	rows, err := s.run(ctx, fmt.Sprintf(`INSERT INTO ai_wraper_state AS s (key, count, expires_at) VALUES (%s, %d, %s)
+ON CONFLICT (key) DO UPDATE SET
+	count = CASE WHEN s.expires_at IS NOT NULL AND s.expires_at <= now() THEN %d ELSE s.count + %d END,
+	expires_at = CASE WHEN s.expires_at IS NOT NULL AND s.expires_at <= now() THEN EXCLUDED.expires_at ELSE s.expires_at END
+RETURNING count;`, pgQuote(key), n, pgExpiry(ttl), n, n))
+	if err != nil {
+		return 0, err
+	}
+	if len(rows) == 0 {
+		return 0, fmt.Errorf("postgres: no count returned for %s", key)
+	}
+	return strconv.ParseInt(strings.TrimSpace(rows[0]), 10, 64)
+}
+
+// SetNX takes over a key that has expired but was not removed yet.
+func (s *postgresStore) SetNX(ctx context.Context, key string, value []byte, ttl time.Duration) (bool, error) {
+	rows, err := s.run(ctx, fmt.Sprintf(`INSERT INTO ai_wraper_state AS s (key, value, expires_at) VALUES (%s, %s, %s)
+ON CONFLICT (key) DO UPDATE SET value = EXCLUDED.value, count = 0, expires_at = EXCLUDED.expires_at
+	WHERE s.expires_at IS NOT NULL AND s.expires_at <= now()
+RETURNING key;`, pgQuote(key), pgQuote(base64.StdEncoding.EncodeToString(value)), pgExpiry(ttl)))
+	return len(rows) > 0, err
+}
+
+func (s *postgresStore) DeleteIf(ctx context.Context, key string, value []byte) (bool, error) {
+	rows, err := s.run(ctx, fmt.Sprintf("DELETE FROM ai_wraper_state WHERE key = %s AND value = %s RETURNING key;",
+		pgQuote(key), pgQuote(base64.StdEncoding.EncodeToString(value))))
+	return len(rows) > 0, err
+}
+
+func (s *postgresStore) Keys(ctx context.Context, prefix string) ([]string, error) {
+	return s.run(ctx, fmt.Sprintf("SELECT key FROM ai_wraper_state WHERE starts_with(key, %s) AND %s ORDER BY key;", pgQuote(prefix), pgLive))
+}
+
+func (s *postgresStore) Close() error {
+	for {
+		select {
+		case c := <-s.pool:
+			c.send('X', nil)
+			c.conn.Close()
+		default:
+			return nil
+		}
+	}
+}
+
+// memoryStore is a StateStore in this process, for state that needs no
+// sharing between replicas.
+type memoryStore struct {
+	mu     sync.Mutex
+	values map[string]memoryEntry
+}
+
+type memoryEntry struct {
+	value   []byte
+	count   int64
+	expires time.Time
+}
+
+// NewMemoryStateStore returns a StateStore kept in memory.
+func NewMemoryStateStore() StateStore {
+	return &memoryStore{values: map[string]memoryEntry{}}
+}
+
+// live returns the unexpired entry at key. Callers hold s.mu.
+func (s *memoryStore) live(key string) (memoryEntry, bool) {
+	e, ok := s.values[key]
+	if ok && !e.expires.IsZero() && !time.Now().Before(e.expires) {
+		delete(s.values, key)
+		return memoryEntry{}, false
+	}
+	return e, ok
+}
+
+func memoryExpiry(ttl time.Duration) time.Time {
+	if ttl <= 0 {
+		return time.Time{}
+	}
+	return time.Now().Add(ttl)
+}
+
+func (s *memoryStore) Get(ctx context.Context, key string) ([]byte, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	e, ok := s.live(key)
+	return e.value, ok, nil
+}
+
+func (s *memoryStore) Set(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.values[key] = memoryEntry{value: bytes.Clone(value), expires: memoryExpiry(ttl)}
+	return nil
+}
+
+func (s *memoryStore) Delete(ctx context.Context, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.values, key)
+	return nil
+}
+
+func (s *memoryStore) Incr(ctx context.Context, key string, n int64, ttl time.Duration) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	e, ok := s.live(key)
+	if !ok {
+		e.expires = memoryExpiry(ttl)
+	}
+	e.count += n
+	s.values[key] = e
+	return e.count, nil
+}
+
+func (s *memoryStore) SetNX(ctx context.Context, key string, value []byte, ttl time.Duration) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.live(key); ok {
+		return false, nil
+	}
+	s.values[key] = memoryEntry{value: bytes.Clone(value), expires: memoryExpiry(ttl)}
+	return true, nil
+}
+
+func (s *memoryStore) DeleteIf(ctx context.Context, key string, value []byte) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if e, ok := s.live(key); !ok || !bytes.Equal(e.value, value) {
+		return false, nil
+	}
+	delete(s.values, key)
+	return true, nil
+}
+
+func (s *memoryStore) Keys(ctx context.Context, prefix string) ([]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var keys []string
+	for key := range s.values {
+		if _, ok := s.live(key); ok && strings.HasPrefix(key, prefix) {
+			keys = append(keys, key)
+		}
+	}
+	slices.Sort(keys)
+	return keys, nil
+}
+
+func (s *memoryStore) Close() error { return nil }
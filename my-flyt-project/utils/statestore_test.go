@@ -0,0 +1,236 @@
+package utils
+
+import (
+	"bufio"
+	"context"
+	"net"
+	"net/url"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestReadRESP(t *testing.T) {
+	tests := []struct {
+		in   string
+		want any
+	}{
+		{"+OK\r\n", "OK"},
+		{":42\r\n", int64(42)},
+		{"$5\r\nhello\r\n", []byte("hello")},
+		{"$0\r\n\r\n", []byte{}},
+		{"$-1\r\n", []byte(nil)},
+		{"*2\r\n$1\r\na\r\n:1\r\n", []any{[]byte("a"), int64(1)}},
+		{"*-1\r\n", []any(nil)},
+	}
+	for _, tt := range tests {
+		got, err := readRESP(bufio.NewReader(strings.NewReader(tt.in)))
+		if err != nil {
+			t.Errorf("readRESP(%q): %v", tt.in, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("readRESP(%q) = %#v, want %#v", tt.in, got, tt.want)
+		}
+	}
+	if _, err := readRESP(bufio.NewReader(strings.NewReader("-ERR wrong\r\n"))); err == nil || err.Error() != "redis: ERR wrong" {
+		t.Errorf("error reply gave %v", err)
+	}
+	if _, err := readRESP(bufio.NewReader(strings.NewReader("$5\r\nhel"))); err == nil {
+		t.Error("a truncated bulk string was accepted")
+	}
+}
+
+// fakeRedis answers the commands redisStore sends, and records them.
+type fakeRedis struct {
+	mu       sync.Mutex
+	values   map[string]string
+	commands [][]string
+	conns    int
+}
+
+func startFakeRedis(t *testing.T) (*fakeRedis, string) {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	f := &fakeRedis{values: map[string]string{}}
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			f.mu.Lock()
+			f.conns++
+			f.mu.Unlock()
+			go f.serve(conn)
+		}
+	}()
+	return f, l.Addr().String()
+}
+
+func (f *fakeRedis) serve(conn net.Conn) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	for {
+		request, err := readRESP(r)
+		if err != nil {
+			return
+		}
+		var args []string
+		for _, arg := range request.([]any) {
+			args = append(args, string(arg.([]byte)))
+		}
+		f.mu.Lock()
+		f.commands = append(f.commands, args)
+		reply := f.reply(args)
+		f.mu.Unlock()
+		conn.Write([]byte(reply))
+	}
+}
+
+func (f *fakeRedis) reply(args []string) string {
+	bulk := func(s string, ok bool) string {
+		if !ok {
+			return "$-1\r\n"
+		}
+		return "$" + strconv.Itoa(len(s)) + "\r\n" + s + "\r\n"
+	}
+	switch args[0] {
+	case "AUTH", "SELECT":
+		return "+OK\r\n"
+	case "PING":
+		return "+PONG\r\n"
+	case "GET":
+		v, ok := f.values[args[1]]
+		return bulk(v, ok)
+	case "SET":
+		if len(args) > 3 && args[3] == "NX" {
+			if _, ok := f.values[args[1]]; ok {
+				return "$-1\r\n"
+			}
+		}
+		f.values[args[1]] = args[2]
+		return "+OK\r\n"
+	case "DEL":
+		delete(f.values, args[1])
+		return ":1\r\n"
+	case "EVAL":
+		key := args[3]
+		switch args[1] {
+		case redisIncrScript:
+			n, _ := strconv.ParseInt(f.values[key], 10, 64)
+			by, _ := strconv.ParseInt(args[4], 10, 64)
+			f.values[key] = strconv.FormatInt(n+by, 10)
+			return ":" + f.values[key] + "\r\n"
+		case redisDeleteIfScript:
+			if v, ok := f.values[key]; ok && v == args[4] {
+				delete(f.values, key)
+				return ":1\r\n"
+			}
+			return ":0\r\n"
+		}
+	}
+	return "-ERR unknown command '" + args[0] + "'\r\n"
+}
+
+func TestRedisStore(t *testing.T) {
+	f, addr := startFakeRedis(t)
+	u, _ := url.Parse("redis://:pw@" + addr + "/2")
+	s, err := openRedisStore(u)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	ctx := context.Background()
+
+	if err := s.Set(ctx, "k", []byte("v\r\nwith newline"), time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	if v, ok, err := s.Get(ctx, "k"); err != nil || !ok || string(v) != "v\r\nwith newline" {
+		t.Errorf("Get = %q, %v, %v", v, ok, err)
+	}
+	if _, ok, err := s.Get(ctx, "missing"); err != nil || ok {
+		t.Errorf("Get of a missing key = %v, %v", ok, err)
+	}
+	for want := int64(2); want <= 4; want += 2 {
+		if n, err := s.Incr(ctx, "c", 2, time.Minute); err != nil || n != want {
+			t.Errorf("Incr = %d, %v, want %d", n, err, want)
+		}
+	}
+
+	if ok, err := s.SetNX(ctx, "lock", []byte("a"), time.Minute); err != nil || !ok {
+		t.Errorf("first SetNX = %v, %v", ok, err)
+	}
+	if ok, err := s.SetNX(ctx, "lock", []byte("b"), time.Minute); err != nil || ok {
+		t.Errorf("second SetNX = %v, %v", ok, err)
+	}
+	if ok, err := s.DeleteIf(ctx, "lock", []byte("b")); err != nil || ok {
+		t.Errorf("DeleteIf with another value = %v, %v", ok, err)
+	}
+	if ok, err := s.DeleteIf(ctx, "lock", []byte("a")); err != nil || !ok {
+		t.Errorf("DeleteIf = %v, %v", ok, err)
+	}
+
+	// An error reply leaves the connection usable.
+	if _, err := s.do(ctx, "NOPE"); err == nil || !strings.Contains(err.Error(), "unknown command") {
+		t.Errorf("unknown command gave %v", err)
+	}
+	if _, _, err := s.Get(ctx, "k"); err != nil {
+		t.Errorf("Get after an error reply: %v", err)
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.conns != 1 {
+		t.Errorf("%d connections were opened, want 1", f.conns)
+	}
+	want := [][]string{{"AUTH", "pw"}, {"SELECT", "2"}, {"PING"}, {"SET", "k", "v\r\nwith newline", "PX", "60000"}}
+	if !reflect.DeepEqual(f.commands[:len(want)], want) {
+		t.Errorf("first commands = %q, want %q", f.commands[:len(want)], want)
+	}
+	incr := []string{"EVAL", redisIncrScript, "1", "c", "2", "60000"}
+	if !slicesContain(f.commands, incr) {
+		t.Errorf("Incr did not send %q", incr)
+	}
+	setNX := []string{"SET", "lock", "a", "NX", "PX", "60000"}
+	if !slicesContain(f.commands, setNX) {
+		t.Errorf("SetNX did not send %q", setNX)
+	}
+}
+
+func slicesContain(commands [][]string, want []string) bool {
+	for _, c := range commands {
+		if reflect.DeepEqual(c, want) {
+			return true
+		}
+	}
+	return false
+}
+
+func TestMemoryStoreLock(t *testing.T) {
+	s := NewMemoryStateStore()
+	ctx := context.Background()
+	if ok, _ := s.SetNX(ctx, "lock", []byte("a"), 20*time.Millisecond); !ok {
+		t.Fatal("first SetNX failed")
+	}
+	if ok, _ := s.SetNX(ctx, "lock", []byte("b"), time.Minute); ok {
+		t.Fatal("SetNX took a held lock")
+	}
+	time.Sleep(30 * time.Millisecond)
+	if ok, _ := s.SetNX(ctx, "lock", []byte("b"), time.Minute); !ok {
+		t.Fatal("SetNX did not take an expired lock")
+	}
+	if ok, _ := s.DeleteIf(ctx, "lock", []byte("a")); ok {
+		t.Fatal("the expired holder released the new lock")
+	}
+	if ok, _ := s.DeleteIf(ctx, "lock", []byte("b")); !ok {
+		t.Fatal("the holder could not release its lock")
+	}
+}
@@ -0,0 +1,158 @@
+package utils
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+	"time"
+)
+
+// CallLLMStructured asks for a JSON reply matching schema and unmarshals it
+// into out, which must be a pointer. schema is a JSON Schema as a
+// map[string]any or raw JSON, or nil to derive it from out's type with
+// SchemaFor. The reply is enforced natively (Gemini's responseMimeType and
+// responseSchema, or OpenAI's response_format) and checked again here; a
+// reply that is not valid JSON or does not match is retried once with the
+// problem explained.
+func CallLLMStructured(ctx context.Context, prompt string, schema any, out any) error {
+	return CallLLMStructuredWithConfig(ctx, prompt, schema, out, DefaultLLMConfig())
+}
+
+// CallLLMStructuredWithConfig is CallLLMStructured with a given config,
+// whose Format and Schema it overrides.
+func CallLLMStructuredWithConfig(ctx context.Context, prompt string, schema any, out any, config *LLMConfig) error {
+	if v := reflect.ValueOf(out); v.Kind() != reflect.Pointer || v.IsNil() {
+		return fmt.Errorf("CallLLMStructured needs a non-nil pointer to decode into, got %T", out)
+	}
+	resolved, err := resolveSchema(schema, out)
+	if err != nil {
+		return err
+	}
+	structured := *config
+	structured.Format, structured.Schema = FormatJSON, resolved
+
+	const maxAttempts = 2
+	attemptPrompt := prompt
+	var lastErr error
+	for attempt := 1; attempt <= maxAttempts; attempt++ {
+		reply, err := CallLLMWithConfig(ctx, attemptPrompt, &structured, false)
+		if err != nil {
+			return err
+		}
+		if lastErr = decodeStructured(ExtractJSON(reply), resolved, out); lastErr == nil {
+			return nil
+		}
+		attemptPrompt = fmt.Sprintf("%s\n\nYour previous reply was rejected: %v\nReply again with only JSON matching the schema.", prompt, lastErr)
+	}
+	return fmt.Errorf("no valid structured reply after %d attempts: %w", maxAttempts, lastErr)
+}
+
+// decodeStructured checks reply against schema and unmarshals it into out.
+func decodeStructured(reply string, schema map[string]any, out any) error {
+	var value any
+	if err := json.Unmarshal([]byte(reply), &value); err != nil {
+		return fmt.Errorf("invalid JSON: %w", err)
+	}
+	if err := ValidateJSONSchema(value, schema); err != nil {
+		return err
+	}
+	if err := json.Unmarshal([]byte(reply), out); err != nil {
+		return fmt.Errorf("JSON does not fit %T: %w", out, err)
+	}
+	return nil
+}
+
+// resolveSchema turns the schema argument of CallLLMStructured into a map.
+func resolveSchema(schema any, out any) (map[string]any, error) {
+	var raw []byte
+	switch s := schema.(type) {
+	case nil:
+		return SchemaFor(out), nil
+	case map[string]any:
+		return s, nil
+	case json.RawMessage:
+		raw = s
+	case []byte:
+		raw = s
+	case string:
+		raw = []byte(s)
+	default:
+		return nil, fmt.Errorf("unsupported schema type %T: pass a map, raw JSON or nil", schema)
+	}
+	var m map[string]any
+	if err := json.Unmarshal(raw, &m); err != nil {
+		return nil, fmt.Errorf("invalid schema: %w", err)
+	}
+	return m, nil
+}
+
+// SchemaFor derives a JSON Schema from the Go type of v (or what it points
+// to), following encoding/json: struct fields are named by their json tags,
+// fields tagged "-" are skipped, and fields without omitempty are required.
+// A `description` tag becomes the property's description.
+func SchemaFor(v any) map[string]any {
+	return schemaForType(reflect.TypeOf(v), map[reflect.Type]bool{})
+}
+
+var timeType = reflect.TypeOf(time.Time{})
+
+func schemaForType(t reflect.Type, seen map[reflect.Type]bool) map[string]any {
+	for t != nil && t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	if t == nil {
+		return map[string]any{}
+	}
+	if t == timeType {
+		return map[string]any{"type": "string", "format": "date-time"}
+	}
+	switch t.Kind() {
+	case reflect.String:
+		return map[string]any{"type": "string"}
+	case reflect.Bool:
+		return map[string]any{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]any{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]any{"type": "number"}
+	case reflect.Slice, reflect.Array:
+		return map[string]any{"type": "array", "items": schemaForType(t.Elem(), seen)}
+	case reflect.Map:
+		return map[string]any{"type": "object"}
+	case reflect.Struct:
+		// Recursive types are cut off at the repeat.
+		if seen[t] {
+			return map[string]any{"type": "object"}
+		}
+		seen[t] = true
+		defer delete(seen, t)
+		properties := map[string]any{}
+		required := []string{}
+		for i := 0; i < t.NumField(); i++ {
+			field := t.Field(i)
+			if !field.IsExported() {
+				continue
+			}
+			name, opts, _ := strings.Cut(field.Tag.Get("json"), ",")
+			if name == "-" && opts == "" {
+				continue
+			}
+			if name == "" {
+				name = field.Name
+			}
+			property := schemaForType(field.Type, seen)
+			if desc := field.Tag.Get("description"); desc != "" {
+				property["description"] = desc
+			}
+			properties[name] = property
+			if !strings.Contains(","+opts+",", ",omitempty,") {
+				required = append(required, name)
+			}
+		}
+		return map[string]any{"type": "object", "properties": properties, "required": required}
+	}
+	return map[string]any{}
+}
@@ -0,0 +1,417 @@
+package utils
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// maxSymbolReferences bounds how many references a symbol report lists.
+const maxSymbolReferences = 60
+
+// SymbolLocation is a place in the workspace where a symbol is defined or used.
+type SymbolLocation struct {
+	Path   string // relative to the workspace
+	Line   int    // 1-based
+	Column int    // 1-based; 0 when unknown
+	Kind   string // e.g. "Function" or "class"; definitions only
+	Text   string // the source line, trimmed
+}
+
+func (l SymbolLocation) String() string {
+	s := fmt.Sprintf("%s:%d", l.Path, l.Line)
+	if l.Kind != "" {
+		s += " [" + l.Kind + "]"
+	}
+	if l.Text != "" {
+		s += ": " + l.Text
+	}
+	return s
+}
+
+// SymbolTool lets the model look symbols up in the workspace instead of guessing.
+var SymbolTool = ToolSpec{
+	Name:        "find_symbol",
+	Description: "Find where a function, type, method or variable is defined in the current workspace and, optionally, every place that references it.",
+	Parameters: map[string]any{
+		"type": "object",
+		"properties": map[string]any{
+			"symbol":     map[string]any{"type": "string", "description": "Identifier to look up, e.g. Start or Server.Start"},
+			"references": map[string]any{"type": "boolean", "description": "Also list the references (callers and uses)"},
+		},
+		"required": []string{"symbol"},
+	},
+}
+
+// RunSymbolTool answers a find_symbol call from the index of workspace.
+func RunSymbolTool(ctx context.Context, workspace string, call ToolCall) ToolResult {
+	result := ToolResult{CallID: call.ID, Name: call.Name}
+	symbol, _ := call.Args["symbol"].(string)
+	withRefs, _ := call.Args["references"].(bool)
+	report, err := SymbolReport(ctx, workspace, symbol, withRefs)
+	if err != nil {
+		result.Content, result.IsError = err.Error(), true
+		return result
+	}
+	result.Content = report
+	return result
+}
+
+var (
+	identPattern          = `\x60?([A-Za-z_][\w.]*[A-Za-z0-9_])(?:\(\))?\x60?`
+	symbolQuestionPattern = []*regexp.Regexp{
+		regexp.MustCompile(`(?i)\bwhere\s+(?:is|are)\s+(?:the\s+)?(?:(?:function|method|type|class|struct|variable|constant)\s+)?` + identPattern + `\s+(?:defined|declared|implemented)`),
+		regexp.MustCompile(`(?i)\b(?:who|what)\s+(?:calls|uses|references)\s+` + identPattern),
+		regexp.MustCompile(`(?i)\b(?:callers|references|usages|uses|definition|declaration)\s+(?:of|to)\s+` + identPattern),
+		regexp.MustCompile(`(?i)\bfind[_ ]symbol\s+` + identPattern),
+	}
+)
+
+// DetectSymbolQuestion returns the identifier a question asks to locate,
+// e.g. "where is parseConfig defined and who calls it" → "parseConfig".
+func DetectSymbolQuestion(question string) (string, bool) {
+	for _, re := range symbolQuestionPattern {
+		m := re.FindStringSubmatch(question)
+		if m == nil || commonWords[strings.ToLower(m[1])] {
+			continue
+		}
+		return m[1], true
+	}
+	return "", false
+}
+
+// WorkspaceRoot returns the enclosing directory of dir that holds .git or
+// go.mod, or dir itself when there is none.
+func WorkspaceRoot(dir string) string {
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return dir
+	}
+	for d := dir; ; d = filepath.Dir(d) {
+		for _, marker := range []string{".git", "go.mod"} {
+			if _, err := os.Stat(filepath.Join(d, marker)); err == nil {
+				return d
+			}
+		}
+		if filepath.Dir(d) == d {
+			return dir
+		}
+	}
+}
+
+// SymbolReport lists the definitions of symbol in workspace and, with
+// withRefs, its references. Go workspaces are indexed by gopls, others by
+// ctags; without either, definitions come from ChunkCode and references
+// from a whole-word scan of the source files.
+func SymbolReport(ctx context.Context, workspace, symbol string, withRefs bool) (string, error) {
+	symbol = strings.Trim(symbol, "`.() ")
+	if symbol == "" {
+		return "", fmt.Errorf("no symbol given")
+	}
+	defs, refs, index, err := lookupSymbol(ctx, workspace, symbol, withRefs)
+	if err != nil {
+		return "", err
+	}
+	if len(defs) == 0 {
+		return fmt.Sprintf("No definition of %s found in %s (indexed with %s).", symbol, workspace, index), nil
+	}
+	var b strings.Builder
+	fmt.Fprintf(&b, "Definitions of %s in %s (indexed with %s):\n", symbol, workspace, index)
+	for _, d := range defs {
+		fmt.Fprintf(&b, "- %s\n", d)
+	}
+	if !withRefs {
+		return b.String(), nil
+	}
+	fmt.Fprintf(&b, "\nReferences (%d):\n", len(refs))
+	for i, r := range refs {
+		if i == maxSymbolReferences {
+			fmt.Fprintf(&b, "- ... %d more\n", len(refs)-i)
+			break
+		}
+		fmt.Fprintf(&b, "- %s\n", r)
+	}
+	return b.String(), nil
+}
+
+// lookupSymbol picks the best index available for workspace and returns its name.
+func lookupSymbol(ctx context.Context, workspace, symbol string, withRefs bool) (defs, refs []SymbolLocation, index string, err error) {
+	if _, err := os.Stat(filepath.Join(workspace, "go.mod")); err == nil {
+		if _, err := exec.LookPath("gopls"); err == nil {
+			defs, err = goplsDefinitions(ctx, workspace, symbol)
+			if err == nil && withRefs {
+				for _, d := range defs {
+					r, err := goplsReferences(ctx, workspace, d)
+					if err != nil {
+						return nil, nil, "", err
+					}
+					refs = append(refs, r...)
+				}
+			}
+			sortLocations(refs)
+			return defs, refs, "gopls", err
+		}
+	}
+	if _, err := exec.LookPath("ctags"); err == nil {
+		defs, err = ctagsDefinitions(ctx, workspace, symbol)
+		index = "ctags"
+	} else {
+		defs, err = chunkDefinitions(workspace, symbol)
+		index = "the built-in code chunker"
+	}
+	if err == nil && withRefs {
+		refs, err = scanReferences(workspace, symbol, defs)
+	}
+	sortLocations(defs)
+	return defs, refs, index, err
+}
+
+// runIndexer runs an indexing tool in workspace with a timeout.
+func runIndexer(ctx context.Context, workspace, name string, args ...string) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(ctx, 60*time.Second)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, name, args...)
+	cmd.Dir = workspace
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("%s failed: %v: %s", name, err, strings.TrimSpace(stderr.String()))
+	}
+	return out, nil
+}
+
+// goplsLocation matches "path/file.go:12:6-11", the position format gopls prints.
+var goplsLocation = regexp.MustCompile(`^(.+?):(\d+):(\d+)(?:-\d+)?`)
+
+func goplsDefinitions(ctx context.Context, workspace, symbol string) ([]SymbolLocation, error) {
+	out, err := runIndexer(ctx, workspace, "gopls", "workspace_symbol", "-matcher=caseSensitive", symbol)
+	if err != nil {
+		return nil, err
+	}
+	var defs []SymbolLocation
+	// Lines look like "/abs/server.go:40:18-23 Server.Start Method".
+	for _, line := range strings.Split(string(out), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 2 || !symbolMatches(fields[1], symbol) {
+			continue
+		}
+		loc, ok := parseGoplsLocation(workspace, fields[0])
+		if !ok {
+			continue
+		}
+		if len(fields) > 2 {
+			loc.Kind = fields[2]
+		}
+		defs = append(defs, loc)
+	}
+	return defs, nil
+}
+
+func goplsReferences(ctx context.Context, workspace string, def SymbolLocation) ([]SymbolLocation, error) {
+	position := fmt.Sprintf("%s:%d:%d", filepath.Join(workspace, def.Path), def.Line, def.Column)
+	out, err := runIndexer(ctx, workspace, "gopls", "references", position)
+	if err != nil {
+		return nil, err
+	}
+	var refs []SymbolLocation
+	for _, line := range strings.Split(string(out), "\n") {
+		if loc, ok := parseGoplsLocation(workspace, strings.TrimSpace(line)); ok {
+			refs = append(refs, loc)
+		}
+	}
+	return refs, nil
+}
+
+func parseGoplsLocation(workspace, s string) (SymbolLocation, bool) {
+	m := goplsLocation.FindStringSubmatch(s)
+	if m == nil {
+		return SymbolLocation{}, false
+	}
+	line, _ := strconv.Atoi(m[2])
+	column, _ := strconv.Atoi(m[3])
+	return withSourceLine(workspace, SymbolLocation{Path: relativePath(workspace, m[1]), Line: line, Column: column}), true
+}
+
+func ctagsDefinitions(ctx context.Context, workspace, symbol string) ([]SymbolLocation, error) {
+	args := []string{"-R", "-f", "-", "--fields=+nK"}
+	for dir := range repoSkipDirs {
+		args = append(args, "--exclude="+dir)
+	}
+	out, err := runIndexer(ctx, workspace, "ctags", append(args, ".")...)
+	if err != nil {
+		return nil, err
+	}
+	name := symbol[strings.LastIndex(symbol, ".")+1:]
+	var defs []SymbolLocation
+	// Lines look like "name<TAB>file<TAB>/^pattern$/;\"<TAB>function<TAB>line:12".
+	scanner := bufio.NewScanner(bytes.NewReader(out))
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		fields := strings.Split(scanner.Text(), "\t")
+		if len(fields) < 4 || fields[0] != name {
+			continue
+		}
+		loc := SymbolLocation{Path: relativePath(workspace, fields[1])}
+		for _, f := range fields[3:] {
+			if n, ok := strings.CutPrefix(f, "line:"); ok {
+				loc.Line, _ = strconv.Atoi(n)
+			} else if !strings.Contains(f, ":") {
+				loc.Kind = f
+			}
+		}
+		if loc.Line > 0 {
+			defs = append(defs, withSourceLine(workspace, loc))
+		}
+	}
+	return defs, scanner.Err()
+}
+
+// chunkDefinitions finds the declarations named symbol with ChunkCode.
+func chunkDefinitions(workspace, symbol string) ([]SymbolLocation, error) {
+	var defs []SymbolLocation
+	err := WalkSourceFiles(workspace, func(path, language string, content []byte) {
+		for _, c := range ChunkCode(string(content), language, 0) {
+			kind, names := splitChunkSymbol(c.Symbol)
+			for _, name := range names {
+				if !symbolMatches(name, symbol) {
+					continue
+				}
+				// The chunk may start with comments; point at the declaration itself.
+				loc := SymbolLocation{Path: relativePath(workspace, path), Line: c.StartLine, Kind: kind}
+				for i, line := range strings.Split(c.Text, "\n") {
+					if !isLeadingLine(line) && wholeWord(symbol[strings.LastIndex(symbol, ".")+1:]).MatchString(line) {
+						loc.Line = c.StartLine + i
+						break
+					}
+				}
+				defs = append(defs, withSourceLine(workspace, loc))
+			}
+		}
+	})
+	return defs, err
+}
+
+// splitChunkSymbol splits a CodeChunk symbol such as "class Parser",
+// "var a, b" or "Server.Start" into its kind and names.
+func splitChunkSymbol(symbol string) (string, []string) {
+	if symbol == "" || strings.HasPrefix(symbol, "package ") {
+		return "", nil
+	}
+	kind, names, ok := strings.Cut(symbol, " ")
+	if !ok {
+		return "func", []string{symbol}
+	}
+	var out []string
+	for _, n := range strings.Split(names, ",") {
+		out = append(out, strings.TrimSpace(n))
+	}
+	return kind, out
+}
+
+// scanReferences lists the lines that mention symbol as a whole word, other
+// than its definitions.
+func scanReferences(workspace, symbol string, defs []SymbolLocation) ([]SymbolLocation, error) {
+	isDef := make(map[string]bool, len(defs))
+	for _, d := range defs {
+		isDef[fmt.Sprintf("%s:%d", d.Path, d.Line)] = true
+	}
+	word := wholeWord(symbol[strings.LastIndex(symbol, ".")+1:])
+	var refs []SymbolLocation
+	err := WalkSourceFiles(workspace, func(path, language string, content []byte) {
+		rel := relativePath(workspace, path)
+		for i, line := range strings.Split(string(content), "\n") {
+			if !word.MatchString(line) || isDef[fmt.Sprintf("%s:%d", rel, i+1)] {
+				continue
+			}
+			refs = append(refs, SymbolLocation{Path: rel, Line: i + 1, Text: strings.TrimSpace(line)})
+		}
+	})
+	return refs, err
+}
+
+// WalkSourceFiles calls fn for every source file in workspace, skipping
+// hidden, dependency and build directories the way the repo connector does.
+func WalkSourceFiles(workspace string, fn func(path, language string, content []byte)) error {
+	return filepath.WalkDir(workspace, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			if path != workspace && (repoSkipDirs[d.Name()] || strings.HasPrefix(d.Name(), ".")) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		language := CodeLanguage(path)
+		if language == "" || language == "markdown" {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil || info.Size() > repoMaxFileSize {
+			return err
+		}
+		content, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		fn(path, language, content)
+		return nil
+	})
+}
+
+// symbolMatches reports whether an indexed name such as "Server.Start" is
+// the symbol asked for, which may omit the receiver or type.
+func symbolMatches(name, symbol string) bool {
+	return name == symbol || strings.HasSuffix(name, "."+symbol)
+}
+
+func wholeWord(name string) *regexp.Regexp {
+	return regexp.MustCompile(`\b` + regexp.QuoteMeta(name) + `\b`)
+}
+
+func relativePath(workspace, path string) string {
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(workspace, path)
+	}
+	if rel, err := filepath.Rel(workspace, path); err == nil {
+		return filepath.ToSlash(rel)
+	}
+	return path
+}
+
+// withSourceLine fills in the text of the line loc points at.
+func withSourceLine(workspace string, loc SymbolLocation) SymbolLocation {
+	if loc.Text != "" {
+		return loc
+	}
+	content, err := os.ReadFile(filepath.Join(workspace, loc.Path))
+	if err != nil {
+		return loc
+	}
+	lines := strings.Split(string(content), "\n")
+	if loc.Line >= 1 && loc.Line <= len(lines) {
+		loc.Text = strings.TrimSpace(lines[loc.Line-1])
+	}
+	return loc
+}
+
+// sortLocations orders locations by file and line.
+func sortLocations(locs []SymbolLocation) {
+	sort.Slice(locs, func(i, j int) bool {
+		if locs[i].Path != locs[j].Path {
+			return locs[i].Path < locs[j].Path
+		}
+		return locs[i].Line < locs[j].Line
+	})
+}
@@ -0,0 +1,244 @@
+package utils
+
+import (
+	"bytes"
+	"encoding/csv"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+)
+
+var tableSeparatorPattern = regexp.MustCompile(`^\s*\|?\s*:?-+:?\s*(\|\s*:?-+:?\s*)*\|?\s*$`)
+
+// Column alignments taken from a markdown table's separator row.
+const (
+	AlignLeft = iota
+	AlignCenter
+	AlignRight
+)
+
+// MarkdownTable is a pipe table found in a markdown answer.
+type MarkdownTable struct {
+	Header []string
+	Align  []int
+	Rows   [][]string
+
+	// first and last line of the table in the source text
+	startLine, endLine int
+}
+
+// FindMarkdownTables returns the pipe tables in markdown, skipping fenced code blocks.
+func FindMarkdownTables(markdown string) []MarkdownTable {
+	lines := strings.Split(markdown, "\n")
+	var tables []MarkdownTable
+	inFence := false
+	for i := 0; i < len(lines); i++ {
+		if strings.HasPrefix(strings.TrimSpace(lines[i]), "```") {
+			inFence = !inFence
+			continue
+		}
+		if inFence || i+1 >= len(lines) || !strings.Contains(lines[i], "|") || !tableSeparatorPattern.MatchString(lines[i+1]) {
+			continue
+		}
+		header := splitTableRow(lines[i])
+		separator := splitTableRow(lines[i+1])
+		if len(header) != len(separator) {
+			continue
+		}
+
+		t := MarkdownTable{Header: header, Align: make([]int, len(header)), startLine: i}
+		for c, cell := range separator {
+			cell = strings.TrimSpace(cell)
+			switch {
+			case strings.HasPrefix(cell, ":") && strings.HasSuffix(cell, ":"):
+				t.Align[c] = AlignCenter
+			case strings.HasSuffix(cell, ":"):
+				t.Align[c] = AlignRight
+			}
+		}
+		j := i + 2
+		for ; j < len(lines) && strings.Contains(lines[j], "|") && strings.TrimSpace(lines[j]) != ""; j++ {
+			row := splitTableRow(lines[j])
+			// Pad or trim ragged rows to the header width.
+			for len(row) < len(header) {
+				row = append(row, "")
+			}
+			t.Rows = append(t.Rows, row[:len(header)])
+		}
+		t.endLine = j - 1
+		tables = append(tables, t)
+		i = j - 1
+	}
+	return tables
+}
+
+func splitTableRow(line string) []string {
+	line = strings.TrimSpace(line)
+	line = strings.TrimPrefix(line, "|")
+	if strings.HasSuffix(line, "|") && !strings.HasSuffix(line, `\|`) {
+		line = line[:len(line)-1]
+	}
+	var cells []string
+	var cell strings.Builder
+	for i := 0; i < len(line); i++ {
+		switch {
+		case line[i] == '\\' && i+1 < len(line) && line[i+1] == '|':
+			cell.WriteByte('|')
+			i++
+		case line[i] == '|':
+			cells = append(cells, strings.TrimSpace(cell.String()))
+			cell.Reset()
+		default:
+			cell.WriteByte(line[i])
+		}
+	}
+	return append(cells, strings.TrimSpace(cell.String()))
+}
+
+// CSV returns the table, header first, encoded as CSV.
+func (t MarkdownTable) CSV() string {
+	var buf bytes.Buffer
+	w := csv.NewWriter(&buf)
+	w.Write(t.Header)
+	w.WriteAll(t.Rows)
+	return buf.String()
+}
+
+// Render draws the table with box-drawing borders, wrapping cell text so the
+// table fits within maxWidth columns where possible.
+func (t MarkdownTable) Render(maxWidth int) string {
+	n := len(t.Header)
+	widths := make([]int, n)
+	for _, row := range append([][]string{t.Header}, t.Rows...) {
+		for c, cell := range row {
+			widths[c] = max(widths[c], utf8.RuneCountInString(cell))
+		}
+	}
+
+	// Shrink the widest column until the table fits; borders take 3 columns per cell plus one.
+	available := maxWidth - (3*n + 1)
+	for sum(widths) > available {
+		widest := 0
+		for c := range widths {
+			if widths[c] > widths[widest] {
+				widest = c
+			}
+		}
+		if widths[widest] <= 4 {
+			break
+		}
+		widths[widest]--
+	}
+
+	var b strings.Builder
+	border := func(left, mid, right string) {
+		b.WriteString(left)
+		for c, w := range widths {
+			if c > 0 {
+				b.WriteString(mid)
+			}
+			b.WriteString(strings.Repeat("─", w+2))
+		}
+		b.WriteString(right + "\n")
+	}
+	row := func(cells []string) {
+		wrapped := make([][]string, n)
+		height := 1
+		for c, cell := range cells {
+			wrapped[c] = wrapCell(cell, widths[c])
+			height = max(height, len(wrapped[c]))
+		}
+		for l := 0; l < height; l++ {
+			b.WriteString("│")
+			for c := range cells {
+				text := ""
+				if l < len(wrapped[c]) {
+					text = wrapped[c][l]
+				}
+				b.WriteString(" " + alignCell(text, widths[c], t.Align[c]) + " │")
+			}
+			b.WriteString("\n")
+		}
+	}
+
+	border("┌", "┬", "┐")
+	row(t.Header)
+	border("├", "┼", "┤")
+	for _, r := range t.Rows {
+		row(r)
+	}
+	border("└", "┴", "┘")
+	return b.String()
+}
+
+func sum(values []int) int {
+	total := 0
+	for _, v := range values {
+		total += v
+	}
+	return total
+}
+
+func alignCell(text string, width, align int) string {
+	pad := width - utf8.RuneCountInString(text)
+	if pad <= 0 {
+		return text
+	}
+	switch align {
+	case AlignRight:
+		return strings.Repeat(" ", pad) + text
+	case AlignCenter:
+		return strings.Repeat(" ", pad/2) + text + strings.Repeat(" ", pad-pad/2)
+	default:
+		return text + strings.Repeat(" ", pad)
+	}
+}
+
+// wrapCell word-wraps text to width, hard-breaking words that are too long.
+func wrapCell(text string, width int) []string {
+	var lines []string
+	line := ""
+	for _, word := range strings.Fields(text) {
+		for utf8.RuneCountInString(word) > width {
+			if line != "" {
+				lines = append(lines, line)
+				line = ""
+			}
+			r := []rune(word)
+			lines = append(lines, string(r[:width]))
+			word = string(r[width:])
+		}
+		switch {
+		case line == "":
+			line = word
+		case utf8.RuneCountInString(line)+1+utf8.RuneCountInString(word) <= width:
+			line += " " + word
+		default:
+			lines = append(lines, line)
+			line = word
+		}
+	}
+	if line != "" || len(lines) == 0 {
+		lines = append(lines, line)
+	}
+	return lines
+}
+
+// RenderMarkdownTables replaces every pipe table in markdown with an aligned
+// terminal table no wider than maxWidth.
+func RenderMarkdownTables(markdown string, maxWidth int) string {
+	tables := FindMarkdownTables(markdown)
+	if len(tables) == 0 {
+		return markdown
+	}
+	lines := strings.Split(markdown, "\n")
+	var out []string
+	last := 0
+	for _, t := range tables {
+		out = append(out, lines[last:t.startLine]...)
+		out = append(out, strings.TrimSuffix(t.Render(maxWidth), "\n"))
+		last = t.endLine + 1
+	}
+	out = append(out, lines[last:]...)
+	return strings.Join(out, "\n")
+}
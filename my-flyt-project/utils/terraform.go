@@ -0,0 +1,145 @@
+package utils
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// TerraformPlan is the part of `terraform show -json plan.out` the plan
+// explanation uses.
+type TerraformPlan struct {
+	FormatVersion    string           `json:"format_version"`
+	TerraformVersion string           `json:"terraform_version"`
+	ResourceChanges  []ResourceChange `json:"resource_changes"`
+}
+
+// ResourceChange is the planned change of one resource instance.
+type ResourceChange struct {
+	Address      string `json:"address"`
+	ModuleAddr   string `json:"module_address,omitempty"`
+	Mode         string `json:"mode"` // "managed" or "data"
+	Type         string `json:"type"`
+	Name         string `json:"name"`
+	ProviderName string `json:"provider_name"`
+	Change       Change `json:"change"`
+	ActionReason string `json:"action_reason,omitempty"`
+}
+
+// Change holds the actions and the values before and after them. Only
+// attribute names are ever put in a prompt, so sensitive values never leave.
+type Change struct {
+	Actions      []string `json:"actions"`
+	Before       any      `json:"before"`
+	After        any      `json:"after"`
+	AfterUnknown any      `json:"after_unknown"`
+	ReplacePaths [][]any  `json:"replace_paths,omitempty"`
+}
+
+// ParseTerraformPlan parses the JSON plan representation.
+func ParseTerraformPlan(data []byte) (*TerraformPlan, error) {
+	var plan TerraformPlan
+	if err := json.Unmarshal(data, &plan); err != nil {
+		return nil, fmt.Errorf("not a terraform JSON plan (use terraform show -json plan.out): %w", err)
+	}
+	if plan.FormatVersion == "" {
+		return nil, fmt.Errorf("not a terraform JSON plan: no format_version (use terraform show -json plan.out)")
+	}
+	return &plan, nil
+}
+
+// Action sums up the change as create, update, delete, replace, read or no-op.
+func (rc ResourceChange) Action() string {
+	actions := rc.Change.Actions
+	switch {
+	case len(actions) == 2:
+		return "replace" // ["delete","create"] or ["create","delete"]
+	case len(actions) == 1:
+		return actions[0]
+	}
+	return "no-op"
+}
+
+// Destructive reports whether applying the change destroys the resource,
+// including replacing it.
+func (rc ResourceChange) Destructive() bool {
+	action := rc.Action()
+	return rc.Mode != "data" && (action == "delete" || action == "replace")
+}
+
+// ChangedAttributes lists the top-level attributes whose value changes or
+// becomes known only after apply.
+func (rc ResourceChange) ChangedAttributes() []string {
+	before, _ := rc.Change.Before.(map[string]any)
+	after, _ := rc.Change.After.(map[string]any)
+	unknown, _ := rc.Change.AfterUnknown.(map[string]any)
+	seen := map[string]bool{}
+	for k, v := range after {
+		if !reflect.DeepEqual(before[k], v) {
+			seen[k] = true
+		}
+	}
+	for k := range before {
+		if _, ok := after[k]; !ok && rc.Change.After != nil {
+			seen[k] = true
+		}
+	}
+	for k, v := range unknown {
+		if v == true {
+			seen[k] = true
+		}
+	}
+	names := make([]string, 0, len(seen))
+	for k := range seen {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// ForcesReplacement lists the attribute paths that force a replacement.
+func (rc ResourceChange) ForcesReplacement() []string {
+	var paths []string
+	for _, p := range rc.Change.ReplacePaths {
+		parts := make([]string, len(p))
+		for i, step := range p {
+			parts[i] = fmt.Sprint(step)
+		}
+		paths = append(paths, strings.Join(parts, "."))
+	}
+	return paths
+}
+
+// Changes returns the resource changes that do something, data reads
+// excluded, grouped by resource type in address order.
+func (p *TerraformPlan) Changes() map[string][]ResourceChange {
+	groups := map[string][]ResourceChange{}
+	for _, rc := range p.ResourceChanges {
+		if action := rc.Action(); action == "no-op" || action == "read" {
+			continue
+		}
+		groups[rc.Type] = append(groups[rc.Type], rc)
+	}
+	for _, g := range groups {
+		sort.Slice(g, func(i, j int) bool { return g[i].Address < g[j].Address })
+	}
+	return groups
+}
+
+// Describe is a compact, value-free description of the change for a prompt.
+func (rc ResourceChange) Describe() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "- %s: %s", rc.Address, rc.Action())
+	if rc.ActionReason != "" {
+		fmt.Fprintf(&b, " (reason: %s)", rc.ActionReason)
+	}
+	if attrs := rc.ChangedAttributes(); len(attrs) > 0 && rc.Action() != "delete" {
+		fmt.Fprintf(&b, "; changed attributes: %s", strings.Join(attrs, ", "))
+	}
+	if paths := rc.ForcesReplacement(); len(paths) > 0 {
+		fmt.Fprintf(&b, "; replacement forced by: %s", strings.Join(paths, ", "))
+	}
+	return b.String()
+}
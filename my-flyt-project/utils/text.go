@@ -167,3 +167,92 @@ func CountTokens(text string) int {
 	}
 	return tokensByChars
 }
+
+// ExtractJSON returns the JSON document embedded in an LLM reply, stripping
+// markdown code fences and any prose before or after it.
+func ExtractJSON(text string) string {
+	text = strings.TrimSpace(text)
+	if i := strings.Index(text, "```"); i >= 0 {
+		rest := text[i+3:]
+		if nl := strings.Index(rest, "\n"); nl >= 0 {
+			rest = rest[nl+1:]
+		}
+		if end := strings.Index(rest, "```"); end >= 0 {
+			rest = rest[:end]
+		}
+		text = strings.TrimSpace(rest)
+	}
+
+	start := strings.IndexAny(text, "[{")
+	if start < 0 {
+		return text
+	}
+	closing := "}"
+	if text[start] == '[' {
+		closing = "]"
+	}
+	end := strings.LastIndex(text, closing)
+	if end < start {
+		return text[start:]
+	}
+	return text[start : end+1]
+}
+
+// ChunkLines splits text into chunks of at most maxChars characters without
+// breaking lines, so log entries and code stay intact. A single line longer
+// than maxChars becomes its own chunk.
+func ChunkLines(text string, maxChars int) []string {
+	if maxChars <= 0 {
+		return []string{text}
+	}
+
+	var chunks []string
+	var current strings.Builder
+	for _, line := range strings.SplitAfter(text, "\n") {
+		if current.Len() > 0 && current.Len()+len(line) > maxChars {
+			chunks = append(chunks, current.String())
+			current.Reset()
+		}
+		current.WriteString(line)
+	}
+	if strings.TrimSpace(current.String()) != "" {
+		chunks = append(chunks, current.String())
+	}
+	return chunks
+}
+
+// ExtractCodeBlock returns the contents of the first fenced code block in
+// text, or the trimmed text itself when it contains no fence.
+func ExtractCodeBlock(text string) string {
+	start := strings.Index(text, "```")
+	if start < 0 {
+		return strings.TrimSpace(text)
+	}
+	rest := text[start+3:]
+	if nl := strings.Index(rest, "\n"); nl >= 0 {
+		rest = rest[nl+1:]
+	}
+	if end := strings.Index(rest, "```"); end >= 0 {
+		rest = rest[:end]
+	}
+	return strings.TrimSpace(rest)
+}
+
+// MaxAttachedChars bounds the piped text AttachText adds to a question.
+const MaxAttachedChars = 200_000
+
+// AttachText adds text, such as piped stdin, to question as a fenced code
+// block. The fence is longer than any backtick run in text so it cannot be
+// closed early, and text over MaxAttachedChars is cut with a warning.
+func AttachText(question, text string) string {
+	text = strings.TrimRight(text, "\n")
+	if cut := truncateRunes(text, MaxAttachedChars); cut != text {
+		PrintWarning("⚠️  Piped input is longer than %d characters; only the start is attached", MaxAttachedChars)
+		text = cut
+	}
+	fence := "```"
+	for strings.Contains(text, fence) {
+		fence += "`"
+	}
+	return fmt.Sprintf("%s\n\n%s\n%s\n%s", strings.TrimSpace(question), fence, text, fence)
+}
@@ -0,0 +1,107 @@
+package utils
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+)
+
+// Style identifies a kind of terminal output that a Theme colors.
+type Style int
+
+const (
+	StyleUser Style = iota
+	StyleAI
+	StyleCitation
+	StyleStatus
+	StyleWarning
+	StyleError
+	StyleHeading
+	StyleCode
+)
+
+// Theme maps each Style to an ANSI SGR sequence (for example "1;36") and
+// names the bat theme used to highlight answers and code blocks.
+type Theme struct {
+	Styles   map[Style]string
+	BatTheme string
+}
+
+// Themes are the built-in presets selectable with SetTheme.
+var Themes = map[string]Theme{
+	"dark": {
+		Styles: map[Style]string{
+			StyleUser:     "1;36",
+			StyleAI:       "1;32",
+			StyleCitation: "2;34",
+			StyleStatus:   "90",
+			StyleWarning:  "33",
+			StyleError:    "1;31",
+			StyleHeading:  "1;33",
+			StyleCode:     "36",
+		},
+		BatTheme: "Monokai Extended",
+	},
+	"light": {
+		Styles: map[Style]string{
+			StyleUser:     "1;34",
+			StyleAI:       "1;35",
+			StyleCitation: "34",
+			StyleStatus:   "2",
+			StyleWarning:  "33",
+			StyleError:    "31",
+			StyleHeading:  "1;34",
+			StyleCode:     "35",
+		},
+		BatTheme: "GitHub",
+	},
+	"none": {},
+}
+
+var currentTheme = Themes["dark"]
+
+// SetTheme selects one of the Themes presets.
+func SetTheme(name string) error {
+	theme, ok := Themes[name]
+	if !ok {
+		names := make([]string, 0, len(Themes))
+		for n := range Themes {
+			names = append(names, n)
+		}
+		sort.Strings(names)
+		return fmt.Errorf("unknown theme %q (available: %s)", name, strings.Join(names, ", "))
+	}
+	currentTheme = theme
+	return nil
+}
+
+// ColorEnabled reports whether output should be colored: stdout is a terminal,
+// NO_COLOR (https://no-color.org) is unset, and the theme is not "none".
+func ColorEnabled() bool {
+	return os.Getenv("NO_COLOR") == "" && currentTheme.Styles != nil && IsTerminal(os.Stdout)
+}
+
+// BatTheme returns the bat theme for the current theme, or "" for bat's default.
+func BatTheme() string {
+	return currentTheme.BatTheme
+}
+
+// Paint wraps text in the current theme's color for style.
+func Paint(style Style, text string) string {
+	code := currentTheme.Styles[style]
+	if code == "" || !ColorEnabled() {
+		return text
+	}
+	return "\x1b[" + code + "m" + text + "\x1b[0m"
+}
+
+// PrintStatus prints a progress line in the theme's status color.
+func PrintStatus(format string, a ...any) {
+	fmt.Println(Paint(StyleStatus, fmt.Sprintf(format, a...)))
+}
+
+// PrintWarning prints a recoverable problem in the theme's warning color.
+func PrintWarning(format string, a ...any) {
+	fmt.Println(Paint(StyleWarning, fmt.Sprintf(format, a...)))
+}
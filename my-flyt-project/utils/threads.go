@@ -0,0 +1,112 @@
+package utils
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// ThreadedProvider is implemented by providers that can keep a conversation
+// on their side, so each turn only sends the new prompt. GenerateInThread
+// continues threadID (a new thread when empty) and returns the ID to continue from next.
+type ThreadedProvider interface {
+	GenerateInThread(ctx context.Context, threadID, prompt string, config *LLMConfig) (reply, nextThreadID string, err error)
+}
+
+// ProviderSupportsThreads reports whether DefaultProvider keeps server-side threads.
+func ProviderSupportsThreads() bool {
+	_, ok := DefaultProvider.(ThreadedProvider)
+	return ok
+}
+
+// NewConversationID returns a unique ID for a conversation, stored in its History.
+func NewConversationID() string {
+	b := make([]byte, 4)
+	rand.Read(b)
+	return time.Now().Format("20060102T150405") + "-" + hex.EncodeToString(b)
+}
+
+// ThreadStore maps conversation IDs to the provider thread they continue.
+type ThreadStore struct {
+	path    string
+	Threads map[string]ThreadRef `json:"threads"`
+}
+
+// ThreadRef is the provider thread of a conversation.
+type ThreadRef struct {
+	ThreadID string    `json:"thread_id"`
+	Updated  time.Time `json:"updated"`
+}
+
+// DefaultThreadStorePath returns where the conversation-to-thread mapping is kept.
+func DefaultThreadStorePath() string {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		dir = os.TempDir()
+	}
+	return filepath.Join(dir, "ai_wraper", "threads.json")
+}
+
+// LoadThreadStore reads the mapping at path; a missing file is an empty mapping.
+func LoadThreadStore(path string) (*ThreadStore, error) {
+	store := &ThreadStore{path: path, Threads: map[string]ThreadRef{}}
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return store, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read thread mapping: %w", err)
+	}
+	if err := json.Unmarshal(data, store); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", path, err)
+	}
+	if store.Threads == nil {
+		store.Threads = map[string]ThreadRef{}
+	}
+	return store, nil
+}
+
+// Save writes the mapping atomically.
+func (s *ThreadStore) Save() error {
+	if err := os.MkdirAll(filepath.Dir(s.path), 0755); err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := s.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, s.path)
+}
+
+// CallLLMInThread sends only prompt and lets the provider supply the earlier
+// turns of the conversation from its server-side thread.
+func CallLLMInThread(ctx context.Context, conversationID, prompt string, config *LLMConfig) (string, error) {
+	config = withPersona(config)
+	provider, ok := DefaultProvider.(ThreadedProvider)
+	if !ok {
+		return "", fmt.Errorf("the provider keeps no server-side threads")
+	}
+	store, err := LoadThreadStore(DefaultThreadStorePath())
+	if err != nil {
+		return "", err
+	}
+
+	reply, next, err := provider.GenerateInThread(ctx, store.Threads[conversationID].ThreadID, prompt, config)
+	if err != nil {
+		return "", err
+	}
+	store.Threads[conversationID] = ThreadRef{ThreadID: next, Updated: time.Now()}
+	if err := store.Save(); err != nil {
+		return "", fmt.Errorf("failed to save thread mapping: %w", err)
+	}
+	return reply, nil
+}
@@ -0,0 +1,201 @@
+package utils
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// Ticket is a structured bug report or task drafted from a conversation.
+type Ticket struct {
+	Title              string   `json:"title"`
+	Type               string   `json:"type"` // "bug" or "task"
+	Summary            string   `json:"summary"`
+	Steps              []string `json:"steps,omitempty"`
+	Expected           string   `json:"expected,omitempty"`
+	Actual             string   `json:"actual,omitempty"`
+	Severity           string   `json:"severity"` // critical, major, minor or trivial
+	AcceptanceCriteria []string `json:"acceptance_criteria"`
+}
+
+// ticketTemplates are the built-in description layouts per tracker: Jira
+// wiki markup and Markdown. A file <tracker>.tmpl in TICKET_TEMPLATES overrides them.
+var ticketTemplates = map[string]string{
+	"jira": `{{.Summary}}
+{{if .Steps}}
+h3. Steps to reproduce
+{{range .Steps}}# {{.}}
+{{end}}{{end}}{{if .Expected}}
+h3. Expected
+{{.Expected}}
+{{end}}{{if .Actual}}
+h3. Actual
+{{.Actual}}
+{{end}}
+h3. Acceptance criteria
+{{range .AcceptanceCriteria}}* {{.}}
+{{end}}
+*Severity:* {{.Severity}}`,
+	"markdown": `{{.Summary}}
+{{if .Steps}}
+## Steps to reproduce
+{{range $i, $s := .Steps}}{{inc $i}}. {{$s}}
+{{end}}{{end}}{{if .Expected}}
+## Expected
+{{.Expected}}
+{{end}}{{if .Actual}}
+## Actual
+{{.Actual}}
+{{end}}
+## Acceptance criteria
+{{range .AcceptanceCriteria}}- [ ] {{.}}
+{{end}}
+**Severity:** {{.Severity}}`,
+}
+
+// RenderTicket formats the ticket description for tracker ("jira", "linear" or "markdown").
+func RenderTicket(t Ticket, tracker string) (string, error) {
+	text, ok := ticketTemplates[tracker]
+	if !ok {
+		text = ticketTemplates["markdown"]
+	}
+	if dir := os.Getenv("TICKET_TEMPLATES"); dir != "" {
+		if custom, err := os.ReadFile(filepath.Join(dir, tracker+".tmpl")); err == nil {
+			text = string(custom)
+		}
+	}
+	tmpl, err := template.New(tracker).Funcs(template.FuncMap{"inc": func(i int) int { return i + 1 }}).Parse(text)
+	if err != nil {
+		return "", fmt.Errorf("invalid %s ticket template: %w", tracker, err)
+	}
+	var b strings.Builder
+	if err := tmpl.Execute(&b, t); err != nil {
+		return "", fmt.Errorf("failed to render ticket: %w", err)
+	}
+	return strings.TrimSpace(b.String()), nil
+}
+
+// TicketTrackerConfigured reports whether the credentials for tracker are set:
+// JIRA_URL, JIRA_EMAIL, JIRA_API_TOKEN and JIRA_PROJECT, or LINEAR_API_KEY and LINEAR_TEAM_ID.
+func TicketTrackerConfigured(tracker string) bool {
+	switch tracker {
+	case "jira":
+		return os.Getenv("JIRA_URL") != "" && os.Getenv("JIRA_EMAIL") != "" && os.Getenv("JIRA_API_TOKEN") != "" && os.Getenv("JIRA_PROJECT") != ""
+	case "linear":
+		return os.Getenv("LINEAR_API_KEY") != "" && os.Getenv("LINEAR_TEAM_ID") != ""
+	}
+	return false
+}
+
+// CreateTicket files the ticket with tracker and returns its key and URL.
+func CreateTicket(t Ticket, tracker, description string) (string, error) {
+	if err := CheckWritable("creating a ticket"); err != nil {
+		return "", err
+	}
+	switch tracker {
+	case "jira":
+		return createJiraIssue(t, description)
+	case "linear":
+		return createLinearIssue(t, description)
+	}
+	return "", fmt.Errorf("unknown tracker %q (use jira or linear)", tracker)
+}
+
+func postTrackerJSON(url string, headers map[string]string, body any, out any) error {
+	jsonData, err := json.Marshal(body)
+	if err != nil {
+		return fmt.Errorf("failed to marshal request: %w", err)
+	}
+	req, err := http.NewRequest("POST", url, bytes.NewBuffer(jsonData))
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+	client := &http.Client{Timeout: 30 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to make request: %w", err)
+	}
+	defer resp.Body.Close()
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("failed to read response: %w", err)
+	}
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("request failed with status %d: %s", resp.StatusCode, truncateBody(respBody))
+	}
+	return json.Unmarshal(respBody, out)
+}
+
+func createJiraIssue(t Ticket, description string) (string, error) {
+	issueType := "Task"
+	if t.Type == "bug" {
+		issueType = "Bug"
+	}
+	base := strings.TrimSuffix(os.Getenv("JIRA_URL"), "/")
+	auth := "Basic " + base64.StdEncoding.EncodeToString([]byte(os.Getenv("JIRA_EMAIL")+":"+os.Getenv("JIRA_API_TOKEN")))
+
+	var result struct {
+		Key string `json:"key"`
+	}
+	err := postTrackerJSON(base+"/rest/api/2/issue", map[string]string{"Authorization": auth}, map[string]any{
+		"fields": map[string]any{
+			"project":     map[string]string{"key": os.Getenv("JIRA_PROJECT")},
+			"summary":     t.Title,
+			"description": description,
+			"issuetype":   map[string]string{"name": issueType},
+		},
+	}, &result)
+	if err != nil {
+		return "", fmt.Errorf("Jira: %w", err)
+	}
+	return fmt.Sprintf("%s %s/browse/%s", result.Key, base, result.Key), nil
+}
+
+// linearPriority maps severities to Linear priorities (1 urgent … 4 low).
+var linearPriority = map[string]int{"critical": 1, "major": 2, "minor": 3, "trivial": 4}
+
+func createLinearIssue(t Ticket, description string) (string, error) {
+	var result struct {
+		Data struct {
+			IssueCreate struct {
+				Success bool `json:"success"`
+				Issue   struct {
+					Identifier string `json:"identifier"`
+					URL        string `json:"url"`
+				} `json:"issue"`
+			} `json:"issueCreate"`
+		} `json:"data"`
+		Errors []struct {
+			Message string `json:"message"`
+		} `json:"errors"`
+	}
+	err := postTrackerJSON("https://api.linear.app/graphql", map[string]string{"Authorization": os.Getenv("LINEAR_API_KEY")}, map[string]any{
+		"query": `mutation($input: IssueCreateInput!) { issueCreate(input: $input) { success issue { identifier url } } }`,
+		"variables": map[string]any{"input": map[string]any{
+			"teamId":      os.Getenv("LINEAR_TEAM_ID"),
+			"title":       t.Title,
+			"description": description,
+			"priority":    linearPriority[t.Severity],
+		}},
+	}, &result)
+	if err != nil {
+		return "", fmt.Errorf("Linear: %w", err)
+	}
+	if len(result.Errors) > 0 {
+		return "", fmt.Errorf("Linear: %s", result.Errors[0].Message)
+	}
+	issue := result.Data.IssueCreate.Issue
+	return issue.Identifier + " " + issue.URL, nil
+}
@@ -0,0 +1,147 @@
+package utils
+
+import (
+	"context"
+	"fmt"
+	"sync"
+)
+
+// Tool is something the model can call by function calling. Tools are
+// added to a ToolRegistry, and flows offer what the registry holds, so a new
+// tool needs no change to the flows.
+type Tool interface {
+	Name() string
+	Description() string
+	// JSONSchema describes the arguments as a JSON Schema object.
+	JSONSchema() map[string]any
+	// Execute runs the tool with the model's arguments; the output is sent
+	// back to the model.
+	Execute(ctx context.Context, args map[string]any) (string, error)
+}
+
+// AvailableTool is a Tool that can be unusable here, such as one that needs
+// credentials that are not configured; registries leave it out until it is.
+type AvailableTool interface {
+	Tool
+	Available() bool
+}
+
+// FuncTool makes a Tool from a function.
+type FuncTool struct {
+	ToolName        string
+	ToolDescription string
+	Schema          map[string]any
+	Run             func(ctx context.Context, args map[string]any) (string, error)
+}
+
+func (t FuncTool) Name() string               { return t.ToolName }
+func (t FuncTool) Description() string        { return t.ToolDescription }
+func (t FuncTool) JSONSchema() map[string]any { return t.Schema }
+func (t FuncTool) Execute(ctx context.Context, args map[string]any) (string, error) {
+	return t.Run(ctx, args)
+}
+
+// ToolSpecOf declares t to a provider.
+func ToolSpecOf(t Tool) ToolSpec {
+	return ToolSpec{Name: t.Name(), Description: t.Description(), Parameters: t.JSONSchema()}
+}
+
+// ToolRegistry holds tools by name, in the order they were registered.
+type ToolRegistry struct {
+	mu    sync.Mutex
+	tools []Tool
+}
+
+// NewToolRegistry returns an empty registry.
+func NewToolRegistry() *ToolRegistry {
+	return &ToolRegistry{}
+}
+
+// Register adds t; a name can only be registered once.
+func (r *ToolRegistry) Register(t Tool) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, existing := range r.tools {
+		if existing.Name() == t.Name() {
+			return fmt.Errorf("tool %q is already registered", t.Name())
+		}
+	}
+	r.tools = append(r.tools, t)
+	return nil
+}
+
+// MustRegister is Register for tools registered at startup, where a
+// duplicate name is a programming error.
+func (r *ToolRegistry) MustRegister(tools ...Tool) {
+	for _, t := range tools {
+		if err := r.Register(t); err != nil {
+			panic(err)
+		}
+	}
+}
+
+// Get returns the tool with the given name.
+func (r *ToolRegistry) Get(name string) (Tool, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, t := range r.tools {
+		if t.Name() == name {
+			return t, true
+		}
+	}
+	return nil, false
+}
+
+// Tools returns the registered tools that are available, in order.
+func (r *ToolRegistry) Tools() []Tool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var tools []Tool
+	for _, t := range r.tools {
+		if a, ok := t.(AvailableTool); ok && !a.Available() {
+			continue
+		}
+		tools = append(tools, t)
+	}
+	return tools
+}
+
+// Names returns the names of every registered tool, available or not.
+func (r *ToolRegistry) Names() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	names := make([]string, len(r.tools))
+	for i, t := range r.tools {
+		names[i] = t.Name()
+	}
+	return names
+}
+
+// Offer sets config up to let the model call tools: it declares them and
+// runs each call with Execute, passing every result to observe (which may be
+// nil) before it is sent back.
+func Offer(config *LLMConfig, tools []Tool, observe func(call ToolCall, result ToolResult)) {
+	byName := make(map[string]Tool, len(tools))
+	config.Tools = config.Tools[:0:0]
+	for _, t := range tools {
+		config.Tools = append(config.Tools, ToolSpecOf(t))
+		byName[t.Name()] = t
+	}
+	config.RunTool = func(ctx context.Context, call ToolCall) ToolResult {
+		result := ToolResult{CallID: call.ID, Name: call.Name}
+		if t, ok := byName[call.Name]; ok {
+			content, err := t.Execute(ctx, call.Args)
+			if err != nil {
+				result.Content, result.IsError = err.Error(), true
+			} else {
+				result.Content = content
+			}
+		} else {
+			result.Content, result.IsError = fmt.Sprintf("unknown tool %q", call.Name), true
+		}
+		if observe != nil {
+			observe(call, result)
+		}
+		return result
+	}
+}
@@ -0,0 +1,301 @@
+package utils
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// ToolSpec declares a tool the model may call. Parameters is a JSON Schema
+// object describing the arguments.
+type ToolSpec struct {
+	Name        string
+	Description string
+	Parameters  map[string]any
+}
+
+// ToolCall is a model's request to run a tool, whatever provider it came from.
+type ToolCall struct {
+	ID   string         `json:"id"`
+	Name string         `json:"name"`
+	Args map[string]any `json:"args"`
+}
+
+// ToolResult is the output of a ToolCall, sent back to the model.
+type ToolResult struct {
+	CallID  string `json:"call_id"`
+	Name    string `json:"name"`
+	Content string `json:"content"`
+	IsError bool   `json:"is_error,omitempty"`
+}
+
+// ToolRunner runs a call the model made to one of LLMConfig.Tools.
+type ToolRunner func(ctx context.Context, call ToolCall) ToolResult
+
+// MaxToolRounds is how many rounds of tool calls one request allows before
+// the model is made to answer with what it has.
+var MaxToolRounds = 5
+
+// runToolCalls runs calls with config.RunTool. A call to a tool that was not
+// declared gets an error result, which the model can recover from.
+func runToolCalls(ctx context.Context, config *LLMConfig, calls []ToolCall) []ToolResult {
+	results := make([]ToolResult, 0, len(calls))
+	for _, call := range calls {
+		declared := false
+		for _, spec := range config.Tools {
+			declared = declared || spec.Name == call.Name
+		}
+		if !declared || config.RunTool == nil {
+			results = append(results, ToolResult{CallID: call.ID, Name: call.Name, Content: fmt.Sprintf("unknown tool %q", call.Name), IsError: true})
+			continue
+		}
+		PrintStatus("🔧 Calling %s(%s)...", call.Name, formatToolArgs(call.Args))
+		result := config.RunTool(ctx, call)
+		result.CallID, result.Name = call.ID, call.Name
+		results = append(results, result)
+	}
+	return results
+}
+
+// formatToolArgs renders call arguments compactly for status lines.
+func formatToolArgs(args map[string]any) string {
+	data, err := json.Marshal(args)
+	if err != nil {
+		return ""
+	}
+	return truncateRunes(strings.TrimSuffix(strings.TrimPrefix(string(data), "{"), "}"), 80)
+}
+
+// ToolDialect translates tool declarations, calls and results to and from
+// one provider's function-calling format, so the agent loop only deals with
+// ToolSpec, ToolCall and ToolResult.
+type ToolDialect interface {
+	// Declarations is the value of the request's tools field.
+	Declarations(specs []ToolSpec) any
+	// ParseCalls extracts the tool calls from the model's reply message
+	// (Gemini content, OpenAI message or Claude content array).
+	ParseCalls(reply json.RawMessage) ([]ToolCall, error)
+	// CallTurn is the model's turn requesting calls, replayed in the next request.
+	CallTurn(calls []ToolCall) map[string]any
+	// ResultTurns are the messages carrying the results back.
+	ResultTurns(results []ToolResult) []map[string]any
+}
+
+// ToolDialects holds the dialect of each provider family.
+var ToolDialects = map[string]ToolDialect{
+	"gemini": geminiTools{},
+	"openai": openAITools{},
+	"claude": claudeTools{},
+}
+
+type geminiTools struct{}
+
+func (geminiTools) Declarations(specs []ToolSpec) any {
+	declarations := make([]map[string]any, 0, len(specs))
+	for _, s := range specs {
+		declarations = append(declarations, map[string]any{
+			"name":        s.Name,
+			"description": s.Description,
+			"parameters":  geminiSchema(s.Parameters),
+		})
+	}
+	return []map[string]any{{"functionDeclarations": declarations}}
+}
+
+// geminiSchema drops the JSON Schema keywords Gemini's OpenAPI subset rejects.
+func geminiSchema(schema map[string]any) map[string]any {
+	out := make(map[string]any, len(schema))
+	for k, v := range schema {
+		switch k {
+		case "$schema", "additionalProperties", "default":
+			continue
+		case "properties":
+			if props, ok := v.(map[string]any); ok {
+				clean := make(map[string]any, len(props))
+				for name, p := range props {
+					if m, ok := p.(map[string]any); ok {
+						clean[name] = geminiSchema(m)
+					}
+				}
+				v = clean
+			}
+		case "items":
+			if m, ok := v.(map[string]any); ok {
+				v = geminiSchema(m)
+			}
+		}
+		out[k] = v
+	}
+	return out
+}
+
+func (geminiTools) ParseCalls(reply json.RawMessage) ([]ToolCall, error) {
+	var content struct {
+		Parts []struct {
+			FunctionCall *struct {
+				ID   string         `json:"id"`
+				Name string         `json:"name"`
+				Args map[string]any `json:"args"`
+			} `json:"functionCall"`
+		} `json:"parts"`
+	}
+	if err := json.Unmarshal(reply, &content); err != nil {
+		return nil, fmt.Errorf("failed to parse Gemini content: %w", err)
+	}
+	var calls []ToolCall
+	for _, p := range content.Parts {
+		if fc := p.FunctionCall; fc != nil {
+			// Gemini matches results by name and order; IDs are optional.
+			id := fc.ID
+			if id == "" {
+				id = fmt.Sprintf("call_%d", len(calls)+1)
+			}
+			calls = append(calls, ToolCall{ID: id, Name: fc.Name, Args: fc.Args})
+		}
+	}
+	return calls, nil
+}
+
+func (geminiTools) CallTurn(calls []ToolCall) map[string]any {
+	parts := make([]map[string]any, 0, len(calls))
+	for _, c := range calls {
+		parts = append(parts, map[string]any{"functionCall": map[string]any{"name": c.Name, "args": c.Args}})
+	}
+	return map[string]any{"role": "model", "parts": parts}
+}
+
+func (geminiTools) ResultTurns(results []ToolResult) []map[string]any {
+	parts := make([]map[string]any, 0, len(results))
+	for _, r := range results {
+		response := map[string]any{"content": r.Content}
+		if r.IsError {
+			response = map[string]any{"error": r.Content}
+		}
+		parts = append(parts, map[string]any{"functionResponse": map[string]any{"name": r.Name, "response": response}})
+	}
+	return []map[string]any{{"role": "user", "parts": parts}}
+}
+
+type openAITools struct{}
+
+func (openAITools) Declarations(specs []ToolSpec) any {
+	tools := make([]map[string]any, 0, len(specs))
+	for _, s := range specs {
+		tools = append(tools, map[string]any{
+			"type": "function",
+			"function": map[string]any{
+				"name":        s.Name,
+				"description": s.Description,
+				"parameters":  s.Parameters,
+			},
+		})
+	}
+	return tools
+}
+
+func (openAITools) ParseCalls(reply json.RawMessage) ([]ToolCall, error) {
+	var message struct {
+		ToolCalls []struct {
+			ID       string `json:"id"`
+			Function struct {
+				Name      string `json:"name"`
+				Arguments string `json:"arguments"`
+			} `json:"function"`
+		} `json:"tool_calls"`
+	}
+	if err := json.Unmarshal(reply, &message); err != nil {
+		return nil, fmt.Errorf("failed to parse OpenAI message: %w", err)
+	}
+	var calls []ToolCall
+	for _, tc := range message.ToolCalls {
+		// Arguments arrive as a JSON-encoded string.
+		args := map[string]any{}
+		if tc.Function.Arguments != "" {
+			if err := json.Unmarshal([]byte(tc.Function.Arguments), &args); err != nil {
+				return nil, fmt.Errorf("invalid arguments for %s: %w", tc.Function.Name, err)
+			}
+		}
+		calls = append(calls, ToolCall{ID: tc.ID, Name: tc.Function.Name, Args: args})
+	}
+	return calls, nil
+}
+
+func (openAITools) CallTurn(calls []ToolCall) map[string]any {
+	toolCalls := make([]map[string]any, 0, len(calls))
+	for _, c := range calls {
+		args, _ := json.Marshal(c.Args)
+		toolCalls = append(toolCalls, map[string]any{
+			"id":       c.ID,
+			"type":     "function",
+			"function": map[string]any{"name": c.Name, "arguments": string(args)},
+		})
+	}
+	return map[string]any{"role": "assistant", "tool_calls": toolCalls}
+}
+
+func (openAITools) ResultTurns(results []ToolResult) []map[string]any {
+	turns := make([]map[string]any, 0, len(results))
+	for _, r := range results {
+		content := r.Content
+		if r.IsError {
+			content = "Error: " + content
+		}
+		turns = append(turns, map[string]any{"role": "tool", "tool_call_id": r.CallID, "content": content})
+	}
+	return turns
+}
+
+type claudeTools struct{}
+
+func (claudeTools) Declarations(specs []ToolSpec) any {
+	tools := make([]map[string]any, 0, len(specs))
+	for _, s := range specs {
+		tools = append(tools, map[string]any{
+			"name":         s.Name,
+			"description":  s.Description,
+			"input_schema": s.Parameters,
+		})
+	}
+	return tools
+}
+
+func (claudeTools) ParseCalls(reply json.RawMessage) ([]ToolCall, error) {
+	var blocks []struct {
+		Type  string         `json:"type"`
+		ID    string         `json:"id"`
+		Name  string         `json:"name"`
+		Input map[string]any `json:"input"`
+	}
+	if err := json.Unmarshal(reply, &blocks); err != nil {
+		return nil, fmt.Errorf("failed to parse Claude content: %w", err)
+	}
+	var calls []ToolCall
+	for _, b := range blocks {
+		if b.Type == "tool_use" {
+			calls = append(calls, ToolCall{ID: b.ID, Name: b.Name, Args: b.Input})
+		}
+	}
+	return calls, nil
+}
+
+func (claudeTools) CallTurn(calls []ToolCall) map[string]any {
+	content := make([]map[string]any, 0, len(calls))
+	for _, c := range calls {
+		content = append(content, map[string]any{"type": "tool_use", "id": c.ID, "name": c.Name, "input": c.Args})
+	}
+	return map[string]any{"role": "assistant", "content": content}
+}
+
+func (claudeTools) ResultTurns(results []ToolResult) []map[string]any {
+	content := make([]map[string]any, 0, len(results))
+	for _, r := range results {
+		content = append(content, map[string]any{
+			"type":        "tool_result",
+			"tool_use_id": r.CallID,
+			"content":     r.Content,
+			"is_error":    r.IsError,
+		})
+	}
+	return []map[string]any{{"role": "user", "content": content}}
+}
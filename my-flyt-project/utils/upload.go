@@ -0,0 +1,256 @@
+package utils
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+)
+
+// MaxFileAPIBytes is the largest file the Gemini File API accepts.
+const MaxFileAPIBytes = 2 << 30
+
+// geminiUploadBase is where files are uploaded to the File API.
+const geminiUploadBase = "https://generativelanguage.googleapis.com/upload/v1beta/files"
+
+// attachmentSource is an attachment ready to be sent: images that are
+// preprocessed are held in memory (they are small once downscaled), every
+// other file is read from disk each time it is sent.
+type attachmentSource struct {
+	Path     string
+	MIMEType string
+	Size     int64
+	data     []byte
+}
+
+// openAttachment prepares path for sending without reading files that need
+// no preprocessing, such as PDFs and videos, into memory.
+func openAttachment(path string) (attachmentSource, error) {
+	mimeType, err := MIMETypeForPath(path)
+	if err != nil {
+		return attachmentSource{}, err
+	}
+	if needsPreparing(mimeType) {
+		data, mimeType, err := PrepareImage(path)
+		if err != nil {
+			return attachmentSource{}, err
+		}
+		return attachmentSource{Path: path, MIMEType: mimeType, Size: int64(len(data)), data: data}, nil
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return attachmentSource{}, fmt.Errorf("cannot attach %s: %w", path, err)
+	}
+	return attachmentSource{Path: path, MIMEType: mimeType, Size: info.Size()}, nil
+}
+
+// needsPreparing reports whether PrepareImage changes files of mimeType.
+func needsPreparing(mimeType string) bool {
+	switch mimeType {
+	case "image/jpeg", "image/png", "image/webp", "image/heic", "image/heif":
+		return true
+	}
+	return false
+}
+
+// Open returns the attachment's content.
+func (a attachmentSource) Open() (io.ReadCloser, error) {
+	if a.data != nil {
+		return io.NopCloser(bytes.NewReader(a.data)), nil
+	}
+	f, err := os.Open(a.Path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", a.Path, err)
+	}
+	return f, nil
+}
+
+// inlinePlaceholder returns a token that stands for the base64 data of
+// inline attachment i in a request marshaled by writeInlineBody.
+func inlinePlaceholder(nonce string, i int) string {
+	return fmt.Sprintf("@@attachment-%s-%d@@", nonce, i)
+}
+
+// newPlaceholderNonce returns a random nonce for inlinePlaceholder, so the
+// placeholders cannot collide with text in the prompt.
+func newPlaceholderNonce() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// writeInlineBody marshals requestBody to a temporary file, writing the
+// base64 data of inline[i] where the string inlinePlaceholder(nonce, i)
+// appears. Attachments are encoded straight from their files as they are
+// written, so they are never held in memory as base64 strings. The caller
+// removes the file.
+func writeInlineBody(requestBody any, nonce string, inline []attachmentSource) (*os.File, error) {
+	jsonData, err := json.Marshal(requestBody)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal request: %w", err)
+	}
+	f, err := os.CreateTemp("", "ai-request-*.json")
+	if err != nil {
+		return nil, fmt.Errorf("failed to buffer request: %w", err)
+	}
+	fail := func(err error) (*os.File, error) {
+		f.Close()
+		os.Remove(f.Name())
+		return nil, err
+	}
+	for i, a := range inline {
+		placeholder := []byte(inlinePlaceholder(nonce, i))
+		at := bytes.Index(jsonData, placeholder)
+		if at < 0 {
+			return fail(fmt.Errorf("attachment %s is missing from the request", a.Path))
+		}
+		if _, err := f.Write(jsonData[:at]); err != nil {
+			return fail(err)
+		}
+		if err := encodeBase64(f, a); err != nil {
+			return fail(err)
+		}
+		jsonData = jsonData[at+len(placeholder):]
+	}
+	if _, err := f.Write(jsonData); err != nil {
+		return fail(err)
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return fail(err)
+	}
+	return f, nil
+}
+
+// encodeBase64 streams the base64 encoding of a to w.
+func encodeBase64(w io.Writer, a attachmentSource) error {
+	r, err := a.Open()
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	enc := base64.NewEncoder(base64.StdEncoding, w)
+	if _, err := io.Copy(enc, r); err != nil {
+		return fmt.Errorf("failed to encode %s: %w", a.Path, err)
+	}
+	return enc.Close()
+}
+
+// newFileRequest builds a request that sends f as its body, reopened for
+// each retry by DoWithRetry.
+func newFileRequest(ctx context.Context, method, url string, f *os.File) (*http.Request, error) {
+	info, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, method, url, f)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	req.ContentLength = info.Size()
+	req.GetBody = func() (io.ReadCloser, error) {
+		return os.Open(f.Name())
+	}
+	return req, nil
+}
+
+// geminiFile is a file stored by the File API.
+type geminiFile struct {
+	Name     string `json:"name"`
+	URI      string `json:"uri"`
+	MIMEType string `json:"mimeType"`
+	State    string `json:"state"`
+	Error    *struct {
+		Message string `json:"message"`
+	} `json:"error"`
+}
+
+// uploadToFileAPI uploads a with the File API's resumable protocol,
+// streaming it from disk, and waits until Gemini has processed it (videos
+// take a while). The file is deleted by Gemini after 48 hours.
+func uploadToFileAPI(ctx context.Context, apiKey string, a attachmentSource) (geminiFile, error) {
+	if a.Size > MaxFileAPIBytes {
+		return geminiFile{}, fmt.Errorf("%s is %s, above the File API's %s limit", a.Path, FormatBytes(a.Size), FormatBytes(MaxFileAPIBytes))
+	}
+	PrintStatus("⬆️  Uploading %s (%s) to the Gemini File API...", a.Path, FormatBytes(a.Size))
+	client := &http.Client{Timeout: 30 * time.Minute}
+
+	metadata, _ := json.Marshal(map[string]any{"file": map[string]string{"display_name": filepath.Base(a.Path)}})
+	req, err := http.NewRequestWithContext(ctx, "POST", geminiUploadBase+"?key="+apiKey, bytes.NewReader(metadata))
+	if err != nil {
+		return geminiFile{}, fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Goog-Upload-Protocol", "resumable")
+	req.Header.Set("X-Goog-Upload-Command", "start")
+	req.Header.Set("X-Goog-Upload-Header-Content-Length", strconv.FormatInt(a.Size, 10))
+	req.Header.Set("X-Goog-Upload-Header-Content-Type", a.MIMEType)
+	resp, err := DoWithRetry(client, req)
+	if err != nil {
+		return geminiFile{}, fmt.Errorf("failed to start upload: %w", err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	uploadURL := resp.Header.Get("X-Goog-Upload-URL")
+	if resp.StatusCode != http.StatusOK || uploadURL == "" {
+		return geminiFile{}, fmt.Errorf("upload of %s refused with status %d: %s", a.Path, resp.StatusCode, body)
+	}
+
+	content, err := a.Open()
+	if err != nil {
+		return geminiFile{}, err
+	}
+	defer content.Close()
+	req, err = http.NewRequestWithContext(ctx, "POST", uploadURL, content)
+	if err != nil {
+		return geminiFile{}, fmt.Errorf("failed to create request: %w", err)
+	}
+	req.ContentLength = a.Size
+	req.Header.Set("X-Goog-Upload-Offset", "0")
+	req.Header.Set("X-Goog-Upload-Command", "upload, finalize")
+	// Sent once: a retry would have to resume at the offset the server
+	// reports, and a failed upload is simply started again.
+	resp, err = client.Do(req)
+	if err != nil {
+		return geminiFile{}, fmt.Errorf("failed to upload %s: %w", a.Path, err)
+	}
+	body, _ = io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return geminiFile{}, fmt.Errorf("upload of %s failed with status %d: %s", a.Path, resp.StatusCode, body)
+	}
+	var uploaded struct {
+		File geminiFile `json:"file"`
+	}
+	if err := json.Unmarshal(body, &uploaded); err != nil {
+		return geminiFile{}, fmt.Errorf("failed to parse upload response: %w", err)
+	}
+
+	file := uploaded.File
+	for file.State == "PROCESSING" {
+		select {
+		case <-time.After(5 * time.Second):
+		case <-ctx.Done():
+			return geminiFile{}, ctx.Err()
+		}
+		if err := geminiJSON(ctx, "GET", file.Name, nil, &file); err != nil {
+			return geminiFile{}, fmt.Errorf("failed to check %s: %w", a.Path, err)
+		}
+	}
+	if file.State == "FAILED" {
+		reason := "unknown error"
+		if file.Error != nil {
+			reason = file.Error.Message
+		}
+		return geminiFile{}, fmt.Errorf("Gemini could not process %s: %s", a.Path, reason)
+	}
+	return file, nil
+}
@@ -1,14 +1,33 @@
 package utils
 
-import "github.com/mark3labs/flyt"
+import (
+	"fmt"
+	"strings"
+
+	"github.com/mark3labs/flyt"
+)
 
 // This struct is now shared across the application.
 type Conversation struct {
 	User string
 	AI   any
+	// Pinned turns are never dropped when history is trimmed.
+	Pinned bool `json:",omitempty"`
+	// Muted turns stay in the saved transcript but are not sent to the model.
+	Muted bool `json:",omitempty"`
+	// Truncated turns hold an answer that was cut off while streaming; /continue resumes it.
+	Truncated bool `json:",omitempty"`
+	// Images are the paths of the images sent with the question.
+	Images []string `json:",omitempty"`
+	// Summarized turns are condensed in History.Summary and no longer sent as they are.
+	Summarized bool `json:",omitempty"`
 }
 
 type History struct {
+	// ID identifies the conversation, e.g. to find its provider-side thread.
+	ID string `json:",omitempty"`
+	// Summary condenses the summarized turns; it is sent before the others.
+	Summary       string `json:",omitempty"`
 	Conversations []Conversation
 }
 
@@ -34,6 +53,17 @@ func GetHistory(shared *flyt.SharedStore) History {
 					if ai, ok := m["AI"]; ok {
 						c.AI = ai
 					}
+					c.Pinned, _ = m["Pinned"].(bool)
+					c.Muted, _ = m["Muted"].(bool)
+					c.Truncated, _ = m["Truncated"].(bool)
+					c.Summarized, _ = m["Summarized"].(bool)
+					if images, ok := m["Images"].([]interface{}); ok {
+						for _, img := range images {
+							if path, ok := img.(string); ok {
+								c.Images = append(c.Images, path)
+							}
+						}
+					}
 					convs = append(convs, c)
 				}
 			}
@@ -42,3 +72,129 @@ func GetHistory(shared *flyt.SharedStore) History {
 		return History{}
 	}
 }
+
+// HistoryTokenBudget caps the estimated tokens of the history sent with a
+// prompt (-history-tokens); 0 sends all of it.
+var HistoryTokenBudget = 100_000
+
+// SummarizeAt is the estimated size in tokens above which the history's
+// older turns are condensed into its Summary (-summarize-at); 0 never does.
+var SummarizeAt = 50_000
+
+// SummaryKeepTurns is how many recent turns stay as they are when the older
+// ones are summarized.
+const SummaryKeepTurns = 4
+
+// ForPrompt returns the turns that should be sent to the model: the summary
+// of earlier turns, if any, then the rest without muted ones, fitted into
+// HistoryTokenBudget.
+func (h History) ForPrompt() []Conversation {
+	return FitHistory(h.promptTurns(), HistoryTokenBudget)
+}
+
+// Dropped is the number of turns ForPrompt leaves out to fit the budget.
+func (h History) Dropped() int {
+	return len(h.promptTurns()) - len(h.ForPrompt())
+}
+
+func (h History) promptTurns() []Conversation {
+	turns := make([]Conversation, 0, len(h.Conversations)+1)
+	if h.Summary != "" {
+		turns = append(turns, Conversation{User: "Summary of the earlier conversation", AI: h.Summary, Pinned: true})
+	}
+	for _, c := range h.Conversations {
+		if !c.Muted && !c.Summarized {
+			turns = append(turns, c)
+		}
+	}
+	return turns
+}
+
+// ToSummarize returns the indexes of the turns to fold into the summary once
+// the history sent is larger than SummarizeAt: every turn but the last
+// SummaryKeepTurns, except pinned and muted ones. It is empty otherwise.
+func (h History) ToSummarize() []int {
+	if SummarizeAt <= 0 || CountTokens(FormatHistory(h.promptTurns())) <= SummarizeAt {
+		return nil
+	}
+	var open []int
+	for i, c := range h.Conversations {
+		if !c.Muted && !c.Summarized {
+			open = append(open, i)
+		}
+	}
+	if len(open) <= SummaryKeepTurns {
+		return nil
+	}
+	var fold []int
+	for _, i := range open[:len(open)-SummaryKeepTurns] {
+		if !h.Conversations[i].Pinned {
+			fold = append(fold, i)
+		}
+	}
+	return fold
+}
+
+// FitHistory drops the oldest unpinned turns of history until its estimated
+// size is within budget tokens. The last turn is always kept; when the kept
+// turns are still too long, the answers of the unpinned ones are shortened,
+// oldest first. Pinned turns, the running summary among them, stay whole even
+// if the result is over budget.
+func FitHistory(history []Conversation, budget int) []Conversation {
+	if budget <= 0 || len(history) == 0 {
+		return history
+	}
+	sizes := make([]int, len(history))
+	total := 0
+	for i, c := range history {
+		sizes[i] = turnTokens(c)
+		total += sizes[i]
+	}
+	if total <= budget {
+		return history
+	}
+	fitted := make([]Conversation, 0, len(history))
+	for i, c := range history {
+		if total > budget && i < len(history)-1 && !c.Pinned {
+			total -= sizes[i]
+			continue
+		}
+		fitted = append(fitted, c)
+	}
+	for i := range fitted {
+		if total <= budget {
+			break
+		}
+		if fitted[i].Pinned {
+			continue
+		}
+		size := turnTokens(fitted[i])
+		answer := []rune(fitted[i].answerText())
+		keep := max(0, len(answer)-(total-budget)*4) // about 4 characters a token
+		fitted[i].AI = string(answer[:keep]) + " […trimmed to fit the history budget]"
+		total -= size - turnTokens(fitted[i])
+	}
+	return fitted
+}
+
+// turnTokens estimates the tokens a turn adds to a prompt.
+func turnTokens(c Conversation) int {
+	return CountTokens(c.User) + CountTokens(c.answerText())
+}
+
+// answerText is the answer of a turn as text.
+func (c Conversation) answerText() string {
+	if text := fmt.Sprint(c.AI); c.AI != nil && strings.TrimSpace(text) != "" {
+		return text
+	}
+	return "(no answer)"
+}
+
+// FormatHistory serializes history entries into the numbered text block used in prompts.
+func FormatHistory(history []Conversation) string {
+	var b strings.Builder
+	for i, c := range history {
+		b.WriteString(fmt.Sprintf("%d. User: %s\n   AI: %v\n", i+1, c.User, c.AI))
+	}
+	return b.String()
+}
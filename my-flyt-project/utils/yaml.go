@@ -0,0 +1,600 @@
+package utils
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// ParseYAML parses the YAML subset found in configuration files and manifests:
+// block mappings and sequences, flow collections, plain/quoted scalars, block
+// scalars (| and >), comments, anchors/aliases with merge keys and multiple
+// documents. It returns one value per document and is meant for validation
+// and conversion, not as a full YAML 1.2 implementation.
+//
+// TODO: replace the parser with gopkg.in/yaml.v3 behind this signature once
+// the module can take the dependency; yaml_test.go pins the behavior to keep.
+func ParseYAML(text string) ([]any, error) {
+	p := &yamlParser{anchors: map[string]any{}}
+	for i, raw := range strings.Split(strings.ReplaceAll(text, "\r\n", "\n"), "\n") {
+		p.lines = append(p.lines, yamlLine{num: i + 1, raw: raw})
+	}
+
+	var docs []any
+	for {
+		p.skipBlank()
+		if p.pos >= len(p.lines) {
+			break
+		}
+		if p.isDocMarker() {
+			p.consumeDocMarker()
+			continue
+		}
+		doc, err := p.parseBlock(0)
+		if err != nil {
+			return nil, err
+		}
+		p.skipBlank()
+		if p.pos < len(p.lines) && !p.isDocMarker() {
+			l := p.lines[p.pos]
+			return nil, fmt.Errorf("yaml: line %d: unexpected content %q", l.num, strings.TrimSpace(l.raw))
+		}
+		docs = append(docs, doc)
+	}
+	return docs, nil
+}
+
+type yamlLine struct {
+	num int
+	raw string
+}
+
+type yamlParser struct {
+	lines   []yamlLine
+	pos     int
+	anchors map[string]any
+}
+
+// content returns the indentation and comment-free content of a line.
+func (p *yamlParser) content(i int) (int, string, error) {
+	raw := p.lines[i].raw
+	indent := 0
+	for indent < len(raw) && raw[indent] == ' ' {
+		indent++
+	}
+	if indent < len(raw) && raw[indent] == '\t' {
+		return 0, "", fmt.Errorf("yaml: line %d: tabs are not allowed for indentation", p.lines[i].num)
+	}
+	return indent, strings.TrimRight(stripYAMLComment(raw[indent:]), " \t"), nil
+}
+
+func (p *yamlParser) skipBlank() {
+	for p.pos < len(p.lines) {
+		if _, c, err := p.content(p.pos); err == nil && c == "" {
+			p.pos++
+			continue
+		}
+		return
+	}
+}
+
+func (p *yamlParser) isDocMarker() bool {
+	raw := p.lines[p.pos].raw
+	return raw == "---" || raw == "..." || strings.HasPrefix(raw, "--- ")
+}
+
+func (p *yamlParser) consumeDocMarker() {
+	raw := p.lines[p.pos].raw
+	if rest := strings.TrimSpace(strings.TrimPrefix(raw, "---")); strings.HasPrefix(raw, "--- ") && rest != "" && !strings.HasPrefix(rest, "#") {
+		// Content on the marker line starts the document.
+		p.lines[p.pos].raw = rest
+		return
+	}
+	p.pos++
+}
+
+// parseBlock parses the node starting at the next line, which must be indented at least minIndent.
+func (p *yamlParser) parseBlock(minIndent int) (any, error) {
+	p.skipBlank()
+	if p.pos >= len(p.lines) || p.isDocMarker() {
+		return nil, nil
+	}
+	indent, c, err := p.content(p.pos)
+	if err != nil {
+		return nil, err
+	}
+	if indent < minIndent {
+		return nil, nil
+	}
+
+	switch {
+	case c == "-" || strings.HasPrefix(c, "- "):
+		return p.parseSequence(indent)
+	case yamlKeySplit(c) >= 0:
+		return p.parseMapping(indent)
+	case strings.HasPrefix(c, "|") || strings.HasPrefix(c, ">"):
+		// Block scalar as a sequence item ("- |"): its lines sit past the parent.
+		p.pos++
+		return p.parseBlockScalar(c, minIndent-1), nil
+	default:
+		p.pos++
+		value := c
+		// Plain scalars may continue on more-indented lines.
+		for p.pos < len(p.lines) {
+			next, nc, err := p.content(p.pos)
+			if err != nil || nc == "" || next < minIndent || p.isDocMarker() {
+				break
+			}
+			value += " " + nc
+			p.pos++
+		}
+		return p.parseInline(value, p.lines[p.pos-1].num)
+	}
+}
+
+func (p *yamlParser) parseSequence(indent int) ([]any, error) {
+	items := []any{}
+	for {
+		p.skipBlank()
+		if p.pos >= len(p.lines) || p.isDocMarker() {
+			return items, nil
+		}
+		li, c, err := p.content(p.pos)
+		if err != nil {
+			return nil, err
+		}
+		if li < indent {
+			return items, nil
+		}
+		if li > indent {
+			return nil, fmt.Errorf("yaml: line %d: bad indentation of a sequence entry", p.lines[p.pos].num)
+		}
+		if c != "-" && !strings.HasPrefix(c, "- ") {
+			return items, nil
+		}
+
+		rest := strings.TrimLeft(c[1:], " ")
+		var item any
+		if rest == "" {
+			p.pos++
+			item, err = p.parseBlock(indent + 1)
+		} else {
+			// Re-read the remainder of the line as a node indented past the dash.
+			itemIndent := li + len(c) - len(rest)
+			p.lines[p.pos].raw = strings.Repeat(" ", itemIndent) + rest
+			item, err = p.parseBlock(itemIndent)
+		}
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, item)
+	}
+}
+
+func (p *yamlParser) parseMapping(indent int) (map[string]any, error) {
+	m := map[string]any{}
+	merged := map[string]bool{}
+	for {
+		p.skipBlank()
+		if p.pos >= len(p.lines) || p.isDocMarker() {
+			return m, nil
+		}
+		li, c, err := p.content(p.pos)
+		if err != nil {
+			return nil, err
+		}
+		num := p.lines[p.pos].num
+		if li < indent {
+			return m, nil
+		}
+		if li > indent {
+			return nil, fmt.Errorf("yaml: line %d: bad indentation of a mapping entry", num)
+		}
+		split := yamlKeySplit(c)
+		if split < 0 {
+			if c == "-" || strings.HasPrefix(c, "- ") {
+				return m, nil
+			}
+			return nil, fmt.Errorf("yaml: line %d: expected a key: value pair, got %q", num, c)
+		}
+
+		key, err := yamlUnquote(strings.TrimSpace(c[:split]), num)
+		if err != nil {
+			return nil, err
+		}
+		rest := strings.TrimSpace(c[split+1:])
+		p.pos++
+
+		var value any
+		anchor := ""
+		if strings.HasPrefix(rest, "&") {
+			name, after, _ := strings.Cut(rest[1:], " ")
+			anchor, rest = name, strings.TrimSpace(after)
+		}
+		switch {
+		case rest == "":
+			p.skipBlank()
+			if p.pos < len(p.lines) {
+				ni, nc, _ := p.content(p.pos)
+				if ni == indent && (nc == "-" || strings.HasPrefix(nc, "- ")) {
+					value, err = p.parseSequence(indent)
+					break
+				}
+			}
+			value, err = p.parseBlock(indent + 1)
+		case strings.HasPrefix(rest, "|") || strings.HasPrefix(rest, ">"):
+			value = p.parseBlockScalar(rest, indent)
+		case yamlKeySplit(rest) >= 0 && !strings.ContainsAny(rest[:1], `"'[{*&!`):
+			err = fmt.Errorf("yaml: line %d: mapping values are not allowed here; quote %q", num, rest)
+		default:
+			value, err = p.parseInline(rest, num)
+			for err == nil && p.pos < len(p.lines) {
+				// Multi-line plain scalar continuation.
+				ni, nc, cerr := p.content(p.pos)
+				if cerr != nil || nc == "" || ni <= indent || yamlKeySplit(nc) >= 0 {
+					break
+				}
+				if s, ok := value.(string); ok {
+					value = s + " " + nc
+					p.pos++
+					continue
+				}
+				return nil, fmt.Errorf("yaml: line %d: unexpected continuation line", p.lines[p.pos].num)
+			}
+		}
+		if err != nil {
+			return nil, err
+		}
+		if anchor != "" {
+			p.anchors[anchor] = value
+		}
+
+		if key == "<<" {
+			if err := mergeYAML(m, merged, value, num); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		// Explicit keys override merged ones but may not repeat each other.
+		if _, dup := m[key]; dup && !merged[key] {
+			return nil, fmt.Errorf("yaml: line %d: duplicate key %q", num, key)
+		}
+		delete(merged, key)
+		m[key] = value
+	}
+}
+
+func mergeYAML(dst map[string]any, merged map[string]bool, value any, num int) error {
+	switch v := value.(type) {
+	case map[string]any:
+		for k, val := range v {
+			if _, ok := dst[k]; !ok {
+				dst[k] = val
+				merged[k] = true
+			}
+		}
+	case []any:
+		for _, item := range v {
+			if err := mergeYAML(dst, merged, item, num); err != nil {
+				return err
+			}
+		}
+	default:
+		return fmt.Errorf("yaml: line %d: merge key needs a mapping", num)
+	}
+	return nil
+}
+
+// parseBlockScalar reads a literal (|) or folded (>) scalar below a key at indent.
+func (p *yamlParser) parseBlockScalar(header string, indent int) string {
+	folded := header[0] == '>'
+	chomp := ""
+	if strings.Contains(header, "-") {
+		chomp = "strip"
+	} else if strings.Contains(header, "+") {
+		chomp = "keep"
+	}
+
+	var lines []string
+	blockIndent := -1
+	for p.pos < len(p.lines) {
+		raw := p.lines[p.pos].raw
+		trimmed := strings.TrimLeft(raw, " ")
+		li := len(raw) - len(trimmed)
+		if trimmed == "" {
+			lines = append(lines, "")
+			p.pos++
+			continue
+		}
+		if li <= indent {
+			break
+		}
+		if blockIndent < 0 {
+			blockIndent = li
+		}
+		if li < blockIndent {
+			break
+		}
+		lines = append(lines, raw[blockIndent:])
+		p.pos++
+	}
+
+	// Trailing blank lines belong to the chomping rule, not the content.
+	trailing := 0
+	for len(lines) > 0 && lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+		trailing++
+	}
+
+	var text string
+	if folded {
+		var b strings.Builder
+		for i, l := range lines {
+			switch {
+			case i == 0, lines[i-1] == "":
+			case l == "" || strings.HasPrefix(l, " ") || strings.HasPrefix(lines[i-1], " "):
+				b.WriteString("\n")
+			default:
+				b.WriteString(" ")
+			}
+			b.WriteString(l)
+		}
+		text = b.String()
+	} else {
+		text = strings.Join(lines, "\n")
+	}
+
+	switch chomp {
+	case "strip":
+		return text
+	case "keep":
+		return text + "\n" + strings.Repeat("\n", trailing)
+	default:
+		if text == "" {
+			return ""
+		}
+		return text + "\n"
+	}
+}
+
+// parseInline parses a value that fits on one line: scalar, alias or flow collection.
+func (p *yamlParser) parseInline(s string, num int) (any, error) {
+	s = strings.TrimSpace(s)
+	if strings.HasPrefix(s, "!") {
+		// Drop tags such as !Ref; !!str keeps a plain scalar a string.
+		tag, after, _ := strings.Cut(s, " ")
+		s = strings.TrimSpace(after)
+		if tag == "!!str" && !strings.HasPrefix(s, `"`) && !strings.HasPrefix(s, "'") {
+			return s, nil
+		}
+	}
+	if strings.HasPrefix(s, "&") {
+		name, after, _ := strings.Cut(s[1:], " ")
+		v, err := p.parseInline(after, num)
+		if err == nil {
+			p.anchors[name] = v
+		}
+		return v, err
+	}
+	if strings.HasPrefix(s, "*") {
+		v, ok := p.anchors[s[1:]]
+		if !ok {
+			return nil, fmt.Errorf("yaml: line %d: unknown alias %q", num, s[1:])
+		}
+		return v, nil
+	}
+	if strings.HasPrefix(s, "[") || strings.HasPrefix(s, "{") {
+		fp := &yamlFlowParser{s: s, num: num, p: p}
+		v, err := fp.parse()
+		if err != nil {
+			return nil, err
+		}
+		fp.skipSpace()
+		if fp.i != len(fp.s) {
+			return nil, fmt.Errorf("yaml: line %d: unexpected %q after flow collection", num, fp.s[fp.i:])
+		}
+		return v, nil
+	}
+	if strings.HasPrefix(s, `"`) || strings.HasPrefix(s, "'") {
+		return yamlUnquote(s, num)
+	}
+	return yamlScalar(s), nil
+}
+
+// yamlFlowParser parses JSON-like flow collections such as [a, b] and {k: v}.
+type yamlFlowParser struct {
+	s   string
+	i   int
+	num int
+	p   *yamlParser
+}
+
+func (f *yamlFlowParser) skipSpace() {
+	for f.i < len(f.s) && (f.s[f.i] == ' ' || f.s[f.i] == '\t') {
+		f.i++
+	}
+}
+
+func (f *yamlFlowParser) parse() (any, error) {
+	f.skipSpace()
+	if f.i >= len(f.s) {
+		return nil, fmt.Errorf("yaml: line %d: unterminated flow collection", f.num)
+	}
+	switch f.s[f.i] {
+	case '[':
+		f.i++
+		list := []any{}
+		for {
+			f.skipSpace()
+			if f.i < len(f.s) && f.s[f.i] == ']' {
+				f.i++
+				return list, nil
+			}
+			v, err := f.parse()
+			if err != nil {
+				return nil, err
+			}
+			list = append(list, v)
+			if err := f.separator(']'); err != nil {
+				return nil, err
+			}
+		}
+	case '{':
+		f.i++
+		m := map[string]any{}
+		for {
+			f.skipSpace()
+			if f.i < len(f.s) && f.s[f.i] == '}' {
+				f.i++
+				return m, nil
+			}
+			k, err := f.parse()
+			if err != nil {
+				return nil, err
+			}
+			f.skipSpace()
+			if f.i >= len(f.s) || f.s[f.i] != ':' {
+				return nil, fmt.Errorf("yaml: line %d: expected ':' in flow mapping", f.num)
+			}
+			f.i++
+			v, err := f.parse()
+			if err != nil {
+				return nil, err
+			}
+			m[fmt.Sprint(k)] = v
+			if err := f.separator('}'); err != nil {
+				return nil, err
+			}
+		}
+	case '"', '\'':
+		quote := f.s[f.i]
+		end := f.i + 1
+		for end < len(f.s) {
+			if f.s[end] == '\\' && quote == '"' {
+				end += 2
+				continue
+			}
+			if f.s[end] == quote {
+				if quote == '\'' && end+1 < len(f.s) && f.s[end+1] == '\'' {
+					end += 2
+					continue
+				}
+				break
+			}
+			end++
+		}
+		if end >= len(f.s) {
+			return nil, fmt.Errorf("yaml: line %d: unterminated quoted string", f.num)
+		}
+		v, err := yamlUnquote(f.s[f.i:end+1], f.num)
+		f.i = end + 1
+		return v, err
+	default:
+		start := f.i
+		for f.i < len(f.s) && !strings.ContainsRune(",]}", rune(f.s[f.i])) {
+			if f.s[f.i] == ':' && (f.i+1 == len(f.s) || f.s[f.i+1] == ' ') {
+				break
+			}
+			f.i++
+		}
+		return f.p.parseInline(f.s[start:f.i], f.num)
+	}
+}
+
+func (f *yamlFlowParser) separator(closing byte) error {
+	f.skipSpace()
+	if f.i >= len(f.s) {
+		return fmt.Errorf("yaml: line %d: unterminated flow collection", f.num)
+	}
+	switch f.s[f.i] {
+	case ',':
+		f.i++
+		return nil
+	case closing:
+		return nil
+	default:
+		return fmt.Errorf("yaml: line %d: expected ',' or '%c' in flow collection", f.num, closing)
+	}
+}
+
+// yamlKeySplit returns the index of the ':' separating a mapping key from its
+// value, or -1 if the content is not a key: value pair.
+func yamlKeySplit(c string) int {
+	if strings.HasPrefix(c, "[") || strings.HasPrefix(c, "{") {
+		return -1
+	}
+	inQuote := byte(0)
+	for i := 0; i < len(c); i++ {
+		ch := c[i]
+		switch {
+		case inQuote != 0:
+			if ch == inQuote {
+				inQuote = 0
+			}
+		case (ch == '"' || ch == '\'') && i == 0:
+			inQuote = ch
+		case ch == ':' && (i+1 == len(c) || c[i+1] == ' '):
+			return i
+		}
+	}
+	return -1
+}
+
+// stripYAMLComment removes a trailing "# comment" that is not inside quotes.
+func stripYAMLComment(s string) string {
+	inQuote := byte(0)
+	for i := 0; i < len(s); i++ {
+		ch := s[i]
+		switch {
+		case inQuote != 0:
+			if ch == '\\' && inQuote == '"' {
+				i++
+			} else if ch == inQuote {
+				inQuote = 0
+			}
+		case ch == '"' || ch == '\'':
+			if i == 0 || strings.ContainsRune(" [{,:", rune(s[i-1])) {
+				inQuote = ch
+			}
+		case ch == '#' && (i == 0 || s[i-1] == ' ' || s[i-1] == '\t'):
+			return s[:i]
+		}
+	}
+	return s
+}
+
+func yamlUnquote(s string, num int) (string, error) {
+	if len(s) >= 2 && s[0] == '\'' && s[len(s)-1] == '\'' {
+		return strings.ReplaceAll(s[1:len(s)-1], "''", "'"), nil
+	}
+	if len(s) >= 2 && s[0] == '"' && s[len(s)-1] == '"' {
+		v, err := strconv.Unquote(s)
+		if err != nil {
+			return "", fmt.Errorf("yaml: line %d: invalid double-quoted string %s", num, s)
+		}
+		return v, nil
+	}
+	if strings.HasPrefix(s, `"`) || strings.HasPrefix(s, "'") {
+		return "", fmt.Errorf("yaml: line %d: unterminated quoted string %s", num, s)
+	}
+	return s, nil
+}
+
+// yamlScalar resolves a plain scalar to null, bool, number or string.
+func yamlScalar(s string) any {
+	switch s {
+	case "", "~", "null", "Null", "NULL":
+		return nil
+	case "true", "True", "TRUE":
+		return true
+	case "false", "False", "FALSE":
+		return false
+	}
+	if i, err := strconv.ParseInt(s, 10, 64); err == nil {
+		return i
+	}
+	if f, err := strconv.ParseFloat(s, 64); err == nil && !strings.ContainsAny(s, "xXpP_") {
+		return f
+	}
+	return s
+}
@@ -0,0 +1,106 @@
+package utils
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseYAML(t *testing.T) {
+	tests := []struct {
+		name string
+		in   string
+		want []any
+	}{
+		{"empty", "", nil},
+		{"comments only", "# nothing\n\n  # here\n", nil},
+		{"scalars", "s: text\ni: 42\nneg: -7\nf: 1.5\nt: true\nF: False\nn: null\ntilde: ~\nempty:\nhex: 0x1F\nver: 1.2.3\n",
+			[]any{map[string]any{"s": "text", "i": int64(42), "neg": int64(-7), "f": 1.5, "t": true, "F": false,
+				"n": nil, "tilde": nil, "empty": nil, "hex": "0x1F", "ver": "1.2.3"}}},
+		{"quoted", `a: "x: y # not a comment"` + "\nb: 'it''s'\nc: \"tab\\tnew\\n\"\n\"quoted key\": 1\n",
+			[]any{map[string]any{"a": "x: y # not a comment", "b": "it's", "c": "tab\tnew\n", "quoted key": int64(1)}}},
+		{"comments", "a: 1 # one\nb: x#y\nurl: http://example.com/#frag\n",
+			[]any{map[string]any{"a": int64(1), "b": "x#y", "url": "http://example.com/#frag"}}},
+		{"nested mapping", "a:\n  b:\n    c: 1\n  d: 2\ne: 3\n",
+			[]any{map[string]any{"a": map[string]any{"b": map[string]any{"c": int64(1)}, "d": int64(2)}, "e": int64(3)}}},
+		{"sequence", "- a\n- 1\n-\n  - nested\n",
+			[]any{[]any{"a", int64(1), []any{"nested"}}}},
+		{"sequence of mappings", "- name: a\n  port: 80\n- name: b\n",
+			[]any{[]any{map[string]any{"name": "a", "port": int64(80)}, map[string]any{"name": "b"}}}},
+		{"sequence at key indent", "items:\n- a\n- b\nnext: 1\n",
+			[]any{map[string]any{"items": []any{"a", "b"}, "next": int64(1)}}},
+		{"indented sequence", "items:\n  - a\n  - b\n",
+			[]any{map[string]any{"items": []any{"a", "b"}}}},
+		{"flow collections", "l: [1, \"two\", [3]]\nm: {a: 1, 'b': [x, y], c: {d: null}}\nempty: []\n",
+			[]any{map[string]any{"l": []any{int64(1), "two", []any{int64(3)}},
+				"m":     map[string]any{"a": int64(1), "b": []any{"x", "y"}, "c": map[string]any{"d": nil}},
+				"empty": []any{}}}},
+		{"literal block", "s: |\n  line 1\n    indented\n  line 3\nnext: 1\n",
+			[]any{map[string]any{"s": "line 1\n  indented\nline 3\n", "next": int64(1)}}},
+		{"folded block", "s: >\n  one\n  two\n\n  three\n",
+			[]any{map[string]any{"s": "one two\nthree\n"}}},
+		{"chomping", "strip: |-\n  a\n\nkeep: |+\n  b\n\nclip: |\n  c\n\n",
+			[]any{map[string]any{"strip": "a", "keep": "b\n\n", "clip": "c\n"}}},
+		{"block scalar in sequence", "- |\n  text\n- x\n",
+			[]any{[]any{"text\n", "x"}}},
+		{"multi-line plain", "a: one\n  two\n  three\nb: 1\n",
+			[]any{map[string]any{"a": "one two three", "b": int64(1)}}},
+		{"multi-line plain item", "- a\n  - b\n", []any{[]any{"a - b"}}},
+		{"colon without space", "time: 12:30\nurl: a:b\n", []any{map[string]any{"time": "12:30", "url": "a:b"}}},
+		{"anchors and merge", "base: &b\n  x: 1\n  y: 2\nderived:\n  <<: *b\n  y: 3\nalias: *b\n",
+			[]any{map[string]any{
+				"base":    map[string]any{"x": int64(1), "y": int64(2)},
+				"derived": map[string]any{"x": int64(1), "y": int64(3)},
+				"alias":   map[string]any{"x": int64(1), "y": int64(2)}}}},
+		{"inline anchor", "a: &v 5\nb: *v\n", []any{map[string]any{"a": int64(5), "b": int64(5)}}},
+		{"tags", "a: !!str 123\nb: !Ref thing\nc: !!str 'q'\n", []any{map[string]any{"a": "123", "b": "thing", "c": "q"}}},
+		{"documents", "---\na: 1\n---\n- b\n...\n--- c\n",
+			[]any{map[string]any{"a": int64(1)}, []any{"b"}, "c"}},
+		{"crlf", "a: 1\r\nb: 2\r\n", []any{map[string]any{"a": int64(1), "b": int64(2)}}},
+		{"top-level scalar", "just text\n", []any{"just text"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseYAML(tt.in)
+			if err != nil {
+				t.Fatalf("ParseYAML: %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ParseYAML =\n%#v\nwant\n%#v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseYAMLErrors(t *testing.T) {
+	tests := []struct {
+		name, in, want string
+	}{
+		{"tab indentation", "a:\n\tb: 1\n", "line 2: tabs"},
+		{"duplicate key", "a: 1\na: 2\n", `line 2: duplicate key "a"`},
+		{"unknown alias", "a: *missing\n", `unknown alias "missing"`},
+		{"bad merge", "a: 1\nb:\n  <<: 5\n", "merge key needs a mapping"},
+		{"unterminated quote", "a: \"open\n", "line 1: unterminated quoted string"},
+		{"bad escape", `a: "\q"`, "line 1: invalid double-quoted string"},
+		{"unterminated single quote", "a: 'open\n", "line 1: unterminated quoted string"},
+		{"unterminated flow", "a: [1, 2\n", "unterminated flow collection"},
+		{"junk after flow", "a: [1] x\n", "after flow collection"},
+		{"missing colon in flow", "a: {b}\n", "expected ':'"},
+		{"bad sequence indentation", "- a\n - b\n", "line 2: bad indentation of a sequence entry"},
+		{"nested mapping value", "a: b: c\n", "line 1: mapping values are not allowed"},
+		{"continued flow collection", "a: [1]\n  more\n", "line 2: unexpected continuation line"},
+		{"not a pair", "a: 1\njust text\n", `line 2: expected a key: value pair`},
+		{"bad mapping indentation", "a:\n    b: 1\n  c: 2\n", "line 3"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := ParseYAML(tt.in)
+			if err == nil {
+				t.Fatalf("ParseYAML(%q) succeeded, want an error containing %q", tt.in, tt.want)
+			}
+			if !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("ParseYAML(%q) = %v, want an error containing %q", tt.in, err, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,129 @@
+package utils
+
+import (
+	"context"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"html"
+	"io"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var youTubeURLPattern = regexp.MustCompile(`(?:https?://)?(?:www\.|m\.)?(?:youtube\.com/(?:watch\?(?:[^\s#]*&)?v=|shorts/|embed/|live/)|youtu\.be/)([A-Za-z0-9_-]{11})[^\s]*`)
+
+// FindYouTubeURL returns the first YouTube video link in text and its video ID.
+func FindYouTubeURL(text string) (url, id string, ok bool) {
+	m := youTubeURLPattern.FindStringSubmatch(text)
+	if m == nil {
+		return "", "", false
+	}
+	return m[0], m[1], true
+}
+
+// FetchYouTubeTranscript returns the captions of a video as "[mm:ss] text"
+// lines, preferring manual English captions over generated ones.
+func FetchYouTubeTranscript(id string) (string, error) {
+	page, err := youTubeGet("https://www.youtube.com/watch?v=" + id)
+	if err != nil {
+		return "", err
+	}
+
+	start := strings.Index(page, `"captionTracks":`)
+	if start < 0 {
+		return "", fmt.Errorf("video %s has no captions", id)
+	}
+	var tracks []struct {
+		BaseURL      string `json:"baseUrl"`
+		LanguageCode string `json:"languageCode"`
+		Kind         string `json:"kind"`
+	}
+	if err := json.NewDecoder(strings.NewReader(page[start+len(`"captionTracks":`):])).Decode(&tracks); err != nil || len(tracks) == 0 {
+		return "", fmt.Errorf("could not read the caption tracks of %s", id)
+	}
+	rank := func(lang, kind string) int {
+		switch {
+		case strings.HasPrefix(lang, "en") && kind != "asr":
+			return 0
+		case strings.HasPrefix(lang, "en"):
+			return 1
+		}
+		return 2
+	}
+	best := tracks[0]
+	for _, t := range tracks[1:] {
+		if rank(t.LanguageCode, t.Kind) < rank(best.LanguageCode, best.Kind) {
+			best = t
+		}
+	}
+
+	captions, err := youTubeGet(best.BaseURL)
+	if err != nil {
+		return "", err
+	}
+	var doc struct {
+		Texts []struct {
+			Start string `xml:"start,attr"`
+			Text  string `xml:",chardata"`
+		} `xml:"text"`
+	}
+	if err := xml.Unmarshal([]byte(captions), &doc); err != nil || len(doc.Texts) == 0 {
+		return "", fmt.Errorf("captions of %s are empty or unreadable", id)
+	}
+
+	var b strings.Builder
+	for _, t := range doc.Texts {
+		seconds, _ := strconv.ParseFloat(t.Start, 64)
+		text := strings.Join(strings.Fields(html.UnescapeString(t.Text)), " ")
+		fmt.Fprintf(&b, "[%s] %s\n", FormatTimestamp(seconds), text)
+	}
+	return b.String(), nil
+}
+
+// FormatTimestamp formats seconds as m:ss, or h:mm:ss for long videos.
+func FormatTimestamp(seconds float64) string {
+	s := int(seconds)
+	if s >= 3600 {
+		return fmt.Sprintf("%d:%02d:%02d", s/3600, s/60%60, s%60)
+	}
+	return fmt.Sprintf("%d:%02d", s/60, s%60)
+}
+
+func youTubeGet(url string) (string, error) {
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Accept-Language", "en-US,en;q=0.9")
+	req.Header.Set("User-Agent", "Mozilla/5.0 (X11; Linux x86_64)")
+	client := &http.Client{Timeout: 30 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("failed to fetch %s: %w", url, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("failed to fetch %s: status %d", url, resp.StatusCode)
+	}
+	body, err := io.ReadAll(resp.Body)
+	return string(body), err
+}
+
+// CallLLMWithVideo asks about a public YouTube video, which Gemini watches itself.
+// It is slower and costlier than working from a transcript.
+func CallLLMWithVideo(ctx context.Context, prompt, videoURL string, config *LLMConfig) (string, error) {
+	config = withPersona(config)
+	apiKey, err := getGEMINIAPIKey()
+	if err != nil {
+		return "", err
+	}
+	parts := []map[string]any{
+		{"file_data": map[string]any{"file_uri": videoURL}},
+		{"text": prompt},
+	}
+	return callLLMWithParts(ctx, apiKey, parts, config)
+}